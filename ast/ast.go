@@ -9,6 +9,8 @@ import (
 // Node is an interface that represents a node in a Poryscript AST.
 type Node interface {
 	TokenLiteral() string
+	Pos() token.Position // position of the node's first byte
+	End() token.Position // position immediately after the node's last byte
 }
 
 // Statement is an interface that represents a statement node in a Poryscript AST.
@@ -17,18 +19,76 @@ type Statement interface {
 	statementNode()
 }
 
-// Text holds a label and value for some script text.
+// Annotation is an attribute attached to a top-level statement, such as
+// @deprecated("use NewScript") or @align(4).
+type Annotation struct {
+	Token token.Token
+	Name  string
+	Args  []string
+}
+
+// Annotations is the list of annotations attached to a top-level statement.
+type Annotations []Annotation
+
+// Get returns the annotation with the given name, if it exists.
+func (a Annotations) Get(name string) (Annotation, bool) {
+	for _, annotation := range a {
+		if annotation.Name == name {
+			return annotation, true
+		}
+	}
+	return Annotation{}, false
+}
+
+// Has reports whether an annotation with the given name exists.
+func (a Annotations) Has(name string) bool {
+	_, ok := a.Get(name)
+	return ok
+}
+
+// AnnotationsOf returns the annotations attached to the given top-level statement.
+func AnnotationsOf(stmt Statement) Annotations {
+	switch s := stmt.(type) {
+	case *ScriptStatement:
+		return s.Annotations
+	case *TextStatement:
+		return s.Annotations
+	case *MovementStatement:
+		return s.Annotations
+	case *MartStatement:
+		return s.Annotations
+	case *DataStatement:
+		return s.Annotations
+	case *AliasStatement:
+		return s.Annotations
+	case *MapScriptsStatement:
+		return s.Annotations
+	case *RawStatement:
+		return s.Annotations
+	case *DirectiveStatement:
+		return s.Annotations
+	}
+	return nil
+}
+
+// Text holds a label and value for some script text. Language is the
+// optional language argument of the text's directive, like "JAPANESE".
 type Text struct {
-	Name       string
-	Value      string
-	StringType string
-	IsGlobal   bool
+	Name        string
+	Value       string
+	StringType  string
+	Language    string
+	IsGlobal    bool
+	Annotations Annotations
 }
 
-// Program represents the root-level Node in any Poryscript AST.
+// Program represents the root-level Node in any Poryscript AST. Comments
+// holds the comments of the file in source order, when its lexer produced
+// them.
 type Program struct {
 	TopLevelStatements []Statement
 	Texts              []Text
+	Comments           []*Comment
 }
 
 // TokenLiteral returns a string representation of the Program node.
@@ -39,13 +99,54 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+// Pos returns the position of the Program's first top-level statement.
+func (p *Program) Pos() token.Position {
+	if len(p.TopLevelStatements) > 0 {
+		return p.TopLevelStatements[0].Pos()
+	}
+	return token.Position{}
+}
+
+// End returns the position immediately after the Program's last top-level statement.
+func (p *Program) End() token.Position {
+	if len(p.TopLevelStatements) > 0 {
+		return p.TopLevelStatements[len(p.TopLevelStatements)-1].End()
+	}
+	return token.Position{}
+}
+
+// Comment is a single-line comment, like "# comment" or "// comment".
+type Comment struct {
+	Token token.Token
+}
+
+// TokenLiteral returns the text of the comment, including its "#" or "//".
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+
+// Pos returns the position of the comment's first byte.
+func (c *Comment) Pos() token.Position { return c.Token.Pos() }
+
+// End returns the position immediately after the comment's last byte.
+func (c *Comment) End() token.Position { return c.Token.End }
+
+// ScriptParam is a named parameter of a script. Each parameter is passed
+// to the script in the given var.
+type ScriptParam struct {
+	Token token.Token
+	Name  string
+	Var   string
+}
+
 // ScriptStatement is a Poryscript script statement. Script statements define
 // the block of a script's execution.
 type ScriptStatement struct {
-	Token token.Token
-	Name  *Identifier
-	Body  *BlockStatement
-	Scope token.Type
+	Token       token.Token
+	Name        *Identifier
+	Params      []ScriptParam
+	Body        *BlockStatement
+	Scope       token.Type
+	Annotations Annotations
+	EndPos      token.Position
 }
 
 func (ss *ScriptStatement) statementNode() {}
@@ -53,11 +154,18 @@ func (ss *ScriptStatement) statementNode() {}
 // TokenLiteral returns a string representation of the script statement.
 func (ss *ScriptStatement) TokenLiteral() string { return ss.Token.Literal }
 
+// Pos returns the position of the script statement's first byte.
+func (ss *ScriptStatement) Pos() token.Position { return ss.Token.Pos() }
+
+// End returns the position immediately after the script statement's last byte.
+func (ss *ScriptStatement) End() token.Position { return ss.EndPos }
+
 // BlockStatement is a Poryscript block, which can hold many statements and blocks inside.
-// It is defined by curly braces.
+// It is defined by curly braces. Its span covers its statements, but not the curly braces.
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+	EndPos     token.Position
 }
 
 func (bs *BlockStatement) statementNode() {}
@@ -65,12 +173,19 @@ func (bs *BlockStatement) statementNode() {}
 // TokenLiteral returns a string representation of the block statement.
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
 
+// Pos returns the position of the block statement's first byte.
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos() }
+
+// End returns the position immediately after the block statement's last byte.
+func (bs *BlockStatement) End() token.Position { return bs.EndPos }
+
 // CommandStatement is a Poryscript command statement. Command statements map directly to
 // original engine script commands.
 type CommandStatement struct {
-	Token token.Token
-	Name  *Identifier
-	Args  []string
+	Token  token.Token
+	Name   *Identifier
+	Args   []string
+	EndPos token.Position
 }
 
 func (cs *CommandStatement) statementNode() {}
@@ -78,6 +193,12 @@ func (cs *CommandStatement) statementNode() {}
 // TokenLiteral returns a string representation of the command statement.
 func (cs *CommandStatement) TokenLiteral() string { return cs.Token.Literal }
 
+// Pos returns the position of the command statement's first byte.
+func (cs *CommandStatement) Pos() token.Position { return cs.Token.Pos() }
+
+// End returns the position immediately after the command statement's last byte.
+func (cs *CommandStatement) End() token.Position { return cs.EndPos }
+
 // Identifier represents a Poryscript identifier.
 type Identifier struct {
 	Token token.Token
@@ -89,11 +210,19 @@ func (i *Identifier) expressionNode() {}
 // TokenLiteral returns a string representation of the identifier.
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 
+// Pos returns the position of the identifier's first byte.
+func (i *Identifier) Pos() token.Position { return i.Token.Pos() }
+
+// End returns the position immediately after the identifier's last byte.
+func (i *Identifier) End() token.Position { return i.Token.End }
+
 // RawStatement is a Poryscript raw statement. Raw statements are directly
 // included into the target bytecode script.
 type RawStatement struct {
-	Token token.Token
-	Value string
+	Token       token.Token
+	Value       string
+	Annotations Annotations
+	EndPos      token.Position
 }
 
 func (rs *RawStatement) statementNode() {}
@@ -101,14 +230,44 @@ func (rs *RawStatement) statementNode() {}
 // TokenLiteral returns a string representation of the raw statement.
 func (rs *RawStatement) TokenLiteral() string { return rs.Token.Literal }
 
+// Pos returns the position of the raw statement's first byte.
+func (rs *RawStatement) Pos() token.Position { return rs.Token.Pos() }
+
+// End returns the position immediately after the raw statement's last byte.
+func (rs *RawStatement) End() token.Position { return rs.EndPos }
+
+// DirectiveStatement is a Poryscript directive statement. Directive statements
+// are emitted verbatim as standalone lines in the target output, which allows
+// assembler or preprocessor directives (e.g. .include, #ifdef) to be placed
+// between other top-level statements.
+type DirectiveStatement struct {
+	Token       token.Token
+	Value       string
+	Annotations Annotations
+	EndPos      token.Position
+}
+
+func (ds *DirectiveStatement) statementNode() {}
+
+// TokenLiteral returns a string representation of the directive statement.
+func (ds *DirectiveStatement) TokenLiteral() string { return ds.Token.Literal }
+
+// Pos returns the position of the directive statement's first byte.
+func (ds *DirectiveStatement) Pos() token.Position { return ds.Token.Pos() }
+
+// End returns the position immediately after the directive statement's last byte.
+func (ds *DirectiveStatement) End() token.Position { return ds.EndPos }
+
 // TextStatement is a Poryscript text statement. Text statements are included
 // into the target bytecode script as native text, and can be auto-formatted.
 type TextStatement struct {
-	Token      token.Token
-	Name       *Identifier
-	Value      string
-	StringType string
-	Scope      token.Type
+	Token       token.Token
+	Name        *Identifier
+	Value       string
+	StringType  string
+	Scope       token.Type
+	Annotations Annotations
+	EndPos      token.Position
 }
 
 func (ts *TextStatement) statementNode() {}
@@ -116,6 +275,12 @@ func (ts *TextStatement) statementNode() {}
 // TokenLiteral returns a string representation of the text statement.
 func (ts *TextStatement) TokenLiteral() string { return ts.Token.Literal }
 
+// Pos returns the position of the text statement's first byte.
+func (ts *TextStatement) Pos() token.Position { return ts.Token.Pos() }
+
+// End returns the position immediately after the text statement's last byte.
+func (ts *TextStatement) End() token.Position { return ts.EndPos }
+
 // MovementStatement is a Poryscript movement statement. Movement statements represent
 // data for the applymovement command.
 type MovementStatement struct {
@@ -123,6 +288,8 @@ type MovementStatement struct {
 	Name             *Identifier
 	MovementCommands []string
 	Scope            token.Type
+	Annotations      Annotations
+	EndPos           token.Position
 }
 
 func (ms *MovementStatement) statementNode() {}
@@ -130,13 +297,21 @@ func (ms *MovementStatement) statementNode() {}
 // TokenLiteral returns a string representation of the movement statement.
 func (ms *MovementStatement) TokenLiteral() string { return ms.Token.Literal }
 
+// Pos returns the position of the movement statement's first byte.
+func (ms *MovementStatement) Pos() token.Position { return ms.Token.Pos() }
+
+// End returns the position immediately after the movement statement's last byte.
+func (ms *MovementStatement) End() token.Position { return ms.EndPos }
+
 // MartStatement is a Poryscript mart statement.
 // Mart statements represent item data for the pokemart command.
 type MartStatement struct {
-	Token     token.Token
-	Name      *Identifier
-	MartItems []string
-	Scope     token.Type
+	Token       token.Token
+	Name        *Identifier
+	MartItems   []string
+	Scope       token.Type
+	Annotations Annotations
+	EndPos      token.Position
 }
 
 func (ps *MartStatement) statementNode() {}
@@ -144,8 +319,68 @@ func (ps *MartStatement) statementNode() {}
 // TokenLiteral returns a string representation of the mart statement.
 func (ps *MartStatement) TokenLiteral() string { return ps.Token.Literal }
 
+// Pos returns the position of the mart statement's first byte.
+func (ps *MartStatement) Pos() token.Position { return ps.Token.Pos() }
+
+// End returns the position immediately after the mart statement's last byte.
+func (ps *MartStatement) End() token.Position { return ps.EndPos }
+
+// DataStatement is a Poryscript data statement.
+// Data statements represent tables of bytes, halfwords, and words, like
+// multichoice lists.
+type DataStatement struct {
+	Token       token.Token
+	Name        *Identifier
+	Rows        []DataRow
+	Scope       token.Type
+	Annotations Annotations
+	EndPos      token.Position
+}
+
+// DataRow is a row of a data statement, like ".2byte: 1, 2, 3". Size is the
+// size of each of its values in bytes, which is 1, 2, or 4.
+type DataRow struct {
+	Size   int
+	Values []string
+}
+
+func (ds *DataStatement) statementNode() {}
+
+// TokenLiteral returns a string representation of the data statement.
+func (ds *DataStatement) TokenLiteral() string { return ds.Token.Literal }
+
+// Pos returns the position of the data statement's first byte.
+func (ds *DataStatement) Pos() token.Position { return ds.Token.Pos() }
+
+// End returns the position immediately after the data statement's last byte.
+func (ds *DataStatement) End() token.Position { return ds.EndPos }
+
+// AliasStatement is a Poryscript alias statement.
+// Alias statements define a label that refers to another label, so that
+// references to a renamed script keep working.
+type AliasStatement struct {
+	Token       token.Token
+	Name        *Identifier
+	Target      *Identifier
+	Scope       token.Type
+	Annotations Annotations
+	EndPos      token.Position
+}
+
+func (as *AliasStatement) statementNode() {}
+
+// TokenLiteral returns a string representation of the alias statement.
+func (as *AliasStatement) TokenLiteral() string { return as.Token.Literal }
+
+// Pos returns the position of the alias statement's first byte.
+func (as *AliasStatement) Pos() token.Position { return as.Token.Pos() }
+
+// End returns the position immediately after the alias statement's last byte.
+func (as *AliasStatement) End() token.Position { return as.EndPos }
+
 // BooleanExpression is a part of a boolean expression.
 type BooleanExpression interface {
+	Node
 	booleanExpressionNode()
 	String() string
 }
@@ -159,20 +394,40 @@ type BinaryExpression struct {
 
 func (be *BinaryExpression) booleanExpressionNode() {}
 
+// TokenLiteral returns a string representation of the binary expression.
+func (be *BinaryExpression) TokenLiteral() string { return string(be.Operator) }
+
+// Pos returns the position of the binary expression's first byte.
+func (be *BinaryExpression) Pos() token.Position { return be.Left.Pos() }
+
+// End returns the position immediately after the binary expression's last byte.
+func (be *BinaryExpression) End() token.Position { return be.Right.End() }
+
 func (be *BinaryExpression) String() string {
 	return fmt.Sprintf("(%s) %s (%s)", be.Left.String(), be.Operator, be.Right.String())
 }
 
 // OperatorExpression represents a built-in operator, like flag(FLAG_1) and var(VAR_1).
 type OperatorExpression struct {
+	Token           token.Token
 	Operand         string
 	Operator        token.Type
 	ComparisonValue string
 	Type            token.Type
+	EndPos          token.Position
 }
 
 func (oe *OperatorExpression) booleanExpressionNode() {}
 
+// TokenLiteral returns a string representation of the operator expression.
+func (oe *OperatorExpression) TokenLiteral() string { return oe.Operand }
+
+// Pos returns the position of the operator expression's first byte.
+func (oe *OperatorExpression) Pos() token.Position { return oe.Token.Pos() }
+
+// End returns the position immediately after the operator expression's last byte.
+func (oe *OperatorExpression) End() token.Position { return oe.EndPos }
+
 func (oe *OperatorExpression) String() string {
 	return fmt.Sprintf("%s(%s) %s %s", oe.Type, oe.Operand, oe.Operator, oe.ComparisonValue)
 }
@@ -182,14 +437,39 @@ func (oe *OperatorExpression) String() string {
 type ConditionExpression struct {
 	Expression BooleanExpression
 	Body       *BlockStatement
+	EndPos     token.Position
+}
+
+// TokenLiteral returns a string representation of the condition expression.
+func (ce *ConditionExpression) TokenLiteral() string {
+	if ce.Expression == nil {
+		return ""
+	}
+	return ce.Expression.TokenLiteral()
 }
 
+// Pos returns the position of the condition expression's first byte. The body
+// of a do...while statement comes before its expression.
+func (ce *ConditionExpression) Pos() token.Position {
+	if ce.Body != nil && (ce.Expression == nil || ce.Body.Pos().Before(ce.Expression.Pos())) {
+		return ce.Body.Pos()
+	}
+	if ce.Expression == nil {
+		return token.Position{}
+	}
+	return ce.Expression.Pos()
+}
+
+// End returns the position immediately after the condition expression's last byte.
+func (ce *ConditionExpression) End() token.Position { return ce.EndPos }
+
 // IfStatement is an if statement in Poryscript.
 type IfStatement struct {
 	Token            token.Token
 	Consequence      *ConditionExpression
 	ElifConsequences []*ConditionExpression
 	ElseConsequence  *BlockStatement
+	EndPos           token.Position
 }
 
 func (is *IfStatement) statementNode() {}
@@ -197,10 +477,17 @@ func (is *IfStatement) statementNode() {}
 // TokenLiteral returns a string representation of the if statement.
 func (is *IfStatement) TokenLiteral() string { return is.Token.Literal }
 
+// Pos returns the position of the if statement's first byte.
+func (is *IfStatement) Pos() token.Position { return is.Token.Pos() }
+
+// End returns the position immediately after the if statement's last byte.
+func (is *IfStatement) End() token.Position { return is.EndPos }
+
 // WhileStatement is a while statement in Poryscript.
 type WhileStatement struct {
 	Token       token.Token
 	Consequence *ConditionExpression
+	EndPos      token.Position
 }
 
 func (ws *WhileStatement) statementNode() {}
@@ -208,10 +495,17 @@ func (ws *WhileStatement) statementNode() {}
 // TokenLiteral returns a string representation of the while statement.
 func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
 
+// Pos returns the position of the while statement's first byte.
+func (ws *WhileStatement) Pos() token.Position { return ws.Token.Pos() }
+
+// End returns the position immediately after the while statement's last byte.
+func (ws *WhileStatement) End() token.Position { return ws.EndPos }
+
 // DoWhileStatement is a do-while statement in Poryscript.
 type DoWhileStatement struct {
 	Token       token.Token
 	Consequence *ConditionExpression
+	EndPos      token.Position
 }
 
 func (dws *DoWhileStatement) statementNode() {}
@@ -219,6 +513,12 @@ func (dws *DoWhileStatement) statementNode() {}
 // TokenLiteral returns a string representation of the do...while statement.
 func (dws *DoWhileStatement) TokenLiteral() string { return dws.Token.Literal }
 
+// Pos returns the position of the do...while statement's first byte.
+func (dws *DoWhileStatement) Pos() token.Position { return dws.Token.Pos() }
+
+// End returns the position immediately after the do...while statement's last byte.
+func (dws *DoWhileStatement) End() token.Position { return dws.EndPos }
+
 // BreakStatement is a break statement in Poryscript.
 type BreakStatement struct {
 	Token         token.Token
@@ -230,6 +530,12 @@ func (bs *BreakStatement) statementNode() {}
 // TokenLiteral returns a string representation of the break statement.
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
 
+// Pos returns the position of the break statement's first byte.
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos() }
+
+// End returns the position immediately after the break statement's last byte.
+func (bs *BreakStatement) End() token.Position { return bs.Token.End }
+
 // ContinueStatement is a continue statement in Poryscript.
 type ContinueStatement struct {
 	Token        token.Token
@@ -241,19 +547,37 @@ func (cs *ContinueStatement) statementNode() {}
 // TokenLiteral returns a string representation of the continue statement.
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
 
+// Pos returns the position of the continue statement's first byte.
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos() }
+
+// End returns the position immediately after the continue statement's last byte.
+func (cs *ContinueStatement) End() token.Position { return cs.Token.End }
+
 // SwitchCase is a single case in a switch statement.
 type SwitchCase struct {
+	Token     token.Token
 	Value     string
 	Body      *BlockStatement
 	IsDefault bool
+	EndPos    token.Position
 }
 
+// TokenLiteral returns a string representation of the switch case.
+func (sc *SwitchCase) TokenLiteral() string { return sc.Value }
+
+// Pos returns the position of the switch case's first byte.
+func (sc *SwitchCase) Pos() token.Position { return sc.Token.Pos() }
+
+// End returns the position immediately after the switch case's last byte.
+func (sc *SwitchCase) End() token.Position { return sc.EndPos }
+
 // SwitchStatement is a switch statement in Poryscript.
 type SwitchStatement struct {
 	Token       token.Token
 	Operand     string
 	Cases       []*SwitchCase
 	DefaultCase *SwitchCase
+	EndPos      token.Position
 }
 
 func (cs *SwitchStatement) statementNode() {}
@@ -261,8 +585,15 @@ func (cs *SwitchStatement) statementNode() {}
 // TokenLiteral returns a string representation of the switch statement.
 func (cs *SwitchStatement) TokenLiteral() string { return cs.Token.Literal }
 
+// Pos returns the position of the switch statement's first byte.
+func (cs *SwitchStatement) Pos() token.Position { return cs.Token.Pos() }
+
+// End returns the position immediately after the switch statement's last byte.
+func (cs *SwitchStatement) End() token.Position { return cs.EndPos }
+
 // MapScript is a single map script with either an inline script implementation or a symbol.
 type MapScript struct {
+	Token  token.Token
 	Type   string
 	Name   string
 	Script *ScriptStatement
@@ -270,6 +601,7 @@ type MapScript struct {
 
 // TableMapScriptEntry is a single map script entry in a table-based map script.
 type TableMapScriptEntry struct {
+	Token      token.Token
 	Condition  string
 	Comparison string
 	Name       string
@@ -291,9 +623,17 @@ type MapScriptsStatement struct {
 	MapScripts      []MapScript
 	TableMapScripts []TableMapScript
 	Scope           token.Type
+	Annotations     Annotations
+	EndPos          token.Position
 }
 
 func (ms *MapScriptsStatement) statementNode() {}
 
 // TokenLiteral returns a string representation of the mapscripts statement.
 func (ms *MapScriptsStatement) TokenLiteral() string { return ms.Token.Literal }
+
+// Pos returns the position of the mapscripts statement's first byte.
+func (ms *MapScriptsStatement) Pos() token.Position { return ms.Token.Pos() }
+
+// End returns the position immediately after the mapscripts statement's last byte.
+func (ms *MapScriptsStatement) End() token.Position { return ms.EndPos }
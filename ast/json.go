@@ -0,0 +1,337 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Fields that refer back to an enclosing statement. They are not encoded,
+// since they would form cycles, and they can be recovered from the
+// statement's position in the tree.
+var backReferenceFields = map[string]bool{
+	"ScopeStatment": true,
+	"LoopStatment":  true,
+}
+
+// A JSON object whose fields are encoded in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encodeJSON(&buf, field.name, ""); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := encodeJSON(&buf, field.value, ""); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Encodes the value without escaping HTML characters, since operators like
+// "<" and "&&" are common in the AST.
+func encodeJSON(buf *bytes.Buffer, v interface{}, indent string) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	// Remove the newline that is added by the encoder.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// EncodeJSON encodes the program as indented JSON. Every node is encoded as
+// an object whose "node" field holds the name of the node's type, followed
+// by the node's fields. Tokens include their positions.
+func EncodeJSON(program *Program) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, toJSONValue(reflect.ValueOf(program)), "  "); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Converts a value of the AST into a value that can be encoded by the json
+// package.
+func toJSONValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		elem := v.Elem()
+		if elem.Kind() == reflect.Struct {
+			return toJSONObject(elem, true)
+		}
+		return toJSONValue(elem)
+	case reflect.Struct:
+		return toJSONObject(v, false)
+	case reflect.Slice:
+		values := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values[i] = toJSONValue(v.Index(i))
+		}
+		return values
+	}
+	return v.Interface()
+}
+
+func toJSONObject(v reflect.Value, isNode bool) jsonObject {
+	t := v.Type()
+	o := jsonObject{}
+	if isNode {
+		o = append(o, jsonField{"node", t.Name()})
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || backReferenceFields[field.Name] {
+			continue
+		}
+		o = append(o, jsonField{field.Name, toJSONValue(v.Field(i))})
+	}
+	return o
+}
+
+// The types of nodes that can be decoded, by name.
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []interface{}{
+		Program{}, ScriptStatement{}, BlockStatement{}, CommandStatement{},
+		Identifier{}, RawStatement{}, DirectiveStatement{}, TextStatement{},
+		MovementStatement{}, MartStatement{}, BinaryExpression{},
+		OperatorExpression{}, ConditionExpression{}, IfStatement{},
+		WhileStatement{}, DoWhileStatement{}, BreakStatement{},
+		ContinueStatement{}, SwitchCase{}, SwitchStatement{},
+		MapScriptsStatement{}, DataStatement{}, AliasStatement{}, Comment{},
+	} {
+		t := reflect.TypeOf(node)
+		nodeTypes[t.Name()] = t
+	}
+}
+
+// DecodeJSON decodes a program that was encoded by EncodeJSON. The
+// statements that break and continue statements refer to are recovered from
+// their enclosing loops and switch statements.
+func DecodeJSON(data []byte) (*Program, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid AST JSON: %s", err)
+	}
+	v, err := fromJSONValue(value, reflect.TypeOf(&Program{}), "program")
+	if err != nil {
+		return nil, err
+	}
+	program := v.Interface().(*Program)
+	if program == nil {
+		return nil, fmt.Errorf("invalid AST JSON: program is null")
+	}
+	for _, stmt := range program.TopLevelStatements {
+		if err := resolveBackReferences(stmt, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	return program, nil
+}
+
+// Converts a decoded JSON value into a value of the given type. The path is
+// the location of the value in the AST, which is used in error messages.
+func fromJSONValue(value interface{}, t reflect.Type, path string) (reflect.Value, error) {
+	result := reflect.New(t).Elem()
+	if value == nil {
+		return result, nil
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected an object", path)
+		}
+		name, _ := object["node"].(string)
+		nodeType, ok := nodeTypes[name]
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: unknown node type '%s'", path, name)
+		}
+		if !reflect.PtrTo(nodeType).Implements(t) {
+			return result, fmt.Errorf("invalid AST JSON: %s: node type '%s' is not a %s", path, name, t.Name())
+		}
+		node, err := fromJSONValue(value, reflect.PtrTo(nodeType), path)
+		if err != nil {
+			return result, err
+		}
+		result.Set(node)
+	case reflect.Ptr:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected an object", path)
+		}
+		if name, ok := object["node"]; ok && name != t.Elem().Name() {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected node type '%s', but got '%v'", path, t.Elem().Name(), name)
+		}
+		elem, err := fromJSONValue(value, t.Elem(), path)
+		if err != nil {
+			return result, err
+		}
+		result.Set(reflect.New(t.Elem()))
+		result.Elem().Set(elem)
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected an object", path)
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fieldValue, ok := object[field.Name]
+			if !ok || field.PkgPath != "" || backReferenceFields[field.Name] {
+				continue
+			}
+			v, err := fromJSONValue(fieldValue, field.Type, path+"."+field.Name)
+			if err != nil {
+				return result, err
+			}
+			result.Field(i).Set(v)
+		}
+	case reflect.Slice:
+		values, ok := value.([]interface{})
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected an array", path)
+		}
+		result.Set(reflect.MakeSlice(t, len(values), len(values)))
+		for i, elemValue := range values {
+			v, err := fromJSONValue(elemValue, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return result, err
+			}
+			result.Index(i).Set(v)
+		}
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected a string", path)
+		}
+		result.SetString(s)
+	case reflect.Int:
+		number, ok := value.(json.Number)
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected a number", path)
+		}
+		n, err := strconv.Atoi(string(number))
+		if err != nil {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected an integer, but got %s", path, number)
+		}
+		result.SetInt(int64(n))
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return result, fmt.Errorf("invalid AST JSON: %s: expected a boolean", path)
+		}
+		result.SetBool(b)
+	default:
+		return result, fmt.Errorf("invalid AST JSON: %s: unsupported type %s", path, t)
+	}
+	return result, nil
+}
+
+// Sets the statements that break and continue statements refer to.
+// breakScope is the innermost loop or switch statement, and loop is the
+// innermost loop statement.
+func resolveBackReferences(stmt Statement, breakScope Statement, loop Statement) error {
+	switch s := stmt.(type) {
+	case *ScriptStatement:
+		return resolveBlockBackReferences(s.Body, nil, nil)
+	case *BlockStatement:
+		return resolveBlockBackReferences(s, breakScope, loop)
+	case *IfStatement:
+		if s.Consequence != nil {
+			if err := resolveBlockBackReferences(s.Consequence.Body, breakScope, loop); err != nil {
+				return err
+			}
+		}
+		for _, elif := range s.ElifConsequences {
+			if elif != nil {
+				if err := resolveBlockBackReferences(elif.Body, breakScope, loop); err != nil {
+					return err
+				}
+			}
+		}
+		return resolveBlockBackReferences(s.ElseConsequence, breakScope, loop)
+	case *WhileStatement:
+		if s.Consequence != nil {
+			return resolveBlockBackReferences(s.Consequence.Body, s, s)
+		}
+	case *DoWhileStatement:
+		if s.Consequence != nil {
+			return resolveBlockBackReferences(s.Consequence.Body, s, s)
+		}
+	case *SwitchStatement:
+		for _, switchCase := range s.Cases {
+			if switchCase != nil {
+				if err := resolveBlockBackReferences(switchCase.Body, s, loop); err != nil {
+					return err
+				}
+			}
+		}
+		if s.DefaultCase != nil {
+			return resolveBlockBackReferences(s.DefaultCase.Body, s, loop)
+		}
+	case *MapScriptsStatement:
+		for _, mapScript := range s.MapScripts {
+			if mapScript.Script != nil {
+				if err := resolveBackReferences(mapScript.Script, nil, nil); err != nil {
+					return err
+				}
+			}
+		}
+		for _, table := range s.TableMapScripts {
+			for _, entry := range table.Entries {
+				if entry.Script != nil {
+					if err := resolveBackReferences(entry.Script, nil, nil); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	case *BreakStatement:
+		if breakScope == nil {
+			return fmt.Errorf("invalid AST JSON: line %d:%d: break statement is not inside a loop or switch statement", s.Token.LineNumber, s.Token.Column)
+		}
+		s.ScopeStatment = breakScope
+	case *ContinueStatement:
+		if loop == nil {
+			return fmt.Errorf("invalid AST JSON: line %d:%d: continue statement is not inside a loop", s.Token.LineNumber, s.Token.Column)
+		}
+		s.LoopStatment = loop
+	}
+	return nil
+}
+
+func resolveBlockBackReferences(block *BlockStatement, breakScope Statement, loop Statement) error {
+	if block == nil {
+		return nil
+	}
+	for _, stmt := range block.Statements {
+		if err := resolveBackReferences(stmt, breakScope, loop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
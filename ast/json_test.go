@@ -0,0 +1,339 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+// Returns a token that begins at the given position of a single line.
+func newTestToken(tokenType token.Type, literal string, offset int, line int, column int) token.Token {
+	return token.Token{
+		Type:       tokenType,
+		Literal:    literal,
+		LineNumber: line,
+		Column:     column,
+		Offset:     offset,
+		End:        token.Position{Offset: offset + len(literal), Line: line, Column: column + len(literal)},
+	}
+}
+
+// Returns the AST of the following script:
+//
+//	@unused script MyScript {
+//	    while (var(VAR_1) < 3) {
+//	        break
+//	    }
+//	} # end
+func getTestProgram() *Program {
+	whileStmt := &WhileStatement{
+		Token: newTestToken(token.WHILE, "while", 30, 2, 5),
+		Consequence: &ConditionExpression{
+			Expression: &OperatorExpression{
+				Token:           newTestToken(token.VAR, "var", 37, 2, 12),
+				Operand:         "VAR_1",
+				Operator:        token.LT,
+				ComparisonValue: "3",
+				Type:            token.VAR,
+				EndPos:          token.Position{Offset: 51, Line: 2, Column: 26},
+			},
+			Body: &BlockStatement{
+				Token:  newTestToken(token.BREAK, "break", 63, 3, 9),
+				EndPos: token.Position{Offset: 68, Line: 3, Column: 14},
+			},
+			EndPos: token.Position{Offset: 74, Line: 4, Column: 6},
+		},
+		EndPos: token.Position{Offset: 74, Line: 4, Column: 6},
+	}
+	whileStmt.Consequence.Body.Statements = []Statement{
+		&BreakStatement{Token: newTestToken(token.BREAK, "break", 63, 3, 9), ScopeStatment: whileStmt},
+	}
+	return &Program{
+		TopLevelStatements: []Statement{
+			&ScriptStatement{
+				Token: newTestToken(token.SCRIPT, "script", 8, 1, 9),
+				Name:  &Identifier{Token: newTestToken(token.IDENT, "MyScript", 15, 1, 16), Value: "MyScript"},
+				Body: &BlockStatement{
+					Token:      newTestToken(token.WHILE, "while", 30, 2, 5),
+					Statements: []Statement{whileStmt},
+					EndPos:     token.Position{Offset: 74, Line: 4, Column: 6},
+				},
+				Scope:       token.GLOBAL,
+				Annotations: Annotations{{Token: newTestToken(token.AT, "@", 0, 1, 1), Name: "unused"}},
+				EndPos:      token.Position{Offset: 76, Line: 5, Column: 2},
+			},
+		},
+		Texts:    []Text{{Name: "MyScript_Text_0", Value: "Hello$", IsGlobal: false}},
+		Comments: []*Comment{{Token: newTestToken(token.COMMENT, "# end", 77, 5, 3)}},
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	expected := `{
+  "node": "Program",
+  "TopLevelStatements": [
+    {
+      "node": "ScriptStatement",
+      "Token": {
+        "Type": "SCRIPT",
+        "Literal": "script",
+        "Filepath": "",
+        "LineNumber": 1,
+        "Column": 9,
+        "Offset": 8,
+        "End": {
+          "Offset": 14,
+          "Line": 1,
+          "Column": 15
+        }
+      },
+      "Name": {
+        "node": "Identifier",
+        "Token": {
+          "Type": "IDENT",
+          "Literal": "MyScript",
+          "Filepath": "",
+          "LineNumber": 1,
+          "Column": 16,
+          "Offset": 15,
+          "End": {
+            "Offset": 23,
+            "Line": 1,
+            "Column": 24
+          }
+        },
+        "Value": "MyScript"
+      },
+      "Params": [],
+      "Body": {
+        "node": "BlockStatement",
+        "Token": {
+          "Type": "WHILE",
+          "Literal": "while",
+          "Filepath": "",
+          "LineNumber": 2,
+          "Column": 5,
+          "Offset": 30,
+          "End": {
+            "Offset": 35,
+            "Line": 2,
+            "Column": 10
+          }
+        },
+        "Statements": [
+          {
+            "node": "WhileStatement",
+            "Token": {
+              "Type": "WHILE",
+              "Literal": "while",
+              "Filepath": "",
+              "LineNumber": 2,
+              "Column": 5,
+              "Offset": 30,
+              "End": {
+                "Offset": 35,
+                "Line": 2,
+                "Column": 10
+              }
+            },
+            "Consequence": {
+              "node": "ConditionExpression",
+              "Expression": {
+                "node": "OperatorExpression",
+                "Token": {
+                  "Type": "VAR",
+                  "Literal": "var",
+                  "Filepath": "",
+                  "LineNumber": 2,
+                  "Column": 12,
+                  "Offset": 37,
+                  "End": {
+                    "Offset": 40,
+                    "Line": 2,
+                    "Column": 15
+                  }
+                },
+                "Operand": "VAR_1",
+                "Operator": "<",
+                "ComparisonValue": "3",
+                "Type": "VAR",
+                "EndPos": {
+                  "Offset": 51,
+                  "Line": 2,
+                  "Column": 26
+                }
+              },
+              "Body": {
+                "node": "BlockStatement",
+                "Token": {
+                  "Type": "BREAK",
+                  "Literal": "break",
+                  "Filepath": "",
+                  "LineNumber": 3,
+                  "Column": 9,
+                  "Offset": 63,
+                  "End": {
+                    "Offset": 68,
+                    "Line": 3,
+                    "Column": 14
+                  }
+                },
+                "Statements": [
+                  {
+                    "node": "BreakStatement",
+                    "Token": {
+                      "Type": "BREAK",
+                      "Literal": "break",
+                      "Filepath": "",
+                      "LineNumber": 3,
+                      "Column": 9,
+                      "Offset": 63,
+                      "End": {
+                        "Offset": 68,
+                        "Line": 3,
+                        "Column": 14
+                      }
+                    }
+                  }
+                ],
+                "EndPos": {
+                  "Offset": 68,
+                  "Line": 3,
+                  "Column": 14
+                }
+              },
+              "EndPos": {
+                "Offset": 74,
+                "Line": 4,
+                "Column": 6
+              }
+            },
+            "EndPos": {
+              "Offset": 74,
+              "Line": 4,
+              "Column": 6
+            }
+          }
+        ],
+        "EndPos": {
+          "Offset": 74,
+          "Line": 4,
+          "Column": 6
+        }
+      },
+      "Scope": "GLOBAL",
+      "Annotations": [
+        {
+          "Token": {
+            "Type": "@",
+            "Literal": "@",
+            "Filepath": "",
+            "LineNumber": 1,
+            "Column": 1,
+            "Offset": 0,
+            "End": {
+              "Offset": 1,
+              "Line": 1,
+              "Column": 2
+            }
+          },
+          "Name": "unused",
+          "Args": []
+        }
+      ],
+      "EndPos": {
+        "Offset": 76,
+        "Line": 5,
+        "Column": 2
+      }
+    }
+  ],
+  "Texts": [
+    {
+      "Name": "MyScript_Text_0",
+      "Value": "Hello$",
+      "StringType": "",
+      "Language": "",
+      "IsGlobal": false,
+      "Annotations": []
+    }
+  ],
+  "Comments": [
+    {
+      "node": "Comment",
+      "Token": {
+        "Type": "COMMENT",
+        "Literal": "# end",
+        "Filepath": "",
+        "LineNumber": 5,
+        "Column": 3,
+        "Offset": 77,
+        "End": {
+          "Offset": 82,
+          "Line": 5,
+          "Column": 8
+        }
+      }
+    }
+  ]
+}`
+	result, err := EncodeJSON(getTestProgram())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(result) != expected {
+		t.Errorf("Incorrect JSON. Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	data, err := EncodeJSON(getTestProgram())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	program, err := DecodeJSON(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	result, err := EncodeJSON(program)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(result) != string(data) {
+		t.Errorf("Decoded program doesn't match the original. Expected:\n%s\nGot:\n%s", data, result)
+	}
+
+	script := program.TopLevelStatements[0].(*ScriptStatement)
+	whileStmt := script.Body.Statements[0].(*WhileStatement)
+	breakStmt := whileStmt.Consequence.Body.Statements[0].(*BreakStatement)
+	if breakStmt.ScopeStatment != whileStmt {
+		t.Errorf("Expected break statement to refer to its enclosing while statement")
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`{"node": "Program", "TopLevelStatements": [`, "invalid AST JSON: unexpected EOF"},
+		{`{"node": "Program", "TopLevelStatements": [{"node": "Foo"}]}`, "invalid AST JSON: program.TopLevelStatements[0]: unknown node type 'Foo'"},
+		{`{"node": "Program", "TopLevelStatements": [{"node": "Identifier"}]}`, "invalid AST JSON: program.TopLevelStatements[0]: node type 'Identifier' is not a Statement"},
+		{`{"node": "Program", "TopLevelStatements": [{"node": "ScriptStatement", "Name": {"node": "Identifier", "Value": 5}}]}`, "invalid AST JSON: program.TopLevelStatements[0].Name.Value: expected a string"},
+		{`{"node": "Program", "TopLevelStatements": [{"node": "ScriptStatement", "Name": {"node": "BlockStatement"}}]}`, "invalid AST JSON: program.TopLevelStatements[0].Name: expected node type 'Identifier', but got 'BlockStatement'"},
+		{`{"node": "Program", "TopLevelStatements": [{"node": "ScriptStatement", "Token": {"LineNumber": 1.5}}]}`, "invalid AST JSON: program.TopLevelStatements[0].Token.LineNumber: expected an integer, but got 1.5"},
+		{`{"node": "Program", "TopLevelStatements": [{"node": "ScriptStatement", "Body": {"node": "BlockStatement", "Statements": [{"node": "BreakStatement", "Token": {"LineNumber": 3, "Column": 5}}]}}]}`, "invalid AST JSON: line 3:5: break statement is not inside a loop or switch statement"},
+		{`null`, "invalid AST JSON: program is null"},
+	}
+
+	for _, test := range tests {
+		_, err := DecodeJSON([]byte(test.input))
+		if err == nil {
+			t.Errorf("Expected error '%s', but no error occurred", test.expectedError)
+			continue
+		}
+		if err.Error() != test.expectedError {
+			t.Errorf("Expected error '%s', but got '%s'", test.expectedError, err.Error())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package ast
+
+// Visitor's Visit method is invoked for each node encountered by Walk. If the
+// result visitor w is not nil, Walk visits each of the children of node with
+// the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order. It starts by calling
+// v.Visit(node), and then walks each of the node's children with the visitor
+// returned by v.Visit, in the order they appear in the node's fields.
+//
+// The break and continue statements' references to their enclosing
+// statements are not followed. The default case of a switch statement is
+// only walked when it isn't also one of the statement's cases.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatementList(v, n.TopLevelStatements)
+	case *ScriptStatement:
+		walkIdentifier(v, n.Name)
+		walkBlock(v, n.Body)
+	case *BlockStatement:
+		walkStatementList(v, n.Statements)
+	case *CommandStatement:
+		walkIdentifier(v, n.Name)
+	case *TextStatement:
+		walkIdentifier(v, n.Name)
+	case *MovementStatement:
+		walkIdentifier(v, n.Name)
+	case *MartStatement:
+		walkIdentifier(v, n.Name)
+	case *DataStatement:
+		walkIdentifier(v, n.Name)
+	case *AliasStatement:
+		walkIdentifier(v, n.Name)
+		walkIdentifier(v, n.Target)
+	case *BinaryExpression:
+		if n.Left != nil {
+			Walk(v, n.Left)
+		}
+		if n.Right != nil {
+			Walk(v, n.Right)
+		}
+	case *ConditionExpression:
+		if n.Expression != nil {
+			Walk(v, n.Expression)
+		}
+		walkBlock(v, n.Body)
+	case *IfStatement:
+		walkCondition(v, n.Consequence)
+		for _, elif := range n.ElifConsequences {
+			walkCondition(v, elif)
+		}
+		walkBlock(v, n.ElseConsequence)
+	case *WhileStatement:
+		walkCondition(v, n.Consequence)
+	case *DoWhileStatement:
+		walkCondition(v, n.Consequence)
+	case *SwitchCase:
+		walkBlock(v, n.Body)
+	case *SwitchStatement:
+		hasDefault := false
+		for _, switchCase := range n.Cases {
+			if switchCase != nil {
+				hasDefault = hasDefault || switchCase.IsDefault
+				Walk(v, switchCase)
+			}
+		}
+		if n.DefaultCase != nil && !hasDefault {
+			Walk(v, n.DefaultCase)
+		}
+	case *MapScriptsStatement:
+		walkIdentifier(v, n.Name)
+		for _, mapScript := range n.MapScripts {
+			if mapScript.Script != nil {
+				Walk(v, mapScript.Script)
+			}
+		}
+		for _, table := range n.TableMapScripts {
+			for _, entry := range table.Entries {
+				if entry.Script != nil {
+					Walk(v, entry.Script)
+				}
+			}
+		}
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatementList(v Visitor, statements []Statement) {
+	for _, stmt := range statements {
+		if stmt != nil {
+			Walk(v, stmt)
+		}
+	}
+}
+
+func walkIdentifier(v Visitor, ident *Identifier) {
+	if ident != nil {
+		Walk(v, ident)
+	}
+}
+
+func walkBlock(v Visitor, block *BlockStatement) {
+	if block != nil {
+		Walk(v, block)
+	}
+}
+
+func walkCondition(v Visitor, condition *ConditionExpression) {
+	if condition != nil {
+		Walk(v, condition)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order. It starts by calling
+// f(node), and if f returns true, Inspect invokes f recursively for each of
+// the node's children, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
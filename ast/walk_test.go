@@ -0,0 +1,120 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+func TestInspect(t *testing.T) {
+	program := getTestProgram()
+	program.TopLevelStatements = append(program.TopLevelStatements,
+		&MapScriptsStatement{
+			Name: &Identifier{Value: "MyMapScripts"},
+			MapScripts: []MapScript{{
+				Type: "MAP_SCRIPT_ON_LOAD",
+				Script: &ScriptStatement{
+					Name: &Identifier{Value: "MyMapScripts_MAP_SCRIPT_ON_LOAD"},
+					Body: &BlockStatement{Statements: []Statement{
+						&IfStatement{
+							Consequence: &ConditionExpression{
+								Expression: &BinaryExpression{
+									Left:     &OperatorExpression{Operand: "FLAG_1", Type: token.FLAG},
+									Operator: token.AND,
+									Right:    &OperatorExpression{Operand: "VAR_1", Type: token.VAR},
+								},
+								Body: &BlockStatement{},
+							},
+							ElseConsequence: &BlockStatement{Statements: []Statement{
+								&CommandStatement{Name: &Identifier{Value: "end"}},
+							}},
+						},
+					}},
+				},
+			}},
+		},
+		&TextStatement{Name: &Identifier{Value: "MyText"}},
+	)
+
+	var result []string
+	depth := 0
+	Inspect(program, func(node Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		result = append(result, fmt.Sprintf("%s%T", strings.Repeat("  ", depth), node))
+		depth++
+		return true
+	})
+	expected := []string{
+		"*ast.Program",
+		"  *ast.ScriptStatement",
+		"    *ast.Identifier",
+		"    *ast.BlockStatement",
+		"      *ast.WhileStatement",
+		"        *ast.ConditionExpression",
+		"          *ast.OperatorExpression",
+		"          *ast.BlockStatement",
+		"            *ast.BreakStatement",
+		"  *ast.MapScriptsStatement",
+		"    *ast.Identifier",
+		"    *ast.ScriptStatement",
+		"      *ast.Identifier",
+		"      *ast.BlockStatement",
+		"        *ast.IfStatement",
+		"          *ast.ConditionExpression",
+		"            *ast.BinaryExpression",
+		"              *ast.OperatorExpression",
+		"              *ast.OperatorExpression",
+		"            *ast.BlockStatement",
+		"          *ast.BlockStatement",
+		"            *ast.CommandStatement",
+		"              *ast.Identifier",
+		"  *ast.TextStatement",
+		"    *ast.Identifier",
+	}
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Incorrect walk. Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(result, "\n"))
+	}
+	if depth != 0 {
+		t.Errorf("Expected every visited node to be followed by a nil visit, but depth is %d", depth)
+	}
+
+	// Children aren't visited when f returns false.
+	count := 0
+	Inspect(program, func(node Node) bool {
+		if node != nil {
+			count++
+		}
+		_, isScript := node.(*ScriptStatement)
+		return !isScript
+	})
+	if count != 7 {
+		t.Errorf("Expected 7 nodes to be visited, got %d", count)
+	}
+}
+
+func TestWalkSwitchStatement(t *testing.T) {
+	defaultBody := &BlockStatement{}
+	switchStmt := &SwitchStatement{
+		Operand: "VAR_1",
+		Cases: []*SwitchCase{
+			{Value: "1", Body: &BlockStatement{}},
+			{IsDefault: true, Body: defaultBody},
+		},
+		DefaultCase: &SwitchCase{Body: defaultBody},
+	}
+	count := 0
+	Inspect(switchStmt, func(node Node) bool {
+		if _, ok := node.(*SwitchCase); ok {
+			count++
+		}
+		return true
+	})
+	if count != 2 {
+		t.Errorf("Expected 2 switch cases to be visited, got %d", count)
+	}
+}
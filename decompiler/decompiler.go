@@ -0,0 +1,665 @@
+// Package decompiler turns assembler event scripts, like the .inc files of
+// the decomp projects, back into Poryscript.
+package decompiler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+// A command or directive of the assembler input, without its comment.
+type line struct {
+	// The name of the command, or of the directive, which starts with a dot.
+	name string
+	args []string
+}
+
+func (l line) isDirective() bool {
+	return strings.HasPrefix(l.name, ".")
+}
+
+// A block is a label, and the lines that follow it until the next label.
+type block struct {
+	label  string
+	global bool
+	// The alignment of the label, from an ".align" directive right before
+	// or after it.
+	align string
+	lines []line
+	// The source text of the block, which is kept for raw statements.
+	source []string
+	kind   blockKind
+	// Whether the block is a map script table that is written inside of its
+	// mapscripts statement.
+	inlined bool
+}
+
+type blockKind int
+
+const (
+	rawBlock blockKind = iota
+	scriptBlock
+	textBlock
+	movementBlock
+	martBlock
+	dataBlock
+	mapScriptsBlock
+	mapScriptTableBlock
+)
+
+var labelPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(::?)(.*)$`)
+var identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// Decompile returns the Poryscript of the given assembler event scripts.
+// Scripts are reconstructed with if and switch statements from their
+// conditional branches where possible, and fall back to goto statements
+// between local scripts otherwise. Texts, movements, marts, data tables,
+// and map scripts become their statements, and anything else is kept in
+// raw statements. Comments are not kept.
+func Decompile(input string) string {
+	blocks := splitBlocks(input)
+	for i := range blocks {
+		blocks[i].kind = classifyBlock(blocks[i])
+	}
+	renameGeneratedLabels(blocks)
+	labels := getLabels(blocks)
+	references := countReferences(blocks)
+	texts := getInlineTexts(blocks, references)
+	for i := range blocks {
+		if blocks[i].kind != mapScriptsBlock {
+			continue
+		}
+		for _, l := range blocks[i].lines {
+			if l.name != "map_script" {
+				continue
+			}
+			if j, ok := labels[l.args[1]]; ok && blocks[j].kind == mapScriptTableBlock && references[l.args[1]] == 1 {
+				blocks[j].inlined = true
+			}
+		}
+	}
+	scripts := decompileScripts(blocks, references, texts)
+
+	var statements []string
+	for i, b := range blocks {
+		switch b.kind {
+		case scriptBlock:
+			statements = append(statements, scripts[i]...)
+		case textBlock:
+			if _, ok := texts[b.label]; !ok {
+				statements = append(statements, renderText(b))
+			}
+		case movementBlock:
+			statements = append(statements, renderMovement(b))
+		case martBlock:
+			statements = append(statements, renderMart(b))
+		case dataBlock:
+			statements = append(statements, renderData(b))
+		case mapScriptsBlock:
+			statements = append(statements, renderMapScripts(b, blocks, labels))
+		case mapScriptTableBlock:
+			if !b.inlined {
+				statements = append(statements, renderRaw(b))
+			}
+		default:
+			statements = append(statements, renderRaw(b))
+		}
+	}
+	if len(statements) == 0 {
+		return ""
+	}
+	return strings.Join(statements, "\n")
+}
+
+// Splits the input into its labeled blocks. Lines before the first label
+// are a block without a label.
+func splitBlocks(input string) []block {
+	var blocks []block
+	current := block{}
+	pendingAlign := ""
+	var pendingSource []string
+	flush := func() {
+		if current.label != "" || len(current.lines) > 0 {
+			blocks = append(blocks, current)
+		}
+	}
+	for _, sourceLine := range strings.Split(strings.Replace(input, "\r\n", "\n", -1), "\n") {
+		text := strings.TrimSpace(stripComment(sourceLine))
+		if text == "" {
+			if len(current.source) > 0 || current.label != "" {
+				current.source = append(current.source, sourceLine)
+			}
+			continue
+		}
+		if m := labelPattern.FindStringSubmatch(text); m != nil {
+			flush()
+			current = block{label: m[1], global: m[2] == "::", align: pendingAlign}
+			current.source = append(pendingSource, sourceLine)
+			pendingAlign = ""
+			pendingSource = nil
+			text = strings.TrimSpace(m[3])
+			if text == "" {
+				continue
+			}
+		} else if pendingAlign != "" {
+			// The alignment didn't belong to a label, after all.
+			current.lines = append(current.lines, parseLine(".align "+pendingAlign))
+			current.source = append(current.source, pendingSource...)
+			pendingAlign = ""
+			pendingSource = nil
+		}
+		l := parseLine(text)
+		if l.name == ".align" && len(l.args) == 1 && current.label != "" && current.align == "" && len(current.lines) == 0 {
+			current.align = l.args[0]
+			current.source = append(current.source, sourceLine)
+			continue
+		}
+		if l.name == ".align" && len(l.args) == 1 {
+			pendingAlign = l.args[0]
+			pendingSource = []string{sourceLine}
+			continue
+		}
+		current.lines = append(current.lines, l)
+		current.source = append(current.source, sourceLine)
+	}
+	if pendingAlign != "" {
+		current.lines = append(current.lines, parseLine(".align "+pendingAlign))
+		current.source = append(current.source, pendingSource...)
+	}
+	flush()
+	return blocks
+}
+
+// Removes the "@" or "//" comment from the end of a line.
+func stripComment(text string) string {
+	inString := false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && inString:
+			i++
+		case c == '"':
+			inString = !inString
+		case c == '@' && !inString:
+			return text[:i]
+		case c == '/' && !inString && i+1 < len(text) && text[i+1] == '/':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// Parses a command or directive, and its comma-separated arguments.
+func parseLine(text string) line {
+	name := text
+	rest := ""
+	if i := strings.IndexAny(text, " \t"); i != -1 {
+		name = text[:i]
+		rest = strings.TrimSpace(text[i:])
+	}
+	return line{name: name, args: splitArgs(rest)}
+}
+
+// Splits arguments at the commas that aren't inside of parentheses or
+// strings.
+func splitArgs(text string) []string {
+	if text == "" {
+		return nil
+	}
+	var args []string
+	depth := 0
+	inString := false
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && inString:
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(text[start:]))
+}
+
+// The directives of data values, which are the types of data rows.
+var dataDirectives = map[string]bool{
+	".byte":  true,
+	".2byte": true,
+	".4byte": true,
+}
+
+func classifyBlock(b block) blockKind {
+	if b.label == "" || len(b.lines) == 0 {
+		return rawBlock
+	}
+	switch {
+	case isText(b.lines):
+		return textBlock
+	case isMapScripts(b.lines):
+		return mapScriptsBlock
+	case isMapScriptTable(b.lines):
+		return mapScriptTableBlock
+	case len(martItems(b.lines)) > 0:
+		return martBlock
+	case b.align != "":
+		// Only marts and data can be aligned in Poryscript.
+		if isData(b.lines) {
+			return dataBlock
+		}
+		return rawBlock
+	case isMovement(b.lines):
+		return movementBlock
+	}
+	if isData(b.lines) {
+		return dataBlock
+	}
+	for i, l := range b.lines {
+		if !isCommand(l) && !isSwitchLine(b.lines, i) {
+			return rawBlock
+		}
+	}
+	return scriptBlock
+}
+
+func isData(lines []line) bool {
+	for _, l := range lines {
+		if !dataDirectives[l.name] || len(l.args) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the line can be written as a command of a Poryscript
+// script. Its name can't be a keyword, like "format", and its arguments
+// can't be strings, which would become inline texts.
+func isCommand(l line) bool {
+	if identifierPattern.FindString(l.name) != l.name || token.GetIdentType(l.name) != token.IDENT {
+		return false
+	}
+	for _, arg := range l.args {
+		if strings.Contains(arg, "\"") {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the line is part of a switch command and its cases,
+// whose names are keywords of Poryscript.
+func isSwitchLine(lines []line, i int) bool {
+	switch lines[i].name {
+	case "switch":
+		return len(lines[i].args) == 1 && i+1 < len(lines) && lines[i+1].name == "case" && len(lines[i+1].args) == 2
+	case "case":
+		for ; i > 0 && lines[i].name == "case" && len(lines[i].args) == 2; i-- {
+		}
+		return lines[i].name == "switch" && len(lines[i].args) == 1
+	}
+	return false
+}
+
+// The terminators that Poryscript adds to the end of texts, by the
+// directive of the text.
+var textTerminators = map[string]string{
+	".string":  "$",
+	".braille": "$",
+	".ascii":   "\\0",
+}
+
+// Reports whether the lines are the string directives of a single text,
+// which ends with the terminator that Poryscript adds to it. Poryscript's
+// strings can't contain quotes, and they can't start with an empty line.
+func isText(lines []line) bool {
+	for i, l := range lines {
+		if !l.isDirective() || len(l.args) == 0 || len(l.args) > 2 || !isQuoted(l.args[0]) {
+			return false
+		}
+		if value := unquote(l.args[0]); strings.Contains(value, "\"") || (i == 0 && value == "" && len(lines) > 1) {
+			return false
+		}
+		if l.name != lines[0].name || len(l.args) != len(lines[0].args) || (len(l.args) == 2 && l.args[1] != lines[0].args[1]) {
+			return false
+		}
+	}
+	terminator, ok := textTerminators[lines[0].name]
+	return ok && strings.HasSuffix(unquote(lines[len(lines)-1].args[0]), terminator)
+}
+
+func isQuoted(arg string) bool {
+	return len(arg) >= 2 && strings.HasPrefix(arg, "\"") && strings.HasSuffix(arg, "\"")
+}
+
+func unquote(arg string) string {
+	return arg[1 : len(arg)-1]
+}
+
+func isMapScripts(lines []line) bool {
+	for _, l := range lines[:len(lines)-1] {
+		if l.name != "map_script" || len(l.args) != 2 {
+			return false
+		}
+	}
+	last := lines[len(lines)-1]
+	return last.name == ".byte" && len(last.args) == 1 && last.args[0] == "0"
+}
+
+func isMapScriptTable(lines []line) bool {
+	for _, l := range lines[:len(lines)-1] {
+		if l.name != "map_script_2" || len(l.args) != 3 {
+			return false
+		}
+	}
+	last := lines[len(lines)-1]
+	return len(lines) > 1 && last.name == ".2byte" && len(last.args) == 1 && last.args[0] == "0"
+}
+
+// Returns the items of a mart, which is a list of ".2byte" items that ends
+// with ITEM_NONE, like the emitted marts, or with "pokemartlistend".
+// Returns nil if the lines aren't a mart.
+func martItems(lines []line) []string {
+	var items []string
+	for i, l := range lines {
+		if l.name == "pokemartlistend" && len(l.args) == 0 && i == len(lines)-1 {
+			return items
+		}
+		if l.name != ".2byte" || len(l.args) != 1 {
+			return nil
+		}
+		if l.args[0] == "ITEM_NONE" {
+			rest := lines[i+1:]
+			if len(rest) == 0 || (len(rest) == 2 && rest[0].name == "release" && len(rest[0].args) == 0 && rest[1].name == "end" && len(rest[1].args) == 0) {
+				return items
+			}
+			return nil
+		}
+		items = append(items, l.args[0])
+	}
+	return nil
+}
+
+func isMovement(lines []line) bool {
+	for _, l := range lines {
+		if l.isDirective() || len(l.args) > 0 || l.name == "end" || l.name == "return" {
+			return false
+		}
+	}
+	return lines[len(lines)-1].name == "step_end"
+}
+
+var generatedLabelPattern = regexp.MustCompile(`^(.+)_(Text_)?(\d+)$`)
+
+// Renames the local labels that look like the labels that Poryscript
+// generates for the chunks and inline texts of scripts, like "MyScript_1"
+// or "MyScript_Text_0", which are usually the output of compiling
+// Poryscript. The decompiled Poryscript would define them again when it is
+// compiled. Labels that are referenced by raw statements aren't renamed.
+func renameGeneratedLabels(blocks []block) {
+	labels := getLabels(blocks)
+	decompiled := func(b block) bool {
+		return b.kind == scriptBlock || b.kind == mapScriptsBlock || b.kind == mapScriptTableBlock
+	}
+	var decompiledBlocks []block
+	for _, b := range blocks {
+		if decompiled(b) {
+			decompiledBlocks = append(decompiledBlocks, b)
+		}
+	}
+	allReferences := countReferences(blocks)
+	decompiledReferences := countReferences(decompiledBlocks)
+	renames := make(map[string]string)
+	for _, b := range blocks {
+		m := generatedLabelPattern.FindStringSubmatch(b.label)
+		if m == nil || b.global || (b.kind != textBlock && !decompiled(b)) || allReferences[b.label] != decompiledReferences[b.label] {
+			continue
+		}
+		if i, ok := labels[m[1]]; !ok || blocks[i].kind != scriptBlock {
+			continue
+		}
+		base := fmt.Sprintf("%s_Part", m[1])
+		if m[2] != "" {
+			base = fmt.Sprintf("%s_SharedText_", m[1])
+		}
+		name := base + m[3]
+		for k := 2; isLabelTaken(name, labels, renames); k++ {
+			name = fmt.Sprintf("%s%s_%d", base, m[3], k)
+		}
+		renames[b.label] = name
+	}
+	if len(renames) == 0 {
+		return
+	}
+	for i := range blocks {
+		if newName, ok := renames[blocks[i].label]; ok {
+			blocks[i].label = newName
+		}
+		if !decompiled(blocks[i]) {
+			continue
+		}
+		for _, l := range blocks[i].lines {
+			for k, arg := range l.args {
+				l.args[k] = identifierPattern.ReplaceAllStringFunc(arg, func(name string) string {
+					if newName, ok := renames[name]; ok {
+						return newName
+					}
+					return name
+				})
+			}
+		}
+	}
+}
+
+// Returns the index of each labeled block.
+func getLabels(blocks []block) map[string]int {
+	labels := make(map[string]int)
+	for i, b := range blocks {
+		if b.label != "" {
+			labels[b.label] = i
+		}
+	}
+	return labels
+}
+
+func isLabelTaken(name string, labels map[string]int, renames map[string]string) bool {
+	if _, ok := labels[name]; ok {
+		return true
+	}
+	for _, newName := range renames {
+		if newName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts the references to each label in the arguments of the lines, which
+// aren't strings.
+func countReferences(blocks []block) map[string]int {
+	references := make(map[string]int)
+	for _, b := range blocks {
+		for _, l := range b.lines {
+			for _, arg := range l.args {
+				if isQuoted(arg) {
+					continue
+				}
+				for _, name := range identifierPattern.FindAllString(arg, -1) {
+					references[name]++
+				}
+			}
+		}
+	}
+	return references
+}
+
+// Returns the texts that are inlined into the commands of scripts, by their
+// labels. A text is inlined if it's local, and a script's command is its
+// only reference, like "msgbox MyText, MSGBOX_DEFAULT".
+func getInlineTexts(blocks []block, references map[string]int) map[string][]string {
+	textBlocks := make(map[string]block)
+	for _, b := range blocks {
+		if b.kind == textBlock && !b.global && b.lines[0].name == ".string" && len(b.lines[0].args) == 1 && references[b.label] == 1 {
+			textBlocks[b.label] = b
+		}
+	}
+	texts := make(map[string][]string)
+	for _, b := range blocks {
+		if b.kind != scriptBlock {
+			continue
+		}
+		for _, l := range b.lines {
+			for _, arg := range l.args {
+				if textBlock, ok := textBlocks[arg]; ok {
+					texts[arg] = textStrings(textBlock)
+				}
+			}
+		}
+	}
+	return texts
+}
+
+// Returns the scope modifier of a statement, when its scope isn't the
+// default scope of its kind of statement.
+func scopeModifier(global bool, defaultGlobal bool) string {
+	if global == defaultGlobal {
+		return ""
+	}
+	if global {
+		return "(global)"
+	}
+	return "(local)"
+}
+
+// Returns the string literals of a text's lines, without the terminator
+// that Poryscript adds to it.
+func textStrings(b block) []string {
+	terminator := textTerminators[b.lines[0].name]
+	var strs []string
+	for i, l := range b.lines {
+		value := unquote(l.args[0])
+		if i == len(b.lines)-1 && terminator != "" && !strings.HasSuffix(strings.TrimSuffix(value, terminator), terminator) {
+			value = strings.TrimSuffix(value, terminator)
+		}
+		strs = append(strs, fmt.Sprintf("\"%s\"", value))
+	}
+	return strs
+}
+
+func renderText(b block) string {
+	var sb strings.Builder
+	first := b.lines[0]
+	if len(first.args) == 2 {
+		sb.WriteString(fmt.Sprintf("@language(%s)\n", first.args[1]))
+	}
+	sb.WriteString(fmt.Sprintf("text%s %s {\n", scopeModifier(b.global, true), b.label))
+	// The string type prefixes the first string, like ascii"Hello".
+	prefix := ""
+	if first.name != ".string" {
+		prefix = strings.TrimPrefix(first.name, ".")
+	}
+	for _, str := range textStrings(b) {
+		sb.WriteString(fmt.Sprintf("    %s%s\n", prefix, str))
+		prefix = ""
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func renderMovement(b block) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("movement%s %s {\n", scopeModifier(b.global, false), b.label))
+	// The step_end terminator is added by Poryscript.
+	commands := b.lines[:len(b.lines)-1]
+	for i := 0; i < len(commands); {
+		count := 1
+		for i+count < len(commands) && commands[i+count].name == commands[i].name {
+			count++
+		}
+		if count > 1 {
+			sb.WriteString(fmt.Sprintf("    %s * %d\n", commands[i].name, count))
+		} else {
+			sb.WriteString(fmt.Sprintf("    %s\n", commands[i].name))
+		}
+		i += count
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func renderMart(b block) string {
+	var sb strings.Builder
+	if b.align != "" && b.align != "2" {
+		sb.WriteString(fmt.Sprintf("@align(%s)\n", b.align))
+	}
+	sb.WriteString(fmt.Sprintf("mart%s %s {\n", scopeModifier(b.global, false), b.label))
+	for _, item := range martItems(b.lines) {
+		sb.WriteString(fmt.Sprintf("    %s\n", item))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func renderData(b block) string {
+	var sb strings.Builder
+	if b.align != "" {
+		sb.WriteString(fmt.Sprintf("@align(%s)\n", b.align))
+	}
+	sb.WriteString(fmt.Sprintf("data%s %s {\n", scopeModifier(b.global, false), b.label))
+	for _, l := range b.lines {
+		sb.WriteString(fmt.Sprintf("    %s: %s\n", l.name, strings.Join(l.args, ", ")))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Renders the map scripts, and the tables of map scripts that are only
+// referenced by them.
+func renderMapScripts(b block, blocks []block, labels map[string]int) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("mapscripts%s %s {", scopeModifier(b.global, true), b.label))
+	if len(b.lines) == 1 {
+		sb.WriteString("}\n")
+		return sb.String()
+	}
+	sb.WriteString("\n")
+	for _, l := range b.lines[:len(b.lines)-1] {
+		mapScriptType, label := l.args[0], l.args[1]
+		j, ok := labels[label]
+		if !ok || !blocks[j].inlined {
+			sb.WriteString(fmt.Sprintf("    %s: %s\n", mapScriptType, label))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("    %s [\n", mapScriptType))
+		table := blocks[j].lines
+		for _, entry := range table[:len(table)-1] {
+			sb.WriteString(fmt.Sprintf("        %s, %s: %s\n", entry.args[0], entry.args[1], entry.args[2]))
+		}
+		sb.WriteString("    ]\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Renders a block in a raw statement, which is delimited by enough
+// backticks to contain the block.
+func renderRaw(b block) string {
+	source := strings.TrimRight(strings.Join(b.source, "\n"), "\n\t ")
+	delimiter := "`"
+	for strings.Contains(source, delimiter) {
+		if delimiter == "`" {
+			delimiter = "```"
+		} else {
+			delimiter += "`"
+		}
+	}
+	return fmt.Sprintf("raw %s\n%s\n%s\n", delimiter, source, delimiter)
+}
@@ -0,0 +1,377 @@
+package decompiler
+
+import (
+	"testing"
+
+	"github.com/huderlem/poryscript/emitter"
+	"github.com/huderlem/poryscript/formatter"
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/parser"
+)
+
+func TestDecompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: `MyTown_MapScripts::
+	map_script MAP_SCRIPT_ON_TRANSITION, MyTown_OnTransition
+	map_script MAP_SCRIPT_ON_FRAME_TABLE, MyTown_OnFrame
+	.byte 0
+
+MyTown_OnFrame:
+	map_script_2 VAR_STATE, 1, MyTown_EventScript_Intro
+	.2byte 0
+
+MyTown_OnTransition:
+	setflag FLAG_VISITED
+	end
+
+MyTown_EventScript_Intro::
+	lockall
+	checkflag FLAG_1
+	goto_if 1, MyTown_EventScript_Done
+	compare VAR_STATE, 2
+	goto_if_eq MyTown_EventScript_Two
+	msgbox MyTown_Text_Hello, MSGBOX_DEFAULT
+	call_if_set FLAG_2, MyTown_EventScript_Sub
+	releaseall
+	end
+
+MyTown_EventScript_Two:
+	setvar VAR_STATE, 3
+	releaseall
+	end
+
+MyTown_EventScript_Done:
+	releaseall
+	end
+
+MyTown_EventScript_Sub::
+	playse SE_PIN
+	return
+
+MyTown_Text_Hello:
+	.string "Hello,\n"
+	.string "world!$"
+
+MyTown_Movement_Walk:
+	walk_up
+	walk_up
+	face_down
+	step_end
+
+MyTown_Mart:
+	.align 2
+	.2byte ITEM_POTION
+	.2byte ITEM_NONE
+	release
+	end
+`,
+			expected: `mapscripts MyTown_MapScripts {
+    MAP_SCRIPT_ON_TRANSITION: MyTown_OnTransition
+    MAP_SCRIPT_ON_FRAME_TABLE [
+        VAR_STATE, 1: MyTown_EventScript_Intro
+    ]
+}
+
+script(local) MyTown_OnTransition {
+    setflag(FLAG_VISITED)
+    end
+}
+
+script MyTown_EventScript_Intro {
+    lockall
+    if (flag(FLAG_1)) {
+        releaseall
+        end
+    }
+    if (var(VAR_STATE) == 2) {
+        setvar(VAR_STATE, 3)
+        releaseall
+        end
+    }
+    msgbox("Hello,\n"
+        "world!", MSGBOX_DEFAULT)
+    if (flag(FLAG_2)) {
+        call(MyTown_EventScript_Sub)
+    }
+    releaseall
+    end
+}
+
+script MyTown_EventScript_Sub {
+    playse(SE_PIN)
+    return
+}
+
+movement MyTown_Movement_Walk {
+    walk_up * 2
+    face_down
+}
+
+mart MyTown_Mart {
+    ITEM_POTION
+}
+`,
+		},
+		{
+			input: `EventScript_Choose::
+	lock
+	multichoice 0, 0, MULTI_YESNO, FALSE
+	switch VAR_RESULT
+	case 0, EventScript_Choose_Yes
+	case 1, EventScript_Choose_No
+	goto EventScript_Choose_Other
+
+EventScript_Choose_Yes:
+	msgbox Text_Shared
+	release
+	end
+
+EventScript_Choose_No:
+	msgbox Text_Shared
+	goto EventScript_Choose_Other
+
+EventScript_Choose_Other:
+	release
+	end
+
+EventScript_Loop::
+	setvar VAR_0x8004, 0
+EventScript_Loop_Next:
+	addvar VAR_0x8004, 1
+	goto_if_lt VAR_0x8004, 5, EventScript_Loop_Next
+	end
+
+Text_Shared::
+	.string "Shared.$"
+
+Text_Ascii:
+	.ascii "Hi\0"
+
+Data_Table:
+	.4byte Text_Shared, Text_Ascii
+
+	.align 2
+Data_Words:
+	.2byte 1, 2, 3
+
+`,
+			expected: `script EventScript_Choose {
+    lock
+    multichoice(0, 0, MULTI_YESNO, FALSE)
+    switch (var(VAR_RESULT)) {
+        case 0:
+            msgbox(Text_Shared)
+            release
+            end
+        case 1:
+            msgbox(Text_Shared)
+    }
+    release
+    end
+}
+
+script EventScript_Loop {
+    setvar(VAR_0x8004, 0)
+    goto(EventScript_Loop_Next)
+}
+
+script(local) EventScript_Loop_Next {
+    addvar(VAR_0x8004, 1)
+    if (var(VAR_0x8004) >= 5) {
+        end
+    }
+    goto(EventScript_Loop_Next)
+}
+
+text Text_Shared {
+    "Shared."
+}
+
+text(local) Text_Ascii {
+    ascii"Hi"
+}
+
+data Data_Table {
+    .4byte: Text_Shared, Text_Ascii
+}
+
+@align(2)
+data Data_Words {
+    .2byte: 1, 2, 3
+}
+`,
+		},
+		{
+			// The labels that look like Poryscript's generated labels are
+			// renamed, so that they don't collide with them when the
+			// decompiled Poryscript is compiled.
+			input: `Main::
+	lock
+Main_2:
+	compare VAR_1, 3
+	goto_if_lt Main_3
+	switch VAR_2
+	case 0, Main_7
+	case 1, Main_8
+	case 2, Main_8
+	release
+	end
+
+Main_3:
+	addvar VAR_1, 1
+	goto_if_set FLAG_1, Main_10
+	checktrainerflag TRAINER_X
+	goto_if 0, Main_2
+Main_10:
+	msgbox Main_Text_0
+	goto Main_2
+
+Main_7:
+	msgbox Main_Text_1
+Main_5:
+	release
+	end
+
+Main_8:
+	msgbox Main_Text_2
+	goto Main_5
+
+
+Main_Text_0:
+	.string "Again?$"
+
+Main_Text_1:
+	.string "Zero$"
+
+Main_Text_2:
+	.string "One or two$"
+`,
+			expected: `script Main {
+    lock
+    goto(Main_Part2)
+}
+
+script(local) Main_Part2 {
+    if (var(VAR_1) < 3) {
+        addvar(VAR_1, 1)
+        if (!flag(FLAG_1)) {
+            if (!defeated(TRAINER_X)) {
+                goto(Main_Part2)
+            }
+        }
+        goto(Main_Part10)
+    }
+    switch (var(VAR_2)) {
+        case 0:
+            msgbox("Zero")
+        case 1:
+        case 2:
+            msgbox("One or two")
+        default:
+            release
+            end
+    }
+    release
+    end
+}
+
+script(local) Main_Part10 {
+    msgbox("Again?")
+    goto(Main_Part2)
+}
+`,
+		},
+		{
+			input:    "Weird:\n\t.incbin \"foo.bin\"\n",
+			expected: "raw `\nWeird:\n\t.incbin \"foo.bin\"\n`\n",
+		},
+	}
+	for i, tt := range tests {
+		result := Decompile(tt.input)
+		if result != tt.expected {
+			t.Errorf("Test %d: Mismatching decompile -- Expected=%q, Got=%q", i, tt.expected, result)
+		}
+	}
+}
+
+func TestDecompileCompiledScripts(t *testing.T) {
+	// The decompiled Poryscript of compiled Poryscript is formatted, and it
+	// compiles again.
+	input := `
+script Main {
+    lock
+    while (var(VAR_1) < 3) {
+        addvar(VAR_1, 1)
+        if (flag(FLAG_1) || defeated(TRAINER_X)) {
+            msgbox("Again?")
+        }
+    }
+    do {
+        random(3)
+    } while (var(VAR_RESULT) != 0)
+    switch (var(VAR_2)) {
+        case 0:
+            msgbox("Zero")
+        case 1:
+        case 2:
+            call(Helper)
+        default:
+            release
+            end
+    }
+    release
+    end
+}
+
+script Helper {
+    if (var(VAR_3) == 1) {
+        setflag(FLAG_2)
+    } elif (!flag(FLAG_3)) {
+        applymovement(OBJ_EVENT_ID_PLAYER, Walk)
+    } else {
+        msgbox("Helper\n"
+            "text", MSGBOX_NPC)
+    }
+    return
+}
+
+movement Walk {
+    walk_up * 3
+    face_down
+}
+
+mart Shop {
+    ITEM_POTION
+    ITEM_POKE_BALL
+}
+`
+	for _, optimize := range []bool{false, true} {
+		result, err := emitProgram(input, optimize)
+		if err != nil {
+			t.Fatalf("Failed to compile the input: %s", err)
+		}
+		decompiled := Decompile(result)
+		formatted, err := formatter.Format(decompiled)
+		if err != nil {
+			t.Fatalf("Decompiled Poryscript doesn't parse: %s\n%s", err, decompiled)
+		}
+		if formatted != decompiled {
+			t.Errorf("Decompiled Poryscript isn't formatted -- Expected=%q, Got=%q", formatted, decompiled)
+		}
+		if _, err := emitProgram(decompiled, optimize); err != nil {
+			t.Errorf("Decompiled Poryscript doesn't compile: %s\n%s", err, decompiled)
+		}
+	}
+}
+
+func emitProgram(input string, optimize bool) (string, error) {
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		return "", err
+	}
+	return emitter.New(program, optimize).Emit()
+}
@@ -0,0 +1,906 @@
+package decompiler
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// A node is a straight run of a script's commands, which ends with the
+// way that the script continues after it. A labeled block of a script is
+// split into several nodes at its conditional branches, and the nodes after
+// the first don't have a label.
+type node struct {
+	label  string
+	global bool
+	// The name of the script that starts at the node, if it is an entry.
+	name     string
+	commands []command
+	exit     exitKind
+	// The terminator command, like "end", or "return".
+	terminator string
+	// The destination of a goto, or of a conditional branch.
+	target    string
+	condition condition
+	switchVar string
+	cases     []switchCase
+	// The index of the node that follows the node, or -1 if the node is the
+	// last node of consecutive script blocks.
+	next int
+	// The label of the block that the last node falls into, if it isn't a
+	// script.
+	fallLabel string
+}
+
+type exitKind int
+
+const (
+	fallExit exitKind = iota
+	terminatorExit
+	gotoExit
+	conditionExit
+	switchExit
+)
+
+type switchCase struct {
+	value  string
+	target string
+}
+
+// A command of a node. The commands that set the condition of the branches
+// after them, like "compare", are only written if a branch didn't use them.
+type command struct {
+	name string
+	args []string
+	// The condition of a call_if command, which is called if the condition
+	// is true.
+	callCondition *condition
+	target        string
+	setter        *conditionSetter
+}
+
+// A command that sets the condition for the conditional branches after it,
+// like "compare VAR_RESULT, 1".
+type conditionSetter struct {
+	name string
+	args []string
+	used bool
+}
+
+// A condition of an if statement, which checks a flag, a var, or a trainer.
+type condition struct {
+	kind    string
+	subject string
+	// The comparison operator of a var condition.
+	operator string
+	value    string
+	// Whether a flag or trainer condition is negated.
+	negated bool
+}
+
+var negatedOperators = map[string]string{
+	"==": "!=",
+	"!=": "==",
+	"<":  ">=",
+	">=": "<",
+	">":  "<=",
+	"<=": ">",
+}
+
+func (c condition) negate() condition {
+	if c.kind == "var" {
+		c.operator = negatedOperators[c.operator]
+	} else {
+		c.negated = !c.negated
+	}
+	return c
+}
+
+func (c condition) String() string {
+	if c.kind == "var" {
+		return fmt.Sprintf("var(%s) %s %s", c.subject, c.operator, c.value)
+	}
+	negation := ""
+	if c.negated {
+		negation = "!"
+	}
+	return fmt.Sprintf("%s%s(%s)", negation, c.kind, c.subject)
+}
+
+// The comparison operators of the suffixes of the conditional branches,
+// like "goto_if_eq".
+var suffixOperators = map[string]string{
+	"_eq": "==",
+	"_ne": "!=",
+	"_lt": "<",
+	"_le": "<=",
+	"_gt": ">",
+	"_ge": ">=",
+}
+
+// The comparison operators of the condition codes of "goto_if" and
+// "call_if", after a "compare" command.
+var codeOperators = map[string]string{
+	"0": "<",
+	"1": "==",
+	"2": ">",
+	"3": "<=",
+	"4": ">=",
+	"5": "!=",
+}
+
+// Parses a conditional branch, like "goto_if_set FLAG_1, MyLabel", or
+// "call_if_eq MyLabel" after a "compare" command. Returns false if the line
+// isn't a conditional branch whose condition is known.
+func parseBranch(l line, setter *conditionSetter) (condition, string, bool, bool) {
+	isCall := strings.HasPrefix(l.name, "call_if")
+	suffix := strings.TrimPrefix(strings.TrimPrefix(l.name, "goto_if"), "call_if")
+	if suffix == l.name || len(l.args) == 0 {
+		return condition{}, "", false, false
+	}
+	target := l.args[len(l.args)-1]
+	switch suffix {
+	case "_set", "_unset":
+		if len(l.args) == 2 {
+			return condition{kind: "flag", subject: l.args[0], negated: suffix == "_unset"}, target, isCall, true
+		}
+	case "_defeated", "_not_defeated":
+		if len(l.args) == 2 {
+			return condition{kind: "defeated", subject: l.args[0], negated: suffix == "_not_defeated"}, target, isCall, true
+		}
+	case "":
+		if len(l.args) != 2 || setter == nil {
+			break
+		}
+		code := l.args[0]
+		if setter.name == "compare" {
+			if operator, ok := codeOperators[code]; ok {
+				setter.used = true
+				return condition{kind: "var", subject: setter.args[0], operator: operator, value: setter.args[1]}, target, isCall, true
+			}
+			break
+		}
+		kind := "flag"
+		if setter.name == "checktrainerflag" {
+			kind = "defeated"
+		}
+		switch code {
+		case "1", "TRUE":
+			setter.used = true
+			return condition{kind: kind, subject: setter.args[0]}, target, isCall, true
+		case "0", "FALSE":
+			setter.used = true
+			return condition{kind: kind, subject: setter.args[0], negated: true}, target, isCall, true
+		}
+	default:
+		operator, ok := suffixOperators[suffix]
+		if !ok {
+			break
+		}
+		if len(l.args) == 3 {
+			return condition{kind: "var", subject: l.args[0], operator: operator, value: l.args[1]}, target, isCall, true
+		}
+		if len(l.args) == 1 && setter != nil && setter.name == "compare" {
+			setter.used = true
+			return condition{kind: "var", subject: setter.args[0], operator: operator, value: setter.args[1]}, target, isCall, true
+		}
+	}
+	return condition{}, "", false, false
+}
+
+// Returns the command that sets a condition, like "compare", or nil.
+func parseConditionSetter(l line) *conditionSetter {
+	if (l.name == "compare" && len(l.args) == 2) || ((l.name == "checkflag" || l.name == "checktrainerflag") && len(l.args) == 1) {
+		return &conditionSetter{name: l.name, args: l.args}
+	}
+	return nil
+}
+
+// Splits the script blocks into nodes. Consecutive script blocks fall into
+// each other.
+func buildNodes(blocks []block) ([]node, map[int][]int) {
+	var nodes []node
+	// The nodes of each block.
+	blockNodes := make(map[int][]int)
+	for i, b := range blocks {
+		if b.kind != scriptBlock {
+			continue
+		}
+		add := func(n node) *node {
+			nodes = append(nodes, n)
+			blockNodes[i] = append(blockNodes[i], len(nodes)-1)
+			return &nodes[len(nodes)-1]
+		}
+		current := add(node{label: b.label, global: b.global})
+		var setter *conditionSetter
+		for j := 0; j < len(b.lines); j++ {
+			l := b.lines[j]
+			if current == nil {
+				current = add(node{})
+			}
+			if (l.name == "end" || l.name == "return") && len(l.args) == 0 {
+				current.exit = terminatorExit
+				current.terminator = l.name
+				current, setter = nil, nil
+				continue
+			}
+			if l.name == "goto" && len(l.args) == 1 {
+				current.exit = gotoExit
+				current.target = l.args[0]
+				current, setter = nil, nil
+				continue
+			}
+			if l.name == "switch" && len(l.args) == 1 && j+1 < len(b.lines) && b.lines[j+1].name == "case" {
+				current.exit = switchExit
+				current.switchVar = l.args[0]
+				for j+1 < len(b.lines) && b.lines[j+1].name == "case" && len(b.lines[j+1].args) == 2 {
+					j++
+					current.cases = append(current.cases, switchCase{value: b.lines[j].args[0], target: b.lines[j].args[1]})
+				}
+				current, setter = nil, nil
+				continue
+			}
+			if cond, target, isCall, ok := parseBranch(l, setter); ok {
+				if isCall {
+					current.commands = append(current.commands, command{callCondition: &cond, target: target})
+				} else {
+					current.exit = conditionExit
+					current.condition = cond
+					current.target = target
+					current = nil
+				}
+				continue
+			}
+			setter = parseConditionSetter(l)
+			current.commands = append(current.commands, command{name: l.name, args: l.args, setter: setter})
+		}
+	}
+	// Link the nodes of each run of script blocks.
+	regionEnd := make([]bool, len(nodes))
+	for i, b := range blocks {
+		if b.kind != scriptBlock || (i+1 < len(blocks) && blocks[i+1].kind == scriptBlock) {
+			continue
+		}
+		last := blockNodes[i][len(blockNodes[i])-1]
+		regionEnd[last] = true
+		if i+1 < len(blocks) {
+			nodes[last].fallLabel = blocks[i+1].label
+		}
+	}
+	for i := range nodes {
+		nodes[i].next = i + 1
+		if regionEnd[i] {
+			nodes[i].next = -1
+		}
+	}
+	return nodes, blockNodes
+}
+
+// A statement of a decompiled script.
+type statement struct {
+	// The line of a single command, like "msgbox(MyText)".
+	text      string
+	condition *condition
+	then      []statement
+	otherwise []statement
+	switchVar string
+	cases     []caseClause
+	// The default case of a switch statement, or nil.
+	defaultCase []statement
+}
+
+type caseClause struct {
+	values []string
+	body   []statement
+}
+
+func simpleStatement(text string) statement {
+	return statement{text: text}
+}
+
+func gotoStatement(label string) statement {
+	return simpleStatement(fmt.Sprintf("goto(%s)", label))
+}
+
+// Reports whether the statements never continue after their end.
+func terminates(statements []statement) bool {
+	if len(statements) == 0 {
+		return false
+	}
+	last := statements[len(statements)-1]
+	switch {
+	case last.condition != nil:
+		return last.otherwise != nil && terminates(last.then) && terminates(last.otherwise)
+	case last.switchVar != "":
+		if last.defaultCase == nil {
+			return false
+		}
+		for _, c := range last.cases {
+			if !terminates(c.body) {
+				return false
+			}
+		}
+		return terminates(last.defaultCase)
+	}
+	return last.text == "end" || last.text == "return" || strings.HasPrefix(last.text, "goto(")
+}
+
+// A scriptDecompiler recovers the scripts of the nodes. A node that starts
+// a script is an entry. Labels that are global, or that are referenced by
+// anything else than a branch, are entries, and so are the nodes that can't
+// be placed inside of another script's if or switch statements.
+type scriptDecompiler struct {
+	nodes   []node
+	labels  map[string]int
+	entries []bool
+	visited []bool
+	// The immediate postdominator of each node, or -1 if it is the exit.
+	postdominators []int
+	changed        bool
+	names          map[string]bool
+	// The string literals of the texts that are inlined, by their labels.
+	texts map[string][]string
+}
+
+// Decompiles the scripts of the script blocks. Returns the scripts that
+// start in each block.
+func decompileScripts(blocks []block, references map[string]int, texts map[string][]string) map[int][]string {
+	nodes, blockNodes := buildNodes(blocks)
+	d := &scriptDecompiler{
+		nodes:   nodes,
+		labels:  make(map[string]int),
+		entries: make([]bool, len(nodes)),
+		names:   make(map[string]bool),
+		texts:   texts,
+	}
+	for _, b := range blocks {
+		if b.label != "" {
+			d.names[b.label] = true
+		}
+	}
+	branchReferences := make(map[string]int)
+	for i, n := range nodes {
+		if n.label != "" {
+			d.labels[n.label] = i
+		}
+		switch n.exit {
+		case gotoExit, conditionExit:
+			branchReferences[n.target]++
+		case switchExit:
+			for _, c := range n.cases {
+				branchReferences[c.target]++
+			}
+		}
+	}
+	for i, n := range nodes {
+		if n.label != "" && (n.global || references[n.label] > branchReferences[n.label]) {
+			d.entries[i] = true
+		}
+	}
+
+	var bodies map[int][]statement
+	for {
+		d.computePostdominators()
+		d.visited = make([]bool, len(nodes))
+		d.changed = false
+		bodies = make(map[int][]statement)
+		for i := range nodes {
+			if d.entries[i] {
+				bodies[i] = d.sequence(i, -1, true)
+			}
+		}
+		// Labeled nodes that weren't reached from an entry are entries, too.
+		for i, n := range nodes {
+			if !d.visited[i] && !d.entries[i] && n.label != "" {
+				d.entries[i] = true
+				d.changed = true
+			}
+		}
+		if !d.changed {
+			break
+		}
+	}
+
+	scripts := make(map[int][]string)
+	for blockIndex, indexes := range blockNodes {
+		for _, i := range indexes {
+			if !d.entries[i] {
+				continue
+			}
+			var sb strings.Builder
+			scope := ""
+			if !d.nodes[i].global {
+				scope = "(local)"
+			}
+			sb.WriteString(fmt.Sprintf("script%s %s {\n", scope, d.name(i)))
+			writeStatements(&sb, bodies[i], 1)
+			sb.WriteString("}\n")
+			scripts[blockIndex] = append(scripts[blockIndex], sb.String())
+		}
+	}
+	return scripts
+}
+
+// Returns the name of the script that starts at the node. Nodes without a
+// label are named after the label before them.
+func (d *scriptDecompiler) name(i int) string {
+	n := &d.nodes[i]
+	if n.label != "" {
+		return n.label
+	}
+	if n.name == "" {
+		base := "Script"
+		for j := i - 1; j >= 0; j-- {
+			if d.nodes[j].label != "" {
+				base = d.nodes[j].label
+				break
+			}
+		}
+		for k := 1; ; k++ {
+			name := fmt.Sprintf("%s_Part%d", base, k)
+			if !d.names[name] {
+				d.names[name] = true
+				n.name = name
+				break
+			}
+		}
+	}
+	return n.name
+}
+
+// Marks a node as an entry, which requires decompiling the scripts again.
+func (d *scriptDecompiler) addEntry(i int) {
+	if !d.entries[i] {
+		d.entries[i] = true
+		d.changed = true
+	}
+}
+
+// Returns the node of a branch's destination, or -1 if the destination
+// isn't a node of the scripts.
+func (d *scriptDecompiler) resolve(label string) int {
+	if i, ok := d.labels[label]; ok {
+		return i
+	}
+	return -1
+}
+
+// Returns the nodes that a node continues at, where -1 means that the
+// script ends, or goes to a label that isn't a node.
+func (d *scriptDecompiler) targets(i int) []int {
+	n := d.nodes[i]
+	switch n.exit {
+	case terminatorExit:
+		return []int{-1}
+	case gotoExit:
+		return []int{d.resolve(n.target)}
+	case conditionExit:
+		return []int{d.resolve(n.target), n.next}
+	case switchExit:
+		targets := []int{n.next}
+		for _, c := range n.cases {
+			targets = append(targets, d.resolve(c.target))
+		}
+		return targets
+	}
+	return []int{n.next}
+}
+
+// Returns the nodes that a node continues at inside of its script, where -1
+// means that the script leaves the node's script, by ending it, or by going
+// to an entry.
+func (d *scriptDecompiler) successors(i int) []int {
+	successors := d.targets(i)
+	for k, j := range successors {
+		if j != -1 && d.entries[j] {
+			successors[k] = -1
+		}
+	}
+	return successors
+}
+
+// Computes the immediate postdominator of each node, which is the first
+// node that all of the node's paths go through. It is where the branches
+// of an if or switch statement join again.
+func (d *scriptDecompiler) computePostdominators() {
+	count := len(d.nodes)
+	exit := count
+	words := (count + 64) / 64
+	index := func(j int) int {
+		if j == -1 {
+			return exit
+		}
+		return j
+	}
+
+	// Nodes that never reach the exit, like infinite loops, don't have a
+	// postdominator.
+	reachesExit := make([]bool, count+1)
+	reachesExit[exit] = true
+	for changed := true; changed; {
+		changed = false
+		for i := count - 1; i >= 0; i-- {
+			if reachesExit[i] {
+				continue
+			}
+			for _, s := range d.successors(i) {
+				if reachesExit[index(s)] {
+					reachesExit[i] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	sets := make([][]uint64, count+1)
+	for i := range sets {
+		sets[i] = make([]uint64, words)
+		if i == exit {
+			sets[i][exit/64] |= 1 << uint(exit%64)
+			continue
+		}
+		for w := range sets[i] {
+			sets[i][w] = ^uint64(0)
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := count - 1; i >= 0; i-- {
+			if !reachesExit[i] {
+				continue
+			}
+			set := make([]uint64, words)
+			for w := range set {
+				set[w] = ^uint64(0)
+			}
+			for _, s := range d.successors(i) {
+				if !reachesExit[index(s)] {
+					continue
+				}
+				for w := range set {
+					set[w] &= sets[index(s)][w]
+				}
+			}
+			set[i/64] |= 1 << uint(i%64)
+			for w := range set {
+				if set[w] != sets[i][w] {
+					sets[i] = set
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	size := func(set []uint64) int {
+		total := 0
+		for _, w := range set {
+			total += bits.OnesCount64(w)
+		}
+		return total
+	}
+	d.postdominators = make([]int, count)
+	for i := 0; i < count; i++ {
+		d.postdominators[i] = -1
+		if !reachesExit[i] {
+			continue
+		}
+		// The immediate postdominator is the closest one, which is
+		// postdominated by all of the others.
+		best, bestSize := exit, 0
+		for j := 0; j <= count; j++ {
+			if j == i || sets[i][j/64]&(1<<uint(j%64)) == 0 {
+				continue
+			}
+			if s := size(sets[j]); s > bestSize {
+				best, bestSize = j, s
+			}
+		}
+		if best != exit {
+			d.postdominators[i] = best
+		}
+	}
+}
+
+// Returns the nodes that can be reached from a node without leaving its
+// script, and the entries that it goes to.
+func (d *scriptDecompiler) reachable(start int) map[int]bool {
+	seen := make(map[int]bool)
+	if start == -1 {
+		return seen
+	}
+	seen[start] = true
+	stack := []int{start}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if d.entries[i] {
+			continue
+		}
+		for _, s := range d.targets(i) {
+			if s != -1 && !seen[s] {
+				seen[s] = true
+				stack = append(stack, s)
+			}
+		}
+	}
+	return seen
+}
+
+// Returns the node where the branches of a node join again, or -1 if they
+// don't. It is the node's immediate postdominator, if the node has one.
+// Otherwise, some of the branches leave the script, like with a goto to
+// another script, and the other branches join at the stop node, or at the
+// first node that more than one of them reach.
+func (d *scriptDecompiler) findJoin(i int, stop int) int {
+	if join := d.postdominators[i]; join != -1 {
+		return join
+	}
+	successors := d.targets(i)
+	counts := make(map[int]int)
+	for k, s := range successors {
+		duplicate := false
+		for _, other := range successors[:k] {
+			if other == s {
+				duplicate = true
+			}
+		}
+		if duplicate {
+			continue
+		}
+		for j := range d.reachable(s) {
+			counts[j]++
+		}
+	}
+	if stop != -1 && counts[stop] > 0 {
+		// The branches that don't leave the script join at the end of the
+		// enclosing if or switch statement.
+		return stop
+	}
+	join, joinCount, joinSize := -1, 1, 0
+	for j, count := range counts {
+		if count < joinCount {
+			continue
+		}
+		size := len(d.reachable(j))
+		if count > joinCount || size > joinSize || (size == joinSize && j < join) {
+			join, joinCount, joinSize = j, count, size
+		}
+	}
+	return join
+}
+
+// Decompiles the statements of a branch to a label.
+func (d *scriptDecompiler) branch(label string, stop int) []statement {
+	i := d.resolve(label)
+	if i == -1 {
+		return []statement{gotoStatement(label)}
+	}
+	return d.sequence(i, stop, false)
+}
+
+// Decompiles the statements of the node that follows a node, which is
+// where a conditional branch continues when its condition is false.
+func (d *scriptDecompiler) fallThrough(i int, stop int) []statement {
+	n := d.nodes[i]
+	if n.next != -1 {
+		return d.sequence(n.next, stop, false)
+	}
+	if n.fallLabel != "" {
+		return []statement{gotoStatement(n.fallLabel)}
+	}
+	return nil
+}
+
+// Decompiles the statements that start at a node, until they reach the
+// stop node, or leave the script. The first node of a script is an entry.
+func (d *scriptDecompiler) sequence(i int, stop int, first bool) []statement {
+	var statements []statement
+	for {
+		if i == stop {
+			return statements
+		}
+		if d.entries[i] && !first {
+			return append(statements, gotoStatement(d.name(i)))
+		}
+		if d.visited[i] {
+			// The node is reached from more than one place, so it has to be
+			// its own script.
+			d.addEntry(i)
+			return append(statements, gotoStatement(d.name(i)))
+		}
+		first = false
+		d.visited[i] = true
+		n := d.nodes[i]
+		statements = append(statements, d.commandStatements(n.commands)...)
+		switch n.exit {
+		case terminatorExit:
+			return append(statements, simpleStatement(n.terminator))
+		case gotoExit:
+			target := d.resolve(n.target)
+			if target == -1 {
+				return append(statements, gotoStatement(n.target))
+			}
+			i = target
+		case conditionExit:
+			join := d.findJoin(i, stop)
+			if join != -1 {
+				statements = append(statements, ifStatement(n.condition, d.branch(n.target, join), d.fallThrough(i, join)))
+				i = join
+				continue
+			}
+			// The branches don't join again, so at least one of them leaves
+			// the script. The smaller branch is placed inside of the if
+			// statement, and the script continues with the other one.
+			target := d.resolve(n.target)
+			if n.next != -1 && !d.entries[n.next] && len(d.reachable(target)) > len(d.reachable(n.next)) {
+				otherwise := d.fallThrough(i, stop)
+				if !terminates(otherwise) {
+					return append(statements, ifStatement(n.condition, d.branch(n.target, stop), otherwise))
+				}
+				cond := n.condition.negate()
+				statements = append(statements, statement{condition: &cond, then: otherwise})
+				if target == -1 {
+					return append(statements, gotoStatement(n.target))
+				}
+				i = target
+				continue
+			}
+			then := d.branch(n.target, stop)
+			if !terminates(then) {
+				return append(statements, ifStatement(n.condition, then, d.fallThrough(i, stop)))
+			}
+			statements = append(statements, statement{condition: &n.condition, then: then})
+			if n.next == -1 {
+				return append(statements, d.fallThrough(i, stop)...)
+			}
+			i = n.next
+		case switchExit:
+			join := d.findJoin(i, stop)
+			caseStop := stop
+			if join != -1 {
+				caseStop = join
+			}
+			s := statement{switchVar: n.switchVar}
+			clauses := make(map[string]int)
+			for _, c := range n.cases {
+				if k, ok := clauses[c.target]; ok {
+					s.cases[k].values = append(s.cases[k].values, c.value)
+					continue
+				}
+				clauses[c.target] = len(s.cases)
+				s.cases = append(s.cases, caseClause{values: []string{c.value}})
+			}
+			for _, c := range n.cases {
+				k := clauses[c.target]
+				if s.cases[k].body == nil {
+					s.cases[k].body = d.branch(c.target, caseStop)
+					if s.cases[k].body == nil {
+						s.cases[k].body = []statement{simpleStatement("break")}
+					}
+				}
+			}
+			allTerminate := true
+			for _, c := range s.cases {
+				if !terminates(c.body) {
+					allTerminate = false
+				}
+			}
+			if join == -1 && allTerminate {
+				// The script continues after the switch statement when none
+				// of the cases match.
+				statements = append(statements, s)
+				if n.next == -1 {
+					return append(statements, d.fallThrough(i, stop)...)
+				}
+				i = n.next
+				continue
+			}
+			if n.next != join {
+				s.defaultCase = d.fallThrough(i, caseStop)
+			}
+			statements = append(statements, s)
+			if join == -1 {
+				return statements
+			}
+			i = join
+		default:
+			if n.next == -1 {
+				if n.fallLabel != "" {
+					statements = append(statements, gotoStatement(n.fallLabel))
+				}
+				return statements
+			}
+			i = n.next
+		}
+	}
+}
+
+// Returns an if statement. Empty branches are avoided by negating the
+// condition, and an else branch that is a single if statement becomes an
+// elif branch when the statements are written.
+func ifStatement(cond condition, then []statement, otherwise []statement) statement {
+	if len(then) == 0 && len(otherwise) > 0 {
+		cond = cond.negate()
+		then, otherwise = otherwise, nil
+	}
+	return statement{condition: &cond, then: then, otherwise: otherwise}
+}
+
+// Returns the statements of a node's commands. The commands that set the
+// condition of the branches after them are left out, unless they are used
+// by a command that isn't a known branch.
+func (d *scriptDecompiler) commandStatements(commands []command) []statement {
+	var statements []statement
+	for _, c := range commands {
+		switch {
+		case c.setter != nil && c.setter.used:
+		case c.callCondition != nil:
+			statements = append(statements, statement{condition: c.callCondition, then: []statement{simpleStatement(fmt.Sprintf("call(%s)", c.target))}})
+		case len(c.args) == 0:
+			statements = append(statements, simpleStatement(c.name))
+		default:
+			statements = append(statements, simpleStatement(d.renderCommand(c)))
+		}
+	}
+	return statements
+}
+
+// Renders a command with arguments. The lines of an inlined text after
+// the first are indented once more than the command, like the formatter
+// does.
+func (d *scriptDecompiler) renderCommand(c command) string {
+	var sb strings.Builder
+	sb.WriteString(c.name + "(")
+	for k, arg := range c.args {
+		if k > 0 {
+			sb.WriteString(", ")
+		}
+		strs, ok := d.texts[arg]
+		if !ok {
+			sb.WriteString(arg)
+			continue
+		}
+		sb.WriteString(strings.Join(strs, "\n    "))
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func writeStatements(sb *strings.Builder, statements []statement, depth int) {
+	indent := strings.Repeat("    ", depth)
+	for _, s := range statements {
+		switch {
+		case s.condition != nil:
+			sb.WriteString(fmt.Sprintf("%sif (%s) {\n", indent, s.condition))
+			writeStatements(sb, s.then, depth+1)
+			otherwise := s.otherwise
+			for len(otherwise) == 1 && otherwise[0].condition != nil {
+				sb.WriteString(fmt.Sprintf("%s} elif (%s) {\n", indent, otherwise[0].condition))
+				writeStatements(sb, otherwise[0].then, depth+1)
+				otherwise = otherwise[0].otherwise
+			}
+			if len(otherwise) > 0 {
+				sb.WriteString(fmt.Sprintf("%s} else {\n", indent))
+				writeStatements(sb, otherwise, depth+1)
+			}
+			sb.WriteString(fmt.Sprintf("%s}\n", indent))
+		case s.switchVar != "":
+			sb.WriteString(fmt.Sprintf("%sswitch (var(%s)) {\n", indent, s.switchVar))
+			for _, c := range s.cases {
+				for _, value := range c.values {
+					sb.WriteString(fmt.Sprintf("%s    case %s:\n", indent, value))
+				}
+				writeStatements(sb, c.body, depth+2)
+			}
+			if len(s.defaultCase) > 0 {
+				sb.WriteString(fmt.Sprintf("%s    default:\n", indent))
+				writeStatements(sb, s.defaultCase, depth+2)
+			}
+			sb.WriteString(fmt.Sprintf("%s}\n", indent))
+		default:
+			sb.WriteString(fmt.Sprintf("%s%s\n", indent, strings.Replace(s.text, "\n", "\n"+indent, -1)))
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Package diff compares texts line by line, and describes their differences
+// as unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The number of unchanged lines that are shown around each change.
+const contextLines = 3
+
+// The largest number of line insertions and deletions that the diff searches
+// for. Texts that are more different than that are described as replacing
+// all of their differing lines, so that the search stays fast.
+const maxEditDistance = 2000
+
+type editKind int
+
+const (
+	equal editKind = iota
+	deletion
+	insertion
+)
+
+// An edit of a single line. Equal lines and deletions refer to the line of
+// the old text, and insertions to the line of the new text.
+type edit struct {
+	kind editKind
+	line string
+	// The indexes of the old and new lines before the edit.
+	oldIndex int
+	newIndex int
+}
+
+// Unified returns the differences between the old and new text as a unified
+// diff, which is headed by the names of the texts. It returns an empty
+// string if the texts are equal.
+func Unified(oldName string, newName string, oldText string, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	edits := computeEdits(splitLines(oldText), splitLines(newText))
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", oldName, newName))
+	for _, hunk := range getHunks(edits) {
+		writeHunk(&sb, hunk)
+	}
+	return sb.String()
+}
+
+// Splits a text into its lines, which keep their line endings. The last
+// line doesn't have one if the text doesn't end with a newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Returns the edits that turn the old lines into the new lines. The common
+// lines at their start and end are skipped before searching for the shortest
+// edits with Myers' algorithm.
+func computeEdits(oldLines []string, newLines []string) []edit {
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix && oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, edit{kind: equal, line: oldLines[i], oldIndex: i, newIndex: i})
+	}
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+	for _, e := range findShortestEdits(a, b) {
+		e.oldIndex += prefix
+		e.newIndex += prefix
+		edits = append(edits, e)
+	}
+	for i := 0; i < suffix; i++ {
+		oldIndex := len(oldLines) - suffix + i
+		newIndex := len(newLines) - suffix + i
+		edits = append(edits, edit{kind: equal, line: oldLines[oldIndex], oldIndex: oldIndex, newIndex: newIndex})
+	}
+	return edits
+}
+
+// Returns the shortest edits that turn a into b. Each step d of the search
+// records the furthest index of a that can be reached with d edits, on each
+// diagonal k = x - y, which is then traced back to find the edits.
+func findShortestEdits(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && d <= maxEditDistance && !found; d++ {
+		// v[k+d] is the furthest x on diagonal k.
+		v := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if d == 0 {
+				x = 0
+			} else if k == -d || (k != d && trace[d-1][k-1+d-1] < trace[d-1][k+1+d-1]) {
+				x = trace[d-1][k+1+d-1]
+			} else {
+				x = trace[d-1][k-1+d-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+d] = x
+			if x >= n && y >= m {
+				found = true
+			}
+		}
+		trace = append(trace, v)
+	}
+	if !found {
+		return replaceAll(a, b)
+	}
+
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		prev := trace[d-1]
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{kind: equal, line: a[x], oldIndex: x, newIndex: y})
+		}
+		if prevK == k+1 {
+			edits = append(edits, edit{kind: insertion, line: b[prevY], oldIndex: prevX, newIndex: prevY})
+		} else {
+			edits = append(edits, edit{kind: deletion, line: a[prevX], oldIndex: prevX, newIndex: prevY})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, edit{kind: equal, line: a[x], oldIndex: x, newIndex: y})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Returns the edits that delete all of a, and insert all of b.
+func replaceAll(a []string, b []string) []edit {
+	var edits []edit
+	for i, line := range a {
+		edits = append(edits, edit{kind: deletion, line: line, oldIndex: i})
+	}
+	for i, line := range b {
+		edits = append(edits, edit{kind: insertion, line: line, oldIndex: len(a), newIndex: i})
+	}
+	return edits
+}
+
+// Groups the changes into hunks, which are surrounded by unchanged lines.
+// Changes that are close enough to share their unchanged lines are in the
+// same hunk.
+func getHunks(edits []edit) [][]edit {
+	var hunks [][]edit
+	start, end, lastChange := -1, -1, -1
+	for i, e := range edits {
+		if e.kind == equal {
+			continue
+		}
+		if start != -1 && i-lastChange-1 > 2*contextLines {
+			hunks = append(hunks, edits[start:end])
+			start = -1
+		}
+		lastChange = i
+		if start == -1 {
+			start = i - contextLines
+			if start < 0 {
+				start = 0
+			}
+		}
+		end = i + 1 + contextLines
+		if end > len(edits) {
+			end = len(edits)
+		}
+	}
+	if start != -1 {
+		hunks = append(hunks, edits[start:end])
+	}
+	return hunks
+}
+
+func writeHunk(sb *strings.Builder, hunk []edit) {
+	oldCount, newCount := 0, 0
+	for _, e := range hunk {
+		if e.kind != insertion {
+			oldCount++
+		}
+		if e.kind != deletion {
+			newCount++
+		}
+	}
+	sb.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", formatRange(hunk[0].oldIndex, oldCount), formatRange(hunk[0].newIndex, newCount)))
+	for _, e := range hunk {
+		switch e.kind {
+		case equal:
+			sb.WriteString(" ")
+		case deletion:
+			sb.WriteString("-")
+		case insertion:
+			sb.WriteString("+")
+		}
+		sb.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Formats the range of a hunk's lines, which starts at the given index. An
+// empty range refers to the line before it, and a range of one line doesn't
+// have a count.
+func formatRange(index int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", index)
+	case 1:
+		return fmt.Sprintf("%d", index+1)
+	}
+	return fmt.Sprintf("%d,%d", index+1, count)
+}
@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		oldText  string
+		newText  string
+		expected string
+	}{
+		{
+			oldText:  "a\nb\n",
+			newText:  "a\nb\n",
+			expected: "",
+		},
+		{
+			oldText: "MyScript::\n\tlock\n\tend\n",
+			newText: "MyScript::\n\tlock\n\tfaceplayer\n\tend\n",
+			expected: `--- old.inc
++++ new.inc
+@@ -1,3 +1,4 @@
+ MyScript::
+ 	lock
++	faceplayer
+ 	end
+`,
+		},
+		{
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			newText: "1\nTWO\n3\n4\n5\n6\n7\n8\n9\n10\n12\n",
+			expected: `--- old.inc
++++ new.inc
+@@ -1,5 +1,5 @@
+ 1
+-2
++TWO
+ 3
+ 4
+ 5
+@@ -8,5 +8,4 @@
+ 8
+ 9
+ 10
+-11
+ 12
+`,
+		},
+		{
+			oldText: "a\nb\n",
+			newText: "",
+			expected: `--- old.inc
++++ new.inc
+@@ -1,2 +0,0 @@
+-a
+-b
+`,
+		},
+		{
+			oldText: "",
+			newText: "a\n",
+			expected: `--- old.inc
++++ new.inc
+@@ -0,0 +1 @@
++a
+`,
+		},
+		{
+			oldText: "a\nb",
+			newText: "a\nb\n",
+			expected: `--- old.inc
++++ new.inc
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+	}
+	for i, tt := range tests {
+		result := Unified("old.inc", "new.inc", tt.oldText, tt.newText)
+		if result != tt.expected {
+			t.Errorf("Test %d: Mismatching diff -- Expected=%q, Got=%q", i, tt.expected, result)
+		}
+	}
+}
+
+func TestUnifiedLargeDifference(t *testing.T) {
+	// Texts that have more differences than the search allows are diffed by
+	// replacing all of their differing lines.
+	var oldLines, newLines []string
+	for i := 0; i < maxEditDistance; i++ {
+		oldLines = append(oldLines, "old\n")
+		newLines = append(newLines, "new\n")
+	}
+	oldText := "same\n" + strings.Join(oldLines, "")
+	newText := "same\n" + strings.Join(newLines, "")
+	result := Unified("old.inc", "new.inc", oldText, newText)
+	expectedHeader := "--- old.inc\n+++ new.inc\n@@ -1,2001 +1,2001 @@\n same\n-old\n"
+	if !strings.HasPrefix(result, expectedHeader) {
+		t.Fatalf("Mismatching diff header -- Expected=%q, Got=%q", expectedHeader, result[:len(expectedHeader)])
+	}
+	if strings.Count(result, "\n-old") != maxEditDistance || strings.Count(result, "\n+new") != maxEditDistance {
+		t.Errorf("Expected %d deleted and inserted lines", maxEditDistance)
+	}
+}
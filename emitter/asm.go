@@ -0,0 +1,276 @@
+package emitter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+	"github.com/huderlem/poryscript/token"
+)
+
+// asmBackend emits assembler bytecode scripts, which are built by the
+// decompilation projects. The profile has the macros of the project.
+type asmBackend struct {
+	profile asmProfile
+}
+
+// EmitScript satisfies the Backend interface.
+func (b *asmBackend) EmitScript(script *ir.Script, chunkIDs []int) (string, error) {
+	return renderScript(script, chunkIDs, "@", func(sb *strings.Builder, branch ir.Branch, nextChunkID int, registerJumpChunk func(int)) (bool, error) {
+		return b.profile.renderBranch(sb, branch, script, nextChunkID, registerJumpChunk), nil
+	})
+}
+
+// TailCallCommand satisfies the TailCallBackend interface.
+func (b *asmBackend) TailCallCommand(script *ir.Script) string {
+	return "call"
+}
+
+// Renders the chunks of a script in the given order. The branch at the end
+// of each chunk is rendered with renderBranch, which returns true if the
+// chunk falls through to the next chunk. Source comments start with
+// commentPrefix.
+func renderScript(script *ir.Script, chunkIDs []int, commentPrefix string, renderBranch func(sb *strings.Builder, branch ir.Branch, nextChunkID int, registerJumpChunk func(int)) (bool, error)) (string, error) {
+	// First, render the bodies of each chunk. We'll
+	// render the actual chunk labels after, since there is
+	// an opportunity to skip renering unnecessary labels.
+	var nextChunkID int
+	chunkBodies := make(map[int]*strings.Builder)
+	jumpChunks := make(map[int]bool)
+	registerJumpChunk := func(chunkID int) {
+		jumpChunks[chunkID] = true
+	}
+	for i, chunkID := range chunkIDs {
+		var sb strings.Builder
+		chunkBodies[chunkID] = &sb
+		if i < len(chunkIDs)-1 {
+			nextChunkID = chunkIDs[i+1]
+		} else {
+			nextChunkID = -1
+		}
+		chunk := script.Chunks[chunkID]
+		renderSourceComment(&sb, chunk, "\t"+commentPrefix)
+		for _, command := range chunk.Commands {
+			sb.WriteString(renderCommand(command))
+		}
+		isFallThrough, err := renderBranch(&sb, chunk.Branch, nextChunkID, registerJumpChunk)
+		if err != nil {
+			return "", err
+		}
+		if !isFallThrough {
+			sb.WriteString("\n")
+		}
+	}
+
+	// Render the labels of each chunk, followed by its body.
+	// A label doesn't need to be rendered if nothing ever jumps
+	// to it.
+	var sb strings.Builder
+	for _, chunkID := range chunkIDs {
+		if chunkID == 0 || jumpChunks[chunkID] {
+			sb.WriteString(renderLabel(script, chunkID))
+		}
+		sb.WriteString(chunkBodies[chunkID].String())
+	}
+
+	return sb.String(), nil
+}
+
+// Renders a comment with the location of the chunk's commands in the
+// Poryscript source, if it is known.
+func renderSourceComment(sb *strings.Builder, chunk *ir.Chunk, commentPrefix string) {
+	if source, ok := chunk.Source(); ok {
+		sb.WriteString(fmt.Sprintf("%s %s\n", commentPrefix, source))
+	}
+}
+
+// EmitMapScripts satisfies the Backend interface.
+func (b *asmBackend) EmitMapScripts(mapScriptStmt *ast.MapScriptsStatement, emitScript func(*ast.ScriptStatement) (string, error)) (string, error) {
+	var sb strings.Builder
+	if mapScriptStmt.Scope == token.GLOBAL {
+		sb.WriteString(fmt.Sprintf("%s::\n", mapScriptStmt.Name.Value))
+	} else {
+		sb.WriteString(fmt.Sprintf("%s:\n", mapScriptStmt.Name.Value))
+	}
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		sb.WriteString(fmt.Sprintf("\tmap_script %s, %s\n", mapScript.Type, mapScript.Name))
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		sb.WriteString(fmt.Sprintf("\tmap_script %s, %s\n", tableMapScript.Type, tableMapScript.Name))
+	}
+	sb.WriteString("\t.byte 0\n\n")
+
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		if mapScript.Script != nil {
+			scriptOutput, err := emitScript(mapScript.Script)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(scriptOutput)
+		}
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		sb.WriteString(fmt.Sprintf("%s:\n", tableMapScript.Name))
+		for _, scriptEntry := range tableMapScript.Entries {
+			sb.WriteString(fmt.Sprintf("\tmap_script_2 %s, %s, %s\n", scriptEntry.Condition, scriptEntry.Comparison, scriptEntry.Name))
+		}
+		sb.WriteString("\t.2byte 0\n\n")
+		for _, scriptEntry := range tableMapScript.Entries {
+			if scriptEntry.Script != nil {
+				scriptOutput, err := emitScript(scriptEntry.Script)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(scriptOutput)
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
+
+func renderLabel(script *ir.Script, chunkID int) string {
+	if chunkID != 0 {
+		return fmt.Sprintf("%s:\n", script.ChunkLabel(chunkID))
+	}
+	// Main script entrypoint label.
+	if script.IsGlobal {
+		return fmt.Sprintf("%s::\n", script.Name)
+	}
+	return fmt.Sprintf("%s:\n", script.Name)
+}
+
+func renderCommand(command ir.Command) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("\t%s", command.Name))
+	if len(command.Args) > 0 {
+		sb.WriteString(fmt.Sprintf(" %s", strings.Join(command.Args, ", ")))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// EmitAlignment satisfies the Backend interface. It renders an alignment
+// directive, if the statement was annotated with @align.
+func (b *asmBackend) EmitAlignment(annotations ast.Annotations) string {
+	align, ok := annotations.Get("align")
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("\t.align %s\n", align.Args[0])
+}
+
+// EmitText satisfies the Backend interface.
+func (b *asmBackend) EmitText(text ast.Text) (string, error) {
+	var sb strings.Builder
+	if text.IsGlobal {
+		sb.WriteString(fmt.Sprintf("%s::\n", text.Name))
+	} else {
+		sb.WriteString(fmt.Sprintf("%s:\n", text.Name))
+	}
+	lines := strings.Split(text.Value, "\n")
+	for _, line := range lines {
+		directive := "string"
+		if len(text.StringType) > 0 {
+			directive = text.StringType
+		}
+		if text.Language != "" {
+			sb.WriteString(fmt.Sprintf("\t.%s \"%s\", %s\n", directive, line, text.Language))
+		} else {
+			sb.WriteString(fmt.Sprintf("\t.%s \"%s\"\n", directive, line))
+		}
+	}
+	return sb.String(), nil
+}
+
+// EmitRaw satisfies the Backend interface.
+func (b *asmBackend) EmitRaw(rawStmt *ast.RawStatement) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s\n", rawStmt.Value))
+	return sb.String(), nil
+}
+
+// EmitDirective satisfies the Backend interface.
+func (b *asmBackend) EmitDirective(directiveStmt *ast.DirectiveStatement) (string, error) {
+	var sb strings.Builder
+	for _, line := range strings.Split(directiveStmt.Value, "\n") {
+		sb.WriteString(fmt.Sprintf("%s\n", strings.TrimSpace(line)))
+	}
+	return sb.String(), nil
+}
+
+// EmitMovement satisfies the Backend interface.
+func (b *asmBackend) EmitMovement(movementStmt *ast.MovementStatement) (string, error) {
+	terminator := "step_end"
+	var sb strings.Builder
+	if movementStmt.Scope == token.GLOBAL {
+		sb.WriteString(fmt.Sprintf("%s::\n", movementStmt.Name.Value))
+	} else {
+		sb.WriteString(fmt.Sprintf("%s:\n", movementStmt.Name.Value))
+	}
+	for _, cmd := range movementStmt.MovementCommands {
+		sb.WriteString(fmt.Sprintf("\t%s\n", cmd))
+		if cmd == terminator {
+			return sb.String(), nil
+		}
+	}
+	sb.WriteString(fmt.Sprintf("\t%s\n", terminator))
+	return sb.String(), nil
+}
+
+// EmitMart satisfies the Backend interface.
+func (b *asmBackend) EmitMart(martStmt *ast.MartStatement) (string, error) {
+	terminator := "ITEM_NONE"
+	var sb strings.Builder
+	if martStmt.Annotations.Has("align") {
+		sb.WriteString(b.EmitAlignment(martStmt.Annotations))
+	} else {
+		sb.WriteString("\t.align 2\n")
+	}
+	if martStmt.Scope == token.GLOBAL {
+		sb.WriteString(fmt.Sprintf("%s::\n", martStmt.Name.Value))
+	} else {
+		sb.WriteString(fmt.Sprintf("%s:\n", martStmt.Name.Value))
+	}
+	for _, item := range martStmt.MartItems {
+		if item == terminator {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("\t.2byte %s\n", item))
+	}
+	sb.WriteString("\t.2byte ITEM_NONE\n\trelease\n\tend\n")
+	return sb.String(), nil
+}
+
+// The directives of data values, by their size in bytes.
+var asmDataDirectives = map[int]string{
+	1: ".byte",
+	2: ".2byte",
+	4: ".4byte",
+}
+
+// EmitData satisfies the Backend interface.
+func (b *asmBackend) EmitData(dataStmt *ast.DataStatement) (string, error) {
+	var sb strings.Builder
+	if dataStmt.Scope == token.GLOBAL {
+		sb.WriteString(fmt.Sprintf("%s::\n", dataStmt.Name.Value))
+	} else {
+		sb.WriteString(fmt.Sprintf("%s:\n", dataStmt.Name.Value))
+	}
+	for _, row := range dataStmt.Rows {
+		sb.WriteString(fmt.Sprintf("\t%s %s\n", asmDataDirectives[row.Size], strings.Join(row.Values, ", ")))
+	}
+	return sb.String(), nil
+}
+
+// EmitAlias satisfies the Backend interface. The alias is a symbol that is
+// equated to its label.
+func (b *asmBackend) EmitAlias(aliasStmt *ast.AliasStatement) (string, error) {
+	var sb strings.Builder
+	if aliasStmt.Scope == token.GLOBAL {
+		sb.WriteString(fmt.Sprintf("\t.global %s\n", aliasStmt.Name.Value))
+	}
+	sb.WriteString(fmt.Sprintf("\t.set %s, %s\n", aliasStmt.Name.Value, aliasStmt.Target.Value))
+	return sb.String(), nil
+}
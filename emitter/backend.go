@@ -0,0 +1,96 @@
+package emitter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+)
+
+// Backend renders the statements of a program in an output format. The
+// emitter lowers scripts into their intermediate representation, and hands
+// each top-level statement to the backend in the order of the program.
+type Backend interface {
+	// EmitScript renders a script, with its chunks in the given order.
+	EmitScript(script *ir.Script, chunkIDs []int) (string, error)
+	// EmitMapScripts renders a mapscripts statement. The scripts that are
+	// defined inside of it are rendered with emitScript.
+	EmitMapScripts(stmt *ast.MapScriptsStatement, emitScript func(*ast.ScriptStatement) (string, error)) (string, error)
+	EmitText(text ast.Text) (string, error)
+	EmitMovement(stmt *ast.MovementStatement) (string, error)
+	EmitMart(stmt *ast.MartStatement) (string, error)
+	EmitData(stmt *ast.DataStatement) (string, error)
+	// EmitAlias renders a label that refers to another label.
+	EmitAlias(stmt *ast.AliasStatement) (string, error)
+	EmitRaw(stmt *ast.RawStatement) (string, error)
+	EmitDirective(stmt *ast.DirectiveStatement) (string, error)
+	// EmitAlignment renders the alignment of a statement, which is rendered
+	// before the statement itself. Marts render their own alignment.
+	EmitAlignment(annotations ast.Annotations) string
+}
+
+// ProgramBackend is implemented by backends that need to see the whole
+// program before any of its statements are emitted, like backends whose
+// references to labels look different from other values.
+type ProgramBackend interface {
+	Backend
+	BeginProgram(program *ast.Program)
+	// EndProgram returns the final output, given the output of all of the
+	// program's statements. Backends that need every label to be defined
+	// before they can resolve references to them build their output here.
+	EndProgram(output string) (string, error)
+}
+
+// TailCallBackend is implemented by backends whose scripts can jump to
+// another script in place of calling it right before they return.
+type TailCallBackend interface {
+	Backend
+	// TailCallCommand returns the command that calls another script, like
+	// "call". Returns "" if the calls of the script can't be replaced.
+	TailCallCommand(script *ir.Script) string
+}
+
+// DefaultBackend is the name of the backend that emits assembler bytecode
+// scripts for pokeemerald.
+const DefaultBackend = "pokeemerald"
+
+var backends = map[string]func() Backend{
+	DefaultBackend: func() Backend { return &asmBackend{profile: pokeemeraldProfile} },
+	"pokefirered":  func() Backend { return &asmBackend{profile: pokefireredProfile} },
+	"pokeruby":     func() Backend { return &asmBackend{profile: pokerubyProfile} },
+	"pokecrystal":  func() Backend { return newPokecrystalBackend() },
+	"xse":          func() Backend { return &xseBackend{} },
+	"bin":          func() Backend { return NewBytecodeBackend(DefaultOpcodeTable()) },
+	"c":            func() Backend { return &cBackend{} },
+}
+
+// RegisterBackend makes a backend available by name, so that it can be
+// selected as the compile target. It panics if the name is already taken.
+func RegisterBackend(name string, newBackend func() Backend) {
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("emitter: backend '%s' is already registered", name))
+	}
+	backends[name] = newBackend
+}
+
+// NewBackend creates the backend with the given name.
+func NewBackend(name string) (Backend, error) {
+	newBackend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown target '%s'. Valid targets are: %s", name, strings.Join(BackendNames(), ", "))
+	}
+	return newBackend(), nil
+}
+
+// BackendNames returns the names of the registered backends, in
+// alphabetical order.
+func BackendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -4,183 +4,152 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
 	"github.com/huderlem/poryscript/token"
 )
 
-// Interface that manages chunk branching behavior.
-type brancher interface {
-	renderBranchConditions(sb *strings.Builder, scriptName string, nextChunkID int, registerJumpChunk func(int)) bool
-	getTailChunkID() int
-}
-
-// Helper types for keeping track of script chunk branching logic.
-type conditionDestination struct {
-	id                 int
-	operatorExpression *ast.OperatorExpression
-}
-
-// Represents the initial jump to a loop or comparison chunk.
-type jump struct {
-	destChunkID int
-}
-
-// Satisfies brancher interface.
-func (j *jump) renderBranchConditions(sb *strings.Builder, scriptName string, nextChunkID int, registerJumpChunk func(int)) bool {
-	if j.destChunkID != nextChunkID {
-		registerJumpChunk(j.destChunkID)
-		sb.WriteString(fmt.Sprintf("\tgoto %s_%d\n", scriptName, j.destChunkID))
-		return false
-	}
-	return true
-}
-
-// Satisfies brancher interface.
-func (j *jump) getTailChunkID() int {
-	return j.destChunkID
-}
-
-// Represents a break statement, where it branches to after its loop scope.
-type breakContext struct {
-	destChunkID int
-}
-
-// Satisfies brancher interface.
-func (bc *breakContext) renderBranchConditions(sb *strings.Builder, scriptName string, nextChunkID int, registerJumpChunk func(int)) bool {
-	if bc.destChunkID == -1 {
-		sb.WriteString("\treturn\n")
+// asmProfile is the set of branching macros that a decompilation project
+// defines. Older projects only have the underlying "goto_if" command, which
+// jumps when the result of the last comparison matches its condition code.
+type asmProfile struct {
+	// Vars are compared with "compare", followed by macros like "goto_if_eq".
+	conditionMacros bool
+	// Flags are checked with "goto_if_set" and "goto_if_unset", instead of
+	// "checkflag".
+	flagMacros bool
+	// Trainers are checked with "goto_if_defeated" and
+	// "goto_if_not_defeated", instead of "checktrainerflag".
+	defeatedMacros bool
+	// Switch statements use the "switch" and "case" macros.
+	switchMacros bool
+}
+
+var pokeemeraldProfile = asmProfile{conditionMacros: true, flagMacros: true, switchMacros: true}
+var pokefireredProfile = asmProfile{conditionMacros: true, flagMacros: true, defeatedMacros: true, switchMacros: true}
+var pokerubyProfile = asmProfile{}
+
+// The condition codes of the "goto_if" command.
+var conditionCodes = map[token.Type]int{
+	token.LT:  0,
+	token.EQ:  1,
+	token.GT:  2,
+	token.LTE: 3,
+	token.GTE: 4,
+	token.NEQ: 5,
+}
+
+// The "goto_if" macros of each comparison operator.
+var conditionMacros = map[token.Type]string{
+	token.EQ:  "goto_if_eq",
+	token.NEQ: "goto_if_ne",
+	token.LT:  "goto_if_lt",
+	token.LTE: "goto_if_le",
+	token.GT:  "goto_if_gt",
+	token.GTE: "goto_if_ge",
+}
+
+// Renders the commands that branch from a chunk. Returns true if the chunk
+// falls through to the next chunk, without any commands.
+func (p asmProfile) renderBranch(sb *strings.Builder, branch ir.Branch, script *ir.Script, nextChunkID int, registerJumpChunk func(int)) bool {
+	switch b := branch.(type) {
+	case *ir.Return:
+		if b.End {
+			sb.WriteString("\tend\n")
+		} else {
+			sb.WriteString("\treturn\n")
+		}
 		return false
-	} else if bc.destChunkID != nextChunkID {
-		registerJumpChunk(bc.destChunkID)
-		sb.WriteString(fmt.Sprintf("\tgoto %s_%d\n", scriptName, bc.destChunkID))
+	case *ir.TailCall:
+		sb.WriteString(fmt.Sprintf("\tgoto %s\n", b.Label))
 		return false
+	case *ir.Goto:
+		return renderGoto(sb, b.Dest, script, nextChunkID, registerJumpChunk)
+	case *ir.Condition:
+		registerJumpChunk(b.Dest)
+		p.renderComparison(sb, b.Comparison, script.ChunkLabel(b.Dest))
+		return renderGoto(sb, b.Else, script, nextChunkID, registerJumpChunk)
+	case *ir.Switch:
+		if p.switchMacros {
+			sb.WriteString(fmt.Sprintf("\tswitch %s\n", b.Operand))
+		} else {
+			sb.WriteString(fmt.Sprintf("\tcopyvar %s, %s\n", switchVar, b.Operand))
+		}
+		for _, switchCase := range b.Cases {
+			registerJumpChunk(switchCase.Dest)
+			dest := script.ChunkLabel(switchCase.Dest)
+			if p.switchMacros {
+				sb.WriteString(fmt.Sprintf("\tcase %s, %s\n", switchCase.Value, dest))
+			} else {
+				p.renderVarComparison(sb, ir.Comparison{Type: token.VAR, Operand: switchVar, Operator: token.EQ, Value: switchCase.Value}, dest)
+			}
+		}
+		return renderGoto(sb, b.Default, script, nextChunkID, registerJumpChunk)
 	}
-	return true
-}
-
-// Satisfies brancher interface.
-func (bc *breakContext) getTailChunkID() int {
-	return bc.destChunkID
-}
-
-// Represents a leaf expression of a compound boolean expression.
-type leafExpressionBranch struct {
-	truthyDest     *conditionDestination
-	falseyReturnID int
+	return false
 }
 
-// Satisfies brancher interface.
-func (l *leafExpressionBranch) renderBranchConditions(sb *strings.Builder, scriptName string, nextChunkID int, registerJumpChunk func(int)) bool {
-	registerJumpChunk(l.truthyDest.id)
-	renderBranchComparison(sb, l.truthyDest, scriptName)
-	if l.falseyReturnID == -1 {
+// Renders an unconditional jump to a chunk, unless it is the next chunk.
+// A destination of -1 returns from the script instead.
+func renderGoto(sb *strings.Builder, destChunkID int, script *ir.Script, nextChunkID int, registerJumpChunk func(int)) bool {
+	if destChunkID == -1 {
 		sb.WriteString("\treturn\n")
 		return false
-	} else if l.falseyReturnID != nextChunkID {
-		registerJumpChunk(l.falseyReturnID)
-		sb.WriteString(fmt.Sprintf("\tgoto %s_%d\n", scriptName, l.falseyReturnID))
+	} else if destChunkID != nextChunkID {
+		registerJumpChunk(destChunkID)
+		sb.WriteString(fmt.Sprintf("\tgoto %s\n", script.ChunkLabel(destChunkID)))
 		return false
 	}
 	return true
 }
 
-// Satisfies brancher interface.
-func (l *leafExpressionBranch) getTailChunkID() int {
-	return l.falseyReturnID
-}
-
-type switchCaseBranch struct {
-	comparisonValue string
-	destChunkID     int
-}
-
-// Represents the a switch statement branch behavior.
-type switchBranch struct {
-	operand     string
-	cases       []*switchCaseBranch
-	defaultCase *switchCaseBranch
-	destChunkID int
-}
-
-// Satisfies brancher interface.
-func (s *switchBranch) renderBranchConditions(sb *strings.Builder, scriptName string, nextChunkID int, registerJumpChunk func(int)) bool {
-	sb.WriteString(fmt.Sprintf("\tswitch %s\n", s.operand))
-	for _, switchCase := range s.cases {
-		registerJumpChunk(switchCase.destChunkID)
-		sb.WriteString(fmt.Sprintf("\tcase %s, %s_%d\n", switchCase.comparisonValue, scriptName, switchCase.destChunkID))
-	}
-
-	if s.defaultCase != nil {
-		if s.defaultCase.destChunkID != nextChunkID {
-			registerJumpChunk(s.defaultCase.destChunkID)
-			sb.WriteString(fmt.Sprintf("\tgoto %s_%d\n", scriptName, s.defaultCase.destChunkID))
-			return false
-		}
-	} else if s.destChunkID != nextChunkID {
-		if s.destChunkID == -1 {
-			sb.WriteString("\treturn\n")
-		} else {
-			registerJumpChunk(s.destChunkID)
-			sb.WriteString(fmt.Sprintf("\tgoto %s_%d\n", scriptName, s.destChunkID))
-		}
-		return false
-	}
-
-	return true
-}
-
-// Satisfies brancher interface.
-func (s *switchBranch) getTailChunkID() int {
-	if s.defaultCase != nil {
-		return s.defaultCase.destChunkID
-	}
-	return s.destChunkID
-}
-
-func renderBranchComparison(sb *strings.Builder, dest *conditionDestination, scriptName string) {
-	switch dest.operatorExpression.Type {
+func (p asmProfile) renderComparison(sb *strings.Builder, comparison ir.Comparison, dest string) {
+	switch comparison.Type {
 	case token.FLAG:
-		renderFlagComparison(sb, dest, scriptName)
+		p.renderFlagComparison(sb, comparison, dest)
 	case token.VAR:
-		renderVarComparison(sb, dest, scriptName)
+		p.renderVarComparison(sb, comparison, dest)
 	case token.DEFEATED:
-		renderDefeatedComparison(sb, dest, scriptName)
+		p.renderDefeatedComparison(sb, comparison, dest)
+	}
+}
+
+func (p asmProfile) renderFlagComparison(sb *strings.Builder, comparison ir.Comparison, dest string) {
+	if !p.flagMacros {
+		sb.WriteString(fmt.Sprintf("\tcheckflag %s\n", comparison.Operand))
+		renderBooleanGotoIf(sb, comparison.IsTrue(), dest)
+	} else if comparison.IsTrue() {
+		sb.WriteString(fmt.Sprintf("\tgoto_if_set %s, %s\n", comparison.Operand, dest))
+	} else {
+		sb.WriteString(fmt.Sprintf("\tgoto_if_unset %s, %s\n", comparison.Operand, dest))
 	}
 }
 
-func renderFlagComparison(sb *strings.Builder, dest *conditionDestination, scriptName string) {
-	if (dest.operatorExpression.Operator == token.EQ && dest.operatorExpression.ComparisonValue == token.TRUE) ||
-		(dest.operatorExpression.Operator == token.NEQ && dest.operatorExpression.ComparisonValue == token.FALSE) {
-		sb.WriteString(fmt.Sprintf("\tgoto_if_set %s, %s_%d\n", dest.operatorExpression.Operand, scriptName, dest.id))
+func (p asmProfile) renderVarComparison(sb *strings.Builder, comparison ir.Comparison, dest string) {
+	sb.WriteString(fmt.Sprintf("\tcompare %s, %s\n", comparison.Operand, comparison.Value))
+	if p.conditionMacros {
+		sb.WriteString(fmt.Sprintf("\t%s %s\n", conditionMacros[comparison.Operator], dest))
 	} else {
-		sb.WriteString(fmt.Sprintf("\tgoto_if_unset %s, %s_%d\n", dest.operatorExpression.Operand, scriptName, dest.id))
+		sb.WriteString(fmt.Sprintf("\tgoto_if %d, %s\n", conditionCodes[comparison.Operator], dest))
 	}
 }
 
-func renderVarComparison(sb *strings.Builder, dest *conditionDestination, scriptName string) {
-	sb.WriteString(fmt.Sprintf("\tcompare %s, %s\n", dest.operatorExpression.Operand, dest.operatorExpression.ComparisonValue))
-	switch dest.operatorExpression.Operator {
-	case token.EQ:
-		sb.WriteString(fmt.Sprintf("\tgoto_if_eq %s_%d\n", scriptName, dest.id))
-	case token.NEQ:
-		sb.WriteString(fmt.Sprintf("\tgoto_if_ne %s_%d\n", scriptName, dest.id))
-	case token.LT:
-		sb.WriteString(fmt.Sprintf("\tgoto_if_lt %s_%d\n", scriptName, dest.id))
-	case token.LTE:
-		sb.WriteString(fmt.Sprintf("\tgoto_if_le %s_%d\n", scriptName, dest.id))
-	case token.GT:
-		sb.WriteString(fmt.Sprintf("\tgoto_if_gt %s_%d\n", scriptName, dest.id))
-	case token.GTE:
-		sb.WriteString(fmt.Sprintf("\tgoto_if_ge %s_%d\n", scriptName, dest.id))
+func (p asmProfile) renderDefeatedComparison(sb *strings.Builder, comparison ir.Comparison, dest string) {
+	if !p.defeatedMacros {
+		sb.WriteString(fmt.Sprintf("\tchecktrainerflag %s\n", comparison.Operand))
+		renderBooleanGotoIf(sb, comparison.IsTrue(), dest)
+	} else if comparison.IsTrue() {
+		sb.WriteString(fmt.Sprintf("\tgoto_if_defeated %s, %s\n", comparison.Operand, dest))
+	} else {
+		sb.WriteString(fmt.Sprintf("\tgoto_if_not_defeated %s, %s\n", comparison.Operand, dest))
 	}
 }
 
-func renderDefeatedComparison(sb *strings.Builder, dest *conditionDestination, scriptName string) {
-	sb.WriteString(fmt.Sprintf("\tchecktrainerflag %s\n", dest.operatorExpression.Operand))
-	if (dest.operatorExpression.Operator == token.EQ && dest.operatorExpression.ComparisonValue == token.TRUE) ||
-		(dest.operatorExpression.Operator == token.NEQ && dest.operatorExpression.ComparisonValue == token.FALSE) {
-		sb.WriteString(fmt.Sprintf("\tgoto_if 1, %s_%d\n", scriptName, dest.id))
+// Renders a jump that depends on the result of "checkflag" or
+// "checktrainerflag", which compare as equal to 1 when the flag is set.
+func renderBooleanGotoIf(sb *strings.Builder, isSet bool, dest string) {
+	if isSet {
+		sb.WriteString(fmt.Sprintf("\tgoto_if 1, %s\n", dest))
 	} else {
-		sb.WriteString(fmt.Sprintf("\tgoto_if 0, %s_%d\n", scriptName, dest.id))
+		sb.WriteString(fmt.Sprintf("\tgoto_if 0, %s\n", dest))
 	}
 }
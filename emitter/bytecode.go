@@ -0,0 +1,587 @@
+package emitter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+	"github.com/huderlem/poryscript/token"
+)
+
+// OpcodeTable describes how the bin target assembles scripts into Gen 3
+// bytecode.
+type OpcodeTable struct {
+	// The ROM address that the output is inserted at, like "0x08800000".
+	// Pointers to labels are relative to it.
+	BaseAddress string `json:"baseAddress"`
+	// The opcode and argument sizes of each command.
+	Commands map[string]Opcode `json:"commands"`
+	// The values of constants, like flags, vars, and movement commands.
+	Constants map[string]int64 `json:"constants"`
+	// The bytes of each character or control code in text.
+	Charmap map[string][]int `json:"charmap"`
+}
+
+// Opcode is a single command in an OpcodeTable.
+type Opcode struct {
+	Opcode int `json:"opcode"`
+	// The size of each argument in bytes, which is 1, 2, or 4. Pointers are
+	// 4 bytes.
+	Args []int `json:"args"`
+}
+
+// DefaultOpcodeTable returns the opcodes of the commands that are shared by
+// the Gen 3 games, including every command that branches use.
+func DefaultOpcodeTable() OpcodeTable {
+	table := OpcodeTable{
+		BaseAddress: "0x08800000",
+		Commands: map[string]Opcode{
+			"nop":                {Opcode: 0x00},
+			"end":                {Opcode: 0x02},
+			"return":             {Opcode: 0x03},
+			"call":               {Opcode: 0x04, Args: []int{4}},
+			"goto":               {Opcode: 0x05, Args: []int{4}},
+			"goto_if":            {Opcode: 0x06, Args: []int{1, 4}},
+			"call_if":            {Opcode: 0x07, Args: []int{1, 4}},
+			"gotostd":            {Opcode: 0x08, Args: []int{1}},
+			"callstd":            {Opcode: 0x09, Args: []int{1}},
+			"loadword":           {Opcode: 0x0F, Args: []int{1, 4}},
+			"setvar":             {Opcode: 0x16, Args: []int{2, 2}},
+			"addvar":             {Opcode: 0x17, Args: []int{2, 2}},
+			"subvar":             {Opcode: 0x18, Args: []int{2, 2}},
+			"copyvar":            {Opcode: 0x19, Args: []int{2, 2}},
+			"compare":            {Opcode: 0x21, Args: []int{2, 2}},
+			"compare_var_to_var": {Opcode: 0x22, Args: []int{2, 2}},
+			"special":            {Opcode: 0x25, Args: []int{2}},
+			"waitstate":          {Opcode: 0x27},
+			"delay":              {Opcode: 0x28, Args: []int{2}},
+			"setflag":            {Opcode: 0x29, Args: []int{2}},
+			"clearflag":          {Opcode: 0x2A, Args: []int{2}},
+			"checkflag":          {Opcode: 0x2B, Args: []int{2}},
+			"playse":             {Opcode: 0x2F, Args: []int{2}},
+			"applymovement":      {Opcode: 0x4F, Args: []int{2, 4}},
+			"waitmovement":       {Opcode: 0x51, Args: []int{2}},
+			"faceplayer":         {Opcode: 0x5A},
+			"checktrainerflag":   {Opcode: 0x60, Args: []int{2}},
+			"waitmessage":        {Opcode: 0x66},
+			"message":            {Opcode: 0x67, Args: []int{4}},
+			"closemessage":       {Opcode: 0x68},
+			"lockall":            {Opcode: 0x69},
+			"lock":               {Opcode: 0x6A},
+			"releaseall":         {Opcode: 0x6B},
+			"release":            {Opcode: 0x6C},
+			"waitbuttonpress":    {Opcode: 0x6D},
+		},
+		Constants: map[string]int64{
+			"MAP_SCRIPT_ON_LOAD":                1,
+			"MAP_SCRIPT_ON_FRAME_TABLE":         2,
+			"MAP_SCRIPT_ON_TRANSITION":          3,
+			"MAP_SCRIPT_ON_WARP_INTO_MAP_TABLE": 4,
+			"MAP_SCRIPT_ON_RESUME":              5,
+			"MAP_SCRIPT_ON_DIVE_WARP":           6,
+			"MAP_SCRIPT_ON_RETURN_TO_FIELD":     7,
+			"ITEM_NONE":                         0,
+			"step_end":                          0xFE,
+		},
+		Charmap: map[string][]int{
+			" ": {0x00}, "é": {0x1B}, "&": {0x2D}, "+": {0x2E}, "=": {0x35}, ";": {0x36},
+			"%": {0x5B}, "(": {0x5C}, ")": {0x5D}, "!": {0xAB}, "?": {0xAC}, ".": {0xAD},
+			"-": {0xAE}, "…": {0xB0}, "“": {0xB1}, "”": {0xB2}, "‘": {0xB3}, "’": {0xB4},
+			"'": {0xB4}, "♂": {0xB5}, "♀": {0xB6}, ",": {0xB8}, "×": {0xB9}, "/": {0xBA},
+			":": {0xF0}, `\l`: {0xFA}, `\p`: {0xFB}, `\n`: {0xFE}, "$": {0xFF},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		table.Charmap[string(rune('0'+i))] = []int{0xA1 + i}
+	}
+	for i := 0; i < 26; i++ {
+		table.Charmap[string(rune('A'+i))] = []int{0xBB + i}
+		table.Charmap[string(rune('a'+i))] = []int{0xD5 + i}
+	}
+	return table
+}
+
+// LoadOpcodeTable reads an opcode table from a JSON file. Its entries are
+// added to the default opcode table, and replace the default entries with
+// the same names.
+func LoadOpcodeTable(filepath string) (OpcodeTable, error) {
+	table := DefaultOpcodeTable()
+	bytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return table, err
+	}
+	var config OpcodeTable
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return table, err
+	}
+	if config.BaseAddress != "" {
+		table.BaseAddress = config.BaseAddress
+	}
+	// The commands are validated in order, so that the same error is
+	// reported every time.
+	names := make([]string, 0, len(config.Commands))
+	for name := range config.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opcode := config.Commands[name]
+		for _, size := range opcode.Args {
+			if size != 1 && size != 2 && size != 4 {
+				return table, fmt.Errorf("invalid argument size %d of command '%s'. Sizes must be 1, 2, or 4", size, name)
+			}
+		}
+		table.Commands[name] = opcode
+	}
+	for name, value := range config.Constants {
+		table.Constants[name] = value
+	}
+	for code, bytes := range config.Charmap {
+		table.Charmap[code] = bytes
+	}
+	return table, nil
+}
+
+// bytecodeBackend assembles scripts straight into Gen 3 bytecode, without
+// the decompilation projects' assembler. The output is built in EndProgram,
+// once the offsets of all of the labels are known.
+type bytecodeBackend struct {
+	table       OpcodeTable
+	charmapKeys []string
+	data        []byte
+	labels      map[string]int
+	fixups      []bytecodeFixup
+	// The labels that aliases refer to, which are resolved once all of the
+	// labels are defined.
+	aliases map[string]string
+}
+
+// A pointer to a label, which is written once all of the labels are defined.
+type bytecodeFixup struct {
+	offset int
+	label  string
+}
+
+// NewBytecodeBackend creates a backend that assembles scripts into bytecode
+// with the given opcode table.
+func NewBytecodeBackend(table OpcodeTable) Backend {
+	b := &bytecodeBackend{table: table, labels: make(map[string]int), aliases: make(map[string]string)}
+	for code := range table.Charmap {
+		b.charmapKeys = append(b.charmapKeys, code)
+	}
+	// Longer codes are matched first, so that "{PLAYER}" isn't read as "{".
+	sort.Slice(b.charmapKeys, func(i, j int) bool {
+		if len(b.charmapKeys[i]) != len(b.charmapKeys[j]) {
+			return len(b.charmapKeys[i]) > len(b.charmapKeys[j])
+		}
+		return b.charmapKeys[i] < b.charmapKeys[j]
+	})
+	return b
+}
+
+// BeginProgram satisfies the ProgramBackend interface.
+func (b *bytecodeBackend) BeginProgram(program *ast.Program) {
+	b.data = nil
+	b.labels = make(map[string]int)
+	b.fixups = nil
+	b.aliases = make(map[string]string)
+}
+
+// EndProgram satisfies the ProgramBackend interface. It ignores the output
+// of the statements, and returns the assembled bytecode.
+func (b *bytecodeBackend) EndProgram(output string) (string, error) {
+	baseAddress, err := strconv.ParseInt(b.table.BaseAddress, 0, 64)
+	if err != nil {
+		return "", emitErrorf("invalid base address '%s'", b.table.BaseAddress)
+	}
+	if err := b.resolveAliases(); err != nil {
+		return "", err
+	}
+	for _, fixup := range b.fixups {
+		offset, ok := b.labels[fixup.label]
+		if !ok {
+			return "", emitErrorf("undefined label '%s'. Labels in other files can be given an address in the opcode table's constants", fixup.label)
+		}
+		binary.LittleEndian.PutUint32(b.data[fixup.offset:], uint32(baseAddress+int64(offset)))
+	}
+	return string(b.data), nil
+}
+
+// Defines the aliases at the offsets of their labels. An alias can refer
+// to another alias.
+func (b *bytecodeBackend) resolveAliases() error {
+	for alias := range b.aliases {
+		target := alias
+		for i := 0; i <= len(b.aliases); i++ {
+			next, ok := b.aliases[target]
+			if !ok {
+				break
+			}
+			target = next
+		}
+		offset, ok := b.labels[target]
+		if !ok {
+			return emitErrorf("undefined label '%s' of alias '%s'", b.aliases[alias], alias)
+		}
+		b.labels[alias] = offset
+	}
+	return nil
+}
+
+func (b *bytecodeBackend) checkDuplicateLabel(name string) error {
+	_, isLabel := b.labels[name]
+	_, isAlias := b.aliases[name]
+	if isLabel || isAlias {
+		return emitErrorf("duplicate label '%s'", name)
+	}
+	return nil
+}
+
+func (b *bytecodeBackend) defineLabel(name string) error {
+	if err := b.checkDuplicateLabel(name); err != nil {
+		return err
+	}
+	b.labels[name] = len(b.data)
+	return nil
+}
+
+// Writes a value of the given size, in bytes. A value that isn't a number
+// or a constant is a pointer to a label, if the size is 4.
+func (b *bytecodeBackend) writeValue(value string, size int) error {
+	v, ok := b.resolveValue(value)
+	if !ok {
+		if size != 4 {
+			return emitErrorf("unknown value '%s'. Add it to the opcode table's constants", value)
+		}
+		b.fixups = append(b.fixups, bytecodeFixup{offset: len(b.data), label: value})
+	}
+	switch size {
+	case 1:
+		b.data = append(b.data, byte(v))
+	case 2:
+		b.data = append(b.data, byte(v), byte(v>>8))
+	case 4:
+		b.data = append(b.data, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	}
+	return nil
+}
+
+// Returns the numeric value of a number or a constant. Constants that end
+// with their value, like VAR_0x8000, don't need to be in the opcode table.
+func (b *bytecodeBackend) resolveValue(value string) (int64, bool) {
+	if v, ok := parseConstantValue(value); ok {
+		return v, true
+	}
+	if v, ok := b.table.Constants[value]; ok {
+		return v, true
+	}
+	if i := strings.LastIndex(value, "_0x"); i != -1 {
+		if v, err := strconv.ParseInt(value[i+1:], 0, 64); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func (b *bytecodeBackend) writeCommand(name string, args ...string) error {
+	opcode, ok := b.table.Commands[name]
+	if !ok {
+		return emitErrorf("command '%s' isn't in the opcode table", name)
+	}
+	if len(args) != len(opcode.Args) {
+		return emitErrorf("command '%s' takes %d arguments, but got %d", name, len(opcode.Args), len(args))
+	}
+	b.data = append(b.data, byte(opcode.Opcode))
+	for i, arg := range args {
+		if err := b.writeValue(arg, opcode.Args[i]); err != nil {
+			return emitErrorf("command '%s': %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// TailCallCommand satisfies the TailCallBackend interface.
+func (b *bytecodeBackend) TailCallCommand(script *ir.Script) string {
+	return "call"
+}
+
+// EmitScript satisfies the Backend interface.
+func (b *bytecodeBackend) EmitScript(script *ir.Script, chunkIDs []int) (string, error) {
+	label := script.ChunkLabel
+	for i, chunkID := range chunkIDs {
+		nextChunkID := -1
+		if i < len(chunkIDs)-1 {
+			nextChunkID = chunkIDs[i+1]
+		}
+		if err := b.defineLabel(label(chunkID)); err != nil {
+			return "", err
+		}
+		chunk := script.Chunks[chunkID]
+		for _, command := range chunk.Commands {
+			if err := b.writeCommand(command.Name, command.Args...); err != nil {
+				return "", emitErrorf("script '%s': %s", script.Name, err.Error())
+			}
+		}
+		if err := b.writeBranch(chunk.Branch, label, nextChunkID); err != nil {
+			return "", emitErrorf("script '%s': %s", script.Name, err.Error())
+		}
+	}
+	return "", nil
+}
+
+func (b *bytecodeBackend) writeBranch(branch ir.Branch, label func(int) string, nextChunkID int) error {
+	switch br := branch.(type) {
+	case *ir.Return:
+		if br.End {
+			return b.writeCommand("end")
+		}
+		return b.writeCommand("return")
+	case *ir.TailCall:
+		return b.writeCommand("goto", br.Label)
+	case *ir.Goto:
+		return b.writeGoto(br.Dest, label, nextChunkID)
+	case *ir.Condition:
+		if err := b.writeComparison(br.Comparison, label(br.Dest)); err != nil {
+			return err
+		}
+		return b.writeGoto(br.Else, label, nextChunkID)
+	case *ir.Switch:
+		if err := b.writeCommand("copyvar", switchVar, br.Operand); err != nil {
+			return err
+		}
+		for _, switchCase := range br.Cases {
+			comparison := ir.Comparison{Type: token.VAR, Operand: switchVar, Operator: token.EQ, Value: switchCase.Value}
+			if err := b.writeComparison(comparison, label(switchCase.Dest)); err != nil {
+				return err
+			}
+		}
+		return b.writeGoto(br.Default, label, nextChunkID)
+	}
+	return nil
+}
+
+// Writes an unconditional jump to a chunk, unless it is the next chunk. A
+// destination of -1 returns from the script instead.
+func (b *bytecodeBackend) writeGoto(destChunkID int, label func(int) string, nextChunkID int) error {
+	if destChunkID == -1 {
+		return b.writeCommand("return")
+	} else if destChunkID != nextChunkID {
+		return b.writeCommand("goto", label(destChunkID))
+	}
+	return nil
+}
+
+// Writes a comparison, followed by a "goto_if" to dest. Like the "compare"
+// macro, values that are vars are compared with "compare_var_to_var".
+func (b *bytecodeBackend) writeComparison(comparison ir.Comparison, dest string) error {
+	var err error
+	condition := conditionCodes[token.EQ]
+	switch comparison.Type {
+	case token.FLAG:
+		err = b.writeCommand("checkflag", comparison.Operand)
+		if !comparison.IsTrue() {
+			condition = conditionCodes[token.LT]
+		}
+	case token.VAR:
+		command := "compare"
+		if value, ok := b.resolveValue(comparison.Value); ok && value >= varsStart {
+			command = "compare_var_to_var"
+		}
+		err = b.writeCommand(command, comparison.Operand, comparison.Value)
+		condition = conditionCodes[comparison.Operator]
+	case token.DEFEATED:
+		err = b.writeCommand("checktrainerflag", comparison.Operand)
+		if !comparison.IsTrue() {
+			condition = conditionCodes[token.LT]
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return b.writeCommand("goto_if", strconv.Itoa(condition), dest)
+}
+
+// EmitMapScripts satisfies the Backend interface.
+func (b *bytecodeBackend) EmitMapScripts(mapScriptStmt *ast.MapScriptsStatement, emitScript func(*ast.ScriptStatement) (string, error)) (string, error) {
+	if err := b.defineLabel(mapScriptStmt.Name.Value); err != nil {
+		return "", err
+	}
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		if err := b.writeMapScriptHeader(mapScript.Type, mapScript.Name); err != nil {
+			return "", err
+		}
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		if err := b.writeMapScriptHeader(tableMapScript.Type, tableMapScript.Name); err != nil {
+			return "", err
+		}
+	}
+	b.data = append(b.data, 0)
+
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		if mapScript.Script != nil {
+			if _, err := emitScript(mapScript.Script); err != nil {
+				return "", err
+			}
+		}
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		if err := b.defineLabel(tableMapScript.Name); err != nil {
+			return "", err
+		}
+		for _, scriptEntry := range tableMapScript.Entries {
+			for _, value := range []string{scriptEntry.Condition, scriptEntry.Comparison, scriptEntry.Name} {
+				size := 2
+				if value == scriptEntry.Name {
+					size = 4
+				}
+				if err := b.writeValue(value, size); err != nil {
+					return "", emitErrorf("mapscripts '%s': %s", mapScriptStmt.Name.Value, err.Error())
+				}
+			}
+		}
+		b.data = append(b.data, 0, 0)
+		for _, scriptEntry := range tableMapScript.Entries {
+			if scriptEntry.Script != nil {
+				if _, err := emitScript(scriptEntry.Script); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func (b *bytecodeBackend) writeMapScriptHeader(scriptType string, name string) error {
+	if err := b.writeValue(scriptType, 1); err != nil {
+		return emitErrorf("map script '%s': %s", name, err.Error())
+	}
+	return b.writeValue(name, 4)
+}
+
+// EmitAlignment satisfies the Backend interface. Like ".align", the data is
+// padded to a multiple of 2 to the power of the alignment.
+func (b *bytecodeBackend) EmitAlignment(annotations ast.Annotations) string {
+	align, ok := annotations.Get("align")
+	if !ok {
+		return ""
+	}
+	if n, err := strconv.Atoi(align.Args[0]); err == nil {
+		b.align(1 << uint(n))
+	}
+	return ""
+}
+
+func (b *bytecodeBackend) align(size int) {
+	for len(b.data)%size != 0 {
+		b.data = append(b.data, 0)
+	}
+}
+
+// EmitText satisfies the Backend interface. The text is encoded with the
+// opcode table's charmap.
+func (b *bytecodeBackend) EmitText(text ast.Text) (string, error) {
+	if err := b.defineLabel(text.Name); err != nil {
+		return "", err
+	}
+	value := strings.Replace(text.Value, "\n", "", -1)
+	for len(value) > 0 {
+		found := false
+		for _, code := range b.charmapKeys {
+			if strings.HasPrefix(value, code) {
+				for _, c := range b.table.Charmap[code] {
+					b.data = append(b.data, byte(c))
+				}
+				value = value[len(code):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", emitErrorf("text '%s': '%s' isn't in the charmap", text.Name, strings.SplitN(value, "", 2)[0])
+		}
+	}
+	return "", nil
+}
+
+// EmitRaw satisfies the Backend interface. Raw statements are assembly,
+// which can't be assembled without an assembler.
+func (b *bytecodeBackend) EmitRaw(rawStmt *ast.RawStatement) (string, error) {
+	return "", emitErrorf("raw statements are not supported by the bin target")
+}
+
+// EmitDirective satisfies the Backend interface.
+func (b *bytecodeBackend) EmitDirective(directiveStmt *ast.DirectiveStatement) (string, error) {
+	return "", emitErrorf("directives are not supported by the bin target")
+}
+
+// EmitMovement satisfies the Backend interface.
+func (b *bytecodeBackend) EmitMovement(movementStmt *ast.MovementStatement) (string, error) {
+	terminator := "step_end"
+	if err := b.defineLabel(movementStmt.Name.Value); err != nil {
+		return "", err
+	}
+	commands := append(append([]string{}, movementStmt.MovementCommands...), terminator)
+	for _, cmd := range commands {
+		if err := b.writeValue(cmd, 1); err != nil {
+			return "", emitErrorf("movement '%s': %s", movementStmt.Name.Value, err.Error())
+		}
+		if cmd == terminator {
+			break
+		}
+	}
+	return "", nil
+}
+
+// EmitMart satisfies the Backend interface.
+func (b *bytecodeBackend) EmitMart(martStmt *ast.MartStatement) (string, error) {
+	terminator := "ITEM_NONE"
+	if martStmt.Annotations.Has("align") {
+		b.EmitAlignment(martStmt.Annotations)
+	} else {
+		b.align(4)
+	}
+	if err := b.defineLabel(martStmt.Name.Value); err != nil {
+		return "", err
+	}
+	for _, item := range append(append([]string{}, martStmt.MartItems...), terminator) {
+		if err := b.writeValue(item, 2); err != nil {
+			return "", emitErrorf("mart '%s': %s", martStmt.Name.Value, err.Error())
+		}
+		if item == terminator {
+			break
+		}
+	}
+	return "", nil
+}
+
+// EmitData satisfies the Backend interface.
+func (b *bytecodeBackend) EmitData(dataStmt *ast.DataStatement) (string, error) {
+	if err := b.defineLabel(dataStmt.Name.Value); err != nil {
+		return "", err
+	}
+	for _, row := range dataStmt.Rows {
+		for _, value := range row.Values {
+			if err := b.writeValue(value, row.Size); err != nil {
+				return "", emitErrorf("data '%s': %s", dataStmt.Name.Value, err.Error())
+			}
+		}
+	}
+	return "", nil
+}
+
+// EmitAlias satisfies the Backend interface. The alias is defined at the
+// offset of its label once all of the labels are defined.
+func (b *bytecodeBackend) EmitAlias(aliasStmt *ast.AliasStatement) (string, error) {
+	if err := b.checkDuplicateLabel(aliasStmt.Name.Value); err != nil {
+		return "", err
+	}
+	b.aliases[aliasStmt.Name.Value] = aliasStmt.Target.Value
+	return "", nil
+}
@@ -0,0 +1,295 @@
+package emitter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+	"github.com/huderlem/poryscript/token"
+)
+
+// cBackend emits C source, for projects that build their event scripts in C
+// translation units. Every label is a const array, and every command is a
+// macro invocation, like "SCRIPT_setflag(FLAG_1)", which the project defines
+// to expand into the command's bytes. Arrays can't fall through into each
+// other, so each group of chunks is its own array.
+type cBackend struct {
+	declarations []cDeclaration
+	// The alignment of the next array, from its @align annotation.
+	alignment string
+}
+
+type cDeclaration struct {
+	name     string
+	cType    string
+	isGlobal bool
+}
+
+// BeginProgram satisfies the ProgramBackend interface.
+func (b *cBackend) BeginProgram(program *ast.Program) {
+	b.declarations = nil
+	b.alignment = ""
+}
+
+// EndProgram satisfies the ProgramBackend interface. C can't refer to an
+// array before it is declared, so every array is declared before the output.
+// The global arrays are declared first, so that they can be copied into a
+// header.
+func (b *cBackend) EndProgram(output string) (string, error) {
+	var sb strings.Builder
+	for _, isGlobal := range []bool{true, false} {
+		written := false
+		for _, declaration := range b.declarations {
+			if declaration.isGlobal == isGlobal {
+				sb.WriteString(fmt.Sprintf("extern const %s %s[];\n", declaration.cType, declaration.name))
+				written = true
+			}
+		}
+		if written {
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString(output)
+	return sb.String(), nil
+}
+
+// Renders the start of an array's definition, and declares it.
+func (b *cBackend) renderArrayStart(name string, cType string, isGlobal bool) string {
+	return fmt.Sprintf("const %s %s[]%s = {\n", cType, name, b.declare(name, cType, isGlobal))
+}
+
+// Declares an array, and returns the attributes of its definition.
+func (b *cBackend) declare(name string, cType string, isGlobal bool) string {
+	b.declarations = append(b.declarations, cDeclaration{name: name, cType: cType, isGlobal: isGlobal})
+	if b.alignment == "" {
+		return ""
+	}
+	attributes := fmt.Sprintf(" __attribute__((aligned(%s)))", b.alignment)
+	b.alignment = ""
+	return attributes
+}
+
+// Converts assembler lines, like "\tcompare VAR_1, 2\n", into the macro
+// invocations of the commands.
+func renderCMacros(asm string) string {
+	var sb strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(asm, "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		args := ""
+		if len(parts) > 1 {
+			args = parts[1]
+		}
+		sb.WriteString(fmt.Sprintf("\tSCRIPT_%s(%s),\n", parts[0], args))
+	}
+	return sb.String()
+}
+
+// TailCallCommand satisfies the TailCallBackend interface.
+func (b *cBackend) TailCallCommand(script *ir.Script) string {
+	return "call"
+}
+
+// EmitScript satisfies the Backend interface.
+func (b *cBackend) EmitScript(script *ir.Script, chunkIDs []int) (string, error) {
+	var sb strings.Builder
+	for i, group := range ir.GroupChunks(script, chunkIDs) {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(b.renderArrayStart(script.ChunkLabel(group[0]), "u8", group[0] == 0 && script.IsGlobal))
+		for j, chunkID := range group {
+			chunk := script.Chunks[chunkID]
+			renderSourceComment(&sb, chunk, "\t//")
+			var asm strings.Builder
+			for _, command := range chunk.Commands {
+				asm.WriteString(renderCommand(command))
+			}
+			pokeemeraldProfile.renderBranch(&asm, chunk.Branch, script, getNextGroupChunkID(group, j), func(int) {})
+			sb.WriteString(renderCMacros(asm.String()))
+		}
+		sb.WriteString("};\n")
+	}
+	return sb.String(), nil
+}
+
+// EmitMapScripts satisfies the Backend interface.
+func (b *cBackend) EmitMapScripts(mapScriptStmt *ast.MapScriptsStatement, emitScript func(*ast.ScriptStatement) (string, error)) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(b.renderArrayStart(mapScriptStmt.Name.Value, "u8", mapScriptStmt.Scope == token.GLOBAL))
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		sb.WriteString(fmt.Sprintf("\tSCRIPT_map_script(%s, %s),\n", mapScript.Type, mapScript.Name))
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		sb.WriteString(fmt.Sprintf("\tSCRIPT_map_script(%s, %s),\n", tableMapScript.Type, tableMapScript.Name))
+	}
+	sb.WriteString("\t0,\n};\n\n")
+
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		if mapScript.Script != nil {
+			scriptOutput, err := emitScript(mapScript.Script)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(scriptOutput)
+			sb.WriteString("\n")
+		}
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		sb.WriteString(b.renderArrayStart(tableMapScript.Name, "u8", false))
+		for _, scriptEntry := range tableMapScript.Entries {
+			sb.WriteString(fmt.Sprintf("\tSCRIPT_map_script_2(%s, %s, %s),\n", scriptEntry.Condition, scriptEntry.Comparison, scriptEntry.Name))
+		}
+		sb.WriteString("\t0, 0,\n};\n\n")
+		for _, scriptEntry := range tableMapScript.Entries {
+			if scriptEntry.Script != nil {
+				scriptOutput, err := emitScript(scriptEntry.Script)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(scriptOutput)
+				sb.WriteString("\n")
+			}
+		}
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+// EmitAlignment satisfies the Backend interface. The alignment is an
+// attribute of the next array, so nothing is rendered before it.
+func (b *cBackend) EmitAlignment(annotations ast.Annotations) string {
+	align, ok := annotations.Get("align")
+	if !ok {
+		return ""
+	}
+	// Like ".align", the alignment is a power of 2.
+	if n, err := strconv.Atoi(align.Args[0]); err == nil {
+		b.alignment = strconv.Itoa(1 << uint(n))
+	} else {
+		b.alignment = fmt.Sprintf("1 << (%s)", align.Args[0])
+	}
+	return ""
+}
+
+// EmitText satisfies the Backend interface. The text is a string literal in
+// the "_()" macro, which encodes it and adds the terminator.
+func (b *cBackend) EmitText(text ast.Text) (string, error) {
+	attributes := b.declare(text.Name, "u8", text.IsGlobal)
+	lines := strings.Split(strings.TrimSuffix(text.Value, "$"), "\n")
+	if len(lines) == 1 {
+		return fmt.Sprintf("const u8 %s[]%s = _(\"%s\");\n", text.Name, attributes, lines[0]), nil
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("const u8 %s[]%s = _(\n", text.Name, attributes))
+	for i, line := range lines {
+		sb.WriteString(fmt.Sprintf("\t\"%s\"", line))
+		if i == len(lines)-1 {
+			sb.WriteString(");")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// EmitRaw satisfies the Backend interface. Raw statements are C source.
+func (b *cBackend) EmitRaw(rawStmt *ast.RawStatement) (string, error) {
+	return fmt.Sprintf("%s\n", rawStmt.Value), nil
+}
+
+// EmitDirective satisfies the Backend interface. Directives are preprocessor
+// directives, like "#include".
+func (b *cBackend) EmitDirective(directiveStmt *ast.DirectiveStatement) (string, error) {
+	var sb strings.Builder
+	for _, line := range strings.Split(directiveStmt.Value, "\n") {
+		sb.WriteString(fmt.Sprintf("%s\n", strings.TrimSpace(line)))
+	}
+	return sb.String(), nil
+}
+
+// EmitMovement satisfies the Backend interface.
+func (b *cBackend) EmitMovement(movementStmt *ast.MovementStatement) (string, error) {
+	terminator := "step_end"
+	var sb strings.Builder
+	sb.WriteString(b.renderArrayStart(movementStmt.Name.Value, "u8", movementStmt.Scope == token.GLOBAL))
+	terminated := false
+	for _, cmd := range movementStmt.MovementCommands {
+		sb.WriteString(fmt.Sprintf("\t%s,\n", cmd))
+		if cmd == terminator {
+			terminated = true
+			break
+		}
+	}
+	if !terminated {
+		sb.WriteString(fmt.Sprintf("\t%s,\n", terminator))
+	}
+	sb.WriteString("};\n")
+	return sb.String(), nil
+}
+
+// EmitMart satisfies the Backend interface. The items are halfwords, which
+// are aligned without an @align annotation.
+func (b *cBackend) EmitMart(martStmt *ast.MartStatement) (string, error) {
+	terminator := "ITEM_NONE"
+	b.EmitAlignment(martStmt.Annotations)
+	var sb strings.Builder
+	sb.WriteString(b.renderArrayStart(martStmt.Name.Value, "u16", martStmt.Scope == token.GLOBAL))
+	for _, item := range martStmt.MartItems {
+		if item == terminator {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("\t%s,\n", item))
+	}
+	sb.WriteString(fmt.Sprintf("\t%s,\n};\n", terminator))
+	return sb.String(), nil
+}
+
+// The C types of data values, by their size in bytes.
+var cDataTypes = map[int]string{
+	1: "u8",
+	2: "u16",
+	4: "u32",
+}
+
+// EmitData satisfies the Backend interface. The data is an array of its
+// values' type, so every row must have values of the same size.
+func (b *cBackend) EmitData(dataStmt *ast.DataStatement) (string, error) {
+	size := 0
+	if len(dataStmt.Rows) > 0 {
+		size = dataStmt.Rows[0].Size
+	}
+	for _, row := range dataStmt.Rows {
+		if row.Size != size {
+			return "", emitErrorf("data '%s' has values of different sizes, which can't be in the same C array", dataStmt.Name.Value)
+		}
+	}
+	if size == 0 {
+		return "", emitErrorf("data '%s' has no values, which can't be a C array", dataStmt.Name.Value)
+	}
+	var sb strings.Builder
+	sb.WriteString(b.renderArrayStart(dataStmt.Name.Value, cDataTypes[size], dataStmt.Scope == token.GLOBAL))
+	for _, row := range dataStmt.Rows {
+		sb.WriteString(fmt.Sprintf("\t%s,\n", strings.Join(row.Values, ", ")))
+	}
+	sb.WriteString("};\n")
+	return sb.String(), nil
+}
+
+// EmitAlias satisfies the Backend interface. The alias is an array with the
+// same address as its label, which has the type of the label's array if it
+// was already declared.
+func (b *cBackend) EmitAlias(aliasStmt *ast.AliasStatement) (string, error) {
+	cType := "u8"
+	for _, declaration := range b.declarations {
+		if declaration.name == aliasStmt.Target.Value {
+			cType = declaration.cType
+		}
+	}
+	attributes := b.declare(aliasStmt.Name.Value, cType, aliasStmt.Scope == token.GLOBAL)
+	return fmt.Sprintf("const %s %s[]%s __attribute__((alias(\"%s\")));\n", cType, aliasStmt.Name.Value, attributes, aliasStmt.Target.Value), nil
+}
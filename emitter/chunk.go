@@ -1,10 +1,8 @@
 package emitter
 
 import (
-	"fmt"
-	"strings"
-
 	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
 )
 
 // Represents a single chunk of script output. Each chunk has an associated label in
@@ -14,60 +12,36 @@ type chunk struct {
 	returnID         int
 	useEndTerminator bool
 	statements       []ast.Statement
-	branchBehavior   brancher
+	branchBehavior   ir.Branch
 }
 
-func (c *chunk) renderLabel(scriptName string, isGlobal bool, sb *strings.Builder) {
-	if c.id == 0 {
-		// Main script entrypoint label.
-		if isGlobal {
-			sb.WriteString(fmt.Sprintf("%s::\n", scriptName))
-		} else {
-			sb.WriteString(fmt.Sprintf("%s:\n", scriptName))
-		}
-	} else {
-		sb.WriteString(fmt.Sprintf("%s_%d:\n", scriptName, c.id))
-	}
-}
-
-func (c *chunk) renderStatements(sb *strings.Builder) error {
-	// Render basic non-branching commands.
+// Converts the chunk into its intermediate representation, once all of its
+// statements are commands. withSource records where each command is in the
+// Poryscript source.
+func (c *chunk) toIR(withSource bool) (*ir.Chunk, error) {
+	commands := make([]ir.Command, 0, len(c.statements))
 	for _, stmt := range c.statements {
 		commandStmt, ok := stmt.(*ast.CommandStatement)
 		if !ok {
-			return fmt.Errorf("could not render chunk statement '%q' because it is not a command statement", stmt.TokenLiteral())
+			return nil, emitErrorf("could not render chunk statement '%q' because it is not a command statement", stmt.TokenLiteral())
 		}
-
-		sb.WriteString(renderCommandStatement(commandStmt))
-	}
-	return nil
-}
-
-func (c *chunk) renderBranching(scriptName string, sb *strings.Builder, nextChunkID int, registerJumpChunk func(int)) bool {
-	if c.branchBehavior != nil {
-		isFallThrough := c.branchBehavior.renderBranchConditions(sb, scriptName, nextChunkID, registerJumpChunk)
-		return isFallThrough
-	}
-
-	// Handle natural return logic that wasn't covered by a branch behavior.
-	if c.returnID == -1 {
-		sb.WriteString(fmt.Sprintf("\t%s\n", c.getTerminatorCommand()))
-		return false
-	} else if c.returnID != nextChunkID {
-		registerJumpChunk(c.returnID)
-		sb.WriteString(fmt.Sprintf("\tgoto %s_%d\n", scriptName, c.returnID))
-		return false
+		command := ir.Command{Name: commandStmt.Name.Value, Args: commandStmt.Args}
+		if withSource {
+			command.Source = ir.Location{Filepath: commandStmt.Token.Filepath, Line: commandStmt.Token.LineNumber}
+		}
+		commands = append(commands, command)
 	}
 
-	// Fallthrough to next chunk.
-	return true
-}
-
-func (c *chunk) getTerminatorCommand() string {
-	if c.useEndTerminator {
-		return "end"
+	branch := c.branchBehavior
+	if branch == nil {
+		// Handle natural return logic that wasn't covered by a branch behavior.
+		if c.returnID == -1 {
+			branch = &ir.Return{End: c.useEndTerminator}
+		} else {
+			branch = &ir.Goto{Dest: c.returnID}
+		}
 	}
-	return "return"
+	return &ir.Chunk{ID: c.id, Commands: commands, Branch: branch}, nil
 }
 
 func (c *chunk) splitChunkForBranch(statementIndex int, chunkCounter *int, remainingChunks []*chunk) ([]*chunk, int) {
@@ -101,13 +75,3 @@ func (c *chunk) createPostLogicChunk(id int, lastStatementIndex int) *chunk {
 	}
 	return newChunk
 }
-
-func renderCommandStatement(commandStmt *ast.CommandStatement) string {
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("\t%s", commandStmt.Name.Value))
-	if len(commandStmt.Args) > 0 {
-		sb.WriteString(fmt.Sprintf(" %s", strings.Join(commandStmt.Args, ", ")))
-	}
-	sb.WriteString("\n")
-	return sb.String()
-}
@@ -0,0 +1,351 @@
+package emitter
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Vars with ids below this value are not real vars. The game treats their
+// ids as literal values instead, so comparisons of them can be evaluated at
+// compile time.
+const varsStart = 0x4000
+
+// Removes the branches of the statements' if and while statements whose
+// conditions can never be true, and replaces if statements whose conditions
+// are always true with their bodies. Returns the statements unchanged if
+// none of the conditions are known at compile time.
+func removeDeadBranches(statements []ast.Statement) []ast.Statement {
+	result := statements
+	for i := 0; i < len(result); i++ {
+		var replacement []ast.Statement
+		switch stmt := result[i].(type) {
+		case *ast.IfStatement:
+			var ok bool
+			if replacement, ok = simplifyIfStatement(stmt); !ok {
+				continue
+			}
+		case *ast.WhileStatement:
+			if _, value, known := simplifyCondition(stmt.Consequence.Expression); !known || value {
+				continue
+			}
+		default:
+			continue
+		}
+		// The statements are copied, because they may be shared with
+		// other chunks. The replacement is checked again, since it
+		// can contain more if statements.
+		spliced := make([]ast.Statement, 0, len(result)-1+len(replacement))
+		spliced = append(spliced, result[:i]...)
+		spliced = append(spliced, replacement...)
+		result = append(spliced, result[i+1:]...)
+		i--
+	}
+	return result
+}
+
+// Returns the statements that replace an if statement, after removing the
+// branches whose conditions are known at compile time. Returns false if none
+// of its conditions are known.
+func simplifyIfStatement(stmt *ast.IfStatement) ([]ast.Statement, bool) {
+	changed := false
+	consequences := []*ast.ConditionExpression{}
+	elseConsequence := stmt.ElseConsequence
+	for _, consequence := range append([]*ast.ConditionExpression{stmt.Consequence}, stmt.ElifConsequences...) {
+		expression, value, known := simplifyCondition(consequence.Expression)
+		if known {
+			changed = true
+			if value {
+				// The later branches can never be reached.
+				elseConsequence = consequence.Body
+				break
+			}
+			continue
+		}
+		if expression != consequence.Expression {
+			changed = true
+			consequence = &ast.ConditionExpression{Expression: expression, Body: consequence.Body, EndPos: consequence.EndPos}
+		}
+		consequences = append(consequences, consequence)
+	}
+	if !changed {
+		return nil, false
+	}
+	if len(consequences) == 0 {
+		if elseConsequence == nil {
+			return []ast.Statement{}, true
+		}
+		return elseConsequence.Statements, true
+	}
+	return []ast.Statement{&ast.IfStatement{
+		Token:            stmt.Token,
+		Consequence:      consequences[0],
+		ElifConsequences: consequences[1:],
+		ElseConsequence:  elseConsequence,
+		EndPos:           stmt.EndPos,
+	}}, true
+}
+
+// Simplifies a boolean expression by evaluating the parts of it that are
+// known at compile time. If the whole expression is known, its value is
+// returned instead.
+func simplifyCondition(expression ast.BooleanExpression) (ast.BooleanExpression, bool, bool) {
+	switch expr := expression.(type) {
+	case *ast.OperatorExpression:
+		value, known := evaluateOperatorExpression(expr)
+		return expr, value, known
+	case *ast.BinaryExpression:
+		left, leftValue, leftKnown := simplifyCondition(expr.Left)
+		right, rightValue, rightKnown := simplifyCondition(expr.Right)
+		// A known operand decides the result of the expression when it
+		// is false in an '&&' expression, or true in an '||' expression.
+		decidingValue := expr.Operator == token.OR
+		if (leftKnown && leftValue == decidingValue) || (rightKnown && rightValue == decidingValue) {
+			return nil, decidingValue, true
+		}
+		if leftKnown {
+			return right, rightValue, rightKnown
+		}
+		if rightKnown {
+			return left, leftValue, leftKnown
+		}
+		if left == expr.Left && right == expr.Right {
+			return expr, false, false
+		}
+		return &ast.BinaryExpression{Left: left, Operator: expr.Operator, Right: right}, false, false
+	}
+	return expression, false, false
+}
+
+// Evaluates a var comparison whose operand and comparison value are both
+// literal values. The game compares unsigned 16-bit values, and a
+// comparison value of at least varsStart is read from that var instead, so
+// only values below varsStart are evaluated. Negative values wrap around to
+// large unsigned values, so they aren't evaluated either.
+func evaluateOperatorExpression(expr *ast.OperatorExpression) (bool, bool) {
+	if expr.Type != token.VAR {
+		return false, false
+	}
+	operand, ok := parseConstantValue(expr.Operand)
+	if !ok || !isLiteralVarValue(operand) {
+		return false, false
+	}
+	value, ok := parseConstantValue(expr.ComparisonValue)
+	if !ok || !isLiteralVarValue(value) {
+		return false, false
+	}
+	switch expr.Operator {
+	case token.EQ:
+		return operand == value, true
+	case token.NEQ:
+		return operand != value, true
+	case token.LT:
+		return operand < value, true
+	case token.LTE:
+		return operand <= value, true
+	case token.GT:
+		return operand > value, true
+	case token.GTE:
+		return operand >= value, true
+	}
+	return false, false
+}
+
+// Reports whether the game treats a var id as the value itself, which is
+// the same when it's read as an unsigned 16-bit value.
+func isLiteralVarValue(value int64) bool {
+	return value >= 0 && value < varsStart
+}
+
+func parseConstantValue(value string) (int64, bool) {
+	switch value {
+	case token.TRUE:
+		return 1, true
+	case token.FALSE:
+		return 0, true
+	}
+	result, err := strconv.ParseInt(value, 0, 64)
+	return result, err == nil
+}
+
+// Returns the names of the inline texts that were only used by the branches
+// that were left out of the emitted scripts, because their conditions can
+// never be true, so that they are left out, too. Text statements are always
+// emitted.
+func (e *Emitter) getDeadTexts() map[string]bool {
+	deadTexts := make(map[string]bool)
+	if e.optimizationLevel < FullOptimization {
+		return deadTexts
+	}
+	textStatements := make(map[string]bool)
+	for _, stmt := range e.program.TopLevelStatements {
+		if textStmt, ok := stmt.(*ast.TextStatement); ok {
+			textStatements[textStmt.Name.Value] = true
+		}
+	}
+	isInlineText := make(map[string]bool)
+	for _, text := range e.program.Texts {
+		isInlineText[text.Name] = !textStatements[text.Name]
+	}
+	for _, stmt := range e.program.TopLevelStatements {
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			if command, ok := node.(*ast.CommandStatement); ok {
+				for _, arg := range command.Args {
+					if isInlineText[arg] && !e.commandArgs[arg] {
+						deadTexts[arg] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	return deadTexts
+}
+
+// Returns the condition that a branch checks. Optimized output checks a
+// simplified, equivalent condition instead.
+func getBranchCondition(expression ast.BooleanExpression, optimize bool) ast.BooleanExpression {
+	if !optimize {
+		return expression
+	}
+	return simplifyBooleanExpression(expression)
+}
+
+// Returns an equivalent boolean expression that needs fewer commands to
+// check. The parser already moves negations into the comparisons, so the
+// expression only consists of '&&' and '||' expressions of comparisons.
+// Repeated operands are removed, and the cheapest operands are checked
+// first, so that the expression can short-circuit as early as possible.
+// Checking operands in a different order is safe, because comparisons
+// don't have side effects.
+func simplifyBooleanExpression(expression ast.BooleanExpression) ast.BooleanExpression {
+	binaryExpression, ok := expression.(*ast.BinaryExpression)
+	if !ok {
+		return expression
+	}
+	operands := []ast.BooleanExpression{}
+	for _, operand := range getOperands(binaryExpression, binaryExpression.Operator) {
+		operand = simplifyBooleanExpression(operand)
+		if !containsBooleanExpression(operands, operand) {
+			operands = append(operands, operand)
+		}
+	}
+	sort.SliceStable(operands, func(i, j int) bool {
+		return getConditionCost(operands[i]) < getConditionCost(operands[j])
+	})
+	result := operands[0]
+	for _, operand := range operands[1:] {
+		result = &ast.BinaryExpression{Left: result, Operator: binaryExpression.Operator, Right: operand}
+	}
+	return result
+}
+
+// Returns the operands of a chain of expressions with the same operator,
+// like 'a && (b && c)'.
+func getOperands(expression ast.BooleanExpression, operator token.Type) []ast.BooleanExpression {
+	binaryExpression, ok := expression.(*ast.BinaryExpression)
+	if !ok || binaryExpression.Operator != operator {
+		return []ast.BooleanExpression{expression}
+	}
+	return append(getOperands(binaryExpression.Left, operator), getOperands(binaryExpression.Right, operator)...)
+}
+
+func containsBooleanExpression(expressions []ast.BooleanExpression, expression ast.BooleanExpression) bool {
+	for _, other := range expressions {
+		if booleanExpressionsEqual(other, expression) {
+			return true
+		}
+	}
+	return false
+}
+
+func booleanExpressionsEqual(a, b ast.BooleanExpression) bool {
+	switch x := a.(type) {
+	case *ast.OperatorExpression:
+		y, ok := b.(*ast.OperatorExpression)
+		return ok && x.Type == y.Type && x.Operand == y.Operand && x.Operator == y.Operator && x.ComparisonValue == y.ComparisonValue
+	case *ast.BinaryExpression:
+		y, ok := b.(*ast.BinaryExpression)
+		return ok && x.Operator == y.Operator && booleanExpressionsEqual(x.Left, y.Left) && booleanExpressionsEqual(x.Right, y.Right)
+	}
+	return false
+}
+
+// Returns the number of commands that are emitted to check the expression.
+func getConditionCost(expression ast.BooleanExpression) int {
+	switch expr := expression.(type) {
+	case *ast.OperatorExpression:
+		if expr.Type == token.FLAG {
+			// goto_if_set or goto_if_unset
+			return 1
+		}
+		// compare or checktrainerflag, followed by a goto
+		return 2
+	case *ast.BinaryExpression:
+		return getConditionCost(expr.Left) + getConditionCost(expr.Right)
+	}
+	return 0
+}
+
+// The var that the "switch" macro copies its operand into, which is then
+// compared against each case.
+const switchVar = "VAR_0x8000"
+
+// The minimum number of conditions of an if statement that is emitted like a
+// switch statement.
+const minSwitchComparisons = 3
+
+// Returns the var and the values that the conditions of an if statement
+// compare it against, if every condition checks whether the same var is
+// equal to a value. Such an if statement can be emitted like a switch
+// statement, which only copies the var once. Like the conditions, the cases
+// are checked in order, so a repeated value still goes to its first branch.
+func getSwitchComparisons(stmt *ast.IfStatement) (string, []string, bool) {
+	consequences := append([]*ast.ConditionExpression{stmt.Consequence}, stmt.ElifConsequences...)
+	if len(consequences) < minSwitchComparisons {
+		return "", nil, false
+	}
+	operand := ""
+	values := make([]string, 0, len(consequences))
+	for _, consequence := range consequences {
+		expr, ok := simplifyBooleanExpression(consequence.Expression).(*ast.OperatorExpression)
+		if !ok || expr.Type != token.VAR || expr.Operator != token.EQ {
+			return "", nil, false
+		}
+		if operand != "" && expr.Operand != operand {
+			return "", nil, false
+		}
+		operand = expr.Operand
+		values = append(values, expr.ComparisonValue)
+	}
+	return operand, values, true
+}
+
+// Reports whether a script refers to the var that switch statements
+// overwrite. Such scripts may depend on its value, so their if statements
+// aren't emitted like switch statements.
+func referencesSwitchVar(scriptStmt *ast.ScriptStatement) bool {
+	for _, param := range scriptStmt.Params {
+		if param.Var == switchVar {
+			return true
+		}
+	}
+	found := false
+	ast.Inspect(scriptStmt, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.CommandStatement:
+			for _, arg := range n.Args {
+				found = found || strings.Contains(arg, switchVar)
+			}
+		case *ast.RawStatement:
+			found = found || strings.Contains(n.Value, switchVar)
+		case *ast.OperatorExpression:
+			found = found || n.Operand == switchVar || n.ComparisonValue == switchVar
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,180 @@
+package emitter
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+)
+
+// Directive statements are emitted once, in the output of raw statements.
+// Their conditional directives, like "#ifdef" and "#endif", are repeated in
+// the other outputs, and around the texts, which are emitted after the
+// other statements. That way, the movements, data, and texts of the
+// statements between them stay inside of their conditional regions.
+
+// The kinds of conditional directives.
+type conditionalKind int
+
+const (
+	notConditional conditionalKind = iota
+	// Starts a conditional region, like "#ifdef" or ".if".
+	openingConditional
+	// Continues a conditional region, like "#else" or ".elseif".
+	continuingConditional
+	// Ends a conditional region, like "#endif" or ".endif".
+	closingConditional
+)
+
+// Returns the kind of conditional directive of a line, which is either a
+// directive of the assembler, like ".ifdef", or of the C preprocessor, like
+// "#ifdef".
+func getConditionalKind(line string) conditionalKind {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ".") {
+		return notConditional
+	}
+	fields := strings.Fields(line[1:])
+	if len(fields) == 0 {
+		return notConditional
+	}
+	switch name := fields[0]; {
+	case name == "endif":
+		return closingConditional
+	case name == "else" || name == "elseif" || strings.HasPrefix(name, "elif"):
+		return continuingConditional
+	case strings.HasPrefix(name, "if"):
+		return openingConditional
+	}
+	return notConditional
+}
+
+// Returns the lines of a directive statement that are conditional
+// directives.
+func getConditionalLines(directiveStmt *ast.DirectiveStatement) []string {
+	var lines []string
+	for _, line := range strings.Split(directiveStmt.Value, "\n") {
+		if getConditionalKind(line) != notConditional {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
+
+// The conditional directives that are repeated in an output, which are
+// only written once a statement is written after them. Branches of regions
+// that would be empty are left out.
+type pendingConditionals struct {
+	lines []string
+}
+
+func (p *pendingConditionals) add(lines []string) {
+	for _, line := range lines {
+		if getConditionalKind(line) == closingConditional {
+			for len(p.lines) > 0 && getConditionalKind(p.lines[len(p.lines)-1]) == continuingConditional {
+				p.lines = p.lines[:len(p.lines)-1]
+			}
+			if len(p.lines) > 0 && getConditionalKind(p.lines[len(p.lines)-1]) == openingConditional {
+				p.lines = p.lines[:len(p.lines)-1]
+				continue
+			}
+		}
+		p.lines = append(p.lines, line)
+	}
+}
+
+// Writes the pending conditional directives to the output, before the next
+// statement is written.
+func (p *pendingConditionals) flush(e *Emitter, out *emitterOutput) error {
+	if len(p.lines) == 0 {
+		return nil
+	}
+	output, err := e.backend.EmitDirective(&ast.DirectiveStatement{Value: strings.Join(p.lines, "\n")})
+	if err != nil {
+		return err
+	}
+	out.separate(e.style.BlankLines)
+	out.sb.WriteString(output)
+	p.lines = nil
+	return nil
+}
+
+// Returns the directive statements that have conditional directives.
+func (e *Emitter) getConditionalDirectiveStatements() []*ast.DirectiveStatement {
+	var directiveStmts []*ast.DirectiveStatement
+	for _, stmt := range e.program.TopLevelStatements {
+		if directiveStmt, ok := stmt.(*ast.DirectiveStatement); ok && len(getConditionalLines(directiveStmt)) > 0 {
+			directiveStmts = append(directiveStmts, directiveStmt)
+		}
+	}
+	return directiveStmts
+}
+
+// Returns the program's texts in the given order, grouped by the
+// conditional directive statements that come before the statements that
+// they belong to. The index of a text's group is the number of conditional
+// directive statements before it. A text statement belongs to itself, and
+// an inline text to the statement that uses it. Inline texts that are used
+// on both sides of a conditional directive statement go into the last
+// group, after all of them.
+func (e *Emitter) groupTextsByDirectives(texts []ast.Text) ([]ast.Text, []int) {
+	textStatements := make(map[string]bool)
+	for _, stmt := range e.program.TopLevelStatements {
+		if textStmt, ok := stmt.(*ast.TextStatement); ok {
+			textStatements[textStmt.Name.Value] = true
+		}
+	}
+	directiveCount := len(e.getConditionalDirectiveStatements())
+	groups := make(map[string]int)
+	group := 0
+	for _, stmt := range e.program.TopLevelStatements {
+		switch s := stmt.(type) {
+		case *ast.DirectiveStatement:
+			if len(getConditionalLines(s)) > 0 {
+				group++
+			}
+			continue
+		case *ast.TextStatement:
+			groups[s.Name.Value] = group
+			continue
+		}
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			if command, ok := node.(*ast.CommandStatement); ok {
+				for _, arg := range command.Args {
+					if textStatements[arg] {
+						continue
+					}
+					if prevGroup, ok := groups[arg]; ok && prevGroup != group {
+						groups[arg] = directiveCount
+					} else if !ok {
+						groups[arg] = group
+					}
+				}
+			}
+			return true
+		})
+	}
+	grouped := append([]ast.Text{}, texts...)
+	textGroups := make([]int, len(grouped))
+	for i, text := range grouped {
+		textGroup, ok := groups[text.Name]
+		if !ok {
+			textGroup = directiveCount
+		}
+		textGroups[i] = textGroup
+	}
+	sort.Stable(textsByGroup{grouped, textGroups})
+	return grouped, textGroups
+}
+
+type textsByGroup struct {
+	texts  []ast.Text
+	groups []int
+}
+
+func (t textsByGroup) Len() int           { return len(t.texts) }
+func (t textsByGroup) Less(i, j int) bool { return t.groups[i] < t.groups[j] }
+func (t textsByGroup) Swap(i, j int) {
+	t.texts[i], t.texts[j] = t.texts[j], t.texts[i]
+	t.groups[i], t.groups[j] = t.groups[j], t.groups[i]
+}
@@ -3,32 +3,357 @@ package emitter
 import (
 	"errors"
 	"fmt"
-	"sort"
 	"strings"
 
 	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+	"github.com/huderlem/poryscript/parser"
 	"github.com/huderlem/poryscript/token"
 )
 
+// ErrEmit is matched by all errors returned by Emit, with errors.Is, whichever
+// backend produced them. They mean that the program can't be emitted for the
+// target, e.g. because it uses a command that isn't in the opcode table.
+var ErrEmit = errors.New("emit error")
+
+type emitError struct {
+	message string
+}
+
+func (e *emitError) Error() string {
+	return e.message
+}
+
+func (e *emitError) Is(target error) bool {
+	return target == ErrEmit
+}
+
+func emitErrorf(format string, args ...interface{}) error {
+	return &emitError{message: fmt.Sprintf(format, args...)}
+}
+
 // Emitter is responsible for transforming a parsed Poryscript program into
 // the target assembler bytecode script.
 type Emitter struct {
-	program  *ast.Program
-	optimize bool
+	program           *ast.Program
+	optimizationLevel OptimizationLevel
+	backend           Backend
+	labelFormat       ir.LabelFormat
+	style             OutputStyle
+	// Whether each chunk of commands is preceded by a comment with its
+	// location in the Poryscript source.
+	sourceComments bool
+	sourceMap      bool
+	lineDirectives LineDirectiveFormat
+	// The output file, which line directives switch back to.
+	outputFilepath string
+	// The locations of the commands of the emitted scripts, by their
+	// source comments.
+	sourceLocations map[string]ir.Location
+	sourceMappings  []SourceMapping
+	symbols         []Symbol
+	tailCalls       bool
+	switchChains    bool
+	textOrder       TextOrder
+	// The directive and language of the texts that don't have their own.
+	textDirective string
+	textLanguage  string
+	targetConfig  TargetConfig
+	scriptLimits  ScriptLimits
+	// The warnings of the most recent call to Emit.
+	diagnosticOptions parser.DiagnosticOptions
+	diagnostics       []parser.Diagnostic
+	collectStats      bool
+	stats             Stats
+	// The arguments of the commands of the emitted scripts, which tell the
+	// inline texts that are only used by branches that were left out.
+	commandArgs map[string]bool
+}
+
+// Symbol is a label that was emitted by Emit.
+type Symbol struct {
+	Name string `json:"name"`
+	// "script", "branch", "mapscripts", "text", "movement", "mart", "data",
+	// or "alias".
+	Kind   string `json:"kind"`
+	Global bool   `json:"global"`
+	// The script that a branch label belongs to.
+	Script string `json:"script,omitempty"`
 }
 
-// New creates a new Poryscript program emitter.
+// OptimizationLevel is how much the emitter optimizes the scripts.
+type OptimizationLevel int
+
+// Optimization levels.
+const (
+	// Every chunk of a script is emitted in the order it was created, and
+	// conditions are checked exactly as they are written, so that the
+	// output maps label-for-label to the source. This helps when debugging.
+	NoOptimization OptimizationLevel = iota
+	// Chunks are merged and ordered, so that they fall through into each
+	// other, and jumps to chunks that only jump elsewhere go straight to
+	// their destination. The conditions are still checked as they are
+	// written.
+	LayoutOptimization
+	// Conditions are simplified too. Branches whose conditions are known at
+	// compile time are left out.
+	FullOptimization
+)
+
+// New creates a new Poryscript program emitter, which emits assembler
+// bytecode scripts for pokeemerald.
 func New(program *ast.Program, optimize bool) *Emitter {
+	return NewWithBackend(program, optimize, &asmBackend{profile: pokeemeraldProfile})
+}
+
+// NewWithBackend creates a new Poryscript program emitter, which renders
+// its output with the given backend.
+func NewWithBackend(program *ast.Program, optimize bool, backend Backend) *Emitter {
+	level := NoOptimization
+	if optimize {
+		level = FullOptimization
+	}
 	return &Emitter{
-		program:  program,
-		optimize: optimize,
+		program:           program,
+		optimizationLevel: level,
+		backend:           backend,
+		labelFormat:       ir.DefaultLabelFormat,
+		style:             DefaultOutputStyle,
 	}
 }
 
-// Emit the target assembler bytecode script.
+// SetOptimizationLevel sets how much the scripts are optimized, which
+// replaces the optimize argument of the constructor.
+func (e *Emitter) SetOptimizationLevel(level OptimizationLevel) {
+	e.optimizationLevel = level
+}
+
+// SetLabelFormat sets the naming scheme of the labels that are generated
+// for the chunks of scripts.
+func (e *Emitter) SetLabelFormat(format ir.LabelFormat) {
+	e.labelFormat = format
+}
+
+// SetOutputStyle sets the formatting of the output.
+func (e *Emitter) SetOutputStyle(style OutputStyle) {
+	e.style = style
+}
+
+// SetSourceComments sets whether each chunk of commands is preceded by a
+// comment with its location in the Poryscript source, like
+// "@ data/scripts/myscript.pory:12".
+func (e *Emitter) SetSourceComments(enabled bool) {
+	e.sourceComments = enabled
+}
+
+// SetSourceMap sets whether Emit builds a source map, which maps the lines
+// of the output to the lines of the Poryscript source.
+func (e *Emitter) SetSourceMap(enabled bool) {
+	e.sourceMap = enabled
+}
+
+// SetLineDirectives sets the format of the line directives that precede each
+// chunk of commands, which make the assembler report errors at the
+// Poryscript source. After the chunk, a line directive switches back to the
+// output file, which is at outputFilepath.
+func (e *Emitter) SetLineDirectives(format LineDirectiveFormat, outputFilepath string) {
+	e.lineDirectives = format
+	e.outputFilepath = outputFilepath
+}
+
+// SetTailCalls sets whether a call right before a script returns is
+// replaced with a jump to the called script, if the backend implements
+// TailCallBackend.
+func (e *Emitter) SetTailCalls(enabled bool) {
+	e.tailCalls = enabled
+}
+
+// SetSwitchChains sets whether an if statement whose conditions all compare
+// the same var with '==' is emitted like a switch statement, at the full
+// optimization level. The switch overwrites VAR_0x8000, which a script's
+// callers or the specials that it uses may depend on, so it's off by
+// default.
+func (e *Emitter) SetSwitchChains(enabled bool) {
+	e.switchChains = enabled
+}
+
+// SetTextOrder sets the order of the texts, which are emitted after the
+// other statements.
+func (e *Emitter) SetTextOrder(order TextOrder) {
+	e.textOrder = order
+}
+
+// SetTextDirective sets the directive that texts are emitted with, like
+// "string" or a project's own macro, and the language argument of the
+// directive, which is empty for no argument. Texts that have their own
+// directive, like ascii"...", keep it, and don't get the language argument.
+func (e *Emitter) SetTextDirective(directive string, language string) {
+	e.textDirective = strings.TrimPrefix(directive, ".")
+	e.textLanguage = language
+}
+
+// SetTargetConfig sets the project-specific output of the target, like the
+// directives around statements.
+func (e *Emitter) SetTargetConfig(config TargetConfig) {
+	e.targetConfig = config
+}
+
+// SetScriptLimits sets the sizes that a single script can expand to before
+// Emit warns about it.
+func (e *Emitter) SetScriptLimits(limits ScriptLimits) {
+	e.scriptLimits = limits
+}
+
+// SetDiagnosticOptions sets which of the emitter's warnings are reported,
+// and whether they are reported as errors.
+func (e *Emitter) SetDiagnosticOptions(options parser.DiagnosticOptions) {
+	e.diagnosticOptions = options
+}
+
+// SetStats sets whether Emit measures the sizes of the emitted program,
+// which are returned by Stats.
+func (e *Emitter) SetStats(enabled bool) {
+	e.collectStats = enabled
+}
+
+// SourceMap returns the source map that was built by the most recent call to
+// Emit, in order of the output's lines. Lines that weren't compiled from a
+// command, like labels of texts, aren't in the source map.
+func (e *Emitter) SourceMap() []SourceMapping {
+	return e.sourceMappings
+}
+
+// Symbols returns the labels that were emitted by the most recent call to
+// Emit, in the order they were emitted.
+func (e *Emitter) Symbols() []Symbol {
+	return e.symbols
+}
+
+// Stats returns the sizes of the program that was emitted by the most recent
+// call to Emit, if the emitter measures them.
+func (e *Emitter) Stats() Stats {
+	return e.stats
+}
+
+// Diagnostics returns the warnings that were found by the most recent call to
+// Emit, like scripts that are larger than the script limits.
+func (e *Emitter) Diagnostics() []parser.Diagnostic {
+	return e.diagnostics
+}
+
+func (e *Emitter) addWarning(category string, pos token.Position, message string) {
+	if diagnostic, ok := e.diagnosticOptions.NewWarning(category, pos, message); ok {
+		e.diagnostics = append(e.diagnostics, diagnostic)
+	}
+}
+
+func (e *Emitter) addSymbol(name string, kind string, scope token.Type) {
+	e.symbols = append(e.symbols, Symbol{Name: name, Kind: kind, Global: scope == token.GLOBAL})
+}
+
+// Emit the target script.
 func (e *Emitter) Emit() (string, error) {
-	var sb strings.Builder
-	i := 0
+	outputs, err := e.emit(1, func(kind string, isGlobal bool) int {
+		return 0
+	})
+	if err != nil {
+		return "", err
+	}
+	return outputs[0], nil
+}
+
+// EmitSplit emits the target script as two outputs, for projects that keep
+// their strings in a dedicated file. The first output has the scripts, map
+// scripts, raw statements, and directives. The second output has the texts,
+// movements, marts, and data.
+func (e *Emitter) EmitSplit() (string, string, error) {
+	return e.emitTwoOutputs(func(kind string, isGlobal bool) int {
+		switch kind {
+		case "text", "movement", "mart", "data":
+			return 1
+		}
+		return 0
+	})
+}
+
+// EmitSplitByScope emits the target script as two outputs, so that one file
+// can feed both a project's shared scripts and a map's local scripts. The
+// first output has the local statements, raw statements, and directives. The
+// second output has the global statements.
+func (e *Emitter) EmitSplitByScope() (string, string, error) {
+	return e.emitTwoOutputs(func(kind string, isGlobal bool) int {
+		if isGlobal {
+			return 1
+		}
+		return 0
+	})
+}
+
+func (e *Emitter) emitTwoOutputs(route outputRouter) (string, string, error) {
+	if _, ok := e.backend.(*bytecodeBackend); ok {
+		return "", "", emitErrorf("binary output can't be split into multiple files")
+	}
+	outputs, err := e.emit(2, route)
+	if err != nil {
+		return "", "", err
+	}
+	return outputs[0], outputs[1], nil
+}
+
+// Chooses the output of a statement, given its kind and whether it is
+// global. The kinds are the kinds of symbols, or "raw" for raw statements
+// and directives.
+type outputRouter func(kind string, isGlobal bool) int
+
+// Returns the kind of a top-level statement, and whether it is global.
+func getStatementKind(stmt ast.Statement) (string, bool) {
+	switch s := stmt.(type) {
+	case *ast.MapScriptsStatement:
+		return "mapscripts", s.Scope == token.GLOBAL
+	case *ast.ScriptStatement:
+		return "script", s.Scope == token.GLOBAL
+	case *ast.MovementStatement:
+		return "movement", s.Scope == token.GLOBAL
+	case *ast.MartStatement:
+		return "mart", s.Scope == token.GLOBAL
+	case *ast.DataStatement:
+		return "data", s.Scope == token.GLOBAL
+	case *ast.AliasStatement:
+		return "alias", s.Scope == token.GLOBAL
+	}
+	return "raw", false
+}
+
+// An output of the emitter. It counts its statements, so that they can be
+// separated with newlines.
+type emitterOutput struct {
+	sb    strings.Builder
+	count int
+}
+
+// Writes the separator before the next statement of the output.
+func (o *emitterOutput) separate(blankLines int) {
+	if o.count > 0 {
+		o.sb.WriteString(strings.Repeat("\n", blankLines))
+	}
+	o.count++
+}
+
+func (e *Emitter) emit(numOutputs int, route outputRouter) ([]string, error) {
+	e.symbols = []Symbol{}
+	e.sourceLocations = make(map[string]ir.Location)
+	e.sourceMappings = nil
+	e.diagnostics = nil
+	e.stats = Stats{}
+	e.commandArgs = make(map[string]bool)
+	if _, ok := e.backend.(*bytecodeBackend); ok && len(e.targetConfig.Directives) > 0 {
+		return nil, emitErrorf("binary output can't have statement directives")
+	}
+	if backend, ok := e.backend.(ProgramBackend); ok {
+		backend.BeginProgram(e.program)
+	}
+	outputs := make([]emitterOutput, numOutputs)
+	conditionals := make([]pendingConditionals, numOutputs)
 	for _, stmt := range e.program.TopLevelStatements {
 		_, ok := stmt.(*ast.TextStatement)
 		if ok {
@@ -36,113 +361,198 @@ func (e *Emitter) Emit() (string, error) {
 			continue
 		}
 
-		// Separate statements with newline.
-		if i > 0 {
-			sb.WriteString("\n")
+		kind, isGlobal := getStatementKind(stmt)
+		outputIndex := route(kind, isGlobal)
+		if directiveStmt, ok := stmt.(*ast.DirectiveStatement); ok {
+			for i := range conditionals {
+				if i != outputIndex {
+					conditionals[i].add(getConditionalLines(directiveStmt))
+				}
+			}
+		}
+		out := &outputs[outputIndex]
+		if err := conditionals[outputIndex].flush(e, out); err != nil {
+			return nil, err
 		}
+		// Separate statements with newline.
+		out.separate(e.style.BlankLines)
+		out.sb.WriteString(e.renderDirectives(kind, true))
 
-		mapScriptsStmt, ok := stmt.(*ast.MapScriptsStatement)
-		if ok {
-			output, err := e.emitMapScriptStatement(mapScriptsStmt)
-			if err != nil {
-				return "", err
-			}
-			sb.WriteString(output)
-			i++
-			continue
+		if _, ok := stmt.(*ast.MartStatement); !ok {
+			out.sb.WriteString(e.style.apply(e.backend.EmitAlignment(ast.AnnotationsOf(stmt))))
 		}
 
-		scriptStmt, ok := stmt.(*ast.ScriptStatement)
-		if ok {
-			output, err := e.emitScriptStatement(scriptStmt)
-			if err != nil {
-				return "", err
+		var output string
+		var err error
+		switch s := stmt.(type) {
+		case *ast.MapScriptsStatement:
+			e.addSymbol(s.Name.Value, "mapscripts", s.Scope)
+			for _, tableMapScript := range s.TableMapScripts {
+				e.addSymbol(tableMapScript.Name, "mapscripts", token.LOCAL)
 			}
-			sb.WriteString(output)
-			i++
-			continue
+			output, err = e.backend.EmitMapScripts(s, e.emitScriptStatement)
+		case *ast.ScriptStatement:
+			output, err = e.emitScriptStatement(s)
+		case *ast.RawStatement:
+			output, err = e.backend.EmitRaw(s)
+		case *ast.DirectiveStatement:
+			output, err = e.backend.EmitDirective(s)
+		case *ast.MovementStatement:
+			e.addSymbol(s.Name.Value, "movement", s.Scope)
+			output, err = e.backend.EmitMovement(s)
+		case *ast.MartStatement:
+			e.addSymbol(s.Name.Value, "mart", s.Scope)
+			output, err = e.backend.EmitMart(s)
+		case *ast.DataStatement:
+			e.addSymbol(s.Name.Value, "data", s.Scope)
+			output, err = e.backend.EmitData(s)
+		case *ast.AliasStatement:
+			e.addSymbol(s.Name.Value, "alias", s.Scope)
+			output, err = e.backend.EmitAlias(s)
+		default:
+			return nil, emitErrorf("could not emit unrecognized top-level statement '%s'", stmt.TokenLiteral())
 		}
-
-		rawStmt, ok := stmt.(*ast.RawStatement)
-		if ok {
-			sb.WriteString(emitRawStatement(rawStmt))
-			i++
-			continue
+		if err != nil {
+			return nil, err
+		}
+		switch stmt.(type) {
+		case *ast.RawStatement, *ast.DirectiveStatement:
+			// Raw statements and directives are written as they are.
+		default:
+			output = e.style.apply(output)
 		}
+		out.sb.WriteString(output)
+		out.sb.WriteString(e.renderDirectives(kind, false))
+	}
 
-		movementStmt, ok := stmt.(*ast.MovementStatement)
-		if ok {
-			sb.WriteString(emitMovementStatement(movementStmt))
-			i++
-			continue
+	for i := range conditionals {
+		if err := conditionals[i].flush(e, &outputs[i]); err != nil {
+			return nil, err
 		}
+	}
 
-		martStmt, ok := stmt.(*ast.MartStatement)
-		if ok {
-			sb.WriteString(emitMartStatement(martStmt))
-			i++
+	deadTexts := e.getDeadTexts()
+	texts, textGroups := e.groupTextsByDirectives(e.getOrderedTexts())
+	directiveStmts := e.getConditionalDirectiveStatements()
+	group := 0
+	for i, text := range texts {
+		if deadTexts[text.Name] {
 			continue
 		}
-
-		return "", fmt.Errorf("could not emit unrecognized top-level statement '%s'", stmt.TokenLiteral())
+		for ; group < textGroups[i]; group++ {
+			for j := range conditionals {
+				conditionals[j].add(getConditionalLines(directiveStmts[group]))
+			}
+		}
+		outputIndex := route("text", text.IsGlobal)
+		out := &outputs[outputIndex]
+		if err := conditionals[outputIndex].flush(e, out); err != nil {
+			return nil, err
+		}
+		out.separate(e.style.BlankLines)
+		out.sb.WriteString(e.renderDirectives("text", true))
+		out.sb.WriteString(e.style.apply(e.backend.EmitAlignment(text.Annotations)))
+		e.symbols = append(e.symbols, Symbol{Name: text.Name, Kind: "text", Global: text.IsGlobal})
+		if e.collectStats {
+			e.addTextStats(text)
+		}
+		if text.StringType == "" {
+			text.StringType = e.textDirective
+			if text.Language == "" {
+				text.Language = e.textLanguage
+			}
+		}
+		emitted, err := e.backend.EmitText(text)
+		if err != nil {
+			return nil, err
+		}
+		out.sb.WriteString(e.style.wrapText(e.style.apply(emitted)))
+		out.sb.WriteString(e.renderDirectives("text", false))
 	}
-
-	for j, text := range e.program.Texts {
-		if i+j > 0 {
-			sb.WriteString("\n")
+	for ; group < len(directiveStmts); group++ {
+		for j := range conditionals {
+			conditionals[j].add(getConditionalLines(directiveStmts[group]))
 		}
-
-		emitted := emitText(text)
-		sb.WriteString(emitted)
 	}
-	return sb.String(), nil
+	for i := range conditionals {
+		if err := conditionals[i].flush(e, &outputs[i]); err != nil {
+			return nil, err
+		}
+	}
+	results := make([]string, numOutputs)
+	for i := range outputs {
+		result, err := e.finishOutput(outputs[i].sb.String(), i == 0)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
 }
 
-func (e *Emitter) emitMapScriptStatement(mapScriptStmt *ast.MapScriptsStatement) (string, error) {
-	var sb strings.Builder
-	if mapScriptStmt.Scope == token.GLOBAL {
-		sb.WriteString(fmt.Sprintf("%s::\n", mapScriptStmt.Name.Value))
-	} else {
-		sb.WriteString(fmt.Sprintf("%s:\n", mapScriptStmt.Name.Value))
+// Finishes an output, once all of the program's statements are rendered.
+// The source map and line directives are only for the first output, which
+// is written to the output file.
+func (e *Emitter) finishOutput(output string, isFirst bool) (string, error) {
+	if backend, ok := e.backend.(ProgramBackend); ok {
+		var err error
+		if output, err = backend.EndProgram(output); err != nil {
+			return "", err
+		}
 	}
-	for _, mapScript := range mapScriptStmt.MapScripts {
-		sb.WriteString(fmt.Sprintf("\tmap_script %s, %s\n", mapScript.Type, mapScript.Name))
+	if _, ok := e.backend.(*bytecodeBackend); ok {
+		// Binary output doesn't have lines.
+		return output, nil
 	}
-	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
-		sb.WriteString(fmt.Sprintf("\tmap_script %s, %s\n", tableMapScript.Type, tableMapScript.Name))
+	if e.needsSources() {
+		output = e.processSourceComments(output, isFirst)
 	}
-	sb.WriteString("\t.byte 0\n\n")
+	return e.style.applyLineEnding(output), nil
+}
 
-	for _, mapScript := range mapScriptStmt.MapScripts {
-		if mapScript.Script != nil {
-			scriptOutput, err := e.emitScriptStatement(mapScript.Script)
-			if err != nil {
-				return "", err
+func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string, error) {
+	script, err := e.LowerScript(scriptStmt)
+	if err != nil {
+		return "", err
+	}
+	var chunkIDs []int
+	if e.optimizationLevel >= LayoutOptimization {
+		chunkIDs = ir.OrderChunks(script)
+	} else {
+		chunkIDs = script.SortedChunkIDs()
+	}
+	e.checkScriptSize(scriptStmt, script, chunkIDs)
+	for _, chunk := range script.Chunks {
+		for _, command := range chunk.Commands {
+			for _, arg := range command.Args {
+				e.commandArgs[arg] = true
 			}
-			sb.WriteString(scriptOutput)
 		}
 	}
-	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
-		sb.WriteString(fmt.Sprintf("%s:\n", tableMapScript.Name))
-		for _, scriptEntry := range tableMapScript.Entries {
-			sb.WriteString(fmt.Sprintf("\tmap_script_2 %s, %s, %s\n", scriptEntry.Condition, scriptEntry.Comparison, scriptEntry.Name))
+	if e.collectStats {
+		if err := e.addScriptStats(scriptStmt, script, chunkIDs); err != nil {
+			return "", err
 		}
-		sb.WriteString("\t.2byte 0\n\n")
-		for _, scriptEntry := range tableMapScript.Entries {
-			if scriptEntry.Script != nil {
-				scriptOutput, err := e.emitScriptStatement(scriptEntry.Script)
-				if err != nil {
-					return "", err
-				}
-				sb.WriteString(scriptOutput)
-			}
+	}
+	for _, chunkID := range chunkIDs {
+		if source, ok := script.Chunks[chunkID].Source(); ok {
+			e.sourceLocations[source.String()] = source
 		}
 	}
+	e.addSymbol(script.Name, "script", scriptStmt.Scope)
+	for _, chunkID := range ir.JumpedChunks(script, chunkIDs) {
+		e.symbols = append(e.symbols, Symbol{Name: script.ChunkLabel(chunkID), Kind: "branch", Script: script.Name})
+	}
+	return e.backend.EmitScript(script, chunkIDs)
+}
 
-	return sb.String(), nil
+// LowerScript converts a script statement into its intermediate
+// representation, which is optimized at the emitter's optimization level.
+func (e *Emitter) LowerScript(scriptStmt *ast.ScriptStatement) (*ir.Script, error) {
+	return e.lowerScript(scriptStmt, e.optimizationLevel)
 }
 
-func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string, error) {
+func (e *Emitter) lowerScript(scriptStmt *ast.ScriptStatement, level OptimizationLevel) (*ir.Script, error) {
 	// The algorithm for emitting script statements is to split the scripts into
 	// self-contained chunks that logically branch to one another. When branching logic
 	// occurs, create a new chunk for any shared logic that follows the branching, as well
@@ -155,6 +565,9 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 	}
 	breakStatementReturnChunks := make(map[ast.Statement]int)
 	breakStatementOriginChunks := make(map[ast.Statement]int)
+	// Whether the conditions are simplified.
+	optimize := level >= FullOptimization
+	canUseSwitchVar := optimize && e.switchChains && !referencesSwitchVar(scriptStmt)
 	for len(remainingChunks) > 0 {
 		ids := []int{}
 		for _, c := range remainingChunks {
@@ -164,6 +577,9 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 		// Grab an unprocessed script chunk.
 		curChunk := remainingChunks[0]
 		remainingChunks = remainingChunks[1:]
+		if optimize {
+			curChunk.statements = removeDeadBranches(curChunk.statements)
+		}
 
 		// Skip over basic command statements.
 		i := 0
@@ -201,7 +617,7 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 
 		// Create new chunks from if statement blocks.
 		if stmt, ok := curChunk.statements[i].(*ast.IfStatement); ok {
-			newRemainingChunks, ifBranch := createIfStatementChunks(stmt, i, curChunk, remainingChunks, &chunkCounter)
+			newRemainingChunks, ifBranch := createIfStatementChunks(stmt, i, curChunk, remainingChunks, &chunkCounter, optimize, canUseSwitchVar)
 			remainingChunks = newRemainingChunks
 			completeChunk := &chunk{
 				id:             curChunk.id,
@@ -211,7 +627,7 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 			}
 			finalChunks[completeChunk.id] = completeChunk
 		} else if stmt, ok := curChunk.statements[i].(*ast.WhileStatement); ok {
-			newRemainingChunks, jump, returnID := createWhileStatementChunks(stmt, i, curChunk, remainingChunks, &chunkCounter)
+			newRemainingChunks, jump, returnID := createWhileStatementChunks(stmt, i, curChunk, remainingChunks, &chunkCounter, optimize)
 			remainingChunks = newRemainingChunks
 			completeChunk := &chunk{
 				id:             curChunk.id,
@@ -221,9 +637,9 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 			}
 			finalChunks[completeChunk.id] = completeChunk
 			breakStatementReturnChunks[stmt] = returnID
-			breakStatementOriginChunks[stmt] = jump.destChunkID
+			breakStatementOriginChunks[stmt] = jump.Dest
 		} else if stmt, ok := curChunk.statements[i].(*ast.DoWhileStatement); ok {
-			newRemainingChunks, jump, returnID := createDoWhileStatementChunks(stmt, i, curChunk, remainingChunks, &chunkCounter)
+			newRemainingChunks, jump, returnID := createDoWhileStatementChunks(stmt, i, curChunk, remainingChunks, &chunkCounter, optimize)
 			remainingChunks = newRemainingChunks
 			completeChunk := &chunk{
 				id:             curChunk.id,
@@ -233,29 +649,29 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 			}
 			finalChunks[completeChunk.id] = completeChunk
 			breakStatementReturnChunks[stmt] = returnID
-			breakStatementOriginChunks[stmt] = jump.destChunkID
+			breakStatementOriginChunks[stmt] = jump.Dest
 		} else if stmt, ok := curChunk.statements[i].(*ast.BreakStatement); ok {
 			destChunkID, ok := breakStatementReturnChunks[stmt.ScopeStatment]
 			if !ok {
-				return "", errors.New("could not emit 'break' statement because its return point is unknown")
+				return nil, emitErrorf("could not emit 'break' statement because its return point is unknown")
 			}
 			completeChunk := &chunk{
 				id:             curChunk.id,
 				returnID:       curChunk.returnID,
 				statements:     curChunk.statements[:i],
-				branchBehavior: &breakContext{destChunkID: destChunkID},
+				branchBehavior: getBreakBranch(destChunkID),
 			}
 			finalChunks[completeChunk.id] = completeChunk
 		} else if stmt, ok := curChunk.statements[i].(*ast.ContinueStatement); ok {
 			destChunkID, ok := breakStatementOriginChunks[stmt.LoopStatment]
 			if !ok {
-				return "", errors.New("could not emit 'continue' statement because its return point is unknown")
+				return nil, emitErrorf("could not emit 'continue' statement because its return point is unknown")
 			}
 			completeChunk := &chunk{
 				id:             curChunk.id,
 				returnID:       curChunk.returnID,
 				statements:     curChunk.statements[:i],
-				branchBehavior: &breakContext{destChunkID: destChunkID},
+				branchBehavior: getBreakBranch(destChunkID),
 			}
 			finalChunks[completeChunk.id] = completeChunk
 		} else if stmt, ok := curChunk.statements[i].(*ast.SwitchStatement); ok {
@@ -269,7 +685,7 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 			}
 			finalChunks[completeChunk.id] = completeChunk
 			breakStatementReturnChunks[stmt] = returnID
-			breakStatementOriginChunks[stmt] = jump.destChunkID
+			breakStatementOriginChunks[stmt] = jump.Dest
 		} else {
 			completeChunk := &chunk{
 				id:         curChunk.id,
@@ -280,17 +696,40 @@ func (e *Emitter) emitScriptStatement(scriptStmt *ast.ScriptStatement) (string,
 		}
 	}
 
-	return e.renderChunks(finalChunks, scriptStmt.Name.Value, scriptStmt.Scope == token.GLOBAL)
+	script := &ir.Script{
+		Name:        scriptStmt.Name.Value,
+		IsGlobal:    scriptStmt.Scope == token.GLOBAL,
+		Chunks:      make(map[int]*ir.Chunk, len(finalChunks)),
+		LabelFormat: e.labelFormat,
+	}
+	for id, c := range finalChunks {
+		irChunk, err := c.toIR(e.needsSources())
+		if err != nil {
+			return nil, err
+		}
+		script.Chunks[id] = irChunk
+	}
+	if level >= LayoutOptimization {
+		ir.Optimize(script)
+	}
+	if backend, ok := e.backend.(TailCallBackend); ok && e.tailCalls {
+		if callCommand := backend.TailCallCommand(script); callCommand != "" {
+			ir.OptimizeTailCalls(script, callCommand)
+		}
+	}
+	return script, nil
 }
 
-func createConditionDestination(destinationChunk int, operatorExpression *ast.OperatorExpression) *conditionDestination {
-	return &conditionDestination{
-		id:                 destinationChunk,
-		operatorExpression: operatorExpression,
+// Returns the branch of a break or continue statement, which returns from
+// the script if the loop was the last statement of the script.
+func getBreakBranch(destChunkID int) ir.Branch {
+	if destChunkID == -1 {
+		return &ir.Return{}
 	}
+	return &ir.Goto{Dest: destChunkID}
 }
 
-func createIfStatementChunks(stmt *ast.IfStatement, i int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int) ([]*chunk, *jump) {
+func createIfStatementChunks(stmt *ast.IfStatement, i int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int, optimize bool, canUseSwitchVar bool) ([]*chunk, *ir.Goto) {
 	remainingChunks, returnID := curChunk.splitChunkForBranch(i, chunkCounter, remainingChunks)
 
 	*chunkCounter++
@@ -324,42 +763,74 @@ func createIfStatementChunks(stmt *ast.IfStatement, i int, curChunk *chunk, rema
 		remainingChunks = append(remainingChunks, elseChunk)
 	}
 
+	if canUseSwitchVar {
+		if operand, values, ok := getSwitchComparisons(stmt); ok {
+			*chunkCounter++
+			switchChunk := &chunk{
+				id:       *chunkCounter,
+				returnID: returnID,
+			}
+			remainingChunks = append(remainingChunks, switchChunk)
+			branchBehavior := &ir.Switch{Operand: operand, Default: returnID}
+			for i, value := range values {
+				caseChunkID := consequenceChunk.id
+				if i > 0 {
+					caseChunkID = elifChunks[i-1].id
+				}
+				branchBehavior.Cases = append(branchBehavior.Cases, ir.Case{Value: value, Dest: caseChunkID})
+			}
+			if elseChunk != nil {
+				branchBehavior.Default = elseChunk.id
+			}
+			switchChunk.branchBehavior = branchBehavior
+			return remainingChunks, &ir.Goto{Dest: switchChunk.id}
+		}
+	}
+
 	// Stitch together the return ids for the cascading if statements in reverse order.
 	prevElifEntryID := -1
 	if len(elifChunks) > 0 {
 		for i := len(elifChunks) - 1; i >= 0; i-- {
 			if i == len(elifChunks)-1 {
 				if elseChunk != nil {
-					remainingChunks, _, prevElifEntryID = splitBooleanExpressionChunks(stmt.ElifConsequences[i].Expression, chunkCounter, elifChunks[i].id, elseChunk.id, remainingChunks, -1)
+					remainingChunks, _, prevElifEntryID = splitBooleanExpressionChunks(getBranchCondition(stmt.ElifConsequences[i].Expression, optimize), chunkCounter, elifChunks[i].id, elseChunk.id, remainingChunks, -1)
 				} else {
-					remainingChunks, _, prevElifEntryID = splitBooleanExpressionChunks(stmt.ElifConsequences[i].Expression, chunkCounter, elifChunks[i].id, returnID, remainingChunks, -1)
+					remainingChunks, _, prevElifEntryID = splitBooleanExpressionChunks(getBranchCondition(stmt.ElifConsequences[i].Expression, optimize), chunkCounter, elifChunks[i].id, returnID, remainingChunks, -1)
 				}
 			} else {
-				remainingChunks, _, prevElifEntryID = splitBooleanExpressionChunks(stmt.ElifConsequences[i].Expression, chunkCounter, elifChunks[i].id, prevElifEntryID, remainingChunks, -1)
+				remainingChunks, _, prevElifEntryID = splitBooleanExpressionChunks(getBranchCondition(stmt.ElifConsequences[i].Expression, optimize), chunkCounter, elifChunks[i].id, prevElifEntryID, remainingChunks, -1)
 			}
 		}
 	}
 
 	var initialEntryChunkID int
 	if len(elifChunks) > 0 {
-		remainingChunks, _, initialEntryChunkID = splitBooleanExpressionChunks(stmt.Consequence.Expression, chunkCounter, consequenceChunk.id, prevElifEntryID, remainingChunks, -1)
+		remainingChunks, _, initialEntryChunkID = splitBooleanExpressionChunks(getBranchCondition(stmt.Consequence.Expression, optimize), chunkCounter, consequenceChunk.id, prevElifEntryID, remainingChunks, -1)
 	} else if elseChunk != nil {
-		remainingChunks, _, initialEntryChunkID = splitBooleanExpressionChunks(stmt.Consequence.Expression, chunkCounter, consequenceChunk.id, elseChunk.id, remainingChunks, -1)
+		remainingChunks, _, initialEntryChunkID = splitBooleanExpressionChunks(getBranchCondition(stmt.Consequence.Expression, optimize), chunkCounter, consequenceChunk.id, elseChunk.id, remainingChunks, -1)
 	} else {
-		remainingChunks, _, initialEntryChunkID = splitBooleanExpressionChunks(stmt.Consequence.Expression, chunkCounter, consequenceChunk.id, returnID, remainingChunks, -1)
+		remainingChunks, _, initialEntryChunkID = splitBooleanExpressionChunks(getBranchCondition(stmt.Consequence.Expression, optimize), chunkCounter, consequenceChunk.id, returnID, remainingChunks, -1)
 	}
 
-	return remainingChunks, &jump{destChunkID: initialEntryChunkID}
+	return remainingChunks, &ir.Goto{Dest: initialEntryChunkID}
 }
 
 func splitBooleanExpressionChunks(expression ast.BooleanExpression, chunkCounter *int, successChunkID int, failureChunkID int, remainingChunks []*chunk, firstID int) ([]*chunk, *chunk, int) {
 	if operatorExpression, ok := expression.(*ast.OperatorExpression); ok {
-		dest := createConditionDestination(successChunkID, operatorExpression)
 		*chunkCounter++
 		newChunk := &chunk{
-			id:             *chunkCounter,
-			statements:     []ast.Statement{},
-			branchBehavior: &leafExpressionBranch{truthyDest: dest, falseyReturnID: failureChunkID},
+			id:         *chunkCounter,
+			statements: []ast.Statement{},
+			branchBehavior: &ir.Condition{
+				Comparison: ir.Comparison{
+					Type:     operatorExpression.Type,
+					Operand:  operatorExpression.Operand,
+					Operator: operatorExpression.Operator,
+					Value:    operatorExpression.ComparisonValue,
+				},
+				Dest: successChunkID,
+				Else: failureChunkID,
+			},
 		}
 		remainingChunks = append(remainingChunks, newChunk)
 		if firstID == -1 {
@@ -379,7 +850,7 @@ func splitBooleanExpressionChunks(expression ast.BooleanExpression, chunkCounter
 			var leftLink *chunk
 			remainingChunks, leftLink, firstID = splitBooleanExpressionChunks(binaryExpression.Left, chunkCounter, successChunk.id, failureChunkID, remainingChunks, firstID)
 			remainingChunks, linkChunk, firstID = splitBooleanExpressionChunks(binaryExpression.Right, chunkCounter, successChunkID, failureChunkID, remainingChunks, firstID)
-			successChunk.branchBehavior = &jump{destChunkID: linkChunk.id}
+			successChunk.branchBehavior = &ir.Goto{Dest: linkChunk.id}
 			remainingChunks = append(remainingChunks, successChunk)
 			return remainingChunks, leftLink, firstID
 		} else if binaryExpression.Operator == token.OR {
@@ -392,7 +863,7 @@ func splitBooleanExpressionChunks(expression ast.BooleanExpression, chunkCounter
 			var leftLink *chunk
 			remainingChunks, leftLink, firstID = splitBooleanExpressionChunks(binaryExpression.Left, chunkCounter, successChunkID, failChunk.id, remainingChunks, firstID)
 			remainingChunks, linkChunk, firstID = splitBooleanExpressionChunks(binaryExpression.Right, chunkCounter, successChunkID, failureChunkID, remainingChunks, firstID)
-			failChunk.branchBehavior = &jump{destChunkID: linkChunk.id}
+			failChunk.branchBehavior = &ir.Goto{Dest: linkChunk.id}
 			remainingChunks = append(remainingChunks, failChunk)
 			return remainingChunks, leftLink, firstID
 		}
@@ -401,7 +872,7 @@ func splitBooleanExpressionChunks(expression ast.BooleanExpression, chunkCounter
 	return remainingChunks, nil, firstID
 }
 
-func createWhileStatementChunks(stmt *ast.WhileStatement, i int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int) ([]*chunk, *jump, int) {
+func createWhileStatementChunks(stmt *ast.WhileStatement, i int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int, optimize bool) ([]*chunk, *ir.Goto, int) {
 
 	remainingChunks, returnID := curChunk.splitChunkForBranch(i, chunkCounter, remainingChunks)
 
@@ -420,15 +891,15 @@ func createWhileStatementChunks(stmt *ast.WhileStatement, i int, curChunk *chunk
 	}
 
 	var entryChunkID int
-	remainingChunks, _, entryChunkID = splitBooleanExpressionChunks(stmt.Consequence.Expression, chunkCounter, consequenceChunk.id, returnID, remainingChunks, -1)
-	headerChunk.branchBehavior = &jump{destChunkID: entryChunkID}
+	remainingChunks, _, entryChunkID = splitBooleanExpressionChunks(getBranchCondition(stmt.Consequence.Expression, optimize), chunkCounter, consequenceChunk.id, returnID, remainingChunks, -1)
+	headerChunk.branchBehavior = &ir.Goto{Dest: entryChunkID}
 	remainingChunks = append(remainingChunks, consequenceChunk)
 	remainingChunks = append(remainingChunks, headerChunk)
 
-	return remainingChunks, &jump{destChunkID: headerChunk.id}, returnID
+	return remainingChunks, &ir.Goto{Dest: headerChunk.id}, returnID
 }
 
-func createDoWhileStatementChunks(stmt *ast.DoWhileStatement, i int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int) ([]*chunk, *jump, int) {
+func createDoWhileStatementChunks(stmt *ast.DoWhileStatement, i int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int, optimize bool) ([]*chunk, *ir.Goto, int) {
 	remainingChunks, returnID := curChunk.splitChunkForBranch(i, chunkCounter, remainingChunks)
 
 	*chunkCounter++
@@ -446,15 +917,15 @@ func createDoWhileStatementChunks(stmt *ast.DoWhileStatement, i int, curChunk *c
 	}
 
 	var entryChunkID int
-	remainingChunks, _, entryChunkID = splitBooleanExpressionChunks(stmt.Consequence.Expression, chunkCounter, consequenceChunk.id, returnID, remainingChunks, -1)
-	headerChunk.branchBehavior = &jump{destChunkID: entryChunkID}
+	remainingChunks, _, entryChunkID = splitBooleanExpressionChunks(getBranchCondition(stmt.Consequence.Expression, optimize), chunkCounter, consequenceChunk.id, returnID, remainingChunks, -1)
+	headerChunk.branchBehavior = &ir.Goto{Dest: entryChunkID}
 	remainingChunks = append(remainingChunks, consequenceChunk)
 	remainingChunks = append(remainingChunks, headerChunk)
 
-	return remainingChunks, &jump{destChunkID: consequenceChunk.id}, returnID
+	return remainingChunks, &ir.Goto{Dest: consequenceChunk.id}, returnID
 }
 
-func createSwitchStatementChunks(stmt *ast.SwitchStatement, statementIndex int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int) ([]*chunk, *jump, int) {
+func createSwitchStatementChunks(stmt *ast.SwitchStatement, statementIndex int, curChunk *chunk, remainingChunks []*chunk, chunkCounter *int) ([]*chunk, *ir.Goto, int) {
 	remainingChunks, returnID := curChunk.splitChunkForBranch(statementIndex, chunkCounter, remainingChunks)
 
 	*chunkCounter++
@@ -464,10 +935,9 @@ func createSwitchStatementChunks(stmt *ast.SwitchStatement, statementIndex int,
 	}
 	remainingChunks = append(remainingChunks, switchChunk)
 
-	branchBehavior := &switchBranch{operand: stmt.Operand}
-	branchCases := []*switchCaseBranch{}
+	branchBehavior := &ir.Switch{Operand: stmt.Operand, Default: returnID}
+	branchCases := []ir.Case{}
 	i := 0
-	processedDefaultCase := false
 	for i < len(stmt.Cases) {
 		switchCase := stmt.Cases[i]
 		destChunkID := -1
@@ -481,11 +951,7 @@ func createSwitchStatementChunks(stmt *ast.SwitchStatement, statementIndex int,
 			remainingChunks = append(remainingChunks, caseChunk)
 			destChunkID = caseChunk.id
 			if switchCase.IsDefault {
-				branchBehavior.defaultCase = &switchCaseBranch{
-					comparisonValue: stmt.DefaultCase.Value,
-					destChunkID:     caseChunk.id,
-				}
-				processedDefaultCase = true
+				branchBehavior.Default = caseChunk.id
 			}
 		} else {
 			// Scan forward for the shared case body.
@@ -500,11 +966,7 @@ func createSwitchStatementChunks(stmt *ast.SwitchStatement, statementIndex int,
 					remainingChunks = append(remainingChunks, caseChunk)
 					destChunkID = caseChunk.id
 					if stmt.Cases[j].IsDefault {
-						branchBehavior.defaultCase = &switchCaseBranch{
-							comparisonValue: stmt.DefaultCase.Value,
-							destChunkID:     caseChunk.id,
-						}
-						processedDefaultCase = true
+						branchBehavior.Default = caseChunk.id
 					}
 
 					// Apply this chunk body to all of the previous shared cases.
@@ -516,15 +978,11 @@ func createSwitchStatementChunks(stmt *ast.SwitchStatement, statementIndex int,
 								statements: stmt.Cases[j].Body.Statements,
 							}
 							remainingChunks = append(remainingChunks, defaultChunk)
-							branchBehavior.defaultCase = &switchCaseBranch{
-								comparisonValue: stmt.DefaultCase.Value,
-								destChunkID:     destChunkID,
-							}
-							processedDefaultCase = true
+							branchBehavior.Default = destChunkID
 						} else {
-							branchCases = append(branchCases, &switchCaseBranch{
-								comparisonValue: stmt.Cases[i].Value,
-								destChunkID:     destChunkID,
+							branchCases = append(branchCases, ir.Case{
+								Value: stmt.Cases[i].Value,
+								Dest:  destChunkID,
 							})
 						}
 						i++
@@ -534,182 +992,15 @@ func createSwitchStatementChunks(stmt *ast.SwitchStatement, statementIndex int,
 			}
 		}
 		if destChunkID != -1 && !stmt.Cases[i].IsDefault {
-			branchCases = append(branchCases, &switchCaseBranch{
-				comparisonValue: stmt.Cases[i].Value,
-				destChunkID:     destChunkID,
+			branchCases = append(branchCases, ir.Case{
+				Value: stmt.Cases[i].Value,
+				Dest:  destChunkID,
 			})
 		}
 		i++
 	}
 
-	branchBehavior.cases = branchCases
-	if !processedDefaultCase {
-		branchBehavior.destChunkID = returnID
-	}
+	branchBehavior.Cases = branchCases
 	switchChunk.branchBehavior = branchBehavior
-	return remainingChunks, &jump{destChunkID: switchChunk.id}, returnID
-}
-
-func (e *Emitter) renderChunks(chunks map[int]*chunk, scriptName string, isGlobal bool) (string, error) {
-	// Get sorted list of final chunk ids.
-	var chunkIDs []int
-	if e.optimize {
-		chunkIDs = optimizeChunkOrder(chunks)
-	} else {
-		chunkIDs = make([]int, 0)
-		for k := range chunks {
-			chunkIDs = append(chunkIDs, k)
-		}
-		sort.Ints(chunkIDs)
-	}
-
-	// First, render the bodies of each chunk. We'll
-	// render the actual chunk labels after, since there is
-	// an opportunity to skip renering unnecessary labels.
-	var nextChunkID int
-	chunkBodies := make(map[int]*strings.Builder)
-	jumpChunks := make(map[int]bool)
-	registerJumpChunk := func(chunkID int) {
-		jumpChunks[chunkID] = true
-	}
-	for i, chunkID := range chunkIDs {
-		var sb strings.Builder
-		chunkBodies[chunkID] = &sb
-		if i < len(chunkIDs)-1 {
-			nextChunkID = chunkIDs[i+1]
-		} else {
-			nextChunkID = -1
-		}
-		chunk := chunks[chunkID]
-		err := chunk.renderStatements(&sb)
-		if err != nil {
-			return "", err
-		}
-		isFallThrough := chunk.renderBranching(scriptName, &sb, nextChunkID, registerJumpChunk)
-		if !isFallThrough {
-			sb.WriteString("\n")
-		}
-	}
-
-	// Render the labels of each chunk, followed by its body.
-	// A label doesn't need to be rendered if nothing ever jumps
-	// to it.
-	var sb strings.Builder
-	for _, chunkID := range chunkIDs {
-		chunk := chunks[chunkID]
-		if chunkID == 0 || jumpChunks[chunkID] {
-			chunk.renderLabel(scriptName, isGlobal, &sb)
-		}
-		sb.WriteString(chunkBodies[chunkID].String())
-	}
-
-	return sb.String(), nil
-}
-
-// Reorders chunks to take advantage of fall-throughs, rather than using
-// unncessary wasteful "goto" commands.
-func optimizeChunkOrder(chunks map[int]*chunk) []int {
-	unvisited := make(map[int]bool)
-	for k := range chunks {
-		unvisited[k] = true
-	}
-
-	chunkIDs := make([]int, 0)
-	if len(chunks) == 0 {
-		return chunkIDs
-	}
-
-	chunkIDs = append(chunkIDs, 0)
-	delete(unvisited, 0)
-	i := 1
-	for len(chunkIDs) < len(chunks) {
-		curChunk := chunks[chunkIDs[len(chunkIDs)-1]]
-		var nextChunkID int
-		if curChunk.branchBehavior != nil {
-			nextChunkID = curChunk.branchBehavior.getTailChunkID()
-		} else {
-			nextChunkID = curChunk.returnID
-		}
-
-		if nextChunkID != -1 {
-			if _, ok := unvisited[nextChunkID]; ok {
-				chunkIDs = append(chunkIDs, nextChunkID)
-				delete(unvisited, nextChunkID)
-				continue
-			}
-		}
-
-		// Choose random unvisited chunk for the next one.
-		for i < len(chunks) {
-			_, ok := unvisited[i]
-			if ok {
-				chunkIDs = append(chunkIDs, i)
-				delete(unvisited, i)
-				break
-			}
-			i++
-		}
-	}
-	return chunkIDs
-}
-
-func emitText(text ast.Text) string {
-	var sb strings.Builder
-	if text.IsGlobal {
-		sb.WriteString(fmt.Sprintf("%s::\n", text.Name))
-	} else {
-		sb.WriteString(fmt.Sprintf("%s:\n", text.Name))
-	}
-	lines := strings.Split(text.Value, "\n")
-	for _, line := range lines {
-		directive := "string"
-		if len(text.StringType) > 0 {
-			directive = text.StringType
-		}
-		sb.WriteString(fmt.Sprintf("\t.%s \"%s\"\n", directive, line))
-	}
-	return sb.String()
-}
-
-func emitRawStatement(rawStmt *ast.RawStatement) string {
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("%s\n", rawStmt.Value))
-	return sb.String()
-}
-
-func emitMovementStatement(movementStmt *ast.MovementStatement) string {
-	terminator := "step_end"
-	var sb strings.Builder
-	if movementStmt.Scope == token.GLOBAL {
-		sb.WriteString(fmt.Sprintf("%s::\n", movementStmt.Name.Value))
-	} else {
-		sb.WriteString(fmt.Sprintf("%s:\n", movementStmt.Name.Value))
-	}
-	for _, cmd := range movementStmt.MovementCommands {
-		sb.WriteString(fmt.Sprintf("\t%s\n", cmd))
-		if cmd == terminator {
-			return sb.String()
-		}
-	}
-	sb.WriteString(fmt.Sprintf("\t%s\n", terminator))
-	return sb.String()
-}
-
-func emitMartStatement(martStmt *ast.MartStatement) string {
-	terminator := "ITEM_NONE"
-	var sb strings.Builder
-	sb.WriteString("\t.align 2\n")
-	if martStmt.Scope == token.GLOBAL {
-		sb.WriteString(fmt.Sprintf("%s::\n", martStmt.Name.Value))
-	} else {
-		sb.WriteString(fmt.Sprintf("%s:\n", martStmt.Name.Value))
-	}
-	for _, item := range martStmt.MartItems {
-		if item == terminator {
-			break
-		}
-		sb.WriteString(fmt.Sprintf("\t.2byte %s\n", item))
-	}
-	sb.WriteString("\t.2byte ITEM_NONE\n\trelease\n\tend\n")
-	return sb.String()
+	return remainingChunks, &ir.Goto{Dest: switchChunk.id}, returnID
 }
@@ -1,8 +1,13 @@
 package emitter
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
 	"github.com/huderlem/poryscript/lexer"
 	"github.com/huderlem/poryscript/parser"
 )
@@ -194,7 +199,6 @@ Route29_EventScript_Dude_Text_1:
 	compare VAR_0x8002, TIME_NIGHT
 	goto_if_eq Route29_EventScript_WaitingMan_2
 	msgbox Route29_EventScript_WaitingMan_Text_1
-Route29_EventScript_WaitingMan_1:
 Route29_EventScript_WaitingMan_6:
 	compare VAR_0x8002, TIME_NIGHT
 	goto_if_eq Route29_EventScript_WaitingMan_7
@@ -203,7 +207,7 @@ Route29_EventScript_WaitingMan_6:
 
 Route29_EventScript_WaitingMan_2:
 	msgbox Route29_EventScript_WaitingMan_Text_0
-	goto Route29_EventScript_WaitingMan_1
+	goto Route29_EventScript_WaitingMan_6
 
 Route29_EventScript_WaitingMan_7:
 	advancetime 5
@@ -485,13 +489,10 @@ MyScript_7:
 	last
 	goto_if_unset FLAG_2, MyScript_7
 MyScript_5:
-	goto_if_set FLAG_3, MyScript_10
+	goto_if_set FLAG_3, MyScript_2
 	lastinwhile
 	goto MyScript_2
 
-MyScript_10:
-	goto MyScript_2
-
 MyScript_13:
 	stuff
 	before
@@ -519,6 +520,325 @@ MyScript_13:
 	}
 }
 
+func TestEmitConstantConditions(t *testing.T) {
+	input := `
+const DEBUG = FALSE
+const LEVEL = 2
+script MyScript {
+	if (var(DEBUG) == TRUE) {
+		debugstuff
+		msgbox("Debug text")
+	}
+	if (var(LEVEL) == 1) {
+		one
+	} elif (flag(FLAG_1) && var(LEVEL) >= 2) {
+		two
+	} elif (var(LEVEL) == 2) {
+		exactlytwo
+	} else {
+		other
+	}
+	while (var(DEBUG) && flag(FLAG_2)) {
+		loop
+	}
+	if (var(LEVEL) < 3 || flag(FLAG_3)) {
+		always
+		if (var(LEVEL) != 2) {
+			never
+		}
+	} else {
+		neverelse
+	}
+	if (var(1) > -1) {
+		wrapsaround
+	}
+	if (var(5) == 0x4001) {
+		readsvar
+	}
+	if (var(5) < 0x4000) {
+		alwaysless
+	}
+	release
+}
+`
+
+	// Negative comparison values wrap around to unsigned 16-bit values, and
+	// values of at least 0x4000 are read from vars, so they aren't known.
+	// The text of the branch that is left out isn't emitted.
+	expectedOptimized := `MyScript::
+	goto_if_set FLAG_1, MyScript_2
+	exactlytwo
+MyScript_1:
+	always
+	compare 1, -1
+	goto_if_le MyScript_5
+	wrapsaround
+MyScript_5:
+	compare 5, 0x4001
+	goto_if_ne MyScript_8
+	readsvar
+MyScript_8:
+	compare 5, 0x4000
+	goto_if_ge MyScript_11
+	alwaysless
+MyScript_11:
+	release
+	return
+
+MyScript_2:
+	two
+	goto MyScript_1
+
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	result, _ := e.Emit()
+	if result != expectedOptimized {
+		t.Errorf("Mismatching optimized emit -- Expected=%q, Got=%q", expectedOptimized, result)
+	}
+
+	// Unoptimized output keeps all of the comparisons.
+	e = New(program, false)
+	result, _ = e.Emit()
+	if !strings.Contains(result, "debugstuff") || !strings.Contains(result, "neverelse") || !strings.Contains(result, "Debug text") {
+		t.Errorf("Expected unoptimized emit to keep constant branches, but got %q", result)
+	}
+}
+
+func TestEmitInlinedBranchTargets(t *testing.T) {
+	input := `
+script MyScript {
+	if (var(VAR_1) < 5) {
+		less
+	}
+	if (defeated(TRAINER_BLUE)) {
+		beaten
+	}
+	if (!flag(FLAG_1)) {
+		unset
+	}
+	if (var(VAR_2) == 1 || var(VAR_3) >= 2) {
+		either
+	}
+	release
+}
+`
+
+	expectedOptimized := `MyScript::
+	compare VAR_1, 5
+	goto_if_ge MyScript_1
+	less
+MyScript_1:
+	checktrainerflag TRAINER_BLUE
+	goto_if 0, MyScript_4
+	beaten
+MyScript_4:
+	goto_if_set FLAG_1, MyScript_7
+	unset
+MyScript_7:
+	compare VAR_2, 1
+	goto_if_eq MyScript_11
+	compare VAR_3, 2
+	goto_if_lt MyScript_10
+MyScript_11:
+	either
+MyScript_10:
+	release
+	return
+
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	result, _ := e.Emit()
+	if result != expectedOptimized {
+		t.Errorf("Mismatching optimized emit -- Expected=%q, Got=%q", expectedOptimized, result)
+	}
+}
+
+func TestEmitSimplifiedBooleanExpressions(t *testing.T) {
+	input := `
+script MyScript {
+	if (var(VAR_1) == 1 && flag(FLAG_1) && var(VAR_1) == 1) {
+		first
+	}
+	while (defeated(TRAINER_1) || (var(VAR_2) > 3 || flag(FLAG_2))) {
+		second
+	}
+	release
+}
+`
+
+	expectedOptimized := `MyScript::
+	goto_if_set FLAG_1, MyScript_3
+MyScript_7:
+	goto_if_set FLAG_2, MyScript_8
+	checktrainerflag TRAINER_1
+	goto_if 1, MyScript_8
+	compare VAR_2, 3
+	goto_if_gt MyScript_8
+	release
+	return
+
+MyScript_3:
+	compare VAR_1, 1
+	goto_if_ne MyScript_7
+	first
+	goto MyScript_7
+
+MyScript_8:
+	second
+	goto MyScript_7
+
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	result, _ := e.Emit()
+	if result != expectedOptimized {
+		t.Errorf("Mismatching optimized emit -- Expected=%q, Got=%q", expectedOptimized, result)
+	}
+}
+
+func TestEmitIfStatementsAsSwitch(t *testing.T) {
+	input := `
+script MyScript {
+	if (var(VAR_RESULT) == 1) {
+		one
+	} elif (var(VAR_RESULT) == 2) {
+		two
+	} elif (var(VAR_RESULT) == 3) {
+		three
+	} else {
+		other
+	}
+	if (var(VAR_1) == 1) {
+		one
+	} elif (var(VAR_1) == 2) {
+		two
+	} elif (var(VAR_1) == 3) {
+		three
+	}
+	release
+}
+
+script MyScript2 {
+	setvar(VAR_0x8000, 4)
+	if (var(VAR_RESULT) == 1) {
+		one
+	} elif (var(VAR_RESULT) == 2) {
+		two
+	} elif (var(VAR_RESULT) == 3) {
+		three
+	}
+	release
+}
+`
+
+	expectedOptimized := `MyScript::
+	switch VAR_RESULT
+	case 1, MyScript_2
+	case 2, MyScript_3
+	case 3, MyScript_4
+	other
+MyScript_1:
+	switch VAR_1
+	case 1, MyScript_8
+	case 2, MyScript_9
+	case 3, MyScript_10
+MyScript_7:
+	release
+	return
+
+MyScript_2:
+	one
+	goto MyScript_1
+
+MyScript_3:
+	two
+	goto MyScript_1
+
+MyScript_4:
+	three
+	goto MyScript_1
+
+MyScript_8:
+	one
+	goto MyScript_7
+
+MyScript_9:
+	two
+	goto MyScript_7
+
+MyScript_10:
+	three
+	goto MyScript_7
+
+
+MyScript2::
+	setvar VAR_0x8000, 4
+	compare VAR_RESULT, 1
+	goto_if_eq MyScript2_2
+	compare VAR_RESULT, 2
+	goto_if_eq MyScript2_3
+	compare VAR_RESULT, 3
+	goto_if_ne MyScript2_1
+	three
+MyScript2_1:
+	release
+	return
+
+MyScript2_2:
+	one
+	goto MyScript2_1
+
+MyScript2_3:
+	two
+	goto MyScript2_1
+
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	e.SetSwitchChains(true)
+	result, _ := e.Emit()
+	if result != expectedOptimized {
+		t.Errorf("Mismatching optimized emit -- Expected=%q, Got=%q", expectedOptimized, result)
+	}
+
+	// Switch statements overwrite VAR_0x8000, so they aren't used by default.
+	e = New(program, true)
+	result, _ = e.Emit()
+	if strings.Contains(result, "switch") {
+		t.Errorf("Expected no switch statements by default, Got=%q", result)
+	}
+}
+
 func TestEmitCompoundBooleanExpressions(t *testing.T) {
 	input := `
 const OTHER_TRAINER = TRAINER_FOO
@@ -656,22 +976,18 @@ MyScript_4:
 	goto_if_eq MyScript_3
 	goto MyScript_1
 
-MyScript_12:
-	hey
-	goto MyScript_11
-
 MyScript_15:
 	compare VAR_55, 5
-	goto_if_le MyScript_12
+	goto_if_gt MyScript_11
+MyScript_12:
+	hey
 	goto MyScript_11
 
-MyScript_18:
-	baz -24, 17
-	goto MyScript_2
-
 MyScript_21:
 	checktrainerflag TRAINER_FOO
-	goto_if 1, MyScript_18
+	goto_if 0, MyScript_2
+MyScript_18:
+	baz -24, 17
 	goto MyScript_2
 
 `
@@ -976,14 +1292,12 @@ MyScript_1:
 	release
 	return
 
-MyScript_2:
-	dostuff
-	goto MyScript_1
-
 MyScript_3:
 	goto_if_unset FLAG_2, MyScript_2
 	compare VAR_1, 0
-	goto_if_eq MyScript_2
+	goto_if_ne MyScript_1
+MyScript_2:
+	dostuff
 	goto MyScript_1
 
 
@@ -995,13 +1309,11 @@ MyScript2_1:
 	release
 	return
 
-MyScript2_2:
-	dostuff
-	goto MyScript2_1
-
 MyScript2_5:
 	compare VAR_1, 3
-	goto_if_le MyScript2_2
+	goto_if_gt MyScript2_1
+MyScript2_2:
+	dostuff
 	goto MyScript2_1
 
 
@@ -1011,61 +1323,49 @@ MyScript3::
 	compare VAR_2, 3
 	goto_if_lt MyScript3_2
 	checktrainerflag TRAINER_1
-	goto_if 1, MyScript3_2
+	goto_if 0, MyScript3_1
+MyScript3_2:
+	dostuff
 MyScript3_1:
 	release
 	return
 
-MyScript3_2:
-	dostuff
-	goto MyScript3_1
-
 
 MyScript4::
-	goto_if_set FLAG_1, MyScript4_3
+	goto_if_set FLAG_1, MyScript4_4
 MyScript4_1:
 	release
 	return
 
-MyScript4_2:
-	dostuff
-	goto MyScript4_1
-
-MyScript4_3:
-	compare VAR_1, 1
-	goto_if_ne MyScript4_5
-	compare VAR_2, 2
-	goto_if_ne MyScript4_5
-	goto MyScript4_1
-
-MyScript4_5:
+MyScript4_4:
 	compare VAR_3, 3
+	goto_if_eq MyScript4_1
+	compare VAR_1, 1
 	goto_if_ne MyScript4_2
+	compare VAR_2, 2
+	goto_if_eq MyScript4_1
+MyScript4_2:
+	dostuff
 	goto MyScript4_1
 
 
 MyScript5::
 	goto_if_set FLAG_1, MyScript5_2
 	compare VAR_1, 1
-	goto_if_ne MyScript5_5
+	goto_if_ne MyScript5_6
 MyScript5_1:
 	release
 	return
 
-MyScript5_2:
-	dostuff
-	goto MyScript5_1
-
-MyScript5_5:
+MyScript5_6:
+	checktrainerflag TRAINER_1
+	goto_if 0, MyScript5_1
 	compare VAR_2, 2
-	goto_if_ne MyScript5_7
+	goto_if_ne MyScript5_2
 	compare VAR_3, 3
-	goto_if_ne MyScript5_7
-	goto MyScript5_1
-
-MyScript5_7:
-	checktrainerflag TRAINER_1
-	goto_if 1, MyScript5_2
+	goto_if_eq MyScript5_1
+MyScript5_2:
+	dostuff
 	goto MyScript5_1
 
 
@@ -1075,16 +1375,14 @@ MyScript6_1:
 	release
 	return
 
-MyScript6_2:
-	dostuff
-	goto MyScript6_1
-
 MyScript6_3:
 	goto_if_unset FLAG_2, MyScript6_2
 	compare VAR_1, 0
 	goto_if_ne MyScript6_2
 	compare VAR_4, 0
-	goto_if_ne MyScript6_2
+	goto_if_eq MyScript6_1
+MyScript6_2:
+	dostuff
 	goto MyScript6_1
 
 `
@@ -1250,7 +1548,8 @@ MyScript_5:
 	goto MyScript_2
 
 MyScript_8:
-	goto_if_set FLAG_1, MyScript_12
+	goto_if_unset FLAG_1, MyScript_11
+	delay 5
 MyScript_11:
 	message0
 	goto MyScript_5
@@ -1267,19 +1566,12 @@ MyScript_10:
 	messagedefault
 	goto MyScript_5
 
-MyScript_12:
-	delay 5
-	goto MyScript_11
-
 MyScript_15:
 	secondfirst
-	goto_if_unset FLAG_TEMP_1, MyScript_18
+	goto_if_unset FLAG_TEMP_1, MyScript_5
 	foo
 	goto MyScript_5
 
-MyScript_18:
-	goto MyScript_5
-
 `
 	l := lexer.New(input)
 	p := parser.New(l, "", nil)
@@ -1649,15 +1941,12 @@ MyMovement0:
 
 PetalburgCity_MapScripts_OnResume::
 	lock
-	goto_if_set FLAG_1, PetalburgCity_MapScripts_OnResume_2
+	goto_if_unset FLAG_1, PetalburgCity_MapScripts_OnResume_1
+	setvar VAR_TEMP_1, 1
 PetalburgCity_MapScripts_OnResume_1:
 	release
 	return
 
-PetalburgCity_MapScripts_OnResume_2:
-	setvar VAR_TEMP_1, 1
-	goto PetalburgCity_MapScripts_OnResume_1
-
 
 PetalburgCity_MapScripts_MAP_SCRIPT_ON_FRAME_TABLE_1_Text_0:
 	.string "Haha it worked! This should make writing\n"
@@ -2182,16 +2471,13 @@ MapScripts_MAP_SCRIPT_ON_FRAME_TABLE_1:
 
 MyScript::
 	lock
-	goto_if_set FLAG_TEST, MyScript_2
+	goto_if_unset FLAG_TEST, MyScript_1
+	msgbox MyScript_Text_0
 MyScript_1:
 	msgbox MyScript_Text_1
 	release
 	return
 
-MyScript_2:
-	msgbox MyScript_Text_0
-	goto MyScript_1
-
 
 MyMovement:
 	face_up
@@ -2236,16 +2522,13 @@ MapScripts_MAP_SCRIPT_ON_FRAME_TABLE_1:
 
 MyScript::
 	lock
-	goto_if_set FLAG_TEST, MyScript_2
+	goto_if_unset FLAG_TEST, MyScript_1
+	msgbox MyScript_Text_0
 MyScript_1:
 	msgbox MyScript_Text_1
 	release
 	return
 
-MyScript_2:
-	msgbox MyScript_Text_0
-	goto MyScript_1
-
 
 MyMovement:
 	face_up
@@ -2425,3 +2708,2456 @@ Route29_Text_Dude_CatchingTutRejected:
 	})
 	benchResult = result
 }
+
+func TestEmitDirectiveStatements(t *testing.T) {
+	input := `
+directive ` + "`" + `.include "constants/flags.inc"` + "`" + `
+directive "#ifdef FIRERED"
+script MyScript {
+	lock
+	release
+}
+directive ` + "`" + `
+#else
+	.set MY_VALUE, 2
+` + "`" + `
+directive "#endif"
+`
+
+	expected := `.include "constants/flags.inc"
+
+#ifdef FIRERED
+
+MyScript::
+	lock
+	release
+	return
+
+
+#else
+.set MY_VALUE, 2
+
+#endif
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, false)
+	result, _ := e.Emit()
+	if result != expected {
+		t.Errorf("Mismatching unoptimized emit -- Expected=%q, Got=%q", expected, result)
+	}
+
+	e = New(program, true)
+	result, _ = e.Emit()
+	if result != expected {
+		t.Errorf("Mismatching optimized emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitDirectiveRegions(t *testing.T) {
+	input := `
+script Shared {
+	msgbox("Shared")
+}
+directive "#ifdef FIRERED"
+script MyScript {
+	msgbox("FireRed")
+	msgbox("Both")
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+}
+movement MyMovement {
+	walk_up
+}
+directive ` + "`" + `
+#else
+	.set MY_VALUE, 2
+` + "`" + `
+script MyScript2 {
+	msgbox("Both")
+}
+directive "#endif"
+directive "#ifdef DEBUG"
+raw ` + "`" + `
+	.byte 1
+` + "`" + `
+directive "#endif"
+`
+
+	expected := `Shared::
+	msgbox Shared_Text_0
+	return
+
+
+#ifdef FIRERED
+
+MyScript::
+	msgbox MyScript_Text_0
+	msgbox MyScript_Text_1
+	applymovement OBJ_EVENT_ID_PLAYER, MyMovement
+	return
+
+
+MyMovement:
+	walk_up
+	step_end
+
+#else
+.set MY_VALUE, 2
+
+MyScript2::
+	msgbox MyScript_Text_1
+	return
+
+
+#endif
+
+#ifdef DEBUG
+
+	.byte 1
+
+#endif
+
+Shared_Text_0:
+	.string "Shared$"
+
+#ifdef FIRERED
+
+MyScript_Text_0:
+	.string "FireRed$"
+
+#endif
+
+MyScript_Text_1:
+	.string "Both$"
+`
+
+	expectedScripts := `Shared::
+	msgbox Shared_Text_0
+	return
+
+
+#ifdef FIRERED
+
+MyScript::
+	msgbox MyScript_Text_0
+	msgbox MyScript_Text_1
+	applymovement OBJ_EVENT_ID_PLAYER, MyMovement
+	return
+
+
+#else
+.set MY_VALUE, 2
+
+MyScript2::
+	msgbox MyScript_Text_1
+	return
+
+
+#endif
+
+#ifdef DEBUG
+
+	.byte 1
+
+#endif
+`
+
+	expectedData := `#ifdef FIRERED
+
+MyMovement:
+	walk_up
+	step_end
+
+#endif
+
+Shared_Text_0:
+	.string "Shared$"
+
+#ifdef FIRERED
+
+MyScript_Text_0:
+	.string "FireRed$"
+
+#endif
+
+MyScript_Text_1:
+	.string "Both$"
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	result, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching emit -- Expected=%q, Got=%q", expected, result)
+	}
+
+	scripts, data, err := e.EmitSplit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if scripts != expectedScripts {
+		t.Errorf("Mismatching split scripts -- Expected=%q, Got=%q", expectedScripts, scripts)
+	}
+	if data != expectedData {
+		t.Errorf("Mismatching split data -- Expected=%q, Got=%q", expectedData, data)
+	}
+}
+
+func TestEmitAlignAnnotations(t *testing.T) {
+	input := `
+@align(2)
+script MyScript {
+	lock
+}
+
+@align(4)
+mart MyMart {
+	ITEM_POTION
+}
+
+@align(2)
+text MyText {
+	"Hello"
+}
+`
+
+	expected := `	.align 2
+MyScript::
+	lock
+	return
+
+
+	.align 4
+MyMart:
+	.2byte ITEM_POTION
+	.2byte ITEM_NONE
+	release
+	end
+
+	.align 2
+MyText::
+	.string "Hello$"
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	result, _ := e.Emit()
+	if result != expected {
+		t.Errorf("Mismatching emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitParamCalls(t *testing.T) {
+	input := `
+script Caller {
+	lock
+	call GiveItems(ITEM_POTION, 2)
+	release
+}
+
+script GiveItems(item, count) {
+	giveitem(item, count)
+}
+`
+
+	expected := `Caller::
+	lock
+	setvar VAR_0x8000, ITEM_POTION
+	setvar VAR_0x8001, 2
+	call GiveItems
+	release
+	return
+
+
+GiveItems::
+	giveitem VAR_0x8000, VAR_0x8001
+	return
+
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	result, _ := e.Emit()
+	if result != expected {
+		t.Errorf("Mismatching emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitTextTemplates(t *testing.T) {
+	input := `
+texttemplate Obtained(item) = "{PLAYER} obtained {item}!"
+texttemplate Sign(line1, line2) = ascii"{line1}\n{line2}"
+
+script MyScript {
+	msgbox(Obtained("POTION"))
+	msgbox(Obtained("ANTIDOTE"))
+	msgbox(Obtained("POTION"))
+}
+
+text MySign {
+	Sign("ROUTE 101", "OLDALE TOWN")
+}
+`
+
+	expected := `MyScript::
+	msgbox MyScript_Text_0
+	msgbox MyScript_Text_1
+	msgbox MyScript_Text_0
+	return
+
+
+MyScript_Text_0:
+	.string "{PLAYER} obtained POTION!$"
+
+MyScript_Text_1:
+	.string "{PLAYER} obtained ANTIDOTE!$"
+
+MySign::
+	.ascii "ROUTE 101\nOLDALE TOWN\0"
+`
+
+	l := lexer.New(input)
+	p := parser.New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := New(program, true)
+	result, _ := e.Emit()
+	if result != expected {
+		t.Errorf("Mismatching emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitDecodedAST(t *testing.T) {
+	input := `
+raw ` + "`" + `
+	.set LOCALID_NURSE, 1
+` + "`" + `
+directive "#ifdef FIRERED"
+
+mapscripts MyMap_MapScripts {
+	MAP_SCRIPT_ON_TRANSITION {
+		setflag(FLAG_TEMP_1)
+	}
+	MAP_SCRIPT_ON_FRAME_TABLE [
+		VAR_TEMP_0, 0: MyScript
+		VAR_TEMP_1, 1 {
+			setvar(VAR_TEMP_1, 2)
+		}
+	]
+}
+
+script MyScript {
+	lock
+	while (var(VAR_RESULT) < 5) {
+		if (flag(FLAG_1) && !defeated(TRAINER_1)) {
+			break
+		} elif (var(VAR_1) == 2 || var(VAR_2) != 3) {
+			continue
+		} else {
+			msgbox("Hello")
+		}
+		switch (var(VAR_RESULT)) {
+			case 0:
+			case 1:
+				do {
+					if (flag(FLAG_2)) {
+						continue
+					}
+					break
+				} while (flag(FLAG_3))
+				break
+			default:
+				continue
+		}
+	}
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+	pokemart(MyMart)
+	msgbox(MyText)
+	release
+}
+
+movement MyMovement {
+	walk_left * 2
+}
+
+mart MyMart {
+	ITEM_POTION
+}
+
+text MyText {
+	"Goodbye"
+}
+`
+	for _, optimize := range []bool{true, false} {
+		p := parser.New(lexer.New(input), "", nil)
+		program, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		expected, err := New(program, optimize).Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		data, err := ast.EncodeJSON(program)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		decoded, err := ast.DecodeJSON(data)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		result, err := New(decoded, optimize).Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if result != expected {
+			t.Errorf("Mismatching emit for decoded AST (optimize=%t) -- Expected=%q, Got=%q", optimize, expected, result)
+		}
+	}
+}
+
+func TestEmitErrors(t *testing.T) {
+	program := &ast.Program{
+		TopLevelStatements: []ast.Statement{&ast.BreakStatement{}},
+	}
+	_, err := New(program, true).Emit()
+	if !errors.Is(err, ErrEmit) {
+		t.Errorf("Expected an emit error, but got '%v'", err)
+	}
+	if errors.Is(err, parser.ErrSyntax) {
+		t.Errorf("Expected error '%v' not to be a syntax error", err)
+	}
+}
+
+// Test backend that lists the names of the statements it renders.
+type listBackend struct{}
+
+func init() {
+	RegisterBackend("list", func() Backend { return &listBackend{} })
+}
+
+func (b *listBackend) EmitScript(script *ir.Script, chunkIDs []int) (string, error) {
+	return fmt.Sprintf("script %s %v\n", script.Name, chunkIDs), nil
+}
+
+func (b *listBackend) EmitMapScripts(stmt *ast.MapScriptsStatement, emitScript func(*ast.ScriptStatement) (string, error)) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("mapscripts %s\n", stmt.Name.Value))
+	for _, mapScript := range stmt.MapScripts {
+		if mapScript.Script != nil {
+			output, err := emitScript(mapScript.Script)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(output)
+		}
+	}
+	return sb.String(), nil
+}
+
+func (b *listBackend) EmitText(text ast.Text) (string, error) {
+	return fmt.Sprintf("text %s\n", text.Name), nil
+}
+
+func (b *listBackend) EmitMovement(stmt *ast.MovementStatement) (string, error) {
+	return fmt.Sprintf("movement %s\n", stmt.Name.Value), nil
+}
+
+func (b *listBackend) EmitMart(stmt *ast.MartStatement) (string, error) {
+	return fmt.Sprintf("mart %s\n", stmt.Name.Value), nil
+}
+
+func (b *listBackend) EmitData(stmt *ast.DataStatement) (string, error) {
+	return fmt.Sprintf("data %s\n", stmt.Name.Value), nil
+}
+
+func (b *listBackend) EmitAlias(stmt *ast.AliasStatement) (string, error) {
+	return fmt.Sprintf("alias %s\n", stmt.Name.Value), nil
+}
+
+func (b *listBackend) EmitRaw(stmt *ast.RawStatement) (string, error) {
+	return "raw\n", nil
+}
+
+func (b *listBackend) EmitDirective(stmt *ast.DirectiveStatement) (string, error) {
+	return "directive\n", nil
+}
+
+func (b *listBackend) EmitAlignment(annotations ast.Annotations) string {
+	if annotations.Has("align") {
+		return "align\n"
+	}
+	return ""
+}
+
+func TestEmitWithBackend(t *testing.T) {
+	input := `
+mapscripts MyMap_MapScripts {
+	MAP_SCRIPT_ON_RESUME {
+		setflag(FLAG_1)
+	}
+}
+
+script MyScript {
+	if (flag(FLAG_1)) {
+		msgbox("Hello")
+	}
+}
+
+raw ` + "`" + `
+	.byte 1
+` + "`" + `
+
+@align(2)
+movement MyMovement {
+	walk_left
+}
+
+mart MyMart {
+	ITEM_POTION
+}
+`
+	expected := `mapscripts MyMap_MapScripts
+script MyMap_MapScripts_MAP_SCRIPT_ON_RESUME [0]
+
+script MyScript [0 1]
+
+raw
+
+align
+movement MyMovement
+
+mart MyMart
+
+text MyScript_Text_0
+`
+	backend, err := NewBackend("list")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := parser.New(lexer.New(input), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := NewWithBackend(program, true, backend).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching emit with backend -- Expected=%q, Got=%q", expected, result)
+	}
+
+	if names := BackendNames(); strings.Join(names, ",") != "bin,c,list,pokecrystal,pokeemerald,pokefirered,pokeruby,xse" {
+		t.Errorf("Expected backends 'bin,c,list,pokecrystal,pokeemerald,pokefirered,pokeruby,xse', but got '%s'", strings.Join(names, ","))
+	}
+	if _, err := NewBackend("unknown"); err == nil || err.Error() != "unknown target 'unknown'. Valid targets are: bin, c, list, pokecrystal, pokeemerald, pokefirered, pokeruby, xse" {
+		t.Errorf("Expected unknown target error, but got '%v'", err)
+	}
+}
+
+func TestEmitPokecrystal(t *testing.T) {
+	input := `
+mapscripts MyMap_MapScripts {
+	MAP_SCRIPT_ON_RESUME {
+		setevent(EVENT_1)
+	}
+	MAP_SCRIPT_ON_FRAME_TABLE [
+		VAR_TEMP_0, 0: MyMap_Scene0
+	]
+}
+
+script MyScript {
+	opentext
+	if (flag(EVENT_A) && var(VAR_X) >= 3) {
+		writetext("Hello there,\nfriend!\pBye.")
+	} elif (var(VAR_Y) <= 1) {
+		closetext
+	}
+	end
+}
+
+mart MyMart {
+	POTION
+	ANTIDOTE
+}
+`
+	expected := `MyMap_MapScripts::
+	def_scene_scripts
+	scene_script MyMap_Scene0, 0
+
+	def_callbacks
+	callback MAP_SCRIPT_ON_RESUME, MyMap_MapScripts_MAP_SCRIPT_ON_RESUME
+
+MyMap_MapScripts_MAP_SCRIPT_ON_RESUME:
+	setevent EVENT_1
+	endcallback
+
+
+MyScript::
+	opentext
+	checkevent EVENT_A
+	iftrue MyScript_5
+MyScript_4:
+	readvar VAR_Y
+	ifgreater 1, MyScript_1
+	closetext
+MyScript_1:
+	end
+
+MyScript_5:
+	readvar VAR_X
+	ifless 3, MyScript_4
+	writetext MyScript_Text_0
+	sjump MyScript_1
+
+
+MyMart:
+	db 2
+	db POTION
+	db ANTIDOTE
+	db -1
+
+MyScript_Text_0:
+	text "Hello there,"
+	line "friend!"
+	para "Bye."
+	done
+`
+	backend, err := NewBackend("pokecrystal")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := parser.New(lexer.New(input), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := NewWithBackend(program, true, backend).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching pokecrystal emit -- Expected=%q, Got=%q", expected, result)
+	}
+
+	p = parser.New(lexer.New("script MyScript { if (defeated(TRAINER_1)) { end } }"), "", nil)
+	program, err = p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	backend, _ = NewBackend("pokecrystal")
+	_, err = NewWithBackend(program, true, backend).Emit()
+	expectedError := "script 'MyScript': defeated() is not supported by the pokecrystal target. Check the trainer's event flag with flag() instead"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', but got '%v'", expectedError, err)
+	}
+	if !errors.Is(err, ErrEmit) {
+		t.Errorf("Expected error to match ErrEmit, but got '%v'", err)
+	}
+}
+
+func TestEmitTargetProfiles(t *testing.T) {
+	input := `
+script MyScript {
+	if (flag(FLAG_1) && var(VAR_1) <= 2) {
+		setflag(FLAG_2)
+	}
+	if (!defeated(TRAINER_1)) {
+		trainerbattle_single(TRAINER_1, Intro, Defeat)
+	}
+	switch (var(VAR_2)) {
+		case 1: setflag(FLAG_3)
+		case 2: setflag(FLAG_4)
+	}
+}
+`
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{
+			target: "pokefirered",
+			expected: `MyScript::
+	goto_if_set FLAG_1, MyScript_3
+MyScript_1:
+	goto_if_defeated TRAINER_1, MyScript_6
+	trainerbattle_single TRAINER_1, Intro, Defeat
+MyScript_6:
+	switch VAR_2
+	case 1, MyScript_10
+	case 2, MyScript_11
+	return
+
+MyScript_3:
+	compare VAR_1, 2
+	goto_if_gt MyScript_1
+	setflag FLAG_2
+	goto MyScript_1
+
+MyScript_10:
+	setflag FLAG_3
+	return
+
+MyScript_11:
+	setflag FLAG_4
+	return
+
+`,
+		},
+		{
+			target: "pokeruby",
+			expected: `MyScript::
+	checkflag FLAG_1
+	goto_if 1, MyScript_3
+MyScript_1:
+	checktrainerflag TRAINER_1
+	goto_if 1, MyScript_6
+	trainerbattle_single TRAINER_1, Intro, Defeat
+MyScript_6:
+	copyvar VAR_0x8000, VAR_2
+	compare VAR_0x8000, 1
+	goto_if 1, MyScript_10
+	compare VAR_0x8000, 2
+	goto_if 1, MyScript_11
+	return
+
+MyScript_3:
+	compare VAR_1, 2
+	goto_if 2, MyScript_1
+	setflag FLAG_2
+	goto MyScript_1
+
+MyScript_10:
+	setflag FLAG_3
+	return
+
+MyScript_11:
+	setflag FLAG_4
+	return
+
+`,
+		},
+	}
+	for _, test := range tests {
+		backend, err := NewBackend(test.target)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		p := parser.New(lexer.New(input), "", nil)
+		program, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		result, err := NewWithBackend(program, true, backend).Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if result != test.expected {
+			t.Errorf("Mismatching emit for target '%s' -- Expected=%q, Got=%q", test.target, test.expected, result)
+		}
+	}
+}
+
+func TestEmitXSE(t *testing.T) {
+	input := `
+script MyScript {
+	lock
+	if (flag(FLAG_1) || var(VAR_1) < 2) {
+		msgbox("Hello\nthere!", MSGBOX_DEFAULT)
+		applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+	}
+	pokemart(MyMart)
+	release
+	end
+}
+
+movement MyMovement {
+	walk_left * 2
+}
+
+mart MyMart {
+	ITEM_POTION
+}
+`
+	expected := `#org @MyScript
+lock
+checkflag FLAG_1
+if 0x1 goto @MyScript_2
+compare VAR_1 2
+if 0x4 goto @MyScript_1
+goto @MyScript_2
+
+#org @MyScript_2
+msgbox @MyScript_Text_0 MSGBOX_DEFAULT
+applymovement OBJ_EVENT_ID_PLAYER @MyMovement
+goto @MyScript_1
+
+#org @MyScript_1
+pokemart @MyMart
+release
+end
+
+
+#org @MyMovement
+#raw walk_left
+#raw walk_left
+#raw step_end
+
+#org @MyMart
+#raw word ITEM_POTION
+#raw word 0x0
+
+#org @MyScript_Text_0
+= Hello\nthere!
+`
+	backend, err := NewBackend("xse")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := parser.New(lexer.New(input), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := NewWithBackend(program, true, backend).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching XSE emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitBytecode(t *testing.T) {
+	input := `
+script MyScript {
+	lock
+	if (flag(FLAG_0x20) || var(VAR_0x4001) < 2) {
+		message("Hi!")
+		waitmessage
+	}
+	applymovement(1, MyMovement)
+	release
+	end
+}
+
+movement MyMovement {
+	step_up * 2
+}
+`
+	expected := []byte{
+		0x6A,             // lock
+		0x2B, 0x20, 0x00, // checkflag FLAG_0x20
+		0x06, 0x01, 0x15, 0x00, 0x90, 0x08, // goto_if 1, MyScript_2
+		0x21, 0x01, 0x40, 0x02, 0x00, // compare VAR_0x4001, 2
+		0x06, 0x04, 0x1B, 0x00, 0x90, 0x08, // goto_if 4, MyScript_1
+		0x67, 0x27, 0x00, 0x90, 0x08, // MyScript_2: message MyScript_Text_0
+		0x66,                                     // waitmessage
+		0x4F, 0x01, 0x00, 0x24, 0x00, 0x90, 0x08, // MyScript_1: applymovement 1, MyMovement
+		0x6C,             // release
+		0x02,             // end
+		0x11, 0x11, 0xFE, // MyMovement
+		0xC2, 0xDD, 0xAB, 0xFF, // MyScript_Text_0
+	}
+	table := DefaultOpcodeTable()
+	table.BaseAddress = "0x08900000"
+	table.Constants["step_up"] = 0x11
+	p := parser.New(lexer.New(input), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := NewWithBackend(program, true, NewBytecodeBackend(table)).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != string(expected) {
+		t.Errorf("Mismatching bytecode emit -- Expected=% X, Got=% X", expected, []byte(result))
+	}
+
+	p = parser.New(lexer.New("script MyScript { setflag(FLAG_1) }"), "", nil)
+	program, err = p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = NewWithBackend(program, true, NewBytecodeBackend(table)).Emit()
+	expectedError := "script 'MyScript': command 'setflag': unknown value 'FLAG_1'. Add it to the opcode table's constants"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', but got '%v'", expectedError, err)
+	}
+	if !errors.Is(err, ErrEmit) {
+		t.Errorf("Expected error to match ErrEmit, but got '%v'", err)
+	}
+}
+
+func TestEmitC(t *testing.T) {
+	input := `
+script MyScript {
+	lock
+	if (flag(FLAG_1)) {
+		msgbox("Hello\nthere!", MSGBOX_DEFAULT)
+	}
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+	release
+	end
+}
+
+movement(local) MyMovement {
+	walk_left * 2
+}
+
+@align(2)
+mart MyMart {
+	ITEM_POTION
+}
+`
+	expected := `extern const u8 MyScript[];
+
+extern const u8 MyScript_1[];
+extern const u8 MyMovement[];
+extern const u16 MyMart[];
+extern const u8 MyScript_Text_0[];
+
+const u8 MyScript[] = {
+	SCRIPT_lock(),
+	SCRIPT_goto_if_unset(FLAG_1, MyScript_1),
+	SCRIPT_msgbox(MyScript_Text_0, MSGBOX_DEFAULT),
+	SCRIPT_goto(MyScript_1),
+};
+
+const u8 MyScript_1[] = {
+	SCRIPT_applymovement(OBJ_EVENT_ID_PLAYER, MyMovement),
+	SCRIPT_release(),
+	SCRIPT_end(),
+};
+
+const u8 MyMovement[] = {
+	walk_left,
+	walk_left,
+	step_end,
+};
+
+const u16 MyMart[] __attribute__((aligned(4))) = {
+	ITEM_POTION,
+	ITEM_NONE,
+};
+
+const u8 MyScript_Text_0[] = _("Hello\nthere!");
+`
+	backend, err := NewBackend("c")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := parser.New(lexer.New(input), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := NewWithBackend(program, true, backend).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching C emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitDeterministicOutput(t *testing.T) {
+	input := `
+mapscripts MyMap_MapScripts {
+	MAP_SCRIPT_ON_TRANSITION {
+		if (var(VAR_0x4001) == 1 || flag(FLAG_0x22)) {
+			setflag(FLAG_0x23)
+		}
+	}
+	MAP_SCRIPT_ON_FRAME_TABLE [
+		VAR_TEMP_0, 0 {
+			setvar(VAR_TEMP_0, 1)
+		}
+	]
+}
+
+script MyScript {
+	lock
+	while (var(VAR_0x4001) < 10) {
+		if (flag(FLAG_0x21) && !flag(FLAG_0x22)) {
+			message("First")
+			break
+		} elif (var(VAR_0x4002) == 2 || var(VAR_0x4003) >= 3) {
+			message("Second")
+			continue
+		}
+		switch (var(VAR_0x4004)) {
+		case 0:
+		case 1:
+			message("Third")
+		case 2:
+			do {
+				addvar(VAR_0x4001, 1)
+			} while (var(VAR_0x4001) != 5)
+		default:
+			message("First")
+		}
+		addvar(VAR_0x4001, 1)
+	}
+	if (var(VAR_0x4005) == 1) {
+		goto(MyScript)
+	} elif (var(VAR_0x4005) == 2) {
+		call(MyScript)
+	} elif (var(VAR_0x4005) == 3) {
+		message(MyText)
+	}
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+	release
+	end
+}
+
+text MyText { "Fourth" }
+
+movement MyMovement {
+	walk_left * 2
+}
+
+mart MyMart {
+	ITEM_POTION
+}
+`
+	for _, name := range BackendNames() {
+		for _, optimize := range []bool{false, true} {
+			var expected string
+			for i := 0; i < 20; i++ {
+				backend, err := NewBackend(name)
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+				if name == "bin" {
+					table := DefaultOpcodeTable()
+					for _, constant := range []string{"VAR_TEMP_0", "OBJ_EVENT_ID_PLAYER", "walk_left", "ITEM_POTION"} {
+						table.Constants[constant] = int64(len(table.Constants))
+					}
+					backend = NewBytecodeBackend(table)
+				}
+				program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+				result, err := NewWithBackend(program, optimize, backend).Emit()
+				if err != nil {
+					t.Fatalf("Target '%s': %s", name, err.Error())
+				}
+				if i == 0 {
+					expected = result
+				} else if result != expected {
+					t.Fatalf("Target '%s' with optimize=%t produced different output on run %d -- Expected=%q, Got=%q", name, optimize, i, expected, result)
+				}
+			}
+		}
+	}
+}
+
+func TestEmitLabelFormat(t *testing.T) {
+	input := `
+script MyScript {
+	if (flag(FLAG_1)) {
+		msgbox("Hello")
+	} else {
+		msgbox("Goodbye")
+	}
+	release
+}
+`
+	expected := `MyScript::
+	goto_if_set FLAG_1, MyScript_Branch02
+	msgbox MyScript_Text_1
+MyScript_Branch01:
+	release
+	return
+
+MyScript_Branch02:
+	msgbox MyScript_Text_0
+	goto MyScript_Branch01
+
+
+MyScript_Text_0:
+	.string "Hello$"
+
+MyScript_Text_1:
+	.string "Goodbye$"
+`
+	p := parser.New(lexer.New(input), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	format, err := ir.ParseLabelFormat("{script}_Branch{n:2}")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := New(program, true)
+	e.SetLabelFormat(format)
+	result, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching label format emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitPerScriptChunkNumbering(t *testing.T) {
+	other := `
+script OtherScript {
+	if (var(VAR_1) == 1) {
+		setflag(FLAG_1)
+	} elif (var(VAR_1) == 2) {
+		setflag(FLAG_2)
+	}
+	release
+}
+`
+	inputs := []string{`
+script MyScript {
+	lock
+}
+` + other, `
+script MyScript {
+	if (flag(FLAG_3)) {
+		while (var(VAR_2) < 5) {
+			addvar(VAR_2, 1)
+		}
+	}
+}
+` + other}
+	program, err := parser.New(lexer.New(other), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected, err := New(program, true).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, input := range inputs {
+		program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		result, err := New(program, true).Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !strings.HasSuffix(result, expected) {
+			t.Errorf("Test %d: editing MyScript changed the labels of OtherScript -- Expected suffix=%q, Got=%q", i, expected, result)
+		}
+	}
+}
+
+func TestEmitSymbols(t *testing.T) {
+	input := `
+script MyScript {
+	if (flag(FLAG_1)) {
+		msgbox("Hello")
+	} else {
+		msgbox(format("Goodbye"))
+	}
+	release
+}
+
+mapscripts MyMap_MapScripts {
+	MAP_SCRIPT_ON_LOAD {
+		setflag(FLAG_2)
+	}
+	MAP_SCRIPT_ON_FRAME_TABLE [
+		VAR_TEMP_0, 0: MyMap_OnFrame
+	]
+}
+
+movement(local) MyMovement {
+	walk_left
+}
+
+mart MyMart {
+	ITEM_POTION
+}
+
+text(global) MyText {
+	"Text"
+}
+`
+	expected := `MyScript script global
+MyScript_1 branch local MyScript
+MyScript_2 branch local MyScript
+MyMap_MapScripts mapscripts global
+MyMap_MapScripts_MAP_SCRIPT_ON_FRAME_TABLE mapscripts local
+MyMap_MapScripts_MAP_SCRIPT_ON_LOAD script local
+MyMovement movement local
+MyMart mart local
+MyScript_Text_0 text local
+MyScript_Text_1 text local
+MyText text global
+`
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := New(program, true)
+	if _, err := e.Emit(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var sb strings.Builder
+	for _, symbol := range e.Symbols() {
+		scope := "local"
+		if symbol.Global {
+			scope = "global"
+		}
+		sb.WriteString(strings.TrimSpace(fmt.Sprintf("%s %s %s %s", symbol.Name, symbol.Kind, scope, symbol.Script)) + "\n")
+	}
+	if sb.String() != expected {
+		t.Errorf("Mismatching symbols -- Expected=%q, Got=%q", expected, sb.String())
+	}
+}
+
+func TestEmitOutputStyle(t *testing.T) {
+	input := `
+raw ` + "`" + `
+	.include "constants.inc"
+` + "`" + `
+
+script MyScript {
+	lock
+	msgbox("Hello", MSGBOX_DEFAULT)
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+	release
+}
+
+movement MyMovement {
+	walk_left
+}
+`
+	expected := `	.include "constants.inc"
+
+
+MyScript::
+    lock
+    msgbox        MyScript_Text_0, MSGBOX_DEFAULT
+    applymovement OBJ_EVENT_ID_PLAYER, MyMovement
+    release
+    return
+
+
+
+MyMovement:
+    walk_left
+    step_end
+
+
+MyScript_Text_0:
+    .string "Hello$"
+`
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := New(program, true)
+	e.SetOutputStyle(OutputStyle{Indent: "    ", BlankLines: 2, AlignArgs: true})
+	result, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching output style emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestParseIndent(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      bool
+	}{
+		{"tab", "\t", false},
+		{"4", "    ", false},
+		{"0", "", false},
+		{"-1", "", true},
+		{"spaces", "", true},
+	}
+	for _, test := range tests {
+		indent, err := ParseIndent(test.input)
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error result for indent '%s': %v", test.input, err)
+		} else if indent != test.expected {
+			t.Errorf("Incorrect indent for '%s'. Expected %q, got %q", test.input, test.expected, indent)
+		}
+	}
+}
+
+func TestEmitLineEndings(t *testing.T) {
+	input := "raw `\r\n\t.include \"constants.inc\"\r\n`\r\n\r\nscript MyScript {\r\n\tmsgbox(\"Hello\")\r\n}\r\n"
+	expected := "\t.include \"constants.inc\"\n\nMyScript::\n\tmsgbox MyScript_Text_0\n\treturn\n\n\nMyScript_Text_0:\n\t.string \"Hello$\"\n"
+	for _, lineEnding := range []string{"\n", "\r\n"} {
+		program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		style := DefaultOutputStyle
+		style.LineEnding = lineEnding
+		e := New(program, true)
+		e.SetOutputStyle(style)
+		result, err := e.Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if expected := strings.Replace(expected, "\n", lineEnding, -1); result != expected {
+			t.Errorf("Mismatching line endings emit for %q -- Expected=%q, Got=%q", lineEnding, expected, result)
+		}
+	}
+}
+
+func TestEmitSourceComments(t *testing.T) {
+	input := `
+script MyScript {
+	lock
+	if (flag(FLAG_1)) {
+		msgbox("Hello")
+		setflag(FLAG_2)
+	}
+	release
+}
+`
+	expected := `MyScript::
+	@ data/scripts/myscript.pory:3
+	lock
+	goto_if_unset FLAG_1, MyScript_1
+	@ data/scripts/myscript.pory:5
+	msgbox MyScript_Text_0
+	setflag FLAG_2
+MyScript_1:
+	@ data/scripts/myscript.pory:8
+	release
+	return
+
+
+MyScript_Text_0:
+	.string "Hello$"
+`
+	p := parser.New(lexer.New(input), "", nil)
+	p.SetFilepath("data/scripts/myscript.pory")
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := New(program, true)
+	e.SetSourceComments(true)
+	result, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching source comments emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitSourceMap(t *testing.T) {
+	input := `
+raw ` + "`" + `
+	@ myscript.pory:3
+` + "`" + `
+
+script MyScript {
+	lock
+	if (flag(FLAG_1)) {
+		msgbox("Hello")
+		setflag(FLAG_2)
+	}
+	release
+}
+`
+	expected := []SourceMapping{
+		{Line: 4, File: "myscript.pory", SourceLine: 7},
+		{Line: 5, File: "myscript.pory", SourceLine: 7},
+		{Line: 6, File: "myscript.pory", SourceLine: 9},
+		{Line: 7, File: "myscript.pory", SourceLine: 9},
+		{Line: 9, File: "myscript.pory", SourceLine: 12},
+		{Line: 10, File: "myscript.pory", SourceLine: 12},
+	}
+	for _, sourceComments := range []bool{false, true} {
+		p := parser.New(lexer.New(input), "", nil)
+		p.SetFilepath("myscript.pory")
+		program, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		e := New(program, true)
+		e.SetSourceComments(sourceComments)
+		e.SetSourceMap(true)
+		result, err := e.Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		lines := strings.Split(result, "\n")
+		mappings := e.SourceMap()
+		if !sourceComments {
+			if strings.Count(result, "@ myscript.pory") != 1 {
+				t.Errorf("Expected only the raw statement's comment in the output, got %q", result)
+			}
+			if len(mappings) != len(expected) {
+				t.Fatalf("Incorrect number of source mappings. Expected %d, got %d", len(expected), len(mappings))
+			}
+			for i, mapping := range mappings {
+				if mapping != expected[i] {
+					t.Errorf("Incorrect source mapping %d. Expected %+v, got %+v", i, expected[i], mapping)
+				}
+			}
+		}
+		// Every mapped line must be a line of commands.
+		for _, mapping := range mappings {
+			line := lines[mapping.Line-1]
+			if !strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "\t@") {
+				t.Errorf("Source mapping %+v is for the line %q", mapping, line)
+			}
+		}
+	}
+}
+
+func TestEmitLineDirectives(t *testing.T) {
+	input := `
+script MyScript {
+	lock
+	if (flag(FLAG_1)) {
+		setflag(FLAG_2)
+	}
+	release
+}
+`
+	tests := []struct {
+		format   LineDirectiveFormat
+		expected string
+	}{
+		{NoLineDirectives, `MyScript::
+	lock
+	goto_if_unset FLAG_1, MyScript_1
+	setflag FLAG_2
+MyScript_1:
+	release
+	return
+
+`},
+		{CppLineDirectives, `MyScript::
+#line 3 "myscript.pory"
+	lock
+	goto_if_unset FLAG_1, MyScript_1
+#line 5 "myscript.pory"
+	setflag FLAG_2
+#line 8 "myscript.inc"
+MyScript_1:
+#line 7 "myscript.pory"
+	release
+	return
+#line 13 "myscript.inc"
+
+`},
+		{GasLineDirectives, `MyScript::
+# 3 "myscript.pory"
+	lock
+	goto_if_unset FLAG_1, MyScript_1
+# 5 "myscript.pory"
+	setflag FLAG_2
+# 8 "myscript.inc"
+MyScript_1:
+# 7 "myscript.pory"
+	release
+	return
+# 13 "myscript.inc"
+
+`},
+	}
+	for _, test := range tests {
+		p := parser.New(lexer.New(input), "", nil)
+		p.SetFilepath("myscript.pory")
+		program, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		e := New(program, true)
+		e.SetLineDirectives(test.format, "myscript.inc")
+		result, err := e.Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if result != test.expected {
+			t.Errorf("Mismatching line directives emit for '%s' -- Expected=%q, Got=%q", test.format, test.expected, result)
+		}
+	}
+}
+
+func TestParseLineDirectiveFormat(t *testing.T) {
+	for input, expected := range map[string]LineDirectiveFormat{"none": NoLineDirectives, "line": CppLineDirectives, "gas": GasLineDirectives} {
+		if format, err := ParseLineDirectiveFormat(input); err != nil || format != expected {
+			t.Errorf("Incorrect line directive format for '%s'. Expected '%s', got '%s' (%v)", input, expected, format, err)
+		}
+	}
+	if _, err := ParseLineDirectiveFormat("#line"); err == nil {
+		t.Errorf("Expected an error for an invalid line directive format")
+	}
+}
+
+func TestEmitSplit(t *testing.T) {
+	input := `
+script MyScript {
+	msgbox("Hello")
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+}
+
+movement MyMovement {
+	walk_left
+}
+
+raw ` + "`" + `
+	.include "constants.inc"
+` + "`" + `
+
+text MyText {
+	"Goodbye"
+}
+`
+	expectedScripts := `MyScript::
+	msgbox MyScript_Text_0
+	applymovement OBJ_EVENT_ID_PLAYER, MyMovement
+	return
+
+
+	.include "constants.inc"
+`
+	expectedData := `MyMovement:
+	walk_left
+	step_end
+
+MyScript_Text_0:
+	.string "Hello$"
+
+MyText::
+	.string "Goodbye$"
+`
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := New(program, true)
+	scripts, data, err := e.EmitSplit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if scripts != expectedScripts {
+		t.Errorf("Mismatching split scripts emit -- Expected=%q, Got=%q", expectedScripts, scripts)
+	}
+	if data != expectedData {
+		t.Errorf("Mismatching split data emit -- Expected=%q, Got=%q", expectedData, data)
+	}
+	whole, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.Contains(whole, "MyMovement:") || !strings.Contains(whole, "MyText::") {
+		t.Errorf("Expected Emit to write the data with the scripts, got %q", whole)
+	}
+	if _, _, err := NewWithBackend(program, true, NewBytecodeBackend(DefaultOpcodeTable())).EmitSplit(); err == nil {
+		t.Errorf("Expected an error when splitting binary output")
+	}
+}
+
+func TestEmitSplitByScope(t *testing.T) {
+	input := `
+script MyGlobalScript {
+	msgbox("Hello")
+}
+
+script(local) MyLocalScript {
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+}
+
+movement MyMovement {
+	walk_left
+}
+
+raw ` + "`" + `
+	.include "constants.inc"
+` + "`" + `
+
+text(global) MyText {
+	"Goodbye"
+}
+`
+	expectedLocal := `MyLocalScript:
+	applymovement OBJ_EVENT_ID_PLAYER, MyMovement
+	return
+
+
+MyMovement:
+	walk_left
+	step_end
+
+	.include "constants.inc"
+
+MyGlobalScript_Text_0:
+	.string "Hello$"
+`
+	expectedGlobal := `MyGlobalScript::
+	msgbox MyGlobalScript_Text_0
+	return
+
+
+MyText::
+	.string "Goodbye$"
+`
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	local, global, err := New(program, true).EmitSplitByScope()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if local != expectedLocal {
+		t.Errorf("Mismatching local emit -- Expected=%q, Got=%q", expectedLocal, local)
+	}
+	if global != expectedGlobal {
+		t.Errorf("Mismatching global emit -- Expected=%q, Got=%q", expectedGlobal, global)
+	}
+}
+
+func TestEmitTailCalls(t *testing.T) {
+	input := `
+script MyScript {
+	lock
+	if (flag(FLAG_1)) {
+		call(OtherScript)
+		return
+	}
+	call(OtherScript)
+	call(OtherScript)
+	release
+	end
+}
+
+script CallingScript {
+	call(OtherScript)
+}
+
+script EndingScript {
+	call(OtherScript)
+	end
+}
+
+script OtherScript {
+	setflag(FLAG_2)
+}
+`
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{
+			target: "pokeemerald",
+			expected: `MyScript::
+	lock
+	goto_if_set FLAG_1, MyScript_2
+	call OtherScript
+	call OtherScript
+	release
+	end
+
+MyScript_2:
+	goto OtherScript
+
+
+CallingScript::
+	goto OtherScript
+
+
+EndingScript::
+	call OtherScript
+	end
+
+
+OtherScript::
+	setflag FLAG_2
+	return
+
+`,
+		},
+		{
+			target: "xse",
+			expected: `#org @MyScript
+lock
+checkflag FLAG_1
+if 0x1 goto @MyScript_2
+call @OtherScript
+call @OtherScript
+release
+end
+
+#org @MyScript_2
+goto @OtherScript
+
+
+#org @CallingScript
+goto @OtherScript
+
+
+#org @EndingScript
+call @OtherScript
+end
+
+
+#org @OtherScript
+setflag FLAG_2
+return
+
+`,
+		},
+	}
+	for _, test := range tests {
+		program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		backend, err := NewBackend(test.target)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		e := NewWithBackend(program, true, backend)
+		e.SetTailCalls(true)
+		result, err := e.Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if result != test.expected {
+			t.Errorf("Mismatching tail calls emit for target '%s' -- Expected=%q, Got=%q", test.target, test.expected, result)
+		}
+	}
+}
+
+func TestEmitTextOrder(t *testing.T) {
+	input := `
+text ZText {
+	"Z"
+}
+
+script BScript {
+	msgbox("B")
+	msgbox(ZText)
+}
+
+text AText {
+	"A"
+}
+
+script AScript {
+	msgbox(AText)
+	msgbox("A2")
+}
+`
+	tests := []struct {
+		order    TextOrder
+		expected []string
+	}{
+		{InlineFirstTextOrder, []string{"BScript_Text_0", "AScript_Text_0", "ZText", "AText"}},
+		{SourceTextOrder, []string{"ZText", "BScript_Text_0", "AText", "AScript_Text_0"}},
+		{FirstUseTextOrder, []string{"BScript_Text_0", "ZText", "AText", "AScript_Text_0"}},
+		{AlphabeticalTextOrder, []string{"AScript_Text_0", "AText", "BScript_Text_0", "ZText"}},
+	}
+	for _, test := range tests {
+		program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		e := New(program, true)
+		e.SetTextOrder(test.order)
+		if _, err := e.Emit(); err != nil {
+			t.Fatalf(err.Error())
+		}
+		var texts []string
+		for _, symbol := range e.Symbols() {
+			if symbol.Kind == "text" {
+				texts = append(texts, symbol.Name)
+			}
+		}
+		if strings.Join(texts, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Mismatching text order '%s' -- Expected=%v, Got=%v", test.order, test.expected, texts)
+		}
+	}
+}
+
+func TestParseTextOrder(t *testing.T) {
+	if order, err := ParseTextOrder("first-use"); err != nil || order != FirstUseTextOrder {
+		t.Errorf("Expected text order 'first-use', but got '%s', %v", order, err)
+	}
+	if order, err := ParseTextOrder("inline-first"); err != nil || order != InlineFirstTextOrder {
+		t.Errorf("Expected the inline-first text order, but got '%s', %v", order, err)
+	}
+	if _, err := ParseTextOrder("random"); err == nil || err.Error() != "invalid text order 'random'. Expected 'inline-first', 'source', 'first-use', or 'alphabetical'" {
+		t.Errorf("Expected invalid text order error, but got %v", err)
+	}
+}
+
+func TestEmitWrappedText(t *testing.T) {
+	input := `
+script MyScript {
+	msgbox("Short.")
+	msgbox("The quick brown fox jumps over the lazy dog.\p{PLAYER}{STR_VAR_1} went to the store.")
+	msgbox("Supercalifragilisticexpialidocious")
+}
+`
+	expected := `MyScript::
+	msgbox MyScript_Text_0
+	msgbox MyScript_Text_1
+	msgbox MyScript_Text_2
+	return
+
+
+MyScript_Text_0:
+	.string "Short.$"
+
+MyScript_Text_1:
+	.string "The quick brown "
+	.string "fox jumps over "
+	.string "the lazy "
+	.string "dog.\p{PLAYER}"
+	.string "{STR_VAR_1} went "
+	.string "to the store.$"
+
+MyScript_Text_2:
+	.string "Supercalifragilis"
+	.string "ticexpialidocious"
+	.string "$"
+`
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	style := DefaultOutputStyle
+	style.WrapColumn = 28
+	e := New(program, true)
+	e.SetOutputStyle(style)
+	result, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching wrapped text emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitTextDirective(t *testing.T) {
+	input := `
+script MyScript {
+	msgbox("Hello")
+	msgbox(ascii"Debug")
+}
+
+@language(JAPANESE)
+text MyText {
+	"Konnichiwa"
+}
+`
+	expected := `MyScript::
+	msgbox MyScript_Text_0
+	msgbox MyScript_Text_1
+	return
+
+
+MyScript_Text_0:
+	.mystring "Hello$", ENGLISH
+
+MyScript_Text_1:
+	.ascii "Debug\0"
+
+MyText::
+	.mystring "Konnichiwa$", JAPANESE
+`
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := New(program, true)
+	e.SetTextDirective(".mystring", "ENGLISH")
+	result, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching text directive emit -- Expected=%q, Got=%q", expected, result)
+	}
+}
+
+func TestEmitStatementDirectives(t *testing.T) {
+	input := `
+raw ` + "`" + `
+	.include "constants.inc"
+` + "`" + `
+
+script MyScript {
+	applymovement(OBJ_EVENT_ID_PLAYER, MyMovement)
+	msgbox("Hello")
+}
+
+movement MyMovement {
+	walk_up
+}
+`
+	expected := `	.include "constants.inc"
+
+MyScript::
+	applymovement OBJ_EVENT_ID_PLAYER, MyMovement
+	msgbox MyScript_Text_0
+	return
+
+
+	.align 2
+MyMovement:
+	walk_up
+	step_end
+
+	.section .rodata.text
+MyScript_Text_0:
+	.string "Hello$"
+	.previous
+`
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := New(program, true)
+	e.SetTargetConfig(TargetConfig{Directives: map[string]StatementDirectives{
+		"movement": {Before: []string{"\t.align 2"}},
+		"text":     {Before: []string{"\t.section .rodata.text"}, After: []string{"\t.previous"}},
+	}})
+	result, err := e.Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != expected {
+		t.Errorf("Mismatching statement directives emit -- Expected=%q, Got=%q", expected, result)
+	}
+
+	e = NewWithBackend(program, true, NewBytecodeBackend(DefaultOpcodeTable()))
+	e.SetTargetConfig(TargetConfig{Directives: map[string]StatementDirectives{"text": {Before: []string{".section .rodata"}}}})
+	if _, err := e.Emit(); err == nil || err.Error() != "binary output can't have statement directives" {
+		t.Errorf("Expected binary statement directives error, but got %v", err)
+	}
+}
+
+func TestEmitData(t *testing.T) {
+	input := `
+script MyScript {
+	multichoice(0, 0, MyData, FALSE)
+}
+
+@align(2)
+data MyData {
+	.byte: 1, 2
+	.2byte: 0x1234
+	.4byte: OtherData
+}
+
+data(global) OtherData {
+	.byte: 3
+}
+`
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{
+			target: "pokeemerald",
+			expected: `MyScript::
+	multichoice 0, 0, MyData, FALSE
+	return
+
+
+	.align 2
+MyData:
+	.byte 1, 2
+	.2byte 0x1234
+	.4byte OtherData
+
+OtherData::
+	.byte 3
+`,
+		},
+		{
+			target: "pokecrystal",
+			expected: `MyScript::
+	multichoice 0, 0, MyData, FALSE
+	end
+
+
+	align 2
+MyData:
+	db 1, 2
+	dw 0x1234
+	dl OtherData
+
+OtherData::
+	db 3
+`,
+		},
+		{
+			target: "xse",
+			expected: `#org @MyScript
+multichoice 0 0 @MyData FALSE
+return
+
+
+#org @MyData
+#raw byte 1
+#raw byte 2
+#raw word 0x1234
+#raw dword @OtherData
+
+#org @OtherData
+#raw byte 3
+`,
+		},
+	}
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, tt := range tests {
+		backend, err := NewBackend(tt.target)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		result, err := NewWithBackend(program, true, backend).Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if result != tt.expected {
+			t.Errorf("Mismatching %s data emit -- Expected=%q, Got=%q", tt.target, tt.expected, result)
+		}
+	}
+
+	expectedBytes := []byte{
+		0x01, 0x02, 0x34, 0x12, 0x08, 0x00, 0x90, 0x08, // MyData
+		0x03, // OtherData
+	}
+	table := DefaultOpcodeTable()
+	table.BaseAddress = "0x08900000"
+	program, err = parser.New(lexer.New(input[strings.Index(input, "@align"):]), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := NewWithBackend(program, true, NewBytecodeBackend(table)).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != string(expectedBytes) {
+		t.Errorf("Mismatching bytecode data emit -- Expected=% X, Got=% X", expectedBytes, []byte(result))
+	}
+
+	_, err = NewWithBackend(program, true, &cBackend{}).Emit()
+	expectedError := "data 'MyData' has values of different sizes, which can't be in the same C array"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', but got '%v'", expectedError, err)
+	}
+	if !errors.Is(err, ErrEmit) {
+		t.Errorf("Expected error to match ErrEmit, but got '%v'", err)
+	}
+}
+
+func TestEmitAliases(t *testing.T) {
+	input := `
+script NewScript {
+	end
+}
+
+alias OldScript = NewScript
+alias(local) LocalAlias = OldScript
+`
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{
+			target: "pokeemerald",
+			expected: `NewScript::
+	end
+
+
+	.global OldScript
+	.set OldScript, NewScript
+
+	.set LocalAlias, OldScript
+`,
+		},
+		{
+			target: "pokecrystal",
+			expected: `NewScript::
+	end
+
+
+OldScript::
+	sjump NewScript
+
+LocalAlias:
+	sjump OldScript
+`,
+		},
+		{
+			target: "xse",
+			expected: `#org @NewScript
+end
+
+
+#org @OldScript
+goto @NewScript
+
+#org @LocalAlias
+goto @OldScript
+`,
+		},
+		{
+			target: "c",
+			expected: `extern const u8 NewScript[];
+extern const u8 OldScript[];
+
+extern const u8 LocalAlias[];
+
+const u8 NewScript[] = {
+	SCRIPT_end(),
+};
+
+const u8 OldScript[] __attribute__((alias("NewScript")));
+
+const u8 LocalAlias[] __attribute__((alias("OldScript")));
+`,
+		},
+	}
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, tt := range tests {
+		backend, err := NewBackend(tt.target)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		result, err := NewWithBackend(program, true, backend).Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if result != tt.expected {
+			t.Errorf("Mismatching %s alias emit -- Expected=%q, Got=%q", tt.target, tt.expected, result)
+		}
+	}
+
+	input = `
+script MyScript {
+	call(LocalAlias)
+}
+
+alias(local) LocalAlias = OldScript
+alias OldScript = MyScript
+`
+	expected := []byte{
+		0x04, 0x00, 0x00, 0x90, 0x08, // call LocalAlias
+		0x03, // return
+	}
+	table := DefaultOpcodeTable()
+	table.BaseAddress = "0x08900000"
+	program, err = parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := NewWithBackend(program, true, NewBytecodeBackend(table)).Emit()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result != string(expected) {
+		t.Errorf("Mismatching bytecode alias emit -- Expected=% X, Got=% X", expected, []byte(result))
+	}
+}
+
+func TestEmitScriptLimits(t *testing.T) {
+	input := `
+script Small {
+	msgbox("Hi")
+	end
+}
+
+script Large {
+	lock
+	if (flag(FLAG_1)) {
+		msgbox("A")
+	} elif (flag(FLAG_2)) {
+		msgbox("B")
+	} else {
+		msgbox("C")
+	}
+	release
+	end
+}
+`
+	tests := []struct {
+		limits   ScriptLimits
+		options  parser.DiagnosticOptions
+		expected []parser.Diagnostic
+	}{
+		{
+			limits: ScriptLimits{},
+		},
+		{
+			limits: ScriptLimits{MaxCommands: 5, MaxChunks: 6},
+		},
+		{
+			limits: ScriptLimits{MaxCommands: 4},
+			expected: []parser.Diagnostic{
+				{Severity: parser.SeverityWarning, Category: parser.WarningScriptSize, LineNumber: 7, Column: 8, Message: "script 'Large' has 5 commands, which is more than the limit of 4. Consider splitting it into smaller scripts"},
+			},
+		},
+		{
+			limits:  ScriptLimits{MaxCommands: 4, MaxChunks: 1},
+			options: parser.DiagnosticOptions{WarningsAsErrors: true},
+			expected: []parser.Diagnostic{
+				{Severity: parser.SeverityError, Category: parser.WarningScriptSize, LineNumber: 7, Column: 8, Message: "script 'Large' has 5 commands, which is more than the limit of 4. Consider splitting it into smaller scripts"},
+				{Severity: parser.SeverityError, Category: parser.WarningScriptSize, LineNumber: 7, Column: 8, Message: "script 'Large' has 6 chunks, which is more than the limit of 1. Consider splitting it into smaller scripts"},
+			},
+		},
+		{
+			limits:  ScriptLimits{MaxCommands: 1, MaxChunks: 1},
+			options: parser.DiagnosticOptions{DisabledWarnings: []string{parser.WarningScriptSize}},
+		},
+	}
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, tt := range tests {
+		e := New(program, true)
+		e.SetScriptLimits(tt.limits)
+		e.SetDiagnosticOptions(tt.options)
+		if _, err := e.Emit(); err != nil {
+			t.Fatalf(err.Error())
+		}
+		diagnostics := e.Diagnostics()
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("Test %d: Expected %d diagnostics, but got %d: %v", i, len(tt.expected), len(diagnostics), diagnostics)
+			continue
+		}
+		for j, diagnostic := range diagnostics {
+			if diagnostic != tt.expected[j] {
+				t.Errorf("Test %d: Mismatching diagnostic %d -- Expected=%v, Got=%v", i, j, tt.expected[j], diagnostic)
+			}
+		}
+	}
+}
+
+func TestEmitStats(t *testing.T) {
+	input := `
+script Small {
+	msgbox("Hi{PLAYER}\p")
+	end
+}
+
+script Large {
+	lock
+	if (flag(FLAG_1)) {
+		msgbox("A")
+	} else {
+		msgbox("B")
+	}
+	release
+	end
+}
+
+mapscripts MyMap {
+	MAP_SCRIPT_ON_TRANSITION {
+		setflag(FLAG_3)
+	}
+}
+`
+	tests := []struct {
+		optimize bool
+		expected Stats
+	}{
+		{
+			optimize: false,
+			expected: Stats{Scripts: 3, Commands: 14, Chunks: 7, Texts: 3, TextBytes: 10},
+		},
+		{
+			optimize: true,
+			expected: Stats{Scripts: 3, Commands: 11, Chunks: 6, Texts: 3, TextBytes: 10, SavedCommands: 3, SavedChunks: 1},
+		},
+	}
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, tt := range tests {
+		e := New(program, tt.optimize)
+		e.SetStats(true)
+		if _, err := e.Emit(); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if e.Stats() != tt.expected {
+			t.Errorf("Test %d: Mismatching stats -- Expected=%+v, Got=%+v", i, tt.expected, e.Stats())
+		}
+	}
+}
+
+func TestEmitOptimizationLevels(t *testing.T) {
+	input := `
+const LEVEL = 2
+script MyScript {
+	if (var(LEVEL) == 1) {
+		msgbox("Never")
+	}
+	if (var(VAR_1) == 1 && flag(FLAG_1)) {
+		msgbox("A")
+	}
+	release
+}
+`
+	tests := []struct {
+		level    OptimizationLevel
+		expected string
+	}{
+		{
+			level: NoOptimization,
+			expected: `MyScript::
+	goto MyScript_3
+
+MyScript_1:
+	goto MyScript_7
+
+MyScript_2:
+	msgbox MyScript_Text_0
+	goto MyScript_1
+
+MyScript_3:
+	compare 2, 1
+	goto_if_eq MyScript_2
+	goto MyScript_1
+
+MyScript_4:
+	release
+	return
+
+MyScript_5:
+	msgbox MyScript_Text_1
+	goto MyScript_4
+
+MyScript_6:
+	goto MyScript_8
+
+MyScript_7:
+	compare VAR_1, 1
+	goto_if_eq MyScript_6
+	goto MyScript_4
+
+MyScript_8:
+	goto_if_set FLAG_1, MyScript_5
+	goto MyScript_4
+
+
+MyScript_Text_0:
+	.string "Never$"
+
+MyScript_Text_1:
+	.string "A$"
+`,
+		},
+		{
+			level: LayoutOptimization,
+			expected: `MyScript::
+	compare 2, 1
+	goto_if_ne MyScript_1
+	msgbox MyScript_Text_0
+MyScript_1:
+	compare VAR_1, 1
+	goto_if_eq MyScript_6
+MyScript_4:
+	release
+	return
+
+MyScript_6:
+	goto_if_unset FLAG_1, MyScript_4
+	msgbox MyScript_Text_1
+	goto MyScript_4
+
+
+MyScript_Text_0:
+	.string "Never$"
+
+MyScript_Text_1:
+	.string "A$"
+`,
+		},
+		{
+			level: FullOptimization,
+			expected: `MyScript::
+	goto_if_set FLAG_1, MyScript_3
+MyScript_1:
+	release
+	return
+
+MyScript_3:
+	compare VAR_1, 1
+	goto_if_ne MyScript_1
+	msgbox MyScript_Text_1
+	goto MyScript_1
+
+
+MyScript_Text_1:
+	.string "A$"
+`,
+		},
+	}
+	program, err := parser.New(lexer.New(input), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, tt := range tests {
+		e := New(program, false)
+		e.SetOptimizationLevel(tt.level)
+		result, err := e.Emit()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if result != tt.expected {
+			t.Errorf("Test %d: Mismatching emit -- Expected=%q, Got=%q", i, tt.expected, result)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package emitter
+
+import (
+	"fmt"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+	"github.com/huderlem/poryscript/parser"
+)
+
+// ScriptLimits are the sizes that a single script can expand to before it
+// is warned about. A script that is larger usually should be split, and is
+// painful to debug in-game.
+type ScriptLimits struct {
+	// The number of commands of the script's chunks, not counting the
+	// commands that branch between them, or 0 for no limit.
+	MaxCommands int
+	// The number of chunks that the script is split into, or 0 for no limit.
+	MaxChunks int
+}
+
+// Warns if the emitted chunks of a script are larger than the emitter's
+// script limits.
+func (e *Emitter) checkScriptSize(scriptStmt *ast.ScriptStatement, script *ir.Script, chunkIDs []int) {
+	numCommands := 0
+	for _, chunkID := range chunkIDs {
+		numCommands += len(script.Chunks[chunkID].Commands)
+	}
+	pos := scriptStmt.Name.Token.Pos()
+	if limit := e.scriptLimits.MaxCommands; limit > 0 && numCommands > limit {
+		e.addWarning(parser.WarningScriptSize, pos, fmt.Sprintf("script '%s' has %d commands, which is more than the limit of %d. Consider splitting it into smaller scripts", script.Name, numCommands, limit))
+	}
+	if limit := e.scriptLimits.MaxChunks; limit > 0 && len(chunkIDs) > limit {
+		e.addWarning(parser.WarningScriptSize, pos, fmt.Sprintf("script '%s' has %d chunks, which is more than the limit of %d. Consider splitting it into smaller scripts", script.Name, len(chunkIDs), limit))
+	}
+}
@@ -0,0 +1,258 @@
+package emitter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+	"github.com/huderlem/poryscript/token"
+)
+
+// pokecrystalBackend emits event scripts and text for pokecrystal, which
+// is built with RGBDS. Gen 2 scripts compare the script var against each
+// value, so var conditions load the var with "readvar" first. Raw
+// statements, directives, and movements are the same as the asm backend's.
+type pokecrystalBackend struct {
+	asmBackend
+	// Scripts that are map callbacks, which end with "endcallback"
+	// instead of "end".
+	callbacks map[string]bool
+}
+
+func newPokecrystalBackend() *pokecrystalBackend {
+	return &pokecrystalBackend{callbacks: make(map[string]bool)}
+}
+
+// TailCallCommand satisfies the TailCallBackend interface. Map callbacks
+// return with "endcallback", which the called script doesn't do, so their
+// calls aren't replaced.
+func (b *pokecrystalBackend) TailCallCommand(script *ir.Script) string {
+	if b.callbacks[script.Name] {
+		return ""
+	}
+	return "scall"
+}
+
+// EmitScript satisfies the Backend interface.
+func (b *pokecrystalBackend) EmitScript(script *ir.Script, chunkIDs []int) (string, error) {
+	returnCommand := "end"
+	if b.callbacks[script.Name] {
+		returnCommand = "endcallback"
+	}
+	renderJump := func(sb *strings.Builder, destChunkID int, nextChunkID int, registerJumpChunk func(int)) bool {
+		if destChunkID == -1 {
+			sb.WriteString(fmt.Sprintf("\t%s\n", returnCommand))
+			return false
+		} else if destChunkID != nextChunkID {
+			registerJumpChunk(destChunkID)
+			sb.WriteString(fmt.Sprintf("\tsjump %s\n", script.ChunkLabel(destChunkID)))
+			return false
+		}
+		return true
+	}
+	return renderScript(script, chunkIDs, ";", func(sb *strings.Builder, branch ir.Branch, nextChunkID int, registerJumpChunk func(int)) (bool, error) {
+		switch br := branch.(type) {
+		case *ir.Return:
+			sb.WriteString(fmt.Sprintf("\t%s\n", returnCommand))
+			return false, nil
+		case *ir.TailCall:
+			sb.WriteString(fmt.Sprintf("\tsjump %s\n", br.Label))
+			return false, nil
+		case *ir.Goto:
+			return renderJump(sb, br.Dest, nextChunkID, registerJumpChunk), nil
+		case *ir.Condition:
+			registerJumpChunk(br.Dest)
+			if err := renderPokecrystalComparison(sb, br.Comparison, script.ChunkLabel(br.Dest)); err != nil {
+				return false, emitErrorf("script '%s': %s", script.Name, err.Error())
+			}
+			return renderJump(sb, br.Else, nextChunkID, registerJumpChunk), nil
+		case *ir.Switch:
+			sb.WriteString(fmt.Sprintf("\treadvar %s\n", br.Operand))
+			for _, switchCase := range br.Cases {
+				registerJumpChunk(switchCase.Dest)
+				sb.WriteString(fmt.Sprintf("\tifequal %s, %s\n", switchCase.Value, script.ChunkLabel(switchCase.Dest)))
+			}
+			return renderJump(sb, br.Default, nextChunkID, registerJumpChunk), nil
+		}
+		return false, nil
+	})
+}
+
+// Renders a comparison that jumps to dest when it is true. Flags are event
+// flags in Gen 2, and there are no "<=" or ">=" checks, so those check for
+// equality separately.
+func renderPokecrystalComparison(sb *strings.Builder, comparison ir.Comparison, dest string) error {
+	switch comparison.Type {
+	case token.FLAG:
+		sb.WriteString(fmt.Sprintf("\tcheckevent %s\n", comparison.Operand))
+		if comparison.IsTrue() {
+			sb.WriteString(fmt.Sprintf("\tiftrue %s\n", dest))
+		} else {
+			sb.WriteString(fmt.Sprintf("\tiffalse %s\n", dest))
+		}
+	case token.VAR:
+		sb.WriteString(fmt.Sprintf("\treadvar %s\n", comparison.Operand))
+		switch comparison.Operator {
+		case token.EQ:
+			sb.WriteString(fmt.Sprintf("\tifequal %s, %s\n", comparison.Value, dest))
+		case token.NEQ:
+			sb.WriteString(fmt.Sprintf("\tifnotequal %s, %s\n", comparison.Value, dest))
+		case token.LT:
+			sb.WriteString(fmt.Sprintf("\tifless %s, %s\n", comparison.Value, dest))
+		case token.LTE:
+			sb.WriteString(fmt.Sprintf("\tifless %s, %s\n", comparison.Value, dest))
+			sb.WriteString(fmt.Sprintf("\tifequal %s, %s\n", comparison.Value, dest))
+		case token.GT:
+			sb.WriteString(fmt.Sprintf("\tifgreater %s, %s\n", comparison.Value, dest))
+		case token.GTE:
+			sb.WriteString(fmt.Sprintf("\tifgreater %s, %s\n", comparison.Value, dest))
+			sb.WriteString(fmt.Sprintf("\tifequal %s, %s\n", comparison.Value, dest))
+		}
+	case token.DEFEATED:
+		return emitErrorf("defeated() is not supported by the pokecrystal target. Check the trainer's event flag with flag() instead")
+	}
+	return nil
+}
+
+// EmitMapScripts satisfies the Backend interface. Map scripts become
+// callbacks, and the entries of table map scripts become scene scripts,
+// with the entry's comparison value as the scene id.
+func (b *pokecrystalBackend) EmitMapScripts(mapScriptStmt *ast.MapScriptsStatement, emitScript func(*ast.ScriptStatement) (string, error)) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(renderPokecrystalLabel(mapScriptStmt.Name.Value, mapScriptStmt.Scope))
+	sb.WriteString("\tdef_scene_scripts\n")
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		for _, scriptEntry := range tableMapScript.Entries {
+			sb.WriteString(fmt.Sprintf("\tscene_script %s, %s\n", scriptEntry.Name, scriptEntry.Comparison))
+		}
+	}
+	sb.WriteString("\n\tdef_callbacks\n")
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		b.callbacks[mapScript.Name] = true
+		sb.WriteString(fmt.Sprintf("\tcallback %s, %s\n", mapScript.Type, mapScript.Name))
+	}
+	sb.WriteString("\n")
+
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		if mapScript.Script != nil {
+			scriptOutput, err := emitScript(mapScript.Script)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(scriptOutput)
+		}
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		for _, scriptEntry := range tableMapScript.Entries {
+			if scriptEntry.Script != nil {
+				scriptOutput, err := emitScript(scriptEntry.Script)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(scriptOutput)
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// EmitAlignment satisfies the Backend interface.
+func (b *pokecrystalBackend) EmitAlignment(annotations ast.Annotations) string {
+	align, ok := annotations.Get("align")
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("\talign %s\n", align.Args[0])
+}
+
+// EmitText satisfies the Backend interface. The text's control codes are
+// converted into the text macros that start each line, and the "$"
+// terminator becomes "done".
+func (b *pokecrystalBackend) EmitText(text ast.Text) (string, error) {
+	var sb strings.Builder
+	if text.IsGlobal {
+		sb.WriteString(fmt.Sprintf("%s::\n", text.Name))
+	} else {
+		sb.WriteString(fmt.Sprintf("%s:\n", text.Name))
+	}
+	value := strings.TrimSuffix(strings.Replace(text.Value, "\n", "", -1), "$")
+	macro := "text"
+	for {
+		end, nextMacro := len(value), ""
+		for code, codeMacro := range pokecrystalTextMacros {
+			if i := strings.Index(value, code); i != -1 && i < end {
+				end, nextMacro = i, codeMacro
+			}
+		}
+		sb.WriteString(fmt.Sprintf("\t%s \"%s\"\n", macro, value[:end]))
+		if end == len(value) {
+			break
+		}
+		value = value[end+2:]
+		macro = nextMacro
+	}
+	sb.WriteString("\tdone\n")
+	return sb.String(), nil
+}
+
+// The control codes of Gen 3 text, and the Gen 2 text macros that start a
+// line in the same way.
+var pokecrystalTextMacros = map[string]string{
+	`\n`: "line",
+	`\l`: "cont",
+	`\p`: "para",
+}
+
+// EmitMart satisfies the Backend interface. Gen 2 marts start with the
+// number of items, and end with -1.
+func (b *pokecrystalBackend) EmitMart(martStmt *ast.MartStatement) (string, error) {
+	terminator := "ITEM_NONE"
+	var sb strings.Builder
+	sb.WriteString(b.EmitAlignment(martStmt.Annotations))
+	sb.WriteString(renderPokecrystalLabel(martStmt.Name.Value, martStmt.Scope))
+	items := []string{}
+	for _, item := range martStmt.MartItems {
+		if item == terminator {
+			break
+		}
+		items = append(items, item)
+	}
+	sb.WriteString(fmt.Sprintf("\tdb %d\n", len(items)))
+	for _, item := range items {
+		sb.WriteString(fmt.Sprintf("\tdb %s\n", item))
+	}
+	sb.WriteString("\tdb -1\n")
+	return sb.String(), nil
+}
+
+// The directives of data values, by their size in bytes.
+var pokecrystalDataDirectives = map[int]string{
+	1: "db",
+	2: "dw",
+	4: "dl",
+}
+
+// EmitData satisfies the Backend interface.
+func (b *pokecrystalBackend) EmitData(dataStmt *ast.DataStatement) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(renderPokecrystalLabel(dataStmt.Name.Value, dataStmt.Scope))
+	for _, row := range dataStmt.Rows {
+		sb.WriteString(fmt.Sprintf("\t%s %s\n", pokecrystalDataDirectives[row.Size], strings.Join(row.Values, ", ")))
+	}
+	return sb.String(), nil
+}
+
+// EmitAlias satisfies the Backend interface. RGBDS can't equate a label to
+// another label, so the alias is a script that jumps to its label.
+func (b *pokecrystalBackend) EmitAlias(aliasStmt *ast.AliasStatement) (string, error) {
+	return fmt.Sprintf("%s\tsjump %s\n", renderPokecrystalLabel(aliasStmt.Name.Value, aliasStmt.Scope), aliasStmt.Target.Value), nil
+}
+
+func renderPokecrystalLabel(name string, scope token.Type) string {
+	if scope == token.GLOBAL {
+		return fmt.Sprintf("%s::\n", name)
+	}
+	return fmt.Sprintf("%s:\n", name)
+}
@@ -0,0 +1,130 @@
+package emitter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huderlem/poryscript/ir"
+)
+
+// SourceMapping maps a line of the output to the line of the Poryscript
+// source that it was compiled from.
+type SourceMapping struct {
+	// The line of the output, starting at 1.
+	Line       int    `json:"line"`
+	File       string `json:"file"`
+	SourceLine int    `json:"sourceLine"`
+}
+
+// LineDirectiveFormat is the syntax of the directives that tell the
+// assembler which line of the Poryscript source the output was compiled
+// from, so that it reports errors at that line.
+type LineDirectiveFormat string
+
+// Formats of line directives.
+const (
+	NoLineDirectives LineDirectiveFormat = ""
+	// Like `#line 12 "myscript.pory"`, which the C preprocessor understands.
+	// pokeemerald runs its assembly through the C preprocessor, and the c
+	// target is compiled by a C compiler.
+	CppLineDirectives LineDirectiveFormat = "line"
+	// Like `# 12 "myscript.pory"`, which GNU as understands without the C
+	// preprocessor.
+	GasLineDirectives LineDirectiveFormat = "gas"
+)
+
+// ParseLineDirectiveFormat parses a line directive option, which is "none",
+// "line", or "gas".
+func ParseLineDirectiveFormat(value string) (LineDirectiveFormat, error) {
+	switch value {
+	case "none":
+		return NoLineDirectives, nil
+	case string(CppLineDirectives), string(GasLineDirectives):
+		return LineDirectiveFormat(value), nil
+	}
+	return NoLineDirectives, fmt.Errorf("invalid line directives '%s'. Expected 'none', 'line', or 'gas'", value)
+}
+
+// Renders a directive, which sets the line number and file of the line
+// after it.
+func (f LineDirectiveFormat) render(line int, filepath string) string {
+	if f == CppLineDirectives {
+		return fmt.Sprintf("#line %d \"%s\"\n", line, filepath)
+	}
+	return fmt.Sprintf("# %d \"%s\"\n", line, filepath)
+}
+
+// The prefixes of the source comments of each backend.
+var sourceCommentPrefixes = []string{"@", ";", "//"}
+
+// Returns true if the commands of the emitted scripts need to know where
+// they are in the Poryscript source.
+func (e *Emitter) needsSources() bool {
+	return e.sourceComments || e.sourceMap || e.lineDirectives != NoLineDirectives
+}
+
+// Builds the source map from the source comments of the output. The source
+// comments are followed by line directives, if they were asked for, and
+// they are removed unless they were asked for, too. Every line after a
+// source comment is mapped to its location, up to the next source comment,
+// blank line, or label. After that, a line directive switches back to the
+// output file. Only the output file has a source map and line directives,
+// so the source comments of other outputs are only removed.
+func (e *Emitter) processSourceComments(output string, isOutputFile bool) string {
+	lineDirectives := NoLineDirectives
+	if isOutputFile {
+		e.sourceMappings = []SourceMapping{}
+		lineDirectives = e.lineDirectives
+	}
+	var sb strings.Builder
+	lineNumber := 0
+	writeLine := func(line string) {
+		sb.WriteString(line)
+		lineNumber++
+	}
+	var current *ir.Location
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if source, ok := e.getSourceComment(line); ok {
+			current = &source
+			if e.sourceComments {
+				writeLine(line)
+			}
+			if lineDirectives != NoLineDirectives {
+				writeLine(lineDirectives.render(source.Line, source.Filepath))
+			}
+			continue
+		}
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasSuffix(trimmed, ":") {
+			if current != nil && lineDirectives != NoLineDirectives {
+				// The line after the directive is the line after it in
+				// the output file.
+				writeLine(lineDirectives.render(lineNumber+2, e.outputFilepath))
+			}
+			current = nil
+		} else if current != nil && isOutputFile {
+			e.sourceMappings = append(e.sourceMappings, SourceMapping{Line: lineNumber + 1, File: current.Filepath, SourceLine: current.Line})
+		}
+		writeLine(line)
+	}
+	if current != nil && lineDirectives != NoLineDirectives {
+		writeLine(lineDirectives.render(lineNumber+2, e.outputFilepath))
+	}
+	return sb.String()
+}
+
+// Returns the location of a source comment. Only the locations of the
+// emitted scripts are recognized, so that raw statements aren't mistaken for
+// source comments.
+func (e *Emitter) getSourceComment(line string) (ir.Location, bool) {
+	line = strings.TrimSpace(line)
+	for _, prefix := range sourceCommentPrefixes {
+		if strings.HasPrefix(line, prefix+" ") {
+			source, ok := e.sourceLocations[strings.TrimPrefix(line, prefix+" ")]
+			return source, ok
+		}
+	}
+	return ir.Location{}, false
+}
@@ -0,0 +1,112 @@
+package emitter
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+)
+
+// Stats are the sizes of an emitted program, which help to keep track of
+// how much of the ROM's space its scripts use.
+type Stats struct {
+	// The scripts, including the scripts of map scripts.
+	Scripts int `json:"scripts"`
+	// The commands of the scripts, including the commands that branch
+	// between their chunks. Each branch counts as a single command.
+	Commands int `json:"commands"`
+	// The chunks that the scripts are split into.
+	Chunks int `json:"chunks"`
+	Texts  int `json:"texts"`
+	// An estimate of the size of the texts in the game's text encoding.
+	// Each character and control code, like "\p", is one byte, and each
+	// placeholder, like "{PLAYER}", is two bytes.
+	TextBytes int `json:"textBytes"`
+	// The commands and chunks that optimizing the scripts saved, compared to
+	// the unoptimized scripts. They are 0 if the emitter doesn't optimize.
+	SavedCommands int `json:"savedCommands"`
+	SavedChunks   int `json:"savedChunks"`
+}
+
+// Adds the sizes of an emitted script to the emitter's stats. If the
+// emitter optimizes, the script is lowered again without optimizing it, to
+// measure the savings.
+func (e *Emitter) addScriptStats(scriptStmt *ast.ScriptStatement, script *ir.Script, chunkIDs []int) error {
+	commands := countCommands(script, chunkIDs)
+	e.stats.Scripts++
+	e.stats.Commands += commands
+	e.stats.Chunks += len(chunkIDs)
+	if e.optimizationLevel == NoOptimization {
+		return nil
+	}
+	unoptimized, err := e.lowerScript(scriptStmt, NoOptimization)
+	if err != nil {
+		return err
+	}
+	unoptimizedChunkIDs := unoptimized.SortedChunkIDs()
+	e.stats.SavedCommands += countCommands(unoptimized, unoptimizedChunkIDs) - commands
+	e.stats.SavedChunks += len(unoptimizedChunkIDs) - len(chunkIDs)
+	return nil
+}
+
+func (e *Emitter) addTextStats(text ast.Text) {
+	e.stats.Texts++
+	e.stats.TextBytes += estimateTextBytes(text.Value)
+}
+
+// Counts the commands of a script's chunks, when they are placed in the
+// given order. A chunk that falls through to the next chunk doesn't need a
+// command to branch there.
+func countCommands(script *ir.Script, chunkIDs []int) int {
+	count := 0
+	for i, chunkID := range chunkIDs {
+		nextChunkID := -1
+		if i < len(chunkIDs)-1 {
+			nextChunkID = chunkIDs[i+1]
+		}
+		jumps := func(destChunkID int) int {
+			if destChunkID != -1 && destChunkID == nextChunkID {
+				return 0
+			}
+			return 1
+		}
+		chunk := script.Chunks[chunkID]
+		count += len(chunk.Commands)
+		switch branch := chunk.Branch.(type) {
+		case *ir.Goto:
+			count += jumps(branch.Dest)
+		case *ir.Condition:
+			count += 1 + jumps(branch.Else)
+		case *ir.Switch:
+			count += len(branch.Cases) + jumps(branch.Default)
+		default:
+			count++
+		}
+	}
+	return count
+}
+
+// Estimates the size of a text in the game's text encoding, in bytes.
+func estimateTextBytes(value string) int {
+	value = strings.Replace(value, "\n", "", -1)
+	size := 0
+	for i := 0; i < len(value); {
+		switch value[i] {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+			}
+		case '{':
+			if end := strings.IndexByte(value[i:], '}'); end != -1 {
+				size += 2
+				i += end + 1
+				continue
+			}
+		}
+		_, width := utf8.DecodeRuneInString(value[i:])
+		i += width
+		size++
+	}
+	return size
+}
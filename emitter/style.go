@@ -0,0 +1,191 @@
+package emitter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// OutputStyle is the formatting of the emitted output, so that it can match
+// a project's existing files. Raw statements and directives are written as
+// they are.
+type OutputStyle struct {
+	// The indentation of commands and data, which is a tab by default.
+	Indent string
+	// The number of newlines between top-level statements.
+	BlankLines int
+	// Pads the commands of each block of indented lines, so that their
+	// arguments start in the same column.
+	AlignArgs bool
+	// The line ending of every line, including the lines of raw statements.
+	// An empty line ending is "\n".
+	LineEnding string
+	// The column that long lines of text, like `.string "..."`, are wrapped
+	// at, or 0 to never wrap them. Columns are counted in characters.
+	WrapColumn int
+}
+
+// DefaultOutputStyle is the style of the output when no style is set.
+var DefaultOutputStyle = OutputStyle{Indent: "\t", BlankLines: 1, LineEnding: "\n"}
+
+// ParseIndent parses an indentation option, which is either "tab", or the
+// number of spaces to indent with.
+func ParseIndent(value string) (string, error) {
+	if value == "tab" {
+		return "\t", nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("invalid indent '%s'. Expected 'tab' or a number of spaces", value)
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+// ParseLineEnding parses a line ending option, which is either "lf" or "crlf".
+func ParseLineEnding(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "lf":
+		return "\n", nil
+	case "crlf":
+		return "\r\n", nil
+	}
+	return "", fmt.Errorf("invalid line ending '%s'. Expected 'lf' or 'crlf'", value)
+}
+
+// Converts every line ending of the output to the style's line ending, so
+// that the output never has mixed line endings.
+func (s OutputStyle) applyLineEnding(output string) string {
+	output = strings.Replace(output, "\r\n", "\n", -1)
+	if s.LineEnding == "" || s.LineEnding == "\n" {
+		return output
+	}
+	return strings.Replace(output, "\n", s.LineEnding, -1)
+}
+
+// Applies the style to the output of a statement. The backends indent with
+// a single tab.
+func (s OutputStyle) apply(output string) string {
+	if s.Indent == "\t" && !s.AlignArgs {
+		return output
+	}
+	lines := strings.Split(output, "\n")
+	for start := 0; start < len(lines); start++ {
+		if !strings.HasPrefix(lines[start], "\t") {
+			continue
+		}
+		end := start
+		width := 0
+		for ; end < len(lines) && strings.HasPrefix(lines[end], "\t"); end++ {
+			if fields := splitAlignedLine(lines[end][1:]); len(fields) == 2 && len(fields[0]) > width {
+				width = len(fields[0])
+			}
+		}
+		for i := start; i < end; i++ {
+			line := lines[i][1:]
+			if s.AlignArgs {
+				if fields := splitAlignedLine(line); len(fields) == 2 {
+					line = fmt.Sprintf("%-*s %s", width, fields[0], fields[1])
+				}
+			}
+			lines[i] = s.Indent + line
+		}
+		start = end
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Splits an indented line into its command and arguments. Comments aren't
+// aligned, so they aren't split.
+func splitAlignedLine(line string) []string {
+	for _, commentPrefix := range sourceCommentPrefixes {
+		if strings.HasPrefix(line, commentPrefix) {
+			return []string{line}
+		}
+	}
+	return strings.SplitN(line, " ", 2)
+}
+
+// Splits the text lines of the output that are longer than the style's wrap
+// column into continuation lines with the same directive, like ".string".
+// Lines are split after the last space that fits, and never inside a
+// control code or placeholder.
+func (s OutputStyle) wrapText(output string) string {
+	if s.WrapColumn <= 0 {
+		return output
+	}
+	var sb strings.Builder
+	for _, line := range strings.SplitAfter(output, "\n") {
+		match := textLinePattern.FindStringSubmatch(strings.TrimSuffix(line, "\n"))
+		if match == nil || utf8.RuneCountInString(line) <= s.WrapColumn {
+			sb.WriteString(line)
+			continue
+		}
+		prefix, content, suffix := match[1], []rune(match[2]), match[3]
+		// The content of each line must fit between the prefix and the
+		// closing quote and language.
+		width := s.WrapColumn - utf8.RuneCountInString(prefix+suffix) - 1
+		for len(content) > 0 {
+			end := len(content)
+			if end > width {
+				end = getTextWrapIndex(content, width)
+			}
+			sb.WriteString(fmt.Sprintf("%s%s\"%s\n", prefix, string(content[:end]), suffix))
+			content = content[end:]
+		}
+	}
+	return sb.String()
+}
+
+// Matches a line of text, like `\t.string "Hello$"` or
+// `\t.string "Hello$", JAPANESE`. The groups are the line up to the opening
+// quote, the text, and the language argument.
+var textLinePattern = regexp.MustCompile(`^(\s*\.\w+ ")(.*)"(, \w+)?$`)
+
+// Returns the index to wrap a line of text at, so that at most width
+// characters of it are on the line.
+func getTextWrapIndex(content []rune, width int) int {
+	if width < 1 {
+		width = 1
+	}
+	// Indexes that wrap the text between control codes and placeholders,
+	// like "\p" and "{PLAYER}".
+	safe := make([]bool, len(content)+1)
+	inPlaceholder := false
+	for i := 0; i < len(content); i++ {
+		safe[i] = !inPlaceholder
+		switch content[i] {
+		case '{':
+			inPlaceholder = true
+		case '}':
+			inPlaceholder = false
+		case '\\':
+			if i+1 < len(content) {
+				i++
+			}
+		}
+	}
+	lastSafe := -1
+	for i := width; i > 0; i-- {
+		if !safe[i] {
+			continue
+		}
+		if content[i-1] == ' ' {
+			return i
+		}
+		if lastSafe == -1 {
+			lastSafe = i
+		}
+	}
+	if lastSafe != -1 {
+		return lastSafe
+	}
+	// The line starts with a placeholder that is longer than the width.
+	for i := width + 1; i < len(content); i++ {
+		if safe[i] {
+			return i
+		}
+	}
+	return len(content)
+}
@@ -0,0 +1,78 @@
+package emitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// TargetConfig describes the project-specific output of a target, which is
+// read from a JSON file.
+type TargetConfig struct {
+	// The boilerplate around each kind of top-level statement, by the
+	// kinds of the symbols: "script", "mapscripts", "movement", "mart",
+	// "data", or "text".
+	Directives map[string]StatementDirectives `json:"directives"`
+}
+
+// StatementDirectives are the lines that are emitted before and after a
+// statement, like ".align 2" or a ".section" directive. Lines that start
+// with a tab are indented with the output style's indentation.
+type StatementDirectives struct {
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+// The kinds of statements that can have directives.
+var directiveStatementKinds = map[string]bool{
+	"script":     true,
+	"mapscripts": true,
+	"movement":   true,
+	"mart":       true,
+	"data":       true,
+	"text":       true,
+}
+
+// LoadTargetConfig reads a target config from a JSON file.
+func LoadTargetConfig(filepath string) (TargetConfig, error) {
+	var config TargetConfig
+	bytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return config, err
+	}
+	// The kinds are validated in order, so that the same error is reported
+	// every time.
+	kinds := make([]string, 0, len(config.Directives))
+	for kind := range config.Directives {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if !directiveStatementKinds[kind] {
+			return config, fmt.Errorf("invalid statement kind '%s' of directives. Expected 'script', 'mapscripts', 'movement', 'mart', 'data', or 'text'", kind)
+		}
+	}
+	return config, nil
+}
+
+// Renders the directives before or after a statement of the given kind.
+func (e *Emitter) renderDirectives(kind string, before bool) string {
+	directives, ok := e.targetConfig.Directives[kind]
+	if !ok {
+		return ""
+	}
+	lines := directives.After
+	if before {
+		lines = directives.Before
+	}
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line + "\n")
+	}
+	return e.style.apply(sb.String())
+}
@@ -0,0 +1,86 @@
+package emitter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/huderlem/poryscript/ast"
+)
+
+// TextOrder is the order of the texts, which are emitted after the other
+// statements of the program. Each project can pick the order that changes
+// the least when its scripts are edited.
+type TextOrder string
+
+// Orders of the texts.
+const (
+	// The inline texts in the order they are used, followed by the text
+	// statements in the order they are defined.
+	InlineFirstTextOrder TextOrder = ""
+	// The order that the texts are defined in. Inline texts are defined at
+	// the statement that uses them first.
+	SourceTextOrder TextOrder = "source"
+	// The order that the texts are first used in by the emitted statements.
+	// Texts that aren't used by any statement come last.
+	FirstUseTextOrder TextOrder = "first-use"
+	// Alphabetical order of the texts' labels.
+	AlphabeticalTextOrder TextOrder = "alphabetical"
+)
+
+// ParseTextOrder parses a text order option, which is "inline-first",
+// "source", "first-use", or "alphabetical".
+func ParseTextOrder(value string) (TextOrder, error) {
+	switch value {
+	case "inline-first":
+		return InlineFirstTextOrder, nil
+	case string(SourceTextOrder), string(FirstUseTextOrder), string(AlphabeticalTextOrder):
+		return TextOrder(value), nil
+	}
+	return InlineFirstTextOrder, fmt.Errorf("invalid text order '%s'. Expected 'inline-first', 'source', 'first-use', or 'alphabetical'", value)
+}
+
+// Returns the texts of the program in the emitter's text order.
+func (e *Emitter) getOrderedTexts() []ast.Text {
+	texts := e.program.Texts
+	switch e.textOrder {
+	case AlphabeticalTextOrder:
+		sorted := append([]ast.Text{}, texts...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Name < sorted[j].Name
+		})
+		return sorted
+	case SourceTextOrder, FirstUseTextOrder:
+		textsByName := make(map[string]ast.Text, len(texts))
+		for _, text := range texts {
+			textsByName[text.Name] = text
+		}
+		ordered := make([]ast.Text, 0, len(texts))
+		add := func(name string) {
+			if text, ok := textsByName[name]; ok {
+				ordered = append(ordered, text)
+				delete(textsByName, name)
+			}
+		}
+		for _, stmt := range e.program.TopLevelStatements {
+			if textStmt, ok := stmt.(*ast.TextStatement); ok {
+				if e.textOrder == SourceTextOrder {
+					add(textStmt.Name.Value)
+				}
+				continue
+			}
+			ast.Inspect(stmt, func(node ast.Node) bool {
+				if command, ok := node.(*ast.CommandStatement); ok {
+					for _, arg := range command.Args {
+						add(arg)
+					}
+				}
+				return true
+			})
+		}
+		for _, text := range texts {
+			add(text.Name)
+		}
+		return ordered
+	}
+	return texts
+}
@@ -0,0 +1,292 @@
+package emitter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/ir"
+	"github.com/huderlem/poryscript/token"
+)
+
+// xseBackend emits scripts in the text format of XSE, which XSE and
+// HexManiacAdvance compile into a binary ROM. Each label is a dynamic
+// "#org" section, which the compiler places anywhere in free space, so
+// sections can't fall through to each other.
+type xseBackend struct {
+	// The labels that are defined in the program. References to them are
+	// prefixed with "@".
+	labels map[string]bool
+}
+
+// BeginProgram satisfies the ProgramBackend interface.
+func (b *xseBackend) BeginProgram(program *ast.Program) {
+	b.labels = make(map[string]bool)
+	for _, stmt := range program.TopLevelStatements {
+		switch s := stmt.(type) {
+		case *ast.ScriptStatement:
+			b.labels[s.Name.Value] = true
+		case *ast.MovementStatement:
+			b.labels[s.Name.Value] = true
+		case *ast.MartStatement:
+			b.labels[s.Name.Value] = true
+		case *ast.DataStatement:
+			b.labels[s.Name.Value] = true
+		case *ast.AliasStatement:
+			b.labels[s.Name.Value] = true
+		case *ast.MapScriptsStatement:
+			b.labels[s.Name.Value] = true
+			for _, mapScript := range s.MapScripts {
+				b.labels[mapScript.Name] = true
+			}
+			for _, tableMapScript := range s.TableMapScripts {
+				b.labels[tableMapScript.Name] = true
+				for _, scriptEntry := range tableMapScript.Entries {
+					b.labels[scriptEntry.Name] = true
+				}
+			}
+		}
+	}
+	for _, text := range program.Texts {
+		b.labels[text.Name] = true
+	}
+}
+
+// EndProgram satisfies the ProgramBackend interface.
+func (b *xseBackend) EndProgram(output string) (string, error) {
+	return output, nil
+}
+
+// Returns a command argument, with the "@" prefix if it refers to a label.
+func (b *xseBackend) renderArg(arg string) string {
+	if b.labels[arg] {
+		return "@" + arg
+	}
+	return arg
+}
+
+// TailCallCommand satisfies the TailCallBackend interface.
+func (b *xseBackend) TailCallCommand(script *ir.Script) string {
+	return "call"
+}
+
+// EmitScript satisfies the Backend interface. Each group of chunks is its
+// own section.
+func (b *xseBackend) EmitScript(script *ir.Script, chunkIDs []int) (string, error) {
+	var sb strings.Builder
+	for i, group := range ir.GroupChunks(script, chunkIDs) {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(fmt.Sprintf("#org @%s\n", script.ChunkLabel(group[0])))
+		for j, chunkID := range group {
+			chunk := script.Chunks[chunkID]
+			renderSourceComment(&sb, chunk, "//")
+			for _, command := range chunk.Commands {
+				sb.WriteString(command.Name)
+				for _, arg := range command.Args {
+					sb.WriteString(" " + b.renderArg(arg))
+				}
+				sb.WriteString("\n")
+			}
+			if tailCall, ok := chunk.Branch.(*ir.TailCall); ok {
+				sb.WriteString(fmt.Sprintf("goto %s\n", b.renderArg(tailCall.Label)))
+			} else {
+				renderXSEBranch(&sb, chunk.Branch, script, getNextGroupChunkID(group, j))
+			}
+		}
+	}
+	sb.WriteString("\n")
+	return sb.String(), nil
+}
+
+// Returns the id of the chunk after the given index of a group of chunks,
+// or -2 if it is the last chunk of the group. Unlike -1, -2 is never the
+// destination of a branch.
+func getNextGroupChunkID(group []int, i int) int {
+	if i < len(group)-1 {
+		return group[i+1]
+	}
+	return -2
+}
+
+// Renders the commands that branch from a chunk.
+func renderXSEBranch(sb *strings.Builder, branch ir.Branch, script *ir.Script, nextChunkID int) {
+	switch b := branch.(type) {
+	case *ir.Return:
+		if b.End {
+			sb.WriteString("end\n")
+		} else {
+			sb.WriteString("return\n")
+		}
+	case *ir.Goto:
+		renderXSEGoto(sb, b.Dest, script, nextChunkID)
+	case *ir.Condition:
+		renderXSEComparison(sb, b.Comparison, "@"+script.ChunkLabel(b.Dest))
+		renderXSEGoto(sb, b.Else, script, nextChunkID)
+	case *ir.Switch:
+		sb.WriteString(fmt.Sprintf("copyvar %s %s\n", switchVar, b.Operand))
+		for _, switchCase := range b.Cases {
+			sb.WriteString(fmt.Sprintf("compare %s %s\n", switchVar, switchCase.Value))
+			sb.WriteString(fmt.Sprintf("if 0x%d goto @%s\n", conditionCodes[token.EQ], script.ChunkLabel(switchCase.Dest)))
+		}
+		renderXSEGoto(sb, b.Default, script, nextChunkID)
+	}
+}
+
+// Renders an unconditional jump to a chunk, unless it is the next chunk.
+// A destination of -1 returns from the script instead.
+func renderXSEGoto(sb *strings.Builder, destChunkID int, script *ir.Script, nextChunkID int) {
+	if destChunkID == -1 {
+		sb.WriteString("return\n")
+	} else if destChunkID != nextChunkID {
+		sb.WriteString(fmt.Sprintf("goto @%s\n", script.ChunkLabel(destChunkID)))
+	}
+}
+
+func renderXSEComparison(sb *strings.Builder, comparison ir.Comparison, dest string) {
+	switch comparison.Type {
+	case token.FLAG:
+		sb.WriteString(fmt.Sprintf("checkflag %s\n", comparison.Operand))
+		renderXSEBooleanIf(sb, comparison.IsTrue(), dest)
+	case token.VAR:
+		sb.WriteString(fmt.Sprintf("compare %s %s\n", comparison.Operand, comparison.Value))
+		sb.WriteString(fmt.Sprintf("if 0x%d goto %s\n", conditionCodes[comparison.Operator], dest))
+	case token.DEFEATED:
+		sb.WriteString(fmt.Sprintf("checktrainerflag %s\n", comparison.Operand))
+		renderXSEBooleanIf(sb, comparison.IsTrue(), dest)
+	}
+}
+
+func renderXSEBooleanIf(sb *strings.Builder, isSet bool, dest string) {
+	if isSet {
+		sb.WriteString(fmt.Sprintf("if 0x1 goto %s\n", dest))
+	} else {
+		sb.WriteString(fmt.Sprintf("if 0x0 goto %s\n", dest))
+	}
+}
+
+// EmitMapScripts satisfies the Backend interface. The map script headers
+// are written as raw data.
+func (b *xseBackend) EmitMapScripts(mapScriptStmt *ast.MapScriptsStatement, emitScript func(*ast.ScriptStatement) (string, error)) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("#org @%s\n", mapScriptStmt.Name.Value))
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		sb.WriteString(fmt.Sprintf("#raw byte %s\n#raw pointer @%s\n", mapScript.Type, mapScript.Name))
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		sb.WriteString(fmt.Sprintf("#raw byte %s\n#raw pointer @%s\n", tableMapScript.Type, tableMapScript.Name))
+	}
+	sb.WriteString("#raw byte 0x0\n\n")
+
+	for _, mapScript := range mapScriptStmt.MapScripts {
+		if mapScript.Script != nil {
+			scriptOutput, err := emitScript(mapScript.Script)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(scriptOutput)
+		}
+	}
+	for _, tableMapScript := range mapScriptStmt.TableMapScripts {
+		sb.WriteString(fmt.Sprintf("#org @%s\n", tableMapScript.Name))
+		for _, scriptEntry := range tableMapScript.Entries {
+			sb.WriteString(fmt.Sprintf("#raw word %s\n#raw word %s\n#raw pointer @%s\n", scriptEntry.Condition, scriptEntry.Comparison, scriptEntry.Name))
+		}
+		sb.WriteString("#raw word 0x0\n\n")
+		for _, scriptEntry := range tableMapScript.Entries {
+			if scriptEntry.Script != nil {
+				scriptOutput, err := emitScript(scriptEntry.Script)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(scriptOutput)
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// EmitAlignment satisfies the Backend interface. Sections are placed by the
+// compiler, so alignment is ignored.
+func (b *xseBackend) EmitAlignment(annotations ast.Annotations) string {
+	return ""
+}
+
+// EmitText satisfies the Backend interface. XSE text is a single line,
+// which uses the same control codes, and is terminated by the compiler.
+func (b *xseBackend) EmitText(text ast.Text) (string, error) {
+	value := strings.TrimSuffix(strings.Replace(text.Value, "\n", "", -1), "$")
+	return fmt.Sprintf("#org @%s\n= %s\n", text.Name, value), nil
+}
+
+// EmitRaw satisfies the Backend interface.
+func (b *xseBackend) EmitRaw(rawStmt *ast.RawStatement) (string, error) {
+	return fmt.Sprintf("%s\n", rawStmt.Value), nil
+}
+
+// EmitDirective satisfies the Backend interface.
+func (b *xseBackend) EmitDirective(directiveStmt *ast.DirectiveStatement) (string, error) {
+	var sb strings.Builder
+	for _, line := range strings.Split(directiveStmt.Value, "\n") {
+		sb.WriteString(fmt.Sprintf("%s\n", strings.TrimSpace(line)))
+	}
+	return sb.String(), nil
+}
+
+// EmitMovement satisfies the Backend interface. Movements are raw bytes.
+func (b *xseBackend) EmitMovement(movementStmt *ast.MovementStatement) (string, error) {
+	terminator := "step_end"
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("#org @%s\n", movementStmt.Name.Value))
+	for _, cmd := range movementStmt.MovementCommands {
+		sb.WriteString(fmt.Sprintf("#raw %s\n", cmd))
+		if cmd == terminator {
+			return sb.String(), nil
+		}
+	}
+	sb.WriteString(fmt.Sprintf("#raw %s\n", terminator))
+	return sb.String(), nil
+}
+
+// EmitMart satisfies the Backend interface. Marts are raw halfwords.
+func (b *xseBackend) EmitMart(martStmt *ast.MartStatement) (string, error) {
+	terminator := "ITEM_NONE"
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("#org @%s\n", martStmt.Name.Value))
+	for _, item := range martStmt.MartItems {
+		if item == terminator {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("#raw word %s\n", item))
+	}
+	sb.WriteString("#raw word 0x0\n")
+	return sb.String(), nil
+}
+
+// The types of raw values, by their size in bytes.
+var xseRawTypes = map[int]string{
+	1: "byte",
+	2: "word",
+	4: "dword",
+}
+
+// EmitData satisfies the Backend interface. Data is raw values, and
+// references to labels are pointers.
+func (b *xseBackend) EmitData(dataStmt *ast.DataStatement) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("#org @%s\n", dataStmt.Name.Value))
+	for _, row := range dataStmt.Rows {
+		for _, value := range row.Values {
+			sb.WriteString(fmt.Sprintf("#raw %s %s\n", xseRawTypes[row.Size], b.renderArg(value)))
+		}
+	}
+	return sb.String(), nil
+}
+
+// EmitAlias satisfies the Backend interface. Sections are placed anywhere
+// in free space, so the alias is a script that jumps to its label.
+func (b *xseBackend) EmitAlias(aliasStmt *ast.AliasStatement) (string, error) {
+	return fmt.Sprintf("#org @%s\ngoto %s\n", aliasStmt.Name.Value, b.renderArg(aliasStmt.Target.Value)), nil
+}
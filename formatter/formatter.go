@@ -0,0 +1,146 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/parser"
+	"github.com/huderlem/poryscript/printer"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Format returns the canonical formatting of the given Poryscript source code.
+func Format(input string) (string, error) {
+	return FormatWithMode(input, 0)
+}
+
+// FormatWithMode returns the canonical formatting of the given Poryscript
+// source code, which is read with the given lexer modes. With the
+// CaseInsensitiveKeywords mode, keywords are written in their canonical case.
+// The source code is parsed, so errors in its syntax are returned, and the
+// program is printed with its comments by the printer package.
+func FormatWithMode(input string, mode lexer.Mode) (string, error) {
+	p := parser.New(lexer.NewWithMode(input, mode|lexer.ScanComments), "", nil)
+	p.SetSyntaxOnly(true)
+	program, err := p.ParseProgram()
+	if err != nil {
+		return "", err
+	}
+	output, err := printer.Format(program, lexer.NewWithMode(input, mode))
+	if err != nil {
+		return "", err
+	}
+	if err := checkEquivalent(input, output, mode); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// Returns the line on which a token ends.
+func getEndLineNumber(tok token.Token) int {
+	switch tok.Type {
+	case token.STRING:
+		return tok.LineNumber + strings.Count(tok.Literal, "\n")
+	case token.RAWSTRING:
+		return tok.End.Line
+	}
+	return tok.LineNumber
+}
+
+func isBlockOpen(tok token.Token) bool {
+	return tok.Type == token.LBRACE || tok.Type == token.LBRACKET
+}
+
+// Verifies that the formatted output produces the same tokens as the
+// original input, ignoring comments, so that formatting never changes
+// the meaning of a file.
+func checkEquivalent(input string, output string, mode lexer.Mode) error {
+	inputLexer := lexer.NewWithMode(input, mode)
+	outputLexer := lexer.NewWithMode(output, mode)
+	for {
+		inputToken := inputLexer.NextToken()
+		outputToken := outputLexer.NextToken()
+		if inputToken.Type != outputToken.Type || inputToken.Literal != outputToken.Literal {
+			return fmt.Errorf("line %d:%d: failed to format '%s' without changing its meaning", inputToken.LineNumber, inputToken.Column, inputToken.Literal)
+		}
+		if inputToken.Type == token.EOF {
+			return nil
+		}
+	}
+}
+
+// FormatRange formats the top-level statements that overlap the given
+// range of lines, which are 1-based and inclusive. The rest of the input
+// is kept verbatim, which allows editors to format a selection without
+// reformatting the whole file.
+func FormatRange(input string, startLine int, endLine int) (string, error) {
+	if startLine < 1 || endLine < startLine {
+		return "", fmt.Errorf("invalid line range %d-%d", startLine, endLine)
+	}
+
+	// Find the lines on which top-level statements begin. The range is
+	// expanded to these boundaries, so that only complete statements
+	// are formatted.
+	boundaries := []int{1}
+	depth := 0
+	prevLine := 0
+	l := lexer.NewWithMode(input, lexer.ScanComments)
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+		if depth == 0 && tok.LineNumber > prevLine && !isBlockOpen(tok) {
+			boundaries = append(boundaries, tok.LineNumber)
+		}
+		switch tok.Type {
+		case token.LBRACE, token.LBRACKET, token.LPAREN:
+			depth++
+		case token.RBRACE, token.RBRACKET, token.RPAREN:
+			if depth > 0 {
+				depth--
+			}
+		}
+		prevLine = getEndLineNumber(tok)
+	}
+
+	rangeStart := 1
+	rangeEnd := -1
+	for _, boundary := range boundaries {
+		if boundary <= startLine {
+			rangeStart = boundary
+		} else if boundary > endLine && rangeEnd == -1 {
+			rangeEnd = boundary - 1
+		}
+	}
+
+	lines := strings.SplitAfter(input, "\n")
+	if rangeEnd == -1 || rangeEnd > len(lines) {
+		rangeEnd = len(lines)
+	}
+	if rangeStart > len(lines) {
+		return input, nil
+	}
+	selection := strings.Join(lines[rangeStart-1:rangeEnd], "")
+	if strings.TrimSpace(selection) == "" {
+		return input, nil
+	}
+	formatted, err := Format(selection)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(selection, "\n") {
+		formatted = strings.TrimSuffix(formatted, "\n")
+	} else if strings.HasSuffix(selection, "\n\n") {
+		// Keep the blank lines that separate the selection from the
+		// following statements.
+		formatted += selection[len(strings.TrimRight(selection, "\n"))+1:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.Join(lines[:rangeStart-1], ""))
+	sb.WriteString(formatted)
+	sb.WriteString(strings.Join(lines[rangeEnd:], ""))
+	return sb.String(), nil
+}
@@ -0,0 +1,301 @@
+package formatter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/parser"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: `# Header comment
+@deprecated("use Other")
+script(local) MyScript
+{
+  lock   // trailing comment
+	if (flag(FLAG_1) &&!flag(FLAG_2)) { msgbox("Hello"
+  "World", MSGBOX_DEFAULT) }
+	elif (var(VAR_1)>=2) {
+	}
+	else {
+	switch (var(VAR_1)) {
+	case 1: foo
+	case 2:
+	bar
+	if (flag(FLAG_3)) { baz }
+	default:
+	  qux
+	}
+	}
+
+
+	do { a } while (flag(FLAG_4))
+	msgbox(ascii"Hi")
+}
+`,
+			expected: `# Header comment
+@deprecated("use Other")
+script(local) MyScript {
+    lock // trailing comment
+    if (flag(FLAG_1) && !flag(FLAG_2)) {
+        msgbox("Hello"
+            "World", MSGBOX_DEFAULT)
+    } elif (var(VAR_1) >= 2) {
+    } else {
+        switch (var(VAR_1)) {
+            case 1:
+                foo
+            case 2:
+                bar
+                if (flag(FLAG_3)) {
+                    baz
+                }
+            default:
+                qux
+        }
+    }
+
+    do {
+        a
+    } while (flag(FLAG_4))
+    msgbox(ascii"Hi")
+}
+`,
+		},
+		{
+			input: `raw ` + "`" + `
+	.byte 1
+` + "`" + `
+movement(global) MyMovement { walk_left*2 }
+text MyText {
+"Line 1\n"
+	"Line 2"
+}
+mapscripts MyMapScripts {
+  MAP_SCRIPT_ON_LOAD: Foo
+  MAP_SCRIPT_ON_FRAME_TABLE [
+    VAR_TEMP_0, 0: Bar
+  ]
+}
+const FOO=1`,
+			expected: `raw ` + "`" + `
+	.byte 1
+` + "`" + `
+movement(global) MyMovement {
+    walk_left * 2
+}
+text MyText {
+    "Line 1\n"
+    "Line 2"
+}
+mapscripts MyMapScripts {
+    MAP_SCRIPT_ON_LOAD: Foo
+    MAP_SCRIPT_ON_FRAME_TABLE [
+        VAR_TEMP_0, 0: Bar
+    ]
+}
+const FOO = 1
+`,
+		},
+		{
+			input: `const X = 1
+macro Greet(message) { msgbox(message) }
+script MyScript { lock faceplayer call(X) end
+	switch (var(VAR_1)) { case 1: msgbox("x") case 2: # Two
+	Greet("Hi") release
+	}
+	call Other(1, 2) poryswitch(GAME) { RUBY: lock _: release }
+
+	# Last
+}
+mapscripts MyMapScripts { MAP_SCRIPT_ON_LOAD { setflag(FLAG_1) lock } }
+`,
+			expected: `const X = 1
+macro Greet(message) {
+    msgbox(message)
+}
+script MyScript {
+    lock
+    faceplayer
+    call(X)
+    end
+    switch (var(VAR_1)) {
+        case 1:
+            msgbox("x")
+        case 2: # Two
+            Greet("Hi")
+            release
+    }
+    call Other(1, 2)
+    poryswitch(GAME) {
+        RUBY: lock _: release
+    }
+
+    # Last
+}
+mapscripts MyMapScripts {
+    MAP_SCRIPT_ON_LOAD {
+        setflag(FLAG_1)
+        lock
+    }
+}
+`,
+		},
+		{
+			input:    "raw ```.string \"`\"```\nraw ````\n```quoted```\n````\n",
+			expected: "raw ```\n.string \"`\"\n```\nraw ````\n```quoted```\n````\n",
+		},
+	}
+
+	for i, test := range tests {
+		output, err := Format(test.input)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error: %s", i, err.Error())
+		}
+		if output != test.expected {
+			t.Errorf("test %d: Mismatching format -- Expected=%q, Got=%q", i, test.expected, output)
+		}
+		reformatted, err := Format(output)
+		if err != nil || reformatted != output {
+			t.Errorf("test %d: Expected formatting to be idempotent -- Expected=%q, Got=%q", i, output, reformatted)
+		}
+	}
+}
+
+func TestFormatCaseInsensitiveKeywords(t *testing.T) {
+	input := "SCRIPT MyScript {\n\tIF (Var(VAR_1) == 1) { Foo } ELSE { bar }\n}\n"
+	expected := "script MyScript {\n    if (var(VAR_1) == 1) {\n        Foo\n    } else {\n        bar\n    }\n}\n"
+	output, err := FormatWithMode(input, lexer.CaseInsensitiveKeywords)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if output != expected {
+		t.Errorf("Mismatching format -- Expected=%q, Got=%q", expected, output)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{
+			input:         "script MyScript {\n\tlock\n",
+			expectedError: "line 2:2: missing closing curly brace for block statement",
+		},
+		{
+			input:         "script MyScript {\n}\n}",
+			expectedError: "line 3:1: could not parse top-level statement for '}'",
+		},
+		{
+			input:         "script MyScript {\n\tif (flag(FLAG_1) {}\n}",
+			expectedError: "line 2:20: expected next token to be '{', got '}' instead",
+		},
+		{
+			input:         "script MyScript {\n" + strings.Repeat("if (flag(FLAG_1)) {\n", 2000) + strings.Repeat("}\n", 2000) + "}",
+			expectedError: "line 102:1: maximum nesting depth of 100 exceeded",
+		},
+		{
+			input:         "script MyScript {\n" + strings.Repeat("if (flag(FLAG_1)) {\n", 200000) + strings.Repeat("}\n", 200000) + "}",
+			expectedError: "line 102:1: maximum nesting depth of 100 exceeded",
+		},
+		{
+			input:         "macro MyMacro {" + strings.Repeat("{", 200000) + strings.Repeat("}", 200000) + "}",
+			expectedError: "line 1:115: maximum nesting depth of 100 exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Format(test.input)
+		if err == nil || err.Error() != test.expectedError {
+			t.Errorf("Expected error '%s', but got '%v'", test.expectedError, err)
+		}
+		if !errors.Is(err, parser.ErrSyntax) {
+			t.Errorf("Expected syntax error, but got '%v'", err)
+		}
+	}
+}
+
+func TestFormatRange(t *testing.T) {
+	input := `script First {
+  lock
+}
+
+script Second
+{
+  if (flag(FLAG_1)) { release }
+}
+
+const   FOO=1`
+
+	tests := []struct {
+		startLine int
+		endLine   int
+		expected  string
+	}{
+		{
+			startLine: 7,
+			endLine:   7,
+			expected: `script First {
+  lock
+}
+
+script Second {
+    if (flag(FLAG_1)) {
+        release
+    }
+}
+
+const   FOO=1`,
+		},
+		{
+			startLine: 2,
+			endLine:   2,
+			expected: `script First {
+    lock
+}
+
+script Second
+{
+  if (flag(FLAG_1)) { release }
+}
+
+const   FOO=1`,
+		},
+		{
+			startLine: 10,
+			endLine:   20,
+			expected: `script First {
+  lock
+}
+
+script Second
+{
+  if (flag(FLAG_1)) { release }
+}
+
+const FOO = 1`,
+		},
+	}
+
+	for i, test := range tests {
+		output, err := FormatRange(input, test.startLine, test.endLine)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error: %s", i, err.Error())
+		}
+		if output != test.expected {
+			t.Errorf("test %d: Mismatching format -- Expected=%q, Got=%q", i, test.expected, output)
+		}
+	}
+
+	if _, err := FormatRange(input, 3, 2); err == nil || err.Error() != "invalid line range 3-2" {
+		t.Errorf("Expected invalid line range error, but got '%v'", err)
+	}
+}
@@ -0,0 +1,334 @@
+// Package ir is the intermediate representation of compiled scripts, which
+// sits between the AST and the emitted assembler output. A script is a set
+// of chunks. Each chunk runs a list of commands, and then branches to other
+// chunks. Optimization passes rewrite the chunks and their branches, and a
+// backend renders them in the order returned by OrderChunks.
+package ir
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+// Script is a compiled script.
+type Script struct {
+	Name     string
+	IsGlobal bool
+	// Chunks by id. Chunk 0 is the script's entry point.
+	Chunks map[int]*Chunk
+	// The naming scheme of the labels of the chunks.
+	LabelFormat LabelFormat
+}
+
+// ChunkLabel returns the label of one of the script's chunks. The label of
+// chunk 0 is the script's name.
+func (s *Script) ChunkLabel(chunkID int) string {
+	if chunkID == 0 {
+		return s.Name
+	}
+	return s.LabelFormat.Label(s.Name, chunkID)
+}
+
+// LabelFormat is the naming scheme of the labels that are generated for the
+// chunks of a script, like "MyScript_1".
+type LabelFormat struct {
+	// Text before the script's name.
+	Prefix string
+	// Text between the script's name and the chunk's number.
+	Separator string
+	// The minimum number of digits of the chunk's number, which is padded
+	// with zeros.
+	Width int
+}
+
+// DefaultLabelFormat is the "{script}_{n}" naming scheme.
+var DefaultLabelFormat = LabelFormat{Separator: "_"}
+
+// Label returns the label of the given chunk of a script.
+func (f LabelFormat) Label(scriptName string, chunkID int) string {
+	return fmt.Sprintf("%s%s%s%0*d", f.Prefix, scriptName, f.Separator, f.Width, chunkID)
+}
+
+// ParseLabelFormat reads a label format template, like "{script}_{n}". The
+// template is made of an optional prefix, "{script}", a separator, and
+// "{n}", which is the chunk's number. "{n:3}" pads the number to 3 digits.
+func ParseLabelFormat(template string) (LabelFormat, error) {
+	var format LabelFormat
+	scriptIndex := strings.Index(template, "{script}")
+	if scriptIndex == -1 {
+		return format, fmt.Errorf("invalid label format '%s'. It must contain '{script}'", template)
+	}
+	format.Prefix = template[:scriptIndex]
+	rest := template[scriptIndex+len("{script}"):]
+	numberIndex := strings.Index(rest, "{n")
+	if numberIndex == -1 || !strings.HasSuffix(rest, "}") {
+		return format, fmt.Errorf("invalid label format '%s'. It must end with '{n}'", template)
+	}
+	format.Separator = rest[:numberIndex]
+	number := rest[numberIndex+len("{n") : len(rest)-1]
+	if number != "" {
+		width, err := strconv.Atoi(strings.TrimPrefix(number, ":"))
+		if err != nil || !strings.HasPrefix(number, ":") || width < 0 {
+			return format, fmt.Errorf("invalid label format '%s'. The number's width must be like '{n:3}'", template)
+		}
+		format.Width = width
+	}
+	if strings.ContainsAny(format.Prefix+format.Separator, "{}") {
+		return format, fmt.Errorf("invalid label format '%s'. Only '{script}' and '{n}' can be used", template)
+	}
+	return format, nil
+}
+
+// SortedChunkIDs returns the ids of the script's chunks in ascending order.
+func (s *Script) SortedChunkIDs() []int {
+	ids := make([]int, 0, len(s.Chunks))
+	for id := range s.Chunks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// JumpedChunks returns the ids of the chunks, other than chunk 0, that are
+// jumped to when the chunks are placed in the given order. The other chunks
+// are only reached by falling through from the chunk before them, so they
+// don't need labels.
+func JumpedChunks(script *Script, chunkIDs []int) []int {
+	jumped := make(map[int]bool)
+	for i, chunkID := range chunkIDs {
+		nextChunkID := -1
+		if i < len(chunkIDs)-1 {
+			nextChunkID = chunkIDs[i+1]
+		}
+		addJump := func(destChunkID int, canFallThrough bool) {
+			if destChunkID != -1 && !(canFallThrough && destChunkID == nextChunkID) {
+				jumped[destChunkID] = true
+			}
+		}
+		switch branch := script.Chunks[chunkID].Branch.(type) {
+		case *Goto:
+			addJump(branch.Dest, true)
+		case *Condition:
+			addJump(branch.Dest, false)
+			addJump(branch.Else, true)
+		case *Switch:
+			for _, switchCase := range branch.Cases {
+				addJump(switchCase.Dest, false)
+			}
+			addJump(branch.Default, true)
+		}
+	}
+	result := []int{}
+	for _, chunkID := range chunkIDs {
+		if chunkID != 0 && jumped[chunkID] {
+			result = append(result, chunkID)
+		}
+	}
+	return result
+}
+
+// Chunk is a list of commands without any branches, followed by a branch to
+// other chunks. Each chunk has a label in the output, unless nothing needs to
+// branch to it.
+type Chunk struct {
+	ID       int
+	Commands []Command
+	Branch   Branch
+}
+
+// Returns the id of the chunk that the chunk unconditionally continues into
+// after its commands. Returns -1 if the chunk ends the script, or if it has
+// conditional branches.
+func (c *Chunk) getContinuationID() int {
+	if branch, ok := c.Branch.(*Goto); ok {
+		return branch.Dest
+	}
+	return -1
+}
+
+// Returns the id of the chunk that the chunk unconditionally jumps to,
+// without running any commands. Returns -1 if the chunk does anything else.
+func (c *Chunk) getJumpTarget() int {
+	if len(c.Commands) > 0 {
+		return -1
+	}
+	return c.getContinuationID()
+}
+
+// Returns the id of the chunk that the chunk's branch continues at when none
+// of its conditions are true, so that it can be placed after the chunk.
+// Returns -1 if there is no such chunk.
+func (c *Chunk) getTailChunkID() int {
+	switch branch := c.Branch.(type) {
+	case *Goto:
+		return branch.Dest
+	case *Condition:
+		return branch.Else
+	case *Switch:
+		return branch.Default
+	}
+	return -1
+}
+
+// Source returns the location of the chunk's first command in the
+// Poryscript source. Returns false if the location isn't known.
+func (c *Chunk) Source() (Location, bool) {
+	for _, command := range c.Commands {
+		if command.Source.Line > 0 {
+			return command.Source, true
+		}
+	}
+	return Location{}, false
+}
+
+// Command is a single script command, like "msgbox" or "setflag".
+type Command struct {
+	Name string
+	Args []string
+	// The location of the command in the Poryscript source. It is only
+	// known when the emitter renders source comments.
+	Source Location
+}
+
+// Location is a line of a Poryscript file.
+type Location struct {
+	Filepath string
+	Line     int
+}
+
+// String returns the location, like "data/scripts/myscript.pory:12".
+func (l Location) String() string {
+	if l.Filepath == "" {
+		return fmt.Sprintf("line %d", l.Line)
+	}
+	return fmt.Sprintf("%s:%d", l.Filepath, l.Line)
+}
+
+// Branch is the way that a chunk continues after its commands.
+type Branch interface {
+	// Destinations returns pointers to the ids of every chunk that can be
+	// branched to, so that optimization passes can rewrite them. Ids of -1
+	// mean that the script returns instead.
+	Destinations() []*int
+}
+
+// Return ends the script with the "return" command, or the "end" command.
+type Return struct {
+	End bool
+}
+
+// Destinations satisfies the Branch interface.
+func (r *Return) Destinations() []*int {
+	return nil
+}
+
+// TailCall continues at the label of another script, which returns on the
+// script's behalf.
+type TailCall struct {
+	Label string
+}
+
+// Destinations satisfies the Branch interface.
+func (t *TailCall) Destinations() []*int {
+	return nil
+}
+
+// Goto unconditionally continues at another chunk.
+type Goto struct {
+	Dest int
+}
+
+// Destinations satisfies the Branch interface.
+func (g *Goto) Destinations() []*int {
+	return []*int{&g.Dest}
+}
+
+// Condition continues at Dest if the comparison is true. Otherwise, it
+// continues at Else, or returns if Else is -1.
+type Condition struct {
+	Comparison Comparison
+	Dest       int
+	Else       int
+}
+
+// Destinations satisfies the Branch interface.
+func (c *Condition) Destinations() []*int {
+	return []*int{&c.Dest, &c.Else}
+}
+
+// Invert swaps the condition's destinations, and negates its comparison to
+// match. The condition must not return when its comparison is false.
+func (c *Condition) Invert() {
+	c.Comparison = c.Comparison.Negate()
+	c.Dest, c.Else = c.Else, c.Dest
+}
+
+// Comparison is a single check of a flag, var, or trainer.
+type Comparison struct {
+	// token.FLAG, token.VAR, or token.DEFEATED
+	Type     token.Type
+	Operand  string
+	Operator token.Type
+	Value    string
+}
+
+// IsTrue reports whether a flag or trainer comparison checks that the flag
+// is set, or that the trainer was defeated.
+func (c Comparison) IsTrue() bool {
+	return (c.Operator == token.EQ && c.Value == token.TRUE) ||
+		(c.Operator == token.NEQ && c.Value == token.FALSE)
+}
+
+// Negate returns the comparison that is true when this one is false.
+func (c Comparison) Negate() Comparison {
+	switch c.Type {
+	case token.FLAG, token.DEFEATED:
+		if c.IsTrue() {
+			c.Value = token.FALSE
+		} else {
+			c.Value = token.TRUE
+		}
+		c.Operator = token.EQ
+	case token.VAR:
+		c.Operator = negatedOperators[c.Operator]
+	}
+	return c
+}
+
+// Comparison operators, and the operators that compare the opposite way.
+var negatedOperators = map[token.Type]token.Type{
+	token.EQ:  token.NEQ,
+	token.NEQ: token.EQ,
+	token.LT:  token.GTE,
+	token.LTE: token.GT,
+	token.GT:  token.LTE,
+	token.GTE: token.LT,
+}
+
+// Switch compares a var against each case's value in order, and continues
+// at the first case that matches. Otherwise, it continues at Default, or
+// returns if Default is -1.
+type Switch struct {
+	Operand string
+	Cases   []Case
+	Default int
+}
+
+// Case is a single value of a Switch.
+type Case struct {
+	Value string
+	Dest  int
+}
+
+// Destinations satisfies the Branch interface.
+func (s *Switch) Destinations() []*int {
+	ids := make([]*int, 0, len(s.Cases)+1)
+	for i := range s.Cases {
+		ids = append(ids, &s.Cases[i].Dest)
+	}
+	return append(ids, &s.Default)
+}
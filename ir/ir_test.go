@@ -0,0 +1,292 @@
+package ir
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+// Returns a readable description of the script's chunks, in order of their ids.
+func describeScript(script *Script) string {
+	var sb strings.Builder
+	for _, id := range script.SortedChunkIDs() {
+		c := script.Chunks[id]
+		sb.WriteString(fmt.Sprintf("%d:", id))
+		for _, command := range c.Commands {
+			sb.WriteString(" " + command.Name)
+		}
+		switch branch := c.Branch.(type) {
+		case *Return:
+			sb.WriteString(fmt.Sprintf(" -> return(end=%t)", branch.End))
+		case *TailCall:
+			sb.WriteString(fmt.Sprintf(" -> tailcall %s", branch.Label))
+		case *Goto:
+			sb.WriteString(fmt.Sprintf(" -> goto %d", branch.Dest))
+		case *Condition:
+			sb.WriteString(fmt.Sprintf(" -> if %s %s %s: %d else %d", branch.Comparison.Operand, branch.Comparison.Operator, branch.Comparison.Value, branch.Dest, branch.Else))
+		case *Switch:
+			sb.WriteString(fmt.Sprintf(" -> switch %s:", branch.Operand))
+			for _, switchCase := range branch.Cases {
+				sb.WriteString(fmt.Sprintf(" %s=%d", switchCase.Value, switchCase.Dest))
+			}
+			sb.WriteString(fmt.Sprintf(" default %d", branch.Default))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func newTestScript(chunks ...*Chunk) *Script {
+	script := &Script{Name: "MyScript", Chunks: make(map[int]*Chunk)}
+	for _, c := range chunks {
+		script.Chunks[c.ID] = c
+	}
+	return script
+}
+
+func commands(names ...string) []Command {
+	result := []Command{}
+	for _, name := range names {
+		result = append(result, Command{Name: name})
+	}
+	return result
+}
+
+var varComparison = Comparison{Type: token.VAR, Operand: "VAR_1", Operator: token.EQ, Value: "1"}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		script   *Script
+		expected string
+	}{
+		{
+			// Chunks that continue into chunks with no other entry are merged.
+			script: newTestScript(
+				&Chunk{ID: 0, Commands: commands("first"), Branch: &Goto{Dest: 1}},
+				&Chunk{ID: 1, Branch: &Goto{Dest: 2}},
+				&Chunk{ID: 2, Commands: commands("second"), Branch: &Condition{Comparison: varComparison, Dest: 3, Else: 4}},
+				&Chunk{ID: 3, Commands: commands("third"), Branch: &Goto{Dest: 4}},
+				&Chunk{ID: 4, Branch: &Return{End: true}},
+			),
+			expected: `0: first second -> if VAR_1 == 1: 3 else 4
+3: third -> goto 4
+4: -> return(end=true)
+`,
+		},
+		{
+			// Branches to chunks that only jump elsewhere go to the final destination.
+			script: newTestScript(
+				&Chunk{ID: 0, Branch: &Condition{Comparison: varComparison, Dest: 1, Else: 2}},
+				&Chunk{ID: 1, Branch: &Goto{Dest: 3}},
+				&Chunk{ID: 2, Commands: commands("second"), Branch: &Goto{Dest: 4}},
+				&Chunk{ID: 3, Commands: commands("third"), Branch: &Switch{Operand: "VAR_2", Cases: []Case{{Value: "0", Dest: 1}}, Default: 4}},
+				&Chunk{ID: 4, Commands: commands("fourth"), Branch: &Goto{Dest: 3}},
+			),
+			expected: `0: -> if VAR_1 == 1: 3 else 2
+2: second -> goto 4
+3: third -> switch VAR_2: 0=3 default 4
+4: fourth -> goto 3
+`,
+		},
+		{
+			// Loops of jumps are left alone.
+			script: newTestScript(
+				&Chunk{ID: 0, Branch: &Goto{Dest: 1}},
+				&Chunk{ID: 1, Branch: &Goto{Dest: 2}},
+				&Chunk{ID: 2, Branch: &Goto{Dest: 1}},
+			),
+			expected: `0: -> goto 1
+1: -> goto 1
+`,
+		},
+	}
+
+	for i, test := range tests {
+		Optimize(test.script)
+		if result := describeScript(test.script); result != test.expected {
+			t.Errorf("Test %d: Incorrect optimized script. Expected:\n%s\nGot:\n%s", i, test.expected, result)
+		}
+	}
+}
+
+func TestOptimizeTailCalls(t *testing.T) {
+	call := Command{Name: "call", Args: []string{"OtherScript"}}
+	script := newTestScript(
+		&Chunk{ID: 0, Commands: []Command{call}, Branch: &Condition{Comparison: varComparison, Dest: 1, Else: 2}},
+		// A call before a "return" is replaced.
+		&Chunk{ID: 1, Commands: []Command{call}, Branch: &Return{}},
+		// A call before an "end" isn't replaced, since the called script's
+		// "return" wouldn't end the script that called this one.
+		&Chunk{ID: 2, Commands: []Command{call}, Branch: &Return{End: true}},
+		// Neither is a call that isn't the chunk's last command.
+		&Chunk{ID: 3, Commands: []Command{call, {Name: "release"}}, Branch: &Return{}},
+	)
+	expected := `0: call -> if VAR_1 == 1: 1 else 2
+1: -> tailcall OtherScript
+2: call -> return(end=true)
+3: call release -> return(end=false)
+`
+	OptimizeTailCalls(script, "call")
+	if result := describeScript(script); result != expected {
+		t.Errorf("Incorrect tail calls. Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestOrderChunks(t *testing.T) {
+	tests := []struct {
+		script        *Script
+		expectedOrder []int
+		expected      string
+	}{
+		{
+			// The body of an if statement is placed after its inverted condition.
+			script: newTestScript(
+				&Chunk{ID: 0, Branch: &Condition{Comparison: varComparison, Dest: 1, Else: 2}},
+				&Chunk{ID: 1, Commands: commands("body"), Branch: &Goto{Dest: 2}},
+				&Chunk{ID: 2, Commands: commands("after"), Branch: &Return{}},
+			),
+			expectedOrder: []int{0, 1, 2},
+			expected: `0: -> if VAR_1 != 1: 2 else 1
+1: body -> goto 2
+2: after -> return(end=false)
+`,
+		},
+		{
+			// Conditions that return when they are false aren't inverted.
+			script: newTestScript(
+				&Chunk{ID: 0, Commands: commands("first"), Branch: &Condition{Comparison: varComparison, Dest: 1, Else: -1}},
+				&Chunk{ID: 1, Commands: commands("body"), Branch: &Return{}},
+			),
+			expectedOrder: []int{0, 1},
+			expected: `0: first -> if VAR_1 == 1: 1 else -1
+1: body -> return(end=false)
+`,
+		},
+		{
+			// Chunks are placed after the chunks that continue into them.
+			script: newTestScript(
+				&Chunk{ID: 0, Branch: &Goto{Dest: 3}},
+				&Chunk{ID: 1, Branch: &Return{}},
+				&Chunk{ID: 2, Branch: &Goto{Dest: 1}},
+				&Chunk{ID: 3, Branch: &Switch{Operand: "VAR_2", Cases: []Case{{Value: "0", Dest: 1}}, Default: 2}},
+			),
+			expectedOrder: []int{0, 3, 2, 1},
+			expected: `0: -> goto 3
+1: -> return(end=false)
+2: -> goto 1
+3: -> switch VAR_2: 0=1 default 2
+`,
+		},
+	}
+
+	for i, test := range tests {
+		order := OrderChunks(test.script)
+		if !reflect.DeepEqual(order, test.expectedOrder) {
+			t.Errorf("Test %d: Incorrect chunk order. Expected %v, got %v", i, test.expectedOrder, order)
+		}
+		if result := describeScript(test.script); result != test.expected {
+			t.Errorf("Test %d: Incorrect ordered script. Expected:\n%s\nGot:\n%s", i, test.expected, result)
+		}
+	}
+}
+
+func TestNegateComparison(t *testing.T) {
+	tests := []struct {
+		comparison Comparison
+		expected   Comparison
+	}{
+		{Comparison{Type: token.VAR, Operand: "VAR_1", Operator: token.LT, Value: "2"}, Comparison{Type: token.VAR, Operand: "VAR_1", Operator: token.GTE, Value: "2"}},
+		{Comparison{Type: token.VAR, Operand: "VAR_1", Operator: token.NEQ, Value: "2"}, Comparison{Type: token.VAR, Operand: "VAR_1", Operator: token.EQ, Value: "2"}},
+		{Comparison{Type: token.FLAG, Operand: "FLAG_1", Operator: token.EQ, Value: token.TRUE}, Comparison{Type: token.FLAG, Operand: "FLAG_1", Operator: token.EQ, Value: token.FALSE}},
+		{Comparison{Type: token.FLAG, Operand: "FLAG_1", Operator: token.NEQ, Value: token.TRUE}, Comparison{Type: token.FLAG, Operand: "FLAG_1", Operator: token.EQ, Value: token.TRUE}},
+		{Comparison{Type: token.DEFEATED, Operand: "TRAINER_1", Operator: token.NEQ, Value: token.FALSE}, Comparison{Type: token.DEFEATED, Operand: "TRAINER_1", Operator: token.EQ, Value: token.FALSE}},
+	}
+
+	for _, test := range tests {
+		if result := test.comparison.Negate(); result != test.expected {
+			t.Errorf("Incorrect negation of %+v. Expected %+v, got %+v", test.comparison, test.expected, result)
+		}
+		if result := test.comparison.Negate().IsTrue(); test.comparison.Type != token.VAR && result == test.comparison.IsTrue() {
+			t.Errorf("Expected negation of %+v to check the opposite state", test.comparison)
+		}
+	}
+}
+
+func TestGroupChunks(t *testing.T) {
+	script := newTestScript(
+		&Chunk{ID: 0, Branch: &Condition{Comparison: varComparison, Dest: 1, Else: 2}},
+		&Chunk{ID: 1, Commands: commands("body"), Branch: &Goto{Dest: 3}},
+		&Chunk{ID: 2, Commands: commands("other"), Branch: &Goto{Dest: 3}},
+		&Chunk{ID: 3, Commands: commands("after"), Branch: &Goto{Dest: 4}},
+		&Chunk{ID: 4, Branch: &Return{}},
+	)
+	expected := [][]int{{0, 2}, {1}, {3, 4}}
+	if groups := GroupChunks(script, []int{0, 2, 1, 3, 4}); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Incorrect chunk groups. Expected %v, got %v", expected, groups)
+	}
+}
+
+func TestParseLabelFormat(t *testing.T) {
+	tests := []struct {
+		template      string
+		expected      string
+		expectedError string
+	}{
+		{"{script}_{n}", "MyScript_7", ""},
+		{"{script}{n}", "MyScript7", ""},
+		{".L{script}__{n:3}", ".LMyScript__007", ""},
+		{"{script}_Branch_{n:2}", "MyScript_Branch_07", ""},
+		{"{n}_{script}", "", "invalid label format '{n}_{script}'. It must end with '{n}'"},
+		{"Label_{n}", "", "invalid label format 'Label_{n}'. It must contain '{script}'"},
+		{"{script}_{n:x}", "", "invalid label format '{script}_{n:x}'. The number's width must be like '{n:3}'"},
+		{"{script}_{id}_{n}", "", "invalid label format '{script}_{id}_{n}'. Only '{script}' and '{n}' can be used"},
+	}
+	for _, test := range tests {
+		format, err := ParseLabelFormat(test.template)
+		if test.expectedError != "" {
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s' for '%s', but got '%v'", test.expectedError, test.template, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", test.template, err.Error())
+			continue
+		}
+		if label := format.Label("MyScript", 7); label != test.expected {
+			t.Errorf("Incorrect label for '%s'. Expected '%s', got '%s'", test.template, test.expected, label)
+		}
+	}
+
+	script := &Script{Name: "MyScript", LabelFormat: DefaultLabelFormat}
+	if label := script.ChunkLabel(0); label != "MyScript" {
+		t.Errorf("Expected the label of chunk 0 to be the script's name, but got '%s'", label)
+	}
+}
+
+func TestJumpedChunks(t *testing.T) {
+	script := newTestScript(
+		&Chunk{ID: 0, Branch: &Condition{Comparison: varComparison, Dest: 1, Else: 2}},
+		&Chunk{ID: 1, Commands: commands("body"), Branch: &Goto{Dest: 3}},
+		&Chunk{ID: 2, Commands: commands("other"), Branch: &Goto{Dest: 3}},
+		&Chunk{ID: 3, Commands: commands("after"), Branch: &Goto{Dest: 4}},
+		&Chunk{ID: 4, Branch: &Return{}},
+	)
+	tests := []struct {
+		chunkIDs []int
+		expected []int
+	}{
+		{[]int{0, 1, 2, 3, 4}, []int{1, 2, 3}},
+		{[]int{0, 2, 1, 3, 4}, []int{1, 3}},
+		{[]int{0, 2, 3, 4, 1}, []int{3, 1}},
+		{[]int{0, 1, 3, 4, 2}, []int{1, 3, 2}},
+	}
+	for i, test := range tests {
+		if jumped := JumpedChunks(script, test.chunkIDs); !reflect.DeepEqual(jumped, test.expected) {
+			t.Errorf("Test %d: Incorrect jumped chunks. Expected %v, got %v", i, test.expected, jumped)
+		}
+	}
+}
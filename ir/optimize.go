@@ -0,0 +1,219 @@
+package ir
+
+// Optimize rewrites the script's chunks, so that they need fewer labels and
+// "goto" commands.
+func Optimize(script *Script) {
+	mergeChunks(script)
+	threadJumps(script)
+	mergeChunks(script)
+}
+
+// OptimizeTailCalls replaces the calls at the end of the script's chunks with
+// jumps, when the chunk returns right after the call. The called script
+// returns instead, so the call doesn't use a level of the call stack. Calls
+// before an "end" aren't replaced, since the called script's "return" would
+// continue the script that called this one, instead of ending it.
+// callCommand is the command that calls another script, like "call".
+func OptimizeTailCalls(script *Script, callCommand string) {
+	for _, chunk := range script.Chunks {
+		if branch, ok := chunk.Branch.(*Return); !ok || branch.End || len(chunk.Commands) == 0 {
+			continue
+		}
+		last := chunk.Commands[len(chunk.Commands)-1]
+		if last.Name != callCommand || len(last.Args) != 1 {
+			continue
+		}
+		chunk.Commands = chunk.Commands[:len(chunk.Commands)-1]
+		chunk.Branch = &TailCall{Label: last.Args[0]}
+	}
+}
+
+// OrderChunks returns the order of the script's chunks in the output. Chunks
+// are placed after the chunks that continue into them, so that they don't
+// need a "goto". Conditions are inverted when that lets them fall through.
+func OrderChunks(script *Script) []int {
+	chunks := script.Chunks
+	unvisited := make(map[int]bool)
+	for k := range chunks {
+		unvisited[k] = true
+	}
+
+	chunkIDs := make([]int, 0)
+	if len(chunks) == 0 {
+		return chunkIDs
+	}
+
+	sortedIDs := script.SortedChunkIDs()
+	chunkIDs = append(chunkIDs, 0)
+	delete(unvisited, 0)
+	for len(chunkIDs) < len(chunks) {
+		curChunk := chunks[chunkIDs[len(chunkIDs)-1]]
+		if branch, ok := curChunk.Branch.(*Condition); ok && shouldInvertBranch(branch, chunks, unvisited) {
+			branch.Invert()
+		}
+
+		nextChunkID := curChunk.getTailChunkID()
+		if nextChunkID != -1 {
+			if _, ok := unvisited[nextChunkID]; ok {
+				chunkIDs = append(chunkIDs, nextChunkID)
+				delete(unvisited, nextChunkID)
+				continue
+			}
+		}
+
+		// Choose the next unvisited chunk, preferring chunks that can't be
+		// placed after an unvisited chunk later on.
+		nextChunkID = getNextChainStart(chunks, sortedIDs, unvisited)
+		chunkIDs = append(chunkIDs, nextChunkID)
+		delete(unvisited, nextChunkID)
+	}
+	return chunkIDs
+}
+
+// GroupChunks splits the chunks into groups, for backends that can't place
+// chunks in a fixed order, so that chunks never fall through into each other.
+// The groups follow the given order of the chunks. A chunk that nothing else
+// branches to is placed in the group of the chunk that continues into it,
+// right after it, since it doesn't need a label of its own.
+func GroupChunks(script *Script, chunkIDs []int) [][]int {
+	refCounts := getChunkRefCounts(script.Chunks)
+	placed := make(map[int]bool)
+	groups := [][]int{}
+	for _, chunkID := range chunkIDs {
+		if placed[chunkID] {
+			continue
+		}
+		group := []int{}
+		for id := chunkID; id != -1 && !placed[id]; {
+			placed[id] = true
+			group = append(group, id)
+			nextID := script.Chunks[id].getTailChunkID()
+			if nextID == -1 || refCounts[nextID] != 1 {
+				break
+			}
+			id = nextID
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Returns the unvisited chunk with the lowest id that no other unvisited
+// chunk branches to. Falls back to the unvisited chunk with the lowest id,
+// which happens when the unvisited chunks form a loop.
+func getNextChainStart(chunks map[int]*Chunk, sortedIDs []int, unvisited map[int]bool) int {
+	hasPredecessor := make(map[int]bool)
+	for id := range unvisited {
+		for _, destID := range chunks[id].Branch.Destinations() {
+			if *destID != id {
+				hasPredecessor[*destID] = true
+			}
+		}
+	}
+	firstID := -1
+	for _, id := range sortedIDs {
+		if !unvisited[id] {
+			continue
+		}
+		if !hasPredecessor[id] {
+			return id
+		}
+		if firstID == -1 {
+			firstID = id
+		}
+	}
+	return firstID
+}
+
+// Reports whether a condition should be inverted, so that the chunk it
+// branches to can be placed right after it. The inverted condition saves a
+// "goto" when the other destination is already placed, or when the chunk
+// continues into the other destination anyway. If nothing else branches to
+// the chunk, it doesn't need a label either:
+//
+//	goto_if_unset FLAG_1, MyScript_1
+//	dostuff
+//	MyScript_1:
+func shouldInvertBranch(branch *Condition, chunks map[int]*Chunk, unvisited map[int]bool) bool {
+	if branch.Else == -1 || !unvisited[branch.Dest] {
+		return false
+	}
+	return !unvisited[branch.Else] || chunks[branch.Dest].getContinuationID() == branch.Else
+}
+
+// Rewrites branches to chunks that only jump to another chunk, so that they
+// branch directly to the final destination. This saves a "goto" at runtime.
+// The jump chunks that are no longer branched to are removed.
+func threadJumps(script *Script) {
+	chunks := script.Chunks
+	getFinalDestination := func(id int) int {
+		visited := make(map[int]bool)
+		for !visited[id] {
+			visited[id] = true
+			c, ok := chunks[id]
+			if !ok || c.getJumpTarget() == -1 {
+				break
+			}
+			id = c.getJumpTarget()
+		}
+		return id
+	}
+	for _, c := range chunks {
+		for _, destID := range c.Branch.Destinations() {
+			if *destID != -1 {
+				*destID = getFinalDestination(*destID)
+			}
+		}
+	}
+
+	refCounts := getChunkRefCounts(chunks)
+	for id, c := range chunks {
+		if id != 0 && refCounts[id] == 0 && c.getJumpTarget() != -1 {
+			delete(chunks, id)
+		}
+	}
+}
+
+// Merges chunks that continue into a chunk which nothing else branches to,
+// so that the pair is a single chunk.
+func mergeChunks(script *Script) {
+	chunks := script.Chunks
+	for merged := true; merged; {
+		merged = false
+		refCounts := getChunkRefCounts(chunks)
+		for _, id := range script.SortedChunkIDs() {
+			c, ok := chunks[id]
+			if !ok {
+				continue
+			}
+			nextID := c.getContinuationID()
+			if nextID <= 0 || nextID == id || refCounts[nextID] != 1 {
+				continue
+			}
+			next, ok := chunks[nextID]
+			if !ok {
+				continue
+			}
+			// The commands are copied, because they may share an
+			// array with the commands of other chunks.
+			commands := make([]Command, 0, len(c.Commands)+len(next.Commands))
+			commands = append(commands, c.Commands...)
+			c.Commands = append(commands, next.Commands...)
+			c.Branch = next.Branch
+			delete(chunks, next.ID)
+			merged = true
+			break
+		}
+	}
+}
+
+// Returns the number of branches to each chunk.
+func getChunkRefCounts(chunks map[int]*Chunk) map[int]int {
+	refCounts := make(map[int]int)
+	for _, c := range chunks {
+		for _, destID := range c.Branch.Destinations() {
+			refCounts[*destID]++
+		}
+	}
+	return refCounts
+}
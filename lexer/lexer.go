@@ -1,52 +1,249 @@
 package lexer
 
 import (
+	"fmt"
+	"io"
 	"strings"
 	"unicode"
 
 	"github.com/huderlem/poryscript/token"
 )
 
+// Mode controls which optional tokens are produced by the lexer.
+type Mode int
+
+// Lexer modes, which can be combined.
+const (
+	// ScanComments produces COMMENT tokens, instead of skipping comments.
+	ScanComments Mode = 1 << iota
+	// ScanTrivia produces COMMENT and WHITESPACE tokens, and keeps string
+	// tokens exactly as they were written, so that the original input can
+	// be reproduced with Source(). Line endings are not normalized, and a
+	// byte order mark is produced as a WHITESPACE token.
+	ScanTrivia
+	// CaseInsensitiveKeywords accepts keywords in any case, like "IF" or
+	// "If". Their literals are normalized to the canonical keyword, except
+	// in the ScanTrivia mode.
+	CaseInsensitiveKeywords
+)
+
+// UTF-8 byte order mark, which is skipped at the start of the input.
+const byteOrderMark = "\uFEFF"
+
+// Error is a problem with the input, which the lexer recovered from so that
+// it could keep producing tokens.
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
 // Lexer produces tokens from a Poryscript file
 type Lexer struct {
-	input        string
+	mode         Mode
+	input        string        // input, if it wasn't read from a reader
+	fromReader   bool          // whether the input is read from a reader
+	reader       io.Reader     // reader that the rest of the input is read from, until it ends
+	buffer       []byte        // input that was read from the reader, and hasn't been dropped yet
+	readErr      error         // error that stopped the reader, other than io.EOF
+	base         int           // position of the first char of buffer
+	discarded    int           // position before which the buffered input can be dropped
+	filepath     string        // file that the input was read from
 	position     int           // current position in input (points to current char)
 	readPosition int           // current reading position in input (after current char)
 	ch           byte          // current char under examination
 	lineNumber   int           // current line number
+	lineStart    int           // position of the first char of the current line
+	bomPending   bool          // whether the skipped byte order mark still needs to be produced as a token
+	errors       []Error       // problems with the input that were recovered from
 	queuedTokens []token.Token // extra tokens that were read ahead of time
 }
 
 // New initializes a new lexer for the given Poryscript file
 func New(input string) *Lexer {
-	l := &Lexer{input: input, lineNumber: 1}
+	return NewWithMode(input, 0)
+}
+
+// NewWithMode initializes a new lexer for the given Poryscript file, which
+// produces the optional tokens enabled by mode.
+func NewWithMode(input string, mode Mode) *Lexer {
+	l := &Lexer{input: input, lineNumber: 1, mode: mode}
+	l.skipByteOrderMark()
 	l.readChar()
 	return l
 }
 
+// Size of the chunks that are read from a lexer's reader.
+const readChunkSize = 4096
+
+// NewReader initializes a new lexer that reads the Poryscript file from r
+// as its tokens are needed, instead of requiring the whole file up front.
+func NewReader(r io.Reader) *Lexer {
+	return NewReaderWithMode(r, 0)
+}
+
+// NewReaderWithMode initializes a new lexer that reads the Poryscript file
+// from r, which produces the optional tokens enabled by mode.
+func NewReaderWithMode(r io.Reader, mode Mode) *Lexer {
+	l := &Lexer{fromReader: true, reader: r, lineNumber: 1, mode: mode}
+	l.skipByteOrderMark()
+	l.readChar()
+	return l
+}
+
+// Err returns the error that stopped the lexer's reader, if any. The lexer
+// treats the error as the end of the input.
+func (l *Lexer) Err() error {
+	return l.readErr
+}
+
+// Mode returns the modes that the lexer was created with.
+func (l *Lexer) Mode() Mode {
+	return l.mode
+}
+
+// Errors returns the problems with the input that were found in the tokens
+// that were read so far.
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+func (l *Lexer) addError(pos token.Position, message string) {
+	l.errors = append(l.errors, Error{Pos: pos, Message: message})
+}
+
+// Reads the next chunk of the input from the reader into the buffer. Returns
+// false if the reader has no more input. When the buffer is full, the
+// discarded input is dropped from its start, and it's only grown when the
+// rest of the input fills more than half of it, so that reading the input
+// takes linear time.
+func (l *Lexer) fill() bool {
+	if l.reader == nil {
+		return false
+	}
+	if len(l.buffer) == cap(l.buffer) {
+		kept := l.buffer[l.discarded-l.base:]
+		buffer := l.buffer
+		if cap(buffer) == 0 || len(kept) > cap(buffer)/2 {
+			buffer = make([]byte, 0, 2*cap(buffer)+readChunkSize)
+		}
+		l.buffer = append(buffer[:0], kept...)
+		l.base = l.discarded
+	}
+	n, err := l.reader.Read(l.buffer[len(l.buffer):cap(l.buffer)])
+	l.buffer = l.buffer[:len(l.buffer)+n]
+	if err != nil {
+		if err != io.EOF {
+			l.readErr = err
+		}
+		l.reader = nil
+	}
+	return n > 0 || l.reader != nil
+}
+
+// Returns the char at the given position in the input, or 0 if the position
+// is past the end of the input.
+func (l *Lexer) charAt(position int) byte {
+	if !l.fromReader {
+		if position >= len(l.input) {
+			return 0
+		}
+		return l.input[position]
+	}
+	for position-l.base >= len(l.buffer) {
+		if !l.fill() {
+			return 0
+		}
+	}
+	return l.buffer[position-l.base]
+}
+
+// Returns the input between the given positions. Input that was read from a
+// reader is copied, since its buffer is reused.
+func (l *Lexer) slice(start int, end int) string {
+	if !l.fromReader {
+		return l.input[start:end]
+	}
+	return string(l.buffer[start-l.base : end-l.base])
+}
+
+// Discards the input before the current char, which was already read. Only
+// input that was read from a reader is discarded, so that the memory used by
+// a large file is released as it's read. It's dropped from the buffer when
+// the next chunk is read.
+func (l *Lexer) discard() {
+	if l.reader == nil {
+		return
+	}
+	l.discarded = l.position
+}
+
+// Skips the byte order mark at the start of the input, if there is one. The
+// first line starts after it, so that its columns start at 1.
+func (l *Lexer) skipByteOrderMark() {
+	for i := 0; i < len(byteOrderMark); i++ {
+		if l.charAt(i) != byteOrderMark[i] {
+			return
+		}
+	}
+	l.readPosition = len(byteOrderMark)
+	l.lineStart = len(byteOrderMark)
+	l.bomPending = l.mode&ScanTrivia != 0
+}
+
+// Moves back to the given position, which must not have been discarded.
+func (l *Lexer) rewind(pos token.Position) {
+	l.readPosition = pos.Offset
+	l.lineNumber = pos.Line
+	l.lineStart = pos.Offset - pos.Column + 1
+	l.ch = 0
+	l.readChar()
+}
+
 func (l *Lexer) readChar() {
 	prevCh := l.ch
-	if l.readPosition >= len(l.input) {
-		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+	l.ch = l.charAt(l.readPosition)
+	// "\r\n" line endings are read as "\n", so that Windows line endings
+	// don't end up in literals.
+	if l.ch == '\r' && l.mode&ScanTrivia == 0 && l.charAt(l.readPosition+1) == '\n' {
+		l.readPosition++
+		l.ch = '\n'
 	}
 	l.position = l.readPosition
 	l.readPosition++
 	if prevCh == '\n' {
 		l.lineNumber++
+		l.lineStart = l.position
 	}
 }
 
+// Returns the column of the current char, starting at 1.
+func (l *Lexer) column() int {
+	return l.position - l.lineStart + 1
+}
+
+// Returns the position of the current char.
+func (l *Lexer) pos() token.Position {
+	return token.Position{Offset: l.position, Line: l.lineNumber, Column: l.column()}
+}
+
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
-		return 0
-	}
-	return l.input[l.readPosition]
+	return l.charAt(l.readPosition)
+}
+
+// SetFilepath sets the filepath of the Poryscript file, which is recorded
+// in the tokens that are read after it's set.
+func (l *Lexer) SetFilepath(filepath string) {
+	l.filepath = filepath
 }
 
 // NextToken builds the next token of the Poryscript file
 func (l *Lexer) NextToken() token.Token {
+	tok := l.readNextToken()
+	tok.Filepath = l.filepath
+	return tok
+}
+
+func (l *Lexer) readNextToken() token.Token {
 	var tok token.Token
 
 	// Return the next queued token, if there is one.
@@ -57,113 +254,187 @@ func (l *Lexer) NextToken() token.Token {
 		l.queuedTokens = l.queuedTokens[1:]
 		return tok
 	}
+	l.discard()
+
+	if l.mode&ScanTrivia != 0 {
+		if l.bomPending {
+			l.bomPending = false
+			return token.Token{
+				Type:       token.WHITESPACE,
+				Literal:    byteOrderMark,
+				LineNumber: 1,
+				Column:     1,
+				End:        l.pos(),
+			}
+		}
+		if isWhitespace(l.ch) {
+			tok.LineNumber = l.lineNumber
+			tok.Column = l.column()
+			tok.Offset = l.position
+			tok.Literal = l.readWhitespace()
+			tok.Type = token.WHITESPACE
+			tok.End = l.pos()
+			return tok
+		}
+		if l.ch == '#' || (l.ch == '/' && l.peekChar() == '/') {
+			tok.LineNumber = l.lineNumber
+			tok.Column = l.column()
+			tok.Offset = l.position
+			start := l.position
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+			tok.Literal = l.slice(start, l.position)
+			tok.Type = token.COMMENT
+			tok.End = l.pos()
+			return tok
+		}
+	}
 
 	l.skipWhitespace()
+	start := l.pos()
 
 	// Check for single-line comment.
 	// Both '#' and '//' are valid comment styles.
 	for l.ch == '#' || (l.ch == '/' && l.peekChar() == '/') {
+		if l.mode&ScanComments != 0 {
+			tok.LineNumber = l.lineNumber
+			tok.Column = l.column()
+			tok.Offset = l.position
+			tok.Literal = l.readComment()
+			tok.Type = token.COMMENT
+			tok.End = token.Position{Offset: tok.Offset + len(tok.Literal), Line: tok.LineNumber, Column: tok.Column + len(tok.Literal)}
+			return tok
+		}
 		l.skipToNextLine()
 		l.skipWhitespace()
+		start = l.pos()
+	}
+	tok = l.readToken()
+	tok.Column = start.Column
+	tok.Offset = start.Offset
+	// Tokens that are followed by characters that were read ahead, like
+	// strings, set their own end position.
+	if tok.End == (token.Position{}) {
+		tok.End = l.pos()
 	}
+	return tok
+}
+
+// Reads the token that begins at the current char.
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '*':
-		tok = newToken(token.MUL, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.MUL)
 	case '=':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch), LineNumber: l.lineNumber}
+			tok = l.newTwoCharToken(token.EQ)
 		} else {
-			tok = newToken(token.ASSIGN, l.ch, l.lineNumber)
+			tok = l.newCharToken(token.ASSIGN)
 		}
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.NEQ, Literal: string(ch) + string(l.ch), LineNumber: l.lineNumber}
+			tok = l.newTwoCharToken(token.NEQ)
 		} else {
-			tok = newToken(token.NOT, l.ch, l.lineNumber)
+			tok = l.newCharToken(token.NOT)
 		}
 	case '<':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.LTE, Literal: string(ch) + string(l.ch), LineNumber: l.lineNumber}
+			tok = l.newTwoCharToken(token.LTE)
 		} else {
-			tok = newToken(token.LT, l.ch, l.lineNumber)
+			tok = l.newCharToken(token.LT)
 		}
 	case '>':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.GTE, Literal: string(ch) + string(l.ch), LineNumber: l.lineNumber}
+			tok = l.newTwoCharToken(token.GTE)
 		} else {
-			tok = newToken(token.GT, l.ch, l.lineNumber)
+			tok = l.newCharToken(token.GT)
 		}
 	case '&':
 		if l.peekChar() == '&' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.AND, Literal: string(ch) + string(l.ch), LineNumber: l.lineNumber}
+			tok = l.newTwoCharToken(token.AND)
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch, l.lineNumber)
+			tok = l.newCharToken(token.ILLEGAL)
 		}
 	case '|':
 		if l.peekChar() == '|' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.OR, Literal: string(ch) + string(l.ch), LineNumber: l.lineNumber}
+			tok = l.newTwoCharToken(token.OR)
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch, l.lineNumber)
+			tok = l.newCharToken(token.ILLEGAL)
 		}
 	case '(':
-		tok = newToken(token.LPAREN, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.LPAREN)
 	case ')':
-		tok = newToken(token.RPAREN, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.RPAREN)
 	case '[':
-		tok = newToken(token.LBRACKET, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.LBRACKET)
 	case ']':
-		tok = newToken(token.RBRACKET, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.RBRACKET)
 	case ',':
-		tok = newToken(token.COMMA, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.COMMA)
 	case ':':
-		tok = newToken(token.COLON, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.COLON)
+	case '@':
+		tok = l.newCharToken(token.AT)
+	case '.':
+		// The type of a row of data, like ".2byte".
+		if isLetter(l.peekChar()) || isDigit(l.peekChar()) {
+			tok.LineNumber = l.lineNumber
+			start := l.position
+			l.readChar()
+			for isLetter(l.ch) || isDigit(l.ch) {
+				l.readChar()
+			}
+			tok.Literal = l.slice(start, l.position)
+			tok.Type = token.DATATYPE
+			return tok
+		}
+		tok = l.newCharToken(token.ILLEGAL)
 	case '"':
 		return l.readStringToken()
 	case '`':
 		tok.LineNumber = l.lineNumber
-		tok.Literal = l.readRaw()
 		tok.Type = token.RAWSTRING
+		fenceLength := l.getFenceLength()
+		if l.mode&ScanTrivia != 0 {
+			var ok bool
+			if tok.Literal, ok = l.readDelimited('`', fenceLength); !ok {
+				tok.Type = token.ILLEGAL
+			} else {
+				// The extra backticks of the fence are kept, so that
+				// Source() reproduces the fence.
+				extra := strings.Repeat("`", fenceLength-1)
+				tok.Literal = extra + tok.Literal + extra
+			}
+		} else {
+			tok.Literal = l.readRaw(fenceLength)
+		}
 		return tok
 	case '{':
-		tok = newToken(token.LBRACE, l.ch, l.lineNumber)
+		tok = l.newCharToken(token.LBRACE)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch, l.lineNumber)
-	case '0':
-		if l.peekChar() == 'x' {
-			l.readChar()
-			l.readChar()
-			tok.Type = token.INT
-			tok.LineNumber = l.lineNumber
-			tok.Literal = "0x" + l.readHexNumber()
-			return tok
-		}
-
-		tok.Type = token.INT
-		tok.LineNumber = l.lineNumber
-		tok.Literal = l.readNumber()
-		return tok
+		tok = l.newCharToken(token.RBRACE)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
 		tok.LineNumber = l.lineNumber
+		tok.End = l.pos()
 	default:
 		if isLetter(l.ch) {
 			tok.LineNumber = l.lineNumber
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.GetIdentType(tok.Literal)
+			if tok.Type == token.IDENT && l.mode&CaseInsensitiveKeywords != 0 {
+				if keyword, ok := token.GetKeyword(tok.Literal); ok {
+					tok.Type = token.GetIdentType(keyword)
+					if l.mode&ScanTrivia == 0 {
+						tok.Literal = keyword
+					}
+				}
+			}
+			tok.End = l.pos()
 			// If the immediately-next character is the start of a
 			// STRING token, then this is a STRINGTYPE token, instead
 			// of an IDENT.
@@ -174,17 +445,12 @@ func (l *Lexer) NextToken() token.Token {
 			}
 			return tok
 		} else if isDigit(l.ch) || (l.ch == '-' && isDigit(l.peekChar())) {
-			tok.Type = token.INT
-			tok.LineNumber = l.lineNumber
-			if l.ch == '-' {
-				l.readChar()
-				tok.Literal = "-" + l.readNumber()
-			} else {
-				tok.Literal = l.readNumber()
-			}
-			return tok
+			return l.readNumberToken()
 		}
-		tok = newToken(token.ILLEGAL, l.ch, l.lineNumber)
+		// A byte that isn't a char of Poryscript, which can be the first
+		// byte of a multi-byte UTF-8 char, is read as the char with its
+		// code.
+		tok = token.Token{Type: token.ILLEGAL, Literal: string(rune(l.ch)), LineNumber: l.lineNumber}
 	}
 
 	l.readChar()
@@ -194,17 +460,143 @@ func (l *Lexer) NextToken() token.Token {
 func (l *Lexer) readStringToken() token.Token {
 	var t token.Token
 	t.LineNumber = l.lineNumber
-	t.Literal = l.readString()
+	t.Column = l.column()
+	t.Offset = l.position
+	if l.mode&ScanTrivia != 0 {
+		var ok bool
+		t.Type = token.STRING
+		if t.Literal, ok = l.readDelimited('"', 1); !ok {
+			t.Type = token.ILLEGAL
+		}
+		t.End = l.pos()
+		return t
+	}
+	t.Literal, t.End = l.readString()
 	t.Type = token.STRING
 	return t
 }
 
+func isWhitespace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for isWhitespace(l.ch) {
+		l.readChar()
+	}
+}
+
+func (l *Lexer) readWhitespace() string {
+	start := l.position
+	l.skipWhitespace()
+	return l.slice(start, l.position)
+}
+
+// Reads the characters between the given delimiters, without any processing.
+// The delimiters are repeated length times. Returns false if the closing
+// delimiter is missing, in which case the opening delimiter and the rest of
+// its line are returned instead.
+func (l *Lexer) readDelimited(delimiter byte, length int) (string, bool) {
+	open := l.pos()
+	l.skipChars(length)
+	start := l.pos()
+	for !l.atDelimiter(delimiter, length) && l.ch != 0 && (delimiter == '`' || l.ch != '\n') {
+		l.readChar()
+	}
+	if l.ch != delimiter {
+		l.recoverUnterminated(open, start, delimiter)
+		return l.slice(open.Offset, l.position), false
+	}
+	value := l.slice(start.Offset, l.position)
+	l.skipChars(length)
+	return value, true
+}
+
+// Returns the number of backticks that open the raw string at the current
+// char. Raw strings that are opened by three or more backticks are closed by
+// the same number of backticks, so that they can contain shorter runs of
+// backticks. Two backticks are an empty raw string.
+func (l *Lexer) getFenceLength() int {
+	length := 1
+	for l.charAt(l.position+length) == '`' {
+		length++
+	}
+	if length < 3 {
+		return 1
+	}
+	return length
+}
+
+// Reports whether the delimiter is repeated length times, starting at the
+// current char.
+func (l *Lexer) atDelimiter(delimiter byte, length int) bool {
+	for i := 0; i < length; i++ {
+		if l.charAt(l.position+i) != delimiter {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Lexer) skipChars(n int) {
+	for i := 0; i < n; i++ {
+		l.readChar()
+	}
+}
+
+// Reports an unterminated string or raw string that begins at open. The
+// lexer continues at the end of the line that the string began on, so that
+// the rest of the file is still read as tokens.
+func (l *Lexer) recoverUnterminated(open token.Position, start token.Position, delimiter byte) {
+	if delimiter == '`' {
+		l.addError(open, "unterminated raw string")
+	} else {
+		l.addError(open, "unterminated string")
+	}
+	if l.ch == 0 {
+		l.rewind(start)
+	}
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
 }
 
+// RawFence returns the backticks that delimit a raw string with the given
+// value. Values that contain backticks need a fence that is longer than any
+// run of backticks in the value.
+func RawFence(value string) string {
+	longest, run := 0, 0
+	for i := 0; i < len(value); i++ {
+		if value[i] != '`' {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	if longest == 0 {
+		return "`"
+	}
+	if longest < 2 {
+		longest = 2
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// Source returns the original text of a token that was produced in the
+// ScanTrivia mode.
+func Source(tok token.Token) string {
+	switch tok.Type {
+	case token.STRING:
+		return "\"" + tok.Literal + "\""
+	case token.RAWSTRING:
+		return "`" + tok.Literal + "`"
+	}
+	return tok.Literal
+}
+
 func (l *Lexer) skipToNextLine() {
 	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
@@ -212,14 +604,31 @@ func (l *Lexer) skipToNextLine() {
 	l.readChar()
 }
 
+func (l *Lexer) readComment() string {
+	start := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	return strings.TrimRightFunc(l.slice(start, l.position), unicode.IsSpace)
+}
+
 func (l *Lexer) skipNewlineWhitespace() {
 	for l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
 	}
 }
 
-func newToken(tokenType token.Type, ch byte, lineNumber int) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch), LineNumber: lineNumber}
+// Returns a token of the current char. Like the other tokens, its literal
+// is a slice of the input, so that reading it doesn't allocate.
+func (l *Lexer) newCharToken(tokenType token.Type) token.Token {
+	return token.Token{Type: tokenType, Literal: l.slice(l.position, l.position+1), LineNumber: l.lineNumber}
+}
+
+// Returns a token of the current char and the next char, which is read.
+func (l *Lexer) newTwoCharToken(tokenType token.Type) token.Token {
+	start := l.position
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: l.slice(start, l.position+1), LineNumber: l.lineNumber}
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -227,62 +636,131 @@ func (l *Lexer) readIdentifier() string {
 	for isLetter(l.ch) || (start != l.position && isDigit(l.ch)) {
 		l.readChar()
 	}
-	return l.input[start:l.position]
+	return l.slice(start, l.position)
 }
 
-func (l *Lexer) readString() string {
+// Reads consecutive strings, which are joined by newlines. Returns the
+// position after the last string's closing quote. A single string is a
+// slice of the input, and only joined strings are copied.
+func (l *Lexer) readString() (string, token.Position) {
+	var value string
 	var sb strings.Builder
+	var end token.Position
 	for l.ch == '"' {
-		if sb.Len() > 0 {
-			sb.WriteString("\n")
-		}
+		open := l.pos()
 		l.readChar()
-		for l.ch != '"' && l.ch != 0 {
-			sb.WriteByte(l.ch)
+		start := l.position
+		for l.ch != '"' && l.ch != '\n' && l.ch != 0 {
 			l.readChar()
 		}
+		part := l.slice(start, l.position)
+		switch {
+		case sb.Len() > 0:
+			sb.WriteString("\n")
+			sb.WriteString(part)
+		case value == "":
+			value = part
+		default:
+			sb.WriteString(value)
+			sb.WriteString("\n")
+			sb.WriteString(part)
+		}
+		if l.ch != '"' {
+			l.recoverUnterminated(open, open, '"')
+			if sb.Len() > 0 {
+				value = sb.String()
+			}
+			return strings.TrimRight(value, "\r"), l.pos()
+		}
 		l.readChar()
+		end = l.pos()
 		l.skipWhitespace()
 	}
-	return sb.String()
+	if sb.Len() > 0 {
+		value = sb.String()
+	}
+	return value, end
 }
 
-func (l *Lexer) readRaw() string {
-	var sb strings.Builder
-	l.readChar()
+// Reads a raw string, whose value is a slice of the input. It's only copied
+// if it has "\r\n" line endings, which are read as "\n".
+func (l *Lexer) readRaw(fenceLength int) string {
+	open := l.pos()
+	l.skipChars(fenceLength)
+	start := l.pos()
 	l.skipNewlineWhitespace()
-	for l.ch != '`' && l.ch != 0 {
-		sb.WriteByte(l.ch)
+	valueStart := l.position
+	for !l.atDelimiter('`', fenceLength) && l.ch != 0 {
 		l.readChar()
 	}
-	l.readChar()
-	return strings.TrimRightFunc(sb.String(), unicode.IsSpace)
+	if l.ch == 0 {
+		l.recoverUnterminated(open, start, '`')
+		return strings.TrimRightFunc(l.slice(start.Offset, l.position), unicode.IsSpace)
+	}
+	value := l.slice(valueStart, l.position)
+	l.skipChars(fenceLength)
+	if strings.Contains(value, "\r\n") {
+		value = strings.Replace(value, "\r\n", "\n", -1)
+	}
+	return strings.TrimRightFunc(value, unicode.IsSpace)
 }
 
 func isLetter(ch byte) bool {
 	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ch == '_'
 }
 
-func (l *Lexer) readNumber() string {
-	start := l.position
-	for isDigit(l.ch) {
+// Reads a decimal, hexadecimal (0x), binary (0b), or octal (leading 0)
+// number, which may be negative. The prefixes can also be uppercase, like
+// 0X. Numbers that are immediately followed by other letters or digits, like
+// 0xZZ or 09, are read as a single token and reported as errors.
+func (l *Lexer) readNumberToken() token.Token {
+	tok := token.Token{Type: token.INT, LineNumber: l.lineNumber}
+	start := l.pos()
+	if l.ch == '-' {
 		l.readChar()
 	}
-	return l.input[start:l.position]
-}
-
-func (l *Lexer) readHexNumber() string {
-	start := l.position
-	for isHexDigit(l.ch) {
+	base := "decimal"
+	isBaseDigit := isDigit
+	if l.ch == '0' && (l.peekChar() == 'x' || l.peekChar() == 'X') {
+		base, isBaseDigit = "hexadecimal", isHexDigit
+		l.readChar()
+		l.readChar()
+	} else if l.ch == '0' && (l.peekChar() == 'b' || l.peekChar() == 'B') {
+		base, isBaseDigit = "binary", isBinaryDigit
+		l.readChar()
+		l.readChar()
+	} else if l.ch == '0' && isDigit(l.peekChar()) {
+		// Like in C and the assembler, a leading 0 makes the number octal.
+		base, isBaseDigit = "octal", isOctalDigit
+	}
+	digitsStart := l.position
+	for isBaseDigit(l.ch) {
+		l.readChar()
+	}
+	valid := l.position > digitsStart
+	for isLetter(l.ch) || isDigit(l.ch) {
+		valid = false
 		l.readChar()
 	}
-	return l.input[start:l.position]
+	tok.Literal = l.slice(start.Offset, l.position)
+	if !valid {
+		l.addError(start, fmt.Sprintf("invalid %s number '%s'", base, tok.Literal))
+	}
+	return tok
 }
 
 func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func isOctalDigit(ch byte) bool {
+	return '0' <= ch && ch <= '7'
+}
+
+func isBinaryDigit(ch byte) bool {
+	return ch == '0' || ch == '1'
+}
+
 func isHexDigit(ch byte) bool {
 	return ('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
 }
@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/huderlem/poryscript/token"
 )
@@ -42,8 +46,14 @@ func TestNextToken(t *testing.T) {
 		const
 		movement
 		mapscripts
+		directive
+		macro
+		texttemplate
+		import
+		@
 		*
 		format
+		data .2byte .
 		("Hello\n"
 		"I'm glad to see$")
 		ascii "Regular"braille"WithType"
@@ -135,8 +145,16 @@ func TestNextToken(t *testing.T) {
 		{token.CONST, "const"},
 		{token.MOVEMENT, "movement"},
 		{token.MAPSCRIPTS, "mapscripts"},
+		{token.DIRECTIVE, "directive"},
+		{token.MACRO, "macro"},
+		{token.TEMPLATE, "texttemplate"},
+		{token.IMPORT, "import"},
+		{token.AT, "@"},
 		{token.MUL, "*"},
 		{token.FORMAT, "format"},
+		{token.DATA, "data"},
+		{token.DATATYPE, ".2byte"},
+		{token.ILLEGAL, "."},
 		{token.LPAREN, "("},
 		{token.STRING, "Hello\\n\nI'm glad to see$"},
 		{token.RPAREN, ")"},
@@ -164,3 +182,503 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestScanComments(t *testing.T) {
+	input := `# Header comment
+script MyScript { // trailing
+	## nested #
+	lock
+}
+//last`
+
+	tests := []struct {
+		expectedType       token.Type
+		expectedLiteral    string
+		expectedLineNumber int
+	}{
+		{token.COMMENT, "# Header comment", 1},
+		{token.SCRIPT, "script", 2},
+		{token.IDENT, "MyScript", 2},
+		{token.LBRACE, "{", 2},
+		{token.COMMENT, "// trailing", 2},
+		{token.COMMENT, "## nested #", 3},
+		{token.IDENT, "lock", 4},
+		{token.RBRACE, "}", 5},
+		{token.COMMENT, "//last", 6},
+		{token.EOF, "", 6},
+	}
+
+	l := NewWithMode(input, ScanComments)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokenType wrong. Expected=%q, Got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. Expected=%q, Got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.LineNumber != tt.expectedLineNumber {
+			t.Errorf("tests[%d] - line number wrong. Expected=%d, Got=%d", i, tt.expectedLineNumber, tok.LineNumber)
+		}
+	}
+}
+
+func TestScanTrivia(t *testing.T) {
+	input := "# Header  \r\nscript MyScript {\n\tmsgbox(\"Hello\"\n\t\t\"World\")  // trailing\n\tmsgbox(ascii\"Hi\")\n}\nraw `\n\t.byte 1\n\n`\n"
+
+	l := NewWithMode(input, ScanTrivia)
+	var sb strings.Builder
+	var types []token.Type
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+		types = append(types, tok.Type)
+		sb.WriteString(Source(tok))
+	}
+	if sb.String() != input {
+		t.Errorf("Expected source to be reproduced exactly. Expected=%q, Got=%q", input, sb.String())
+	}
+
+	expectedTypes := []token.Type{
+		token.COMMENT, token.WHITESPACE, token.SCRIPT, token.WHITESPACE, token.IDENT, token.WHITESPACE, token.LBRACE, token.WHITESPACE,
+		token.IDENT, token.LPAREN, token.STRING, token.WHITESPACE, token.STRING, token.RPAREN, token.WHITESPACE, token.COMMENT, token.WHITESPACE,
+		token.IDENT, token.LPAREN, token.STRINGTYPE, token.STRING, token.RPAREN, token.WHITESPACE,
+		token.RBRACE, token.WHITESPACE, token.RAW, token.WHITESPACE, token.RAWSTRING, token.WHITESPACE,
+	}
+	if len(types) != len(expectedTypes) {
+		t.Fatalf("Expected %d tokens, but got %d: %v", len(expectedTypes), len(types), types)
+	}
+	for i := range types {
+		if types[i] != expectedTypes[i] {
+			t.Errorf("tests[%d] - tokenType wrong. Expected=%q, Got=%q", i, expectedTypes[i], types[i])
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	input := "script MyScript {\n\tmsgbox(ascii\"Hi\", MSGBOX_DEFAULT) # comment\n  // comment\n    if (var(VAR_1) >= -2) {}\n}"
+
+	tests := []struct {
+		expectedLiteral    string
+		expectedLineNumber int
+		expectedColumn     int
+	}{
+		{"script", 1, 1},
+		{"MyScript", 1, 8},
+		{"{", 1, 17},
+		{"msgbox", 2, 2},
+		{"(", 2, 8},
+		{"ascii", 2, 9},
+		{"Hi", 2, 14},
+		{",", 2, 18},
+		{"MSGBOX_DEFAULT", 2, 20},
+		{")", 2, 34},
+		{"if", 4, 5},
+		{"(", 4, 8},
+		{"var", 4, 9},
+		{"(", 4, 12},
+		{"VAR_1", 4, 13},
+		{")", 4, 18},
+		{">=", 4, 20},
+		{"-2", 4, 23},
+		{")", 4, 25},
+		{"{", 4, 27},
+		{"}", 4, 28},
+		{"}", 5, 1},
+		{"", 5, 2},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. Expected=%q, Got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.LineNumber != tt.expectedLineNumber {
+			t.Errorf("tests[%d] - line number wrong. Expected=%d, Got=%d", i, tt.expectedLineNumber, tok.LineNumber)
+		}
+		if tok.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - column wrong. Expected=%d, Got=%d", i, tt.expectedColumn, tok.Column)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "text MyText {\n\t\"Hello\"\n\t\"there\"  \n}\nraw `\n\t.byte 1\n`"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     token.Position
+		expectedEnd     token.Position
+	}{
+		{"text", token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 4, Line: 1, Column: 5}},
+		{"MyText", token.Position{Offset: 5, Line: 1, Column: 6}, token.Position{Offset: 11, Line: 1, Column: 12}},
+		{"{", token.Position{Offset: 12, Line: 1, Column: 13}, token.Position{Offset: 13, Line: 1, Column: 14}},
+		{"Hello\nthere", token.Position{Offset: 15, Line: 2, Column: 2}, token.Position{Offset: 31, Line: 3, Column: 9}},
+		{"}", token.Position{Offset: 34, Line: 4, Column: 1}, token.Position{Offset: 35, Line: 4, Column: 2}},
+		{"raw", token.Position{Offset: 36, Line: 5, Column: 1}, token.Position{Offset: 39, Line: 5, Column: 4}},
+		{"\t.byte 1", token.Position{Offset: 40, Line: 5, Column: 5}, token.Position{Offset: 52, Line: 7, Column: 2}},
+		{"", token.Position{Offset: 52, Line: 7, Column: 2}, token.Position{Offset: 52, Line: 7, Column: 2}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. Expected=%q, Got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos() != tt.expectedPos {
+			t.Errorf("tests[%d] - position wrong. Expected=%+v, Got=%+v", i, tt.expectedPos, tok.Pos())
+		}
+		if tok.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - end wrong. Expected=%+v, Got=%+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestFilepath(t *testing.T) {
+	l := New("script MyScript {}")
+	if tok := l.NextToken(); tok.Filepath != "" {
+		t.Errorf("Expected empty filepath before SetFilepath(), got '%s'", tok.Filepath)
+	}
+	l.SetFilepath("data/scripts/test.pory")
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Filepath != "data/scripts/test.pory" {
+			t.Errorf("Incorrect filepath for token %q. Got '%s'", tok.Literal, tok.Filepath)
+		}
+	}
+}
+
+// Returns all tokens of the lexer, up to and including EOF.
+func readAllTokens(l *Lexer) []token.Token {
+	var tokens []token.Token
+	for {
+		tok := l.NextToken()
+		tokens = append(tokens, tok)
+		if tok.Type == token.EOF {
+			return tokens
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	input := strings.Repeat("# Comment\nscript MyScript {\n\tmsgbox(format(\"Hello\"\n\t\"there\"))\n\tif (var(VAR_1) >= 0x10 && !flag(FLAG_1)) {}\n}\nraw `\n\t.byte -1\n`\n", 200)
+
+	for _, mode := range []Mode{0, ScanComments, ScanTrivia} {
+		expected := readAllTokens(NewWithMode(input, mode))
+		readers := []io.Reader{strings.NewReader(input), iotest.OneByteReader(strings.NewReader(input)), iotest.DataErrReader(strings.NewReader(input))}
+		for i, r := range readers {
+			l := NewReaderWithMode(r, mode)
+			tokens := readAllTokens(l)
+			if l.Err() != nil {
+				t.Errorf("mode %d, reader %d - unexpected error: %s", mode, i, l.Err())
+			}
+			if len(l.buffer) > 2*readChunkSize {
+				t.Errorf("mode %d, reader %d - expected read input to be discarded, but %d bytes are kept", mode, i, len(l.buffer))
+			}
+			if len(tokens) != len(expected) {
+				t.Fatalf("mode %d, reader %d - expected %d tokens, got %d", mode, i, len(expected), len(tokens))
+			}
+			for j := range tokens {
+				if tokens[j] != expected[j] {
+					t.Fatalf("mode %d, reader %d - tokens[%d] wrong. Expected=%+v, Got=%+v", mode, i, j, expected[j], tokens[j])
+				}
+			}
+		}
+	}
+}
+
+func TestReaderLongToken(t *testing.T) {
+	// The raw string is longer than many chunks of the reader, so the buffer
+	// has to grow while it's read.
+	value := strings.Repeat("\t.byte 1\n", 5*readChunkSize)
+	input := "raw `" + value + "`\nscript MyScript {}\n"
+	for i, r := range []io.Reader{strings.NewReader(input), iotest.OneByteReader(strings.NewReader(input))} {
+		tokens := readAllTokens(NewReader(r))
+		expected := readAllTokens(New(input))
+		if len(tokens) != len(expected) {
+			t.Fatalf("reader %d - expected %d tokens, got %d", i, len(expected), len(tokens))
+		}
+		for j := range tokens {
+			if tokens[j] != expected[j] {
+				t.Fatalf("reader %d - tokens[%d] wrong. Expected=%+v, Got=%+v", i, j, expected[j], tokens[j])
+			}
+		}
+		if len(tokens[1].Literal) < 4*readChunkSize {
+			t.Errorf("reader %d - expected the raw string to be read whole, but got %d bytes", i, len(tokens[1].Literal))
+		}
+	}
+}
+
+type failingReader struct{}
+
+var errRead = errors.New("read failed")
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errRead
+}
+
+func TestReaderError(t *testing.T) {
+	l := NewReader(io.MultiReader(strings.NewReader("script MyScript"), failingReader{}))
+	tokens := readAllTokens(l)
+	if len(tokens) != 3 || tokens[1].Literal != "MyScript" {
+		t.Errorf("Expected tokens before the error to be read, got %+v", tokens)
+	}
+	if l.Err() != errRead {
+		t.Errorf("Expected error '%s', got '%v'", errRead, l.Err())
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	input := "script MyScript {\n\t# Comment\n\tmsgbox(\"Hello\"\n\t\"there\")\n}\nraw `\n\t.byte 1\n\t.byte 2\n`\n"
+	windowsInput := byteOrderMark + strings.Replace(input, "\n", "\r\n", -1)
+
+	expected := readAllTokens(New(input))
+	tokens := readAllTokens(New(windowsInput))
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i := range tokens {
+		if tokens[i].Type != expected[i].Type || tokens[i].Literal != expected[i].Literal {
+			t.Errorf("tests[%d] - token wrong. Expected=%s %q, Got=%s %q", i, expected[i].Type, expected[i].Literal, tokens[i].Type, tokens[i].Literal)
+		}
+		if tokens[i].LineNumber != expected[i].LineNumber || tokens[i].Column != expected[i].Column {
+			t.Errorf("tests[%d] - position wrong. Expected=%d:%d, Got=%d:%d", i, expected[i].LineNumber, expected[i].Column, tokens[i].LineNumber, tokens[i].Column)
+		}
+	}
+	if tokens[0].Offset != len(byteOrderMark) {
+		t.Errorf("Expected first token to start after the byte order mark, but got offset %d", tokens[0].Offset)
+	}
+
+	// The ScanTrivia mode reproduces the byte order mark and line endings.
+	var sb strings.Builder
+	for _, tok := range readAllTokens(NewWithMode(windowsInput, ScanTrivia)) {
+		sb.WriteString(Source(tok))
+	}
+	if sb.String() != windowsInput {
+		t.Errorf("Expected source to be reproduced exactly. Expected=%q, Got=%q", windowsInput, sb.String())
+	}
+}
+
+func TestUnterminatedStrings(t *testing.T) {
+	input := "msgbox(\"Hello)\nend\nraw `\n\t.byte 1\nscript"
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+		expectedLine    int
+	}{
+		{token.IDENT, "msgbox", 1},
+		{token.LPAREN, "(", 1},
+		{token.STRING, "Hello)", 1},
+		{token.IDENT, "end", 2},
+		{token.RAW, "raw", 3},
+		{token.RAWSTRING, "", 3},
+		{token.DATATYPE, ".byte", 4},
+		{token.INT, "1", 4},
+		{token.SCRIPT, "script", 5},
+		{token.EOF, "", 5},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.LineNumber != tt.expectedLine {
+			t.Errorf("tests[%d] - token wrong. Expected=%s %q line %d, Got=%s %q line %d", i, tt.expectedType, tt.expectedLiteral, tt.expectedLine, tok.Type, tok.Literal, tok.LineNumber)
+		}
+	}
+	expectedErrors := []Error{
+		{Pos: token.Position{Offset: 7, Line: 1, Column: 8}, Message: "unterminated string"},
+		{Pos: token.Position{Offset: 23, Line: 3, Column: 5}, Message: "unterminated raw string"},
+	}
+	if len(l.Errors()) != len(expectedErrors) {
+		t.Fatalf("Expected %d errors, got %d: %+v", len(expectedErrors), len(l.Errors()), l.Errors())
+	}
+	for i, expected := range expectedErrors {
+		if l.Errors()[i] != expected {
+			t.Errorf("errors[%d] wrong. Expected=%+v, Got=%+v", i, expected, l.Errors()[i])
+		}
+	}
+
+	// The ScanTrivia mode produces unterminated strings as ILLEGAL tokens,
+	// so that the source is still reproduced exactly.
+	var sb strings.Builder
+	var illegal []string
+	for _, tok := range readAllTokens(NewWithMode(input, ScanTrivia)) {
+		sb.WriteString(Source(tok))
+		if tok.Type == token.ILLEGAL {
+			illegal = append(illegal, tok.Literal)
+		}
+	}
+	if sb.String() != input {
+		t.Errorf("Expected source to be reproduced exactly. Expected=%q, Got=%q", input, sb.String())
+	}
+	if len(illegal) < 2 || illegal[0] != "\"Hello)" || illegal[1] != "`" {
+		t.Errorf("Expected unterminated strings to be ILLEGAL tokens, got %q", illegal)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := "12 -7 0x1F 0b101 -0x10 0 0xZZ 0x 0b102 12abc 0X1f 0B11 -0XA 017 00 -010 08 09 0719 0XG"
+
+	tests := []string{"12", "-7", "0x1F", "0b101", "-0x10", "0", "0xZZ", "0x", "0b102", "12abc", "0X1f", "0B11", "-0XA", "017", "00", "-010", "08", "09", "0719", "0XG"}
+	l := New(input)
+	for i, expected := range tests {
+		tok := l.NextToken()
+		if tok.Type != token.INT || tok.Literal != expected {
+			t.Errorf("tests[%d] - token wrong. Expected=INT %q, Got=%s %q", i, expected, tok.Type, tok.Literal)
+		}
+	}
+	expectedErrors := []string{
+		"invalid hexadecimal number '0xZZ'",
+		"invalid hexadecimal number '0x'",
+		"invalid binary number '0b102'",
+		"invalid decimal number '12abc'",
+		"invalid octal number '08'",
+		"invalid octal number '09'",
+		"invalid octal number '0719'",
+		"invalid hexadecimal number '0XG'",
+	}
+	if len(l.Errors()) != len(expectedErrors) {
+		t.Fatalf("Expected %d errors, got %d: %+v", len(expectedErrors), len(l.Errors()), l.Errors())
+	}
+	for i, expected := range expectedErrors {
+		if l.Errors()[i].Message != expected {
+			t.Errorf("errors[%d] wrong. Expected=%q, Got=%q", i, expected, l.Errors()[i].Message)
+		}
+	}
+	if pos := l.Errors()[0].Pos; pos.Column != 26 {
+		t.Errorf("Expected error at column 26, got %d", pos.Column)
+	}
+}
+
+func TestCaseInsensitiveKeywords(t *testing.T) {
+	input := "SCRIPT MyScript { If (FLAG(FLAG_1) == True) { Text } }"
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.SCRIPT, "script"},
+		{token.IDENT, "MyScript"},
+		{token.LBRACE, "{"},
+		{token.IF, "if"},
+		{token.LPAREN, "("},
+		{token.FLAG, "flag"},
+		{token.LPAREN, "("},
+		{token.IDENT, "FLAG_1"},
+		{token.RPAREN, ")"},
+		{token.EQ, "=="},
+		{token.TRUE, "true"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.TEXT, "text"},
+		{token.RBRACE, "}"},
+		{token.RBRACE, "}"},
+	}
+
+	l := NewWithMode(input, CaseInsensitiveKeywords)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - token wrong. Expected=%s %q, Got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	// Keywords are only case-sensitive by default.
+	if tok := New("If").NextToken(); tok.Type != token.IDENT {
+		t.Errorf("Expected IDENT without CaseInsensitiveKeywords, got %s", tok.Type)
+	}
+	// The ScanTrivia mode keeps the literals as they were written.
+	if tok := NewWithMode("If", CaseInsensitiveKeywords|ScanTrivia).NextToken(); tok.Type != token.IF || tok.Literal != "If" {
+		t.Errorf("Expected IF token with original literal, got %s %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestAllocations(t *testing.T) {
+	// The literals of the tokens are slices of the input, so only the lexer
+	// itself is allocated. Only consecutive strings, which are joined, and
+	// raw strings with "\r\n" line endings need to be copied.
+	input := "script MyScript {\n\tif (var(VAR_1) >= 0x10 && !flag(FLAG_1)) {\n\t\tmsgbox(ascii\"Hello\", MSGBOX_DEFAULT)\n\t}\n}\nraw `\n\t.byte -1\n`\n# Comment\n"
+	for _, mode := range []Mode{0, ScanComments, ScanTrivia} {
+		allocs := testing.AllocsPerRun(10, func() {
+			l := NewWithMode(input, mode)
+			for l.NextToken().Type != token.EOF {
+			}
+		})
+		if allocs > 2 {
+			t.Errorf("mode %d - expected at most 2 allocations, got %v", mode, allocs)
+		}
+	}
+}
+
+// Input of the benchmarks, which is like a large map's script file.
+var benchmarkInput = strings.Repeat(`# Talks to the player.
+script Route101_EventScript_Youngster {
+	lock
+	faceplayer
+	if (var(VAR_ROUTE101_STATE) >= 0x2 && !flag(FLAG_BADGE01_GET)) {
+		msgbox("Hi! Have you seen the professor?\n"
+			"He went into the tall grass.", MSGBOX_DEFAULT)
+	} elif (defeated(TRAINER_CALVIN_1) == TRUE || var(VAR_RESULT) != 1) {
+		msgbox(format("I lost, but I'll train harder!"))
+	}
+	applymovement(OBJ_EVENT_ID_PLAYER, Route101_Movement_Walk)
+	release
+}
+
+movement Route101_Movement_Walk {
+	walk_up * 2
+	face_down
+}
+
+raw `+"`"+`
+Route101_Data:
+	.byte -1, 0b101
+`+"`"+`
+`, 500)
+
+// Helper benchmark var to prevent compiler/runtime optimizations.
+var benchmarkTokens int
+
+func benchmarkLexer(b *testing.B, mode Mode) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkInput)))
+	for i := 0; i < b.N; i++ {
+		l := NewWithMode(benchmarkInput, mode)
+		count := 0
+		for l.NextToken().Type != token.EOF {
+			count++
+		}
+		benchmarkTokens = count
+	}
+}
+
+func BenchmarkLexer(b *testing.B) {
+	benchmarkLexer(b, 0)
+}
+
+func BenchmarkLexerScanTrivia(b *testing.B) {
+	benchmarkLexer(b, ScanTrivia)
+}
+
+func BenchmarkLexerReader(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkInput)))
+	for i := 0; i < b.N; i++ {
+		l := NewReader(strings.NewReader(benchmarkInput))
+		count := 0
+		for l.NextToken().Type != token.EOF {
+			count++
+		}
+		benchmarkTokens = count
+	}
+}
@@ -0,0 +1,145 @@
+package lsp
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/huderlem/poryscript/symbols"
+	"github.com/huderlem/poryscript/token"
+)
+
+// An open text document.
+type document struct {
+	uri         string
+	text        string
+	lines       []string
+	lineOffsets []int
+	file        *symbols.File
+}
+
+func newDocument(uri string, text string, file *symbols.File) *document {
+	doc := &document{uri: uri, text: text, file: file}
+	doc.lines = strings.Split(text, "\n")
+	offset := 0
+	for _, line := range doc.lines {
+		doc.lineOffsets = append(doc.lineOffsets, offset)
+		offset += len(line) + 1
+	}
+	return doc
+}
+
+// Returns the number of UTF-16 code units in the text, which is how the
+// Language Server Protocol counts characters.
+func getUTF16Length(text string) int {
+	length := 0
+	for _, r := range text {
+		if r >= 0x10000 {
+			// Characters outside the Basic Multilingual Plane are
+			// surrogate pairs in UTF-16.
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return length
+}
+
+// Converts a symbols position into a protocol position.
+func (doc *document) toPosition(pos symbols.Position) Position {
+	line := pos.Line - 1
+	if line < 0 || line >= len(doc.lines) {
+		return Position{Line: line, Character: pos.Column - 1}
+	}
+	text := doc.lines[line]
+	column := pos.Column - 1
+	if column > len(text) {
+		column = len(text)
+	}
+	return Position{Line: line, Character: getUTF16Length(text[:column])}
+}
+
+// Converts a symbols span into a protocol range.
+func (doc *document) toRange(span symbols.Span) Range {
+	return Range{Start: doc.toPosition(span.Start), End: doc.toPosition(span.End)}
+}
+
+// Converts a protocol position into a symbols position.
+func (doc *document) fromPosition(pos Position) symbols.Position {
+	if pos.Line < 0 || pos.Line >= len(doc.lines) {
+		return symbols.Position{Offset: len(doc.text), Line: pos.Line + 1, Column: 1}
+	}
+	text := doc.lines[pos.Line]
+	column := len(text)
+	length := 0
+	for i, r := range text {
+		if length >= pos.Character {
+			column = i
+			break
+		}
+		length += getUTF16Length(string(r))
+	}
+	return symbols.Position{
+		Offset: doc.lineOffsets[pos.Line] + column,
+		Line:   pos.Line + 1,
+		Column: column + 1,
+	}
+}
+
+// Matches identifiers that are most likely constants defined by the decomp
+// project, such as FLAG_BADGE01_GET or VAR_RESULT.
+var constantRegex = regexp.MustCompile(`^[A-Z][A-Z0-9]*_[A-Z0-9_]+$`)
+
+// Returns the names of the commands and constants that are used in the
+// document. Poryscript doesn't know the decomp project's commands and
+// constants, so they are gathered from their uses.
+func (doc *document) findCommandsAndConstants() ([]string, []string) {
+	commands := []string{}
+	constants := []string{}
+	seen := make(map[string]bool)
+	for _, d := range doc.file.Definitions {
+		seen[d.Name] = true
+	}
+	depth := 0
+	for i, tok := range doc.file.Tokens {
+		switch tok.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+		if tok.Type != token.IDENT || seen[tok.Literal] {
+			continue
+		}
+		if constantRegex.MatchString(tok.Literal) {
+			seen[tok.Literal] = true
+			constants = append(constants, tok.Literal)
+		} else if depth > 0 && doc.isCommand(i) {
+			seen[tok.Literal] = true
+			commands = append(commands, tok.Literal)
+		}
+	}
+	return commands, constants
+}
+
+// Reports whether the identifier at the given index is the name of a
+// command, such as msgbox("Hello") or lock.
+func (doc *document) isCommand(i int) bool {
+	tokens := doc.file.Tokens
+	tok := tokens[i]
+	if i > 0 {
+		prev := tokens[i-1]
+		if prev.Type == token.LPAREN || prev.Type == token.COMMA {
+			return false
+		}
+		if prev.Span.End.Line == tok.Span.Start.Line && prev.Type != token.LBRACE && prev.Type != token.RBRACE {
+			return false
+		}
+	}
+	if i+1 >= len(tokens) {
+		return true
+	}
+	next := tokens[i+1]
+	return next.Type == token.LPAREN || next.Span.Start.Line != tok.Span.End.Line || next.Type == token.RBRACE
+}
@@ -0,0 +1,200 @@
+package lsp
+
+import "encoding/json"
+
+// The subset of the Language Server Protocol types used by the server.
+// See https://microsoft.github.io/language-server-protocol/specification
+
+type requestMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type responseMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type errorResponseMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   responseError    `json:"error"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type notificationMessage struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// JSON-RPC error codes.
+const (
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+)
+
+// Position is a zero-based line and character offset in a document.
+// Characters are counted in UTF-16 code units.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a span of a document. The end position is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location is a range in a document.
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type didOpenTextDocumentParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type textDocumentContentChangeEvent struct {
+	Text string `json:"text"`
+}
+
+type didChangeTextDocumentParams struct {
+	TextDocument   textDocumentIdentifier           `json:"textDocument"`
+	ContentChanges []textDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type didCloseTextDocumentParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type referenceContext struct {
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
+
+type referenceParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	Context      referenceContext       `json:"context"`
+}
+
+type documentSymbolParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+// Text document sync kinds.
+const (
+	textDocumentSyncFull = 1
+)
+
+type serverCapabilities struct {
+	TextDocumentSync       int                `json:"textDocumentSync"`
+	DocumentSymbolProvider bool               `json:"documentSymbolProvider"`
+	HoverProvider          bool               `json:"hoverProvider"`
+	DefinitionProvider     bool               `json:"definitionProvider"`
+	ReferencesProvider     bool               `json:"referencesProvider"`
+	CompletionProvider     *completionOptions `json:"completionProvider,omitempty"`
+}
+
+type completionOptions struct{}
+
+type serverInfo struct {
+	Name string `json:"name"`
+}
+
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+	ServerInfo   serverInfo         `json:"serverInfo"`
+}
+
+// Diagnostic severities.
+const (
+	diagnosticSeverityError   = 1
+	diagnosticSeverityWarning = 2
+)
+
+// Diagnostic is a problem reported in a document.
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Symbol kinds.
+const (
+	symbolKindNamespace = 3
+	symbolKindFunction  = 12
+	symbolKindConstant  = 14
+	symbolKindString    = 15
+	symbolKindArray     = 18
+)
+
+// DocumentSymbol is a top-level statement of a document.
+type DocumentSymbol struct {
+	Name           string `json:"name"`
+	Detail         string `json:"detail,omitempty"`
+	Kind           int    `json:"kind"`
+	Range          Range  `json:"range"`
+	SelectionRange Range  `json:"selectionRange"`
+}
+
+// Completion item kinds.
+const (
+	completionItemKindText      = 1
+	completionItemKindFunction  = 3
+	completionItemKindModule    = 9
+	completionItemKindValue     = 12
+	completionItemKindKeyword   = 14
+	completionItemKindSnippet   = 15
+	completionItemKindReference = 18
+	completionItemKindConstant  = 21
+)
+
+// CompletionItem is a suggestion offered at the cursor.
+type CompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Hover is the information shown for the symbol under the cursor.
+type Hover struct {
+	Contents markupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
@@ -0,0 +1,479 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/parser"
+	"github.com/huderlem/poryscript/symbols"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Server is a Poryscript language server, which communicates with an editor
+// using the Language Server Protocol.
+type Server struct {
+	reader             *bufio.Reader
+	writer             io.Writer
+	fontConfigFilepath string
+	diagnosticOptions  parser.DiagnosticOptions
+	lintConfig         *parser.LintConfig
+	documents          map[string]*document
+	index              *symbols.Index
+}
+
+// NewServer creates a new language server, which reads messages from in and
+// writes messages to out.
+func NewServer(in io.Reader, out io.Writer, fontConfigFilepath string) *Server {
+	return &Server{
+		reader:             bufio.NewReader(in),
+		writer:             out,
+		fontConfigFilepath: fontConfigFilepath,
+		documents:          make(map[string]*document),
+		index:              symbols.NewIndex(),
+	}
+}
+
+// SetDiagnosticOptions sets the options that control how warnings are reported.
+func (s *Server) SetDiagnosticOptions(options parser.DiagnosticOptions) {
+	s.diagnosticOptions = options
+}
+
+// SetLintConfig sets the lint rules that are checked. A nil config disables linting.
+func (s *Server) SetLintConfig(config *parser.LintConfig) {
+	s.lintConfig = config
+}
+
+// Run handles messages until the client sends the "exit" notification, or
+// the input is closed.
+func (s *Server) Run() error {
+	for {
+		message, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if message.Method == "exit" {
+			return nil
+		}
+		if err := s.handleMessage(message); err != nil {
+			return err
+		}
+	}
+}
+
+// Reads a message, which is preceded by a Content-Length header.
+func (s *Server) readMessage() (requestMessage, error) {
+	var message requestMessage
+	contentLength := -1
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return message, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			contentLength, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			if err != nil {
+				return message, fmt.Errorf("invalid Content-Length header '%s'", line)
+			}
+		}
+	}
+	if contentLength < 0 {
+		return message, fmt.Errorf("missing Content-Length header")
+	}
+	content := make([]byte, contentLength)
+	if _, err := io.ReadFull(s.reader, content); err != nil {
+		return message, err
+	}
+	if err := json.Unmarshal(content, &message); err != nil {
+		return message, fmt.Errorf("invalid message: %s", err)
+	}
+	return message, nil
+}
+
+func (s *Server) writeMessage(message interface{}) error {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	return err
+}
+
+func (s *Server) handleMessage(message requestMessage) error {
+	result, err := s.handleMethod(message)
+	if message.ID == nil {
+		// Notifications don't have a response.
+		return nil
+	}
+	if err != nil {
+		return s.writeMessage(errorResponseMessage{JSONRPC: "2.0", ID: message.ID, Error: *err})
+	}
+	return s.writeMessage(responseMessage{JSONRPC: "2.0", ID: message.ID, Result: result})
+}
+
+func (s *Server) handleMethod(message requestMessage) (interface{}, *responseError) {
+	switch message.Method {
+	case "initialize":
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:       textDocumentSyncFull,
+				DocumentSymbolProvider: true,
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				ReferencesProvider:     true,
+				CompletionProvider:     &completionOptions{},
+			},
+			ServerInfo: serverInfo{Name: "poryscript"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenTextDocumentParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.updateDocument(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeTextDocumentParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) > 0 {
+			// The server uses full document sync, so the last change
+			// holds the entire document.
+			s.updateDocument(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseTextDocumentParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.index.RemoveFile(params.TextDocument.URI)
+		s.publishDiagnostics(params.TextDocument.URI, []Diagnostic{})
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.getDocumentSymbols(params.TextDocument.URI), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.getCompletionItems(params.TextDocument.URI), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.getHover(params.TextDocument.URI, params.Position), nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.getDefinition(params.TextDocument.URI, params.Position), nil
+	case "textDocument/references":
+		var params referenceParams
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.getReferences(params.TextDocument.URI, params.Position, params.Context.IncludeDeclaration), nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("unsupported method '%s'", message.Method)}
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+func (s *Server) updateDocument(uri string, text string) {
+	doc := newDocument(uri, text, s.index.AddFile(uri, text))
+	s.documents[uri] = doc
+	s.publishDiagnostics(uri, s.getDiagnostics(doc))
+}
+
+func (s *Server) publishDiagnostics(uri string, diagnostics []Diagnostic) {
+	s.writeMessage(notificationMessage{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+var windowsDriveRegex = regexp.MustCompile(`^/[A-Za-z]:`)
+
+// Returns the filepath of a "file://" URI, or the URI itself if it refers
+// to something else.
+func getFilepath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if windowsDriveRegex.MatchString(path) {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+// Parses the document, and returns its errors and warnings.
+func (s *Server) getDiagnostics(doc *document) []Diagnostic {
+	path := getFilepath(doc.uri)
+	p := parser.New(lexer.New(doc.text), s.fontConfigFilepath, nil)
+	p.SetFilepath(path)
+	p.SetDiagnosticOptions(s.diagnosticOptions)
+	p.SetLintConfig(s.lintConfig)
+	_, err := p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	if err != nil && !parser.IsDiagnosticsError(err) {
+		diagnostics = append(diagnostics, parser.NewErrorDiagnostic(err))
+	}
+
+	result := []Diagnostic{}
+	lines := strings.Split(doc.text, "\n")
+	for _, d := range diagnostics {
+		message := d.Message
+		lineNumber := d.LineNumber
+		column := d.Column
+		if d.Filepath != "" && d.Filepath != path {
+			// The problem is in an imported file, so it's reported
+			// at the top of the document.
+			message = d.String()
+			lineNumber = 1
+			column = 0
+		}
+		if lineNumber < 1 || lineNumber > len(lines) {
+			lineNumber = 1
+			column = 0
+		}
+		severity := diagnosticSeverityWarning
+		if d.Severity == parser.SeverityError {
+			severity = diagnosticSeverityError
+		}
+		code := d.Category
+		if d.Rule != "" {
+			code = d.Rule
+		}
+		result = append(result, Diagnostic{
+			Range:    getLineRange(lines, lineNumber-1, column),
+			Severity: severity,
+			Code:     code,
+			Source:   "poryscript",
+			Message:  message,
+		})
+	}
+	return result
+}
+
+// Returns the range of the given line, starting at the given column, or
+// after its indentation if the column is unknown.
+func getLineRange(lines []string, line int, column int) Range {
+	text := strings.TrimRight(lines[line], "\r")
+	start := len(text) - len(strings.TrimLeft(text, " \t"))
+	if column > 0 && column <= len(text)+1 {
+		start = getUTF16Length(text[:column-1])
+	}
+	return Range{
+		Start: Position{Line: line, Character: start},
+		End:   Position{Line: line, Character: getUTF16Length(text)},
+	}
+}
+
+var symbolKinds = map[symbols.Kind]int{
+	symbols.KindScript:       symbolKindFunction,
+	symbols.KindText:         symbolKindString,
+	symbols.KindMovement:     symbolKindArray,
+	symbols.KindMart:         symbolKindArray,
+	symbols.KindMapScripts:   symbolKindNamespace,
+	symbols.KindConst:        symbolKindConstant,
+	symbols.KindMacro:        symbolKindFunction,
+	symbols.KindTextTemplate: symbolKindString,
+	symbols.KindData:         symbolKindArray,
+	symbols.KindAlias:        symbolKindConstant,
+}
+
+func (s *Server) getDocumentSymbols(uri string) []DocumentSymbol {
+	result := []DocumentSymbol{}
+	doc, ok := s.documents[uri]
+	if !ok {
+		return result
+	}
+	for _, d := range doc.file.Definitions {
+		result = append(result, DocumentSymbol{
+			Name:           d.Name,
+			Detail:         d.Kind.String(),
+			Kind:           symbolKinds[d.Kind],
+			Range:          doc.toRange(d.Span),
+			SelectionRange: doc.toRange(d.NameSpan),
+		})
+	}
+	return result
+}
+
+// Keywords offered as completions.
+var completionKeywords = []string{
+	"alias", "break", "case", "const", "continue", "data", "default",
+	"defeated", "directive", "do", "elif", "else", "false", "flag", "format",
+	"global", "if", "import", "local", "macro", "mapscripts", "mart",
+	"movement", "poryswitch", "raw", "script", "switch", "text",
+	"texttemplate", "true", "var", "while",
+}
+
+var completionKinds = map[symbols.Kind]int{
+	symbols.KindScript:       completionItemKindFunction,
+	symbols.KindText:         completionItemKindText,
+	symbols.KindMovement:     completionItemKindValue,
+	symbols.KindMart:         completionItemKindValue,
+	symbols.KindMapScripts:   completionItemKindModule,
+	symbols.KindConst:        completionItemKindConstant,
+	symbols.KindMacro:        completionItemKindSnippet,
+	symbols.KindTextTemplate: completionItemKindText,
+	symbols.KindData:         completionItemKindValue,
+	symbols.KindAlias:        completionItemKindReference,
+}
+
+func (s *Server) getCompletionItems(uri string) []CompletionItem {
+	result := []CompletionItem{}
+	for _, keyword := range completionKeywords {
+		result = append(result, CompletionItem{Label: keyword, Kind: completionItemKindKeyword})
+	}
+	doc, ok := s.documents[uri]
+	if !ok {
+		return result
+	}
+	seen := make(map[string]bool)
+	for _, d := range s.index.Definitions() {
+		if seen[d.Name] || (d.Filepath != uri && d.Scope == token.LOCAL) {
+			continue
+		}
+		seen[d.Name] = true
+		result = append(result, CompletionItem{
+			Label:  d.Name,
+			Kind:   completionKinds[d.Kind],
+			Detail: d.Kind.String(),
+		})
+	}
+	commands, constants := doc.findCommandsAndConstants()
+	sort.Strings(commands)
+	sort.Strings(constants)
+	for _, command := range commands {
+		result = append(result, CompletionItem{Label: command, Kind: completionItemKindFunction, Detail: "command"})
+	}
+	for _, constant := range constants {
+		if !seen[constant] {
+			result = append(result, CompletionItem{Label: constant, Kind: completionItemKindConstant, Detail: "constant"})
+		}
+	}
+	return result
+}
+
+func (s *Server) getHover(uri string, pos Position) *Hover {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil
+	}
+	tok, ok := doc.file.TokenAt(doc.fromPosition(pos))
+	if !ok || tok.Type != token.IDENT {
+		return nil
+	}
+	d, ok := s.index.LookupDefinition(uri, tok.Literal)
+	if !ok {
+		return nil
+	}
+	rng := doc.toRange(tok.Span)
+	return &Hover{
+		Contents: markupContent{Kind: "markdown", Value: getDefinitionDescription(d)},
+		Range:    &rng,
+	}
+}
+
+// Returns the markdown description of a definition, which is shown on hover.
+func getDefinitionDescription(d symbols.Definition) string {
+	var sb strings.Builder
+	sb.WriteString("```poryscript\n")
+	sb.WriteString(d.Kind.String())
+	if d.Scope != "" {
+		sb.WriteString(fmt.Sprintf("(%s)", strings.ToLower(string(d.Scope))))
+	}
+	sb.WriteString(" ")
+	sb.WriteString(d.Name)
+	if d.Kind == symbols.KindConst {
+		sb.WriteString(" = ")
+		sb.WriteString(d.Value)
+	}
+	sb.WriteString("\n```")
+	if d.Value != "" && d.Kind != symbols.KindConst {
+		sb.WriteString("\n\n")
+		sb.WriteString(strings.Replace(d.Value, "\n", "  \n", -1))
+	}
+	return sb.String()
+}
+
+// Returns the protocol location of a span in an open document.
+func (s *Server) getLocation(uri string, span symbols.Span) (Location, bool) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return Location{}, false
+	}
+	return Location{URI: uri, Range: doc.toRange(span)}, true
+}
+
+func (s *Server) getDefinition(uri string, pos Position) []Location {
+	result := []Location{}
+	doc, ok := s.documents[uri]
+	if !ok {
+		return result
+	}
+	d, ok := s.index.DefinitionAt(uri, doc.fromPosition(pos))
+	if !ok {
+		return result
+	}
+	if location, ok := s.getLocation(d.Filepath, d.NameSpan); ok {
+		result = append(result, location)
+	}
+	return result
+}
+
+func (s *Server) getReferences(uri string, pos Position, includeDeclaration bool) []Location {
+	result := []Location{}
+	doc, ok := s.documents[uri]
+	if !ok {
+		return result
+	}
+	d, ok := s.index.DefinitionAt(uri, doc.fromPosition(pos))
+	if !ok {
+		return result
+	}
+	if includeDeclaration {
+		if location, ok := s.getLocation(d.Filepath, d.NameSpan); ok {
+			result = append(result, location)
+		}
+	}
+	for _, reference := range s.index.FindReferences(d) {
+		if location, ok := s.getLocation(reference.Filepath, reference.Span); ok {
+			result = append(result, location)
+		}
+	}
+	return result
+}
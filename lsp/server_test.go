@@ -0,0 +1,266 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func encodeMessages(t *testing.T, messages ...map[string]interface{}) io.Reader {
+	var buf bytes.Buffer
+	for _, message := range messages {
+		message["jsonrpc"] = "2.0"
+		content, err := json.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	}
+	return &buf
+}
+
+type testMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func decodeMessages(t *testing.T, output []byte) []testMessage {
+	messages := []testMessage{}
+	reader := bufio.NewReader(bytes.NewReader(output))
+	for {
+		header, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return messages
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "Content-Length:")))
+		if err != nil {
+			t.Fatalf("Invalid header '%s'", header)
+		}
+		reader.ReadString('\n')
+		content := make([]byte, length)
+		io.ReadFull(reader, content)
+		var message testMessage
+		if err := json.Unmarshal(content, &message); err != nil {
+			t.Fatalf("Invalid message '%s': %s", content, err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+func getResponse(t *testing.T, messages []testMessage, id int, result interface{}) {
+	for _, message := range messages {
+		if message.ID != nil && *message.ID == id {
+			if message.Error != nil {
+				t.Fatalf("Unexpected error response for request %d: %s", id, message.Error.Message)
+			}
+			if err := json.Unmarshal(message.Result, result); err != nil {
+				t.Fatalf("Invalid result for request %d: %s", id, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("Missing response for request %d", id)
+}
+
+func TestServer(t *testing.T) {
+	uri := "file:///project/data/maps/Route1/scripts.pory"
+	input := `const GUIDE_ID = 3
+
+script Route1_EventScript_Guide {
+    lock
+    faceplayer
+    msgbox(Route1_Text_Hello, MSGBOX_DEFAULT)
+    applymovement(GUIDE_ID, Route1_Movement_Walk)
+    release
+}
+
+text Route1_Text_Hello {
+    "Hello!\p"
+    "Welcome to ROUTE 1."
+}
+
+movement Route1_Movement_Walk {
+    walk_left * 2
+}
+
+script(local) Route1_EventScript_Broken {
+    if (
+}
+`
+	in := encodeMessages(t,
+		map[string]interface{}{"id": 1, "method": "initialize", "params": map[string]interface{}{}},
+		map[string]interface{}{"method": "initialized", "params": map[string]interface{}{}},
+		map[string]interface{}{"method": "textDocument/didOpen", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "poryscript", "version": 1, "text": input},
+		}},
+		map[string]interface{}{"id": 2, "method": "textDocument/documentSymbol", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+		}},
+		map[string]interface{}{"id": 3, "method": "textDocument/completion", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": 4, "character": 4},
+		}},
+		map[string]interface{}{"id": 4, "method": "textDocument/hover", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": 5, "character": 12},
+		}},
+		map[string]interface{}{"id": 5, "method": "textDocument/hover", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": 6, "character": 20},
+		}},
+		map[string]interface{}{"id": 6, "method": "textDocument/definition", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": 5, "character": 12},
+		}},
+		map[string]interface{}{"id": 7, "method": "textDocument/references", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": 10, "character": 5},
+			"context":      map[string]interface{}{"includeDeclaration": true},
+		}},
+		map[string]interface{}{"id": 8, "method": "textDocument/codeLens", "params": map[string]interface{}{}},
+		map[string]interface{}{"id": 9, "method": "shutdown"},
+		map[string]interface{}{"method": "exit"},
+	)
+	var out bytes.Buffer
+	if err := NewServer(in, &out, "../font_widths.json").Run(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	messages := decodeMessages(t, out.Bytes())
+
+	var initResult initializeResult
+	getResponse(t, messages, 1, &initResult)
+	if initResult.Capabilities.TextDocumentSync != textDocumentSyncFull || !initResult.Capabilities.HoverProvider || !initResult.Capabilities.DocumentSymbolProvider {
+		t.Errorf("Incorrect capabilities: %+v", initResult.Capabilities)
+	}
+
+	var diagnostics publishDiagnosticsParams
+	for _, message := range messages {
+		if message.Method == "textDocument/publishDiagnostics" {
+			json.Unmarshal(message.Params, &diagnostics)
+		}
+	}
+	if len(diagnostics.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %d", len(diagnostics.Diagnostics))
+	}
+	diagnostic := diagnostics.Diagnostics[0]
+	if diagnostic.Severity != diagnosticSeverityError || diagnostic.Range.Start.Line != 20 || diagnostic.Range.Start.Character != 7 {
+		t.Errorf("Incorrect diagnostic: %+v", diagnostic)
+	}
+
+	var symbols []DocumentSymbol
+	getResponse(t, messages, 2, &symbols)
+	expectedSymbols := []struct {
+		name      string
+		kind      int
+		startLine int
+		endLine   int
+		nameChar  int
+	}{
+		{"GUIDE_ID", symbolKindConstant, 0, 0, 6},
+		{"Route1_EventScript_Guide", symbolKindFunction, 2, 8, 7},
+		{"Route1_Text_Hello", symbolKindString, 10, 13, 5},
+		{"Route1_Movement_Walk", symbolKindArray, 15, 17, 9},
+		{"Route1_EventScript_Broken", symbolKindFunction, 19, 21, 14},
+	}
+	if len(symbols) != len(expectedSymbols) {
+		t.Fatalf("Expected %d symbols, got %d", len(expectedSymbols), len(symbols))
+	}
+	for i, expected := range expectedSymbols {
+		symbol := symbols[i]
+		if symbol.Name != expected.name || symbol.Kind != expected.kind || symbol.Range.Start.Line != expected.startLine || symbol.Range.End.Line != expected.endLine || symbol.SelectionRange.Start.Character != expected.nameChar {
+			t.Errorf("Incorrect symbol %d. Expected %+v, got %+v", i, expected, symbol)
+		}
+	}
+
+	var items []CompletionItem
+	getResponse(t, messages, 3, &items)
+	labels := make(map[string]int)
+	for _, item := range items {
+		labels[item.Label] = item.Kind
+	}
+	expectedItems := map[string]int{
+		"script":                   completionItemKindKeyword,
+		"Route1_EventScript_Guide": completionItemKindFunction,
+		"Route1_Text_Hello":        completionItemKindText,
+		"GUIDE_ID":                 completionItemKindConstant,
+		"lock":                     completionItemKindFunction,
+		"msgbox":                   completionItemKindFunction,
+		"applymovement":            completionItemKindFunction,
+		"MSGBOX_DEFAULT":           completionItemKindConstant,
+	}
+	for label, kind := range expectedItems {
+		if labels[label] != kind {
+			t.Errorf("Expected completion item '%s' with kind %d, got %d", label, kind, labels[label])
+		}
+	}
+	if _, ok := labels["walk_left"]; ok {
+		t.Errorf("Unexpected completion item 'walk_left'")
+	}
+
+	var hover Hover
+	getResponse(t, messages, 4, &hover)
+	expectedHover := "```poryscript\ntext(global) Route1_Text_Hello\n```\n\nHello!\\p  \nWelcome to ROUTE 1."
+	if hover.Contents.Value != expectedHover {
+		t.Errorf("Incorrect hover. Expected:\n%s\nGot:\n%s", expectedHover, hover.Contents.Value)
+	}
+	if hover.Range == nil || hover.Range.Start.Character != 11 || hover.Range.End.Character != 28 {
+		t.Errorf("Incorrect hover range: %+v", hover.Range)
+	}
+	getResponse(t, messages, 5, &hover)
+	expectedHover = "```poryscript\nconst GUIDE_ID = 3\n```"
+	if hover.Contents.Value != expectedHover {
+		t.Errorf("Incorrect hover. Expected:\n%s\nGot:\n%s", expectedHover, hover.Contents.Value)
+	}
+
+	var locations []Location
+	getResponse(t, messages, 6, &locations)
+	expectedRange := Range{Start: Position{Line: 10, Character: 5}, End: Position{Line: 10, Character: 22}}
+	if len(locations) != 1 || locations[0].URI != uri || locations[0].Range != expectedRange {
+		t.Errorf("Incorrect definition. Expected %+v, got %+v", expectedRange, locations)
+	}
+	getResponse(t, messages, 7, &locations)
+	expectedRanges := []Range{
+		expectedRange,
+		{Start: Position{Line: 5, Character: 11}, End: Position{Line: 5, Character: 28}},
+	}
+	if len(locations) != len(expectedRanges) {
+		t.Fatalf("Expected %d references, got %d", len(expectedRanges), len(locations))
+	}
+	for i, location := range locations {
+		if location.Range != expectedRanges[i] {
+			t.Errorf("Incorrect reference %d. Expected %+v, got %+v", i, expectedRanges[i], location.Range)
+		}
+	}
+
+	for _, message := range messages {
+		if message.ID != nil && *message.ID == 8 {
+			if message.Error == nil || message.Error.Code != codeMethodNotFound {
+				t.Errorf("Expected method not found error for unsupported request")
+			}
+		}
+	}
+}
+
+func TestGetFilepath(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{"file:///home/user/scripts.pory", "/home/user/scripts.pory"},
+		{"file:///home/user/my%20scripts.pory", "/home/user/my scripts.pory"},
+		{"untitled:Untitled-1", "untitled:Untitled-1"},
+	}
+	for _, test := range tests {
+		if result := getFilepath(test.uri); result != test.expected {
+			t.Errorf("Incorrect filepath for '%s'. Expected '%s', got '%s'", test.uri, test.expected, result)
+		}
+	}
+}
@@ -1,17 +1,36 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/decompiler"
+	"github.com/huderlem/poryscript/diff"
 	"github.com/huderlem/poryscript/emitter"
+	"github.com/huderlem/poryscript/formatter"
+	"github.com/huderlem/poryscript/ir"
 	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/lsp"
 	"github.com/huderlem/poryscript/parser"
+	"github.com/huderlem/poryscript/sarif"
+	"github.com/huderlem/poryscript/symbols"
+	"github.com/huderlem/poryscript/token"
 )
 
 const version = "2.10.0"
@@ -31,42 +50,488 @@ func (opt mapOption) Set(value string) error {
 	return nil
 }
 
+// An option that can be set multiple times. Each value is appended to the
+// list.
+type listOption []string
+
+func (opt *listOption) String() string {
+	return ""
+}
+
+func (opt *listOption) Set(value string) error {
+	*opt = append(*opt, value)
+	return nil
+}
+
 type options struct {
 	inputFilepath      string
 	outputFilepath     string
+	inputFilepaths     []string
+	outputFilepaths    []string
+	fontWidths         *parser.FontWidthsConfig
+	dataFilepath       string
+	globalFilepath     string
 	fontWidthsFilepath string
-	optimize           bool
+	optimizationLevel  emitter.OptimizationLevel
 	compileSwitches    map[string]string
+	paramVars          []string
+	nestingLimit       int
+	lexerMode          lexer.Mode
+	projectFilepaths   []string
+	diagnosticOptions  parser.DiagnosticOptions
+	lintConfig         *parser.LintConfig
+	autoEndConfig      *parser.AutoEndConfig
+	fixCallEnds        bool
+	commandSignatures  parser.CommandSignatures
+	dumpAST            bool
+	dumpTokens         bool
+	loadAST            bool
+	target             string
+	opcodeTable        *emitter.OpcodeTable
+	targetConfig       emitter.TargetConfig
+	labelFormat        ir.LabelFormat
+	symbolsFilepath    string
+	outputStyle        emitter.OutputStyle
+	sourceComments     bool
+	sourceMap          bool
+	dependencyFile     bool
+	lineDirectives     emitter.LineDirectiveFormat
+	tailCalls          bool
+	switchChains       bool
+	textOrder          emitter.TextOrder
+	textDirective      string
+	textLanguage       string
+	scriptLimits       emitter.ScriptLimits
+	watch              bool
+	batch              bool
+	check              bool
+	diff               bool
+	errorFormat        string
+	statsFormat        string
+	cpuProfileFilepath string
+	memProfileFilepath string
+	// The config files that were given by the options, which are watched
+	// for changes in watch mode.
+	configFilepaths []string
+	// Whether each output file mirrors a file of a directory or pattern -i,
+	// so that its missing directories are created when it's written.
+	mirroredOutputs []bool
+	createOutputDir bool
+}
+
+// The flags that configure how Poryscript files are parsed, which are shared
+// by the subcommands that parse them.
+type parserFlags struct {
+	fontWidthsFilepath *string
+	paramVars          *string
+	nestingLimit       *int
+	caseInsensitive    *bool
+	disabledWarnings   *string
+	warningsAsErrors   *bool
+	macros             *string
+	compileSwitches    mapOption
+}
+
+func addParserFlags(flags *flag.FlagSet) *parserFlags {
+	f := &parserFlags{
+		fontWidthsFilepath: flags.String("fw", "font_widths.json", "font widths config JSON file"),
+		paramVars:          flags.String("param-vars", strings.Join(parser.DefaultParamVars, ","), "comma-separated list of vars used to pass parameters to scripts"),
+		nestingLimit:       flags.Int("nesting-limit", parser.DefaultNestingLimit, "maximum depth of nested blocks and boolean expressions"),
+		caseInsensitive:    flags.Bool("case-insensitive-keywords", false, "accept keywords in any case, like 'IF' or 'If'"),
+		disabledWarnings:   flags.String("disable-warnings", "", fmt.Sprintf("comma-separated list of warning categories to disable (%s)", strings.Join(parser.WarningCategories, ", "))),
+		warningsAsErrors:   flags.Bool("Werror", false, "treat all warnings as errors"),
+		macros:             flags.String("macros", "", "comma-separated list of assembler files that define the script command macros, like asm/macros/event.inc"),
+		compileSwitches:    make(mapOption),
+	}
+	flags.Var(f.compileSwitches, "s", "set a compile-time switch. Multiple -s options can be set. Example: -s VERSION=RUBY -s LANGUAGE=GERMAN")
+	return f
+}
+
+func (f *parserFlags) getParamVars() []string {
+	return strings.Split(*f.paramVars, ",")
+}
+
+func (f *parserFlags) getLexerMode() lexer.Mode {
+	return getLexerMode(*f.caseInsensitive)
 }
 
-func parseOptions() options {
-	helpPtr := flag.Bool("h", false, "show poryscript help information")
-	versionPtr := flag.Bool("v", false, "show version of poryscript")
-	inputPtr := flag.String("i", "", "input poryscript file (leave empty to read from standard input)")
-	outputPtr := flag.String("o", "", "output script file (leave empty to write to standard output)")
-	fontsPtr := flag.String("fw", "font_widths.json", "font widths config JSON file")
-	optimizePtr := flag.Bool("optimize", true, "optimize compiled script size (To disable, use '-optimize=false')")
-	compileSwitches := make(mapOption)
-	flag.Var(compileSwitches, "s", "set a compile-time switch. Multiple -s options can be set. Example: -s VERSION=RUBY -s LANGUAGE=GERMAN")
-	flag.Parse()
+// Returns the diagnostic options of the flags. Exits if a disabled warning
+// category doesn't exist.
+func (f *parserFlags) getDiagnosticOptions() parser.DiagnosticOptions {
+	var disabledWarnings []string
+	if *f.disabledWarnings != "" {
+		disabledWarnings = strings.Split(*f.disabledWarnings, ",")
+	}
+	if err := parser.ValidateWarningCategories(disabledWarnings); err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	return parser.DiagnosticOptions{
+		DisabledWarnings: disabledWarnings,
+		WarningsAsErrors: *f.warningsAsErrors,
+	}
+}
+
+// Returns the command signatures of the -macros files. Exits if they can't
+// be loaded.
+func (f *parserFlags) getCommandSignatures() parser.CommandSignatures {
+	commandSignatures, err := loadCommandSignatures(*f.macros)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load command macros: %s\n", err.Error())
+	}
+	return commandSignatures
+}
 
-	if *helpPtr == true {
-		flag.Usage()
+// The flags of the compile and check subcommands.
+type compileFlags struct {
+	help               *bool
+	version            *bool
+	inputFilepaths     listOption
+	outputFilepaths    listOption
+	dataOutput         *string
+	globalOutput       *string
+	optimize           *bool
+	noOptimization     *bool
+	layoutOptimization *bool
+	fullOptimization   *bool
+	tailCalls          *bool
+	switchChains       *bool
+	lint               *string
+	autoEnd            *bool
+	lockCommands       *string
+	releaseCommands    *string
+	fixCallEnds        *bool
+	endCommand         *string
+	dumpAST            *bool
+	dumpTokens         *bool
+	loadAST            *bool
+	targetConfig       *string
+	opcodes            *string
+	labelFormat        *string
+	indent             *string
+	blankLines         *int
+	alignArgs          *bool
+	wrapText           *int
+	lineEndings        *string
+	sourceComments     *bool
+	dependencyFile     *bool
+	sourceMap          *bool
+	lineDirectives     *string
+	textDirective      *string
+	textLanguage       *string
+	maxScriptCommands  *int
+	maxScriptChunks    *int
+	textOrder          *string
+	symbols            *string
+	check              *bool
+	diff               *bool
+	errorFormat        *string
+	color              *string
+	stats              *string
+	cpuProfile         *string
+	memProfile         *string
+	watch              *bool
+	batch              *bool
+	target             *string
+	parserFlags        *parserFlags
+	verbosityFlags     *verbosityFlags
+}
+
+// Defines the flags of the compile and check subcommands on the flag set.
+func addCompileFlags(flags *flag.FlagSet) *compileFlags {
+	f := &compileFlags{
+		help:               flags.Bool("h", false, "show poryscript help information"),
+		version:            flags.Bool("v", false, "show version of poryscript"),
+		dataOutput:         flags.String("data-o", "", "additionally write the compiled texts, movements, marts, and data to this file, instead of the output script file"),
+		globalOutput:       flags.String("global-o", "", "additionally write the compiled global scripts, texts, movements, marts, data, and mapscripts to this file, instead of the output script file"),
+		optimize:           flags.Bool("optimize", true, "optimize compiled script size, like -O2 (To disable, use '-optimize=false')"),
+		noOptimization:     flags.Bool("O0", false, "don't optimize the compiled scripts, so that each chunk has its own label and conditions are checked as they are written"),
+		layoutOptimization: flags.Bool("O1", false, "only optimize the layout of the compiled scripts' chunks, and check conditions as they are written"),
+		fullOptimization:   flags.Bool("O2", false, "optimize the layout of the compiled scripts' chunks, and simplify their conditions (the default)"),
+		tailCalls:          flags.Bool("tail-calls", false, "replace a call right before a script returns with a goto, so it doesn't use a level of the call stack"),
+		switchChains:       flags.Bool("switch-chains", false, "with -O2, emit an if statement whose conditions all compare the same var with '==' like a switch statement, which overwrites VAR_0x8000"),
+		lint:               flags.String("lint", "", "lint rules config JSON file (leave empty to disable linting)"),
+		autoEnd:            flags.Bool("auto-end", false, "end the scripts that fall off the end of their body, and release them first if they can still be locked"),
+		lockCommands:       flags.String("lock-commands", strings.Join(parser.DefaultAutoEndConfig.LockCommands, ","), "comma-separated list of the commands that lock, for -auto-end"),
+		releaseCommands:    flags.String("release-commands", strings.Join(parser.DefaultAutoEndConfig.ReleaseCommands, ","), "comma-separated list of the commands that release, for -auto-end. The first one is added to scripts"),
+		fixCallEnds:        flags.Bool("fix-call-ends", false, "replace 'end' with 'return' in scripts that are called by other scripts, instead of warning about it"),
+		endCommand:         flags.String("end-command", parser.DefaultAutoEndConfig.EndCommand, "command that is added to the scripts, for -auto-end"),
+		dumpAST:            flags.Bool("dump-ast", false, "write the parsed AST as JSON, instead of the compiled script"),
+		dumpTokens:         flags.Bool("dump-tokens", false, "write the lexer's tokens, instead of the compiled script"),
+		loadAST:            flags.Bool("load-ast", false, "read the input as a JSON AST that was written by -dump-ast, instead of a Poryscript file"),
+		targetConfig:       flags.String("target-config", "", "target config JSON file, with the directives that are emitted around each kind of statement"),
+		opcodes:            flags.String("opcodes", "", "opcode table JSON file of the bin target (leave empty to use the default table)"),
+		labelFormat:        flags.String("label-format", "{script}_{n}", "naming scheme of the generated labels of script chunks. '{n:3}' pads the number to 3 digits"),
+		indent:             flags.String("indent", "tab", "indentation of the compiled script's commands. Either 'tab', or a number of spaces"),
+		blankLines:         flags.Int("blank-lines", emitter.DefaultOutputStyle.BlankLines, "number of newlines between the compiled script's top-level statements"),
+		alignArgs:          flags.Bool("align-args", false, "align the arguments of the compiled script's commands in a column"),
+		wrapText:           flags.Int("wrap-text", 0, "wrap the compiled script's lines of text, like '.string', that are longer than this column (leave 0 to never wrap them)"),
+		lineEndings:        flags.String("line-endings", "lf", "line endings of the compiled script (lf, crlf)"),
+		sourceComments:     flags.Bool("source-comments", false, "precede each chunk of the compiled script's commands with a comment of its location in the Poryscript source"),
+		dependencyFile:     flags.Bool("MD", false, "additionally write a Make dependency file, which lists the input file, its imports, and the config files as prerequisites of the output file, next to the output file with the '.d' extension"),
+		sourceMap:          flags.Bool("source-map", false, "additionally write a JSON source map, which maps the lines of the compiled script to the Poryscript source, next to the output file with the '.map' extension"),
+		lineDirectives:     flags.String("line-directives", "none", "precede each chunk of the compiled script's commands with a directive that makes the assembler report errors at the Poryscript source (none, line, gas)"),
+		textDirective:      flags.String("text-directive", "string", "assembler directive that texts are emitted with, like 'string' or a project's own macro"),
+		textLanguage:       flags.String("text-language", "", "language argument of the text directive, like 'JAPANESE' (leave empty for no argument)"),
+		maxScriptCommands:  flags.Int("max-script-commands", 0, "warn about scripts that are compiled into more than this many commands (leave 0 for no limit)"),
+		maxScriptChunks:    flags.Int("max-script-chunks", 0, "warn about scripts that are compiled into more than this many chunks of commands (leave 0 for no limit)"),
+		textOrder:          flags.String("text-order", "inline-first", "order of the compiled script's texts (inline-first, source, first-use, alphabetical)"),
+		symbols:            flags.String("symbols", "", "additionally write the emitted labels to a symbol file. The file is JSON if its name ends with '.json'"),
+		check:              flags.Bool("check", false, "parse and compile the input without writing any output, and exit with a nonzero status if there are any errors, including warnings that are treated as errors"),
+		diff:               flags.Bool("diff", false, "print a unified diff of the compiled output against the existing output files, instead of writing them, and exit with a nonzero status if they differ"),
+		errorFormat:        flags.String("error-format", textErrorFormat, "format of the printed errors and warnings (text, json)"),
+		color:              flags.String("color", "auto", "color the printed errors and warnings (never, always, auto). 'auto' colors them when standard error is a terminal, unless the NO_COLOR environment variable is set"),
+		stats:              flags.String("stats", "", "print the statistics of each compiled file, like its numbers of scripts, commands, and texts, in the given format (text, json)"),
+		cpuProfile:         flags.String("cpuprofile", "", "write a CPU profile of the compilation to this file, which can be read with 'go tool pprof'"),
+		memProfile:         flags.String("memprofile", "", "write a memory profile of the compilation to this file, which can be read with 'go tool pprof'"),
+		watch:              flags.Bool("watch", false, "compile again whenever an input file, one of its imports, or a config file changes, until interrupted"),
+		batch:              flags.Bool("batch", false, "read the files to compile from standard input, one 'input:output' pair per line, like multiple -i and -o options"),
+		target:             flags.String("target", emitter.DefaultBackend, fmt.Sprintf("output format of the compiled script (%s)", strings.Join(emitter.BackendNames(), ", "))),
+		parserFlags:        addParserFlags(flags),
+		verbosityFlags:     addVerbosityFlags(flags),
+	}
+	flags.Var(&f.inputFilepaths, "i", "input poryscript file, directory, or glob pattern like 'data/scripts/**/*.pory' (leave empty to read from standard input). Multiple -i options can be set, each with its own -o")
+	flags.Var(&f.outputFilepaths, "o", "output script file, or output directory of a directory or glob pattern -i (leave empty to write to standard output)")
+	return f
+}
+
+// Parses the flags of the compile and check subcommands.
+func parseOptions(name string, args []string) options {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() { printUsage(flags) }
+	f := addCompileFlags(flags)
+	parseFlags(flags, args, nil)
+
+	if *f.help == true {
+		flags.Usage()
 		os.Exit(0)
 	}
 
-	if *versionPtr == true {
+	if *f.version == true {
 		fmt.Printf("%s\n", version)
 		os.Exit(0)
 	}
+	f.verbosityFlags.apply()
+	setColor(*f.color, isTerminal(os.Stderr))
+
+	backend, err := emitter.NewBackend(*f.target)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	if _, ok := backend.(emitter.TailCallBackend); *f.tailCalls && !ok {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -tail-calls isn't supported by the '%s' target\n", *f.target)
+	}
+
+	if *f.dataOutput != "" && *f.globalOutput != "" {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -data-o and -global-o can't be used together\n")
+	}
+
+	var opcodeTable *emitter.OpcodeTable
+	if *f.opcodes != "" {
+		if *f.target != "bin" {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: -opcodes can only be used with -target bin\n")
+		}
+		table, err := emitter.LoadOpcodeTable(*f.opcodes)
+		if err != nil {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load opcode table: %s\n", err.Error())
+		}
+		opcodeTable = &table
+	}
+
+	var targetConfig emitter.TargetConfig
+	if *f.targetConfig != "" {
+		if targetConfig, err = emitter.LoadTargetConfig(*f.targetConfig); err != nil {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load target config: %s\n", err.Error())
+		}
+	}
+
+	labelFormat, err := ir.ParseLabelFormat(*f.labelFormat)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+
+	indent, err := emitter.ParseIndent(*f.indent)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	lineEnding, err := emitter.ParseLineEnding(*f.lineEndings)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	textOrder, err := emitter.ParseTextOrder(*f.textOrder)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	lineDirectives, err := emitter.ParseLineDirectiveFormat(*f.lineDirectives)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	if *f.blankLines < 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -blank-lines can't be negative\n")
+	}
+	if *f.errorFormat != textErrorFormat && *f.errorFormat != jsonErrorFormat {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: unknown error format '%s'. Expected 'text' or 'json'\n", *f.errorFormat)
+	}
+	if *f.stats != "" && *f.stats != "text" && *f.stats != "json" {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: unknown stats format '%s'. Expected 'text' or 'json'\n", *f.stats)
+	}
+	if *f.wrapText < 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -wrap-text can't be negative\n")
+	}
+	if *f.maxScriptCommands < 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -max-script-commands can't be negative\n")
+	}
+	if *f.maxScriptChunks < 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -max-script-chunks can't be negative\n")
+	}
+
+	var lintConfig *parser.LintConfig
+	if *f.lint != "" {
+		config, err := parser.LoadLintConfig(*f.lint)
+		if err != nil {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load lint config: %s\n", err.Error())
+		}
+		lintConfig = &config
+	}
+
+	var autoEndConfig *parser.AutoEndConfig
+	if *f.autoEnd {
+		autoEndConfig = &parser.AutoEndConfig{
+			LockCommands:    splitCommandList(*f.lockCommands),
+			ReleaseCommands: splitCommandList(*f.releaseCommands),
+			EndCommand:      *f.endCommand,
+		}
+	}
+
+	configFilepaths := []string{projectConfigFilepath, *f.parserFlags.fontWidthsFilepath, *f.lint, *f.targetConfig, *f.opcodes}
+	if *f.parserFlags.macros != "" {
+		configFilepaths = append(configFilepaths, strings.Split(*f.parserFlags.macros, ",")...)
+	}
+	logConfigFilepaths(configFilepaths)
+
+	var inputFilepath, outputFilepath string
+	if len(f.inputFilepaths) > 0 {
+		inputFilepath = f.inputFilepaths[0]
+	}
+	if len(f.outputFilepaths) > 0 {
+		outputFilepath = f.outputFilepaths[0]
+	}
 
 	return options{
-		inputFilepath:      *inputPtr,
-		outputFilepath:     *outputPtr,
-		fontWidthsFilepath: *fontsPtr,
-		optimize:           *optimizePtr,
-		compileSwitches:    compileSwitches,
+		inputFilepath:      inputFilepath,
+		outputFilepath:     outputFilepath,
+		inputFilepaths:     f.inputFilepaths,
+		outputFilepaths:    f.outputFilepaths,
+		dataFilepath:       *f.dataOutput,
+		globalFilepath:     *f.globalOutput,
+		fontWidthsFilepath: *f.parserFlags.fontWidthsFilepath,
+		optimizationLevel:  getOptimizationLevel(*f.optimize, *f.noOptimization, *f.layoutOptimization, *f.fullOptimization),
+		compileSwitches:    f.parserFlags.compileSwitches,
+		paramVars:          f.parserFlags.getParamVars(),
+		nestingLimit:       *f.parserFlags.nestingLimit,
+		lexerMode:          f.parserFlags.getLexerMode(),
+		projectFilepaths:   flags.Args(),
+		diagnosticOptions:  f.parserFlags.getDiagnosticOptions(),
+		lintConfig:         lintConfig,
+		autoEndConfig:      autoEndConfig,
+		fixCallEnds:        *f.fixCallEnds,
+		commandSignatures:  f.parserFlags.getCommandSignatures(),
+		dumpAST:            *f.dumpAST,
+		dumpTokens:         *f.dumpTokens,
+		loadAST:            *f.loadAST,
+		target:             *f.target,
+		opcodeTable:        opcodeTable,
+		targetConfig:       targetConfig,
+		labelFormat:        labelFormat,
+		symbolsFilepath:    *f.symbols,
+		outputStyle: emitter.OutputStyle{
+			Indent:     indent,
+			BlankLines: *f.blankLines,
+			AlignArgs:  *f.alignArgs,
+			LineEnding: lineEnding,
+			WrapColumn: *f.wrapText,
+		},
+		sourceComments: *f.sourceComments,
+		sourceMap:      *f.sourceMap,
+		dependencyFile: *f.dependencyFile,
+		lineDirectives: lineDirectives,
+		tailCalls:      *f.tailCalls,
+		switchChains:   *f.switchChains,
+		textOrder:      textOrder,
+		textDirective:  *f.textDirective,
+		textLanguage:   *f.textLanguage,
+		scriptLimits: emitter.ScriptLimits{
+			MaxCommands: *f.maxScriptCommands,
+			MaxChunks:   *f.maxScriptChunks,
+		},
+		watch:              *f.watch,
+		batch:              *f.batch,
+		check:              *f.check,
+		diff:               *f.diff,
+		errorFormat:        *f.errorFormat,
+		statsFormat:        *f.stats,
+		cpuProfileFilepath: *f.cpuProfile,
+		memProfileFilepath: *f.memProfile,
+		configFilepaths:    configFilepaths,
+	}
+}
+
+// Returns the optimization level of the -O0, -O1, and -O2 flags. Without
+// them, -optimize chooses between no optimization and full optimization.
+func getOptimizationLevel(optimize bool, noOptimization bool, layoutOptimization bool, fullOptimization bool) emitter.OptimizationLevel {
+	count := 0
+	level := emitter.FullOptimization
+	for _, option := range []struct {
+		given bool
+		level emitter.OptimizationLevel
+	}{
+		{noOptimization, emitter.NoOptimization},
+		{layoutOptimization, emitter.LayoutOptimization},
+		{fullOptimization, emitter.FullOptimization},
+	} {
+		if option.given {
+			count++
+			level = option.level
+		}
+	}
+	if count > 1 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: only one of -O0, -O1, and -O2 can be used\n")
+	}
+	if !optimize {
+		if count > 0 && level != emitter.NoOptimization {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: -optimize=false cannot be used with -O1 or -O2\n")
+		}
+		return emitter.NoOptimization
+	}
+	return level
+}
+
+// Splits a comma-separated list of command names. An empty list has no
+// commands.
+func splitCommandList(value string) []string {
+	var commands []string
+	for _, command := range strings.Split(value, ",") {
+		if command = strings.TrimSpace(command); command != "" {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// Reads the command signatures from a comma-separated list of macro files.
+// The signatures are nil when no files are given.
+func loadCommandSignatures(filepaths string) (parser.CommandSignatures, error) {
+	if filepaths == "" {
+		return nil, nil
 	}
+	return parser.LoadCommandSignatures(strings.Split(filepaths, ","))
+}
+
+// Returns the lexer modes for the given options.
+func getLexerMode(caseInsensitiveKeywords bool) lexer.Mode {
+	if caseInsensitiveKeywords {
+		return lexer.CaseInsensitiveKeywords
+	}
+	return 0
 }
 
 func getInput(filepath string) (string, error) {
@@ -81,44 +546,2077 @@ func getInput(filepath string) (string, error) {
 	return string(bytes), err
 }
 
-func writeOutput(output string, filepath string) error {
-	if filepath == "" {
+// Writes the output to the file, or to standard output if the filepath is
+// empty.
+func writeOutput(output string, outputFilepath string) error {
+	if outputFilepath == "" {
 		fmt.Print(output)
 	} else {
-		f, err := os.Create(filepath)
+		f, err := os.Create(outputFilepath)
 		if err != nil {
 			return err
 		}
+		defer f.Close()
 
 		_, err = io.WriteString(f, output)
 		if err != nil {
 			return err
 		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		logf(infoLevel, "PORYSCRIPT: wrote %s\n", outputFilepath)
 	}
 	return nil
 }
 
-func main() {
-	log.SetFlags(0)
-	options := parseOptions()
-	input, err := getInput(options.inputFilepath)
-	if err != nil {
-		log.Fatalf("PORYSCRIPT ERROR: %s\n", err.Error())
+// Creates the missing directories of the output file of a file that was
+// matched by a directory or pattern -i, whose output directory mirrors the
+// directories of the matched files. The directories of the other output
+// files must already exist.
+func createOutputDir(options options) error {
+	if !options.createOutputDir || options.outputFilepath == "" || options.check || options.diff {
+		return nil
+	}
+	return os.MkdirAll(filepath.Dir(options.outputFilepath), 0755)
+}
+
+// Writes compiled output, unless the options only check that the input
+// compiles, or diff the output against the existing files.
+func writeCompiledOutput(output string, outputFilepath string, options options) error {
+	if options.check {
+		return nil
+	}
+	if options.diff {
+		return printOutputDiff(output, outputFilepath)
+	}
+	return writeOutput(output, outputFilepath)
+}
+
+// Whether any of the compiled outputs differ from their existing files, when
+// they are diffed instead of written.
+var outputsDiffer bool
+
+// Prints the differences between the existing output file and the compiled
+// output. A missing file is diffed as an empty file.
+func printOutputDiff(output string, outputFilepath string) error {
+	bytes, err := ioutil.ReadFile(outputFilepath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if result := diff.Unified(outputFilepath, outputFilepath, string(bytes), output); result != "" {
+		fmt.Print(result)
+		outputsDiffer = true
+	}
+	return nil
+}
+
+// Exit codes, which let build scripts tell problems in the scripts apart
+// from problems with how Poryscript is run.
+const (
+	// Any other failure, like output files that differ from their compiled
+	// output with -diff.
+	exitFailure = 1
+	// Invalid flags, or config files that can't be loaded. The flag package
+	// exits with the same code when it can't parse the flags.
+	exitUsage = 2
+	// Lexical or syntax errors in the scripts.
+	exitParseError = 3
+	// Errors in well-formed scripts, like undefined or duplicate labels, and
+	// warnings that are treated as errors.
+	exitSemanticError = 4
+	// Input files that can't be read, or output files that can't be written.
+	exitIOError = 5
+	// Scripts that can't be emitted for the target, like ones that use
+	// commands that aren't in the bin target's opcode table.
+	exitEmitError = 6
+)
+
+// Prints an error, and exits with the given exit code.
+func fatalf(code int, format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// Returns the exit code of an error that was returned by the parser or the
+// emitter.
+func getErrorExitCode(err error) int {
+	var pathErr *os.PathError
+	switch {
+	case errors.Is(err, parser.ErrLex), errors.Is(err, parser.ErrSyntax):
+		return exitParseError
+	case errors.Is(err, parser.ErrSemantic):
+		return exitSemanticError
+	case errors.As(err, &pathErr):
+		return exitIOError
+	case errors.Is(err, emitter.ErrEmit):
+		return exitEmitError
+	}
+	return exitFailure
+}
+
+// The levels of the messages that are printed to standard error, from the
+// most important to the most verbose. Standard output is left for the
+// compiled output.
+type logLevel int
+
+const (
+	// Errors, which are always printed.
+	errorLevel logLevel = iota
+	// Warnings and status messages, which -q hides.
+	warningLevel
+	// The files that are read and written, and the config files that are
+	// used, which -verbose shows.
+	infoLevel
+	// The time that each pass takes, which -very-verbose shows.
+	debugLevel
+)
+
+// The most verbose level of the messages that are printed.
+var verbosity = warningLevel
+
+// Prints a message, if its level is printed.
+func logf(level logLevel, format string, args ...interface{}) {
+	if level <= verbosity {
+		log.Printf(format, args...)
+	}
+}
+
+// Prints the time that a pass over a file took, which started at start.
+func logPassTime(pass string, filepath string, start time.Time) {
+	logPassDuration(pass, filepath, time.Since(start))
+}
+
+// Prints the time that a pass over a file took, when it was measured earlier.
+func logPassDuration(pass string, filepath string, duration time.Duration) {
+	if filepath == "" {
+		filepath = "standard input"
+	}
+	logf(debugLevel, "PORYSCRIPT: %s %s in %s\n", pass, filepath, duration)
+}
+
+// Prints the config files that exist, which are used. The default font
+// widths config, for example, doesn't need to exist.
+func logConfigFilepaths(configFilepaths []string) {
+	for _, configFilepath := range configFilepaths {
+		configFilepath = strings.TrimSpace(configFilepath)
+		if _, err := os.Stat(configFilepath); configFilepath != "" && err == nil {
+			logf(infoLevel, "PORYSCRIPT: using config file %s\n", configFilepath)
+		}
+	}
+}
+
+// The flags that choose the verbosity, which are shared by the subcommands.
+type verbosityFlags struct {
+	verbose     *bool
+	veryVerbose *bool
+	quiet       *bool
+}
+
+func addVerbosityFlags(flags *flag.FlagSet) *verbosityFlags {
+	return &verbosityFlags{
+		verbose:     flags.Bool("verbose", false, "print the files that are read and written, and the config files that are used, to standard error"),
+		veryVerbose: flags.Bool("very-verbose", false, "print the time that each pass takes, too, like -verbose"),
+		quiet:       flags.Bool("q", false, "only print errors to standard error, without warnings or status messages"),
+	}
+}
+
+// Sets the verbosity of the flags. Exits if -q is used with -verbose or -very-verbose.
+func (f *verbosityFlags) apply() {
+	if *f.quiet && (*f.verbose || *f.veryVerbose) {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -q cannot be used with -verbose or -very-verbose\n")
+	}
+	switch {
+	case *f.quiet:
+		verbosity = errorLevel
+	case *f.veryVerbose:
+		verbosity = debugLevel
+	case *f.verbose:
+		verbosity = infoLevel
+	}
+}
+
+// Whether the diagnostics are printed with colors.
+var useColor bool
+
+// Chooses whether the diagnostics are printed with colors, from the value of
+// a -color flag. With "auto", they are if they are printed to a terminal, and
+// the NO_COLOR environment variable isn't set.
+func setColor(value string, terminal bool) {
+	switch value {
+	case "always":
+		useColor = true
+	case "never":
+		useColor = false
+	case "auto":
+		useColor = terminal && os.Getenv("NO_COLOR") == ""
+	default:
+		fatalf(exitUsage, "PORYSCRIPT ERROR: unknown color mode '%s'. Expected 'never', 'always', or 'auto'\n", value)
+	}
+}
+
+// Whether the file is a terminal, rather than a regular file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func exitIfOutputsDiffer() {
+	if outputsDiffer {
+		os.Exit(exitFailure)
+	}
+}
+
+// The formats that diagnostics can be printed in.
+const (
+	textErrorFormat = "text"
+	// One JSON object per line, for tools that read the diagnostics.
+	jsonErrorFormat = "json"
+)
+
+// Prints the diagnostics in the given format. In the text format, each one
+// is followed by an excerpt of the source that marks its location. sources
+// holds the contents of files by filepath, and other files are read as
+// needed.
+func printDiagnostics(diagnostics []parser.Diagnostic, sources map[string]string, format string) {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity != parser.SeverityError && verbosity < warningLevel {
+			continue
+		}
+		if format == jsonErrorFormat {
+			bytes, err := json.Marshal(diagnostic.ToJSON(getSource(sources, diagnostic.Filepath)))
+			if err != nil {
+				fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", err.Error())
+			}
+			log.Println(string(bytes))
+			continue
+		}
+		message := diagnostic.String()
+		excerpt := diagnostic.Excerpt(getSource(sources, diagnostic.Filepath))
+		if useColor {
+			excerpt = diagnostic.ColorExcerpt(getSource(sources, diagnostic.Filepath))
+		}
+		if excerpt != "" {
+			message += "\n" + excerpt
+		}
+		prefix := "PORYSCRIPT WARNING:"
+		if diagnostic.Severity == parser.SeverityError {
+			prefix = "PORYSCRIPT ERROR:"
+		}
+		if useColor {
+			prefix = diagnostic.Severity.Colorize(prefix)
+		}
+		log.Printf("%s %s\n", prefix, message)
 	}
+}
+
+// Prints the error returned by the parser, along with an excerpt of the
+// source that marks its location, and exits. In the JSON format, errors that
+// only count the diagnostics with error severity aren't printed, since each
+// of those diagnostics was already printed.
+func fatalParseError(err error, sources map[string]string, format string) {
+	if format != jsonErrorFormat || !parser.IsDiagnosticsError(err) {
+		printDiagnostics([]parser.Diagnostic{parser.NewErrorDiagnostic(err)}, sources, format)
+	}
+	os.Exit(getErrorExitCode(err))
+}
+
+// Returns the contents of the file, or an empty string if it can't be read.
+func getSource(sources map[string]string, filepath string) string {
+	source, ok := sources[filepath]
+	if !ok {
+		bytes, _ := ioutil.ReadFile(filepath)
+		source = string(bytes)
+		sources[filepath] = source
+	}
+	return source
+}
+
+// Returns the sources of a single-file compilation. Its diagnostics refer
+// to the input file by its filepath.
+func getInputSources(input string, options options) map[string]string {
+	return map[string]string{options.inputFilepath: input}
+}
+
+// Returns the diagnostics of a single-file compilation. Warnings aren't
+// located in a file by the parser, so they're located in the input file,
+// like its errors are.
+func getFileDiagnostics(diagnostics []parser.Diagnostic, options options) []parser.Diagnostic {
+	for i := range diagnostics {
+		if diagnostics[i].Filepath == "" {
+			diagnostics[i].Filepath = options.inputFilepath
+		}
+	}
+	return diagnostics
+}
 
-	parser := parser.New(lexer.New(input), options.fontWidthsFilepath, options.compileSwitches)
+// Parses the input, and returns its imports and diagnostics, too.
+func parseProgram(input string, options options) (*ast.Program, []string, []parser.Diagnostic, error) {
+	parser := parser.New(lexer.NewWithMode(input, options.lexerMode), options.fontWidthsFilepath, options.compileSwitches)
+	parser.SetParamVars(options.paramVars)
+	parser.SetNestingLimit(options.nestingLimit)
+	parser.SetFilepath(options.inputFilepath)
+	parser.SetDiagnosticOptions(options.diagnosticOptions)
+	parser.SetLintConfig(options.lintConfig)
+	parser.SetAutoEnd(options.autoEndConfig)
+	parser.SetFixCallEnds(options.fixCallEnds)
+	parser.SetCommandSignatures(options.commandSignatures)
+	if options.fontWidths != nil {
+		parser.SetFontWidths(options.fontWidths)
+	}
 	program, err := parser.ParseProgram()
-	if err != nil {
-		log.Fatalf("PORYSCRIPT ERROR: %s\n", err.Error())
+	return program, parser.Imports(), getFileDiagnostics(parser.Diagnostics(), options), err
+}
+
+// Returns the tokens of the input, one per line, with their positions.
+func dumpTokens(input string, mode lexer.Mode) string {
+	var sb strings.Builder
+	l := lexer.NewWithMode(input, mode)
+	for {
+		tok := l.NextToken()
+		sb.WriteString(fmt.Sprintf("%d:%d\t%s\t%q\n", tok.LineNumber, tok.Column, tok.Type, tok.Literal))
+		if tok.Type == token.EOF {
+			break
+		}
 	}
+	return sb.String()
+}
 
-	emitter := emitter.New(program, options.optimize)
-	result, err := emitter.Emit()
-	if err != nil {
-		log.Fatalf("PORYSCRIPT ERROR: %s\n", err.Error())
+// Returns the output filepath for a project file. The output is written
+// next to the input file, with the ".inc" extension.
+func getProjectOutputFilepath(inputFilepath string) string {
+	return strings.TrimSuffix(inputFilepath, filepath.Ext(inputFilepath)) + ".inc"
+}
+
+// Expands the -i options that are a directory, or a glob pattern like
+// "data/scripts/**/*.pory", into every file that they match. A directory
+// matches all of the ".pory" files inside of it, and "**" matches any number
+// of directories. The -o of an expanded -i is an output directory, which
+// mirrors the directories of the matched files below the -i's directory, or
+// the part of the pattern before its first wildcard. The returned bools tell
+// which outputs mirror matched files, since their missing directories are
+// created when they're written.
+func expandInputFilepaths(inputFilepaths []string, outputFilepaths []string) ([]string, []string, []bool, error) {
+	var inputs, outputs []string
+	var mirrored []bool
+	for i, input := range inputFilepaths {
+		baseDir, pattern, ok := getInputPattern(input)
+		if !ok {
+			inputs = append(inputs, input)
+			if i < len(outputFilepaths) {
+				outputs = append(outputs, outputFilepaths[i])
+				mirrored = append(mirrored, false)
+			}
+			continue
+		}
+		if i >= len(outputFilepaths) || outputFilepaths[i] == "" {
+			return nil, nil, nil, fmt.Errorf("-i '%s' is a directory or a pattern, so it needs an -o output directory", input)
+		}
+		matches, err := findMatchingFiles(baseDir, pattern)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(matches) == 0 {
+			return nil, nil, nil, fmt.Errorf("no files match -i '%s'", input)
+		}
+		for _, match := range matches {
+			inputs = append(inputs, filepath.Join(baseDir, match))
+			outputs = append(outputs, getProjectOutputFilepath(filepath.Join(outputFilepaths[i], match)))
+			mirrored = append(mirrored, true)
+		}
 	}
-	err = writeOutput(result, options.outputFilepath)
+	for i := len(inputFilepaths); i < len(outputFilepaths); i++ {
+		outputs = append(outputs, outputFilepaths[i])
+		mirrored = append(mirrored, false)
+	}
+	return inputs, outputs, mirrored, nil
+}
+
+// Reads the input and output files of -batch, which are listed one pair per
+// line, like "data/scripts/foo.pory:data/scripts/foo.inc". Blank lines are
+// skipped. The colon of a Windows drive, like "C:\", doesn't separate the
+// files.
+func readBatchFilepaths(r io.Reader) ([]string, []string, error) {
+	bytes, err := ioutil.ReadAll(r)
 	if err != nil {
-		log.Fatalf("PORYSCRIPT ERROR: %s\n", err.Error())
+		return nil, nil, err
+	}
+	var inputs, outputs []string
+	for i, line := range strings.Split(string(bytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		input, output, ok := splitBatchLine(line)
+		if !ok {
+			return nil, nil, fmt.Errorf("line %d: expected an 'input:output' pair, but got '%s'", i+1, line)
+		}
+		inputs = append(inputs, input)
+		outputs = append(outputs, output)
+	}
+	if len(inputs) == 0 {
+		return nil, nil, errors.New("no files were given")
+	}
+	return inputs, outputs, nil
+}
+
+// Splits a line of -batch at the colon between its input and output file.
+func splitBatchLine(line string) (string, string, bool) {
+	for i := 0; i < len(line); i++ {
+		if line[i] != ':' || isDriveColon(line, i) {
+			continue
+		}
+		input, output := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		return input, output, input != "" && output != ""
+	}
+	return "", "", false
+}
+
+// Reports whether the colon at the index belongs to the Windows drive of the
+// input file, like "C:\". The separating colon comes before the drive of the
+// output file.
+func isDriveColon(line string, i int) bool {
+	if i != 1 || i+1 >= len(line) || (line[i+1] != '\\' && line[i+1] != '/') {
+		return false
+	}
+	c := line[0]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// Splits an -i into the directory that its files are searched in, and the
+// components of the pattern that their paths below the directory must match.
+// Returns false if the -i is a single file.
+func getInputPattern(input string) (string, []string, bool) {
+	if info, err := os.Stat(input); err == nil && info.IsDir() {
+		return input, []string{"**", "*.pory"}, true
+	}
+	components := strings.Split(filepath.ToSlash(input), "/")
+	for i, component := range components {
+		if strings.ContainsAny(component, "*?[") {
+			baseDir := filepath.FromSlash(strings.Join(components[:i], "/"))
+			if i == 0 {
+				baseDir = "."
+			} else if baseDir == "" {
+				baseDir = string(filepath.Separator)
+			}
+			return baseDir, components[i:], true
+		}
+	}
+	return "", nil, false
+}
+
+// Returns the paths of the files below the directory that match the pattern,
+// relative to the directory, in lexical order.
+func findMatchingFiles(dir string, pattern []string) ([]string, error) {
+	for _, component := range pattern {
+		if _, err := filepath.Match(component, ""); err != nil {
+			return nil, fmt.Errorf("invalid -i pattern '%s'", strings.Join(pattern, "/"))
+		}
+	}
+	var matches []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if matchPathPattern(pattern, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, rel)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// Reports whether the components of a path match the components of a
+// pattern. "**" matches any number of components, and the other components
+// are matched with filepath.Match.
+func matchPathPattern(pattern []string, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchPathPattern(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+		return false
+	}
+	return matchPathPattern(pattern[1:], path[1:])
+}
+
+// The result of compiling a program.
+type emittedProgram struct {
+	output      string
+	symbols     []emitter.Symbol
+	diagnostics []parser.Diagnostic
+	stats       emitter.Stats
+	sourceMap   []emitter.SourceMapping
+}
+
+// Compiles a program with the backend of the target that was chosen by the
+// options. The data or the global statements are written to their own file,
+// if they were asked for. Returns the labels that were emitted, the
+// emitter's warnings, and the source map, too.
+func emitProgram(program *ast.Program, options options, outputFilepath string) (emittedProgram, error) {
+	var backend emitter.Backend
+	if options.opcodeTable != nil {
+		backend = emitter.NewBytecodeBackend(*options.opcodeTable)
+	} else {
+		var err error
+		if backend, err = emitter.NewBackend(options.target); err != nil {
+			return emittedProgram{}, err
+		}
+	}
+	e := emitter.NewWithBackend(program, true, backend)
+	e.SetOptimizationLevel(options.optimizationLevel)
+	e.SetLabelFormat(options.labelFormat)
+	e.SetOutputStyle(options.outputStyle)
+	e.SetSourceComments(options.sourceComments)
+	e.SetSourceMap(options.sourceMap)
+	e.SetLineDirectives(options.lineDirectives, outputFilepath)
+	e.SetTailCalls(options.tailCalls)
+	e.SetSwitchChains(options.switchChains)
+	e.SetTextOrder(options.textOrder)
+	e.SetTextDirective(options.textDirective, options.textLanguage)
+	e.SetTargetConfig(options.targetConfig)
+	e.SetScriptLimits(options.scriptLimits)
+	e.SetDiagnosticOptions(options.diagnosticOptions)
+	e.SetStats(options.statsFormat != "")
+	var output string
+	var err error
+	if options.dataFilepath != "" {
+		var data string
+		if output, data, err = e.EmitSplit(); err != nil {
+			return emittedProgram{}, err
+		}
+		if err := writeCompiledOutput(data, options.dataFilepath, options); err != nil {
+			return emittedProgram{}, err
+		}
+	} else if options.globalFilepath != "" {
+		var global string
+		if output, global, err = e.EmitSplitByScope(); err != nil {
+			return emittedProgram{}, err
+		}
+		if err := writeCompiledOutput(global, options.globalFilepath, options); err != nil {
+			return emittedProgram{}, err
+		}
+	} else if output, err = e.Emit(); err != nil {
+		return emittedProgram{}, err
+	}
+	return emittedProgram{output: output, symbols: e.Symbols(), diagnostics: e.Diagnostics(), stats: e.Stats(), sourceMap: e.SourceMap()}, nil
+}
+
+// Writes the source map of a compiled program next to its output file, if
+// it was asked for.
+func writeProgramSourceMap(result emittedProgram, outputFilepath string, options options) error {
+	if !options.sourceMap || options.check || options.diff {
+		return nil
+	}
+	return writeSourceMap(result.sourceMap, outputFilepath)
+}
+
+// The statistics of a compiled file, when they are printed as JSON.
+type fileStats struct {
+	File string `json:"file"`
+	emitter.Stats
+}
+
+// Prints the statistics of a compiled file in the given format, which is
+// "text" or "json". The text format lists them under the file's name.
+func printStats(filepath string, stats emitter.Stats, format string) {
+	if format == "json" {
+		bytes, err := json.Marshal(fileStats{File: filepath, Stats: stats})
+		if err != nil {
+			fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		log.Println(string(bytes))
+		return
+	}
+	if filepath == "" {
+		filepath = "standard input"
+	}
+	savings := func(saved int) string {
+		if saved == 0 {
+			return ""
+		}
+		return fmt.Sprintf(" (optimization saved %d)", saved)
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("PORYSCRIPT STATS: %s\n", filepath))
+	sb.WriteString(fmt.Sprintf("  scripts:  %d\n", stats.Scripts))
+	sb.WriteString(fmt.Sprintf("  commands: %d%s\n", stats.Commands, savings(stats.SavedCommands)))
+	sb.WriteString(fmt.Sprintf("  chunks:   %d%s\n", stats.Chunks, savings(stats.SavedChunks)))
+	sb.WriteString(fmt.Sprintf("  texts:    %d (about %d bytes)\n", stats.Texts, stats.TextBytes))
+	log.Print(sb.String())
+}
+
+// The source map file of a compiled script.
+type sourceMap struct {
+	File     string                  `json:"file"`
+	Mappings []emitter.SourceMapping `json:"mappings"`
+}
+
+// Writes the source map of an output file, next to it.
+func writeSourceMap(mappings []emitter.SourceMapping, outputFilepath string) error {
+	result, err := json.MarshalIndent(sourceMap{File: outputFilepath, Mappings: mappings}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutput(string(result)+"\n", outputFilepath+".map")
+}
+
+// Writes the Make dependency file of an output file, next to it. The output
+// files are the targets of its rule, and the input file, the files that it
+// depends on, and the config files that exist are their prerequisites. Like
+// with GCC's -MP option, each prerequisite other than the input file has an
+// empty rule, so that Make doesn't fail when one of them is deleted.
+func writeDependencyFile(outputFilepath string, inputFilepath string, dependencies []string, options options) error {
+	targets := []string{outputFilepath}
+	for _, filepath := range []string{options.dataFilepath, options.globalFilepath} {
+		if filepath != "" {
+			targets = append(targets, filepath)
+		}
+	}
+	prerequisites := []string{inputFilepath}
+	seen := map[string]bool{inputFilepath: true}
+	add := func(filepath string) {
+		if !seen[filepath] {
+			seen[filepath] = true
+			prerequisites = append(prerequisites, filepath)
+		}
+	}
+	for _, dependency := range dependencies {
+		add(dependency)
+	}
+	for _, configFilepath := range options.configFilepaths {
+		configFilepath = strings.TrimSpace(configFilepath)
+		if _, err := os.Stat(configFilepath); configFilepath != "" && err == nil {
+			add(configFilepath)
+		}
+	}
+
+	var sb strings.Builder
+	for i, target := range targets {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(escapeMakePath(target))
+	}
+	sb.WriteString(":")
+	for _, prerequisite := range prerequisites {
+		sb.WriteString(" \\\n  " + escapeMakePath(prerequisite))
+	}
+	sb.WriteString("\n")
+	for _, prerequisite := range prerequisites[1:] {
+		sb.WriteString(fmt.Sprintf("\n%s:\n", escapeMakePath(prerequisite)))
+	}
+	return writeOutput(sb.String(), outputFilepath+".d")
+}
+
+// Escapes the characters of a filepath that are special in Make rules.
+func escapeMakePath(filepath string) string {
+	filepath = strings.Replace(filepath, "$", "$$", -1)
+	filepath = strings.Replace(filepath, "#", "\\#", -1)
+	return strings.Replace(filepath, " ", "\\ ", -1)
+}
+
+// A label in the symbol file, and the file that it was compiled from, when
+// compiling a project.
+type symbolEntry struct {
+	emitter.Symbol
+	File string `json:"file,omitempty"`
+}
+
+// Writes the symbol file. A ".json" file is an array of the symbols.
+// Otherwise, each line is a symbol's name, its kind, and its scope, like
+// "MyScript script global".
+func writeSymbols(symbols []symbolEntry, filepath string) error {
+	if strings.HasSuffix(strings.ToLower(filepath), ".json") {
+		result, err := json.MarshalIndent(symbols, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeOutput(string(result)+"\n", filepath)
+	}
+	var sb strings.Builder
+	for _, symbol := range symbols {
+		scope := "local"
+		if symbol.Global {
+			scope = "global"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s %s", symbol.Name, symbol.Kind, scope))
+		if symbol.File != "" {
+			sb.WriteString(" " + symbol.File)
+		}
+		sb.WriteString("\n")
+	}
+	return writeOutput(sb.String(), filepath)
+}
+
+func compileProject(options options) {
+	project := parser.NewProject(options.projectFilepaths, options.fontWidthsFilepath, options.compileSwitches)
+	project.SetParamVars(options.paramVars)
+	project.SetNestingLimit(options.nestingLimit)
+	project.SetLexerMode(options.lexerMode)
+	project.SetDiagnosticOptions(options.diagnosticOptions)
+	project.SetLintConfig(options.lintConfig)
+	project.SetAutoEnd(options.autoEndConfig)
+	project.SetFixCallEnds(options.fixCallEnds)
+	project.SetCommandSignatures(options.commandSignatures)
+	start := time.Now()
+	files, err := project.ParseProject()
+	sources := map[string]string{}
+	printDiagnostics(project.Diagnostics(), sources, options.errorFormat)
+	if err != nil {
+		fatalParseError(err, sources, options.errorFormat)
+	}
+	for _, file := range files {
+		logf(infoLevel, "PORYSCRIPT: read %s\n", file.Filepath)
+	}
+	logPassTime("parsed", "the project", start)
+
+	// The files are compiled concurrently, and finished in their order.
+	results := make([]emittedProgram, len(files))
+	errs := make([]error, len(files))
+	compileTimes := make([]time.Duration, len(files))
+	symbols := []symbolEntry{}
+	runConcurrently(len(files), func(i int) {
+		start := time.Now()
+		results[i], errs[i] = emitProgram(files[i].Program, options, getProjectOutputFilepath(files[i].Filepath))
+		compileTimes[i] = time.Since(start)
+	}, func(i int) {
+		file := files[i]
+		outputFilepath := getProjectOutputFilepath(file.Filepath)
+		if errs[i] != nil {
+			fatalf(getErrorExitCode(errs[i]), "PORYSCRIPT ERROR: %s: %s\n", file.Filepath, errs[i].Error())
+		}
+		logPassDuration("compiled", file.Filepath, compileTimes[i])
+		result := results[i]
+		results[i] = emittedProgram{}
+		diagnostics := result.diagnostics
+		for i := range diagnostics {
+			diagnostics[i].Filepath = file.Filepath
+		}
+		printDiagnostics(diagnostics, sources, options.errorFormat)
+		if parser.HasErrors(diagnostics) {
+			os.Exit(exitSemanticError)
+		}
+		if err := writeCompiledOutput(result.output, outputFilepath, options); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		if err := writeProgramSourceMap(result, outputFilepath, options); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		if options.dependencyFile && !options.check && !options.diff {
+			// The references to the other files of the project are resolved
+			// with their definitions, so the output depends on them, too.
+			dependencies := append([]string{}, file.Imports...)
+			for _, other := range files {
+				dependencies = append(dependencies, other.Filepath)
+			}
+			if err := writeDependencyFile(outputFilepath, file.Filepath, dependencies, options); err != nil {
+				fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+			}
+		}
+		if options.statsFormat != "" {
+			printStats(file.Filepath, result.stats, options.statsFormat)
+		}
+		for _, symbol := range result.symbols {
+			symbols = append(symbols, symbolEntry{Symbol: symbol, File: file.Filepath})
+		}
+	})
+	if options.symbolsFilepath != "" && !options.check && !options.diff {
+		if err := writeSymbols(symbols, options.symbolsFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+	}
+}
+
+// The flags of the "fmt" subcommand.
+type formatFlags struct {
+	write           *bool
+	caseInsensitive *bool
+	verbosityFlags  *verbosityFlags
+}
+
+// Defines the flags of the "fmt" subcommand on the flag set.
+func addFormatFlags(flags *flag.FlagSet) *formatFlags {
+	return &formatFlags{
+		write:           flags.Bool("w", false, "write the result to the source file instead of standard output"),
+		caseInsensitive: flags.Bool("case-insensitive-keywords", false, "accept keywords in any case, and write them in their canonical case"),
+		verbosityFlags:  addVerbosityFlags(flags),
+	}
+}
+
+// Runs the "fmt" subcommand, which formats the given files. When no files
+// are given, standard input is formatted.
+func runFormat(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	f := addFormatFlags(flags)
+	parseFlags(flags, args, nil)
+	f.verbosityFlags.apply()
+	logConfigFilepaths([]string{projectConfigFilepath})
+
+	filepaths := flags.Args()
+	if len(filepaths) == 0 {
+		filepaths = []string{""}
+	}
+	for _, inputFilepath := range filepaths {
+		input, err := getInput(inputFilepath)
+		if err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		if inputFilepath != "" {
+			logf(infoLevel, "PORYSCRIPT: read %s\n", inputFilepath)
+		}
+		start := time.Now()
+		result, err := formatter.FormatWithMode(input, getLexerMode(*f.caseInsensitive))
+		if err != nil {
+			if inputFilepath != "" {
+				fatalf(exitParseError, "PORYSCRIPT ERROR: %s: %s\n", inputFilepath, err.Error())
+			}
+			fatalf(exitParseError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		logPassTime("formatted", inputFilepath, start)
+		outputFilepath := ""
+		if *f.write {
+			outputFilepath = inputFilepath
+		}
+		if err := writeOutput(result, outputFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+	}
+}
+
+// The flags of the "lint" subcommand.
+type lintFlags struct {
+	config         *string
+	format         *string
+	output         *string
+	color          *string
+	parserFlags    *parserFlags
+	verbosityFlags *verbosityFlags
+}
+
+// Defines the flags of the "lint" subcommand on the flag set.
+func addLintFlags(flags *flag.FlagSet) *lintFlags {
+	return &lintFlags{
+		config:         flags.String("config", "", "lint rules config JSON file (leave empty to only run the semantic checks)"),
+		format:         flags.String("format", "text", "output format (text, sarif, or json)"),
+		output:         flags.String("o", "", "output file (leave empty to write to standard output)"),
+		color:          flags.String("color", "auto", "color the severities of the text output (never, always, auto). 'auto' colors them when the output is a terminal, unless the NO_COLOR environment variable is set"),
+		parserFlags:    addParserFlags(flags),
+		verbosityFlags: addVerbosityFlags(flags),
+	}
+}
+
+// Runs the "lint" subcommand, which runs the semantic checks and lint rules
+// on the given files without compiling them.
+func runLint(args []string) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	f := addLintFlags(flags)
+	// The lint rules config is the compiler's -lint setting.
+	parseFlags(flags, args, map[string]string{"lint": "config"})
+	f.verbosityFlags.apply()
+	setColor(*f.color, *f.output == "" && isTerminal(os.Stdout))
+
+	if *f.format != "text" && *f.format != "sarif" && *f.format != jsonErrorFormat {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: unknown lint output format '%s'. Expected 'text', 'sarif', or 'json'\n", *f.format)
+	}
+	if flags.NArg() == 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: no input files were given to lint\n")
+	}
+
+	configFilepaths := []string{projectConfigFilepath, *f.parserFlags.fontWidthsFilepath, *f.config}
+	if *f.parserFlags.macros != "" {
+		configFilepaths = append(configFilepaths, strings.Split(*f.parserFlags.macros, ",")...)
+	}
+	logConfigFilepaths(configFilepaths)
+
+	diagnosticOptions := f.parserFlags.getDiagnosticOptions()
+	var lintConfig *parser.LintConfig
+	if *f.config != "" {
+		config, err := parser.LoadLintConfig(*f.config)
+		if err != nil {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load lint config: %s\n", err.Error())
+		}
+		lintConfig = &config
+	}
+	project := parser.NewProject(flags.Args(), *f.parserFlags.fontWidthsFilepath, f.parserFlags.compileSwitches)
+	project.SetParamVars(f.parserFlags.getParamVars())
+	project.SetNestingLimit(*f.parserFlags.nestingLimit)
+	project.SetLexerMode(f.parserFlags.getLexerMode())
+	project.SetDiagnosticOptions(diagnosticOptions)
+	project.SetLintConfig(lintConfig)
+	project.SetCommandSignatures(f.parserFlags.getCommandSignatures())
+	start := time.Now()
+	files, err := project.ParseProject()
+	for _, file := range files {
+		logf(infoLevel, "PORYSCRIPT: read %s\n", file.Filepath)
+	}
+	logPassTime("linted", "the project", start)
+	diagnostics := project.Diagnostics()
+	// Warnings that were treated as errors and lexical errors are already
+	// reported on their own.
+	if err != nil && !parser.IsDiagnosticsError(err) {
+		diagnostics = append(diagnostics, parser.NewErrorDiagnostic(err))
+	}
+
+	var output string
+	if *f.format == "sarif" {
+		bytes, err := sarif.New(diagnostics, version).Marshal()
+		if err != nil {
+			fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		output = string(bytes) + "\n"
+	} else if *f.format == jsonErrorFormat {
+		var sb strings.Builder
+		sources := make(map[string]string)
+		for _, diagnostic := range diagnostics {
+			bytes, err := json.Marshal(diagnostic.ToJSON(getSource(sources, diagnostic.Filepath)))
+			if err != nil {
+				fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", err.Error())
+			}
+			sb.WriteString(string(bytes) + "\n")
+		}
+		output = sb.String()
+	} else {
+		var sb strings.Builder
+		for _, diagnostic := range diagnostics {
+			severity := diagnostic.Severity.String() + ":"
+			if useColor {
+				severity = diagnostic.Severity.Colorize(severity)
+			}
+			sb.WriteString(fmt.Sprintf("%s %s\n", severity, diagnostic))
+		}
+		output = sb.String()
+	}
+	if err := writeOutput(output, *f.output); err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	if err != nil {
+		os.Exit(getErrorExitCode(err))
+	}
+	if parser.HasErrors(diagnostics) {
+		os.Exit(exitSemanticError)
+	}
+}
+
+// A symbol that is listed by the "list-symbols" subcommand.
+type listedSymbol struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// "global" or "local", or empty if the kind of symbol has no scope, like
+	// a const.
+	Scope  string `json:"scope,omitempty"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// The flags of the "list-symbols" subcommand.
+type listSymbolsFlags struct {
+	format         *string
+	kinds          *string
+	output         *string
+	verbosityFlags *verbosityFlags
+}
+
+// Defines the flags of the "list-symbols" subcommand on the flag set.
+func addListSymbolsFlags(flags *flag.FlagSet) *listSymbolsFlags {
+	return &listSymbolsFlags{
+		format:         flags.String("format", "text", "output format (text, json)"),
+		kinds:          flags.String("kinds", "", "comma-separated list of the kinds of symbols to list, like 'script,text' (leave empty to list all of them)"),
+		output:         flags.String("o", "", "output file (leave empty to write to standard output)"),
+		verbosityFlags: addVerbosityFlags(flags),
+	}
+}
+
+// Runs the "list-symbols" subcommand, which lists the symbols that are
+// defined by the given files, in the order of the files. Like the language
+// server, it finds the definitions even if a file has syntax errors.
+func runListSymbols(args []string) {
+	flags := flag.NewFlagSet("list-symbols", flag.ExitOnError)
+	f := addListSymbolsFlags(flags)
+	parseFlags(flags, args, nil)
+	f.verbosityFlags.apply()
+
+	if *f.format != "text" && *f.format != "json" {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: unknown list-symbols output format '%s'. Expected 'text' or 'json'\n", *f.format)
+	}
+	if flags.NArg() == 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: no input files were given to list the symbols of\n")
+	}
+	var kinds map[symbols.Kind]bool
+	if *f.kinds != "" {
+		kinds = make(map[symbols.Kind]bool)
+		for _, keyword := range strings.Split(*f.kinds, ",") {
+			kind, ok := symbols.ParseKind(strings.TrimSpace(keyword))
+			if !ok {
+				fatalf(exitUsage, "PORYSCRIPT ERROR: unknown kind of symbol '%s'\n", keyword)
+			}
+			kinds[kind] = true
+		}
+	}
+
+	listed := []listedSymbol{}
+	for _, inputFilepath := range flags.Args() {
+		input, err := getInput(inputFilepath)
+		if err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		logf(infoLevel, "PORYSCRIPT: read %s\n", inputFilepath)
+		for _, definition := range symbols.ParseFile(inputFilepath, input).Definitions {
+			if kinds != nil && !kinds[definition.Kind] {
+				continue
+			}
+			listed = append(listed, listedSymbol{
+				Name:   definition.Name,
+				Kind:   definition.Kind.String(),
+				Scope:  strings.ToLower(string(definition.Scope)),
+				File:   definition.Filepath,
+				Line:   definition.NameSpan.Start.Line,
+				Column: definition.NameSpan.Start.Column,
+			})
+		}
+	}
+
+	var output string
+	if *f.format == "json" {
+		bytes, err := json.MarshalIndent(listed, "", "  ")
+		if err != nil {
+			fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		output = string(bytes) + "\n"
+	} else {
+		var sb strings.Builder
+		for _, symbol := range listed {
+			sb.WriteString(fmt.Sprintf("%s:%d:%d: %s %s", symbol.File, symbol.Line, symbol.Column, symbol.Kind, symbol.Name))
+			if symbol.Scope != "" {
+				sb.WriteString(" " + symbol.Scope)
+			}
+			sb.WriteString("\n")
+		}
+		output = sb.String()
+	}
+	if err := writeOutput(output, *f.output); err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+}
+
+// The flags of the "rename" subcommand.
+type renameFlags struct {
+	file            *string
+	diff            *bool
+	caseInsensitive *bool
+	verbosityFlags  *verbosityFlags
+}
+
+// Defines the flags of the "rename" subcommand on the flag set.
+func addRenameFlags(flags *flag.FlagSet) *renameFlags {
+	return &renameFlags{
+		file:            flags.String("file", "", "file that defines the symbol, when multiple files define a symbol with the old name"),
+		diff:            flags.Bool("diff", false, "print a unified diff of the renamed files, instead of writing them"),
+		caseInsensitive: flags.Bool("case-insensitive-keywords", false, "accept keywords in any case, and write them in their canonical case"),
+		verbosityFlags:  addVerbosityFlags(flags),
+	}
+}
+
+// Runs the "rename" subcommand, which renames a symbol, like a script, at
+// its definition and at all of its references in the given files, and
+// formats the files that changed. Directories are searched for their .pory
+// files.
+func runRename(args []string) {
+	flags := flag.NewFlagSet("rename", flag.ExitOnError)
+	f := addRenameFlags(flags)
+	parseFlags(flags, args, nil)
+	f.verbosityFlags.apply()
+
+	if flags.NArg() < 3 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: rename needs the old name, the new name, and the files to rename the symbol in\n")
+	}
+	oldName, newName := flags.Arg(0), flags.Arg(1)
+	if tokens := symbols.Tokenize(newName); len(tokens) != 1 || tokens[0].Type != token.IDENT || tokens[0].Literal != newName {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: '%s' isn't a valid symbol name\n", newName)
+	}
+	filepaths, err := expandFilepaths(flags.Args()[2:])
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+
+	index, inputs := readIndexedFiles(filepaths)
+
+	var definitions []symbols.Definition
+	for _, definition := range index.Definitions() {
+		if definition.Name == newName {
+			pos := definition.NameSpan.Start
+			fatalf(exitSemanticError, "PORYSCRIPT ERROR: '%s' is already defined at %s: line %d:%d\n", newName, definition.Filepath, pos.Line, pos.Column)
+		}
+		if definition.Name == oldName && (*f.file == "" || filepath.Clean(definition.Filepath) == filepath.Clean(*f.file)) {
+			definitions = append(definitions, definition)
+		}
+	}
+	if len(definitions) == 0 {
+		fatalf(exitSemanticError, "PORYSCRIPT ERROR: '%s' isn't defined in the given files\n", oldName)
+	}
+	if len(definitions) > 1 {
+		var locations []string
+		for _, definition := range definitions {
+			locations = append(locations, definition.Filepath)
+		}
+		fatalf(exitUsage, "PORYSCRIPT ERROR: '%s' is defined in multiple files (%s). Use -file to choose one of them\n", oldName, strings.Join(locations, ", "))
+	}
+	definition := definitions[0]
+
+	// The raw statements are copied to the output as they are, so the
+	// references inside of them can't be found reliably.
+	nameRegex := regexp.MustCompile(`\b` + regexp.QuoteMeta(oldName) + `\b`)
+	for _, inputFilepath := range filepaths {
+		f, _ := index.File(inputFilepath)
+		for _, tok := range f.Tokens {
+			if tok.Type == token.RAWSTRING && nameRegex.MatchString(tok.Literal) {
+				logf(warningLevel, "PORYSCRIPT WARNING: %s: line %d:%d: raw statement mentions '%s', which isn't renamed inside of it\n", inputFilepath, tok.LineNumber, tok.Column, oldName)
+			}
+		}
+	}
+
+	edits := index.RenameEdits(definition, newName)
+	writeEditedFiles(filepaths, inputs, edits, getLexerMode(*f.caseInsensitive), *f.diff)
+	logf(warningLevel, "PORYSCRIPT: renamed %s '%s' to '%s' at %d location(s)\n", definition.Kind, oldName, newName, len(edits))
+}
+
+// Reads the files, and indexes their symbols. Returns the index, and the
+// contents of the files by filepath.
+func readIndexedFiles(filepaths []string) (*symbols.Index, map[string]string) {
+	index := symbols.NewIndex()
+	inputs := make(map[string]string)
+	for _, inputFilepath := range filepaths {
+		input, err := getInput(inputFilepath)
+		if err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		logf(infoLevel, "PORYSCRIPT: read %s\n", inputFilepath)
+		inputs[inputFilepath] = input
+		index.AddFile(inputFilepath, input)
+	}
+	return index, inputs
+}
+
+// Applies the edits to the files, and writes the files that changed, which
+// are formatted like by the "fmt" subcommand. With showDiff, a unified diff
+// of each file is printed, instead. No file is written until all of them
+// were formatted, so that an error doesn't leave them half-edited.
+func writeEditedFiles(filepaths []string, inputs map[string]string, edits []symbols.Edit, mode lexer.Mode, showDiff bool) {
+	editedFilepaths := make(map[string]bool)
+	for _, edit := range edits {
+		editedFilepaths[edit.Filepath] = true
+	}
+	results := make(map[string]string)
+	for _, inputFilepath := range filepaths {
+		if !editedFilepaths[inputFilepath] {
+			continue
+		}
+		result, err := formatter.FormatWithMode(symbols.ApplyEdits(inputFilepath, inputs[inputFilepath], edits), mode)
+		if err != nil {
+			fatalf(exitParseError, "PORYSCRIPT ERROR: %s: %s\n", inputFilepath, err.Error())
+		}
+		results[inputFilepath] = result
+	}
+	for _, inputFilepath := range filepaths {
+		result, ok := results[inputFilepath]
+		if !ok {
+			continue
+		}
+		if showDiff {
+			fmt.Print(diff.Unified(inputFilepath, inputFilepath, inputs[inputFilepath], result))
+		} else if err := writeOutput(result, inputFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+	}
+}
+
+// The flags of the "extract-text" subcommand.
+type extractTextFlags struct {
+	scripts         *string
+	diff            *bool
+	caseInsensitive *bool
+	verbosityFlags  *verbosityFlags
+}
+
+// Defines the flags of the "extract-text" subcommand on the flag set.
+func addExtractTextFlags(flags *flag.FlagSet) *extractTextFlags {
+	return &extractTextFlags{
+		scripts:         flags.String("scripts", "", "comma-separated list of the scripts whose texts are extracted (leave empty to extract the texts of all scripts)"),
+		diff:            flags.Bool("diff", false, "print a unified diff of the changed files, instead of writing them"),
+		caseInsensitive: flags.Bool("case-insensitive-keywords", false, "accept keywords in any case, and write them in their canonical case"),
+		verbosityFlags:  addVerbosityFlags(flags),
+	}
+}
+
+// Runs the "extract-text" subcommand, which moves the inline texts of the
+// chosen scripts into their own text statements, and formats the files that
+// changed. Directories are searched for their .pory files.
+func runExtractText(args []string) {
+	flags := flag.NewFlagSet("extract-text", flag.ExitOnError)
+	f := addExtractTextFlags(flags)
+	parseFlags(flags, args, nil)
+	f.verbosityFlags.apply()
+
+	if flags.NArg() == 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: no input files were given to extract the texts of\n")
+	}
+	filepaths, err := expandFilepaths(flags.Args())
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	index, inputs := readIndexedFiles(filepaths)
+
+	scriptNames := make(map[string]bool)
+	for _, definition := range index.Definitions() {
+		if definition.Kind == symbols.KindScript {
+			scriptNames[definition.Name] = true
+		}
+	}
+	if *f.scripts != "" {
+		chosenNames := make(map[string]bool)
+		for _, name := range strings.Split(*f.scripts, ",") {
+			name = strings.TrimSpace(name)
+			if !scriptNames[name] {
+				fatalf(exitSemanticError, "PORYSCRIPT ERROR: script '%s' isn't defined in the given files\n", name)
+			}
+			chosenNames[name] = true
+		}
+		scriptNames = chosenNames
+	}
+
+	edits := []symbols.Edit{}
+	count := 0
+	for _, inputFilepath := range filepaths {
+		fileEdits, fileCount := index.ExtractTextEdits(inputFilepath, inputs[inputFilepath], scriptNames)
+		edits = append(edits, fileEdits...)
+		count += fileCount
+	}
+	writeEditedFiles(filepaths, inputs, edits, getLexerMode(*f.caseInsensitive), *f.diff)
+	logf(warningLevel, "PORYSCRIPT: extracted %d text(s)\n", count)
+}
+
+// The flags of the "decompile" subcommand.
+type decompileFlags struct {
+	output         *string
+	verbosityFlags *verbosityFlags
+}
+
+// Defines the flags of the "decompile" subcommand on the flag set.
+func addDecompileFlags(flags *flag.FlagSet) *decompileFlags {
+	return &decompileFlags{
+		output:         flags.String("o", "", "output file (leave empty to write to standard output)"),
+		verbosityFlags: addVerbosityFlags(flags),
+	}
+}
+
+// Runs the "decompile" subcommand, which turns an assembler script file,
+// like a map's scripts.inc file, into Poryscript.
+func runDecompile(args []string) {
+	flags := flag.NewFlagSet("decompile", flag.ExitOnError)
+	f := addDecompileFlags(flags)
+	parseFlags(flags, args, nil)
+	f.verbosityFlags.apply()
+
+	if flags.NArg() == 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: no input file was given to decompile\n")
+	}
+	if flags.NArg() > 1 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: only one input file can be decompiled at a time\n")
+	}
+	inputFilepath := flags.Arg(0)
+	input, err := getInput(inputFilepath)
+	if err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	logf(infoLevel, "PORYSCRIPT: read %s\n", inputFilepath)
+	start := time.Now()
+	output := decompiler.Decompile(input)
+	logPassTime("decompiled", inputFilepath, start)
+	if _, err := formatter.Format(output); err != nil {
+		fatalf(exitFailure, "PORYSCRIPT ERROR: the decompiled Poryscript of '%s' doesn't parse: %s\n", inputFilepath, err.Error())
+	}
+	if err := writeOutput(output, *f.output); err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+}
+
+// Returns the files of the given filepaths. Directories and glob patterns
+// are expanded to the .pory files that they match, like with -i.
+func expandFilepaths(filepaths []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			result = append(result, path)
+		}
+	}
+	for _, path := range filepaths {
+		baseDir, pattern, ok := getInputPattern(path)
+		if !ok {
+			add(path)
+			continue
+		}
+		matches, err := findMatchingFiles(baseDir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match '%s'", path)
+		}
+		for _, match := range matches {
+			add(filepath.Join(baseDir, match))
+		}
+	}
+	return result, nil
+}
+
+// The flags of the "lsp" subcommand.
+type languageServerFlags struct {
+	fonts *string
+	lint  *string
+}
+
+// Defines the flags of the "lsp" subcommand on the flag set.
+func addLanguageServerFlags(flags *flag.FlagSet) *languageServerFlags {
+	return &languageServerFlags{
+		fonts: flags.String("fw", "font_widths.json", "font widths config JSON file"),
+		lint:  flags.String("lint", "", "lint rules config JSON file (leave empty to disable linting)"),
+	}
+}
+
+// Runs the "lsp" subcommand, which starts a language server that
+// communicates over standard input and output.
+func runLanguageServer(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	f := addLanguageServerFlags(flags)
+	parseFlags(flags, args, nil)
+
+	server := lsp.NewServer(os.Stdin, os.Stdout, *f.fonts)
+	if *f.lint != "" {
+		config, err := parser.LoadLintConfig(*f.lint)
+		if err != nil {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load lint config: %s\n", err.Error())
+		}
+		server.SetLintConfig(&config)
+	}
+	if err := server.Run(); err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+}
+
+// The interval that the watched files are checked for changes at.
+const watchInterval = 500 * time.Millisecond
+
+// Compiles the input files, and compiles them again whenever one of them,
+// their imports, or the config files change. Each compilation runs
+// Poryscript again with -watch=false, so that its diagnostics are printed as
+// usual, and an error doesn't stop the watching.
+func watch(options options) {
+	if len(options.inputFilepaths) == 0 && len(options.projectFilepaths) == 0 {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -watch needs input files, given with -i or as a project\n")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	args := getCompilationArgs(os.Args[1:])
+	var imports []string
+	var modTimes map[string]time.Time
+	for {
+		current := getModTimes(getWatchedFilepaths(options, imports))
+		if modTimes != nil && !modTimesChanged(modTimes, current) {
+			time.Sleep(watchInterval)
+			continue
+		}
+		cmd := exec.Command(executable, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", err.Error())
+			}
+			// The compilation already reported its errors.
+		}
+		imports = getImports(options)
+		filepaths := getWatchedFilepaths(options, imports)
+		modTimes = getModTimes(filepaths)
+		logf(warningLevel, "PORYSCRIPT: watching %d files for changes...\n", len(filepaths))
+	}
+}
+
+var watchArgRegex = regexp.MustCompile(`^--?watch(=.*)?$`)
+
+// Returns the command-line arguments of a compilation of -watch, which
+// replace the -watch option with -watch=false. It's given explicitly, so that
+// the compilation never watches the files itself. It comes before the other
+// flags, but after the subcommand, if there is one.
+func getCompilationArgs(args []string) []string {
+	var result []string
+	if len(args) > 0 && (args[0] == "compile" || args[0] == "check") {
+		result = append(result, args[0])
+		args = args[1:]
+	}
+	result = append(result, "-watch=false")
+	for _, arg := range args {
+		if !watchArgRegex.MatchString(arg) {
+			result = append(result, arg)
+		}
+	}
+	return result
+}
+
+// Returns the files that are watched for changes, which are the input files,
+// their imports, and the config files. Patterns are expanded again, so that
+// new files that match them are noticed.
+func getWatchedFilepaths(options options, imports []string) []string {
+	filepaths := append([]string{}, options.projectFilepaths...)
+	if inputFilepaths, _, _, err := expandInputFilepaths(options.inputFilepaths, options.outputFilepaths); err == nil {
+		filepaths = append(filepaths, inputFilepaths...)
+	}
+	filepaths = append(filepaths, imports...)
+	for _, configFilepath := range options.configFilepaths {
+		if configFilepath = strings.TrimSpace(configFilepath); configFilepath != "" {
+			filepaths = append(filepaths, configFilepath)
+		}
+	}
+	return filepaths
+}
+
+// Returns the files that are imported by the input files, directly or
+// indirectly.
+func getImports(options options) []string {
+	inputFilepaths := options.projectFilepaths
+	if expanded, _, _, err := expandInputFilepaths(options.inputFilepaths, options.outputFilepaths); err == nil {
+		inputFilepaths = append(append([]string{}, inputFilepaths...), expanded...)
+	}
+	var imports []string
+	for _, inputFilepath := range inputFilepaths {
+		if inputFilepath == "" {
+			continue
+		}
+		input, err := getInput(inputFilepath)
+		if err != nil {
+			continue
+		}
+		p := parser.New(lexer.NewWithMode(input, options.lexerMode), options.fontWidthsFilepath, options.compileSwitches)
+		p.SetFilepath(inputFilepath)
+		// The imports that were found before an error are still watched.
+		p.ParseProgram()
+		imports = append(imports, p.Imports()...)
+	}
+	return imports
+}
+
+// Returns the modification time of each file. Files that don't exist have
+// the zero time, so that creating them is noticed, too.
+func getModTimes(filepaths []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(filepaths))
+	for _, filepath := range filepaths {
+		if info, err := os.Stat(filepath); err == nil {
+			modTimes[filepath] = info.ModTime()
+		} else {
+			modTimes[filepath] = time.Time{}
+		}
+	}
+	return modTimes
+}
+
+func modTimesChanged(previous map[string]time.Time, current map[string]time.Time) bool {
+	if len(previous) != len(current) {
+		return true
+	}
+	for filepath, modTime := range current {
+		if previousModTime, ok := previous[filepath]; !ok || !previousModTime.Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// The project config file, which is read from the working directory. It sets
+// the default values of the flags, so that they don't need to be given to
+// every run of Poryscript.
+const projectConfigFilepath = "poryscript.json"
+
+// The flags that can only be given on the command line, since they change
+// what a run of Poryscript does, instead of how it compiles. For example,
+// "v" would make every run print the version and exit, and the compilations
+// of -watch read the project config, too, so "watch" would make them watch
+// the files themselves.
+var commandLineFlags = map[string]bool{
+	"h":     true,
+	"v":     true,
+	"batch": true,
+	"check": true,
+	"diff":  true,
+	"watch": true,
+}
+
+// Parses the flags of a subcommand. The flags that weren't given are set by
+// the project config file, if there is one. The names of its settings are
+// the names of the flags, and aliases maps the settings to the subcommand's
+// flags whose names differ. Settings that the subcommand doesn't have a flag
+// for are ignored, since they belong to the other subcommands, but settings
+// that no subcommand has a flag for are reported.
+func parseFlags(flags *flag.FlagSet, args []string, aliases map[string]string) {
+	flags.Parse(args)
+	bytes, err := ioutil.ReadFile(projectConfigFilepath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load project config: %s\n", err.Error())
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: failed to load project config %s: %s\n", projectConfigFilepath, err.Error())
+	}
+
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	// The settings are applied in order, so that the same error is reported
+	// every time.
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var settingNames map[string]bool
+	for _, name := range names {
+		if commandLineFlags[name] {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: '%s' can't be set in %s, only on the command line\n", name, projectConfigFilepath)
+		}
+		flagName := name
+		if alias, ok := aliases[name]; ok {
+			flagName = alias
+		}
+		f := flags.Lookup(flagName)
+		if f == nil {
+			if settingNames == nil {
+				settingNames = getConfigSettingNames()
+			}
+			if !settingNames[name] {
+				fatalf(exitUsage, "PORYSCRIPT ERROR: unknown setting '%s' in %s. Its name must be the name of a flag, without the '-'\n", name, projectConfigFilepath)
+			}
+			continue
+		}
+		switches, isSwitches := f.Value.(mapOption)
+		if given[flagName] && !isSwitches {
+			continue
+		}
+		values, err := getConfigFlagValues(config[name], f.Value)
+		if err != nil {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: invalid value of '%s' in %s: %s\n", name, projectConfigFilepath, err.Error())
+		}
+		for _, value := range values {
+			// The compile-time switches that were given override the
+			// config's switches of the same name.
+			if _, ok := switches[strings.SplitN(value, "=", 2)[0]]; ok && given[flagName] {
+				continue
+			}
+			if err := flags.Set(flagName, value); err != nil {
+				fatalf(exitUsage, "PORYSCRIPT ERROR: invalid value of '%s' in %s: %s\n", name, projectConfigFilepath, err.Error())
+			}
+		}
+	}
+}
+
+// Returns the names of the settings of the project config that any
+// subcommand has a flag for.
+func getConfigSettingNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, command := range getSubcommands() {
+		flags := flag.NewFlagSet(command.name, flag.ContinueOnError)
+		command.addFlags(flags)
+		flags.VisitAll(func(f *flag.Flag) {
+			names[f.Name] = true
+		})
+	}
+	return names
+}
+
+// Returns the values that a setting of the project config sets its flag to.
+// Flags that can be set multiple times are set to each value of an array,
+// and the other flags are set to the comma-separated values. Each entry of
+// an object, like the compile-time switches, is set as "key=value".
+func getConfigFlagValues(setting interface{}, flagValue flag.Value) ([]string, error) {
+	switch value := setting.(type) {
+	case []interface{}:
+		var values []string
+		for _, element := range value {
+			elementValues, err := getConfigFlagValues(element, flagValue)
+			if err != nil || len(elementValues) != 1 {
+				return nil, fmt.Errorf("expected an array of strings, numbers, or booleans")
+			}
+			values = append(values, elementValues[0])
+		}
+		switch flagValue.(type) {
+		case mapOption, *listOption:
+			return values, nil
+		}
+		return []string{strings.Join(values, ",")}, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			entryValues, err := getConfigFlagValues(value[key], flagValue)
+			if err != nil || len(entryValues) != 1 {
+				return nil, fmt.Errorf("expected an object of strings, numbers, or booleans")
+			}
+			values[i] = key + "=" + entryValues[0]
+		}
+		return values, nil
+	case string:
+		return []string{value}, nil
+	case float64:
+		return []string{strconv.FormatFloat(value, 'f', -1, 64)}, nil
+	case bool:
+		return []string{strconv.FormatBool(value)}, nil
+	}
+	return nil, fmt.Errorf("expected a string, number, boolean, array, or object")
+}
+
+// A subcommand of the command line, like "poryscript fmt".
+type subcommand struct {
+	name        string
+	description string
+	run         func(args []string)
+	// Defines the subcommand's flags, like run does, so that the project
+	// config can be checked against them.
+	addFlags func(flags *flag.FlagSet)
+}
+
+// Returns the subcommands, in the order they are listed in the usage.
+func getSubcommands() []subcommand {
+	return []subcommand{
+		{"compile", "compile Poryscript files (the default, when no subcommand is given)", runCompile, func(flags *flag.FlagSet) { addCompileFlags(flags) }},
+		{"check", "compile Poryscript files without writing any output, like 'compile -check'", runCheck, func(flags *flag.FlagSet) { addCompileFlags(flags) }},
+		{"fmt", "format Poryscript files", runFormat, func(flags *flag.FlagSet) { addFormatFlags(flags) }},
+		{"lint", "check Poryscript files for warnings and lint rules, without compiling them", runLint, func(flags *flag.FlagSet) { addLintFlags(flags) }},
+		{"list-symbols", "list the scripts, texts, and other symbols that Poryscript files define", runListSymbols, func(flags *flag.FlagSet) { addListSymbolsFlags(flags) }},
+		{"rename", "rename a symbol and all of its references in Poryscript files", runRename, func(flags *flag.FlagSet) { addRenameFlags(flags) }},
+		{"extract-text", "move the inline texts of scripts into their own text statements", runExtractText, func(flags *flag.FlagSet) { addExtractTextFlags(flags) }},
+		{"decompile", "turn an assembler script file into Poryscript", runDecompile, func(flags *flag.FlagSet) { addDecompileFlags(flags) }},
+		{"lsp", "run the language server", runLanguageServer, func(flags *flag.FlagSet) { addLanguageServerFlags(flags) }},
+	}
+}
+
+// Prints the usage of Poryscript, which lists the subcommands, followed by
+// the flags of the given subcommand.
+func printUsage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Usage of poryscript:\n  poryscript [compile] [flags] [files...]\n  poryscript <subcommand> [flags] [files...]\n\nSubcommands:\n")
+	for _, command := range getSubcommands() {
+		fmt.Fprintf(out, "  %-12s %s\n", command.name, command.description)
+	}
+	fmt.Fprintf(out, "\nFlags of %s:\n", flags.Name())
+	flags.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) > 1 {
+		for _, command := range getSubcommands() {
+			if os.Args[1] == command.name {
+				command.run(os.Args[2:])
+				return
+			}
+		}
+	}
+	// Without a subcommand, the files are compiled.
+	runCompile(os.Args[1:])
+}
+
+// Runs the "compile" subcommand, which compiles the input files.
+func runCompile(args []string) {
+	compile(parseOptions("compile", args))
+}
+
+// Runs the "check" subcommand, which compiles the input files without
+// writing any output.
+func runCheck(args []string) {
+	options := parseOptions("check", args)
+	options.check = true
+	compile(options)
+}
+
+func compile(options options) {
+	if (options.check || options.diff) && (options.dumpAST || options.dumpTokens) {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -check and -diff cannot be used with -dump-ast or -dump-tokens\n")
+	}
+	if options.check && options.diff {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -check and -diff cannot be used together\n")
+	}
+	if options.batch && options.watch {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -batch and -watch cannot be used together\n")
+	}
+	if options.diff {
+		// The differences of all of the outputs are printed before exiting.
+		defer exitIfOutputsDiffer()
+	}
+	// In watch mode, each compilation profiles its own run of Poryscript.
+	if !options.watch {
+		defer startProfiling(options.cpuProfileFilepath, options.memProfileFilepath)()
+	}
+	if options.watch {
+		watch(options)
+		return
+	}
+	if options.batch {
+		if len(options.inputFilepaths) > 0 || len(options.outputFilepaths) > 0 || len(options.projectFilepaths) > 0 {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: -batch cannot be used with -i, -o, or a project of multiple files\n")
+		}
+		inputFilepaths, outputFilepaths, err := readBatchFilepaths(os.Stdin)
+		if err != nil {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: failed to read -batch files: %s\n", err.Error())
+		}
+		options.inputFilepaths = inputFilepaths
+		options.outputFilepaths = outputFilepaths
+	}
+	if len(options.projectFilepaths) > 0 {
+		if len(options.inputFilepaths) > 0 || len(options.outputFilepaths) > 0 || options.dataFilepath != "" || options.globalFilepath != "" {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: -i, -o, -data-o, and -global-o cannot be used when compiling a project of multiple files\n")
+		}
+		if options.dumpAST || options.dumpTokens || options.loadAST {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: -dump-ast, -dump-tokens, and -load-ast cannot be used when compiling a project of multiple files\n")
+		}
+		compileProject(options)
+		return
+	}
+	inputFilepaths, outputFilepaths, mirroredOutputs, err := expandInputFilepaths(options.inputFilepaths, options.outputFilepaths)
+	if err != nil {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	options.inputFilepaths = inputFilepaths
+	options.outputFilepaths = outputFilepaths
+	options.mirroredOutputs = mirroredOutputs
+	if len(inputFilepaths) == 1 && len(outputFilepaths) == 1 {
+		// A pattern can match a single file.
+		options.inputFilepath = inputFilepaths[0]
+		options.outputFilepath = outputFilepaths[0]
+		options.createOutputDir = mirroredOutputs[0]
+	}
+	if len(inputFilepaths) > 1 || len(outputFilepaths) > 1 {
+		compileFiles(options)
+		return
+	}
+	symbols := compileFile(options)
+	if options.symbolsFilepath != "" && !options.dumpAST && !options.dumpTokens && !options.check && !options.diff {
+		entries := make([]symbolEntry, len(symbols))
+		for i, symbol := range symbols {
+			entries[i] = symbolEntry{Symbol: symbol}
+		}
+		if err := writeSymbols(entries, options.symbolsFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+	}
+}
+
+// Starts the CPU profile, if its filepath isn't empty, and returns a function
+// that stops it, and writes the memory profile, if its filepath isn't empty.
+// The profiles are only written when the compilation finishes, since a
+// compilation that fails exits right away.
+func startProfiling(cpuProfileFilepath string, memProfileFilepath string) func() {
+	var cpuProfile *os.File
+	if cpuProfileFilepath != "" {
+		var err error
+		if cpuProfile, err = os.Create(cpuProfileFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		if err := pprof.StartCPUProfile(cpuProfile); err != nil {
+			fatalf(exitFailure, "PORYSCRIPT ERROR: failed to start CPU profile: %s\n", err.Error())
+		}
+	}
+	return func() {
+		if cpuProfile != nil {
+			pprof.StopCPUProfile()
+			cpuProfile.Close()
+			logf(infoLevel, "PORYSCRIPT: wrote %s\n", cpuProfileFilepath)
+		}
+		if memProfileFilepath != "" {
+			f, err := os.Create(memProfileFilepath)
+			if err != nil {
+				fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+			}
+			defer f.Close()
+			// The garbage is collected first, so that the profile shows the
+			// memory that is still in use.
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fatalf(exitIOError, "PORYSCRIPT ERROR: failed to write memory profile: %s\n", err.Error())
+			}
+			logf(infoLevel, "PORYSCRIPT: wrote %s\n", memProfileFilepath)
+		}
+	}
+}
+
+// Compiles each input file to its own output file. The files are compiled
+// on their own, like they would be by separate runs of Poryscript, but the
+// configs, like the font widths, are only loaded once. The files are built
+// concurrently, and finished in their order, so the messages and outputs are
+// the same as if they were compiled one after another. The symbol file lists
+// the symbols of every file, followed by the file that they were compiled
+// from.
+func compileFiles(options options) {
+	if len(options.inputFilepaths) != len(options.outputFilepaths) {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: each -i needs its own -o when compiling multiple input files, but got %d -i and %d -o\n", len(options.inputFilepaths), len(options.outputFilepaths))
+	}
+	if options.dataFilepath != "" || options.globalFilepath != "" {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -data-o and -global-o cannot be used when compiling multiple input files\n")
+	}
+	for i := range options.inputFilepaths {
+		if options.inputFilepaths[i] == "" || options.outputFilepaths[i] == "" {
+			fatalf(exitUsage, "PORYSCRIPT ERROR: standard input and output cannot be used when compiling multiple input files\n")
+		}
+	}
+	// When the config can't be loaded, each file that uses format() warns
+	// about it instead.
+	if fonts, err := parser.LoadFontWidths(options.fontWidthsFilepath); err == nil {
+		options.fontWidths = &fonts
+	}
+
+	for i := range options.inputFilepaths {
+		checkFileOptions(getFileOptions(options, i))
+	}
+	files := make([]builtFile, len(options.inputFilepaths))
+	symbols := []symbolEntry{}
+	runConcurrently(len(files), func(i int) {
+		files[i] = buildFile(getFileOptions(options, i))
+	}, func(i int) {
+		for _, symbol := range finishFile(files[i], getFileOptions(options, i)) {
+			symbols = append(symbols, symbolEntry{Symbol: symbol, File: options.inputFilepaths[i]})
+		}
+		// The finished file isn't needed anymore.
+		files[i] = builtFile{}
+	})
+	if options.symbolsFilepath != "" && !options.dumpAST && !options.dumpTokens && !options.check && !options.diff {
+		if err := writeSymbols(symbols, options.symbolsFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+	}
+}
+
+// Returns the options that compile the input file at the given index of
+// multiple input files.
+func getFileOptions(options options, i int) options {
+	options.inputFilepath = options.inputFilepaths[i]
+	options.outputFilepath = options.outputFilepaths[i]
+	options.createOutputDir = options.mirroredOutputs[i]
+	return options
+}
+
+// Runs build for each of count items on a pool of GOMAXPROCS workers, and
+// runs finish for each item in their order, as soon as it's built. finish
+// runs on the calling goroutine, one item at a time, so that the messages of
+// the items aren't interleaved. The workers keep building the next items
+// while an item is finished.
+func runConcurrently(count int, build func(i int), finish func(i int)) {
+	built := make([]chan struct{}, count)
+	indexes := make(chan int, count)
+	for i := range built {
+		built[i] = make(chan struct{})
+		indexes <- i
+	}
+	close(indexes)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > count {
+		workers = count
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range indexes {
+				build(i)
+				close(built[i])
+			}
+		}()
+	}
+	for i := range built {
+		<-built[i]
+		finish(i)
+	}
+}
+
+// Compiles the input file of the options to its output file, and returns the
+// labels that were emitted. Nothing is emitted when the tokens or the AST are
+// dumped instead.
+func compileFile(options options) []emitter.Symbol {
+	checkFileOptions(options)
+	return finishFile(buildFile(options), options)
+}
+
+// Exits if the options can't be used to compile the input file of the
+// options to its output file.
+func checkFileOptions(options options) {
+	if options.sourceMap && options.outputFilepath == "" {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -source-map can only be used with -o, or when compiling a project\n")
+	}
+	if options.dependencyFile && (options.outputFilepath == "" || options.inputFilepath == "") {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -MD can only be used with -i and -o, or when compiling a project\n")
+	}
+	if options.lineDirectives != emitter.NoLineDirectives && options.outputFilepath == "" {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -line-directives can only be used with -o, or when compiling a project\n")
+	}
+	if options.diff && options.outputFilepath == "" {
+		fatalf(exitUsage, "PORYSCRIPT ERROR: -diff can only be used with -o, or when compiling a project\n")
+	}
+}
+
+// A file that was read, parsed, and compiled, whose messages haven't been
+// printed, and whose output hasn't been written yet. Multiple files are built
+// concurrently, and then finished one at a time.
+type builtFile struct {
+	input   string
+	readErr error
+	// The tokens or the AST, when they are dumped instead of compiling.
+	dump        string
+	program     *ast.Program
+	imports     []string
+	diagnostics []parser.Diagnostic
+	// The error of the pass that failed, if any, which stopped the build.
+	parseErr    error
+	dumpErr     error
+	emitErr     error
+	result      emittedProgram
+	parseTime   time.Duration
+	compileTime time.Duration
+}
+
+// Reads, parses, and compiles the input file of the options, without
+// printing anything or writing any files, other than the -data-o and
+// -global-o files. It's safe to build multiple files concurrently.
+func buildFile(options options) builtFile {
+	var file builtFile
+	file.input, file.readErr = getInput(options.inputFilepath)
+	if file.readErr != nil {
+		return file
+	}
+	if options.dumpTokens {
+		file.dump = dumpTokens(file.input, options.lexerMode)
+		return file
+	}
+
+	start := time.Now()
+	if options.loadAST {
+		file.program, file.parseErr = ast.DecodeJSON([]byte(file.input))
+	} else {
+		file.program, file.imports, file.diagnostics, file.parseErr = parseProgram(file.input, options)
+	}
+	file.parseTime = time.Since(start)
+	if file.parseErr != nil {
+		return file
+	}
+
+	if options.dumpAST {
+		result, err := ast.EncodeJSON(file.program)
+		file.dump, file.dumpErr = string(result)+"\n", err
+		return file
+	}
+
+	start = time.Now()
+	file.result, file.emitErr = emitProgram(file.program, options, options.outputFilepath)
+	file.compileTime = time.Since(start)
+	return file
+}
+
+// Prints the messages of a built file, and writes its outputs, or exits if
+// it failed to build. Returns the labels that were emitted.
+func finishFile(file builtFile, options options) []emitter.Symbol {
+	if file.readErr != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", file.readErr.Error())
+	}
+	if options.inputFilepath != "" {
+		logf(infoLevel, "PORYSCRIPT: read %s\n", options.inputFilepath)
+	}
+
+	if options.dumpTokens {
+		if err := createOutputDir(options); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		if err := writeOutput(file.dump, options.outputFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		return nil
+	}
+
+	printDiagnostics(file.diagnostics, getInputSources(file.input, options), options.errorFormat)
+	if file.parseErr != nil {
+		if options.loadAST {
+			fatalf(exitParseError, "PORYSCRIPT ERROR: %s\n", file.parseErr.Error())
+		}
+		fatalParseError(file.parseErr, getInputSources(file.input, options), options.errorFormat)
+	}
+	logPassDuration("parsed", options.inputFilepath, file.parseTime)
+
+	if options.dumpAST {
+		if file.dumpErr != nil {
+			fatalf(exitFailure, "PORYSCRIPT ERROR: %s\n", file.dumpErr.Error())
+		}
+		if err := createOutputDir(options); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		if err := writeOutput(file.dump, options.outputFilepath); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+		return nil
+	}
+
+	if file.emitErr != nil {
+		fatalf(getErrorExitCode(file.emitErr), "PORYSCRIPT ERROR: %s\n", file.emitErr.Error())
+	}
+	logPassDuration("compiled", options.inputFilepath, file.compileTime)
+	result := file.result
+	diagnostics := result.diagnostics
+	sources := getInputSources(file.input, options)
+	if options.loadAST {
+		// The input isn't the Poryscript source, so there are no excerpts.
+		sources = map[string]string{}
+	}
+	printDiagnostics(getFileDiagnostics(diagnostics, options), sources, options.errorFormat)
+	if parser.HasErrors(diagnostics) {
+		os.Exit(exitSemanticError)
+	}
+	if err := createOutputDir(options); err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	err := writeCompiledOutput(result.output, options.outputFilepath, options)
+	if err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	if err := writeProgramSourceMap(result, options.outputFilepath, options); err != nil {
+		fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+	}
+	if options.dependencyFile && !options.check && !options.diff {
+		if err := writeDependencyFile(options.outputFilepath, options.inputFilepath, file.imports, options); err != nil {
+			fatalf(exitIOError, "PORYSCRIPT ERROR: %s\n", err.Error())
+		}
+	}
+	if options.statsFormat != "" {
+		printStats(options.inputFilepath, result.stats, options.statsFormat)
 	}
+	return result.symbols
 }
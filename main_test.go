@@ -0,0 +1,679 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/huderlem/poryscript/emitter"
+	"github.com/huderlem/poryscript/parser"
+)
+
+// When the test binary is run with this environment variable, it runs
+// Poryscript's main function with its arguments instead of the tests, so that
+// the tests can check the exit codes of Poryscript.
+const runMainEnv = "PORYSCRIPT_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Runs Poryscript with the given arguments in the given directory, and returns
+// its standard error and exit code.
+func runPoryscript(t *testing.T, dir string, stdin string, args ...string) (string, int) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return stderr.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return stderr.String(), 0
+}
+
+// Creates a temporary directory with the given files, and returns its path.
+func createTestDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "poryscript")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	return dir
+}
+
+// Changes the working directory to the given directory, and returns a function
+// that changes it back.
+func changeDir(t *testing.T, dir string) func() {
+	prevDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf(err.Error())
+	}
+	return func() {
+		if err := os.Chdir(prevDir); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+}
+
+func TestGetConfigFlagValues(t *testing.T) {
+	tests := []struct {
+		setting   interface{}
+		flagValue flag.Value
+		expected  []string
+	}{
+		{"pokeemerald", new(stringValue), []string{"pokeemerald"}},
+		{float64(4), new(stringValue), []string{"4"}},
+		{1.5, new(stringValue), []string{"1.5"}},
+		{true, new(stringValue), []string{"true"}},
+		// Arrays of flags that can't be set multiple times are joined.
+		{[]interface{}{"a.inc", "b.inc"}, new(stringValue), []string{"a.inc,b.inc"}},
+		{[]interface{}{"a.pory", "b.pory"}, &listOption{}, []string{"a.pory", "b.pory"}},
+		{[]interface{}{"VERSION=RUBY", "LANGUAGE=GERMAN"}, mapOption{}, []string{"VERSION=RUBY", "LANGUAGE=GERMAN"}},
+		// The entries of objects are sorted by their keys.
+		{map[string]interface{}{"VERSION": "RUBY", "DEBUG": true, "LEVEL": float64(2)}, mapOption{}, []string{"DEBUG=true", "LEVEL=2", "VERSION=RUBY"}},
+		{[]interface{}{}, new(stringValue), []string{""}},
+	}
+	for _, test := range tests {
+		values, err := getConfigFlagValues(test.setting, test.flagValue)
+		if err != nil {
+			t.Errorf("Unexpected error for setting %v: %s", test.setting, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("Incorrect values for setting %v. Expected %q, got %q", test.setting, test.expected, values)
+		}
+	}
+
+	invalidTests := []interface{}{
+		nil,
+		[]interface{}{nil},
+		[]interface{}{[]interface{}{"a", "b"}},
+		map[string]interface{}{"a": nil},
+		map[string]interface{}{"a": []interface{}{"b", "c"}},
+	}
+	for _, setting := range invalidTests {
+		if _, err := getConfigFlagValues(setting, &listOption{}); err == nil {
+			t.Errorf("Expected an error for setting %v", setting)
+		}
+	}
+}
+
+// A flag.Value of a string flag.
+type stringValue string
+
+func (v *stringValue) String() string { return string(*v) }
+
+func (v *stringValue) Set(value string) error {
+	*v = stringValue(value)
+	return nil
+}
+
+func TestParseFlagsConfig(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"poryscript.json": `{
+	"target": "xse",
+	"fw": "config/font_widths.json",
+	"Werror": true,
+	"disable-warnings": ["unused", "end-call"],
+	"s": {"VERSION": "RUBY", "LANGUAGE": "ENGLISH"},
+	"lint": "lint.json",
+	"w": true
+}`,
+	})
+	defer os.RemoveAll(dir)
+	defer changeDir(t, dir)()
+
+	tests := []struct {
+		args             []string
+		expectedTarget   string
+		expectedFonts    string
+		expectedWerror   bool
+		expectedWarnings string
+		expectedSwitches mapOption
+		expectedLint     string
+	}{
+		{
+			args:             nil,
+			expectedTarget:   "xse",
+			expectedFonts:    "config/font_widths.json",
+			expectedWerror:   true,
+			expectedWarnings: "unused,end-call",
+			expectedSwitches: mapOption{"VERSION": "RUBY", "LANGUAGE": "ENGLISH"},
+			expectedLint:     "lint.json",
+		},
+		{
+			// The flags that are given override the config, and the
+			// given switches are merged with the config's switches.
+			args:             []string{"-target", "pokeemerald", "-Werror=false", "-s", "LANGUAGE=GERMAN", "-s", "DEBUG=1"},
+			expectedTarget:   "pokeemerald",
+			expectedFonts:    "config/font_widths.json",
+			expectedWerror:   false,
+			expectedWarnings: "unused,end-call",
+			expectedSwitches: mapOption{"VERSION": "RUBY", "LANGUAGE": "GERMAN", "DEBUG": "1"},
+			expectedLint:     "lint.json",
+		},
+	}
+	for i, test := range tests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		targetPtr := flags.String("target", "pokeemerald", "")
+		// The lint setting is the -config of this flag set.
+		lintPtr := flags.String("config", "", "")
+		parserFlags := addParserFlags(flags)
+		parseFlags(flags, test.args, map[string]string{"lint": "config"})
+		if *targetPtr != test.expectedTarget {
+			t.Errorf("Test %d: Incorrect target. Expected %q, got %q", i, test.expectedTarget, *targetPtr)
+		}
+		if *parserFlags.fontWidthsFilepath != test.expectedFonts {
+			t.Errorf("Test %d: Incorrect font widths. Expected %q, got %q", i, test.expectedFonts, *parserFlags.fontWidthsFilepath)
+		}
+		if *parserFlags.warningsAsErrors != test.expectedWerror {
+			t.Errorf("Test %d: Incorrect Werror. Expected %t, got %t", i, test.expectedWerror, *parserFlags.warningsAsErrors)
+		}
+		if *parserFlags.disabledWarnings != test.expectedWarnings {
+			t.Errorf("Test %d: Incorrect disabled warnings. Expected %q, got %q", i, test.expectedWarnings, *parserFlags.disabledWarnings)
+		}
+		if !reflect.DeepEqual(parserFlags.compileSwitches, test.expectedSwitches) {
+			t.Errorf("Test %d: Incorrect switches. Expected %v, got %v", i, test.expectedSwitches, parserFlags.compileSwitches)
+		}
+		if *lintPtr != test.expectedLint {
+			t.Errorf("Test %d: Incorrect lint config. Expected %q, got %q", i, test.expectedLint, *lintPtr)
+		}
+	}
+}
+
+func TestGetConfigSettingNames(t *testing.T) {
+	names := getConfigSettingNames()
+	// The settings of the compiler, the shared flags, and the other
+	// subcommands.
+	for _, name := range []string{"target", "O2", "fw", "s", "Werror", "verbose", "w", "config", "kinds", "scripts", "lint"} {
+		if !names[name] {
+			t.Errorf("Expected '%s' to be a setting", name)
+		}
+	}
+	if names["tagret"] {
+		t.Errorf("Expected 'tagret' to not be a setting")
+	}
+}
+
+func TestUnknownConfigSetting(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"poryscript.json": `{"tagret": "xse"}`,
+		"script.pory":     "script MyScript {}",
+	})
+	defer os.RemoveAll(dir)
+	stderr, code := runPoryscript(t, dir, "", "-i", "script.pory")
+	if code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+	if !strings.Contains(stderr, "unknown setting 'tagret' in poryscript.json") {
+		t.Errorf("Expected an unknown setting error, got %q", stderr)
+	}
+
+	// The settings of the other subcommands are ignored.
+	dir = createTestDir(t, map[string]string{
+		"poryscript.json": `{"w": true, "kinds": "script"}`,
+		"script.pory":     "script MyScript {}",
+	})
+	defer os.RemoveAll(dir)
+	if stderr, code := runPoryscript(t, dir, "", "-i", "script.pory"); code != 0 {
+		t.Errorf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+}
+
+func TestMatchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"*.pory", "script.pory", true},
+		{"*.pory", "maps/script.pory", false},
+		{"*.pory", "script.inc", false},
+		{"maps/*.pory", "maps/script.pory", true},
+		{"maps/*.pory", "maps/town/script.pory", false},
+		{"**/*.pory", "script.pory", true},
+		{"**/*.pory", "maps/script.pory", true},
+		{"**/*.pory", "maps/town/script.pory", true},
+		{"maps/**/*.pory", "maps/script.pory", true},
+		{"maps/**/*.pory", "maps/town/script.pory", true},
+		{"maps/**/*.pory", "other/script.pory", false},
+		{"**/town/*.pory", "maps/town/script.pory", true},
+		{"**/town/*.pory", "maps/city/script.pory", false},
+		{"**", "maps/town/script.pory", true},
+		{"maps/**", "maps/town/script.pory", true},
+		{"script?.pory", "script1.pory", true},
+		{"[ab].pory", "c.pory", false},
+	}
+	for _, test := range tests {
+		if result := matchPathPattern(strings.Split(test.pattern, "/"), strings.Split(test.path, "/")); result != test.expected {
+			t.Errorf("Incorrect match of pattern '%s' and path '%s'. Expected %t, got %t", test.pattern, test.path, test.expected, result)
+		}
+	}
+}
+
+func TestFindMatchingFiles(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"a.pory":              "",
+		"b.inc":               "",
+		"maps/town.pory":      "",
+		"maps/city/city.pory": "",
+	})
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.pory", []string{"a.pory"}},
+		{"**/*.pory", []string{"a.pory", "maps/city/city.pory", "maps/town.pory"}},
+		{"maps/**/*.pory", []string{"maps/city/city.pory", "maps/town.pory"}},
+		{"**/*.txt", nil},
+	}
+	for _, test := range tests {
+		matches, err := findMatchingFiles(dir, strings.Split(test.pattern, "/"))
+		if err != nil {
+			t.Errorf("Unexpected error for pattern '%s': %s", test.pattern, err.Error())
+			continue
+		}
+		var expected []string
+		for _, match := range test.expected {
+			expected = append(expected, filepath.FromSlash(match))
+		}
+		if !reflect.DeepEqual(matches, expected) {
+			t.Errorf("Incorrect matches of pattern '%s'. Expected %q, got %q", test.pattern, expected, matches)
+		}
+	}
+	if _, err := findMatchingFiles(dir, []string{"[a"}); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+}
+
+func TestExpandInputFilepaths(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"scripts/a.pory":        "",
+		"scripts/maps/b.pory":   "",
+		"scripts/maps/c.inc":    "",
+		"other/single.pory":     "",
+		"other/maps/town.pory":  "",
+		"other/maps/city.pory":  "",
+		"other/maps/notes.txt":  "",
+		"other/empty/notes.txt": "",
+	})
+	defer os.RemoveAll(dir)
+	path := func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+
+	tests := []struct {
+		inputs           []string
+		outputs          []string
+		expectedInputs   []string
+		expectedOutputs  []string
+		expectedMirrored []bool
+	}{
+		{
+			// A single file without an output writes to standard output.
+			inputs:           []string{path("other/single.pory")},
+			outputs:          nil,
+			expectedInputs:   []string{path("other/single.pory")},
+			expectedOutputs:  nil,
+			expectedMirrored: nil,
+		},
+		{
+			inputs:           []string{path("other/single.pory")},
+			outputs:          []string{path("build/single.inc")},
+			expectedInputs:   []string{path("other/single.pory")},
+			expectedOutputs:  []string{path("build/single.inc")},
+			expectedMirrored: []bool{false},
+		},
+		{
+			// A directory matches its .pory files, and its output directory
+			// mirrors their directories.
+			inputs:           []string{path("scripts"), path("other/single.pory")},
+			outputs:          []string{path("build/scripts"), path("build/single.inc")},
+			expectedInputs:   []string{path("scripts/a.pory"), path("scripts/maps/b.pory"), path("other/single.pory")},
+			expectedOutputs:  []string{path("build/scripts/a.inc"), path("build/scripts/maps/b.inc"), path("build/single.inc")},
+			expectedMirrored: []bool{true, true, false},
+		},
+		{
+			// The output directory of a pattern mirrors the directories below
+			// the part of the pattern before its first wildcard.
+			inputs:           []string{path("other/**/*.pory")},
+			outputs:          []string{path("build")},
+			expectedInputs:   []string{path("other/maps/city.pory"), path("other/maps/town.pory"), path("other/single.pory")},
+			expectedOutputs:  []string{path("build/maps/city.inc"), path("build/maps/town.inc"), path("build/single.inc")},
+			expectedMirrored: []bool{true, true, true},
+		},
+		{
+			// A pattern can match a single file.
+			inputs:           []string{path("other/maps/t*.pory")},
+			outputs:          []string{path("build")},
+			expectedInputs:   []string{path("other/maps/town.pory")},
+			expectedOutputs:  []string{path("build/town.inc")},
+			expectedMirrored: []bool{true},
+		},
+	}
+	for i, test := range tests {
+		inputs, outputs, mirrored, err := expandInputFilepaths(test.inputs, test.outputs)
+		if err != nil {
+			t.Errorf("Test %d: Unexpected error: %s", i, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(inputs, test.expectedInputs) {
+			t.Errorf("Test %d: Incorrect inputs. Expected %q, got %q", i, test.expectedInputs, inputs)
+		}
+		if !reflect.DeepEqual(outputs, test.expectedOutputs) {
+			t.Errorf("Test %d: Incorrect outputs. Expected %q, got %q", i, test.expectedOutputs, outputs)
+		}
+		if !reflect.DeepEqual(mirrored, test.expectedMirrored) {
+			t.Errorf("Test %d: Incorrect mirrored outputs. Expected %v, got %v", i, test.expectedMirrored, mirrored)
+		}
+	}
+
+	errorTests := []struct {
+		inputs        []string
+		outputs       []string
+		expectedError string
+	}{
+		{[]string{path("scripts")}, nil, "needs an -o output directory"},
+		{[]string{path("scripts/*.pory")}, []string{""}, "needs an -o output directory"},
+		{[]string{path("other/empty")}, []string{path("build")}, "no files match"},
+		{[]string{path("other/**/*.txt"), path("scripts/[a")}, []string{path("build"), path("build")}, "invalid -i pattern"},
+	}
+	for i, test := range errorTests {
+		_, _, _, err := expandInputFilepaths(test.inputs, test.outputs)
+		if err == nil {
+			t.Errorf("Error test %d: Expected an error", i)
+		} else if !strings.Contains(err.Error(), test.expectedError) {
+			t.Errorf("Error test %d: Expected an error containing %q, got %q", i, test.expectedError, err.Error())
+		}
+	}
+}
+
+func TestCompileInputPatterns(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"scripts/maps/town.pory": "script Town {}",
+		"single.pory":            "script Single {}",
+	})
+	defer os.RemoveAll(dir)
+
+	// A single -i without an -o writes to standard output.
+	if stderr, code := runPoryscript(t, dir, "", "-i", "single.pory"); code != 0 {
+		t.Errorf("Expected exit code 0 for a single -i, got %d: %s", code, stderr)
+	}
+	// The missing directories of the mirrored outputs are created, even when
+	// a pattern matches a single file.
+	if stderr, code := runPoryscript(t, dir, "", "-i", "scripts/**/*.pory", "-o", "build"); code != 0 {
+		t.Errorf("Expected exit code 0 for a pattern -i, got %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "build", "maps", "town.inc")); err != nil {
+		t.Errorf("Expected the mirrored output to be written: %s", err.Error())
+	}
+	// The directories of the other outputs aren't created.
+	if _, code := runPoryscript(t, dir, "", "-i", "single.pory", "-o", filepath.Join("missing", "single.inc")); code != exitIOError {
+		t.Errorf("Expected exit code %d for an output in a missing directory, got %d", exitIOError, code)
+	}
+}
+
+func TestSplitBatchLine(t *testing.T) {
+	tests := []struct {
+		line           string
+		expectedInput  string
+		expectedOutput string
+		expectedOk     bool
+	}{
+		{"data/scripts/foo.pory:data/scripts/foo.inc", "data/scripts/foo.pory", "data/scripts/foo.inc", true},
+		{" foo.pory : foo.inc ", "foo.pory", "foo.inc", true},
+		{`C:\a.pory:C:\b.inc`, `C:\a.pory`, `C:\b.inc`, true},
+		{"C:/a.pory:b.inc", "C:/a.pory", "b.inc", true},
+		{`a.pory:C:\b.inc`, "a.pory", `C:\b.inc`, true},
+		{"scripts/**/*.pory:build", "scripts/**/*.pory", "build", true},
+		// A drive letter without a separator isn't a drive.
+		{"C:a.pory", "C", "a.pory", true},
+		{"foo.pory", "", "", false},
+		{"foo.pory:", "foo.pory", "", false},
+		{":foo.inc", "", "foo.inc", false},
+		{`C:\a.pory`, "", "", false},
+	}
+	for _, test := range tests {
+		input, output, ok := splitBatchLine(test.line)
+		if ok != test.expectedOk || (ok && (input != test.expectedInput || output != test.expectedOutput)) {
+			t.Errorf("Incorrect split of line '%s'. Expected (%q, %q, %t), got (%q, %q, %t)", test.line, test.expectedInput, test.expectedOutput, test.expectedOk, input, output, ok)
+		}
+	}
+}
+
+func TestIsDriveColon(t *testing.T) {
+	tests := []struct {
+		line     string
+		i        int
+		expected bool
+	}{
+		{`C:\a.pory:b.inc`, 1, true},
+		{"c:/a.pory:b.inc", 1, true},
+		{`C:\a.pory:b.inc`, 9, false},
+		{"C:a.pory", 1, false},
+		{"C:", 1, false},
+		{`1:\a.pory`, 1, false},
+		{`ab:\a.pory`, 2, false},
+	}
+	for _, test := range tests {
+		if result := isDriveColon(test.line, test.i); result != test.expected {
+			t.Errorf("Incorrect drive colon at %d of line '%s'. Expected %t, got %t", test.i, test.line, test.expected, result)
+		}
+	}
+}
+
+func TestReadBatchFilepaths(t *testing.T) {
+	input := "a.pory:a.inc\n\n   \r\n" + `C:\b.pory:C:\b.inc` + "\r\nscripts/**/*.pory:build\n"
+	inputs, outputs, err := readBatchFilepaths(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expectedInputs := []string{"a.pory", `C:\b.pory`, "scripts/**/*.pory"}
+	expectedOutputs := []string{"a.inc", `C:\b.inc`, "build"}
+	if !reflect.DeepEqual(inputs, expectedInputs) {
+		t.Errorf("Incorrect inputs. Expected %q, got %q", expectedInputs, inputs)
+	}
+	if !reflect.DeepEqual(outputs, expectedOutputs) {
+		t.Errorf("Incorrect outputs. Expected %q, got %q", expectedOutputs, outputs)
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"a.pory:a.inc\n\nb.pory\n", "line 3: expected an 'input:output' pair, but got 'b.pory'"},
+		{"a.pory:\n", "line 1: expected an 'input:output' pair"},
+		{"", "no files were given"},
+		{"\n  \n", "no files were given"},
+	}
+	for _, test := range errorTests {
+		_, _, err := readBatchFilepaths(strings.NewReader(test.input))
+		if err == nil {
+			t.Errorf("Expected an error for input %q", test.input)
+		} else if !strings.Contains(err.Error(), test.expectedError) {
+			t.Errorf("Expected an error containing %q for input %q, got %q", test.expectedError, test.input, err.Error())
+		}
+	}
+}
+
+func TestCompileBatch(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"a.pory":                 "script A {}",
+		"scripts/maps/town.pory": "script Town {}",
+	})
+	defer os.RemoveAll(dir)
+	stdin := "a.pory:a.inc\n\nscripts/**/*.pory:build\n"
+	if stderr, code := runPoryscript(t, dir, stdin, "-batch"); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	for _, output := range []string{"a.inc", filepath.Join("build", "maps", "town.inc")} {
+		if _, err := os.Stat(filepath.Join(dir, output)); err != nil {
+			t.Errorf("Expected output %s to be written: %s", output, err.Error())
+		}
+	}
+	if _, code := runPoryscript(t, dir, "a.pory\n", "-batch"); code != exitUsage {
+		t.Errorf("Expected exit code %d for a line without an output, got %d", exitUsage, code)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"valid.pory":     "script MyScript {\n\tmsgbox(\"Hello\")\n}\n",
+		"valid.inc":      "MyScript::\n\tend\n",
+		"syntax.pory":    "script MyScript {\n}}\n",
+		"lex.pory":       "script MyScript {\n\tmsgbox(\"Hello\n}\n",
+		"duplicate.pory": "script MyScript(a, a) {}\n",
+		"warning.pory":   "script(local) Unused {}\n",
+		"unknown.pory":   "script MyScript {\n\tunknowncommand\n}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		args         []string
+		expectedCode int
+	}{
+		{[]string{"-i", "valid.pory"}, 0},
+		{[]string{"check", "valid.pory"}, 0},
+		{[]string{"-diff", "-i", "valid.pory", "-o", "valid.inc"}, exitFailure},
+		{[]string{"-unknown-flag"}, exitUsage},
+		{[]string{"-q", "-verbose", "-i", "valid.pory"}, exitUsage},
+		{[]string{"-i", "valid.pory", "-macros", "missing.inc"}, exitUsage},
+		{[]string{"-i", "valid.pory", "-lint", "missing.json"}, exitUsage},
+		{[]string{"-i", "syntax.pory"}, exitParseError},
+		{[]string{"-i", "lex.pory"}, exitParseError},
+		{[]string{"-i", "duplicate.pory"}, exitSemanticError},
+		{[]string{"check", "duplicate.pory"}, exitSemanticError},
+		{[]string{"-i", "warning.pory"}, 0},
+		{[]string{"-Werror", "-i", "warning.pory"}, exitSemanticError},
+		{[]string{"-i", "missing.pory"}, exitIOError},
+		{[]string{"-i", "valid.pory", "-o", filepath.Join("missing", "valid.inc")}, exitIOError},
+		{[]string{"-target", "bin", "-i", "unknown.pory"}, exitEmitError},
+		{[]string{"lint", "valid.pory"}, 0},
+		{[]string{"lint", "syntax.pory"}, exitParseError},
+		{[]string{"lint", "-Werror", "warning.pory"}, exitSemanticError},
+		{[]string{"lint", "missing.pory"}, exitIOError},
+		{[]string{"lint", "-config", "missing.json", "valid.pory"}, exitUsage},
+		{[]string{"fmt", "valid.pory"}, 0},
+		{[]string{"fmt", "syntax.pory"}, exitParseError},
+		{[]string{"fmt", "missing.pory"}, exitIOError},
+	}
+	for _, test := range tests {
+		stderr, code := runPoryscript(t, dir, "", test.args...)
+		if code != test.expectedCode {
+			t.Errorf("Incorrect exit code of 'poryscript %s'. Expected %d, got %d: %s", strings.Join(test.args, " "), test.expectedCode, code, stderr)
+		}
+	}
+}
+
+func TestDiagnosticFilepaths(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"warning.pory": "script(local) Unused {}\n",
+		"syntax.pory":  "script MyScript {\n}}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		args             []string
+		expectedMessages []string
+	}{
+		{[]string{"-i", "warning.pory"}, []string{"PORYSCRIPT WARNING: warning.pory: line 1:15: "}},
+		{[]string{"-Werror", "-i", "warning.pory"}, []string{"PORYSCRIPT ERROR: warning.pory: line 1:15: "}},
+		{[]string{"-i", "syntax.pory"}, []string{"PORYSCRIPT ERROR: syntax.pory: line 2:2: "}},
+		{[]string{"-i", "warning.pory", "-i", "syntax.pory", "-o", "warning.inc", "-o", "syntax.inc"}, []string{
+			"PORYSCRIPT WARNING: warning.pory: line 1:15: ",
+			"PORYSCRIPT ERROR: syntax.pory: line 2:2: ",
+		}},
+	}
+	for _, test := range tests {
+		stderr, _ := runPoryscript(t, dir, "", test.args...)
+		for _, message := range test.expectedMessages {
+			if !strings.Contains(stderr, message) {
+				t.Errorf("Expected the output of 'poryscript %s' to contain %q, got: %s", strings.Join(test.args, " "), message, stderr)
+			}
+		}
+	}
+}
+
+func TestGetErrorExitCode(t *testing.T) {
+	tests := []struct {
+		err          error
+		expectedCode int
+	}{
+		{&parser.ParseError{Code: parser.ErrorLex}, exitParseError},
+		{&parser.ParseError{Code: parser.ErrorSyntax}, exitParseError},
+		{&parser.ParseError{Code: parser.ErrorDuplicate}, exitSemanticError},
+		{&parser.ParseError{Code: parser.ErrorWarnings}, exitSemanticError},
+		{fmt.Errorf("file.pory: %w", &parser.ParseError{Code: parser.ErrorSyntax}), exitParseError},
+		{&os.PathError{Op: "open", Path: "file.pory", Err: os.ErrNotExist}, exitIOError},
+		{fmt.Errorf("file.pory: %w", emitter.ErrEmit), exitEmitError},
+		{errors.New("other error"), exitFailure},
+	}
+	for _, test := range tests {
+		if code := getErrorExitCode(test.err); code != test.expectedCode {
+			t.Errorf("Incorrect exit code of error '%s'. Expected %d, got %d", test.err, test.expectedCode, code)
+		}
+	}
+}
+
+func TestGetCompilationArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"-watch", "-i", "a.pory", "-o", "a.inc"}, []string{"-watch=false", "-i", "a.pory", "-o", "a.inc"}},
+		{[]string{"-i", "a.pory", "--watch=true"}, []string{"-watch=false", "-i", "a.pory"}},
+		{[]string{"compile", "-watch", "a.pory"}, []string{"compile", "-watch=false", "a.pory"}},
+		{[]string{"check", "-watch", "a.pory"}, []string{"check", "-watch=false", "a.pory"}},
+	}
+	for _, test := range tests {
+		if result := getCompilationArgs(test.args); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Incorrect compilation args of %q. Expected %q, got %q", test.args, test.expected, result)
+		}
+	}
+}
+
+func TestCommandLineConfigSettings(t *testing.T) {
+	for _, name := range []string{"h", "v", "batch", "check", "diff", "watch"} {
+		dir := createTestDir(t, map[string]string{
+			"poryscript.json": fmt.Sprintf(`{"%s": true}`, name),
+			"script.pory":     "script MyScript {}",
+		})
+		defer os.RemoveAll(dir)
+		stderr, code := runPoryscript(t, dir, "", "-i", "script.pory")
+		if code != exitUsage {
+			t.Errorf("Expected exit code %d for setting '%s', got %d", exitUsage, name, code)
+		}
+		if expected := fmt.Sprintf("'%s' can't be set in poryscript.json", name); !strings.Contains(stderr, expected) {
+			t.Errorf("Expected an error about the setting '%s', got %q", name, stderr)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/token"
+)
+
+// AutoEndConfig configures the pass that ends scripts which fall off the
+// end of their body without ending. It also warns about locks that aren't
+// released before a script ends.
+type AutoEndConfig struct {
+	// Commands that lock the player and other objects, like "lock".
+	LockCommands []string
+	// Commands that release the locks, like "release". The first one is
+	// added to scripts that can still be locked when they fall off their end.
+	ReleaseCommands []string
+	// The command that is added to end scripts, like "end".
+	EndCommand string
+}
+
+// DefaultAutoEndConfig is the config of the pokeemerald commands.
+var DefaultAutoEndConfig = AutoEndConfig{
+	LockCommands:    []string{"lock", "lockall", "faceplayer"},
+	ReleaseCommands: []string{"release", "releaseall"},
+	EndCommand:      "end",
+}
+
+// The state of the paths through a script. A path is locked after a lock
+// command, until it is released.
+type lockState struct {
+	// The lock command of a path that is still locked, or nil if every
+	// path is released.
+	lock *ast.CommandStatement
+}
+
+func mergeLockStates(states ...lockState) lockState {
+	for _, state := range states {
+		if state.lock != nil {
+			return state
+		}
+	}
+	return lockState{}
+}
+
+// Follows the paths through the statements of a script, to find where they
+// can still be locked.
+type lockAnalysis struct {
+	config *AutoEndConfig
+	// The states of the paths that break out of, or continue, each loop
+	// and switch statement.
+	breaks    map[ast.Statement][]lockState
+	continues map[ast.Statement][]lockState
+	// The lock commands that are still locked when a path ends.
+	unreleased []*ast.CommandStatement
+}
+
+// Returns the state after the statements, and whether a path falls through
+// to the statement after them.
+func (a *lockAnalysis) analyzeBlock(statements []ast.Statement, state lockState) (lockState, bool) {
+	for _, stmt := range statements {
+		var fallsThrough bool
+		state, fallsThrough = a.analyzeStatement(stmt, state)
+		if !fallsThrough {
+			return state, false
+		}
+	}
+	return state, true
+}
+
+func (a *lockAnalysis) analyzeStatement(stmt ast.Statement, state lockState) (lockState, bool) {
+	switch s := stmt.(type) {
+	case *ast.CommandStatement:
+		name := s.Name.Value
+		switch {
+		case containsString(a.config.LockCommands, name):
+			return lockState{lock: s}, true
+		case containsString(a.config.ReleaseCommands, name):
+			return lockState{}, true
+		case name == "end" || name == a.config.EndCommand:
+			if state.lock != nil {
+				a.unreleased = append(a.unreleased, state.lock)
+			}
+			return state, false
+		case terminatingCommands[name]:
+			// The script returns to its caller, or continues in another
+			// script, which can release the lock.
+			return state, false
+		}
+		return state, true
+	case *ast.IfStatement:
+		var outs []lockState
+		blocks := []*ast.BlockStatement{s.Consequence.Body}
+		for _, elif := range s.ElifConsequences {
+			blocks = append(blocks, elif.Body)
+		}
+		if s.ElseConsequence != nil {
+			blocks = append(blocks, s.ElseConsequence)
+		} else {
+			outs = append(outs, state)
+		}
+		for _, block := range blocks {
+			if out, fallsThrough := a.analyzeBlock(block.Statements, state); fallsThrough {
+				outs = append(outs, out)
+			}
+		}
+		return mergeLockStates(outs...), len(outs) > 0
+	case *ast.WhileStatement:
+		outs := append(a.analyzeLoop(s, s.Consequence.Body, state), state)
+		return mergeLockStates(outs...), true
+	case *ast.DoWhileStatement:
+		outs := a.analyzeLoop(s, s.Consequence.Body, state)
+		return mergeLockStates(outs...), len(outs) > 0
+	case *ast.SwitchStatement:
+		var outs []lockState
+		cases := s.Cases
+		if s.DefaultCase != nil {
+			cases = append(append([]*ast.SwitchCase{}, cases...), s.DefaultCase)
+		} else {
+			outs = append(outs, state)
+		}
+		for _, switchCase := range cases {
+			if out, fallsThrough := a.analyzeBlock(switchCase.Body.Statements, state); fallsThrough {
+				outs = append(outs, out)
+			}
+		}
+		outs = append(outs, a.breaks[s]...)
+		return mergeLockStates(outs...), len(outs) > 0
+	case *ast.BreakStatement:
+		a.breaks[s.ScopeStatment] = append(a.breaks[s.ScopeStatment], state)
+		return state, false
+	case *ast.ContinueStatement:
+		a.continues[s.LoopStatment] = append(a.continues[s.LoopStatment], state)
+		return state, false
+	}
+	return state, true
+}
+
+// Returns the states of the paths that leave a loop's body, other than
+// the path that never runs it.
+func (a *lockAnalysis) analyzeLoop(loop ast.Statement, body *ast.BlockStatement, state lockState) []lockState {
+	var outs []lockState
+	if out, fallsThrough := a.analyzeBlock(body.Statements, state); fallsThrough {
+		outs = append(outs, out)
+	}
+	outs = append(outs, a.continues[loop]...)
+	return append(outs, a.breaks[loop]...)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Ends the scripts that fall off the end of their body, and releases them
+// first if they can still be locked. Scripts that are called by other
+// scripts are skipped. Warns about locks that aren't released before an
+// end command.
+func (p *Parser) autoEnd(program *ast.Program) {
+	if p.autoEndConfig == nil {
+		return
+	}
+	config := p.autoEndConfig
+	called := getCalledScripts(program)
+	for _, script := range getScripts(program) {
+		analysis := &lockAnalysis{
+			config:    config,
+			breaks:    make(map[ast.Statement][]lockState),
+			continues: make(map[ast.Statement][]lockState),
+		}
+		state, fallsThrough := analysis.analyzeBlock(script.Body.Statements, lockState{})
+		warned := make(map[*ast.CommandStatement]bool)
+		for _, lock := range analysis.unreleased {
+			if !warned[lock] {
+				warned[lock] = true
+				p.addWarning(WarningUnreleased, lock.Token.Pos(), fmt.Sprintf("'%s' in script '%s' isn't released on every path before the script ends", lock.Name.Value, script.Name.Value))
+			}
+		}
+		if _, ok := called[script.Name.Value]; !fallsThrough || ok {
+			continue
+		}
+		if state.lock != nil && len(config.ReleaseCommands) > 0 {
+			script.Body.Statements = append(script.Body.Statements, newGeneratedCommand(config.ReleaseCommands[0], script))
+		}
+		script.Body.Statements = append(script.Body.Statements, newGeneratedCommand(config.EndCommand, script))
+	}
+}
+
+// Creates a command that is added to the end of a script's body. It is
+// located at the script's closing brace.
+func newGeneratedCommand(name string, script *ast.ScriptStatement) *ast.CommandStatement {
+	tok := token.Token{
+		Type:       token.IDENT,
+		Literal:    name,
+		Filepath:   script.Token.Filepath,
+		LineNumber: script.EndPos.Line,
+		Column:     script.EndPos.Column,
+		Offset:     script.EndPos.Offset,
+		End:        script.EndPos,
+	}
+	return &ast.CommandStatement{
+		Token:  tok,
+		Name:   &ast.Identifier{Token: tok, Value: name},
+		EndPos: script.EndPos,
+	}
+}
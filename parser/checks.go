@@ -0,0 +1,497 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Calls fn for every statement in the given list of statements, including
+// the statements nested inside of branching and looping statements.
+func walkStatements(statements []ast.Statement, fn func(ast.Statement)) {
+	for _, stmt := range statements {
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			if s, ok := node.(ast.Statement); ok {
+				if _, isBlock := s.(*ast.BlockStatement); !isBlock {
+					fn(s)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// Calls fn for every block of statements in the given list of statements,
+// including the given list itself.
+func walkBlocks(statements []ast.Statement, fn func([]ast.Statement)) {
+	fn(statements)
+	for _, stmt := range statements {
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			if block, ok := node.(*ast.BlockStatement); ok {
+				fn(block.Statements)
+			}
+			return true
+		})
+	}
+}
+
+// Returns every script in the program, including the inline scripts
+// defined inside of mapscripts statements.
+func getScripts(program *ast.Program) []*ast.ScriptStatement {
+	scripts := []*ast.ScriptStatement{}
+	for _, stmt := range program.TopLevelStatements {
+		switch s := stmt.(type) {
+		case *ast.ScriptStatement:
+			scripts = append(scripts, s)
+		case *ast.MapScriptsStatement:
+			for _, mapScript := range s.MapScripts {
+				if mapScript.Script != nil {
+					scripts = append(scripts, mapScript.Script)
+				}
+			}
+			for _, tableMapScript := range s.TableMapScripts {
+				for _, entry := range tableMapScript.Entries {
+					if entry.Script != nil {
+						scripts = append(scripts, entry.Script)
+					}
+				}
+			}
+		}
+	}
+	return scripts
+}
+
+// Returns the label name of a named top-level statement.
+func getStatementName(stmt ast.Statement) (string, bool) {
+	name := getStatementIdentifier(stmt)
+	if name == nil {
+		return "", false
+	}
+	return name.Value, true
+}
+
+// Returns the label identifier of a named top-level statement.
+func getStatementIdentifier(stmt ast.Statement) *ast.Identifier {
+	switch s := stmt.(type) {
+	case *ast.ScriptStatement:
+		return s.Name
+	case *ast.TextStatement:
+		return s.Name
+	case *ast.MovementStatement:
+		return s.Name
+	case *ast.MartStatement:
+		return s.Name
+	case *ast.DataStatement:
+		return s.Name
+	case *ast.AliasStatement:
+		return s.Name
+	case *ast.MapScriptsStatement:
+		return s.Name
+	}
+	return nil
+}
+
+// Describes where a top-level label is defined.
+type labelLocation struct {
+	filepath string
+	pos      token.Position
+	isGlobal bool
+}
+
+func (loc labelLocation) String() string {
+	if loc.filepath == "" {
+		return fmt.Sprintf("line %d:%d", loc.pos.Line, loc.pos.Column)
+	}
+	return fmt.Sprintf("%s: line %d:%d", loc.filepath, loc.pos.Line, loc.pos.Column)
+}
+
+// Returns the location of a named top-level statement defined in the given file.
+func getLabelLocation(stmt ast.Statement, filepath string) labelLocation {
+	return labelLocation{
+		filepath: filepath,
+		pos:      getStatementIdentifier(stmt).Pos(),
+		isGlobal: isGlobalStatement(stmt),
+	}
+}
+
+// Two definitions of the same label clash, unless both of them are local
+// to their own files.
+func isDuplicateLabel(a labelLocation, b labelLocation) bool {
+	return a.filepath != b.filepath && (a.isGlobal || b.isGlobal)
+}
+
+// Reports an error if a named top-level statement in the program has the same
+// label as a statement in one of the imported files.
+func (p *Parser) checkImportedLabels(program *ast.Program) error {
+	for _, stmt := range program.TopLevelStatements {
+		name, ok := getStatementName(stmt)
+		if !ok {
+			continue
+		}
+		location := getLabelLocation(stmt, p.filepath)
+		if existing, ok := p.importedLabels[name]; ok && isDuplicateLabel(location, existing) {
+			err := parseErrorf(ErrorDuplicate, location.pos, "duplicate label '%s', which is already defined at %s", name, existing)
+			return setErrorFilepath(err, location.filepath)
+		}
+	}
+	return nil
+}
+
+// Returns whether a named top-level statement is visible to other files.
+func isGlobalStatement(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.ScriptStatement:
+		return s.Scope == token.GLOBAL
+	case *ast.TextStatement:
+		return s.Scope == token.GLOBAL
+	case *ast.MovementStatement:
+		return s.Scope == token.GLOBAL
+	case *ast.MartStatement:
+		return s.Scope == token.GLOBAL
+	case *ast.DataStatement:
+		return s.Scope == token.GLOBAL
+	case *ast.AliasStatement:
+		return s.Scope == token.GLOBAL
+	case *ast.MapScriptsStatement:
+		return s.Scope == token.GLOBAL
+	}
+	return false
+}
+
+// Warns about references to top-level statements that are annotated
+// with @deprecated. The statements can be defined by the program, or by
+// the files that it imports. The program's own statements take precedence
+// over the imported ones.
+func (p *Parser) checkDeprecatedReferences(program *ast.Program) {
+	deprecated := make(map[string]ast.Annotation)
+	for name, annotation := range p.importedDeprecations {
+		deprecated[name] = annotation
+	}
+	for _, stmt := range program.TopLevelStatements {
+		name, ok := getStatementName(stmt)
+		if !ok {
+			continue
+		}
+		if annotation, ok := ast.AnnotationsOf(stmt).Get("deprecated"); ok {
+			deprecated[name] = annotation
+		} else {
+			delete(deprecated, name)
+		}
+	}
+	p.warnDeprecatedReferences(program, deprecated)
+}
+
+// Warns about the references of the program's scripts and mapscripts to
+// the given deprecated labels.
+func (p *Parser) warnDeprecatedReferences(program *ast.Program, deprecated map[string]ast.Annotation) {
+	if len(deprecated) == 0 {
+		return
+	}
+
+	for _, script := range getScripts(program) {
+		walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+			command, ok := stmt.(*ast.CommandStatement)
+			if !ok {
+				return
+			}
+			for _, arg := range command.Args {
+				annotation, ok := deprecated[arg]
+				if !ok || arg == script.Name.Value {
+					continue
+				}
+				p.addWarning(WarningDeprecated, command.Token.Pos(), fmt.Sprintf("'%s' in script '%s' references deprecated '%s'%s", command.Name.Value, script.Name.Value, arg, getDeprecationSuffix(annotation)))
+			}
+		})
+	}
+
+	for _, stmt := range program.TopLevelStatements {
+		mapScriptsStmt, ok := stmt.(*ast.MapScriptsStatement)
+		if !ok {
+			continue
+		}
+		for _, mapScript := range mapScriptsStmt.MapScripts {
+			if annotation, ok := deprecated[mapScript.Name]; ok {
+				p.addWarning(WarningDeprecated, mapScript.Token.Pos(), fmt.Sprintf("mapscripts '%s' references deprecated '%s'%s", mapScriptsStmt.Name.Value, mapScript.Name, getDeprecationSuffix(annotation)))
+			}
+		}
+		for _, tableMapScript := range mapScriptsStmt.TableMapScripts {
+			for _, entry := range tableMapScript.Entries {
+				if annotation, ok := deprecated[entry.Name]; ok {
+					p.addWarning(WarningDeprecated, entry.Token.Pos(), fmt.Sprintf("mapscripts '%s' references deprecated '%s'%s", mapScriptsStmt.Name.Value, entry.Name, getDeprecationSuffix(annotation)))
+				}
+			}
+		}
+	}
+}
+
+// Warns about if, elif, else, and loop bodies that contain no statements,
+// which usually indicates an accidentally-deleted block or misplaced brace.
+func (p *Parser) checkEmptyBodies(program *ast.Program) {
+	for _, script := range getScripts(program) {
+		warn := func(pos token.Position, kind string) {
+			p.addWarning(WarningEmptyBody, pos, fmt.Sprintf("empty '%s' body in script '%s'", kind, script.Name.Value))
+		}
+		walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+			switch s := stmt.(type) {
+			case *ast.IfStatement:
+				if len(s.Consequence.Body.Statements) == 0 {
+					warn(s.Token.Pos(), "if")
+				}
+				for _, elif := range s.ElifConsequences {
+					if len(elif.Body.Statements) == 0 {
+						warn(elif.Body.Token.Pos(), "elif")
+					}
+				}
+				if s.ElseConsequence != nil && len(s.ElseConsequence.Statements) == 0 {
+					warn(s.ElseConsequence.Token.Pos(), "else")
+				}
+			case *ast.WhileStatement:
+				if len(s.Consequence.Body.Statements) == 0 {
+					warn(s.Token.Pos(), "while")
+				}
+			case *ast.DoWhileStatement:
+				if len(s.Consequence.Body.Statements) == 0 {
+					warn(s.Token.Pos(), "do")
+				}
+			}
+		})
+	}
+}
+
+// Commands that unconditionally stop execution from continuing to the
+// next statement.
+var terminatingCommands = map[string]bool{
+	"end":    true,
+	"return": true,
+	"goto":   true,
+}
+
+// Warns about statements that follow an end, return, or unconditional goto
+// in the same block, since they can never be executed. The warning is at the
+// first of those statements.
+func (p *Parser) checkUnreachableCode(program *ast.Program) {
+	for _, script := range getScripts(program) {
+		walkBlocks(script.Body.Statements, func(statements []ast.Statement) {
+			for i, stmt := range statements {
+				command, ok := stmt.(*ast.CommandStatement)
+				if !ok || !terminatingCommands[command.Name.Value] || i == len(statements)-1 {
+					continue
+				}
+				unreachable := statements[i+1:]
+				firstLine := unreachable[0].Pos().Line
+				lastLine := unreachable[len(unreachable)-1].Pos().Line
+				lines := fmt.Sprintf("line %d", firstLine)
+				if lastLine > firstLine {
+					lines = fmt.Sprintf("lines %d-%d", firstLine, lastLine)
+				}
+				p.addWarning(WarningUnreachable, unreachable[0].Pos(), fmt.Sprintf("unreachable code after '%s' in script '%s' (%s)", command.Name.Value, script.Name.Value, lines))
+				return
+			}
+		})
+	}
+}
+
+// Returns the names of the scripts that are called by other scripts, like
+// "call MyScript", and the name of the first script that calls each of them.
+func getCalledScripts(program *ast.Program) map[string]string {
+	called := make(map[string]string)
+	for _, script := range getScripts(program) {
+		walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+			command, ok := stmt.(*ast.CommandStatement)
+			if !ok || !strings.HasPrefix(command.Name.Value, "call") {
+				return
+			}
+			for _, arg := range command.Args {
+				if _, ok := called[arg]; !ok {
+					called[arg] = script.Name.Value
+				}
+			}
+		})
+	}
+	return called
+}
+
+// Warns about "end" commands in scripts that are called by other scripts.
+// "end" stops the whole script, so the caller never continues after the
+// call, which usually leaves the player frozen. If the parser fixes call
+// ends, they are replaced with "return" instead.
+func (p *Parser) checkCalledScriptEnds(program *ast.Program) {
+	called := getCalledScripts(program)
+	for _, script := range getScripts(program) {
+		caller, ok := called[script.Name.Value]
+		if !ok {
+			continue
+		}
+		walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+			command, ok := stmt.(*ast.CommandStatement)
+			if !ok || command.Name.Value != "end" {
+				return
+			}
+			if p.fixCallEnds {
+				command.Token.Literal = "return"
+				command.Name.Value = "return"
+				return
+			}
+			p.addWarning(WarningCallEnd, command.Token.Pos(), fmt.Sprintf("script '%s' is called by script '%s', but it uses 'end' instead of 'return', so '%s' never continues after the call", script.Name.Value, caller, caller))
+		})
+	}
+}
+
+// Reports an error for the first command whose arguments don't match the
+// signature of its macro. Commands without a known signature are skipped.
+func (p *Parser) checkCommandArgs(program *ast.Program) error {
+	if p.commandSignatures == nil {
+		return nil
+	}
+	labels := make(map[string]bool)
+	for _, stmt := range program.TopLevelStatements {
+		if name, ok := getStatementName(stmt); ok {
+			labels[name] = true
+		}
+	}
+	for _, text := range program.Texts {
+		labels[text.Name] = true
+	}
+	for name := range p.importedLabels {
+		labels[name] = true
+	}
+	var err error
+	for _, script := range getScripts(program) {
+		walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+			if command, ok := stmt.(*ast.CommandStatement); ok && err == nil {
+				err = p.checkCommand(command, labels)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Parser) checkCommand(command *ast.CommandStatement, labels map[string]bool) error {
+	signature, ok := p.commandSignatures[command.Name.Value]
+	if !ok {
+		return nil
+	}
+	locations, ok := p.commandArgs[command]
+	if !ok {
+		// The command was generated by the parser, like the setvars of a
+		// parameterized call.
+		return nil
+	}
+	if len(command.Args) < signature.MinArgs() {
+		param := signature.Params[len(command.Args)]
+		return tokenErrorf(ErrorArguments, locations.end, "missing argument '%s' for command '%s'. Usage: %s", param.Name, command.Name.Value, signature)
+	}
+	if maxArgs := signature.MaxArgs(); maxArgs != -1 && len(command.Args) > maxArgs {
+		return tokenErrorf(ErrorArguments, locations.args[maxArgs].token, "too many arguments for command '%s'. Expected at most %d, but got %d. Usage: %s", command.Name.Value, maxArgs, len(command.Args), signature)
+	}
+	for i, arg := range command.Args {
+		param := signature.Params[len(signature.Params)-1]
+		if i < len(signature.Params) {
+			param = signature.Params[i]
+		}
+		if param.Kind != ParamNumber {
+			continue
+		}
+		location := locations.args[i]
+		if location.isText {
+			return tokenErrorf(ErrorArguments, location.token, "argument '%s' of command '%s' must be a number, but got text", param.Name, command.Name.Value)
+		}
+		if labels[arg] {
+			return tokenErrorf(ErrorArguments, location.token, "argument '%s' of command '%s' must be a number, but got label '%s'", param.Name, command.Name.Value, arg)
+		}
+	}
+	return nil
+}
+
+// Returns the identifiers that appear in the given value, such as the
+// labels used in a command argument or raw statement.
+func getIdentifiers(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+	})
+}
+
+// Warns about local scripts, texts, movements, marts, data, and aliases that
+// are never referenced. Statements annotated with @unused are skipped.
+func (p *Parser) checkUnusedLocals(program *ast.Program) {
+	references := make(map[string]bool)
+	addReferences := func(value string, exclude string) {
+		for _, name := range getIdentifiers(value) {
+			if name != exclude {
+				references[name] = true
+			}
+		}
+	}
+	for _, script := range getScripts(program) {
+		walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+			if command, ok := stmt.(*ast.CommandStatement); ok {
+				for _, arg := range command.Args {
+					addReferences(arg, script.Name.Value)
+				}
+			}
+		})
+	}
+	for _, stmt := range program.TopLevelStatements {
+		switch s := stmt.(type) {
+		case *ast.MapScriptsStatement:
+			for _, mapScript := range s.MapScripts {
+				references[mapScript.Name] = true
+			}
+			for _, tableMapScript := range s.TableMapScripts {
+				for _, entry := range tableMapScript.Entries {
+					references[entry.Name] = true
+				}
+			}
+		case *ast.DataStatement:
+			for _, row := range s.Rows {
+				for _, value := range row.Values {
+					addReferences(value, s.Name.Value)
+				}
+			}
+		case *ast.AliasStatement:
+			references[s.Target.Value] = true
+		case *ast.RawStatement:
+			addReferences(s.Value, "")
+		case *ast.DirectiveStatement:
+			addReferences(s.Value, "")
+		}
+	}
+
+	for _, stmt := range program.TopLevelStatements {
+		var kind string
+		switch stmt.(type) {
+		case *ast.ScriptStatement:
+			kind = "script"
+		case *ast.TextStatement:
+			kind = "text"
+		case *ast.MovementStatement:
+			kind = "movement"
+		case *ast.MartStatement:
+			kind = "mart"
+		case *ast.DataStatement:
+			kind = "data"
+		case *ast.AliasStatement:
+			kind = "alias"
+		default:
+			continue
+		}
+		name := getStatementIdentifier(stmt)
+		if isGlobalStatement(stmt) || references[name.Value] || ast.AnnotationsOf(stmt).Has("unused") {
+			continue
+		}
+		p.addWarning(WarningUnused, name.Token.Pos(), fmt.Sprintf("local %s '%s' is never referenced", kind, name.Value))
+	}
+}
+
+func getDeprecationSuffix(annotation ast.Annotation) string {
+	if len(annotation.Args) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(": %s", annotation.Args[0])
+}
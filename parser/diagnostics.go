@@ -0,0 +1,418 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Severity is the severity level of a Diagnostic.
+type Severity int
+
+// Severity levels of diagnostics.
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// ANSI escape codes, which style the diagnostics that are printed to a
+// terminal.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[1;31m"
+	ansiYellow = "\x1b[1;33m"
+)
+
+// Colorize returns the text in the bold color of the severity, which is red
+// for errors and yellow for warnings, using ANSI escape codes.
+func (s Severity) Colorize(text string) string {
+	color := ansiYellow
+	if s == SeverityError {
+		color = ansiRed
+	}
+	return color + text + ansiReset
+}
+
+// Warning categories, which can be individually disabled.
+const (
+	WarningDeprecated  = "deprecated"
+	WarningEmptyBody   = "empty-body"
+	WarningUnreachable = "unreachable"
+	WarningUnused      = "unused"
+	WarningLint        = "lint"
+	WarningFontConfig  = "font-config"
+	WarningUnreleased  = "unreleased"
+	WarningCallEnd     = "call-end"
+	WarningScriptSize  = "script-size"
+)
+
+// WarningCategories is the list of all warning categories.
+var WarningCategories = []string{
+	WarningDeprecated,
+	WarningEmptyBody,
+	WarningUnreachable,
+	WarningUnused,
+	WarningLint,
+	WarningFontConfig,
+	WarningUnreleased,
+	WarningCallEnd,
+	WarningScriptSize,
+}
+
+// Diagnostic is a problem that was found in a Poryscript file, which doesn't
+// prevent it from being compiled.
+type Diagnostic struct {
+	Severity   Severity
+	Category   string
+	Rule       string // name of the lint rule that produced the diagnostic, if any
+	Filepath   string
+	LineNumber int
+	Column     int // column of the diagnostic's location, starting at 1, or 0 if unknown
+	Message    string
+}
+
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+	if d.Filepath != "" {
+		sb.WriteString(d.Filepath)
+		sb.WriteString(": ")
+	}
+	if d.LineNumber > 0 && d.Column > 0 {
+		sb.WriteString(fmt.Sprintf("line %d:%d: ", d.LineNumber, d.Column))
+	} else if d.LineNumber > 0 {
+		sb.WriteString(fmt.Sprintf("line %d: ", d.LineNumber))
+	}
+	sb.WriteString(d.Message)
+	return sb.String()
+}
+
+// Excerpt returns the source line that the diagnostic refers to, followed by
+// a line of carets underneath the token at the diagnostic's column. Long
+// lines are clipped to the characters around the token, and the clipped ends
+// are replaced with ellipses. It returns an empty string if the diagnostic's
+// location isn't in the source.
+func (d Diagnostic) Excerpt(source string) string {
+	return d.excerpt(source, false)
+}
+
+// ColorExcerpt is like Excerpt, but the token at the diagnostic's column is
+// bold, and the carets are in the color of the diagnostic's severity, using
+// ANSI escape codes.
+func (d Diagnostic) ColorExcerpt(source string) string {
+	return d.excerpt(source, true)
+}
+
+func (d Diagnostic) excerpt(source string, color bool) string {
+	if d.LineNumber < 1 || d.Column < 1 {
+		return ""
+	}
+	lines := strings.Split(source, "\n")
+	if d.LineNumber > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[d.LineNumber-1], "\r")
+	if d.Column > len(line)+1 {
+		return ""
+	}
+
+	width := getTokenWidth(source, line, d.LineNumber, d.Column)
+	start := d.Column - 1
+	end := getRuneOffset(line, start, width)
+	clipStart := getRuneOffset(line, start, -excerptContext)
+	clipEnd := getRuneOffset(line, end, excerptContext)
+	var sb strings.Builder
+	if clipStart > 0 {
+		sb.WriteString(excerptEllipsis)
+	}
+	if color && start < len(line) {
+		sb.WriteString(line[clipStart:start] + ansiBold + line[start:end] + ansiReset + line[end:clipEnd])
+	} else {
+		sb.WriteString(line[clipStart:clipEnd])
+	}
+	if clipEnd < len(line) {
+		sb.WriteString(excerptEllipsis)
+	}
+	sb.WriteByte('\n')
+	if clipStart > 0 {
+		sb.WriteString(strings.Repeat(" ", len(excerptEllipsis)))
+	}
+	// Tabs are kept, so that the carets line up with the source line.
+	for _, c := range line[clipStart:start] {
+		if c == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	carets := strings.Repeat("^", width)
+	if color {
+		carets = d.Severity.Colorize(carets)
+	}
+	sb.WriteString(carets)
+	return sb.String()
+}
+
+// The number of characters of an excerpt's line that are shown before and
+// after the token that the diagnostic refers to.
+const excerptContext = 40
+
+// Replaces the characters of an excerpt's line that are clipped.
+const excerptEllipsis = "..."
+
+// Returns the byte offset in the line that is the given number of characters
+// after the given byte offset, or before it if the number is negative. The
+// offset stays within the line.
+func getRuneOffset(line string, offset int, count int) int {
+	for ; count > 0 && offset < len(line); count-- {
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	for ; count < 0 && offset > 0; count++ {
+		_, size := utf8.DecodeLastRuneInString(line[:offset])
+		offset -= size
+	}
+	return offset
+}
+
+// Returns the number of characters of the token that begins at the given
+// location, up to the end of its line. Returns 1 if no token begins there.
+func getTokenWidth(source string, line string, lineNumber int, column int) int {
+	end := getTokenEndColumn(source, line, lineNumber, column)
+	if end-1 > len(line) {
+		return 1
+	}
+	width := utf8.RuneCountInString(line[column-1 : end-1])
+	if width < 1 {
+		return 1
+	}
+	return width
+}
+
+// Returns the column after the token that begins at the given location, up
+// to the end of its line. Returns the column after the location if no token
+// begins there.
+func getTokenEndColumn(source string, line string, lineNumber int, column int) int {
+	l := lexer.New(source)
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF || tok.LineNumber > lineNumber {
+			return column + 1
+		}
+		if tok.LineNumber != lineNumber || tok.Column != column {
+			continue
+		}
+		end := len(line) + 1
+		if tok.End.Line == lineNumber && tok.End.Column < end {
+			end = tok.End.Column
+		}
+		if end <= column {
+			return column + 1
+		}
+		return end
+	}
+}
+
+// DiagnosticPosition is a position in a file. Lines and columns start at 1.
+type DiagnosticPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// DiagnosticRange is the part of a file that a diagnostic refers to. The end
+// is the position after the range's last character.
+type DiagnosticRange struct {
+	Start DiagnosticPosition `json:"start"`
+	End   DiagnosticPosition `json:"end"`
+}
+
+// JSONDiagnostic is the structured form of a diagnostic, which tools like
+// editor plugins and CI annotators can read without parsing its message.
+type JSONDiagnostic struct {
+	File     string           `json:"file"`
+	Range    *DiagnosticRange `json:"range,omitempty"`
+	Severity string           `json:"severity"`
+	// The warning category or error code of the diagnostic.
+	Code    string `json:"code"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// ToJSON returns the structured form of the diagnostic. source is the
+// contents of the diagnostic's file, which is used to find the end of its
+// range. A diagnostic without a column refers to its whole line, and a
+// diagnostic without a line doesn't have a range.
+func (d Diagnostic) ToJSON(source string) JSONDiagnostic {
+	result := JSONDiagnostic{
+		File:     d.Filepath,
+		Severity: d.Severity.String(),
+		Code:     d.Category,
+		Rule:     d.Rule,
+		Message:  d.Message,
+	}
+	if d.LineNumber < 1 {
+		return result
+	}
+	var line string
+	lines := strings.Split(source, "\n")
+	if d.LineNumber <= len(lines) {
+		line = strings.TrimRight(lines[d.LineNumber-1], "\r")
+	}
+	start, end := d.Column, d.Column
+	if d.Column < 1 {
+		start, end = 1, len(line)+1
+	} else if d.LineNumber <= len(lines) && d.Column <= len(line)+1 {
+		end = getTokenEndColumn(source, line, d.LineNumber, d.Column)
+	}
+	result.Range = &DiagnosticRange{
+		Start: DiagnosticPosition{Line: d.LineNumber, Column: start},
+		End:   DiagnosticPosition{Line: d.LineNumber, Column: end},
+	}
+	return result
+}
+
+// DiagnosticOptions controls how warnings are reported.
+type DiagnosticOptions struct {
+	// Categories of warnings that are not reported.
+	DisabledWarnings []string
+	// Reports all warnings with error severity.
+	WarningsAsErrors bool
+}
+
+// ValidateWarningCategories returns an error if any of the given warning
+// categories don't exist.
+func ValidateWarningCategories(categories []string) error {
+	for _, category := range categories {
+		found := false
+		for _, validCategory := range WarningCategories {
+			if category == validCategory {
+				found = true
+				break
+			}
+		}
+		if !found {
+			validCategories := append([]string{}, WarningCategories...)
+			sort.Strings(validCategories)
+			return fmt.Errorf("unknown warning category '%s'. Valid categories are: %s", category, strings.Join(validCategories, ", "))
+		}
+	}
+	return nil
+}
+
+// NewWarning returns the diagnostic for a warning, or false if the warning's
+// category is disabled.
+func (options DiagnosticOptions) NewWarning(category string, pos token.Position, message string) (Diagnostic, bool) {
+	for _, disabled := range options.DisabledWarnings {
+		if disabled == category {
+			return Diagnostic{}, false
+		}
+	}
+	severity := SeverityWarning
+	if options.WarningsAsErrors {
+		severity = SeverityError
+	}
+	return Diagnostic{
+		Severity:   severity,
+		Category:   category,
+		LineNumber: pos.Line,
+		Column:     pos.Column,
+		Message:    message,
+	}, true
+}
+
+var errorLocationRegex = regexp.MustCompile(`^(?:(.*?): )?line (\d+)(?::(\d+))?: (.*)$`)
+
+// NewErrorDiagnostic converts an error returned by the parser into an error
+// diagnostic, so that it can be reported alongside the warnings. The location
+// of errors other than ParseErrors is read from their messages.
+func NewErrorDiagnostic(err error) Diagnostic {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return Diagnostic{
+			Severity:   SeverityError,
+			Category:   parseErr.Code,
+			Filepath:   parseErr.Filepath,
+			LineNumber: parseErr.LineNumber,
+			Column:     parseErr.Column,
+			Message:    parseErr.Message,
+		}
+	}
+	diagnostic := Diagnostic{
+		Severity: SeverityError,
+		Category: ErrorSyntax,
+		Message:  err.Error(),
+	}
+	if match := errorLocationRegex.FindStringSubmatch(err.Error()); match != nil {
+		diagnostic.Filepath = match[1]
+		diagnostic.LineNumber, _ = strconv.Atoi(match[2])
+		diagnostic.Column, _ = strconv.Atoi(match[3])
+		diagnostic.Message = match[4]
+	}
+	return diagnostic
+}
+
+// HasErrors reports whether any of the diagnostics have error severity.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an error if any of the diagnostics have error severity.
+func checkDiagnosticErrors(diagnostics []Diagnostic) error {
+	lexErrors, warnings := 0, 0
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity != SeverityError {
+			continue
+		}
+		if diagnostic.Category == ErrorLex {
+			lexErrors++
+		} else {
+			warnings++
+		}
+	}
+	if lexErrors > 0 {
+		return parseErrorf(ErrorLex, token.Position{}, "%d lexical error(s) were found", lexErrors)
+	}
+	if warnings > 0 {
+		return parseErrorf(ErrorWarnings, token.Position{}, "%d warning(s) were treated as errors", warnings)
+	}
+	return nil
+}
+
+// IsDiagnosticsError reports whether the error only reports that some of the
+// diagnostics have error severity. The diagnostics themselves describe the
+// problems, so the error doesn't need to be reported on its own.
+func IsDiagnosticsError(err error) bool {
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.LineNumber > 0 {
+		return false
+	}
+	return parseErr.Code == ErrorWarnings || parseErr.Code == ErrorLex
+}
+
+// Returns the string form of each of the diagnostics.
+func getDiagnosticStrings(diagnostics []Diagnostic) []string {
+	result := make([]string, len(diagnostics))
+	for i, diagnostic := range diagnostics {
+		result[i] = diagnostic.String()
+	}
+	return result
+}
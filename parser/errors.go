@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+// Error codes, which identify the kind of problem that a ParseError reports.
+const (
+	ErrorLex           = "lex"
+	ErrorSyntax        = "syntax"
+	ErrorDuplicate     = "duplicate"
+	ErrorArguments     = "arguments"
+	ErrorImport        = "import"
+	ErrorScope         = "scope"
+	ErrorCompileSwitch = "compile-switch"
+	ErrorWarnings      = "warnings"
+)
+
+// Categories of errors. Every ParseError matches one of them with errors.Is,
+// depending on its code.
+var (
+	// ErrLex is matched by errors that are caused by characters that don't
+	// form a valid token.
+	ErrLex = errors.New("lexical error")
+	// ErrSyntax is matched by errors in the structure of the file.
+	ErrSyntax = errors.New("syntax error")
+	// ErrSemantic is matched by errors in well-formed statements, such as
+	// duplicate labels or calls with the wrong number of arguments.
+	ErrSemantic = errors.New("semantic error")
+)
+
+// ParseError is a problem that prevents a Poryscript file from being
+// compiled.
+type ParseError struct {
+	Filepath   string
+	LineNumber int
+	Column     int // column of the error's location, starting at 1, or 0 if unknown
+	Code       string
+	Message    string
+}
+
+func (e *ParseError) Error() string {
+	return e.String()
+}
+
+// Is reports whether the error belongs to the given category of errors.
+func (e *ParseError) Is(target error) bool {
+	switch e.Code {
+	case ErrorLex:
+		return target == ErrLex
+	case ErrorSyntax:
+		return target == ErrSyntax
+	default:
+		return target == ErrSemantic
+	}
+}
+
+func (e *ParseError) String() string {
+	var sb strings.Builder
+	if e.Filepath != "" {
+		sb.WriteString(e.Filepath)
+		sb.WriteString(": ")
+	}
+	if e.LineNumber > 0 && e.Column > 0 {
+		sb.WriteString(fmt.Sprintf("line %d:%d: ", e.LineNumber, e.Column))
+	} else if e.LineNumber > 0 {
+		sb.WriteString(fmt.Sprintf("line %d: ", e.LineNumber))
+	}
+	sb.WriteString(e.Message)
+	return sb.String()
+}
+
+// Returns a syntax error at the given token. The error is a lexical error
+// instead when the parser stopped at an illegal token.
+func (p *Parser) syntaxErrorf(tok token.Token, format string, args ...interface{}) error {
+	code := ErrorSyntax
+	if tok.Type == token.ILLEGAL || p.curToken.Type == token.ILLEGAL || p.peekToken.Type == token.ILLEGAL {
+		code = ErrorLex
+	}
+	return tokenErrorf(code, tok, format, args...)
+}
+
+// Returns an error with the given code at the given token.
+func tokenErrorf(code string, tok token.Token, format string, args ...interface{}) error {
+	return &ParseError{
+		Filepath:   tok.Filepath,
+		LineNumber: tok.LineNumber,
+		Column:     tok.Column,
+		Code:       code,
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+// Returns an error with the given code at the given position.
+func parseErrorf(code string, pos token.Position, format string, args ...interface{}) error {
+	return &ParseError{
+		LineNumber: pos.Line,
+		Column:     pos.Column,
+		Code:       code,
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+// Returns the error with the given filepath. Errors that already have a
+// filepath are returned unchanged, since errors in imported files refer to
+// the imported file.
+func setErrorFilepath(err error, filepath string) error {
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		return fmt.Errorf("%s: %s", filepath, err.Error())
+	}
+	if parseErr.Filepath != "" {
+		return err
+	}
+	result := *parseErr
+	result.Filepath = filepath
+	return &result
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -41,6 +42,7 @@ func (fw *FontWidthsConfig) FormatText(text string, maxWidth int, fontID string)
 			validFontIDs[i] = k
 			i++
 		}
+		sort.Strings(validFontIDs)
 		return "", fmt.Errorf("Unknown fontID '%s' used in format(). List of valid fontIDs are '%s'", fontID, validFontIDs)
 	}
 
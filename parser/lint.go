@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/token"
+)
+
+// LintConfig configures the optional lint rules. A rule is disabled when
+// its setting is left empty.
+type LintConfig struct {
+	// Regular expression that script names must match.
+	ScriptNaming string `json:"scriptNaming"`
+	// Regular expression that text names must match.
+	TextNaming string `json:"textNaming"`
+	// Maximum nesting depth of if, while, do...while, and switch statements.
+	MaxNestingDepth int `json:"maxNestingDepth"`
+	// Maximum number of statements in a script, including nested statements.
+	MaxScriptLength int `json:"maxScriptLength"`
+	// Requires every switch statement to have a default case.
+	RequireSwitchDefault bool `json:"requireSwitchDefault"`
+
+	scriptNamingRegex *regexp.Regexp
+	textNamingRegex   *regexp.Regexp
+}
+
+// LoadLintConfig reads a lint config JSON file.
+func LoadLintConfig(filepath string) (LintConfig, error) {
+	var config LintConfig
+	bytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return config, err
+	}
+
+	if err := config.compile(); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func (config *LintConfig) compile() error {
+	var err error
+	if config.ScriptNaming != "" {
+		if config.scriptNamingRegex, err = regexp.Compile(config.ScriptNaming); err != nil {
+			return fmt.Errorf("invalid scriptNaming pattern: %s", err.Error())
+		}
+	}
+	if config.TextNaming != "" {
+		if config.textNamingRegex, err = regexp.Compile(config.TextNaming); err != nil {
+			return fmt.Errorf("invalid textNaming pattern: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Reports lint warnings for the program, according to the parser's lint config.
+func (p *Parser) lint(program *ast.Program) error {
+	if p.lintConfig == nil {
+		return nil
+	}
+	config := p.lintConfig
+	if err := config.compile(); err != nil {
+		return err
+	}
+	warn := func(pos token.Position, rule string, message string) {
+		if diagnostic, ok := p.diagnosticOptions.NewWarning(WarningLint, pos, fmt.Sprintf("[%s] %s", rule, message)); ok {
+			diagnostic.Rule = rule
+			p.diagnostics = append(p.diagnostics, diagnostic)
+		}
+	}
+
+	for _, stmt := range program.TopLevelStatements {
+		switch s := stmt.(type) {
+		case *ast.ScriptStatement:
+			if config.scriptNamingRegex != nil && !config.scriptNamingRegex.MatchString(s.Name.Value) {
+				warn(s.Name.Token.Pos(), "script-naming", fmt.Sprintf("script name '%s' doesn't match the pattern '%s'", s.Name.Value, config.ScriptNaming))
+			}
+		case *ast.TextStatement:
+			if config.textNamingRegex != nil && !config.textNamingRegex.MatchString(s.Name.Value) {
+				warn(s.Name.Token.Pos(), "text-naming", fmt.Sprintf("text name '%s' doesn't match the pattern '%s'", s.Name.Value, config.TextNaming))
+			}
+		}
+	}
+
+	for _, script := range getScripts(program) {
+		if config.MaxScriptLength > 0 {
+			length := 0
+			walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+				length++
+			})
+			if length > config.MaxScriptLength {
+				warn(script.Name.Token.Pos(), "max-script-length", fmt.Sprintf("script '%s' has %d statements, which exceeds the maximum of %d", script.Name.Value, length, config.MaxScriptLength))
+			}
+		}
+		if config.MaxNestingDepth > 0 {
+			if stmt := findNestingDepth(script.Body.Statements, 1, config.MaxNestingDepth); stmt != nil {
+				warn(stmt.Pos(), "max-nesting-depth", fmt.Sprintf("script '%s' exceeds the maximum nesting depth of %d", script.Name.Value, config.MaxNestingDepth))
+			}
+		}
+		if config.RequireSwitchDefault {
+			walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+				if switchStmt, ok := stmt.(*ast.SwitchStatement); ok && switchStmt.DefaultCase == nil {
+					warn(switchStmt.Token.Pos(), "switch-default", fmt.Sprintf("switch statement in script '%s' has no default case", script.Name.Value))
+				}
+			})
+		}
+	}
+	return nil
+}
+
+// Returns the first statement that is nested deeper than the maximum depth,
+// or nil if there is no such statement.
+func findNestingDepth(statements []ast.Statement, depth int, maxDepth int) ast.Statement {
+	for _, stmt := range statements {
+		var blocks [][]ast.Statement
+		switch s := stmt.(type) {
+		case *ast.IfStatement:
+			blocks = append(blocks, s.Consequence.Body.Statements)
+			for _, elif := range s.ElifConsequences {
+				blocks = append(blocks, elif.Body.Statements)
+			}
+			if s.ElseConsequence != nil {
+				blocks = append(blocks, s.ElseConsequence.Statements)
+			}
+		case *ast.WhileStatement:
+			blocks = append(blocks, s.Consequence.Body.Statements)
+		case *ast.DoWhileStatement:
+			blocks = append(blocks, s.Consequence.Body.Statements)
+		case *ast.SwitchStatement:
+			for _, switchCase := range s.Cases {
+				blocks = append(blocks, switchCase.Body.Statements)
+			}
+		default:
+			continue
+		}
+		if depth > maxDepth {
+			return stmt
+		}
+		for _, block := range blocks {
+			if found := findNestingDepth(block, depth+1, maxDepth); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package parser
 
 import (
 	"fmt"
-	"log"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -16,8 +18,29 @@ var topLevelTokens = map[token.Type]bool{
 	token.RAW:        true,
 	token.TEXT:       true,
 	token.MOVEMENT:   true,
+	token.MART:       true,
+	token.DATA:       true,
+	token.ALIAS:      true,
 	token.MAPSCRIPTS: true,
 	token.CONST:      true,
+	token.DIRECTIVE:  true,
+	token.MACRO:      true,
+	token.TEMPLATE:   true,
+	token.IMPORT:     true,
+	token.AT:         true,
+}
+
+// The locations of a command's arguments, which are used to report
+// problems with the arguments once all labels are known.
+type commandArgLocations struct {
+	args []commandArgLocation
+	// The token that ends the argument list.
+	end token.Token
+}
+
+type commandArgLocation struct {
+	token  token.Token
+	isText bool
 }
 
 type impText struct {
@@ -28,6 +51,61 @@ type impText struct {
 	scriptName string
 }
 
+// A parameterized call whose setvar commands are resolved after
+// all scripts have been parsed.
+type paramCall struct {
+	token      token.Token
+	scriptName string
+	setvars    []*ast.CommandStatement
+}
+
+// DefaultParamVars are the vars used to pass parameters to scripts,
+// unless specified otherwise with SetParamVars().
+var DefaultParamVars = []string{
+	"VAR_0x8000",
+	"VAR_0x8001",
+	"VAR_0x8002",
+	"VAR_0x8003",
+	"VAR_0x8004",
+	"VAR_0x8005",
+	"VAR_0x8006",
+	"VAR_0x8007",
+}
+
+// DefaultNestingLimit is the maximum depth of nested blocks and boolean
+// expressions, unless specified otherwise with SetNestingLimit().
+const DefaultNestingLimit = 100
+
+// A user-defined compile-time macro, which is expanded inline at
+// each of its use sites.
+type macro struct {
+	name   string
+	params []string
+	body   []token.Token
+}
+
+// A user-defined text template, which is instantiated with
+// arguments to produce text.
+type textTemplate struct {
+	name       string
+	params     []string
+	value      string
+	stringType string
+}
+
+// FileLoader reads the contents of a Poryscript file. It is used to load
+// the files referenced by import statements.
+type FileLoader func(filepath string) (string, error)
+
+func loadFile(filepath string) (string, error) {
+	bytes, err := ioutil.ReadFile(filepath)
+	return string(bytes), err
+}
+
+// Maximum depth of nested macro expansions, which guards against
+// infinitely-recursive macros.
+const maxMacroExpansionDepth = 100
+
 type textKey struct {
 	value   string
 	strType string
@@ -36,9 +114,12 @@ type textKey struct {
 // Parser is a Poryscript AST parser.
 type Parser struct {
 	l                  *lexer.Lexer
+	prevToken          token.Token
+	prevStatementToken token.Token
 	curToken           token.Token
 	peekToken          token.Token
 	peek2Token         token.Token
+	comments           []*ast.Comment
 	inlineTexts        []ast.Text
 	inlineTextsSet     map[textKey]string
 	inlineTextCounts   map[string]int
@@ -49,19 +130,54 @@ type Parser struct {
 	fonts              *FontWidthsConfig
 	compileSwitches    map[string]string
 	constants          map[string]string
+	diagnostics        []Diagnostic
+	diagnosticOptions  DiagnosticOptions
+	lintConfig         *LintConfig
+	autoEndConfig      *AutoEndConfig
+	fixCallEnds        bool
+	commandSignatures  CommandSignatures
+	commandArgs        map[*ast.CommandStatement]commandArgLocations
+	paramVars          []string
+	scriptParams       map[string]string
+	paramCalls         []paramCall
+	macros             map[string]*macro
+	macroDepth         int
+	nestingDepth       int
+	nestingLimit       int
+	textTemplates      map[string]*textTemplate
+	queuedTokens       []token.Token
+	filepath           string
+	loadFile           FileLoader
+	importStack        []string
+	importedFiles      map[string]bool
+	importedScripts    map[string]*ast.ScriptStatement
+	importedLabels     map[string]labelLocation
+	// The @deprecated annotations of the imported files' labels.
+	importedDeprecations map[string]ast.Annotation
+	deferParamCalls      bool
+	syntaxOnly           bool
 }
 
 // New creates a new Poryscript AST Parser.
 func New(l *lexer.Lexer, fontConfigFilepath string, compileSwitches map[string]string) *Parser {
 	p := &Parser{
-		l:                  l,
-		inlineTexts:        make([]ast.Text, 0),
-		inlineTextsSet:     make(map[textKey]string),
-		inlineTextCounts:   make(map[string]int),
-		textStatements:     make([]*ast.TextStatement, 0),
-		fontConfigFilepath: fontConfigFilepath,
-		compileSwitches:    compileSwitches,
-		constants:          make(map[string]string),
+		l:                    l,
+		inlineTexts:          make([]ast.Text, 0),
+		inlineTextsSet:       make(map[textKey]string),
+		inlineTextCounts:     make(map[string]int),
+		textStatements:       make([]*ast.TextStatement, 0),
+		fontConfigFilepath:   fontConfigFilepath,
+		compileSwitches:      compileSwitches,
+		constants:            make(map[string]string),
+		paramVars:            DefaultParamVars,
+		nestingLimit:         DefaultNestingLimit,
+		macros:               make(map[string]*macro),
+		textTemplates:        make(map[string]*textTemplate),
+		loadFile:             loadFile,
+		importedFiles:        make(map[string]bool),
+		importedScripts:      make(map[string]*ast.ScriptStatement),
+		importedLabels:       make(map[string]labelLocation),
+		importedDeprecations: make(map[string]ast.Annotation),
 	}
 	// Read three tokens, so curToken, peekToken, and peek2Token are all set.
 	p.nextToken()
@@ -70,6 +186,107 @@ func New(l *lexer.Lexer, fontConfigFilepath string, compileSwitches map[string]s
 	return p
 }
 
+// SetParamVars sets the vars that are used to pass parameters to scripts.
+// Parameters are assigned to the vars in order.
+func (p *Parser) SetParamVars(vars []string) {
+	p.paramVars = vars
+}
+
+// SetNestingLimit sets the maximum depth of nested blocks and boolean
+// expressions. Deeper nesting is reported as an error.
+func (p *Parser) SetNestingLimit(limit int) {
+	p.nestingLimit = limit
+}
+
+// SetFilepath sets the filepath of the Poryscript file being parsed. Imported
+// files are resolved relative to its directory.
+func (p *Parser) SetFilepath(filepath string) {
+	p.filepath = filepath
+	p.l.SetFilepath(filepath)
+	// The first tokens were already read by New().
+	p.curToken.Filepath = filepath
+	p.peekToken.Filepath = filepath
+	p.peek2Token.Filepath = filepath
+}
+
+// SetFontWidths sets the font widths that format() uses, so that several
+// parsers can share a config that was loaded once with LoadFontWidths.
+// Otherwise, the config is loaded from the font config filepath when it's
+// first needed.
+func (p *Parser) SetFontWidths(config *FontWidthsConfig) {
+	p.fonts = config
+}
+
+// SetFileLoader sets the function used to read imported files.
+func (p *Parser) SetFileLoader(loader FileLoader) {
+	p.loadFile = loader
+}
+
+// Imports returns the filepaths of all files that were imported, directly
+// or indirectly, by ParseProgram.
+func (p *Parser) Imports() []string {
+	imports := make([]string, 0, len(p.importedFiles))
+	for path := range p.importedFiles {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+// SetDiagnosticOptions sets the options that control how warnings are reported.
+func (p *Parser) SetDiagnosticOptions(options DiagnosticOptions) {
+	p.diagnosticOptions = options
+}
+
+// SetLintConfig enables the lint rules in the given config. Lint rules are
+// disabled when config is nil.
+func (p *Parser) SetLintConfig(config *LintConfig) {
+	p.lintConfig = config
+}
+
+// SetAutoEnd enables the pass that ends the scripts which fall off the end
+// of their body. It is disabled when config is nil.
+func (p *Parser) SetAutoEnd(config *AutoEndConfig) {
+	p.autoEndConfig = config
+}
+
+// SetFixCallEnds sets whether "end" is replaced with "return" in scripts
+// that are called by other scripts, instead of warning about it.
+func (p *Parser) SetFixCallEnds(enabled bool) {
+	p.fixCallEnds = enabled
+}
+
+// SetSyntaxOnly sets whether only the syntax of the file is checked, which
+// is all that the formatter needs. Imported files and fonts aren't loaded,
+// poryswitch statements whose compile switch isn't given use their first
+// case, and the checks that need the rest of the project, like the ones of
+// parameterized calls, are skipped.
+func (p *Parser) SetSyntaxOnly(enabled bool) {
+	p.syntaxOnly = enabled
+}
+
+// SetCommandSignatures sets the signatures of the commands, which are read
+// from the project's assembler macros with LoadCommandSignatures.
+func (p *Parser) SetCommandSignatures(signatures CommandSignatures) {
+	p.commandSignatures = signatures
+}
+
+// Diagnostics returns the diagnostics that were produced by the most recent call to ParseProgram.
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+// Warnings returns the warnings that were produced by the most recent call to ParseProgram.
+func (p *Parser) Warnings() []string {
+	return getDiagnosticStrings(p.diagnostics)
+}
+
+func (p *Parser) addWarning(category string, pos token.Position, message string) {
+	if diagnostic, ok := p.diagnosticOptions.NewWarning(category, pos, message); ok {
+		p.diagnostics = append(p.diagnostics, diagnostic)
+	}
+}
+
 func (p *Parser) pushBreakStack(statement ast.Statement) {
 	p.breakStack = append(p.breakStack, statement)
 }
@@ -101,9 +318,36 @@ func (p *Parser) peekContinueStack() ast.Statement {
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.curToken
 	p.curToken = p.peekToken
 	p.peekToken = p.peek2Token
-	p.peek2Token = p.l.NextToken()
+	p.peek2Token = p.readToken()
+}
+
+func (p *Parser) readToken() token.Token {
+	if len(p.queuedTokens) > 0 {
+		tok := p.queuedTokens[0]
+		p.queuedTokens = p.queuedTokens[1:]
+		return tok
+	}
+	tok := p.l.NextToken()
+	// Comments are only produced when the lexer scans them. They are
+	// kept in the program, instead of being parsed.
+	for tok.Type == token.COMMENT {
+		p.comments = append(p.comments, &ast.Comment{Token: tok})
+		tok = p.l.NextToken()
+	}
+	return tok
+}
+
+// Inserts the given tokens directly after the current token.
+func (p *Parser) injectTokens(tokens []token.Token) {
+	queue := make([]token.Token, 0, len(tokens)+len(p.queuedTokens)+2)
+	queue = append(queue, tokens...)
+	queue = append(queue, p.peekToken, p.peek2Token)
+	p.queuedTokens = append(queue, p.queuedTokens...)
+	p.peekToken = p.readToken()
+	p.peek2Token = p.readToken()
 }
 
 func (p *Parser) peekTokenIs(expectedType token.Type) bool {
@@ -120,7 +364,7 @@ func (p *Parser) expectPeek(expectedType token.Type) error {
 		return nil
 	}
 
-	return fmt.Errorf("line %d: expected next token to be '%s', got '%s' instead", p.peekToken.LineNumber, expectedType, p.peekToken.Literal)
+	return p.syntaxErrorf(p.peekToken, "expected next token to be '%s', got '%s' instead", expectedType, p.peekToken.Literal)
 }
 
 func getImplicitTextLabel(scriptName string, i int) string {
@@ -129,16 +373,46 @@ func getImplicitTextLabel(scriptName string, i int) string {
 
 // ParseProgram parses a Poryscript file into an AST.
 func (p *Parser) ParseProgram() (*ast.Program, error) {
+	p.diagnostics = make([]Diagnostic, 0)
+	program, err := p.parseProgram()
+	// Problems that the lexer recovered from are reported even if parsing
+	// stopped at a later error.
+	for _, lexErr := range p.l.Errors() {
+		p.diagnostics = append(p.diagnostics, Diagnostic{
+			Severity:   SeverityError,
+			Category:   ErrorLex,
+			Filepath:   p.filepath,
+			LineNumber: lexErr.Pos.Line,
+			Column:     lexErr.Pos.Column,
+			Message:    lexErr.Message,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDiagnosticErrors(p.diagnostics); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func (p *Parser) parseProgram() (*ast.Program, error) {
 	p.inlineTexts = make([]ast.Text, 0)
 	p.inlineTextsSet = make(map[textKey]string)
 	p.textStatements = make([]*ast.TextStatement, 0)
+	p.paramCalls = make([]paramCall, 0)
+	p.commandArgs = make(map[*ast.CommandStatement]commandArgLocations)
 	program := &ast.Program{
 		TopLevelStatements: []ast.Statement{},
 		Texts:              []ast.Text{},
+		Comments:           []*ast.Comment{},
 	}
 
 	for p.curToken.Type != token.EOF {
 		statement, err := p.parseTopLevelStatement()
+		if readErr := p.l.Err(); readErr != nil {
+			return nil, fmt.Errorf("failed to read input: %w", readErr)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -147,6 +421,17 @@ func (p *Parser) ParseProgram() (*ast.Program, error) {
 		}
 		p.nextToken()
 	}
+	program.Comments = append(program.Comments, p.comments...)
+	if p.syntaxOnly {
+		return program, nil
+	}
+
+	if err := p.checkImportedLabels(program); err != nil {
+		return nil, err
+	}
+	if err := p.resolveParamCalls(program); err != nil {
+		return nil, err
+	}
 
 	// Build list of Texts from both inline and explicit texts.
 	// Generate error if there are any name clashes.
@@ -154,26 +439,46 @@ func (p *Parser) ParseProgram() (*ast.Program, error) {
 		program.Texts = append(program.Texts, text)
 	}
 	for _, textStmt := range p.textStatements {
-		program.Texts = append(program.Texts, ast.Text{
-			Value:      textStmt.Value,
-			StringType: textStmt.StringType,
-			Name:       textStmt.Name.Value,
-			IsGlobal:   textStmt.Scope == token.GLOBAL,
-		})
+		text := ast.Text{
+			Value:       textStmt.Value,
+			StringType:  textStmt.StringType,
+			Name:        textStmt.Name.Value,
+			IsGlobal:    textStmt.Scope == token.GLOBAL,
+			Annotations: textStmt.Annotations,
+		}
+		if language, ok := textStmt.Annotations.Get("language"); ok {
+			text.Language = language.Args[0]
+		}
+		program.Texts = append(program.Texts, text)
 	}
 	names := make(map[string]struct{}, 0)
 	for _, text := range program.Texts {
 		if _, ok := names[text.Name]; ok {
-			return nil, fmt.Errorf("Duplicate text label '%s'. Choose a unique label that won't clash with the auto-generated text labels", text.Name)
+			return nil, parseErrorf(ErrorDuplicate, token.Position{}, "Duplicate text label '%s'. Choose a unique label that won't clash with the auto-generated text labels", text.Name)
 		}
 		names[text.Name] = struct{}{}
 	}
 
+	if err := p.checkCommandArgs(program); err != nil {
+		return nil, err
+	}
+	p.checkDeprecatedReferences(program)
+	p.checkEmptyBodies(program)
+	p.checkUnreachableCode(program)
+	p.checkUnusedLocals(program)
+	p.checkCalledScriptEnds(program)
+	p.autoEnd(program)
+	if err := p.lint(program); err != nil {
+		return nil, err
+	}
+
 	return program, nil
 }
 
 func (p *Parser) parseTopLevelStatement() (ast.Statement, error) {
 	switch p.curToken.Type {
+	case token.AT:
+		return p.parseAnnotatedStatement()
 	case token.SCRIPT:
 		statement, implicitTexts, err := p.parseScriptStatement()
 		if err != nil {
@@ -187,6 +492,12 @@ func (p *Parser) parseTopLevelStatement() (ast.Statement, error) {
 			return nil, err
 		}
 		return statement, nil
+	case token.DIRECTIVE:
+		statement, err := p.parseDirectiveStatement()
+		if err != nil {
+			return nil, err
+		}
+		return statement, nil
 	case token.TEXT:
 		statement, err := p.parseTextStatement()
 		if err != nil {
@@ -205,6 +516,18 @@ func (p *Parser) parseTopLevelStatement() (ast.Statement, error) {
 			return nil, err
 		}
 		return statement, nil
+	case token.DATA:
+		statement, err := p.parseDataStatement()
+		if err != nil {
+			return nil, err
+		}
+		return statement, nil
+	case token.ALIAS:
+		statement, err := p.parseAliasStatement()
+		if err != nil {
+			return nil, err
+		}
+		return statement, nil
 	case token.MAPSCRIPTS:
 		statement, implicitTexts, err := p.parseMapscriptsStatement()
 		if err != nil {
@@ -215,9 +538,118 @@ func (p *Parser) parseTopLevelStatement() (ast.Statement, error) {
 	case token.CONST:
 		err := p.parseConstant()
 		return nil, err
+	case token.MACRO:
+		err := p.parseMacro()
+		return nil, err
+	case token.TEMPLATE:
+		err := p.parseTextTemplate()
+		return nil, err
+	case token.IMPORT:
+		err := p.parseImport()
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("line %d: could not parse top-level statement for '%s'", p.curToken.LineNumber, p.curToken.Literal)
+	return nil, p.syntaxErrorf(p.curToken, "could not parse top-level statement for '%s'%s", p.curToken.Literal, didYouMean(p.curToken.Literal, topLevelKeywords))
+}
+
+// Known annotations, mapped to the number of arguments they accept.
+var annotationArgCounts = map[string][]int{
+	"deprecated": {0, 1},
+	"unused":     {0},
+	"align":      {1},
+	"language":   {1},
+}
+
+func (p *Parser) parseAnnotatedStatement() (ast.Statement, error) {
+	annotations := ast.Annotations{}
+	for p.curToken.Type == token.AT {
+		annotation, err := p.parseAnnotation()
+		if err != nil {
+			return nil, err
+		}
+		if annotations.Has(annotation.Name) {
+			return nil, tokenErrorf(ErrorDuplicate, annotation.Token, "duplicate annotation '@%s'", annotation.Name)
+		}
+		annotations = append(annotations, annotation)
+		p.nextToken()
+	}
+
+	startToken := p.curToken
+	statement, err := p.parseTopLevelStatement()
+	if err != nil {
+		return nil, err
+	}
+	switch stmt := statement.(type) {
+	case *ast.ScriptStatement:
+		stmt.Annotations = annotations
+	case *ast.TextStatement:
+		stmt.Annotations = annotations
+	case *ast.MovementStatement:
+		stmt.Annotations = annotations
+	case *ast.MartStatement:
+		stmt.Annotations = annotations
+	case *ast.DataStatement:
+		stmt.Annotations = annotations
+	case *ast.AliasStatement:
+		stmt.Annotations = annotations
+	case *ast.MapScriptsStatement:
+		stmt.Annotations = annotations
+	case *ast.RawStatement:
+		stmt.Annotations = annotations
+	case *ast.DirectiveStatement:
+		stmt.Annotations = annotations
+	default:
+		return nil, p.syntaxErrorf(startToken, "annotations cannot be applied to '%s'", startToken.Literal)
+	}
+	if language, ok := annotations.Get("language"); ok && startToken.Type != token.TEXT {
+		return nil, p.syntaxErrorf(language.Token, "annotation '@language' can only be applied to 'text'")
+	}
+	return statement, nil
+}
+
+func (p *Parser) parseAnnotation() (ast.Annotation, error) {
+	annotation := ast.Annotation{
+		Token: p.curToken,
+		Args:  []string{},
+	}
+	if !p.peekTokenIs(token.IDENT) {
+		return annotation, p.syntaxErrorf(p.peekToken, "expected annotation name after '@', but got '%s' instead", p.peekToken.Literal)
+	}
+	p.nextToken()
+	annotation.Name = p.curToken.Literal
+	argCounts, ok := annotationArgCounts[annotation.Name]
+	if !ok {
+		return annotation, p.syntaxErrorf(p.curToken, "unknown annotation '@%s'", annotation.Name)
+	}
+
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		p.nextToken()
+		for p.curToken.Type != token.RPAREN {
+			if p.curToken.Type != token.STRING && p.curToken.Type != token.INT && p.curToken.Type != token.IDENT {
+				return annotation, p.syntaxErrorf(p.curToken, "invalid argument '%s' for annotation '@%s'", p.curToken.Literal, annotation.Name)
+			}
+			annotation.Args = append(annotation.Args, p.curToken.Literal)
+			p.nextToken()
+			if p.curToken.Type == token.COMMA {
+				p.nextToken()
+			} else if p.curToken.Type != token.RPAREN {
+				return annotation, p.syntaxErrorf(annotation.Token, "missing closing parenthesis for annotation '@%s'", annotation.Name)
+			}
+		}
+	}
+
+	for _, count := range argCounts {
+		if len(annotation.Args) == count {
+			if annotation.Name == "align" {
+				if _, err := strconv.ParseInt(annotation.Args[0], 0, 64); err != nil {
+					return annotation, p.syntaxErrorf(annotation.Token, "invalid alignment '%s' for annotation '@align'. Expected integer", annotation.Args[0])
+				}
+			}
+			return annotation, nil
+		}
+	}
+	return annotation, tokenErrorf(ErrorArguments, annotation.Token, "wrong number of arguments for annotation '@%s'. Got %d", annotation.Name, len(annotation.Args))
 }
 
 func (p *Parser) addImplicitTexts(implicitTexts []impText) {
@@ -247,11 +679,11 @@ func (p *Parser) parseScopeModifier(defaultScope token.Type) (token.Type, error)
 	}
 	p.nextToken()
 	if !p.peekTokenIs(token.GLOBAL) && !p.peekTokenIs(token.LOCAL) {
-		return scope, fmt.Errorf("line %d: scope modifier must be 'global' or 'local', but got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return scope, p.syntaxErrorf(p.peekToken, "scope modifier must be 'global' or 'local', but got '%s' instead%s", p.peekToken.Literal, didYouMean(p.peekToken.Literal, []string{"global", "local"}))
 	}
 	p.nextToken()
 	if !p.peekTokenIs(token.RPAREN) {
-		return scope, fmt.Errorf("line %d: missing ')' after scope modifier. Got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return scope, p.syntaxErrorf(p.peekToken, "missing ')' after scope modifier. Got '%s' instead", p.peekToken.Literal)
 	}
 	scope = p.curToken.Type
 	p.nextToken()
@@ -266,7 +698,7 @@ func (p *Parser) parseScriptStatement() (*ast.ScriptStatement, []impText, error)
 	}
 	statement.Scope = scope
 	if err := p.expectPeek(token.IDENT); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing name for script", p.curToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing name for script")
 	}
 
 	statement.Name = &ast.Identifier{
@@ -274,30 +706,88 @@ func (p *Parser) parseScriptStatement() (*ast.ScriptStatement, []impText, error)
 		Value: p.curToken.Literal,
 	}
 
+	if p.peekTokenIs(token.LPAREN) {
+		params, err := p.parseScriptParams(statement.Name.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		statement.Params = params
+	}
+
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing opening curly brace for script '%s'", p.curToken.LineNumber, statement.Name.Value)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing opening curly brace for script '%s'", statement.Name.Value)
 	}
 
 	p.nextToken()
 
+	p.scriptParams = make(map[string]string)
+	for _, param := range statement.Params {
+		p.scriptParams[param.Name] = param.Var
+	}
 	blockStmt, implicitTexts, err := p.parseBlockStatement(statement.Name.Value)
+	p.scriptParams = nil
 	if err != nil {
 		return nil, nil, err
 	}
 	statement.Body = blockStmt
+	statement.EndPos = p.curToken.End
 	return statement, implicitTexts, nil
 }
 
+func (p *Parser) parseScriptParams(scriptName string) ([]ast.ScriptParam, error) {
+	params := []ast.ScriptParam{}
+	names := make(map[string]bool)
+	p.nextToken()
+	for !p.peekTokenIs(token.RPAREN) {
+		if err := p.expectPeek(token.IDENT); err != nil {
+			return nil, p.syntaxErrorf(p.peekToken, "expected parameter name for script '%s', but got '%s' instead", scriptName, p.peekToken.Literal)
+		}
+		param := ast.ScriptParam{
+			Token: p.curToken,
+			Name:  p.curToken.Literal,
+		}
+		if names[param.Name] {
+			return nil, tokenErrorf(ErrorDuplicate, p.curToken, "duplicate parameter '%s' for script '%s'", param.Name, scriptName)
+		}
+		names[param.Name] = true
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			if !p.peekTokenIs(token.IDENT) && !p.peekTokenIs(token.INT) {
+				return nil, p.syntaxErrorf(p.peekToken, "expected var for parameter '%s', but got '%s' instead", param.Name, p.peekToken.Literal)
+			}
+			p.nextToken()
+			param.Var = p.tryReplaceWithConstant(p.curToken.Literal)
+		} else {
+			if len(params) >= len(p.paramVars) {
+				return nil, tokenErrorf(ErrorArguments, p.curToken, "too many parameters for script '%s'. Only %d parameter vars are available", scriptName, len(p.paramVars))
+			}
+			param.Var = p.paramVars[len(params)]
+		}
+		params = append(params, param)
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.peekTokenIs(token.RPAREN) {
+			return nil, p.syntaxErrorf(p.peekToken, "missing closing parenthesis for parameters of script '%s'", scriptName)
+		}
+	}
+	p.nextToken()
+	return params, nil
+}
+
 func (p *Parser) parseBlockStatement(scriptName string) (*ast.BlockStatement, []impText, error) {
 	block := &ast.BlockStatement{
 		Token:      p.curToken,
 		Statements: []ast.Statement{},
 	}
 	implicitTexts := make([]impText, 0)
+	if err := p.enterNesting(block.Token); err != nil {
+		return nil, nil, err
+	}
+	defer p.exitNesting()
 
 	for p.curToken.Type != token.RBRACE {
 		if p.curToken.Type == token.EOF {
-			return nil, nil, fmt.Errorf("line %d: missing closing curly brace for block statement", block.Token.LineNumber)
+			return nil, nil, p.syntaxErrorf(block.Token, "missing closing curly brace for block statement")
 		}
 
 		statements, stmtTexts, err := p.parseStatement(scriptName)
@@ -310,6 +800,7 @@ func (p *Parser) parseBlockStatement(scriptName string) (*ast.BlockStatement, []
 		p.nextToken()
 	}
 
+	block.EndPos = p.getBlockEnd(block)
 	return block, implicitTexts, nil
 }
 
@@ -319,10 +810,14 @@ func (p *Parser) parseSwitchBlockStatement(scriptName string) (*ast.BlockStateme
 		Statements: []ast.Statement{},
 	}
 	implicitTexts := make([]impText, 0)
+	if err := p.enterNesting(block.Token); err != nil {
+		return nil, nil, err
+	}
+	defer p.exitNesting()
 
 	for p.curToken.Type != token.RBRACE && p.curToken.Type != token.CASE && p.curToken.Type != token.DEFAULT {
 		if p.curToken.Type == token.EOF {
-			return nil, nil, fmt.Errorf("line %d: missing end for switch case body", block.Token.LineNumber)
+			return nil, nil, p.syntaxErrorf(block.Token, "missing end for switch case body")
 		}
 
 		statements, stmtTexts, err := p.parseStatement(scriptName)
@@ -335,16 +830,56 @@ func (p *Parser) parseSwitchBlockStatement(scriptName string) (*ast.BlockStateme
 		p.nextToken()
 	}
 
+	block.EndPos = p.getBlockEnd(block)
 	return block, implicitTexts, nil
 }
 
+// Enters a nested block or boolean expression that begins at the given
+// token. Returns an error if the nesting limit is exceeded.
+func (p *Parser) enterNesting(tok token.Token) error {
+	if p.nestingDepth >= p.nestingLimit {
+		return p.syntaxErrorf(tok, "maximum nesting depth of %d exceeded", p.nestingLimit)
+	}
+	p.nestingDepth++
+	return nil
+}
+
+func (p *Parser) exitNesting() {
+	p.nestingDepth--
+}
+
+// Returns the end of a block statement's last statement, when the current
+// token is the one that follows the block. The span of an empty block is
+// empty.
+func (p *Parser) getBlockEnd(block *ast.BlockStatement) token.Position {
+	if len(block.Statements) == 0 {
+		return block.Token.Pos()
+	}
+	return p.prevToken.End
+}
+
 func (p *Parser) parseStatement(scriptName string) ([]ast.Statement, []impText, error) {
+	startToken := p.curToken
 	statements := make([]ast.Statement, 0, 1)
 	var implicitTexts []impText
 	var err error
 	var statement ast.Statement
 	switch p.curToken.Type {
 	case token.IDENT:
+		if _, ok := p.macros[p.curToken.Literal]; ok {
+			var block *ast.BlockStatement
+			block, implicitTexts, err = p.parseMacroExpansion(scriptName)
+			if err == nil {
+				statements = append(statements, block.Statements...)
+			}
+			break
+		}
+		if p.isParamCall() {
+			var stmts []ast.Statement
+			stmts, err = p.parseParamCallStatement()
+			statements = append(statements, stmts...)
+			break
+		}
 		statement, implicitTexts, err = p.parseCommandStatement(scriptName)
 		statements = append(statements, statement)
 	case token.IF:
@@ -370,13 +905,22 @@ func (p *Parser) parseStatement(scriptName string) ([]ast.Statement, []impText,
 		stmts, implicitTexts, err = p.parsePoryswitchStatement(scriptName)
 		statements = append(statements, stmts...)
 	default:
-		err = fmt.Errorf("line %d: could not parse statement for '%s'", p.curToken.LineNumber, p.curToken.Literal)
+		suggestion := ""
+		if p.curToken.Type == token.LBRACE && p.prevStatementToken.Type == token.IDENT {
+			// A misspelled keyword is parsed as a command, which can't be
+			// followed by a block.
+			if keyword, ok := getSuggestion(p.prevStatementToken.Literal, statementKeywords); ok {
+				suggestion = fmt.Sprintf(". Did you mean '%s' instead of '%s'?", keyword, p.prevStatementToken.Literal)
+			}
+		}
+		err = p.syntaxErrorf(p.curToken, "could not parse statement for '%s'%s", p.curToken.Literal, suggestion)
 	}
 
 	if err != nil {
 		return nil, nil, err
 	}
 
+	p.prevStatementToken = startToken
 	return statements, implicitTexts, nil
 }
 
@@ -391,22 +935,32 @@ func (p *Parser) parseCommandStatement(scriptName string) (ast.Statement, []impT
 	}
 
 	implicitTexts := make([]impText, 0)
+	argInfo := commandArgLocations{end: p.curToken}
 
 	if p.peekTokenIs(token.LPAREN) {
 		p.nextToken()
 		p.nextToken()
 		argParts := []string{}
 		numOpenParens := 0
+		arg := commandArgLocation{token: p.curToken}
 		for !(p.curToken.Type == token.RPAREN && numOpenParens == 0) {
 			if p.curToken.Type == token.EOF {
-				err := fmt.Errorf("line %d: missing closing parenthesis for command '%s'", command.Token.LineNumber, command.Name.TokenLiteral())
+				err := p.syntaxErrorf(command.Token, "missing closing parenthesis for command '%s'", command.Name.TokenLiteral())
 				return nil, nil, err
 			}
 
+			if len(argParts) == 0 && p.curToken.Type != token.COMMA {
+				arg = commandArgLocation{token: p.curToken}
+			}
+			if p.curToken.Type == token.FORMAT || p.curToken.Type == token.STRING || p.curToken.Type == token.STRINGTYPE || p.isTextTemplateInstance() {
+				arg.isText = true
+			}
+
 			if p.curToken.Type == token.COMMA {
-				arg := strings.Join(argParts, " ")
-				command.Args = append(command.Args, arg)
+				command.Args = append(command.Args, strings.Join(argParts, " "))
+				argInfo.args = append(argInfo.args, arg)
 				argParts = []string{}
+				arg = commandArgLocation{token: p.curToken}
 			} else if p.curToken.Type == token.LPAREN {
 				numOpenParens++
 				argParts = append(argParts, p.curToken.Literal)
@@ -434,11 +988,24 @@ func (p *Parser) parseCommandStatement(scriptName string) (ast.Statement, []impT
 					scriptName: scriptName,
 				})
 				argParts = append(argParts, "")
+			} else if p.isTextTemplateInstance() {
+				strValue, strType, err := p.parseTextTemplateInstance()
+				if err != nil {
+					return nil, nil, err
+				}
+				implicitTexts = append(implicitTexts, impText{
+					command:    command,
+					argPos:     len(command.Args),
+					text:       strValue,
+					stringType: strType,
+					scriptName: scriptName,
+				})
+				argParts = append(argParts, "")
 			} else if p.curToken.Type == token.STRINGTYPE {
 				stringType := p.curToken.Literal
 				p.nextToken()
 				if p.curToken.Type != token.STRING {
-					err := fmt.Errorf("line %d: expected a string literal after string type '%s'. Got '%s' instead", p.curToken.LineNumber, stringType, p.curToken.Literal)
+					err := p.syntaxErrorf(p.curToken, "expected a string literal after string type '%s'. Got '%s' instead", stringType, p.curToken.Literal)
 					return nil, nil, err
 				}
 				implicitTexts = append(implicitTexts, impText{
@@ -457,24 +1024,165 @@ func (p *Parser) parseCommandStatement(scriptName string) (ast.Statement, []impT
 		}
 
 		if len(argParts) > 0 {
-			arg := strings.Join(argParts, " ")
-			command.Args = append(command.Args, arg)
+			command.Args = append(command.Args, strings.Join(argParts, " "))
+			argInfo.args = append(argInfo.args, arg)
 		}
+		argInfo.end = p.curToken
 	}
 
+	command.EndPos = p.curToken.End
+	p.commandArgs[command] = argInfo
 	return command, implicitTexts, nil
 }
 
+// Reports whether the current token begins a parameterized call,
+// such as "call MyScript(1, 2)".
+func (p *Parser) isParamCall() bool {
+	return (p.curToken.Literal == "call" || p.curToken.Literal == "goto") && p.peekTokenIs(token.IDENT) && p.peek2TokenIs(token.LPAREN)
+}
+
+func (p *Parser) parseParamCallStatement() ([]ast.Statement, error) {
+	command := &ast.CommandStatement{
+		Token: p.curToken,
+		Name: &ast.Identifier{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		},
+	}
+	p.nextToken()
+	call := paramCall{
+		token:      p.curToken,
+		scriptName: p.curToken.Literal,
+	}
+	command.Args = []string{call.scriptName}
+	p.nextToken()
+	p.nextToken()
+
+	statements := []ast.Statement{}
+	argParts := []string{}
+	numOpenParens := 0
+	addSetvar := func() error {
+		if len(argParts) == 0 {
+			return tokenErrorf(ErrorArguments, call.token, "missing argument for call to script '%s'", call.scriptName)
+		}
+		setvarToken := command.Token
+		setvarToken.Literal = "setvar"
+		setvar := &ast.CommandStatement{
+			Token: setvarToken,
+			Name: &ast.Identifier{
+				Token: setvarToken,
+				Value: "setvar",
+			},
+			Args: []string{"", strings.Join(argParts, " ")},
+		}
+		call.setvars = append(call.setvars, setvar)
+		statements = append(statements, setvar)
+		argParts = []string{}
+		return nil
+	}
+	for !(p.curToken.Type == token.RPAREN && numOpenParens == 0) {
+		if p.curToken.Type == token.EOF {
+			return nil, p.syntaxErrorf(call.token, "missing closing parenthesis for call to script '%s'", call.scriptName)
+		}
+		if p.curToken.Type == token.COMMA && numOpenParens == 0 {
+			if err := addSetvar(); err != nil {
+				return nil, err
+			}
+		} else {
+			if p.curToken.Type == token.LPAREN {
+				numOpenParens++
+			} else if p.curToken.Type == token.RPAREN {
+				numOpenParens--
+			}
+			argParts = append(argParts, p.tryReplaceWithConstant(p.curToken.Literal))
+		}
+		p.nextToken()
+	}
+	if len(argParts) > 0 || len(call.setvars) > 0 {
+		if err := addSetvar(); err != nil {
+			return nil, err
+		}
+	}
+
+	command.EndPos = p.curToken.End
+	for _, setvar := range call.setvars {
+		setvar.EndPos = command.EndPos
+	}
+	p.paramCalls = append(p.paramCalls, call)
+	statements = append(statements, command)
+	return statements, nil
+}
+
+// Assigns the parameter vars of parameterized calls, now that all
+// of the scripts' parameters are known.
+func (p *Parser) resolveParamCalls(program *ast.Program) error {
+	scripts := make(map[string]*ast.ScriptStatement)
+	for name, script := range p.importedScripts {
+		scripts[name] = script
+	}
+	for _, stmt := range program.TopLevelStatements {
+		if scriptStmt, ok := stmt.(*ast.ScriptStatement); ok {
+			scripts[scriptStmt.Name.Value] = scriptStmt
+		}
+	}
+	calls := p.paramCalls
+	p.paramCalls = make([]paramCall, 0)
+	return p.assignParamCalls(calls, scripts)
+}
+
+// Assigns the parameter vars of the called scripts to the setvar commands
+// of the given parameterized calls. When the parser is part of a project,
+// calls to unknown scripts are kept, since they may be defined in
+// another file of the project.
+func (p *Parser) assignParamCalls(calls []paramCall, scripts map[string]*ast.ScriptStatement) error {
+	for _, call := range calls {
+		script, ok := scripts[call.scriptName]
+		if !ok {
+			if p.deferParamCalls {
+				p.paramCalls = append(p.paramCalls, call)
+				continue
+			}
+			return tokenErrorf(ErrorScope, call.token, "unknown script '%s' in parameterized call", call.scriptName)
+		}
+		if len(script.Params) != len(call.setvars) {
+			return tokenErrorf(ErrorArguments, call.token, "script '%s' expects %d parameters, but got %d", call.scriptName, len(script.Params), len(call.setvars))
+		}
+		for i, setvar := range call.setvars {
+			setvar.Args[0] = script.Params[i].Var
+		}
+	}
+	return nil
+}
+
 func (p *Parser) parseRawStatement() (*ast.RawStatement, error) {
 	statement := &ast.RawStatement{
 		Token: p.curToken,
 	}
 
 	if err := p.expectPeek(token.RAWSTRING); err != nil {
-		return nil, fmt.Errorf("line %d: raw statement must begin with a backtick character '`'", p.curToken.LineNumber)
+		return nil, p.syntaxErrorf(p.curToken, "raw statement must begin with a backtick character '`'")
+	}
+
+	statement.Value = p.curToken.Literal
+	statement.EndPos = p.curToken.End
+	return statement, nil
+}
+
+func (p *Parser) parseDirectiveStatement() (*ast.DirectiveStatement, error) {
+	statement := &ast.DirectiveStatement{
+		Token: p.curToken,
+	}
+
+	if !p.peekTokenIs(token.STRING) && !p.peekTokenIs(token.RAWSTRING) {
+		return nil, p.syntaxErrorf(p.peekToken, "directive statement must be a string or backtick-delimited value. Got '%s' instead", p.peekToken.Literal)
+	}
+	p.nextToken()
+	if len(strings.TrimSpace(p.curToken.Literal)) == 0 {
+		return nil, p.syntaxErrorf(p.curToken, "directive statement cannot be empty")
 	}
 
 	statement.Value = p.curToken.Literal
+	statement.EndPos = p.curToken.End
 	return statement, nil
 }
 
@@ -488,7 +1196,7 @@ func (p *Parser) parseTextStatement() (*ast.TextStatement, error) {
 	}
 	statement.Scope = scope
 	if err := p.expectPeek(token.IDENT); err != nil {
-		return nil, fmt.Errorf("line %d: missing name for text statement", p.curToken.LineNumber)
+		return nil, p.syntaxErrorf(p.curToken, "missing name for text statement")
 	}
 
 	statement.Name = &ast.Identifier{
@@ -497,7 +1205,7 @@ func (p *Parser) parseTextStatement() (*ast.TextStatement, error) {
 	}
 
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return nil, fmt.Errorf("line %d: missing opening curly brace for text '%s'", p.peekToken.LineNumber, statement.Name.Value)
+		return nil, p.syntaxErrorf(p.peekToken, "missing opening curly brace for text '%s'", statement.Name.Value)
 	}
 	p.nextToken()
 
@@ -519,8 +1227,9 @@ func (p *Parser) parseTextStatement() (*ast.TextStatement, error) {
 	statement.StringType = strType
 	p.textStatements = append(p.textStatements, statement)
 	if err := p.expectPeek(token.RBRACE); err != nil {
-		return nil, fmt.Errorf("line %d: expected closing curly brace for text. Got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return nil, p.syntaxErrorf(p.peekToken, "expected closing curly brace for text. Got '%s' instead", p.peekToken.Literal)
 	}
+	statement.EndPos = p.curToken.End
 	return statement, nil
 }
 
@@ -534,55 +1243,59 @@ func (p *Parser) parseTextValue() (string, string, error) {
 		return p.formatTextTerminator(strValue, stringType), stringType, nil
 	} else if p.curToken.Type == token.STRING {
 		return p.formatTextTerminator(p.curToken.Literal, ""), "", nil
+	} else if p.isTextTemplateInstance() {
+		return p.parseTextTemplateInstance()
 	} else if p.curToken.Type == token.STRINGTYPE {
 		stringType := p.curToken.Literal
 		p.nextToken()
 		if p.curToken.Type != token.STRING {
-			return "", "", fmt.Errorf("line %d: expected a string literal after string type '%s'. Got '%s' instead", p.curToken.LineNumber, stringType, p.curToken.Literal)
+			return "", "", p.syntaxErrorf(p.curToken, "expected a string literal after string type '%s'. Got '%s' instead", stringType, p.curToken.Literal)
 		}
 		return p.formatTextTerminator(p.curToken.Literal, stringType), stringType, nil
 	} else {
-		return "", "", fmt.Errorf("line %d: body of text statement must be a string or formatted string. Got '%s' instead", p.curToken.LineNumber, p.curToken.Literal)
+		return "", "", p.syntaxErrorf(p.curToken, "body of text statement must be a string or formatted string. Got '%s' instead", p.curToken.Literal)
 	}
 }
 
 func (p *Parser) parsePoryswitchHeader() (string, string, error) {
-	if len(p.compileSwitches) == 0 {
-		return "", "", fmt.Errorf("line %d: poryswitch used, but no compile switches were specified with the '-s' option", p.curToken.LineNumber)
+	if len(p.compileSwitches) == 0 && !p.syntaxOnly {
+		return "", "", tokenErrorf(ErrorCompileSwitch, p.curToken, "poryswitch used, but no compile switches were specified with the '-s' option")
 	}
 	if err := p.expectPeek(token.LPAREN); err != nil {
-		return "", "", fmt.Errorf("line %d: expected opening parenthesis for poryswitch value. Got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return "", "", p.syntaxErrorf(p.peekToken, "expected opening parenthesis for poryswitch value. Got '%s' instead", p.peekToken.Literal)
 	}
 	if err := p.expectPeek(token.IDENT); err != nil {
-		return "", "", fmt.Errorf("line %d: expected poryswitch identifier value. Got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return "", "", p.syntaxErrorf(p.peekToken, "expected poryswitch identifier value. Got '%s' instead", p.peekToken.Literal)
 	}
 	switchCase := p.curToken.Literal
-	var switchValue string
-	var ok bool
-	if switchValue, ok = p.compileSwitches[switchCase]; !ok {
-		return "", "", fmt.Errorf("line %d: no poryswitch for '%s' was specified with the '-s' option", p.curToken.LineNumber, switchCase)
+	switchValue, ok := p.compileSwitches[switchCase]
+	if !ok && !p.syntaxOnly {
+		return "", "", tokenErrorf(ErrorCompileSwitch, p.curToken, "no poryswitch for '%s' was specified with the '-s' option", switchCase)
 	}
 
 	if err := p.expectPeek(token.RPAREN); err != nil {
-		return "", "", fmt.Errorf("line %d: expected closing parenthesis for poryswitch value. Got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return "", "", p.syntaxErrorf(p.peekToken, "expected closing parenthesis for poryswitch value. Got '%s' instead", p.peekToken.Literal)
 	}
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return "", "", fmt.Errorf("line %d: expected opening curly brace for poryswitch statement. Got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return "", "", p.syntaxErrorf(p.peekToken, "expected opening curly brace for poryswitch statement. Got '%s' instead", p.peekToken.Literal)
 	}
 	p.nextToken()
+	if !ok {
+		switchValue = p.curToken.Literal
+	}
 	return switchCase, switchValue, nil
 }
 
 func (p *Parser) parsePoryswitchTextCases() (map[string]string, map[string]string, error) {
 	textCases := make(map[string]string)
 	textStringTypeCases := make(map[string]string)
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	for p.curToken.Type != token.RBRACE {
 		if p.curToken.Type == token.EOF {
-			return nil, nil, fmt.Errorf("line %d: missing closing curly brace for poryswitch statement", startLineNumber)
+			return nil, nil, p.syntaxErrorf(startToken, "missing closing curly brace for poryswitch statement")
 		}
 		if p.curToken.Type != token.IDENT && p.curToken.Type != token.INT {
-			return nil, nil, fmt.Errorf("line %d: invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, nil, p.syntaxErrorf(p.curToken, "invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.Literal)
 		}
 		caseValue := p.curToken.Literal
 		p.nextToken()
@@ -598,19 +1311,19 @@ func (p *Parser) parsePoryswitchTextCases() (map[string]string, map[string]strin
 			p.nextToken()
 			if usedBrace {
 				if p.curToken.Type != token.RBRACE {
-					return nil, nil, fmt.Errorf("line %d: missing closing curly brace for poryswitch case '%s'", startLineNumber, caseValue)
+					return nil, nil, p.syntaxErrorf(startToken, "missing closing curly brace for poryswitch case '%s'", caseValue)
 				}
 				p.nextToken()
 			}
 		} else {
-			return nil, nil, fmt.Errorf("line %d: invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.LineNumber, p.curToken.Literal, caseValue)
+			return nil, nil, p.syntaxErrorf(p.curToken, "invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.Literal, caseValue)
 		}
 	}
 	return textCases, textStringTypeCases, nil
 }
 
 func (p *Parser) parsePoryswitchTextStatement() (string, string, error) {
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	switchCase, switchValue, err := p.parsePoryswitchHeader()
 	if err != nil {
 		return "", "", err
@@ -624,7 +1337,7 @@ func (p *Parser) parsePoryswitchTextStatement() (string, string, error) {
 	if !ok {
 		strValue, ok = cases["_"]
 		if !ok {
-			return "", "", fmt.Errorf("line %d: no poryswitch case found for '%s=%s', which was specified with the '-s' option", startLineNumber, switchCase, switchValue)
+			return "", "", tokenErrorf(ErrorCompileSwitch, startToken, "no poryswitch case found for '%s=%s', which was specified with the '-s' option", switchCase, switchValue)
 		}
 	}
 	return strValue, strTypeValue, nil
@@ -641,7 +1354,7 @@ func (p *Parser) parseMovementStatement() (*ast.MovementStatement, error) {
 	}
 	statement.Scope = scope
 	if err := p.expectPeek(token.IDENT); err != nil {
-		return nil, fmt.Errorf("line %d: missing name for movement statement", p.curToken.LineNumber)
+		return nil, p.syntaxErrorf(p.curToken, "missing name for movement statement")
 	}
 
 	statement.Name = &ast.Identifier{
@@ -650,13 +1363,14 @@ func (p *Parser) parseMovementStatement() (*ast.MovementStatement, error) {
 	}
 
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return nil, fmt.Errorf("line %d: missing opening curly brace for movement '%s'", p.peekToken.LineNumber, statement.Name.Value)
+		return nil, p.syntaxErrorf(p.peekToken, "missing opening curly brace for movement '%s'", statement.Name.Value)
 	}
 	p.nextToken()
 	statement.MovementCommands, err = p.parseMovementValue(true)
 	if err != nil {
 		return nil, err
 	}
+	statement.EndPos = p.curToken.End
 
 	return statement, nil
 }
@@ -676,17 +1390,17 @@ func (p *Parser) parseMovementValue(allowMultiple bool) ([]string, error) {
 			if p.curToken.Type == token.MUL {
 				p.nextToken()
 				if p.curToken.Type != token.INT {
-					return nil, fmt.Errorf("line %d: expected mulplier number for movement command, but got '%s' instead", p.curToken.LineNumber, p.curToken.Literal)
+					return nil, p.syntaxErrorf(p.curToken, "expected mulplier number for movement command, but got '%s' instead", p.curToken.Literal)
 				}
 				num, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 				if err != nil {
-					return nil, fmt.Errorf("line %d: invalid movement mulplier integer '%s': %s", p.curToken.LineNumber, p.curToken.Literal, err.Error())
+					return nil, p.syntaxErrorf(p.curToken, "invalid movement mulplier integer '%s': %s", p.curToken.Literal, err.Error())
 				}
 				if num <= 0 {
-					return nil, fmt.Errorf("line %d: movement mulplier must be a positive integer, but got '%s' instead", p.curToken.LineNumber, p.curToken.Literal)
+					return nil, p.syntaxErrorf(p.curToken, "movement mulplier must be a positive integer, but got '%s' instead", p.curToken.Literal)
 				}
 				if num > 9999 {
-					return nil, fmt.Errorf("line %d: movement mulplier '%s' is too large. Maximum is 9999", p.curToken.LineNumber, p.curToken.Literal)
+					return nil, p.syntaxErrorf(p.curToken, "movement mulplier '%s' is too large. Maximum is 9999", p.curToken.Literal)
 				}
 				var i int64
 				for i = 0; i < num; i++ {
@@ -698,7 +1412,7 @@ func (p *Parser) parseMovementValue(allowMultiple bool) ([]string, error) {
 				movementCommands = append(movementCommands, moveCommand)
 			}
 		} else {
-			return nil, fmt.Errorf("line %d: expected movement command, but got '%s' instead", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, p.syntaxErrorf(p.curToken, "expected movement command, but got '%s' instead", p.curToken.Literal)
 		}
 		if !allowMultiple {
 			break
@@ -708,7 +1422,7 @@ func (p *Parser) parseMovementValue(allowMultiple bool) ([]string, error) {
 }
 
 func (p *Parser) parsePoryswitchMovementStatement() ([]string, error) {
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	switchCase, switchValue, err := p.parsePoryswitchHeader()
 	if err != nil {
 		return nil, err
@@ -721,7 +1435,7 @@ func (p *Parser) parsePoryswitchMovementStatement() ([]string, error) {
 	if !ok {
 		movements, ok = cases["_"]
 		if !ok {
-			return nil, fmt.Errorf("line %d: no poryswitch case found for '%s=%s', which was specified with the '-s' option", startLineNumber, switchCase, switchValue)
+			return nil, tokenErrorf(ErrorCompileSwitch, startToken, "no poryswitch case found for '%s=%s', which was specified with the '-s' option", switchCase, switchValue)
 		}
 	}
 	p.nextToken()
@@ -730,13 +1444,13 @@ func (p *Parser) parsePoryswitchMovementStatement() ([]string, error) {
 
 func (p *Parser) parsePoryswitchMovementCases() (map[string][]string, error) {
 	movementCases := make(map[string][]string)
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	for p.curToken.Type != token.RBRACE {
 		if p.curToken.Type == token.EOF {
-			return nil, fmt.Errorf("line %d: missing closing curly braces for poryswitch statement", startLineNumber)
+			return nil, p.syntaxErrorf(startToken, "missing closing curly braces for poryswitch statement")
 		}
 		if p.curToken.Type != token.IDENT && p.curToken.Type != token.INT {
-			return nil, fmt.Errorf("line %d: invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, p.syntaxErrorf(p.curToken, "invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.Literal)
 		}
 		caseValue := p.curToken.Literal
 		p.nextToken()
@@ -750,12 +1464,12 @@ func (p *Parser) parsePoryswitchMovementCases() (map[string][]string, error) {
 			movementCases[caseValue] = movements
 			if usedBrace {
 				if p.curToken.Type != token.RBRACE {
-					return nil, fmt.Errorf("line %d: missing closing curly brace for poryswitch case '%s'", startLineNumber, caseValue)
+					return nil, p.syntaxErrorf(startToken, "missing closing curly brace for poryswitch case '%s'", caseValue)
 				}
 				p.nextToken()
 			}
 		} else {
-			return nil, fmt.Errorf("line %d: invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.LineNumber, p.curToken.Literal, caseValue)
+			return nil, p.syntaxErrorf(p.curToken, "invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.Literal, caseValue)
 		}
 	}
 	return movementCases, nil
@@ -772,7 +1486,7 @@ func (p *Parser) parseMartStatement() (*ast.MartStatement, error) {
 	}
 	statement.Scope = scope
 	if err := p.expectPeek(token.IDENT); err != nil {
-		return nil, fmt.Errorf("line %d: missing name for mart statement", p.curToken.LineNumber)
+		return nil, p.syntaxErrorf(p.curToken, "missing name for mart statement")
 	}
 
 	statement.Name = &ast.Identifier{
@@ -781,13 +1495,14 @@ func (p *Parser) parseMartStatement() (*ast.MartStatement, error) {
 	}
 
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return nil, fmt.Errorf("line %d: missing opening curly brace for mart '%s'", p.peekToken.LineNumber, statement.Name.Value)
+		return nil, p.syntaxErrorf(p.peekToken, "missing opening curly brace for mart '%s'", statement.Name.Value)
 	}
 	p.nextToken()
 	statement.MartItems, err = p.parseMartValue(true)
 	if err != nil {
 		return nil, err
 	}
+	statement.EndPos = p.curToken.End
 
 	return statement, nil
 }
@@ -806,7 +1521,7 @@ func (p *Parser) parseMartValue(allowMultiple bool) ([]string, error) {
 			p.nextToken()
 			martCommands = append(martCommands, martCommand)
 		} else {
-			return nil, fmt.Errorf("line %d: expected mart item, but got '%s' instead", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, p.syntaxErrorf(p.curToken, "expected mart item, but got '%s' instead", p.curToken.Literal)
 		}
 		if !allowMultiple {
 			break
@@ -816,7 +1531,7 @@ func (p *Parser) parseMartValue(allowMultiple bool) ([]string, error) {
 }
 
 func (p *Parser) parsePoryswitchMartStatement() ([]string, error) {
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	switchCase, switchValue, err := p.parsePoryswitchHeader()
 	if err != nil {
 		return nil, err
@@ -829,7 +1544,7 @@ func (p *Parser) parsePoryswitchMartStatement() ([]string, error) {
 	if !ok {
 		items, ok = cases["_"]
 		if !ok {
-			return nil, fmt.Errorf("line %d: no poryswitch case found for '%s=%s', which was specified with the '-s' option", startLineNumber, switchCase, switchValue)
+			return nil, tokenErrorf(ErrorCompileSwitch, startToken, "no poryswitch case found for '%s=%s', which was specified with the '-s' option", switchCase, switchValue)
 		}
 	}
 	p.nextToken()
@@ -838,13 +1553,13 @@ func (p *Parser) parsePoryswitchMartStatement() ([]string, error) {
 
 func (p *Parser) parsePoryswitchMartCases() (map[string][]string, error) {
 	martCases := make(map[string][]string)
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	for p.curToken.Type != token.RBRACE {
 		if p.curToken.Type == token.EOF {
-			return nil, fmt.Errorf("line %d: missing closing curly braces for poryswitch statement", startLineNumber)
+			return nil, p.syntaxErrorf(startToken, "missing closing curly braces for poryswitch statement")
 		}
 		if p.curToken.Type != token.IDENT && p.curToken.Type != token.INT {
-			return nil, fmt.Errorf("line %d: invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, p.syntaxErrorf(p.curToken, "invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.Literal)
 		}
 		caseValue := p.curToken.Literal
 		p.nextToken()
@@ -858,28 +1573,139 @@ func (p *Parser) parsePoryswitchMartCases() (map[string][]string, error) {
 			martCases[caseValue] = items
 			if usedBrace {
 				if p.curToken.Type != token.RBRACE {
-					return nil, fmt.Errorf("line %d: missing closing curly brace for poryswitch case '%s'", startLineNumber, caseValue)
+					return nil, p.syntaxErrorf(startToken, "missing closing curly brace for poryswitch case '%s'", caseValue)
 				}
 				p.nextToken()
 			}
 		} else {
-			return nil, fmt.Errorf("line %d: invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.LineNumber, p.curToken.Literal, caseValue)
+			return nil, p.syntaxErrorf(p.curToken, "invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.Literal, caseValue)
 		}
 	}
 	return martCases, nil
 }
 
+// The sizes of the values of each data type, in bytes.
+var dataTypeSizes = map[string]int{
+	".byte":  1,
+	".2byte": 2,
+	".4byte": 4,
+}
+
+func (p *Parser) parseDataStatement() (*ast.DataStatement, error) {
+	statement := &ast.DataStatement{
+		Token: p.curToken,
+		Rows:  []ast.DataRow{},
+	}
+	scope, err := p.parseScopeModifier(token.LOCAL)
+	if err != nil {
+		return nil, err
+	}
+	statement.Scope = scope
+	if err := p.expectPeek(token.IDENT); err != nil {
+		return nil, p.syntaxErrorf(p.curToken, "missing name for data statement")
+	}
+
+	statement.Name = &ast.Identifier{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+
+	if err := p.expectPeek(token.LBRACE); err != nil {
+		return nil, p.syntaxErrorf(p.peekToken, "missing opening curly brace for data '%s'", statement.Name.Value)
+	}
+	p.nextToken()
+	for p.curToken.Type != token.RBRACE {
+		if p.curToken.Type == token.EOF {
+			return nil, p.syntaxErrorf(statement.Token, "missing closing curly brace for data '%s'", statement.Name.Value)
+		}
+		row, err := p.parseDataRow()
+		if err != nil {
+			return nil, err
+		}
+		statement.Rows = append(statement.Rows, row)
+	}
+	statement.EndPos = p.curToken.End
+
+	return statement, nil
+}
+
+// Parses a row of a data statement, like ".2byte: 1, 2, 3".
+func (p *Parser) parseDataRow() (ast.DataRow, error) {
+	row := ast.DataRow{Values: []string{}}
+	if p.curToken.Type != token.DATATYPE {
+		return row, p.syntaxErrorf(p.curToken, "expected data type, like '.2byte', but got '%s' instead", p.curToken.Literal)
+	}
+	dataType := p.curToken.Literal
+	size, ok := dataTypeSizes[dataType]
+	if !ok {
+		return row, p.syntaxErrorf(p.curToken, "invalid data type '%s'. Expected '.byte', '.2byte', or '.4byte'", dataType)
+	}
+	row.Size = size
+	if err := p.expectPeek(token.COLON); err != nil {
+		return row, p.syntaxErrorf(p.peekToken, "missing ':' after data type '%s'", dataType)
+	}
+	p.nextToken()
+	for {
+		if p.curToken.Type != token.IDENT && p.curToken.Type != token.INT {
+			return row, p.syntaxErrorf(p.curToken, "expected data value, but got '%s' instead", p.curToken.Literal)
+		}
+		row.Values = append(row.Values, p.tryReplaceWithConstant(p.curToken.Literal))
+		p.nextToken()
+		if p.curToken.Type != token.COMMA {
+			return row, nil
+		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseAliasStatement() (*ast.AliasStatement, error) {
+	statement := &ast.AliasStatement{
+		Token: p.curToken,
+	}
+	scope, err := p.parseScopeModifier(token.GLOBAL)
+	if err != nil {
+		return nil, err
+	}
+	statement.Scope = scope
+	if err := p.expectPeek(token.IDENT); err != nil {
+		return nil, p.syntaxErrorf(p.curToken, "missing name for alias statement")
+	}
+
+	statement.Name = &ast.Identifier{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+
+	if err := p.expectPeek(token.ASSIGN); err != nil {
+		return nil, p.syntaxErrorf(p.peekToken, "missing equals sign after alias name '%s'", statement.Name.Value)
+	}
+	if err := p.expectPeek(token.IDENT); err != nil {
+		return nil, p.syntaxErrorf(p.peekToken, "missing label for alias '%s'", statement.Name.Value)
+	}
+	statement.Target = &ast.Identifier{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+	if statement.Target.Value == statement.Name.Value {
+		return nil, p.syntaxErrorf(p.curToken, "alias '%s' can't refer to itself", statement.Name.Value)
+	}
+	statement.EndPos = p.curToken.End
+
+	return statement, nil
+}
+
 func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText, error) {
+	mapscriptsToken := p.curToken
 	scope, err := p.parseScopeModifier(token.GLOBAL)
 	if err != nil {
 		return nil, nil, err
 	}
 	if err := p.expectPeek(token.IDENT); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing name for mapscripts statement", p.curToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing name for mapscripts statement")
 	}
 
 	statement := &ast.MapScriptsStatement{
-		Token: p.curToken,
+		Token: mapscriptsToken,
 		Name: &ast.Identifier{
 			Token: p.curToken,
 			Value: p.curToken.Literal,
@@ -891,21 +1717,23 @@ func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText
 	implicitTexts := make([]impText, 0)
 
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing opening curly brace for mapscripts '%s'", p.peekToken.LineNumber, statement.Name.Value)
+		return nil, nil, p.syntaxErrorf(p.peekToken, "missing opening curly brace for mapscripts '%s'", statement.Name.Value)
 	}
 	p.nextToken()
 
 	for p.curToken.Type != token.RBRACE {
 		if p.curToken.Type != token.IDENT {
-			return nil, nil, fmt.Errorf("line %d: expected map script type, but got '%s' instead", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, nil, p.syntaxErrorf(p.curToken, "expected map script type, but got '%s' instead", p.curToken.Literal)
 		}
+		mapScriptToken := p.curToken
 		mapScriptType := p.curToken.Literal
 		p.nextToken()
 		if p.curToken.Type == token.COLON {
 			if err := p.expectPeek(token.IDENT); err != nil {
-				return nil, nil, fmt.Errorf("line %d: expected map script label after ':', but got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+				return nil, nil, p.syntaxErrorf(p.peekToken, "expected map script label after ':', but got '%s' instead", p.peekToken.Literal)
 			}
 			statement.MapScripts = append(statement.MapScripts, ast.MapScript{
+				Token:  mapScriptToken,
 				Type:   mapScriptType,
 				Name:   p.curToken.Literal,
 				Script: nil,
@@ -920,14 +1748,17 @@ func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText
 			}
 			implicitTexts = append(implicitTexts, stmtTexts...)
 			statement.MapScripts = append(statement.MapScripts, ast.MapScript{
-				Type: mapScriptType,
-				Name: scriptName,
+				Token: mapScriptToken,
+				Type:  mapScriptType,
+				Name:  scriptName,
 				Script: &ast.ScriptStatement{
+					Token: mapScriptToken,
 					Name: &ast.Identifier{
 						Value: scriptName,
 					},
-					Body:  blockStmt,
-					Scope: token.LOCAL,
+					Body:   blockStmt,
+					Scope:  token.LOCAL,
+					EndPos: p.curToken.End,
 				},
 			})
 			p.nextToken()
@@ -937,7 +1768,8 @@ func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText
 			i := 0
 			for p.curToken.Type != token.RBRACKET {
 				var sb strings.Builder
-				startLineNumber := p.curToken.LineNumber
+				entryToken := p.curToken
+				startToken := p.curToken
 				for p.curToken.Type != token.COMMA {
 					if sb.Len() != 0 {
 						sb.WriteByte(' ')
@@ -945,16 +1777,16 @@ func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText
 					sb.WriteString(p.tryReplaceWithConstant(p.curToken.Literal))
 					p.nextToken()
 					if p.curToken.Type == token.EOF {
-						return nil, nil, fmt.Errorf("line %d: missing ',' to specify map script table entry comparison value", startLineNumber)
+						return nil, nil, p.syntaxErrorf(startToken, "missing ',' to specify map script table entry comparison value")
 					}
 				}
 				conditionValue := sb.String()
 				if len(conditionValue) == 0 {
-					return nil, nil, fmt.Errorf("line %d: expected condition for map script table entry, but it was empty", p.curToken.LineNumber)
+					return nil, nil, p.syntaxErrorf(p.curToken, "expected condition for map script table entry, but it was empty")
 				}
 				p.nextToken()
 				sb.Reset()
-				startLineNumber = p.curToken.LineNumber
+				startToken = p.curToken
 				for p.curToken.Type != token.COLON && p.curToken.Type != token.LBRACE {
 					if sb.Len() != 0 {
 						sb.WriteByte(' ')
@@ -962,19 +1794,20 @@ func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText
 					sb.WriteString(p.tryReplaceWithConstant(p.curToken.Literal))
 					p.nextToken()
 					if p.curToken.Type == token.EOF {
-						return nil, nil, fmt.Errorf("line %d: missing ':' or '{' to specify map script table entry", startLineNumber)
+						return nil, nil, p.syntaxErrorf(startToken, "missing ':' or '{' to specify map script table entry")
 					}
 				}
 				comparisonValue := sb.String()
 				if len(comparisonValue) == 0 {
-					return nil, nil, fmt.Errorf("line %d: expected comparison value for map script table entry, but it was empty", p.curToken.LineNumber)
+					return nil, nil, p.syntaxErrorf(p.curToken, "expected comparison value for map script table entry, but it was empty")
 				}
 
 				if p.curToken.Type == token.COLON {
 					if err := p.expectPeek(token.IDENT); err != nil {
-						return nil, nil, fmt.Errorf("line %d: expected map script label after ':', but got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+						return nil, nil, p.syntaxErrorf(p.peekToken, "expected map script label after ':', but got '%s' instead", p.peekToken.Literal)
 					}
 					tableEntries = append(tableEntries, ast.TableMapScriptEntry{
+						Token:      entryToken,
 						Condition:  conditionValue,
 						Comparison: comparisonValue,
 						Name:       p.curToken.Literal,
@@ -990,15 +1823,18 @@ func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText
 					}
 					implicitTexts = append(implicitTexts, stmtTexts...)
 					tableEntries = append(tableEntries, ast.TableMapScriptEntry{
+						Token:      entryToken,
 						Condition:  conditionValue,
 						Comparison: comparisonValue,
 						Name:       scriptName,
 						Script: &ast.ScriptStatement{
+							Token: entryToken,
 							Name: &ast.Identifier{
 								Value: scriptName,
 							},
-							Body:  blockStmt,
-							Scope: token.LOCAL,
+							Body:   blockStmt,
+							Scope:  token.LOCAL,
+							EndPos: p.curToken.End,
 						},
 					})
 					p.nextToken()
@@ -1014,12 +1850,13 @@ func (p *Parser) parseMapscriptsStatement() (*ast.MapScriptsStatement, []impText
 		}
 	}
 
+	statement.EndPos = p.curToken.End
 	return statement, implicitTexts, nil
 }
 
 func (p *Parser) parseFormatStringOperator() (string, string, error) {
 	if err := p.expectPeek(token.LPAREN); err != nil {
-		return "", "", fmt.Errorf("line %d: format operator must begin with an open parenthesis '('", p.peekToken.LineNumber)
+		return "", "", p.syntaxErrorf(p.peekToken, "format operator must begin with an open parenthesis '('")
 	}
 	stringType := ""
 	if p.peekTokenIs(token.STRINGTYPE) {
@@ -1027,9 +1864,9 @@ func (p *Parser) parseFormatStringOperator() (string, string, error) {
 		stringType = p.curToken.Literal
 	}
 	if err := p.expectPeek(token.STRING); err != nil {
-		return "", "", fmt.Errorf("line %d: invalid format() argument '%s'. Expected a string literal", p.peekToken.LineNumber, p.peekToken.Literal)
+		return "", "", p.syntaxErrorf(p.peekToken, "invalid format() argument '%s'. Expected a string literal", p.peekToken.Literal)
 	}
-	lineNum := p.curToken.LineNumber
+	startToken := p.curToken
 	rawText := p.curToken.Literal
 	var fontID string
 	setFontID := false
@@ -1043,7 +1880,7 @@ func (p *Parser) parseFormatStringOperator() (string, string, error) {
 			if p.peekTokenIs(token.COMMA) {
 				p.nextToken()
 				if err := p.expectPeek(token.INT); err != nil {
-					return "", "", fmt.Errorf("line %d: invalid format() maxLineLength '%s'. Expected integer", p.peekToken.LineNumber, p.peekToken.Literal)
+					return "", "", p.syntaxErrorf(p.peekToken, "invalid format() maxLineLength '%s'. Expected integer", p.peekToken.Literal)
 				}
 				num, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
 				maxTextLength = int(num)
@@ -1055,22 +1892,25 @@ func (p *Parser) parseFormatStringOperator() (string, string, error) {
 			if p.peekTokenIs(token.COMMA) {
 				p.nextToken()
 				if err := p.expectPeek(token.STRING); err != nil {
-					return "", "", fmt.Errorf("line %d: invalid format() fontId '%s'. Expected string", p.peekToken.LineNumber, p.peekToken.Literal)
+					return "", "", p.syntaxErrorf(p.peekToken, "invalid format() fontId '%s'. Expected string", p.peekToken.Literal)
 				}
 				fontID = p.curToken.Literal
 				setFontID = true
 			}
 		} else {
-			return "", "", fmt.Errorf("line %d: invalid format() parameter '%s'. Expected either fontId (string) or maxLineLength (integer)", p.peekToken.LineNumber, p.peekToken.Literal)
+			return "", "", p.syntaxErrorf(p.peekToken, "invalid format() parameter '%s'. Expected either fontId (string) or maxLineLength (integer)", p.peekToken.Literal)
 		}
 	}
 	if err := p.expectPeek(token.RPAREN); err != nil {
-		return "", "", fmt.Errorf("line %d: missing closing parenthesis ')' for format()", p.peekToken.LineNumber)
+		return "", "", p.syntaxErrorf(p.peekToken, "missing closing parenthesis ')' for format()")
+	}
+	if p.syntaxOnly {
+		return rawText, stringType, nil
 	}
 	if p.fonts == nil {
 		fw, err := LoadFontWidths(p.fontConfigFilepath)
 		if err != nil {
-			log.Printf("PORYSCRIPT WARNING: Failed to load fonts JSON config file. Text auto-formatting will not work. Please specify a valid font config filepath with -fw option. '%s'\n", err.Error())
+			p.addWarning(WarningFontConfig, startToken.Pos(), fmt.Sprintf("failed to load fonts JSON config file. Text auto-formatting will not work. Please specify a valid font config filepath with -fw option. '%s'", err.Error()))
 		}
 		p.fonts = &fw
 	}
@@ -1079,7 +1919,7 @@ func (p *Parser) parseFormatStringOperator() (string, string, error) {
 	}
 	formatted, err := p.fonts.FormatText(rawText, maxTextLength, fontID)
 	if err != nil {
-		return "", "", fmt.Errorf("line %d: %s", lineNum, err.Error())
+		return "", "", tokenErrorf(ErrorArguments, startToken, "%s", err.Error())
 	}
 	return formatted, stringType, nil
 }
@@ -1113,7 +1953,7 @@ func (p *Parser) parseIfStatement(scriptName string) (*ast.IfStatement, []impTex
 	if p.peekToken.Type == token.ELSE {
 		p.nextToken()
 		if err := p.expectPeek(token.LBRACE); err != nil {
-			return nil, nil, fmt.Errorf("line %d: missing opening curly brace of else statement", p.curToken.LineNumber)
+			return nil, nil, p.syntaxErrorf(p.curToken, "missing opening curly brace of else statement")
 		}
 		p.nextToken()
 		blockStmt, stmtTexts, err := p.parseBlockStatement(scriptName)
@@ -1124,6 +1964,7 @@ func (p *Parser) parseIfStatement(scriptName string) (*ast.IfStatement, []impTex
 		statement.ElseConsequence = blockStmt
 	}
 
+	statement.EndPos = p.curToken.End
 	return statement, implicitTexts, nil
 }
 
@@ -1144,6 +1985,7 @@ func (p *Parser) parseWhileStatement(scriptName string) (*ast.WhileStatement, []
 	p.popBreakStack()
 	p.popContinueStack()
 	statement.Consequence = consequence
+	statement.EndPos = p.curToken.End
 
 	return statement, implicitTexts, nil
 }
@@ -1159,7 +2001,7 @@ func (p *Parser) parseDoWhileStatement(scriptName string) (*ast.DoWhileStatement
 	expression := &ast.ConditionExpression{}
 
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing opening curly brace of do...while statement", p.curToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing opening curly brace of do...while statement")
 	}
 	p.nextToken()
 	blockStmt, stmtTexts, err := p.parseBlockStatement(scriptName)
@@ -1172,10 +2014,10 @@ func (p *Parser) parseDoWhileStatement(scriptName string) (*ast.DoWhileStatement
 	p.popContinueStack()
 
 	if err := p.expectPeek(token.WHILE); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing 'while' after body of do...while statement", p.curToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing 'while' after body of do...while statement")
 	}
 	if err := p.expectPeek(token.LPAREN); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing '(' to start condition for do...while statement", p.curToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing '(' to start condition for do...while statement")
 	}
 
 	boolExpression, err := p.parseBooleanExpression(false, false)
@@ -1183,7 +2025,9 @@ func (p *Parser) parseDoWhileStatement(scriptName string) (*ast.DoWhileStatement
 		return nil, nil, err
 	}
 	expression.Expression = boolExpression
+	expression.EndPos = p.curToken.End
 	statement.Consequence = expression
+	statement.EndPos = p.curToken.End
 	return statement, implicitTexts, nil
 }
 
@@ -1193,7 +2037,7 @@ func (p *Parser) parseBreakStatement(scriptName string) (*ast.BreakStatement, er
 	}
 
 	if p.peekBreakStack() == nil {
-		return nil, fmt.Errorf("line %d: 'break' statement outside of any break-able scope", p.curToken.LineNumber)
+		return nil, p.syntaxErrorf(p.curToken, "'break' statement outside of any break-able scope")
 	}
 	statement.ScopeStatment = p.peekBreakStack()
 
@@ -1206,12 +2050,12 @@ func (p *Parser) parseContinueStatement(scriptName string) (*ast.ContinueStateme
 	}
 
 	if p.peekContinueStack() == nil {
-		return nil, fmt.Errorf("line %d: 'continue' statement outside of any continue-able scope", p.curToken.LineNumber)
+		return nil, p.syntaxErrorf(p.curToken, "'continue' statement outside of any continue-able scope")
 	}
 	statement.LoopStatment = p.peekContinueStack()
 
 	if p.peekToken.Type != token.RBRACE {
-		return nil, fmt.Errorf("line %d: 'continue' must be the last statement in block scope", p.peekToken.LineNumber)
+		return nil, p.syntaxErrorf(p.peekToken, "'continue' must be the last statement in block scope")
 	}
 
 	return statement, nil
@@ -1224,23 +2068,23 @@ func (p *Parser) parseSwitchStatement(scriptName string) (*ast.SwitchStatement,
 	}
 	implicitTexts := make([]impText, 0)
 	p.pushBreakStack(statement)
-	originalLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 
 	if err := p.expectPeek(token.LPAREN); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing opening parenthesis of switch statement operand", p.curToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing opening parenthesis of switch statement operand")
 	}
 	if err := p.expectPeek(token.VAR); err != nil {
-		return nil, nil, fmt.Errorf("line %d: invalid switch statement operand '%s'. Must be 'var`", p.peekToken.LineNumber, p.peekToken.Literal)
+		return nil, nil, p.syntaxErrorf(p.peekToken, "invalid switch statement operand '%s'. Must be 'var`", p.peekToken.Literal)
 	}
 	if err := p.expectPeek(token.LPAREN); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing '(' after var operator. Got '%s` instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return nil, nil, p.syntaxErrorf(p.peekToken, "missing '(' after var operator. Got '%s` instead", p.peekToken.Literal)
 	}
 
 	p.nextToken()
 	parts := []string{}
 	for p.curToken.Type != token.RPAREN {
 		if p.curToken.Type == token.EOF {
-			return nil, nil, fmt.Errorf("line %d: missing closing parenthesis of switch statement value", originalLineNumber)
+			return nil, nil, p.syntaxErrorf(startToken, "missing closing parenthesis of switch statement value")
 		}
 		parts = append(parts, p.tryReplaceWithConstant(p.curToken.Literal))
 		p.nextToken()
@@ -1249,29 +2093,30 @@ func (p *Parser) parseSwitchStatement(scriptName string) (*ast.SwitchStatement,
 	statement.Operand = strings.Join(parts, " ")
 
 	if err := p.expectPeek(token.LBRACE); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing opening curly brace of switch statement", p.curToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.curToken, "missing opening curly brace of switch statement")
 	}
 	p.nextToken()
 
 	// Parse each of the switch cases, including "default".
 	caseValues := make(map[string]bool)
 	for p.curToken.Type != token.RBRACE {
+		caseToken := p.curToken
 		if p.curToken.Type == token.CASE {
-			caseLineNum := p.curToken.LineNumber
 			p.nextToken()
 			parts := []string{}
 			for p.curToken.Type != token.COLON {
 				parts = append(parts, p.tryReplaceWithConstant(p.curToken.Literal))
 				p.nextToken()
 				if p.curToken.Type == token.EOF {
-					return nil, nil, fmt.Errorf("line %d: missing `:` after 'case'", caseLineNum)
+					return nil, nil, p.syntaxErrorf(caseToken, "missing `:` after 'case'")
 				}
 			}
 			caseValue := strings.Join(parts, " ")
-			if caseValues[caseValue] {
-				return nil, nil, fmt.Errorf("line %d: duplicate switch cases detected for case '%s'", p.curToken.LineNumber, caseValue)
+			caseKey := getSwitchCaseKey(caseValue)
+			if caseValues[caseKey] {
+				return nil, nil, tokenErrorf(ErrorDuplicate, p.curToken, "duplicate switch cases detected for case '%s'", caseValue)
 			}
-			caseValues[caseValue] = true
+			caseValues[caseKey] = true
 			p.nextToken()
 
 			body, stmtTexts, err := p.parseSwitchBlockStatement(scriptName)
@@ -1280,15 +2125,17 @@ func (p *Parser) parseSwitchStatement(scriptName string) (*ast.SwitchStatement,
 			}
 			implicitTexts = append(implicitTexts, stmtTexts...)
 			statement.Cases = append(statement.Cases, &ast.SwitchCase{
-				Value: caseValue,
-				Body:  body,
+				Token:  caseToken,
+				Value:  caseValue,
+				Body:   body,
+				EndPos: p.prevToken.End,
 			})
 		} else if p.curToken.Type == token.DEFAULT {
 			if statement.DefaultCase != nil {
-				return nil, nil, fmt.Errorf("line %d: multiple `default` cases found in switch statement. Only one `default` case is allowed", p.peekToken.LineNumber)
+				return nil, nil, p.syntaxErrorf(p.peekToken, "multiple `default` cases found in switch statement. Only one `default` case is allowed")
 			}
 			if err := p.expectPeek(token.COLON); err != nil {
-				return nil, nil, fmt.Errorf("line %d: missing `:` after default", p.curToken.LineNumber)
+				return nil, nil, p.syntaxErrorf(p.curToken, "missing `:` after default")
 			}
 			p.nextToken()
 			body, stmtTexts, err := p.parseSwitchBlockStatement(scriptName)
@@ -1297,29 +2144,43 @@ func (p *Parser) parseSwitchStatement(scriptName string) (*ast.SwitchStatement,
 			}
 			implicitTexts = append(implicitTexts, stmtTexts...)
 			statement.Cases = append(statement.Cases, &ast.SwitchCase{
+				Token:     caseToken,
 				IsDefault: true,
 				Body:      body,
+				EndPos:    p.prevToken.End,
 			})
 			statement.DefaultCase = &ast.SwitchCase{
-				Body: body,
+				Token:  caseToken,
+				Body:   body,
+				EndPos: p.prevToken.End,
 			}
 		} else {
-			return nil, nil, fmt.Errorf("line %d: invalid start of switch case '%s'. Expected 'case' or 'default'", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, nil, p.syntaxErrorf(p.curToken, "invalid start of switch case '%s'. Expected 'case' or 'default'", p.curToken.Literal)
 		}
 	}
 
 	p.popBreakStack()
 
 	if len(statement.Cases) == 0 && statement.DefaultCase == nil {
-		return nil, nil, fmt.Errorf("line %d: switch statement has no cases or default case", originalLineNumber)
+		return nil, nil, p.syntaxErrorf(startToken, "switch statement has no cases or default case")
 	}
 
+	statement.EndPos = p.curToken.End
 	return statement, implicitTexts, nil
 }
 
+// Returns the value used to detect duplicate switch cases. Integer
+// literals are normalized, so that "16" and "0x10" are the same case.
+func getSwitchCaseKey(caseValue string) string {
+	if value, err := strconv.ParseInt(caseValue, 0, 64); err == nil {
+		return strconv.FormatInt(value, 10)
+	}
+	return caseValue
+}
+
 func (p *Parser) parseConditionExpression(scriptName string) (*ast.ConditionExpression, []impText, error) {
 	if err := p.expectPeek(token.LPAREN); err != nil {
-		return nil, nil, fmt.Errorf("line %d: missing '(' to start boolean expression", p.peekToken.LineNumber)
+		return nil, nil, p.syntaxErrorf(p.peekToken, "missing '(' to start boolean expression")
 	}
 
 	expression := &ast.ConditionExpression{}
@@ -1340,6 +2201,7 @@ func (p *Parser) parseConditionExpression(scriptName string) (*ast.ConditionExpr
 	}
 	implicitTexts = append(implicitTexts, stmtTexts...)
 	expression.Body = blockStmt
+	expression.EndPos = p.curToken.End
 	return expression, implicitTexts, nil
 }
 
@@ -1357,12 +2219,16 @@ func (p *Parser) parseBooleanExpression(single bool, negated bool) (ast.BooleanE
 			p.nextToken()
 			negatedNested = !negated
 		}
+		if err := p.enterNesting(p.curToken); err != nil {
+			return nil, err
+		}
 		nestedExpression, err := p.parseBooleanExpression(false, negatedNested)
+		p.exitNesting()
 		if err != nil {
 			return nil, err
 		}
 		if p.curToken.Type != token.RPAREN {
-			return nil, fmt.Errorf("line %d: missing closing ')' for nested boolean expression", p.curToken.LineNumber)
+			return nil, p.syntaxErrorf(p.curToken, "missing closing ')' for nested boolean expression")
 		}
 		if p.peekTokenIs(token.AND) || p.peekTokenIs(token.OR) {
 			p.nextToken()
@@ -1460,29 +2326,33 @@ func (p *Parser) parseLeafBooleanExpression() (*ast.OperatorExpression, error) {
 	if p.peekTokenIs(token.NOT) {
 		operatorExpression.Operator = token.EQ
 		p.nextToken()
+		operatorExpression.Token = p.curToken
 		usedNotOperator = true
 	}
 
 	if !p.peekTokenIs(token.VAR) && !p.peekTokenIs(token.FLAG) && !p.peekTokenIs(token.DEFEATED) {
-		return nil, fmt.Errorf("line %d: left side of binary expression must be var(), flag(), or defeated() operator. Instead, found '%s'", p.curToken.LineNumber, p.peekToken.Literal)
+		return nil, p.syntaxErrorf(p.curToken, "left side of binary expression must be var(), flag(), or defeated() operator. Instead, found '%s'%s", p.peekToken.Literal, didYouMean(p.peekToken.Literal, []string{"var", "flag", "defeated"}))
 	}
 	p.nextToken()
 	operatorExpression.Type = p.curToken.Type
+	if !usedNotOperator {
+		operatorExpression.Token = p.curToken
+	}
 
 	if err := p.expectPeek(token.LPAREN); err != nil {
-		return nil, fmt.Errorf("line %d: missing opening parenthesis for condition operator '%s'", p.curToken.LineNumber, operatorExpression.Type)
+		return nil, p.syntaxErrorf(p.curToken, "missing opening parenthesis for condition operator '%s'", operatorExpression.Type)
 	}
 	if p.peekToken.Type == token.RPAREN {
-		return nil, fmt.Errorf("line %d: missing value for condition operator '%s'", p.curToken.LineNumber, operatorExpression.Type)
+		return nil, p.syntaxErrorf(p.curToken, "missing value for condition operator '%s'", operatorExpression.Type)
 	}
 	p.nextToken()
 	parts := []string{}
-	lineNum := p.curToken.LineNumber
+	startToken := p.curToken
 	for p.curToken.Type != token.RPAREN {
 		parts = append(parts, p.tryReplaceWithConstant(p.curToken.Literal))
 		p.nextToken()
 		if p.curToken.Type == token.EOF {
-			return nil, fmt.Errorf("line %d: missing closing ')' for condition operator value", lineNum)
+			return nil, p.syntaxErrorf(startToken, "missing closing ')' for condition operator value")
 		}
 	}
 	operatorExpression.Operand = strings.Join(parts, " ")
@@ -1513,6 +2383,7 @@ func (p *Parser) parseLeafBooleanExpression() (*ast.OperatorExpression, error) {
 		}
 	}
 
+	operatorExpression.EndPos = p.prevToken.End
 	return operatorExpression, nil
 }
 
@@ -1528,15 +2399,15 @@ func (p *Parser) parseConditionVarOperator(expression *ast.OperatorExpression) e
 	p.nextToken()
 
 	if p.curToken.Type == token.RPAREN {
-		return fmt.Errorf("line %d: missing comparison value for var operator", p.curToken.LineNumber)
+		return p.syntaxErrorf(p.curToken, "missing comparison value for var operator")
 	}
 	parts := []string{}
-	lineNum := p.curToken.LineNumber
+	startToken := p.curToken
 	for p.curToken.Type != token.RPAREN && p.curToken.Type != token.AND && p.curToken.Type != token.OR {
 		parts = append(parts, p.tryReplaceWithConstant(p.curToken.Literal))
 		p.nextToken()
 		if p.curToken.Type == token.EOF {
-			return fmt.Errorf("line %d: missing ')', '&&' or '||' when evaluating 'var' operator", lineNum)
+			return p.syntaxErrorf(startToken, "missing ')', '&&' or '||' when evaluating 'var' operator")
 		}
 	}
 
@@ -1556,11 +2427,11 @@ func (p *Parser) parseConditionFlagLikeOperator(expression *ast.OperatorExpressi
 	p.nextToken()
 
 	if p.curToken.Type == token.RPAREN {
-		return fmt.Errorf("line %d: missing comparison value for %s operator", p.curToken.LineNumber, operatorName)
+		return p.syntaxErrorf(p.curToken, "missing comparison value for %s operator", operatorName)
 	}
 
 	if p.curToken.Type != token.TRUE && p.curToken.Type != token.FALSE {
-		return fmt.Errorf("line %d: invalid %s comparison value '%s'. Only TRUE and FALSE are allowed", p.curToken.LineNumber, operatorName, p.curToken.Literal)
+		return p.syntaxErrorf(p.curToken, "invalid %s comparison value '%s'. Only TRUE and FALSE are allowed", operatorName, p.curToken.Literal)
 	}
 	expression.ComparisonValue = string(p.curToken.Type)
 	p.nextToken()
@@ -1586,7 +2457,7 @@ func (p *Parser) formatTextTerminator(text string, strType string) string {
 }
 
 func (p *Parser) parsePoryswitchStatement(scriptName string) ([]ast.Statement, []impText, error) {
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	switchCase, switchValue, err := p.parsePoryswitchHeader()
 	if err != nil {
 		return nil, nil, err
@@ -1599,14 +2470,14 @@ func (p *Parser) parsePoryswitchStatement(scriptName string) ([]ast.Statement, [
 	if !ok {
 		statements, ok = cases["_"]
 		if !ok {
-			return nil, nil, fmt.Errorf("line %d: no poryswitch case found for '%s=%s', which was specified with the '-s' option", startLineNumber, switchCase, switchValue)
+			return nil, nil, tokenErrorf(ErrorCompileSwitch, startToken, "no poryswitch case found for '%s=%s', which was specified with the '-s' option", switchCase, switchValue)
 		}
 	}
 	implicitTexts, ok := caseTexts[switchValue]
 	if !ok {
 		implicitTexts, ok = caseTexts["_"]
 		if !ok {
-			return nil, nil, fmt.Errorf("line %d: no poryswitch case found for '%s=%s', which was specified with the '-s' option", startLineNumber, switchCase, switchValue)
+			return nil, nil, tokenErrorf(ErrorCompileSwitch, startToken, "no poryswitch case found for '%s=%s', which was specified with the '-s' option", switchCase, switchValue)
 		}
 	}
 	return statements, implicitTexts, nil
@@ -1615,13 +2486,13 @@ func (p *Parser) parsePoryswitchStatement(scriptName string) ([]ast.Statement, [
 func (p *Parser) parsePoryswitchStatementCases(scriptName string) (map[string][]ast.Statement, map[string][]impText, error) {
 	statementCases := make(map[string][]ast.Statement)
 	implicitTexts := make(map[string][]impText)
-	startLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	for p.curToken.Type != token.RBRACE {
 		if p.curToken.Type == token.EOF {
-			return nil, nil, fmt.Errorf("line %d: missing closing curly braces for poryswitch statement", startLineNumber)
+			return nil, nil, p.syntaxErrorf(startToken, "missing closing curly braces for poryswitch statement")
 		}
 		if p.curToken.Type != token.IDENT && p.curToken.Type != token.INT {
-			return nil, nil, fmt.Errorf("line %d: invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.LineNumber, p.curToken.Literal)
+			return nil, nil, p.syntaxErrorf(p.curToken, "invalid poryswitch case '%s'. Expected a simple identifier", p.curToken.Literal)
 		}
 		caseValue := p.curToken.Literal
 		p.nextToken()
@@ -1636,12 +2507,12 @@ func (p *Parser) parsePoryswitchStatementCases(scriptName string) (map[string][]
 			implicitTexts[caseValue] = stmtTexts
 			if usedBrace {
 				if p.curToken.Type != token.RBRACE {
-					return nil, nil, fmt.Errorf("line %d: missing closing curly brace for poryswitch case '%s'", startLineNumber, caseValue)
+					return nil, nil, p.syntaxErrorf(startToken, "missing closing curly brace for poryswitch case '%s'", caseValue)
 				}
 				p.nextToken()
 			}
 		} else {
-			return nil, nil, fmt.Errorf("line %d: invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.LineNumber, p.curToken.Literal, caseValue)
+			return nil, nil, p.syntaxErrorf(p.curToken, "invalid token '%s' after poryswitch case '%s'. Expected ':' or '{'", p.curToken.Literal, caseValue)
 		}
 	}
 	return statementCases, implicitTexts, nil
@@ -1676,16 +2547,16 @@ func (p *Parser) parsePoryswitchStatements(scriptName string, allowMultiple bool
 }
 
 func (p *Parser) parseConstant() error {
-	initialLineNumber := p.curToken.LineNumber
+	startToken := p.curToken
 	if err := p.expectPeek(token.IDENT); err != nil {
-		return fmt.Errorf("line %d: expected identifier after const, but got '%s' instead", p.peekToken.LineNumber, p.peekToken.Literal)
+		return p.syntaxErrorf(p.peekToken, "expected identifier after const, but got '%s' instead", p.peekToken.Literal)
 	}
 	constName := p.curToken.Literal
 	if _, ok := p.constants[constName]; ok {
-		return fmt.Errorf("line %d: duplicate const '%s'. Must use unique const names", p.curToken.LineNumber, constName)
+		return tokenErrorf(ErrorDuplicate, p.curToken, "duplicate const '%s'. Must use unique const names", constName)
 	}
 	if err := p.expectPeek(token.ASSIGN); err != nil {
-		return fmt.Errorf("line %d: missing equals sign after const name '%s'", p.peekToken.LineNumber, constName)
+		return p.syntaxErrorf(p.peekToken, "missing equals sign after const name '%s'", constName)
 	}
 
 	var sb strings.Builder
@@ -1702,13 +2573,304 @@ func (p *Parser) parseConstant() error {
 	}
 
 	if sb.Len() == 0 {
-		return fmt.Errorf("line %d: missing value for const '%s'", initialLineNumber, constName)
+		return p.syntaxErrorf(startToken, "missing value for const '%s'", constName)
 	}
 	p.constants[constName] = sb.String()
 	return nil
 }
 
+func (p *Parser) parseMacro() error {
+	startToken := p.curToken
+	if err := p.expectPeek(token.IDENT); err != nil {
+		return p.syntaxErrorf(p.peekToken, "expected name after macro, but got '%s' instead", p.peekToken.Literal)
+	}
+	m := &macro{
+		name:   p.curToken.Literal,
+		params: []string{},
+		body:   []token.Token{},
+	}
+	if _, ok := p.macros[m.name]; ok {
+		return tokenErrorf(ErrorDuplicate, p.curToken, "duplicate macro '%s'. Must use unique macro names", m.name)
+	}
+
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		for !p.peekTokenIs(token.RPAREN) {
+			if err := p.expectPeek(token.IDENT); err != nil {
+				return p.syntaxErrorf(p.peekToken, "expected parameter name for macro '%s', but got '%s' instead", m.name, p.peekToken.Literal)
+			}
+			for _, param := range m.params {
+				if param == p.curToken.Literal {
+					return tokenErrorf(ErrorDuplicate, p.curToken, "duplicate parameter '%s' for macro '%s'", param, m.name)
+				}
+			}
+			m.params = append(m.params, p.curToken.Literal)
+			if p.peekTokenIs(token.COMMA) {
+				p.nextToken()
+			} else if !p.peekTokenIs(token.RPAREN) {
+				return p.syntaxErrorf(p.peekToken, "missing closing parenthesis for parameters of macro '%s'", m.name)
+			}
+		}
+		p.nextToken()
+	}
+
+	if err := p.expectPeek(token.LBRACE); err != nil {
+		return p.syntaxErrorf(p.peekToken, "missing opening curly brace for macro '%s'", m.name)
+	}
+	p.nextToken()
+	numOpenBraces := 0
+	for !(p.curToken.Type == token.RBRACE && numOpenBraces == 0) {
+		if p.curToken.Type == token.EOF {
+			return p.syntaxErrorf(startToken, "missing closing curly brace for macro '%s'", m.name)
+		}
+		if p.curToken.Type == token.LBRACE {
+			numOpenBraces++
+		} else if p.curToken.Type == token.RBRACE {
+			numOpenBraces--
+		}
+		m.body = append(m.body, p.curToken)
+		p.nextToken()
+	}
+
+	p.macros[m.name] = m
+	return nil
+}
+
+func (p *Parser) parseMacroExpansion(scriptName string) (*ast.BlockStatement, []impText, error) {
+	m := p.macros[p.curToken.Literal]
+	callToken := p.curToken
+	if p.macroDepth >= maxMacroExpansionDepth {
+		return nil, nil, p.syntaxErrorf(callToken, "maximum macro expansion depth exceeded when expanding macro '%s'. Is it recursive?", m.name)
+	}
+
+	args := [][]token.Token{}
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		p.nextToken()
+		arg := []token.Token{}
+		numOpenParens := 0
+		for !(p.curToken.Type == token.RPAREN && numOpenParens == 0) {
+			if p.curToken.Type == token.EOF {
+				return nil, nil, p.syntaxErrorf(callToken, "missing closing parenthesis for macro '%s'", m.name)
+			}
+			if p.curToken.Type == token.COMMA && numOpenParens == 0 {
+				args = append(args, arg)
+				arg = []token.Token{}
+			} else {
+				if p.curToken.Type == token.LPAREN {
+					numOpenParens++
+				} else if p.curToken.Type == token.RPAREN {
+					numOpenParens--
+				}
+				arg = append(arg, p.curToken)
+			}
+			p.nextToken()
+		}
+		if len(arg) > 0 || len(args) > 0 {
+			args = append(args, arg)
+		}
+	}
+	if len(args) != len(m.params) {
+		return nil, nil, tokenErrorf(ErrorArguments, callToken, "macro '%s' expects %d arguments, but got %d", m.name, len(m.params), len(args))
+	}
+
+	// Substitute the arguments into the macro's body, and inject the
+	// resulting tokens as a block statement.
+	// The braces are placed at the end of the macro call, so that the spans
+	// of the enclosing statements end there.
+	callEnd := p.curToken.End
+	lbrace := token.Token{Type: token.LBRACE, Literal: "{", Filepath: callToken.Filepath, LineNumber: callToken.LineNumber, Column: callEnd.Column, Offset: callEnd.Offset, End: callEnd}
+	rbrace := token.Token{Type: token.RBRACE, Literal: "}", Filepath: callToken.Filepath, LineNumber: callToken.LineNumber, Column: callEnd.Column, Offset: callEnd.Offset, End: callEnd}
+	expanded := []token.Token{lbrace}
+	for _, tok := range m.body {
+		substituted := false
+		if tok.Type == token.IDENT {
+			for i, param := range m.params {
+				if tok.Literal == param {
+					expanded = append(expanded, args[i]...)
+					substituted = true
+					break
+				}
+			}
+		}
+		if !substituted {
+			expanded = append(expanded, tok)
+		}
+	}
+	expanded = append(expanded, rbrace)
+	p.injectTokens(expanded)
+	p.nextToken()
+	p.nextToken()
+
+	// The macro's body is inlined into the enclosing block, so it doesn't
+	// count towards the nesting limit.
+	p.macroDepth++
+	p.nestingDepth--
+	block, implicitTexts, err := p.parseBlockStatement(scriptName)
+	p.nestingDepth++
+	p.macroDepth--
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, implicitTexts, nil
+}
+
+func (p *Parser) parseTextTemplate() error {
+	if err := p.expectPeek(token.IDENT); err != nil {
+		return p.syntaxErrorf(p.peekToken, "expected name after texttemplate, but got '%s' instead", p.peekToken.Literal)
+	}
+	template := &textTemplate{
+		name:   p.curToken.Literal,
+		params: []string{},
+	}
+	if _, ok := p.textTemplates[template.name]; ok {
+		return tokenErrorf(ErrorDuplicate, p.curToken, "duplicate texttemplate '%s'. Must use unique texttemplate names", template.name)
+	}
+	if err := p.expectPeek(token.LPAREN); err != nil {
+		return p.syntaxErrorf(p.peekToken, "missing opening parenthesis for parameters of texttemplate '%s'", template.name)
+	}
+	for !p.peekTokenIs(token.RPAREN) {
+		if err := p.expectPeek(token.IDENT); err != nil {
+			return p.syntaxErrorf(p.peekToken, "expected parameter name for texttemplate '%s', but got '%s' instead", template.name, p.peekToken.Literal)
+		}
+		template.params = append(template.params, p.curToken.Literal)
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.peekTokenIs(token.RPAREN) {
+			return p.syntaxErrorf(p.peekToken, "missing closing parenthesis for parameters of texttemplate '%s'", template.name)
+		}
+	}
+	p.nextToken()
+	if err := p.expectPeek(token.ASSIGN); err != nil {
+		return p.syntaxErrorf(p.peekToken, "missing equals sign after parameters of texttemplate '%s'", template.name)
+	}
+	p.nextToken()
+	if p.curToken.Type == token.STRINGTYPE {
+		template.stringType = p.curToken.Literal
+		p.nextToken()
+	}
+	if p.curToken.Type != token.STRING {
+		return p.syntaxErrorf(p.curToken, "expected string value for texttemplate '%s', but got '%s' instead", template.name, p.curToken.Literal)
+	}
+	template.value = p.curToken.Literal
+	p.textTemplates[template.name] = template
+	return nil
+}
+
+// Reports whether the current token begins an instance of a text
+// template, such as Obtained("POTION").
+func (p *Parser) isTextTemplateInstance() bool {
+	_, ok := p.textTemplates[p.curToken.Literal]
+	return ok && p.curToken.Type == token.IDENT && p.peekTokenIs(token.LPAREN)
+}
+
+func (p *Parser) parseTextTemplateInstance() (string, string, error) {
+	template := p.textTemplates[p.curToken.Literal]
+	startToken := p.curToken
+	p.nextToken()
+	p.nextToken()
+	args := []string{}
+	argParts := []string{}
+	for p.curToken.Type != token.RPAREN {
+		if p.curToken.Type == token.EOF {
+			return "", "", p.syntaxErrorf(startToken, "missing closing parenthesis for texttemplate '%s'", template.name)
+		}
+		if p.curToken.Type == token.COMMA {
+			args = append(args, strings.Join(argParts, " "))
+			argParts = []string{}
+		} else {
+			argParts = append(argParts, p.curToken.Literal)
+		}
+		p.nextToken()
+	}
+	if len(argParts) > 0 || len(args) > 0 {
+		args = append(args, strings.Join(argParts, " "))
+	}
+	if len(args) != len(template.params) {
+		return "", "", tokenErrorf(ErrorArguments, startToken, "texttemplate '%s' expects %d arguments, but got %d", template.name, len(template.params), len(args))
+	}
+
+	value := template.value
+	for i, param := range template.params {
+		value = strings.ReplaceAll(value, "{"+param+"}", args[i])
+	}
+	return p.formatTextTerminator(value, template.stringType), template.stringType, nil
+}
+
+func (p *Parser) parseImport() error {
+	if err := p.expectPeek(token.STRING); err != nil {
+		return p.syntaxErrorf(p.peekToken, "expected filepath string after import, but got '%s' instead", p.peekToken.Literal)
+	}
+	importPath := p.curToken.Literal
+	if !filepath.IsAbs(importPath) && p.filepath != "" {
+		importPath = filepath.Join(filepath.Dir(p.filepath), importPath)
+	}
+	importPath = filepath.Clean(importPath)
+
+	importStack := p.importStack
+	if len(importStack) == 0 && p.filepath != "" {
+		importStack = []string{filepath.Clean(p.filepath)}
+	}
+	for i, path := range importStack {
+		if path == importPath {
+			cycle := append(importStack[i:len(importStack):len(importStack)], importPath)
+			return tokenErrorf(ErrorImport, p.curToken, "import cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if p.importedFiles[importPath] || p.syntaxOnly {
+		// The file was already imported, so its definitions are already
+		// available, or they aren't needed.
+		return nil
+	}
+	p.importedFiles[importPath] = true
+
+	input, err := p.loadFile(importPath)
+	if err != nil {
+		return tokenErrorf(ErrorImport, p.curToken, "failed to import '%s': %s", p.curToken.Literal, err.Error())
+	}
+
+	// The imported file shares definitions with the importing file, but its
+	// statements are not emitted. They are emitted when the imported
+	// file is compiled on its own.
+	importParser := New(lexer.NewWithMode(input, p.l.Mode()&lexer.CaseInsensitiveKeywords), p.fontConfigFilepath, p.compileSwitches)
+	importParser.SetFilepath(importPath)
+	importParser.loadFile = p.loadFile
+	importParser.fonts = p.fonts
+	importParser.paramVars = p.paramVars
+	importParser.nestingLimit = p.nestingLimit
+	importParser.commandSignatures = p.commandSignatures
+	importParser.constants = p.constants
+	importParser.macros = p.macros
+	importParser.textTemplates = p.textTemplates
+	importParser.importedFiles = p.importedFiles
+	importParser.importedScripts = p.importedScripts
+	importParser.importedLabels = p.importedLabels
+	importParser.importedDeprecations = p.importedDeprecations
+	importParser.importStack = append(importStack[:len(importStack):len(importStack)], importPath)
+	program, err := importParser.ParseProgram()
+	if err != nil {
+		return setErrorFilepath(err, importPath)
+	}
+	for _, stmt := range program.TopLevelStatements {
+		if scriptStmt, ok := stmt.(*ast.ScriptStatement); ok {
+			p.importedScripts[scriptStmt.Name.Value] = scriptStmt
+		}
+		if name, ok := getStatementName(stmt); ok {
+			if _, ok := p.importedLabels[name]; !ok {
+				p.importedLabels[name] = getLabelLocation(stmt, importPath)
+				if annotation, ok := ast.AnnotationsOf(stmt).Get("deprecated"); ok {
+					p.importedDeprecations[name] = annotation
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (p *Parser) tryReplaceWithConstant(value string) string {
+	if paramVar, ok := p.scriptParams[p.curToken.Literal]; ok {
+		return paramVar
+	}
 	if constValue, ok := p.constants[p.curToken.Literal]; ok {
 		return constValue
 	}
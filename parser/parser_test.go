@@ -1,7 +1,13 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/huderlem/poryscript/token"
 
@@ -689,6 +695,28 @@ text MyText2 {
 	}
 }
 
+func TestSetFontWidths(t *testing.T) {
+	input := `
+text MyText {
+	format("Hello there", "TEST", 12)
+}
+`
+	fonts := FontWidthsConfig{DefaultFontID: "TEST"}
+	p := New(lexer.New(input), "missing_font_widths.json", nil)
+	p.SetFontWidths(&fonts)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(p.Diagnostics()) != 0 {
+		t.Fatalf("Expected no diagnostics, but got %v", p.Warnings())
+	}
+	expected := "Hello\\n\nthere$"
+	if program.Texts[0].Value != expected {
+		t.Fatalf("Incorrect format() evaluation. Got '%s' instead of '%s'", program.Texts[0].Value, expected)
+	}
+}
+
 func TestMovementStatements(t *testing.T) {
 	input := `
 movement MyMovement {
@@ -789,6 +817,93 @@ func testMart(t *testing.T, stmt ast.Statement, expectedName string, expectedIte
 	}
 }
 
+func TestDataStatements(t *testing.T) {
+	input := `
+const COUNT = 3
+data MyData {
+	.byte: 1, 0x2, COUNT
+	.2byte: MULTI_YES
+	.4byte: MyData, -1
+}
+
+data(global) EmptyData {
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(program.TopLevelStatements) != 2 {
+		t.Fatalf("len(program.TopLevelStatements) != 2. Got '%d' instead.", len(program.TopLevelStatements))
+	}
+	testData(t, program.TopLevelStatements[0], "MyData", token.LOCAL, []ast.DataRow{
+		{Size: 1, Values: []string{"1", "0x2", "3"}},
+		{Size: 2, Values: []string{"MULTI_YES"}},
+		{Size: 4, Values: []string{"MyData", "-1"}},
+	})
+	testData(t, program.TopLevelStatements[1], "EmptyData", token.GLOBAL, []ast.DataRow{})
+}
+
+func testData(t *testing.T, stmt ast.Statement, expectedName string, expectedScope token.Type, expectedRows []ast.DataRow) {
+	dataStmt := stmt.(*ast.DataStatement)
+	if dataStmt.Name.Value != expectedName {
+		t.Errorf("Incorrect data name. Got '%s' instead of '%s'", dataStmt.Name.Value, expectedName)
+	}
+	if dataStmt.Scope != expectedScope {
+		t.Errorf("Incorrect data scope. Got '%s' instead of '%s'", dataStmt.Scope, expectedScope)
+	}
+	if len(dataStmt.Rows) != len(expectedRows) {
+		t.Fatalf("Incorrect number of data rows. Got %d rows instead of %d", len(dataStmt.Rows), len(expectedRows))
+	}
+	for i, row := range expectedRows {
+		actual := dataStmt.Rows[i]
+		if actual.Size != row.Size || strings.Join(actual.Values, ", ") != strings.Join(row.Values, ", ") {
+			t.Errorf("Incorrect data row at index %d. Got %d '%s' instead of %d '%s'", i, actual.Size, strings.Join(actual.Values, ", "), row.Size, strings.Join(row.Values, ", "))
+		}
+	}
+}
+
+func TestAliasStatements(t *testing.T) {
+	input := `
+script NewScript {}
+alias OldScript = NewScript
+alias(local) LocalAlias = OldScript
+script MyScript {
+	goto(LocalAlias)
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(program.TopLevelStatements) != 4 {
+		t.Fatalf("len(program.TopLevelStatements) != 4. Got '%d' instead.", len(program.TopLevelStatements))
+	}
+	tests := []struct {
+		name   string
+		target string
+		scope  token.Type
+	}{
+		{"OldScript", "NewScript", token.GLOBAL},
+		{"LocalAlias", "OldScript", token.LOCAL},
+	}
+	for i, tt := range tests {
+		aliasStmt := program.TopLevelStatements[i+1].(*ast.AliasStatement)
+		if aliasStmt.Name.Value != tt.name || aliasStmt.Target.Value != tt.target || aliasStmt.Scope != tt.scope {
+			t.Errorf("Incorrect alias %d. Got '%s' = '%s' (%s) instead of '%s' = '%s' (%s)", i, aliasStmt.Name.Value, aliasStmt.Target.Value, aliasStmt.Scope, tt.name, tt.target, tt.scope)
+		}
+	}
+	if len(p.Warnings()) != 0 {
+		t.Errorf("Expected no warnings, but got %v", p.Warnings())
+	}
+}
+
 func TestMapScriptStatements(t *testing.T) {
 	input := `
 mapscripts MyMap_MapScripts {
@@ -940,71 +1055,1619 @@ mapscripts(global) MapScripts3 {}
 		t.Fatalf(err.Error())
 	}
 
-	if len(program.TopLevelStatements) != 12 {
-		t.Fatalf("len(program.TopLevelStatements) != 12. Got '%d' instead.", len(program.TopLevelStatements))
+	if len(program.TopLevelStatements) != 12 {
+		t.Fatalf("len(program.TopLevelStatements) != 12. Got '%d' instead.", len(program.TopLevelStatements))
+	}
+	expectedScopes := []token.Type{
+		token.GLOBAL,
+		token.LOCAL,
+		token.GLOBAL,
+		token.GLOBAL,
+		token.LOCAL,
+		token.GLOBAL,
+		token.LOCAL,
+		token.LOCAL,
+		token.GLOBAL,
+		token.GLOBAL,
+		token.LOCAL,
+		token.GLOBAL,
+	}
+	for i, expectedScope := range expectedScopes {
+		testScope(t, i, program.TopLevelStatements[i], expectedScope)
+	}
+}
+
+func testScope(t *testing.T, i int, statement ast.Statement, expectedScope token.Type) {
+	if script, ok := statement.(*ast.ScriptStatement); ok {
+		if script.Scope != expectedScope {
+			t.Errorf("%d: Expected script scope %s, but got %s", i, expectedScope, script.Scope)
+		}
+	} else if text, ok := statement.(*ast.TextStatement); ok {
+		if text.Scope != expectedScope {
+			t.Errorf("%d: Expected text scope %s, but got %s", i, expectedScope, text.Scope)
+		}
+	} else if movement, ok := statement.(*ast.MovementStatement); ok {
+		if movement.Scope != expectedScope {
+			t.Errorf("%d: Expected movement scope %s, but got %s", i, expectedScope, movement.Scope)
+		}
+	} else if mapscripts, ok := statement.(*ast.MapScriptsStatement); ok {
+		if mapscripts.Scope != expectedScope {
+			t.Errorf("%d: Expected mapscripts scope %s, but got %s", i, expectedScope, mapscripts.Scope)
+		}
+	}
+}
+
+func TestConstants(t *testing.T) {
+	input := `
+const FOO = 2
+const BAR = FLAG_TEMP_1 +   3
+	- FLAG_BASE   const PROF_BIRCH = FOO+1
+const PROF_ELM = PROF_BIRCH - 1
+
+script Script1 {
+	command1(FOO)
+	command2(1, 2 + BAR)
+	command3(2, (PROF_ELM) - ((PROF_BIRCH + FOO)))
+	if (flag(PROF_ELM)) {}
+	if (var(PROF_BIRCH) == PROF_ELM +1) {}
+	switch (var(PROF_ELM)) {
+		case FOO: commandfood()
+		default: command()
+	}
+}
+
+mapscripts MyMapScript {
+	MAP_SCRIPT_ON_FRAME_TABLE [
+		PROF_ELM, FOO: MyOnFrameScript
+	]
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	script := program.TopLevelStatements[0].(*ast.ScriptStatement)
+	command1 := script.Body.Statements[0].(*ast.CommandStatement)
+	command2 := script.Body.Statements[1].(*ast.CommandStatement)
+	command3 := script.Body.Statements[2].(*ast.CommandStatement)
+	testConstant(t, "2", command1.Args[0])
+	testConstant(t, "2 + FLAG_TEMP_1 + 3 - FLAG_BASE", command2.Args[1])
+	testConstant(t, "( 2 + 1 - 1 ) - ( ( 2 + 1 + 2 ) )", command3.Args[1])
+
+	if1 := script.Body.Statements[3].(*ast.IfStatement)
+	op1 := if1.Consequence.Expression.(*ast.OperatorExpression)
+	testConstant(t, "2 + 1 - 1", op1.Operand)
+
+	if2 := script.Body.Statements[4].(*ast.IfStatement)
+	op2 := if2.Consequence.Expression.(*ast.OperatorExpression)
+	testConstant(t, "2 + 1", op2.Operand)
+	testConstant(t, "2 + 1 - 1 + 1", op2.ComparisonValue)
+
+	sw := script.Body.Statements[5].(*ast.SwitchStatement)
+	testConstant(t, "2 + 1 - 1", sw.Operand)
+	testConstant(t, "2", sw.Cases[0].Value)
+
+	ms := program.TopLevelStatements[1].(*ast.MapScriptsStatement)
+	frame := ms.TableMapScripts[0].Entries[0]
+	testConstant(t, "2 + 1 - 1", frame.Condition)
+	testConstant(t, "2", frame.Comparison)
+}
+
+func testConstant(t *testing.T, expected, actual string) {
+	if actual != expected {
+		t.Errorf("Expected '%s', but got '%s'", expected, actual)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	input := `
+@deprecated("use NewScript")
+@align(4)
+script OldScript {}
+
+@unused
+text MyText { "Hello" }
+
+movement MyMovement { walk_up }
+const FOO = 1
+@deprecated
+mart MyMart { ITEM_POTION }
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := []struct {
+		expectedAnnotations []ast.Annotation
+	}{
+		{[]ast.Annotation{{Name: "deprecated", Args: []string{"use NewScript"}}, {Name: "align", Args: []string{"4"}}}},
+		{[]ast.Annotation{{Name: "unused", Args: []string{}}}},
+		{[]ast.Annotation{}},
+		{[]ast.Annotation{{Name: "deprecated", Args: []string{}}}},
+	}
+	for i, tt := range tests {
+		annotations := ast.AnnotationsOf(program.TopLevelStatements[i])
+		if len(annotations) != len(tt.expectedAnnotations) {
+			t.Fatalf("statement %d: expected %d annotations, got %d", i, len(tt.expectedAnnotations), len(annotations))
+		}
+		for j, expected := range tt.expectedAnnotations {
+			if annotations[j].Name != expected.Name {
+				t.Errorf("statement %d: expected annotation name '%s', got '%s'", i, expected.Name, annotations[j].Name)
+			}
+			if strings.Join(annotations[j].Args, ",") != strings.Join(expected.Args, ",") {
+				t.Errorf("statement %d: expected annotation args '%v', got '%v'", i, expected.Args, annotations[j].Args)
+			}
+		}
+	}
+	if !program.Texts[0].Annotations.Has("unused") {
+		t.Errorf("expected text to have @unused annotation")
+	}
+}
+
+func TestDeprecatedReferences(t *testing.T) {
+	input := `
+@deprecated("use NewScript")
+script OldScript {
+	goto(OldScript)
+}
+
+@deprecated
+movement OldMovement { walk_up }
+
+script NewScript {
+	call(OldScript)
+	if (flag(FLAG_1)) {
+		applymovement(OBJ_EVENT_ID_PLAYER, OldMovement)
+	}
+}
+
+mapscripts MyMapScripts {
+	MAP_SCRIPT_ON_LOAD: OldScript
+	MAP_SCRIPT_ON_FRAME_TABLE [
+		VAR_TEMP_0, 0: OldScript
+	]
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := []string{
+		"line 11:2: 'call' in script 'NewScript' references deprecated 'OldScript': use NewScript",
+		"line 13:3: 'applymovement' in script 'NewScript' references deprecated 'OldMovement'",
+		"line 18:2: mapscripts 'MyMapScripts' references deprecated 'OldScript': use NewScript",
+		"line 20:3: mapscripts 'MyMapScripts' references deprecated 'OldScript': use NewScript",
+	}
+	warnings := p.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, warning := range warnings {
+		if warning != expected[i] {
+			t.Errorf("Expected warning '%s', but got '%s'", expected[i], warning)
+		}
+	}
+}
+
+func TestDeprecatedReferencesAcrossFiles(t *testing.T) {
+	files := map[string]string{
+		"scripts/a.pory": `
+@deprecated("use NewScript")
+script OldScript {
+	end
+}
+@deprecated
+movement(global) OldMovement { walk_up }
+@deprecated
+script(local) OldLocal {
+	return
+}
+script NewScript {
+	end
+}`,
+		"scripts/b.pory": `
+script B {
+	call(OldScript)
+	applymovement(OBJ_EVENT_ID_PLAYER, OldMovement)
+	call(NewScript)
+}
+mapscripts B_MapScripts {
+	MAP_SCRIPT_ON_LOAD: OldScript
+}`,
+		"scripts/c.pory": `
+import "a.pory"
+script C {
+	goto(OldScript)
+}`,
+		"scripts/shadows.pory": `
+import "a.pory"
+script(local) OldLocal {
+	return
+}
+script Shadows {
+	call(OldLocal)
+}`,
+	}
+	loader := func(path string) (string, error) {
+		input, ok := files[filepath.ToSlash(path)]
+		if !ok {
+			return "", fmt.Errorf("file not found")
+		}
+		return input, nil
+	}
+
+	// Deprecated labels of imported files.
+	p := New(lexer.New(files["scripts/c.pory"]), "", nil)
+	p.SetFilepath("scripts/c.pory")
+	p.SetFileLoader(loader)
+	if _, err := p.ParseProgram(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{"line 4:2: 'goto' in script 'C' references deprecated 'OldScript': use NewScript"}
+	if warnings := p.Warnings(); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings %q, but got %q", expected, warnings)
+	}
+
+	// A file's own label takes precedence over an imported one.
+	p = New(lexer.New(files["scripts/shadows.pory"]), "", nil)
+	p.SetFilepath("scripts/shadows.pory")
+	p.SetFileLoader(loader)
+	if _, err := p.ParseProgram(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if warnings := p.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, but got %q", warnings)
+	}
+
+	// Deprecated labels of the other files of a project. The label that is
+	// also imported is only reported once.
+	project := NewProject([]string{"scripts/a.pory", "scripts/b.pory", "scripts/c.pory"}, "", nil)
+	project.SetFileLoader(loader)
+	if _, err := project.ParseProject(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	expectedDiagnostics := []string{
+		"scripts/a.pory: line 9:15: local script 'OldLocal' is never referenced",
+		"scripts/b.pory: line 3:2: 'call' in script 'B' references deprecated 'OldScript': use NewScript",
+		"scripts/b.pory: line 4:2: 'applymovement' in script 'B' references deprecated 'OldMovement'",
+		"scripts/b.pory: line 8:2: mapscripts 'B_MapScripts' references deprecated 'OldScript': use NewScript",
+		"scripts/c.pory: line 4:2: 'goto' in script 'C' references deprecated 'OldScript': use NewScript",
+	}
+	var diagnostics []string
+	for _, diagnostic := range project.Diagnostics() {
+		diagnostics = append(diagnostics, filepath.ToSlash(diagnostic.String()))
+	}
+	if !reflect.DeepEqual(diagnostics, expectedDiagnostics) {
+		t.Errorf("Expected diagnostics %q, but got %q", expectedDiagnostics, diagnostics)
+	}
+}
+
+func TestEmptyBodyWarnings(t *testing.T) {
+	input := `
+script MyScript {
+	if (flag(FLAG_1)) {
+	} elif (flag(FLAG_2)) {
+		foo
+	} elif (flag(FLAG_3)) {
+	} else {}
+	while (var(VAR_1) < 2) {
+		if (flag(FLAG_4)) {
+			bar
+		}
+	}
+	do {
+	} while (flag(FLAG_5))
+	while (flag(FLAG_6)) {}
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{
+		"line 3:2: empty 'if' body in script 'MyScript'",
+		"line 7:2: empty 'elif' body in script 'MyScript'",
+		"line 7:10: empty 'else' body in script 'MyScript'",
+		"line 13:2: empty 'do' body in script 'MyScript'",
+		"line 15:2: empty 'while' body in script 'MyScript'",
+	}
+	warnings := p.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, warning := range warnings {
+		if warning != expected[i] {
+			t.Errorf("Expected warning '%s', but got '%s'", expected[i], warning)
+		}
+	}
+}
+
+func TestUnreachableCodeWarnings(t *testing.T) {
+	input := `
+script MyScript {
+	if (flag(FLAG_1)) {
+		goto(OtherScript)
+		foo
+	}
+	switch (var(VAR_1)) {
+	case 1:
+		return
+		bar
+		baz
+	case 2:
+		goto_if_set(FLAG_2, OtherScript)
+		qux
+	}
+	end
+	msgbox("Never")
+	if (flag(FLAG_3)) {
+		release
+	}
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{
+		"line 17:2: unreachable code after 'end' in script 'MyScript' (lines 17-18)",
+		"line 5:3: unreachable code after 'goto' in script 'MyScript' (line 5)",
+		"line 10:3: unreachable code after 'return' in script 'MyScript' (lines 10-11)",
+	}
+	warnings := p.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, warning := range warnings {
+		if warning != expected[i] {
+			t.Errorf("Expected warning '%s', but got '%s'", expected[i], warning)
+		}
+	}
+}
+
+func TestUnusedLocalWarnings(t *testing.T) {
+	input := `
+script(local) UnusedScript {
+	goto(UnusedScript)
+}
+script(local) UsedScript {
+	applymovement(OBJ_EVENT_ID_PLAYER, UsedMovement)
+	pokemart(UsedMart)
+	msgbox(format("Hi"))
+}
+script(local) MapScript {}
+script GlobalScript {
+	call(UsedScript)
+}
+text(local) UnusedText { "Unused" }
+text(local) RawText { "Raw" }
+@unused
+text(local) IgnoredText { "Ignored" }
+movement UsedMovement { walk_left }
+movement UnusedMovement { walk_right }
+mart UsedMart { ITEM_POTION }
+mart UnusedMart { ITEM_POTION }
+data UsedData { .byte: 1 }
+data UnusedData { .4byte: UsedData }
+mapscripts MyMapScripts {
+	MAP_SCRIPT_ON_LOAD: MapScript
+}
+raw ` + "`" + `
+	.4byte RawText
+` + "`" + `
+`
+	l := lexer.New(input)
+	p := New(l, "../font_widths.json", nil)
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{
+		"line 2:15: local script 'UnusedScript' is never referenced",
+		"line 14:13: local text 'UnusedText' is never referenced",
+		"line 19:10: local movement 'UnusedMovement' is never referenced",
+		"line 21:6: local mart 'UnusedMart' is never referenced",
+		"line 23:6: local data 'UnusedData' is never referenced",
+	}
+	warnings := p.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, warning := range warnings {
+		if warning != expected[i] {
+			t.Errorf("Expected warning '%s', but got '%s'", expected[i], warning)
+		}
+	}
+}
+
+func TestAutoEnd(t *testing.T) {
+	input := `
+script Locked {
+	lock
+	faceplayer
+	msgbox("Hi")
+}
+script Released {
+	lockall
+	msgbox("Hi")
+	releaseall
+}
+script MaybeLocked {
+	if (flag(FLAG_1)) {
+		lock
+	}
+}
+script Ended {
+	lock
+	if (flag(FLAG_1)) {
+		end
+	}
+	release
+	end
+}
+script Looped {
+	while (var(VAR_1) < 3) {
+		lock
+		if (flag(FLAG_2)) {
+			break
+		}
+		release
+	}
+}
+script Called {
+	msgbox("Hi")
+}
+script Caller {
+	call(Called)
+	goto(Released)
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	p.SetAutoEnd(&DefaultAutoEndConfig)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expectedEnds := map[string][]string{
+		"Locked":      {"release", "end"},
+		"Released":    {"releaseall", "end"},
+		"MaybeLocked": {"release", "end"},
+		"Ended":       {"release", "end"},
+		"Looped":      {"release", "end"},
+		"Called":      {"msgbox"},
+		"Caller":      {"call", "goto"},
+	}
+	for _, stmt := range program.TopLevelStatements {
+		script := stmt.(*ast.ScriptStatement)
+		expected := expectedEnds[script.Name.Value]
+		statements := script.Body.Statements
+		if len(statements) < len(expected) {
+			t.Fatalf("Script '%s' has %d statements, but expected at least %d", script.Name.Value, len(statements), len(expected))
+		}
+		for i, name := range expected {
+			command, ok := statements[len(statements)-len(expected)+i].(*ast.CommandStatement)
+			if !ok || command.Name.Value != name {
+				t.Errorf("Expected script '%s' to end with %v, but got %v", script.Name.Value, expected, statements[len(statements)-len(expected):])
+				break
+			}
+		}
+	}
+	expected := []string{
+		"line 18:2: 'lock' in script 'Ended' isn't released on every path before the script ends",
+	}
+	warnings := p.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, warning := range warnings {
+		if warning != expected[i] {
+			t.Errorf("Expected warning '%s', but got '%s'", expected[i], warning)
+		}
+	}
+}
+
+func TestCalledScriptEnds(t *testing.T) {
+	input := `
+script MyScript {
+	call(Helper)
+	call_if_set(FLAG_1, OtherHelper)
+	end
+}
+script Helper {
+	if (flag(FLAG_2)) {
+		end
+	}
+	return
+}
+script OtherHelper {
+	msgbox("Hi")
+	end
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{
+		"line 9:3: script 'Helper' is called by script 'MyScript', but it uses 'end' instead of 'return', so 'MyScript' never continues after the call",
+		"line 15:2: script 'OtherHelper' is called by script 'MyScript', but it uses 'end' instead of 'return', so 'MyScript' never continues after the call",
+	}
+	warnings := p.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, warning := range warnings {
+		if warning != expected[i] {
+			t.Errorf("Expected warning '%s', but got '%s'", expected[i], warning)
+		}
+	}
+
+	p = New(lexer.New(input), "", nil)
+	p.SetFixCallEnds(true)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(p.Warnings()) != 0 {
+		t.Fatalf("Expected no warnings when fixing call ends, but got %v", p.Warnings())
+	}
+	expectedEnds := []string{"end", "return", "return"}
+	for i, stmt := range program.TopLevelStatements {
+		statements := stmt.(*ast.ScriptStatement).Body.Statements
+		command := statements[len(statements)-1].(*ast.CommandStatement)
+		if command.Name.Value != expectedEnds[i] {
+			t.Errorf("Expected script %d to end with '%s', but got '%s'", i, expectedEnds[i], command.Name.Value)
+		}
+	}
+	helperIf := program.TopLevelStatements[1].(*ast.ScriptStatement).Body.Statements[0].(*ast.IfStatement)
+	if command := helperIf.Consequence.Body.Statements[0].(*ast.CommandStatement); command.Name.Value != "return" {
+		t.Errorf("Expected the nested 'end' to be fixed, but got '%s'", command.Name.Value)
+	}
+}
+
+func TestDiagnosticOptions(t *testing.T) {
+	input := `
+script MyScript {
+	if (flag(FLAG_1)) {}
+	end
+	release
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	p.SetDiagnosticOptions(DiagnosticOptions{DisabledWarnings: []string{WarningEmptyBody}})
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, but got %d: %v", len(diagnostics), diagnostics)
+	}
+	expected := Diagnostic{
+		Severity:   SeverityWarning,
+		Category:   WarningUnreachable,
+		LineNumber: 5,
+		Column:     2,
+		Message:    "unreachable code after 'end' in script 'MyScript' (line 5)",
+	}
+	if diagnostics[0] != expected {
+		t.Errorf("Expected diagnostic %v, but got %v", expected, diagnostics[0])
+	}
+
+	l = lexer.New(input)
+	p = New(l, "", nil)
+	p.SetDiagnosticOptions(DiagnosticOptions{WarningsAsErrors: true})
+	_, err = p.ParseProgram()
+	if err == nil || err.Error() != "2 warning(s) were treated as errors" {
+		t.Fatalf("Expected warnings to be treated as errors, but got '%v'", err)
+	}
+	for _, diagnostic := range p.Diagnostics() {
+		if diagnostic.Severity != SeverityError {
+			t.Errorf("Expected diagnostic '%s' to have error severity", diagnostic)
+		}
+	}
+
+	if err := ValidateWarningCategories([]string{"unused", "foo"}); err == nil || err.Error() != "unknown warning category 'foo'. Valid categories are: call-end, deprecated, empty-body, font-config, lint, script-size, unreachable, unreleased, unused" {
+		t.Errorf("Expected unknown warning category error, but got '%v'", err)
+	}
+}
+
+func TestFontConfigWarning(t *testing.T) {
+	input := `
+script MyScript {
+	msgbox(format("Hello"))
+	msgbox(format("Goodbye"))
+}`
+	l := lexer.New(input)
+	p := New(l, "missing_font_widths.json", nil)
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, but got %d: %v", len(diagnostics), diagnostics)
+	}
+	if diagnostics[0].Category != WarningFontConfig || diagnostics[0].LineNumber != 3 || diagnostics[0].Column != 16 {
+		t.Errorf("Incorrect font config warning: %+v", diagnostics[0])
+	}
+}
+
+func TestNestingLimit(t *testing.T) {
+	tests := []struct {
+		input         string
+		limit         int
+		expectedError string
+	}{
+		{"script MyScript {\n" + strings.Repeat("if (flag(FLAG_1)) {\n", 3) + strings.Repeat("}\n", 3) + "}", 4, ""},
+		{"script MyScript {\n" + strings.Repeat("if (flag(FLAG_1)) {\n", 4) + strings.Repeat("}\n", 4) + "}", 4, "line 6:1: maximum nesting depth of 4 exceeded"},
+		{"script MyScript {\n\tif (" + strings.Repeat("(", 3) + "flag(FLAG_1)" + strings.Repeat(")", 3) + ") {}\n}", 4, ""},
+		{"script MyScript {\n\tif (" + strings.Repeat("(", 4) + "flag(FLAG_1)" + strings.Repeat(")", 4) + ") {}\n}", 4, "line 2:9: maximum nesting depth of 4 exceeded"},
+		{"script MyScript {\n" + strings.Repeat("while (flag(FLAG_1)) {\n", 50000) + strings.Repeat("}\n", 50000) + "}", DefaultNestingLimit, "line 102:1: maximum nesting depth of 100 exceeded"},
+	}
+	for _, test := range tests {
+		p := New(lexer.New(test.input), "", nil)
+		p.SetNestingLimit(test.limit)
+		_, err := p.ParseProgram()
+		if test.expectedError == "" {
+			if err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		} else if err == nil || err.Error() != test.expectedError {
+			t.Errorf("Expected error '%s', but got '%v'", test.expectedError, err)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "# Header\nscript MyScript { // trailing\n\tlock # after lock\n}\n# Footer"
+	p := New(lexer.NewWithMode(input, lexer.ScanComments), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []string{"# Header", "// trailing", "# after lock", "# Footer"}
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("Incorrect number of comments. Expected %d, got %d", len(expected), len(program.Comments))
+	}
+	for i, comment := range program.Comments {
+		if comment.TokenLiteral() != expected[i] {
+			t.Errorf("Incorrect comment %d. Expected '%s', got '%s'", i, expected[i], comment.TokenLiteral())
+		}
+	}
+	script := program.TopLevelStatements[0].(*ast.ScriptStatement)
+	if len(script.Body.Statements) != 1 {
+		t.Errorf("Expected comments to be skipped by the parser, but got %d statements", len(script.Body.Statements))
+	}
+}
+
+func TestSyntaxOnly(t *testing.T) {
+	input := `
+import "missing.pory"
+script MyScript {
+	call OtherScript(1)
+	poryswitch(GAME) {
+		RUBY: lock
+		SAPPHIRE: faceplayer
+	}
+	msgbox(format("Hello", "UNKNOWN_FONT"))
+}`
+	p := New(lexer.New(input), "", nil)
+	if _, err := p.ParseProgram(); err == nil {
+		t.Fatalf("Expected error without the syntax-only mode")
+	}
+	p = New(lexer.New(input), "", nil)
+	p.SetSyntaxOnly(true)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	script := program.TopLevelStatements[0].(*ast.ScriptStatement)
+	command := script.Body.Statements[len(script.Body.Statements)-2].(*ast.CommandStatement)
+	if command.Name.Value != "lock" {
+		t.Errorf("Expected the first poryswitch case to be used, but got '%s'", command.Name.Value)
+	}
+
+	p = New(lexer.New("script MyScript {\n\tif (flag(FLAG_1) {}\n}"), "", nil)
+	p.SetSyntaxOnly(true)
+	if _, err := p.ParseProgram(); !errors.Is(err, ErrSyntax) {
+		t.Errorf("Expected syntax error, but got '%v'", err)
+	}
+}
+
+func TestDiagnosticExcerpt(t *testing.T) {
+	input := "script MyScript {\n\tmsgbox(\"héllo\", MSGBOX_DEFAULT)\n\tif (var(VAR_1) == ) {\n\t}\n}\n"
+	tests := []struct {
+		diagnostic Diagnostic
+		expected   string
+	}{
+		{Diagnostic{LineNumber: 2, Column: 2}, "\tmsgbox(\"héllo\", MSGBOX_DEFAULT)\n\t^^^^^^"},
+		{Diagnostic{LineNumber: 2, Column: 9}, "\tmsgbox(\"héllo\", MSGBOX_DEFAULT)\n\t       ^^^^^^^"},
+		{Diagnostic{LineNumber: 3, Column: 20}, "\tif (var(VAR_1) == ) {\n\t                  ^"},
+		{Diagnostic{LineNumber: 3, Column: 17}, "\tif (var(VAR_1) == ) {\n\t               ^^"},
+		{Diagnostic{LineNumber: 3}, ""},
+		{Diagnostic{LineNumber: 9, Column: 1}, ""},
+	}
+	for _, test := range tests {
+		result := test.diagnostic.Excerpt(input)
+		if result != test.expected {
+			t.Errorf("Incorrect excerpt for %v. Expected:\n%s\nGot:\n%s", test.diagnostic, test.expected, result)
+		}
+	}
+}
+
+func TestDiagnosticExcerptClipping(t *testing.T) {
+	input := "script MyScript {\n\tsetvar(VAR_1, " + strings.Repeat("1 + ", 25) + "foo)\n}\n"
+	tests := []struct {
+		diagnostic Diagnostic
+		color      bool
+		expected   string
+	}{
+		{Diagnostic{LineNumber: 2, Column: 2}, false, "\tsetvar(VAR_1, " + strings.Repeat("1 + ", 8) + "...\n\t^^^^^^"},
+		{Diagnostic{LineNumber: 2, Column: 64}, false, "..." + strings.Repeat("1 + ", 10) + "1" + strings.Repeat(" + 1", 10) + "...\n" + strings.Repeat(" ", 43) + "^"},
+		{Diagnostic{LineNumber: 2, Column: 116}, false, "..." + strings.Repeat("1 + ", 10) + "foo)\n" + strings.Repeat(" ", 43) + "^^^"},
+		{Diagnostic{Severity: SeverityError, LineNumber: 2, Column: 116}, true, "..." + strings.Repeat("1 + ", 10) + "\x1b[1mfoo\x1b[0m)\n" + strings.Repeat(" ", 43) + "\x1b[1;31m^^^\x1b[0m"},
+	}
+	for _, test := range tests {
+		result := test.diagnostic.Excerpt(input)
+		if test.color {
+			result = test.diagnostic.ColorExcerpt(input)
+		}
+		if result != test.expected {
+			t.Errorf("Incorrect excerpt for %v. Expected:\n%q\nGot:\n%q", test.diagnostic, test.expected, result)
+		}
+	}
+}
+
+func TestDiagnosticColorExcerpt(t *testing.T) {
+	input := "script MyScript {\n\tmsgbox(\"héllo\", MSGBOX_DEFAULT)\n\tif (var(VAR_1) == ) {\n\t}\n}\n"
+	tests := []struct {
+		diagnostic Diagnostic
+		expected   string
+	}{
+		{Diagnostic{Severity: SeverityError, LineNumber: 2, Column: 9}, "\tmsgbox(\x1b[1m\"héllo\"\x1b[0m, MSGBOX_DEFAULT)\n\t       \x1b[1;31m^^^^^^^\x1b[0m"},
+		{Diagnostic{Severity: SeverityWarning, LineNumber: 3, Column: 17}, "\tif (var(VAR_1) \x1b[1m==\x1b[0m ) {\n\t               \x1b[1;33m^^\x1b[0m"},
+		{Diagnostic{Severity: SeverityError, LineNumber: 4, Column: 3}, "\t}\n\t \x1b[1;31m^\x1b[0m"},
+		{Diagnostic{LineNumber: 3}, ""},
+	}
+	for _, test := range tests {
+		result := test.diagnostic.ColorExcerpt(input)
+		if result != test.expected {
+			t.Errorf("Incorrect excerpt for %v. Expected:\n%q\nGot:\n%q", test.diagnostic, test.expected, result)
+		}
+	}
+}
+
+func TestDiagnosticToJSON(t *testing.T) {
+	input := "script MyScript {\n\tmsgbox(\"Hello\", MSGBOX_DEFAULT)\n}\n"
+	tests := []struct {
+		diagnostic Diagnostic
+		expected   JSONDiagnostic
+	}{
+		{
+			Diagnostic{Severity: SeverityError, Category: ErrorSyntax, Filepath: "a.pory", LineNumber: 2, Column: 2, Message: "bad"},
+			JSONDiagnostic{File: "a.pory", Range: &DiagnosticRange{Start: DiagnosticPosition{2, 2}, End: DiagnosticPosition{2, 8}}, Severity: "error", Code: ErrorSyntax, Message: "bad"},
+		},
+		{
+			Diagnostic{Severity: SeverityWarning, Category: WarningLint, Rule: "textNaming", LineNumber: 2},
+			JSONDiagnostic{Range: &DiagnosticRange{Start: DiagnosticPosition{2, 1}, End: DiagnosticPosition{2, 33}}, Severity: "warning", Code: WarningLint, Rule: "textNaming"},
+		},
+		{
+			Diagnostic{Severity: SeverityWarning, Category: WarningUnused, LineNumber: 9, Column: 3},
+			JSONDiagnostic{Range: &DiagnosticRange{Start: DiagnosticPosition{9, 3}, End: DiagnosticPosition{9, 3}}, Severity: "warning", Code: WarningUnused},
+		},
+		{
+			Diagnostic{Severity: SeverityError, Category: ErrorWarnings, Message: "1 warning(s) were treated as errors"},
+			JSONDiagnostic{Severity: "error", Code: ErrorWarnings, Message: "1 warning(s) were treated as errors"},
+		},
+	}
+	for _, test := range tests {
+		result := test.diagnostic.ToJSON(input)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Incorrect JSON diagnostic for %v. Expected %+v %+v, but got %+v %+v", test.diagnostic, test.expected, test.expected.Range, result, result.Range)
+		}
+	}
+}
+
+func TestReaderInput(t *testing.T) {
+	input := `
+script MyScript {
+	msgbox("Hello")
+}`
+	p := New(lexer.NewReader(iotest.OneByteReader(strings.NewReader(input))), "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(program.TopLevelStatements) != 1 || len(program.Texts) != 1 {
+		t.Errorf("Expected 1 statement and 1 text, but got %d and %d", len(program.TopLevelStatements), len(program.Texts))
+	}
+
+	p = New(lexer.NewReader(iotest.TimeoutReader(strings.NewReader(input))), "", nil)
+	_, err = p.ParseProgram()
+	if !errors.Is(err, iotest.ErrTimeout) {
+		t.Errorf("Expected read error, but got '%v'", err)
+	}
+}
+
+func TestUnterminatedStrings(t *testing.T) {
+	input := `
+text MyText {
+	"Hello
+}
+script MyScript {
+	msgbox(MyText
+}`
+	p := New(lexer.New(input), "", nil)
+	_, err := p.ParseProgram()
+	expectedError := "line 6:2: missing closing parenthesis for command 'msgbox'"
+	if err == nil || err.Error() != expectedError {
+		t.Fatalf("Expected error '%s', but got '%v'", expectedError, err)
+	}
+	expected := "line 3:2: unterminated string"
+	if diagnostics := p.Warnings(); len(diagnostics) != 1 || diagnostics[0] != expected {
+		t.Errorf("Expected diagnostic '%s', but got %v", expected, diagnostics)
+	}
+
+	p = New(lexer.New(input[:strings.Index(input, "script")]), "", nil)
+	_, err = p.ParseProgram()
+	if !errors.Is(err, ErrLex) || !IsDiagnosticsError(err) {
+		t.Errorf("Expected lexical error that summarizes the diagnostics, but got '%v'", err)
+	}
+	if diagnostics := p.Diagnostics(); len(diagnostics) != 1 || diagnostics[0].Severity != SeverityError {
+		t.Errorf("Expected 1 error diagnostic, but got %v", diagnostics)
+	}
+}
+
+func TestInvalidNumbers(t *testing.T) {
+	input := `
+script MyScript {
+	setvar(VAR_1, 0xZZ)
+	setvar(VAR_2, 0b101)
+}`
+	p := New(lexer.New(input), "", nil)
+	_, err := p.ParseProgram()
+	if !errors.Is(err, ErrLex) {
+		t.Errorf("Expected lexical error, but got '%v'", err)
+	}
+	expected := "line 3:16: invalid hexadecimal number '0xZZ'"
+	if diagnostics := p.Warnings(); len(diagnostics) != 1 || diagnostics[0] != expected {
+		t.Errorf("Expected diagnostic '%s', but got %v", expected, diagnostics)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	input := `
+script MyScript {
+	call MyHelper(1)
+}
+script MyHelper(a, b) {
+	end
+}`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	_, err := p.ParseProgram()
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, but got '%v'", err)
+	}
+	expected := ParseError{
+		LineNumber: 3,
+		Column:     7,
+		Code:       ErrorArguments,
+		Message:    "script 'MyHelper' expects 2 parameters, but got 1",
+	}
+	if *parseErr != expected {
+		t.Errorf("Expected error %+v, but got %+v", expected, *parseErr)
+	}
+
+	err = setErrorFilepath(err, "data/scripts/myscript.pory")
+	expectedString := "data/scripts/myscript.pory: line 3:7: script 'MyHelper' expects 2 parameters, but got 1"
+	if err.Error() != expectedString {
+		t.Errorf("Expected error '%s', but got '%s'", expectedString, err.Error())
+	}
+	diagnostic := NewErrorDiagnostic(err)
+	if diagnostic.Category != ErrorArguments || diagnostic.Filepath != "data/scripts/myscript.pory" || diagnostic.Column != 7 {
+		t.Errorf("Incorrect error diagnostic: %+v", diagnostic)
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	tests := []struct {
+		input    string
+		category error
+	}{
+		{"script MyScript {\n\tif (flag(FLAG_1) & flag(FLAG_2)) {}\n}", ErrLex},
+		{"script MyScript {\n\tmovement\n}", ErrSyntax},
+		{"const FOO = 1\nconst FOO = 2", ErrSemantic},
+		{"script MyScript {\n\tporyswitch(GAME) {}\n}", ErrSemantic},
+	}
+	categories := []error{ErrLex, ErrSyntax, ErrSemantic}
+	for _, test := range tests {
+		p := New(lexer.New(test.input), "", nil)
+		_, err := p.ParseProgram()
+		for _, category := range categories {
+			if errors.Is(err, category) != (category == test.category) {
+				t.Errorf("Incorrect category for error '%v'. Expected '%v'", err, test.category)
+			}
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	input := `
+script MyScript {
+	if (flag(FLAG_1)) {
+		while (flag(FLAG_2)) {
+			switch (var(VAR_1)) {
+			case 1:
+				foo
+			}
+		}
+	}
+	switch (var(VAR_2)) {
+	case 1:
+		bar
+	default:
+		baz
+	}
+}
+script bad_name {
+	end
+}
+text BadText { "Hello" }
+text MyScript_Text { "Hello" }
+`
+	config := &LintConfig{
+		ScriptNaming:         "^[A-Z][A-Za-z0-9]*$",
+		TextNaming:           "^[A-Za-z0-9]+_Text",
+		MaxNestingDepth:      2,
+		MaxScriptLength:      6,
+		RequireSwitchDefault: true,
+	}
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	p.SetLintConfig(config)
+	_, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{
+		"line 18:8: [script-naming] script name 'bad_name' doesn't match the pattern '^[A-Z][A-Za-z0-9]*$'",
+		"line 21:6: [text-naming] text name 'BadText' doesn't match the pattern '^[A-Za-z0-9]+_Text'",
+		"line 2:8: [max-script-length] script 'MyScript' has 7 statements, which exceeds the maximum of 6",
+		"line 5:4: [max-nesting-depth] script 'MyScript' exceeds the maximum nesting depth of 2",
+		"line 5:4: [switch-default] switch statement in script 'MyScript' has no default case",
+	}
+	warnings := p.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, warning := range warnings {
+		if warning != expected[i] {
+			t.Errorf("Expected warning '%s', but got '%s'", expected[i], warning)
+		}
+	}
+
+	p = New(lexer.New(input), "", nil)
+	p.SetLintConfig(&LintConfig{ScriptNaming: "("})
+	if _, err = p.ParseProgram(); err == nil || !strings.HasPrefix(err.Error(), "invalid scriptNaming pattern: ") {
+		t.Errorf("Expected invalid pattern error, but got '%v'", err)
+	}
+}
+
+func TestCommandSignatures(t *testing.T) {
+	input := `
+@ Ends the script.
+	.macro end
+	.byte 0x02
+	.endm
+
+	.macro setflag flag:req @ Sets a flag.
+	.byte 0x29
+	.2byte \flag
+	.endm
+
+	.macro msgbox text:req, type = MSGBOX_DEFAULT
+	loadword 0, \text
+	callstd \type
+	.endm
+
+	.macro setvar destination:req value:req
+	.endm
+
+/*	.macro commented out:req
+	.endm */
+	.macro message, text
+	.endm
+
+	.macro goto_if_ge dest:req, extra, args:vararg
+	.macro \dest\()_inner
+	.endm
+	.endm
+
+	.macro end
+	.endm
+`
+	signatures := ParseCommandSignatures(input, "event.inc")
+	expected := map[string]string{
+		"end":        "end",
+		"setflag":    "setflag flag",
+		"msgbox":     "msgbox text, [type=MSGBOX_DEFAULT]",
+		"setvar":     "setvar destination, value",
+		"message":    "message [text]",
+		"goto_if_ge": "goto_if_ge dest, [extra], args...",
+	}
+	if len(signatures) != len(expected) {
+		t.Fatalf("Expected %d signatures, but got %d: %v", len(expected), len(signatures), signatures)
+	}
+	for name, usage := range expected {
+		signature, ok := signatures[name]
+		if !ok {
+			t.Errorf("Expected signature for '%s'", name)
+			continue
+		}
+		if signature.String() != usage {
+			t.Errorf("Expected usage '%s', but got '%s'", usage, signature.String())
+		}
+	}
+
+	tests := []struct {
+		name       string
+		minArgs    int
+		maxArgs    int
+		lineNumber int
+	}{
+		{"end", 0, 0, 3},
+		{"setflag", 1, 1, 7},
+		{"msgbox", 1, 2, 12},
+		{"message", 0, 1, 22},
+		{"goto_if_ge", 1, -1, 25},
+	}
+	for _, tt := range tests {
+		signature := signatures[tt.name]
+		if signature.MinArgs() != tt.minArgs || signature.MaxArgs() != tt.maxArgs {
+			t.Errorf("Expected '%s' to take %d to %d args, but got %d to %d", tt.name, tt.minArgs, tt.maxArgs, signature.MinArgs(), signature.MaxArgs())
+		}
+		if signature.Filepath != "event.inc" || signature.LineNumber != tt.lineNumber {
+			t.Errorf("Expected '%s' to be defined at event.inc:%d, but got %s:%d", tt.name, tt.lineNumber, signature.Filepath, signature.LineNumber)
+		}
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	macros := `
+	.macro loadword destination:req, value:req
+	.byte 0x0f
+	.byte \destination
+	.4byte \value
+	.endm
+
+	.macro callstd function:req
+	.byte 0x09
+	.byte \function
+	.endm
+
+	.macro msgbox text:req, type=MSGBOX_DEFAULT
+	loadword 0, \text
+	callstd \type
+	.endm
+
+	.macro setflag flag:req
+	.2byte \flag
+	.endm
+
+	.macro lock
+	.endm
+
+	.macro special function:req, args:vararg
+	.2byte \function
+	.endm
+`
+	signatures := ParseCommandSignatures(macros, "event.inc")
+	expectedKinds := map[string][]ParamKind{
+		"loadword": {ParamNumber, ParamLabel},
+		"msgbox":   {ParamLabel, ParamNumber},
+		"special":  {ParamNumber, ParamAny},
+	}
+	for name, kinds := range expectedKinds {
+		for i, kind := range kinds {
+			if signatures[name].Params[i].Kind != kind {
+				t.Errorf("Expected parameter %d of '%s' to be a %s, but got %s", i, name, kind, signatures[name].Params[i].Kind)
+			}
+		}
+	}
+
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`
+script MyScript {
+	lock
+	msgbox("Hello", MSGBOX_NPC)
+	msgbox(MyScript_Text)
+	setflag(FLAG_1)
+	special(ShowMoney, 1, 2)
+	unknowncommand(1, 2, 3)
+}
+text MyScript_Text { "Hi" }`, ""},
+		{`
+script MyScript {
+	msgbox()
+}`, "line 3:9: missing argument 'text' for command 'msgbox'. Usage: msgbox text, [type=MSGBOX_DEFAULT]"},
+		{`
+script MyScript {
+	lock
+	msgbox
+}`, "line 4:2: missing argument 'text' for command 'msgbox'. Usage: msgbox text, [type=MSGBOX_DEFAULT]"},
+		{`
+script MyScript {
+	msgbox("Hello", MSGBOX_NPC, 3)
+}`, "line 3:30: too many arguments for command 'msgbox'. Expected at most 2, but got 3. Usage: msgbox text, [type=MSGBOX_DEFAULT]"},
+		{`
+script MyScript {
+	lock(1)
+}`, "line 3:7: too many arguments for command 'lock'. Expected at most 0, but got 1. Usage: lock"},
+		{`
+script MyScript {
+	setflag("Hello")
+}`, "line 3:10: argument 'flag' of command 'setflag' must be a number, but got text"},
+		{`
+script MyScript {
+	msgbox("Hello", Other)
+}
+script Other {
+	special(Other)
+}`, "line 3:18: argument 'type' of command 'msgbox' must be a number, but got label 'Other'"},
+	}
+	for i, tt := range tests {
+		p := New(lexer.New(tt.input), "", nil)
+		p.SetCommandSignatures(signatures)
+		_, err := p.ParseProgram()
+		if tt.expectedError == "" {
+			if err != nil {
+				t.Errorf("Test %d: unexpected error: %s", i, err.Error())
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expectedError {
+			t.Errorf("Test %d: expected error '%s', but got '%v'", i, tt.expectedError, err)
+		} else if !errors.Is(err, ErrSemantic) {
+			t.Errorf("Test %d: expected a semantic error, but got '%v'", i, err)
+		}
+	}
+}
+
+func TestParamCalls(t *testing.T) {
+	input := `
+script Caller {
+	call Helper(ITEM_POTION, (2 + 3))
+	goto NoParams()
+}
+
+script Helper(item, count, extra = VAR_TEMP_1) {
+	giveitem(item, count)
+	if (var(count) == 2) {}
+}
+
+script NoParams {}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	p.SetParamVars([]string{"VAR_A", "VAR_B"})
+	_, err := p.ParseProgram()
+	if err == nil || err.Error() != "line 3:7: script 'Helper' expects 3 parameters, but got 2" {
+		t.Fatalf("Expected parameter count error, but got '%v'", err)
+	}
+
+	input = strings.Replace(input, "(2 + 3))", "(2 + 3), 1)", 1)
+	l = lexer.New(input)
+	p = New(l, "", nil)
+	p.SetParamVars([]string{"VAR_A", "VAR_B"})
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	caller := program.TopLevelStatements[0].(*ast.ScriptStatement)
+	testScriptStatement(t, caller, "Caller", []commandArgs{
+		{"setvar", []string{"VAR_A", "ITEM_POTION"}},
+		{"setvar", []string{"VAR_B", "( 2 + 3 )"}},
+		{"setvar", []string{"VAR_TEMP_1", "1"}},
+		{"call", []string{"Helper"}},
+		{"goto", []string{"NoParams"}},
+	})
+
+	helper := program.TopLevelStatements[1].(*ast.ScriptStatement)
+	if len(helper.Params) != 3 || helper.Params[0].Var != "VAR_A" || helper.Params[1].Var != "VAR_B" || helper.Params[2].Var != "VAR_TEMP_1" {
+		t.Fatalf("Unexpected script params: %v", helper.Params)
+	}
+	giveitem := helper.Body.Statements[0].(*ast.CommandStatement)
+	testConstant(t, "VAR_A", giveitem.Args[0])
+	testConstant(t, "VAR_B", giveitem.Args[1])
+	ifStmt := helper.Body.Statements[1].(*ast.IfStatement)
+	testConstant(t, "VAR_B", ifStmt.Consequence.Expression.(*ast.OperatorExpression).Operand)
+}
+
+func TestMacros(t *testing.T) {
+	input := `
+macro giveItemMsg(item, count) {
+	giveitem(item, count)
+	if (var(VAR_RESULT) == FALSE) {
+		msgbox("Your bag is full.")
+	}
+}
+
+macro lockAndFace {
+	lock
+	faceplayer
+}
+
+script MyScript {
+	lockAndFace
+	giveItemMsg(ITEM_POTION, 2 + 1)
+	giveItemMsg(ITEM_ANTIDOTE, 1)
+	release
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(program.TopLevelStatements) != 1 {
+		t.Fatalf("Expected 1 top-level statement, but got %d", len(program.TopLevelStatements))
+	}
+	script := program.TopLevelStatements[0].(*ast.ScriptStatement)
+	if len(script.Body.Statements) != 7 {
+		t.Fatalf("Expected 7 statements, but got %d", len(script.Body.Statements))
+	}
+	expectedCommands := map[int]commandArgs{
+		0: {"lock", []string{}},
+		1: {"faceplayer", []string{}},
+		2: {"giveitem", []string{"ITEM_POTION", "2 + 1"}},
+		4: {"giveitem", []string{"ITEM_ANTIDOTE", "1"}},
+		6: {"release", []string{}},
+	}
+	for i, expected := range expectedCommands {
+		command := script.Body.Statements[i].(*ast.CommandStatement)
+		if command.Name.Value != expected.name {
+			t.Errorf("Expected command '%s', but got '%s'", expected.name, command.Name.Value)
+		}
+		if strings.Join(command.Args, ",") != strings.Join(expected.args, ",") {
+			t.Errorf("Expected args '%v', but got '%v'", expected.args, command.Args)
+		}
+	}
+	if _, ok := script.Body.Statements[3].(*ast.IfStatement); !ok {
+		t.Errorf("Expected if statement, but got %T", script.Body.Statements[3])
+	}
+	if len(program.Texts) != 1 {
+		t.Errorf("Expected 1 text, but got %d", len(program.Texts))
+	}
+}
+
+func TestImports(t *testing.T) {
+	files := map[string]string{
+		"data/common/constants.pory": `
+import "texts.pory"
+const ITEM_COUNT = 3
+macro lockAndFace {
+	lock
+	faceplayer
+}
+script GiveItem(item) {
+	giveitem(item, ITEM_COUNT)
+}`,
+		"data/common/texts.pory": `
+texttemplate Obtained(item) = "Got {item}!"
+text SharedText { "Shared" }`,
+		"data/scripts/cycle_a.pory": `import "cycle_b.pory"`,
+		"data/common/broken.pory": `
+script Broken {
+	msgbox("Hi"
+}`,
+		"data/scripts/cycle_b.pory": `import "cycle_a.pory"`,
+	}
+	loader := func(path string) (string, error) {
+		input, ok := files[filepath.ToSlash(path)]
+		if !ok {
+			return "", fmt.Errorf("file not found")
+		}
+		return input, nil
+	}
+
+	input := `
+import "../common/constants.pory"
+import "../common/texts.pory"
+
+script MyScript {
+	lockAndFace
+	call GiveItem(ITEM_POTION)
+	msgbox(Obtained("POTION"))
+	setvar(VAR_TEMP_0, ITEM_COUNT)
+}
+`
+	l := lexer.New(input)
+	p := New(l, "", nil)
+	p.SetFilepath("data/scripts/myscript.pory")
+	p.SetFileLoader(loader)
+	program, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(program.TopLevelStatements) != 1 {
+		t.Fatalf("Expected imported statements to be excluded, but got %d statements", len(program.TopLevelStatements))
+	}
+	if len(program.Texts) != 1 {
+		t.Fatalf("Expected 1 text, but got %d", len(program.Texts))
+	}
+	testScriptStatement(t, program.TopLevelStatements[0], "MyScript", []commandArgs{
+		{"lock", []string{}},
+		{"faceplayer", []string{}},
+		{"setvar", []string{"VAR_0x8000", "ITEM_POTION"}},
+		{"call", []string{"GiveItem"}},
+		{"msgbox", []string{"MyScript_Text_0"}},
+		{"setvar", []string{"VAR_TEMP_0", "3"}},
+	})
+	imports := p.Imports()
+	if len(imports) != 2 || filepath.ToSlash(imports[0]) != "data/common/constants.pory" || filepath.ToSlash(imports[1]) != "data/common/texts.pory" {
+		t.Errorf("Unexpected imports: %v", imports)
+	}
+
+	l = lexer.New(`import "cycle_a.pory"`)
+	p = New(l, "", nil)
+	p.SetFilepath("data/scripts/cycle_b.pory")
+	p.SetFileLoader(loader)
+	_, err = p.ParseProgram()
+	expectedError := filepath.FromSlash("data/scripts/cycle_a.pory: line 1:8: import cycle detected: data/scripts/cycle_b.pory -> data/scripts/cycle_a.pory -> data/scripts/cycle_b.pory")
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', but got '%v'", expectedError, err)
+	}
+
+	l = lexer.New(`
+import "../common/texts.pory"
+text SharedText { "Duplicate" }`)
+	p = New(l, "", nil)
+	p.SetFilepath("data/scripts/duplicate.pory")
+	p.SetFileLoader(loader)
+	_, err = p.ParseProgram()
+	expectedError = filepath.FromSlash("data/scripts/duplicate.pory: line 3:6: duplicate label 'SharedText', which is already defined at data/common/texts.pory: line 3:6")
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', but got '%v'", expectedError, err)
+	}
+
+	l = lexer.New(`import "../common/broken.pory"`)
+	p = New(l, "", nil)
+	p.SetFilepath("data/scripts/broken.pory")
+	p.SetFileLoader(loader)
+	_, err = p.ParseProgram()
+	expectedError = filepath.FromSlash("data/common/broken.pory: line 3:2: missing closing parenthesis for command 'msgbox'")
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', but got '%v'", expectedError, err)
+	}
+
+	l = lexer.New(`import "missing.pory"`)
+	p = New(l, "", nil)
+	p.SetFileLoader(loader)
+	_, err = p.ParseProgram()
+	if err == nil || err.Error() != "line 1:8: failed to import 'missing.pory': file not found" {
+		t.Errorf("Expected missing import error, but got '%v'", err)
+	}
+}
+
+func TestProject(t *testing.T) {
+	files := map[string]string{
+		"maps/Route101/scripts.pory": `
+mapscripts Route101_MapScripts {
+	MAP_SCRIPT_ON_TRANSITION: Shared_OnTransition
+}
+script Route101_Sign {
+	call Shared_GiveItem(ITEM_POTION, 2)
+	msgbox(Shared_Text)
+}`,
+		"scripts/shared.pory": `
+script Shared_GiveItem(item, amount) {
+	giveitem(item, amount)
+}
+script Shared_OnTransition {
+	setflag(FLAG_VISITED)
+}
+text Shared_Text { "Hello" }`,
+		"scripts/local.pory": `
+script(local) Local_Script {
+	end
+}
+text(local) Local_Text { "Local" }`,
+		"scripts/uses_local_text.pory": `
+script UsesLocal {
+	msgbox(Local_Text)
+}`,
+		"scripts/uses_local_mapscript.pory": `
+mapscripts UsesLocal_MapScripts {
+	MAP_SCRIPT_ON_LOAD: Local_Script
+}`,
+		"scripts/duplicate.pory": `
+script Local_Script {
+	end
+}`,
+		"scripts/local_duplicate.pory": `
+script(local) Local_Script {
+	msgbox(Local_Text)
+}
+text(local) Local_Text { "Other" }`,
+		"scripts/bad_call.pory": `
+script BadCall {
+	call Shared_GiveItem(ITEM_POTION)
+}`,
+		"scripts/importer.pory": `
+import "constants.pory"
+script Importer {
+	setflag(MY_FLAG)
+}`,
+		"scripts/constants.pory": `
+const MY_FLAG = FLAG_1`,
 	}
-	expectedScopes := []token.Type{
-		token.GLOBAL,
-		token.LOCAL,
-		token.GLOBAL,
-		token.GLOBAL,
-		token.LOCAL,
-		token.GLOBAL,
-		token.LOCAL,
-		token.LOCAL,
-		token.GLOBAL,
-		token.GLOBAL,
-		token.LOCAL,
-		token.GLOBAL,
+	loader := func(path string) (string, error) {
+		input, ok := files[filepath.ToSlash(path)]
+		if !ok {
+			return "", fmt.Errorf("file not found")
+		}
+		return input, nil
 	}
-	for i, expectedScope := range expectedScopes {
-		testScope(t, i, program.TopLevelStatements[i], expectedScope)
+
+	project := NewProject([]string{"maps/Route101/scripts.pory", "scripts/shared.pory"}, "", nil)
+	project.SetFileLoader(loader)
+	projectFiles, err := project.ParseProject()
+	if err != nil {
+		t.Fatalf(err.Error())
 	}
-}
+	if len(projectFiles) != 2 || projectFiles[0].Filepath != "maps/Route101/scripts.pory" || projectFiles[1].Filepath != "scripts/shared.pory" {
+		t.Fatalf("Unexpected project files: %v", projectFiles)
+	}
+	testScriptStatement(t, projectFiles[0].Program.TopLevelStatements[1], "Route101_Sign", []commandArgs{
+		{"setvar", []string{"VAR_0x8000", "ITEM_POTION"}},
+		{"setvar", []string{"VAR_0x8001", "2"}},
+		{"call", []string{"Shared_GiveItem"}},
+		{"msgbox", []string{"Shared_Text"}},
+	})
 
-func testScope(t *testing.T, i int, statement ast.Statement, expectedScope token.Type) {
-	if script, ok := statement.(*ast.ScriptStatement); ok {
-		if script.Scope != expectedScope {
-			t.Errorf("%d: Expected script scope %s, but got %s", i, expectedScope, script.Scope)
-		}
-	} else if text, ok := statement.(*ast.TextStatement); ok {
-		if text.Scope != expectedScope {
-			t.Errorf("%d: Expected text scope %s, but got %s", i, expectedScope, text.Scope)
-		}
-	} else if movement, ok := statement.(*ast.MovementStatement); ok {
-		if movement.Scope != expectedScope {
-			t.Errorf("%d: Expected movement scope %s, but got %s", i, expectedScope, movement.Scope)
+	project = NewProject([]string{"scripts/local.pory", "scripts/local_duplicate.pory"}, "", nil)
+	project.SetFileLoader(loader)
+	if _, err = project.ParseProject(); err != nil {
+		t.Errorf("Expected local labels in different files to not clash, but got '%s'", err.Error())
+	}
+
+	project = NewProject([]string{"scripts/importer.pory", "scripts/shared.pory"}, "", nil)
+	project.SetFileLoader(loader)
+	if projectFiles, err = project.ParseProject(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(projectFiles[0].Imports) != 1 || filepath.ToSlash(projectFiles[0].Imports[0]) != "scripts/constants.pory" || len(projectFiles[1].Imports) != 0 {
+		t.Errorf("Unexpected imports of the project files: %v, %v", projectFiles[0].Imports, projectFiles[1].Imports)
+	}
+
+	tests := []struct {
+		filepaths     []string
+		expectedError string
+	}{
+		{
+			filepaths:     []string{"scripts/local.pory", "scripts/uses_local_text.pory"},
+			expectedError: "scripts/uses_local_text.pory: line 3:2: 'msgbox' in script 'UsesLocal' references 'Local_Text', which is local to 'scripts/local.pory'. Use the 'global' scope modifier to make it visible to other files",
+		},
+		{
+			filepaths:     []string{"scripts/uses_local_mapscript.pory", "scripts/local.pory"},
+			expectedError: "scripts/uses_local_mapscript.pory: line 3:2: mapscripts 'UsesLocal_MapScripts' references 'Local_Script', which is local to 'scripts/local.pory'. Use the 'global' scope modifier to make it visible to other files",
+		},
+		{
+			filepaths:     []string{"scripts/local.pory", "scripts/duplicate.pory"},
+			expectedError: "scripts/duplicate.pory: line 2:8: duplicate label 'Local_Script', which is already defined at scripts/local.pory: line 2:15",
+		},
+		{
+			filepaths:     []string{"scripts/bad_call.pory", "scripts/shared.pory"},
+			expectedError: "scripts/bad_call.pory: line 3:7: script 'Shared_GiveItem' expects 2 parameters, but got 1",
+		},
+		{
+			filepaths:     []string{"scripts/bad_call.pory"},
+			expectedError: "scripts/bad_call.pory: line 3:7: unknown script 'Shared_GiveItem' in parameterized call",
+		},
+	}
+	for _, test := range tests {
+		project := NewProject(test.filepaths, "", nil)
+		project.SetFileLoader(loader)
+		_, err := project.ParseProject()
+		if err == nil || filepath.ToSlash(err.Error()) != test.expectedError {
+			t.Errorf("Expected error '%s', but got '%v'", test.expectedError, err)
 		}
-	} else if mapscripts, ok := statement.(*ast.MapScriptsStatement); ok {
-		if mapscripts.Scope != expectedScope {
-			t.Errorf("%d: Expected mapscripts scope %s, but got %s", i, expectedScope, mapscripts.Scope)
+	}
+}
+
+func TestProjectOrder(t *testing.T) {
+	// The files are parsed concurrently, but their diagnostics and errors
+	// are reported in the order of the files.
+	files := map[string]string{}
+	var filepaths []string
+	for i := 0; i < 32; i++ {
+		path := fmt.Sprintf("scripts/file%d.pory", i)
+		files[path] = fmt.Sprintf("text(local) Unused%d { \"Hi\" }", i)
+		filepaths = append(filepaths, path)
+	}
+	loader := func(path string) (string, error) {
+		return files[filepath.ToSlash(path)], nil
+	}
+	project := NewProject(filepaths, "", nil)
+	project.SetFileLoader(loader)
+	if _, err := project.ParseProject(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	diagnostics := project.Diagnostics()
+	if len(diagnostics) != len(filepaths) {
+		t.Fatalf("Expected %d diagnostics, but got %d", len(filepaths), len(diagnostics))
+	}
+	for i, diagnostic := range diagnostics {
+		if diagnostic.Filepath != filepaths[i] {
+			t.Errorf("Expected diagnostic %d to be in '%s', but got '%s'", i, filepaths[i], diagnostic.Filepath)
 		}
 	}
+
+	files["scripts/file20.pory"] = "script Broken {"
+	files["scripts/file10.pory"] = "script Broken {"
+	project = NewProject(filepaths, "", nil)
+	project.SetFileLoader(loader)
+	_, err := project.ParseProject()
+	if err == nil || !strings.HasPrefix(filepath.ToSlash(err.Error()), "scripts/file10.pory: ") {
+		t.Errorf("Expected the error of scripts/file10.pory, but got '%v'", err)
+	}
+	if len(project.Diagnostics()) != 10 {
+		t.Errorf("Expected the 10 diagnostics of the files before the error, but got %d", len(project.Diagnostics()))
+	}
 }
 
-func TestConstants(t *testing.T) {
+func TestPositions(t *testing.T) {
 	input := `
-const FOO = 2
-const BAR = FLAG_TEMP_1 +   3
-	- FLAG_BASE   const PROF_BIRCH = FOO+1
-const PROF_ELM = PROF_BIRCH - 1
-
-script Script1 {
-	command1(FOO)
-	command2(1, 2 + BAR)
-	command3(2, (PROF_ELM) - ((PROF_BIRCH + FOO)))
-	if (flag(PROF_ELM)) {}
-	if (var(PROF_BIRCH) == PROF_ELM +1) {}
-	switch (var(PROF_ELM)) {
-		case FOO: commandfood()
-		default: command()
+script MyScript {
+	msgbox("Hi", MSGBOX_DEFAULT)
+	if (!flag(FLAG_1) && var(VAR_1) >= 2) {
+		lock
+	} else {
+		release
 	}
+	do {
+		switch (var(VAR_2)) {
+			case 1:
+				waitstate
+			default:
+		}
+	} while (defeated(TRAINER_1) == FALSE)
 }
 
-mapscripts MyMapScript {
-	MAP_SCRIPT_ON_FRAME_TABLE [
-		PROF_ELM, FOO: MyOnFrameScript
-	]
+text MyText {
+	"Hello"
+	"there"
+}
+
+mapscripts MyMapScripts {
+	MAP_SCRIPT_ON_LOAD {
+		end
+	}
 }
 `
 	l := lexer.New(input)
@@ -1014,36 +2677,44 @@ mapscripts MyMapScript {
 		t.Fatalf(err.Error())
 	}
 
-	script := program.TopLevelStatements[0].(*ast.ScriptStatement)
-	command1 := script.Body.Statements[0].(*ast.CommandStatement)
-	command2 := script.Body.Statements[1].(*ast.CommandStatement)
-	command3 := script.Body.Statements[2].(*ast.CommandStatement)
-	testConstant(t, "2", command1.Args[0])
-	testConstant(t, "2 + FLAG_TEMP_1 + 3 - FLAG_BASE", command2.Args[1])
-	testConstant(t, "( 2 + 1 - 1 ) - ( ( 2 + 1 + 2 ) )", command3.Args[1])
-
-	if1 := script.Body.Statements[3].(*ast.IfStatement)
-	op1 := if1.Consequence.Expression.(*ast.OperatorExpression)
-	testConstant(t, "2 + 1 - 1", op1.Operand)
-
-	if2 := script.Body.Statements[4].(*ast.IfStatement)
-	op2 := if2.Consequence.Expression.(*ast.OperatorExpression)
-	testConstant(t, "2 + 1", op2.Operand)
-	testConstant(t, "2 + 1 - 1 + 1", op2.ComparisonValue)
-
-	sw := script.Body.Statements[5].(*ast.SwitchStatement)
-	testConstant(t, "2 + 1 - 1", sw.Operand)
-	testConstant(t, "2", sw.Cases[0].Value)
-
-	ms := program.TopLevelStatements[1].(*ast.MapScriptsStatement)
-	frame := ms.TableMapScripts[0].Entries[0]
-	testConstant(t, "2 + 1 - 1", frame.Condition)
-	testConstant(t, "2", frame.Comparison)
-}
-
-func testConstant(t *testing.T, expected, actual string) {
-	if actual != expected {
-		t.Errorf("Expected '%s', but got '%s'", expected, actual)
+	var sources []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node.(type) {
+		case nil, *ast.Program, *ast.Identifier, *ast.BlockStatement:
+		default:
+			sources = append(sources, fmt.Sprintf("%T: %s", node, input[node.Pos().Offset:node.End().Offset]))
+		}
+		return true
+	})
+	expected := []string{
+		"*ast.ScriptStatement: script MyScript {\n\tmsgbox(\"Hi\", MSGBOX_DEFAULT)\n\tif (!flag(FLAG_1) && var(VAR_1) >= 2) {\n\t\tlock\n\t} else {\n\t\trelease\n\t}\n\tdo {\n\t\tswitch (var(VAR_2)) {\n\t\t\tcase 1:\n\t\t\t\twaitstate\n\t\t\tdefault:\n\t\t}\n\t} while (defeated(TRAINER_1) == FALSE)\n}",
+		"*ast.CommandStatement: msgbox(\"Hi\", MSGBOX_DEFAULT)",
+		"*ast.IfStatement: if (!flag(FLAG_1) && var(VAR_1) >= 2) {\n\t\tlock\n\t} else {\n\t\trelease\n\t}",
+		"*ast.ConditionExpression: !flag(FLAG_1) && var(VAR_1) >= 2) {\n\t\tlock\n\t}",
+		"*ast.BinaryExpression: !flag(FLAG_1) && var(VAR_1) >= 2",
+		"*ast.OperatorExpression: !flag(FLAG_1)",
+		"*ast.OperatorExpression: var(VAR_1) >= 2",
+		"*ast.CommandStatement: lock",
+		"*ast.CommandStatement: release",
+		"*ast.DoWhileStatement: do {\n\t\tswitch (var(VAR_2)) {\n\t\t\tcase 1:\n\t\t\t\twaitstate\n\t\t\tdefault:\n\t\t}\n\t} while (defeated(TRAINER_1) == FALSE)",
+		"*ast.ConditionExpression: switch (var(VAR_2)) {\n\t\t\tcase 1:\n\t\t\t\twaitstate\n\t\t\tdefault:\n\t\t}\n\t} while (defeated(TRAINER_1) == FALSE)",
+		"*ast.OperatorExpression: defeated(TRAINER_1) == FALSE",
+		"*ast.SwitchStatement: switch (var(VAR_2)) {\n\t\t\tcase 1:\n\t\t\t\twaitstate\n\t\t\tdefault:\n\t\t}",
+		"*ast.SwitchCase: case 1:\n\t\t\t\twaitstate",
+		"*ast.CommandStatement: waitstate",
+		"*ast.SwitchCase: default:",
+		"*ast.TextStatement: text MyText {\n\t\"Hello\"\n\t\"there\"\n}",
+		"*ast.MapScriptsStatement: mapscripts MyMapScripts {\n\tMAP_SCRIPT_ON_LOAD {\n\t\tend\n\t}\n}",
+		"*ast.ScriptStatement: MAP_SCRIPT_ON_LOAD {\n\t\tend\n\t}",
+		"*ast.CommandStatement: end",
+	}
+	if len(sources) != len(expected) {
+		t.Fatalf("Expected %d nodes, but got %d:\n%s", len(expected), len(sources), strings.Join(sources, "\n"))
+	}
+	for i := range expected {
+		if sources[i] != expected[i] {
+			t.Errorf("Incorrect span for node %d. Expected:\n%s\nGot:\n%s", i, expected[i], sources[i])
+		}
 	}
 }
 
@@ -1057,7 +2728,7 @@ func TestErrors(t *testing.T) {
 script Script1 {
 	break
 }`,
-			expectedError: "line 3: 'break' statement outside of any break-able scope",
+			expectedError: "line 3:2: 'break' statement outside of any break-able scope",
 		},
 		{
 			input: `
@@ -1068,7 +2739,7 @@ script Script1 {
 	}
 	break
 }`,
-			expectedError: "line 7: 'break' statement outside of any break-able scope",
+			expectedError: "line 7:2: 'break' statement outside of any break-able scope",
 		},
 		{
 			input: `
@@ -1079,7 +2750,7 @@ script Script1 {
 	}
 	continue
 }`,
-			expectedError: "line 7: 'continue' statement outside of any continue-able scope",
+			expectedError: "line 7:2: 'continue' statement outside of any continue-able scope",
 		},
 		{
 			input: `
@@ -1092,7 +2763,7 @@ script Script1 {
 		continue
 	}
 }`,
-			expectedError: "line 8: 'continue' statement outside of any continue-able scope",
+			expectedError: "line 8:3: 'continue' statement outside of any continue-able scope",
 		},
 		{
 			input: `
@@ -1100,27 +2771,27 @@ script Script1 {}
 raw ` + "``" + `
 invalid
 `,
-			expectedError: "line 4: could not parse top-level statement for 'invalid'",
+			expectedError: "line 4:1: could not parse top-level statement for 'invalid'",
 		},
 		{
 			input: `
 raw "stuff"
 `,
-			expectedError: "line 2: raw statement must begin with a backtick character '`'",
+			expectedError: "line 2:1: raw statement must begin with a backtick character '`'",
 		},
 		{
 			input: `
 script {
 	foo
 }`,
-			expectedError: "line 2: missing name for script",
+			expectedError: "line 2:1: missing name for script",
 		},
 		{
 			input: `
 script MyScript
 	foo
 }`,
-			expectedError: "line 2: missing opening curly brace for script 'MyScript'",
+			expectedError: "line 2:8: missing opening curly brace for script 'MyScript'",
 		},
 		{
 			input: `
@@ -1128,7 +2799,7 @@ script MyScript {
 	if (var(VAR_1)) {
 	foo
 }`,
-			expectedError: "line 3: missing closing curly brace for block statement",
+			expectedError: "line 3:2: missing closing curly brace for block statement",
 		},
 		{
 			input: `
@@ -1136,7 +2807,7 @@ script MyScript {
 	switch (var(VAR_1)) {
 	case 1: foo
 `,
-			expectedError: "line 4: missing end for switch case body",
+			expectedError: "line 4:10: missing end for switch case body",
 		},
 		{
 			input: `
@@ -1144,7 +2815,7 @@ script MyScript {
 	foo
 	<
 }`,
-			expectedError: "line 4: could not parse statement for '<'",
+			expectedError: "line 4:2: could not parse statement for '<'",
 		},
 		{
 			input: `
@@ -1155,7 +2826,7 @@ script MyScript {
 		bar
 	}
 }`,
-			expectedError: "line 5: missing opening curly brace of else statement",
+			expectedError: "line 5:4: missing opening curly brace of else statement",
 		},
 		{
 			input: `
@@ -1164,7 +2835,7 @@ script MyScript {
 		foo
 	while (flag(FLAG_1))
 }`,
-			expectedError: "line 3: missing opening curly brace of do...while statement",
+			expectedError: "line 3:2: missing opening curly brace of do...while statement",
 		},
 		{
 			input: `
@@ -1173,7 +2844,7 @@ script MyScript {
 		foo
 	} (flag(FLAG_1))
 }`,
-			expectedError: "line 5: missing 'while' after body of do...while statement",
+			expectedError: "line 5:2: missing 'while' after body of do...while statement",
 		},
 		{
 			input: `
@@ -1182,7 +2853,7 @@ script MyScript {
 		foo
 	} while flag(FLAG_1)
 }`,
-			expectedError: "line 5: missing '(' to start condition for do...while statement",
+			expectedError: "line 5:4: missing '(' to start condition for do...while statement",
 		},
 		{
 			input: `
@@ -1192,7 +2863,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 5: 'continue' must be the last statement in block scope",
+			expectedError: "line 5:3: 'continue' must be the last statement in block scope",
 		},
 		{
 			input: `
@@ -1202,7 +2873,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing opening parenthesis of switch statement operand",
+			expectedError: "line 3:2: missing opening parenthesis of switch statement operand",
 		},
 		{
 			input: `
@@ -1212,7 +2883,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: invalid switch statement operand 'flag'. Must be 'var`",
+			expectedError: "line 3:10: invalid switch statement operand 'flag'. Must be 'var`",
 		},
 		{
 			input: `
@@ -1222,7 +2893,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing '(' after var operator. Got 'FLAG_1` instead",
+			expectedError: "line 3:14: missing '(' after var operator. Got 'FLAG_1` instead",
 		},
 		{
 			input: `
@@ -1232,7 +2903,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing opening curly brace of switch statement",
+			expectedError: "line 3:21: missing opening curly brace of switch statement",
 		},
 		{
 			input: `
@@ -1245,7 +2916,20 @@ script MyScript {
 		bar
 	}
 }`,
-			expectedError: "line 7: duplicate switch cases detected for case '1'",
+			expectedError: "line 7:8: duplicate switch cases detected for case '1'",
+		},
+		{
+			input: `
+const FIRST = 16
+script MyScript {
+	switch (var(VAR_1)) {
+	case FIRST:
+		foo
+	case 0x10:
+		bar
+	}
+}`,
+			expectedError: "line 7:11: duplicate switch cases detected for case '0x10'",
 		},
 		{
 			input: `
@@ -1257,7 +2941,7 @@ script MyScript {
 		baz
 	}
 }`,
-			expectedError: "line 6: missing `:` after default",
+			expectedError: "line 6:2: missing `:` after default",
 		},
 		{
 			input: `
@@ -1268,7 +2952,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 5: missing `:` after 'case'",
+			expectedError: "line 5:2: missing `:` after 'case'",
 		},
 		{
 			input: `
@@ -1276,28 +2960,28 @@ script MyScript {
 	switch (var(FLAG_1)) {
 		foo
 	}`,
-			expectedError: "line 4: invalid start of switch case 'foo'. Expected 'case' or 'default'",
+			expectedError: "line 4:3: invalid start of switch case 'foo'. Expected 'case' or 'default'",
 		},
 		{
 			input: `
 script MyScript {
 	switch (var(FLAG_1)) {
 	}`,
-			expectedError: "line 3: switch statement has no cases or default case",
+			expectedError: "line 3:2: switch statement has no cases or default case",
 		},
 		{
 			input: `
 script MyScript {
 	if var(FLAG_1)) {
 	}`,
-			expectedError: "line 3: missing '(' to start boolean expression",
+			expectedError: "line 3:5: missing '(' to start boolean expression",
 		},
 		{
 			input: `
 script MyScript {
 	if (var(FLAG_1) ||) {
 	}`,
-			expectedError: "line 3: left side of binary expression must be var(), flag(), or defeated() operator. Instead, found ')'",
+			expectedError: "line 3:18: left side of binary expression must be var(), flag(), or defeated() operator. Instead, found ')'",
 		},
 		{
 			input: `
@@ -1305,7 +2989,7 @@ script MyScript {
 	if (var(FLAG_1)) 
 		foo
 	}`,
-			expectedError: "line 4: expected next token to be '{', got 'foo' instead",
+			expectedError: "line 4:3: expected next token to be '{', got 'foo' instead",
 		},
 		{
 			input: `
@@ -1313,7 +2997,7 @@ script MyScript {
 	if (var(FLAG_1) && (var(VAR_1) == 1 {
 		foo
 	}`,
-			expectedError: "line 3: missing ')', '&&' or '||' when evaluating 'var' operator",
+			expectedError: "line 3:36: missing ')', '&&' or '||' when evaluating 'var' operator",
 		},
 		{
 			input: `
@@ -1321,7 +3005,7 @@ script MyScript {
 	if (var{FLAG_1) {
 		foo
 	}`,
-			expectedError: "line 3: missing opening parenthesis for condition operator 'VAR'",
+			expectedError: "line 3:6: missing opening parenthesis for condition operator 'VAR'",
 		},
 		{
 			input: `
@@ -1329,7 +3013,7 @@ script MyScript {
 	if (flag{FLAG_1) {
 		foo
 	}`,
-			expectedError: "line 3: missing opening parenthesis for condition operator 'FLAG'",
+			expectedError: "line 3:6: missing opening parenthesis for condition operator 'FLAG'",
 		},
 		{
 			input: `
@@ -1337,7 +3021,7 @@ script MyScript {
 	if (flag()) {
 		foo
 	}`,
-			expectedError: "line 3: missing value for condition operator 'FLAG'",
+			expectedError: "line 3:10: missing value for condition operator 'FLAG'",
 		},
 		{
 			input: `
@@ -1345,7 +3029,7 @@ script MyScript {
 	foo(sdfa
 	bar()
 }`,
-			expectedError: "line 3: missing closing parenthesis for command 'foo'",
+			expectedError: "line 3:2: missing closing parenthesis for command 'foo'",
 		},
 		{
 			input: `
@@ -1356,7 +3040,49 @@ script MyScript {
 		bar
 	}
 }`,
-			expectedError: "line 5: left side of binary expression must be var(), flag(), or defeated() operator. Instead, found 'fla'",
+			expectedError: "line 5:9: left side of binary expression must be var(), flag(), or defeated() operator. Instead, found 'fla'. Did you mean 'flag'?",
+		},
+		{
+			input: `
+scirpt Script1 {
+	end
+}`,
+			expectedError: "line 2:1: could not parse top-level statement for 'scirpt'. Did you mean 'script'?",
+		},
+		{
+			input: `
+script Script1 {
+	if (flag(FLAG_1)) {
+		end
+	} elseif (flag(FLAG_2)) {
+		end
+	}
+}`,
+			expectedError: "line 5:26: could not parse statement for '{'. Did you mean 'elif' instead of 'elseif'?",
+		},
+		{
+			input: `
+script Script1 {
+	swithc (var(VAR_1)) {
+		case 1: end
+	}
+}`,
+			expectedError: "line 3:22: could not parse statement for '{'. Did you mean 'switch' instead of 'swithc'?",
+		},
+		{
+			input: `
+script Script1 {
+	lock {
+	}
+}`,
+			expectedError: "line 3:7: could not parse statement for '{'",
+		},
+		{
+			input: `
+script(gloabl) Script1 {
+	end
+}`,
+			expectedError: "line 2:8: scope modifier must be 'global' or 'local', but got 'gloabl' instead. Did you mean 'global'?",
 		},
 		{
 			input: `
@@ -1367,7 +3093,7 @@ script MyScript {
 		else
 	}
 }`,
-			expectedError: "line 6: could not parse statement for 'else'",
+			expectedError: "line 6:3: could not parse statement for 'else'",
 		},
 		{
 			input: `
@@ -1377,7 +3103,7 @@ script MyScript {
 		break
 	} while (flag(FLAG_1))
 }`,
-			expectedError: "line 5: 'continue' must be the last statement in block scope",
+			expectedError: "line 5:3: 'continue' must be the last statement in block scope",
 		},
 		{
 			input: `
@@ -1386,7 +3112,7 @@ script MyScript {
 		continue
 	} while (flag(FLAG_1) == 45)
 }`,
-			expectedError: "line 5: invalid flag comparison value '45'. Only TRUE and FALSE are allowed",
+			expectedError: "line 5:27: invalid flag comparison value '45'. Only TRUE and FALSE are allowed",
 		},
 		{
 			input: `
@@ -1400,7 +3126,7 @@ script MyScript {
 		baz
 	}
 }`,
-			expectedError: "line 8: multiple `default` cases found in switch statement. Only one `default` case is allowed",
+			expectedError: "line 8:9: multiple `default` cases found in switch statement. Only one `default` case is allowed",
 		},
 		{
 			input: `
@@ -1413,7 +3139,7 @@ script MyScript {
 		continue
 	}
 }`,
-			expectedError: "line 8: 'continue' statement outside of any continue-able scope",
+			expectedError: "line 8:3: 'continue' statement outside of any continue-able scope",
 		},
 		{
 			input: `
@@ -1422,7 +3148,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing closing ')' for nested boolean expression",
+			expectedError: "line 3:23: missing closing ')' for nested boolean expression",
 		},
 		{
 			input: `
@@ -1431,7 +3157,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing closing ')' for condition operator value",
+			expectedError: "line 3:14: missing closing ')' for condition operator value",
 		},
 		{
 			input: `
@@ -1440,7 +3166,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing comparison value for flag operator",
+			expectedError: "line 3:25: missing comparison value for flag operator",
 		},
 		{
 			input: `
@@ -1449,7 +3175,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing comparison value for var operator",
+			expectedError: "line 3:23: missing comparison value for var operator",
 		},
 		{
 			input: `
@@ -1458,7 +3184,7 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing comparison value for defeated operator",
+			expectedError: "line 3:31: missing comparison value for defeated operator",
 		},
 		{
 			input: `
@@ -1467,21 +3193,21 @@ script MyScript {
 		foo
 	}
 }`,
-			expectedError: "line 3: missing value for condition operator 'FLAG'",
+			expectedError: "line 3:56: missing value for condition operator 'FLAG'",
 		},
 		{
 			input: `
 text {
 	"MyText$"
 }`,
-			expectedError: "line 2: missing name for text statement",
+			expectedError: "line 2:1: missing name for text statement",
 		},
 		{
 			input: `
 text Text1
 	"MyText$"
 }`,
-			expectedError: "line 3: missing opening curly brace for text 'Text1'",
+			expectedError: "line 3:2: missing opening curly brace for text 'Text1'",
 		},
 		{
 			input: `
@@ -1489,7 +3215,7 @@ text Text1 {
 	nottext
 	"MyText$"
 }`,
-			expectedError: "line 3: body of text statement must be a string or formatted string. Got 'nottext' instead",
+			expectedError: "line 3:2: body of text statement must be a string or formatted string. Got 'nottext' instead",
 		},
 		{
 			input: `
@@ -1497,7 +3223,7 @@ text Text1 {
 	"MyText$"
 	notcurlybrace
 }`,
-			expectedError: "line 4: expected closing curly brace for text. Got 'notcurlybrace' instead",
+			expectedError: "line 4:2: expected closing curly brace for text. Got 'notcurlybrace' instead",
 		},
 		{
 			input: `
@@ -1514,138 +3240,138 @@ text Script1_Text_0 {
 movement {
 	
 }`,
-			expectedError: "line 2: missing name for movement statement",
+			expectedError: "line 2:1: missing name for movement statement",
 		},
 		{
 			input: `
 movement Foo
 	walk_up
 }`,
-			expectedError: "line 3: missing opening curly brace for movement 'Foo'",
+			expectedError: "line 3:2: missing opening curly brace for movement 'Foo'",
 		},
 		{
 			input: `
 movement Foo {
 	+
 }`,
-			expectedError: "line 3: expected movement command, but got '+' instead",
+			expectedError: "line 3:2: expected movement command, but got '+' instead",
 		},
 		{
 			input: `
 movement Foo {
 	walk_up * walk_down
 }`,
-			expectedError: "line 3: expected mulplier number for movement command, but got 'walk_down' instead",
+			expectedError: "line 3:12: expected mulplier number for movement command, but got 'walk_down' instead",
 		},
 		{
 			input: `
 movement Foo {
 	walk_up * 999999999999999999999999999999999
 }`,
-			expectedError: "line 3: invalid movement mulplier integer '999999999999999999999999999999999': strconv.ParseInt: parsing \"999999999999999999999999999999999\": value out of range",
+			expectedError: "line 3:12: invalid movement mulplier integer '999999999999999999999999999999999': strconv.ParseInt: parsing \"999999999999999999999999999999999\": value out of range",
 		},
 		{
 			input: `
 movement Foo {
 	walk_up * 10000
 }`,
-			expectedError: "line 3: movement mulplier '10000' is too large. Maximum is 9999",
+			expectedError: "line 3:12: movement mulplier '10000' is too large. Maximum is 9999",
 		},
 		{
 			input: `
 movement Foo {
 	walk_up * 0
 }`,
-			expectedError: "line 3: movement mulplier must be a positive integer, but got '0' instead",
+			expectedError: "line 3:12: movement mulplier must be a positive integer, but got '0' instead",
 		},
 		{
 			input: `
 movement Foo {
 	walk_up * -2
 }`,
-			expectedError: "line 3: movement mulplier must be a positive integer, but got '-2' instead",
+			expectedError: "line 3:12: movement mulplier must be a positive integer, but got '-2' instead",
 		},
 		{
 			input: `
 text Foo {
 	format asdf
 }`,
-			expectedError: "line 3: format operator must begin with an open parenthesis '('",
+			expectedError: "line 3:9: format operator must begin with an open parenthesis '('",
 		},
 		{
 			input: `
 text Foo {
 	format()
 }`,
-			expectedError: "line 3: invalid format() argument ')'. Expected a string literal",
+			expectedError: "line 3:9: invalid format() argument ')'. Expected a string literal",
 		},
 		{
 			input: `
 text Foo {
 	format("Hi", )
 }`,
-			expectedError: "line 3: invalid format() parameter ')'. Expected either fontId (string) or maxLineLength (integer)",
+			expectedError: "line 3:15: invalid format() parameter ')'. Expected either fontId (string) or maxLineLength (integer)",
 		},
 		{
 			input: `
 text Foo {
 	format("Hi"
 }`,
-			expectedError: "line 4: missing closing parenthesis ')' for format()",
+			expectedError: "line 4:1: missing closing parenthesis ')' for format()",
 		},
 		{
 			input: `
 text Foo {
 	format("Hi", "TEST", "NOT_AN_INT")
 }`,
-			expectedError: "line 3: invalid format() maxLineLength 'NOT_AN_INT'. Expected integer",
+			expectedError: "line 3:23: invalid format() maxLineLength 'NOT_AN_INT'. Expected integer",
 		},
 		{
 			input: `
 text Foo {
 	format("Hi", 100, 42)
 }`,
-			expectedError: "line 3: invalid format() fontId '42'. Expected string",
+			expectedError: "line 3:20: invalid format() fontId '42'. Expected string",
 		},
 		{
 			input: `
 script Foo {
 	msgbox(format("Hi", ))
 }`,
-			expectedError: "line 3: invalid format() parameter ')'. Expected either fontId (string) or maxLineLength (integer)",
+			expectedError: "line 3:22: invalid format() parameter ')'. Expected either fontId (string) or maxLineLength (integer)",
 		},
 		{
 			input: `
 text Foo {
 	format("Hi", "invalidFontID")
 }`,
-			expectedError: "line 3: Unknown fontID 'invalidFontID' used in format(). List of valid fontIDs are '[1_latin]'",
+			expectedError: "line 3:9: Unknown fontID 'invalidFontID' used in format(). List of valid fontIDs are '[1_latin]'",
 		},
 		{
 			input: `
 mapscripts {
 }`,
-			expectedError: "line 2: missing name for mapscripts statement",
+			expectedError: "line 2:1: missing name for mapscripts statement",
 		},
 		{
 			input: `
 mapscripts MyMapScripts
 }`,
-			expectedError: "line 3: missing opening curly brace for mapscripts 'MyMapScripts'",
+			expectedError: "line 3:1: missing opening curly brace for mapscripts 'MyMapScripts'",
 		},
 		{
 			input: `
 mapscripts MyMapScripts {
 	+
 }`,
-			expectedError: "line 3: expected map script type, but got '+' instead",
+			expectedError: "line 3:2: expected map script type, but got '+' instead",
 		},
 		{
 			input: `
 mapscripts MyMapScripts {
 	SOME_TYPE: 5
 }`,
-			expectedError: "line 3: expected map script label after ':', but got '5' instead",
+			expectedError: "line 3:13: expected map script label after ':', but got '5' instead",
 		},
 		{
 			input: `
@@ -1654,7 +3380,7 @@ mapscripts MyMapScripts {
 		if (sdf)
 	}
 }`,
-			expectedError: "line 4: left side of binary expression must be var(), flag(), or defeated() operator. Instead, found 'sdf'",
+			expectedError: "line 4:6: left side of binary expression must be var(), flag(), or defeated() operator. Instead, found 'sdf'",
 		},
 		{
 			input: `
@@ -1663,7 +3389,7 @@ mapscripts MyMapScripts {
 		VAR_TEMP
 	]
 }`,
-			expectedError: "line 4: missing ',' to specify map script table entry comparison value",
+			expectedError: "line 4:3: missing ',' to specify map script table entry comparison value",
 		},
 		{
 			input: `
@@ -1672,7 +3398,7 @@ mapscripts MyMapScripts {
 		VAR_TEMP, : Foo
 	]
 }`,
-			expectedError: "line 4: expected comparison value for map script table entry, but it was empty",
+			expectedError: "line 4:13: expected comparison value for map script table entry, but it was empty",
 		},
 		{
 			input: `
@@ -1681,7 +3407,7 @@ mapscripts MyMapScripts {
 		VAR_TEMP, FOO
 	]
 }`,
-			expectedError: "line 4: missing ':' or '{' to specify map script table entry",
+			expectedError: "line 4:13: missing ':' or '{' to specify map script table entry",
 		},
 		{
 			input: `
@@ -1690,7 +3416,7 @@ mapscripts MyMapScripts {
 		, FOO: Foo Script
 	]
 }`,
-			expectedError: "line 4: expected condition for map script table entry, but it was empty",
+			expectedError: "line 4:3: expected condition for map script table entry, but it was empty",
 		},
 		{
 			input: `
@@ -1699,7 +3425,7 @@ mapscripts MyMapScripts {
 		VAR_TEMP, 1: 5
 	]
 }`,
-			expectedError: "line 4: expected map script label after ':', but got '5' instead",
+			expectedError: "line 4:16: expected map script label after ':', but got '5' instead",
 		},
 		{
 			input: `
@@ -1710,53 +3436,193 @@ mapscripts MyMapScripts {
 		}
 	]
 }`,
-			expectedError: "line 5: missing closing parenthesis for command 'msgbox'",
+			expectedError: "line 5:4: missing closing parenthesis for command 'msgbox'",
 		},
 		{
 			input: `
 script(asdf) MyScript {}`,
-			expectedError: "line 2: scope modifier must be 'global' or 'local', but got 'asdf' instead",
+			expectedError: "line 2:8: scope modifier must be 'global' or 'local', but got 'asdf' instead",
 		},
 		{
 			input: `
 script(local MyScript {}`,
-			expectedError: "line 2: missing ')' after scope modifier. Got 'MyScript' instead",
+			expectedError: "line 2:14: missing ')' after scope modifier. Got 'MyScript' instead",
 		},
 		{
 			input: `
 text(local MyText {"test"}`,
-			expectedError: "line 2: missing ')' after scope modifier. Got 'MyText' instead",
+			expectedError: "line 2:12: missing ')' after scope modifier. Got 'MyText' instead",
 		},
 		{
 			input: `
 movement() MyMovement {walk_left}`,
-			expectedError: "line 2: scope modifier must be 'global' or 'local', but got ')' instead",
+			expectedError: "line 2:10: scope modifier must be 'global' or 'local', but got ')' instead",
 		},
 		{
 			input: `
 mapscripts() MyMapScripts {}`,
-			expectedError: "line 2: scope modifier must be 'global' or 'local', but got ')' instead",
+			expectedError: "line 2:12: scope modifier must be 'global' or 'local', but got ')' instead",
 		},
 		{
 			input:         `const 45`,
-			expectedError: "line 1: expected identifier after const, but got '45' instead",
+			expectedError: "line 1:7: expected identifier after const, but got '45' instead",
 		},
 		{
 			input:         `const FOO`,
-			expectedError: "line 1: missing equals sign after const name 'FOO'",
+			expectedError: "line 1:10: missing equals sign after const name 'FOO'",
 		},
 		{
 			input:         `const FOO = 4 const FOO = 5`,
-			expectedError: "line 1: duplicate const 'FOO'. Must use unique const names",
+			expectedError: "line 1:21: duplicate const 'FOO'. Must use unique const names",
 		},
 		{
 			input:         `const FOO = `,
-			expectedError: "line 1: missing value for const 'FOO'",
+			expectedError: "line 1:1: missing value for const 'FOO'",
 		},
 		{
 			input: `const FOO = 
 			script MyScript {}`,
-			expectedError: "line 1: missing value for const 'FOO'",
+			expectedError: "line 1:1: missing value for const 'FOO'",
+		},
+		{
+			input:         `directive FOO`,
+			expectedError: "line 1:11: directive statement must be a string or backtick-delimited value. Got 'FOO' instead",
+		},
+		{
+			input:         `directive ""`,
+			expectedError: "line 1:11: directive statement cannot be empty",
+		},
+		{
+			input:         `@foo script MyScript {}`,
+			expectedError: "line 1:2: unknown annotation '@foo'",
+		},
+		{
+			input:         `@unused @unused script MyScript {}`,
+			expectedError: "line 1:9: duplicate annotation '@unused'",
+		},
+		{
+			input:         `@align script MyScript {}`,
+			expectedError: "line 1:1: wrong number of arguments for annotation '@align'. Got 0",
+		},
+		{
+			input:         `@align(FOO) script MyScript {}`,
+			expectedError: "line 1:1: invalid alignment 'FOO' for annotation '@align'. Expected integer",
+		},
+		{
+			input:         `data {}`,
+			expectedError: "line 1:1: missing name for data statement",
+		},
+		{
+			input:         `data MyData .byte: 1`,
+			expectedError: "line 1:13: missing opening curly brace for data 'MyData'",
+		},
+		{
+			input:         `data MyData { .byte: 1`,
+			expectedError: "line 1:1: missing closing curly brace for data 'MyData'",
+		},
+		{
+			input:         `data MyData { 1, 2 }`,
+			expectedError: "line 1:15: expected data type, like '.2byte', but got '1' instead",
+		},
+		{
+			input:         `data MyData { .3byte: 1 }`,
+			expectedError: "line 1:15: invalid data type '.3byte'. Expected '.byte', '.2byte', or '.4byte'",
+		},
+		{
+			input:         `data MyData { .byte 1 }`,
+			expectedError: "line 1:21: missing ':' after data type '.byte'",
+		},
+		{
+			input:         `data MyData { .byte: 1, }`,
+			expectedError: "line 1:25: expected data value, but got '}' instead",
+		},
+		{
+			input:         `alias = MyScript`,
+			expectedError: "line 1:1: missing name for alias statement",
+		},
+		{
+			input:         `alias OldScript MyScript`,
+			expectedError: "line 1:17: missing equals sign after alias name 'OldScript'",
+		},
+		{
+			input:         `alias OldScript = "MyScript"`,
+			expectedError: "line 1:19: missing label for alias 'OldScript'",
+		},
+		{
+			input:         `alias OldScript = OldScript`,
+			expectedError: "line 1:19: alias 'OldScript' can't refer to itself",
+		},
+		{
+			input:         `@language(JAPANESE) script MyScript {}`,
+			expectedError: "line 1:1: annotation '@language' can only be applied to 'text'",
+		},
+		{
+			input:         `@unused const FOO = 1`,
+			expectedError: "line 1:9: annotations cannot be applied to 'const'",
+		},
+		{
+			input:         `script MyScript { call Unknown(1) }`,
+			expectedError: "line 1:24: unknown script 'Unknown' in parameterized call",
+		},
+		{
+			input:         `script MyScript(a, a) {}`,
+			expectedError: "line 1:20: duplicate parameter 'a' for script 'MyScript'",
+		},
+		{
+			input:         `script MyScript(a, b, c, d, e, f, g, h, i) {}`,
+			expectedError: "line 1:41: too many parameters for script 'MyScript'. Only 8 parameter vars are available",
+		},
+		{
+			input:         `script MyScript { call MyScript(1,,2) }`,
+			expectedError: "line 1:24: missing argument for call to script 'MyScript'",
+		},
+		{
+			input:         `macro foo(a) {} script MyScript { foo(1, 2) }`,
+			expectedError: "line 1:35: macro 'foo' expects 1 arguments, but got 2",
+		},
+		{
+			input:         `macro foo {} macro foo {}`,
+			expectedError: "line 1:20: duplicate macro 'foo'. Must use unique macro names",
+		},
+		{
+			input:         `macro foo { foo } script MyScript { foo }`,
+			expectedError: "line 1:13: maximum macro expansion depth exceeded when expanding macro 'foo'. Is it recursive?",
+		},
+		{
+			input: `macro foo {
+	lock`,
+			expectedError: "line 1:1: missing closing curly brace for macro 'foo'",
+		},
+		{
+			input: `
+macro stop {
+	break
+}
+script MyScript {
+	stop
+}`,
+			expectedError: "line 3:2: 'break' statement outside of any break-able scope",
+		},
+		{
+			input: `
+macro skip {
+	continue
+}
+script MyScript {
+	switch (var(VAR_1)) {
+	case 1:
+		skip
+	}
+}`,
+			expectedError: "line 3:2: 'continue' statement outside of any continue-able scope",
+		},
+		{
+			input:         `texttemplate Foo(a) = "{a}" script MyScript { msgbox(Foo()) }`,
+			expectedError: "line 1:54: texttemplate 'Foo' expects 1 arguments, but got 0",
+		},
+		{
+			input:         `texttemplate Foo(a) = FOO`,
+			expectedError: "line 1:23: expected string value for texttemplate 'Foo', but got 'FOO' instead",
 		},
 	}
 
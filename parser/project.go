@@ -0,0 +1,370 @@
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Project compiles multiple Poryscript files as a single unit. Labels
+// defined in one file of the project can be referenced by the other files,
+// and those references are validated across all of the files.
+type Project struct {
+	fontConfigFilepath string
+	compileSwitches    map[string]string
+	paramVars          []string
+	nestingLimit       int
+	lexerMode          lexer.Mode
+	loadFile           FileLoader
+	filepaths          []string
+	diagnosticOptions  DiagnosticOptions
+	lintConfig         *LintConfig
+	autoEndConfig      *AutoEndConfig
+	fixCallEnds        bool
+	commandSignatures  CommandSignatures
+	diagnostics        []Diagnostic
+}
+
+// ProjectFile is a single parsed file of a Project.
+type ProjectFile struct {
+	Filepath string
+	Program  *ast.Program
+	// The files that the file imports, directly or indirectly.
+	Imports []string
+}
+
+type projectFile struct {
+	filepath string
+	parser   *Parser
+	program  *ast.Program
+}
+
+// The result of parsing one of the files of a project.
+type parsedProjectFile struct {
+	file *projectFile
+	err  error
+}
+
+// A top-level label defined in one of the files of a project.
+type projectLabel struct {
+	location  labelLocation
+	statement ast.Statement
+}
+
+// NewProject initializes a new Poryscript project for the given files.
+func NewProject(filepaths []string, fontConfigFilepath string, compileSwitches map[string]string) *Project {
+	return &Project{
+		fontConfigFilepath: fontConfigFilepath,
+		compileSwitches:    compileSwitches,
+		paramVars:          DefaultParamVars,
+		nestingLimit:       DefaultNestingLimit,
+		loadFile:           loadFile,
+		filepaths:          filepaths,
+	}
+}
+
+// SetParamVars sets the vars that are used to pass parameters to scripts.
+func (proj *Project) SetParamVars(vars []string) {
+	proj.paramVars = vars
+}
+
+// SetNestingLimit sets the maximum depth of nested blocks and boolean
+// expressions.
+func (proj *Project) SetNestingLimit(limit int) {
+	proj.nestingLimit = limit
+}
+
+// SetLexerMode sets the lexer modes that the project's files are read with.
+func (proj *Project) SetLexerMode(mode lexer.Mode) {
+	proj.lexerMode = mode
+}
+
+// SetFileLoader sets the function used to read the project's files.
+func (proj *Project) SetFileLoader(loader FileLoader) {
+	proj.loadFile = loader
+}
+
+// SetDiagnosticOptions sets the options that control how warnings are reported.
+func (proj *Project) SetDiagnosticOptions(options DiagnosticOptions) {
+	proj.diagnosticOptions = options
+}
+
+// SetLintConfig enables the lint rules in the given config for all files.
+func (proj *Project) SetLintConfig(config *LintConfig) {
+	proj.lintConfig = config
+}
+
+// SetAutoEnd enables the pass that ends scripts for all files.
+func (proj *Project) SetAutoEnd(config *AutoEndConfig) {
+	proj.autoEndConfig = config
+}
+
+// SetFixCallEnds sets whether "end" is replaced with "return" in called
+// scripts for all files.
+func (proj *Project) SetFixCallEnds(enabled bool) {
+	proj.fixCallEnds = enabled
+}
+
+// SetCommandSignatures sets the signatures of the commands for all files.
+func (proj *Project) SetCommandSignatures(signatures CommandSignatures) {
+	proj.commandSignatures = signatures
+}
+
+// Diagnostics returns the diagnostics that were produced by the most recent call to ParseProject.
+func (proj *Project) Diagnostics() []Diagnostic {
+	return proj.diagnostics
+}
+
+// Warnings returns the warnings that were produced by the most recent call to ParseProject.
+func (proj *Project) Warnings() []string {
+	return getDiagnosticStrings(proj.diagnostics)
+}
+
+// ParseProject parses every file of the project, and resolves the references
+// between them. The files are returned in the same order they were given.
+// The files are parsed concurrently, but their diagnostics and the first
+// error are reported as if they were parsed one after another.
+func (proj *Project) ParseProject() ([]ProjectFile, error) {
+	proj.diagnostics = make([]Diagnostic, 0)
+	parsed := proj.parseFiles()
+	files := make([]*projectFile, 0, len(proj.filepaths))
+	for _, result := range parsed {
+		if result.file == nil {
+			return nil, result.err
+		}
+		for _, diagnostic := range result.file.parser.Diagnostics() {
+			diagnostic.Filepath = result.file.filepath
+			proj.diagnostics = append(proj.diagnostics, diagnostic)
+		}
+		if result.err != nil {
+			return nil, setErrorFilepath(result.err, result.file.filepath)
+		}
+		files = append(files, result.file)
+	}
+
+	labels, err := getProjectLabels(files)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveProjectParamCalls(files, labels); err != nil {
+		return nil, err
+	}
+	if err := checkProjectReferences(files, labels); err != nil {
+		return nil, err
+	}
+	// The warnings of the project's references are reported with the other
+	// diagnostics of their files.
+	proj.diagnostics = make([]Diagnostic, 0)
+	for _, file := range files {
+		file.parser.checkProjectDeprecatedReferences(file.program, file.filepath, labels)
+		for _, diagnostic := range file.parser.Diagnostics() {
+			diagnostic.Filepath = file.filepath
+			proj.diagnostics = append(proj.diagnostics, diagnostic)
+		}
+	}
+
+	result := make([]ProjectFile, len(files))
+	for i, file := range files {
+		result[i] = ProjectFile{Filepath: file.filepath, Program: file.program, Imports: file.parser.Imports()}
+	}
+	return result, nil
+}
+
+// Parses the files of the project on a pool of GOMAXPROCS workers. The file
+// loader is only called by one worker at a time, since it doesn't need to
+// be safe for concurrent use. The file of a result is nil if it couldn't be
+// loaded.
+func (proj *Project) parseFiles() []parsedProjectFile {
+	var loaderMutex sync.Mutex
+	loadFile := func(path string) (string, error) {
+		loaderMutex.Lock()
+		defer loaderMutex.Unlock()
+		return proj.loadFile(path)
+	}
+	parsed := make([]parsedProjectFile, len(proj.filepaths))
+	indexes := make(chan int, len(proj.filepaths))
+	for i := range proj.filepaths {
+		indexes <- i
+	}
+	close(indexes)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(proj.filepaths) {
+		workers = len(proj.filepaths)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				parsed[i] = proj.parseFile(proj.filepaths[i], loadFile)
+			}
+		}()
+	}
+	wg.Wait()
+	return parsed
+}
+
+func (proj *Project) parseFile(path string, loadFile FileLoader) parsedProjectFile {
+	input, err := loadFile(path)
+	if err != nil {
+		return parsedProjectFile{err: err}
+	}
+	p := New(lexer.NewWithMode(input, proj.lexerMode), proj.fontConfigFilepath, proj.compileSwitches)
+	p.SetFilepath(path)
+	p.SetFileLoader(loadFile)
+	p.SetParamVars(proj.paramVars)
+	p.SetNestingLimit(proj.nestingLimit)
+	p.SetDiagnosticOptions(proj.diagnosticOptions)
+	p.SetLintConfig(proj.lintConfig)
+	p.SetAutoEnd(proj.autoEndConfig)
+	p.SetFixCallEnds(proj.fixCallEnds)
+	p.SetCommandSignatures(proj.commandSignatures)
+	p.deferParamCalls = true
+	program, err := p.ParseProgram()
+	return parsedProjectFile{file: &projectFile{filepath: path, parser: p, program: program}, err: err}
+}
+
+// Collects the top-level labels defined by all files in the project.
+// A global label can only be defined once across the entire project.
+func getProjectLabels(files []*projectFile) (map[string][]projectLabel, error) {
+	labels := make(map[string][]projectLabel)
+	for _, file := range files {
+		for _, stmt := range file.program.TopLevelStatements {
+			name, ok := getStatementName(stmt)
+			if !ok {
+				continue
+			}
+			location := getLabelLocation(stmt, file.filepath)
+			for _, existing := range labels[name] {
+				if isDuplicateLabel(location, existing.location) {
+					err := parseErrorf(ErrorDuplicate, location.pos, "duplicate label '%s', which is already defined at %s", name, existing.location)
+					return nil, setErrorFilepath(err, location.filepath)
+				}
+			}
+			labels[name] = append(labels[name], projectLabel{location: location, statement: stmt})
+		}
+	}
+	return labels, nil
+}
+
+func resolveProjectParamCalls(files []*projectFile, labels map[string][]projectLabel) error {
+	for _, file := range files {
+		scripts := make(map[string]*ast.ScriptStatement)
+		for name, label := range labels {
+			if definition, ok := getVisibleLabel(label, file.filepath); ok {
+				if scriptStmt, ok := definition.statement.(*ast.ScriptStatement); ok {
+					scripts[name] = scriptStmt
+				}
+			}
+		}
+		calls := file.parser.paramCalls
+		file.parser.paramCalls = make([]paramCall, 0)
+		file.parser.deferParamCalls = false
+		if err := file.parser.assignParamCalls(calls, scripts); err != nil {
+			return setErrorFilepath(err, file.filepath)
+		}
+	}
+	return nil
+}
+
+// Returns the definition of a label that is visible from the given file.
+// A file's own definition takes precedence over global definitions
+// from other files.
+func getVisibleLabel(definitions []projectLabel, filepath string) (projectLabel, bool) {
+	for _, definition := range definitions {
+		if definition.location.filepath == filepath {
+			return definition, true
+		}
+	}
+	for _, definition := range definitions {
+		if definition.location.isGlobal {
+			return definition, true
+		}
+	}
+	return projectLabel{}, false
+}
+
+// Warns about references to the deprecated labels of the other files of the
+// project. The references to the file's own labels, and to the labels of
+// the files that it imports, were already checked when it was parsed.
+func (p *Parser) checkProjectDeprecatedReferences(program *ast.Program, filepath string, labels map[string][]projectLabel) {
+	deprecated := make(map[string]ast.Annotation)
+	for name, definitions := range labels {
+		definition, ok := getVisibleLabel(definitions, filepath)
+		if !ok || definition.location.filepath == filepath {
+			continue
+		}
+		if _, ok := p.importedDeprecations[name]; ok {
+			continue
+		}
+		if annotation, ok := ast.AnnotationsOf(definition.statement).Get("deprecated"); ok {
+			deprecated[name] = annotation
+		}
+	}
+	p.warnDeprecatedReferences(program, deprecated)
+}
+
+// Validates that references to labels defined in other files of the project
+// only refer to global labels. Local labels are not visible outside of the
+// file that defines them.
+func checkProjectReferences(files []*projectFile, labels map[string][]projectLabel) error {
+	for _, file := range files {
+		checkReference := func(pos token.Position, context string, name string) error {
+			definitions, ok := labels[name]
+			if !ok {
+				return nil
+			}
+			if _, ok := getVisibleLabel(definitions, file.filepath); ok {
+				return nil
+			}
+			err := parseErrorf(ErrorScope, pos, "%s references '%s', which is local to '%s'. Use the 'global' scope modifier to make it visible to other files", context, name, filepath.Clean(definitions[0].location.filepath))
+			return setErrorFilepath(err, file.filepath)
+		}
+
+		var err error
+		for _, script := range getScripts(file.program) {
+			walkStatements(script.Body.Statements, func(stmt ast.Statement) {
+				command, ok := stmt.(*ast.CommandStatement)
+				if !ok || err != nil {
+					return
+				}
+				for _, arg := range command.Args {
+					context := fmt.Sprintf("'%s' in script '%s'", command.Name.Value, script.Name.Value)
+					if err = checkReference(command.Token.Pos(), context, arg); err != nil {
+						return
+					}
+				}
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, stmt := range file.program.TopLevelStatements {
+			mapScriptsStmt, ok := stmt.(*ast.MapScriptsStatement)
+			if !ok {
+				continue
+			}
+			context := fmt.Sprintf("mapscripts '%s'", mapScriptsStmt.Name.Value)
+			for _, mapScript := range mapScriptsStmt.MapScripts {
+				if err := checkReference(mapScript.Token.Pos(), context, mapScript.Name); err != nil {
+					return err
+				}
+			}
+			for _, tableMapScript := range mapScriptsStmt.TableMapScripts {
+				for _, entry := range tableMapScript.Entries {
+					if err := checkReference(entry.Token.Pos(), context, entry.Name); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// CommandSignature describes the parameters of a command, as they are
+// declared by its assembler macro.
+type CommandSignature struct {
+	Name   string
+	Params []CommandParam
+	// The location of the macro's definition.
+	Filepath   string
+	LineNumber int
+}
+
+// CommandParam is a single parameter of a command's macro.
+type CommandParam struct {
+	Name string
+	// Required parameters are declared with ":req".
+	Required bool
+	// The value of the parameter when it is omitted, if it has one.
+	Default string
+	// A vararg parameter accepts all of the remaining arguments.
+	Vararg bool
+	// The kind of value that the parameter accepts, which is learned from
+	// how the macro emits it.
+	Kind ParamKind
+
+	uses []paramUse
+}
+
+// ParamKind is the kind of value that a command parameter accepts.
+type ParamKind int
+
+// Kinds of command parameters.
+const (
+	// The parameter's kind is unknown, so it accepts any value.
+	ParamAny ParamKind = iota
+	// A number, or a constant. The parameter is emitted as a byte or a
+	// halfword, so it can't be a label or a string.
+	ParamNumber
+	// A pointer, which is usually a label or a string. The parameter is
+	// emitted as a word.
+	ParamLabel
+)
+
+func (k ParamKind) String() string {
+	switch k {
+	case ParamNumber:
+		return "number"
+	case ParamLabel:
+		return "label"
+	}
+	return "any"
+}
+
+// A place where a macro's body uses one of its parameters. It either emits
+// the parameter directly, or passes it to another macro.
+type paramUse struct {
+	kind  ParamKind
+	macro string
+	index int
+}
+
+// The data directives that a parameter's kind is learned from.
+var paramKindDirectives = map[string]ParamKind{
+	".byte":  ParamNumber,
+	".2byte": ParamNumber,
+	".hword": ParamNumber,
+	".short": ParamNumber,
+	".4byte": ParamLabel,
+	".word":  ParamLabel,
+	".long":  ParamLabel,
+}
+
+// MinArgs returns the number of arguments that the command requires. Only
+// the arguments up to the last required parameter can't be omitted.
+func (s *CommandSignature) MinArgs() int {
+	for i := len(s.Params) - 1; i >= 0; i-- {
+		if s.Params[i].Required {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// MaxArgs returns the number of arguments that the command accepts, or -1
+// if it accepts any number of arguments.
+func (s *CommandSignature) MaxArgs() int {
+	if len(s.Params) > 0 && s.Params[len(s.Params)-1].Vararg {
+		return -1
+	}
+	return len(s.Params)
+}
+
+// CommandSignatures maps command names to their signatures.
+type CommandSignatures map[string]*CommandSignature
+
+// LoadCommandSignatures reads the macro definitions of the given assembler
+// files, like asm/macros/event.inc. When a macro is defined more than once,
+// the first definition is used.
+func LoadCommandSignatures(filepaths []string) (CommandSignatures, error) {
+	signatures := make(CommandSignatures)
+	for _, path := range filepaths {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, signature := range ParseCommandSignatures(string(bytes), path) {
+			if _, ok := signatures[name]; !ok {
+				signatures[name] = signature
+			}
+		}
+	}
+	// Macros can pass their parameters to macros in other files.
+	signatures.resolveParamKinds()
+	return signatures, nil
+}
+
+// ParseCommandSignatures reads the ".macro" definitions of assembler source.
+// Everything else in the source is ignored.
+func ParseCommandSignatures(input string, filepath string) CommandSignatures {
+	signatures := make(CommandSignatures)
+	inBlockComment := false
+	// The macro whose body is being read, if any.
+	var current *CommandSignature
+	depth := 0
+	for i, line := range strings.Split(input, "\n") {
+		line, inBlockComment = stripAsmComments(line, inBlockComment)
+		fields := strings.Fields(strings.Replace(line, ",", " , ", 1))
+		if len(fields) == 0 {
+			continue
+		}
+		directive := strings.ToLower(fields[0])
+		if directive == ".endm" {
+			if depth > 0 {
+				depth--
+			}
+			if depth == 0 {
+				current = nil
+			}
+			continue
+		}
+		if directive == ".macro" {
+			// Nested macros are part of the outer macro's body.
+			depth++
+			if depth > 1 || len(fields) < 2 {
+				continue
+			}
+			name := fields[1]
+			if strings.Contains(name, `\`) {
+				// The name is built from the arguments of another macro.
+				continue
+			}
+			if _, ok := signatures[name]; ok {
+				continue
+			}
+			rest := strings.TrimSpace(line[strings.Index(line, name)+len(name):])
+			rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+			current = &CommandSignature{
+				Name:       name,
+				Params:     parseMacroParams(rest),
+				Filepath:   filepath,
+				LineNumber: i + 1,
+			}
+			signatures[name] = current
+			continue
+		}
+		if current != nil && depth == 1 {
+			current.addParamUses(strings.TrimSpace(line))
+		}
+	}
+	signatures.resolveParamKinds()
+	return signatures
+}
+
+// Records the uses of the macro's parameters in a line of its body, like
+// ".2byte \flag" or "goto_if TRUE, \dest".
+func (s *CommandSignature) addParamUses(line string) {
+	parts := strings.SplitN(strings.Replace(line, "\t", " ", -1), " ", 2)
+	if len(parts) < 2 {
+		return
+	}
+	directive := strings.ToLower(parts[0])
+	if strings.HasPrefix(directive, ".") {
+		kind, ok := paramKindDirectives[directive]
+		if !ok {
+			return
+		}
+		for _, operand := range strings.Split(parts[1], ",") {
+			if param := s.getParam(strings.TrimSpace(operand)); param != nil {
+				param.uses = append(param.uses, paramUse{kind: kind})
+			}
+		}
+		return
+	}
+	for j, operand := range strings.Split(parts[1], ",") {
+		if param := s.getParam(strings.TrimSpace(operand)); param != nil {
+			param.uses = append(param.uses, paramUse{macro: parts[0], index: j})
+		}
+	}
+}
+
+// Returns the parameter that is referenced by an operand, like "\flag".
+func (s *CommandSignature) getParam(operand string) *CommandParam {
+	if !strings.HasPrefix(operand, `\`) {
+		return nil
+	}
+	for i := range s.Params {
+		if s.Params[i].Name == operand[1:] {
+			return &s.Params[i]
+		}
+	}
+	return nil
+}
+
+// Learns the kinds of the parameters from their uses. A parameter that is
+// used as different kinds, or whose uses are unknown, accepts any value.
+func (signatures CommandSignatures) resolveParamKinds() {
+	for _, signature := range signatures {
+		for i := range signature.Params {
+			signature.Params[i].Kind = signatures.getParamKind(signature, i, map[*CommandParam]bool{})
+		}
+	}
+}
+
+func (signatures CommandSignatures) getParamKind(signature *CommandSignature, index int, visited map[*CommandParam]bool) ParamKind {
+	param := &signature.Params[index]
+	if visited[param] {
+		return ParamAny
+	}
+	// Only recursive uses on the current path are cut short.
+	visited[param] = true
+	defer delete(visited, param)
+	result := ParamAny
+	for _, use := range param.uses {
+		kind := use.kind
+		if use.macro != "" {
+			target, ok := signatures[use.macro]
+			if !ok || len(target.Params) == 0 {
+				return ParamAny
+			}
+			targetIndex := use.index
+			if targetIndex >= len(target.Params) {
+				if !target.Params[len(target.Params)-1].Vararg {
+					return ParamAny
+				}
+				targetIndex = len(target.Params) - 1
+			}
+			kind = signatures.getParamKind(target, targetIndex, visited)
+		}
+		if kind == ParamAny || (result != ParamAny && kind != result) {
+			return ParamAny
+		}
+		result = kind
+	}
+	return result
+}
+
+// Returns the line without its comments, and whether a block comment
+// continues onto the next line. "@" starts a comment in ARM assembly, and
+// "//" comments are removed by the C preprocessor.
+func stripAsmComments(line string, inBlockComment bool) (string, bool) {
+	var sb strings.Builder
+	inString := false
+	for i := 0; i < len(line); i++ {
+		if inBlockComment {
+			if strings.HasPrefix(line[i:], "*/") {
+				inBlockComment = false
+				i++
+			}
+			continue
+		}
+		c := line[i]
+		if c == '"' {
+			inString = !inString
+		} else if !inString {
+			if c == '@' || strings.HasPrefix(line[i:], "//") {
+				break
+			}
+			if strings.HasPrefix(line[i:], "/*") {
+				inBlockComment = true
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String(), inBlockComment
+}
+
+// Parses the parameter list of a ".macro" directive, like
+// "text:req, type=MSGBOX_DEFAULT". Parameters are separated by commas or
+// whitespace.
+func parseMacroParams(input string) []CommandParam {
+	params := []CommandParam{}
+	for _, field := range splitMacroParams(input) {
+		param := CommandParam{}
+		if i := strings.Index(field, "="); i != -1 {
+			param.Default = strings.TrimSpace(field[i+1:])
+			field = strings.TrimSpace(field[:i])
+		}
+		if i := strings.Index(field, ":"); i != -1 {
+			switch strings.ToLower(field[i+1:]) {
+			case "req":
+				param.Required = true
+			case "vararg":
+				param.Vararg = true
+			}
+			field = field[:i]
+		}
+		param.Name = field
+		params = append(params, param)
+	}
+	return params
+}
+
+// Splits a macro's parameter list into its parameters. A default value can
+// be surrounded by whitespace, and quoted default values can contain
+// whitespace and commas.
+func splitMacroParams(input string) []string {
+	fields := []string{}
+	var sb strings.Builder
+	inString := false
+	// Whether the current parameter is waiting for its default value.
+	awaitingDefault := false
+	flush := func() {
+		if sb.Len() > 0 {
+			fields = append(fields, sb.String())
+			sb.Reset()
+		}
+	}
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == '"':
+			inString = !inString
+			sb.WriteByte(c)
+			awaitingDefault = false
+		case inString:
+			sb.WriteByte(c)
+		case c == ',':
+			flush()
+			awaitingDefault = false
+		case c == ' ' || c == '\t':
+			if awaitingDefault {
+				continue
+			}
+			// Look ahead for a default value that is separated by whitespace.
+			rest := strings.TrimLeft(input[i:], " \t")
+			if strings.HasPrefix(rest, "=") {
+				continue
+			}
+			flush()
+		case c == '=':
+			sb.WriteByte(c)
+			awaitingDefault = true
+		default:
+			sb.WriteByte(c)
+			awaitingDefault = false
+		}
+	}
+	flush()
+	return fields
+}
+
+// String returns the command's usage, like "msgbox text, [type=MSGBOX_DEFAULT]".
+func (s *CommandSignature) String() string {
+	params := make([]string, len(s.Params))
+	for i, param := range s.Params {
+		switch {
+		case param.Vararg:
+			params[i] = fmt.Sprintf("%s...", param.Name)
+		case param.Default != "":
+			params[i] = fmt.Sprintf("[%s=%s]", param.Name, param.Default)
+		case !param.Required:
+			params[i] = fmt.Sprintf("[%s]", param.Name)
+		default:
+			params[i] = param.Name
+		}
+	}
+	if len(params) == 0 {
+		return s.Name
+	}
+	return fmt.Sprintf("%s %s", s.Name, strings.Join(params, ", "))
+}
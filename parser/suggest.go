@@ -0,0 +1,80 @@
+package parser
+
+import "fmt"
+
+// Keywords that can begin a top-level statement.
+var topLevelKeywords = []string{
+	"script", "raw", "text", "movement", "mart", "data", "alias",
+	"mapscripts", "const", "directive", "macro", "texttemplate", "import",
+}
+
+// Keywords that begin a statement, or a part of a statement, inside of a
+// script.
+var statementKeywords = []string{
+	"if", "elif", "else", "while", "do", "switch", "case", "default",
+	"break", "continue", "poryswitch",
+}
+
+// Returns a suggestion to use the most similar candidate instead of the given
+// word, which is appended to an error message. Returns an empty string if
+// none of the candidates are similar enough for the word to be a likely
+// misspelling.
+func didYouMean(word string, candidates []string) string {
+	suggestion, ok := getSuggestion(word, candidates)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(". Did you mean '%s'?", suggestion)
+}
+
+// Returns the candidate with the smallest edit distance to the word. Longer
+// words allow more edits.
+func getSuggestion(word string, candidates []string) (string, bool) {
+	maxDistance := (len([]rune(word)) + 2) / 3
+	best := ""
+	bestDistance := maxDistance + 1
+	for _, candidate := range candidates {
+		if candidate == word {
+			continue
+		}
+		if distance := getEditDistance(word, candidate); distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+	return best, best != ""
+}
+
+// Returns the number of single-character insertions, deletions,
+// substitutions, and adjacent transpositions needed to turn a into b.
+func getEditDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, minInt(d[i][j-1]+1, d[i-1][j-1]+cost))
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,504 @@
+package printer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/parser"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Tokens that are followed by a space when they come before an opening
+// parenthesis. Otherwise, the parenthesis belongs to a call, such as
+// flag(FLAG_1) or script(local).
+var spaceBeforeParenTokens = map[token.Type]bool{
+	token.IF:      true,
+	token.ELSEIF:  true,
+	token.WHILE:   true,
+	token.SWITCH:  true,
+	token.CASE:    true,
+	token.AND:     true,
+	token.OR:      true,
+	token.EQ:      true,
+	token.NEQ:     true,
+	token.LT:      true,
+	token.GT:      true,
+	token.LTE:     true,
+	token.GTE:     true,
+	token.ASSIGN:  true,
+	token.MUL:     true,
+	token.COMMA:   true,
+	token.COLON:   true,
+	token.ILLEGAL: true,
+}
+
+// Format prints a program as canonically formatted Poryscript source code.
+// Unlike Print, it keeps the source as it was written, including the
+// program's comments, so the given lexer must read the source that the
+// program was parsed from.
+//
+// The program decides the layout: every statement of a script begins on
+// its own line, and every block is indented. The parts of the source that
+// the program doesn't keep, like constants, macros, and the other top-level
+// statements, keep their line breaks. Comments are printed before the
+// token that follows them, or at the end of the line of the token that
+// precedes them.
+func Format(program *ast.Program, l *lexer.Lexer) (string, error) {
+	f := newSourcePrinter(program, l)
+	for _, stmt := range program.TopLevelStatements {
+		if err := f.printGap(stmt.Pos().Offset); err != nil {
+			return "", err
+		}
+		if !f.isNext(stmt, len(f.tokens)) {
+			continue
+		}
+		f.lineBreak = true
+		switch s := stmt.(type) {
+		case *ast.ScriptStatement:
+			if err := f.printBlock(s.Body.Statements); err != nil {
+				return "", err
+			}
+		case *ast.MapScriptsStatement:
+			f.addMapScriptBodies(s)
+		}
+		if err := f.printTokens(stmt.End().Offset, true); err != nil {
+			return "", err
+		}
+	}
+	eof := f.eofOffset()
+	if err := f.printGap(eof); err != nil {
+		return "", err
+	}
+	f.flushComments(eof)
+	if f.sb.Len() > 0 {
+		f.sb.WriteString("\n")
+	}
+	return f.sb.String(), nil
+}
+
+// Prints the tokens of a program's source, in order.
+type sourcePrinter struct {
+	tokens   []token.Token
+	comments []*ast.Comment
+	// The index of the bracket that closes each opening bracket.
+	closing map[int]int
+	// The statements of the blocks of map scripts, by the index of the
+	// block's opening brace.
+	scriptBodies map[int][]ast.Statement
+	sb           strings.Builder
+	next         int
+	nextComment  int
+	depth        int
+	parenDepth   int
+	// The source line on which the last token or comment that was printed
+	// ends.
+	lastLine int
+	// Whether the current line only has indentation, which isn't written
+	// until something is printed on it.
+	lineStart bool
+	// Whether the next token begins a new line.
+	lineBreak bool
+	// Whether the last token that was printed opened a block.
+	blockStart bool
+}
+
+func newSourcePrinter(program *ast.Program, l *lexer.Lexer) *sourcePrinter {
+	f := &sourcePrinter{
+		comments:     program.Comments,
+		closing:      make(map[int]int),
+		scriptBodies: make(map[int][]ast.Statement),
+	}
+	var opening []int
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+		switch tok.Type {
+		case token.LBRACE, token.LBRACKET, token.LPAREN:
+			opening = append(opening, len(f.tokens))
+		case token.RBRACE, token.RBRACKET, token.RPAREN:
+			if len(opening) > 0 {
+				f.closing[opening[len(opening)-1]] = len(f.tokens)
+				opening = opening[:len(opening)-1]
+			}
+		}
+		f.tokens = append(f.tokens, tok)
+	}
+	return f
+}
+
+// Returns the offset after the last token or comment.
+func (f *sourcePrinter) eofOffset() int {
+	offset := 0
+	if len(f.tokens) > 0 {
+		offset = f.tokens[len(f.tokens)-1].End.Offset
+	}
+	if len(f.comments) > 0 && f.comments[len(f.comments)-1].End().Offset > offset {
+		offset = f.comments[len(f.comments)-1].End().Offset
+	}
+	return offset + 1
+}
+
+// Reports whether the next token begins the given node, which must end
+// before the token at the given index. Statements that were expanded from
+// macros, or that share their tokens with other statements, like the
+// setvars of parameterized calls, begin elsewhere.
+func (f *sourcePrinter) isNext(node ast.Node, end int) bool {
+	if f.next >= end || f.tokens[f.next].Offset != node.Pos().Offset {
+		return false
+	}
+	return end == len(f.tokens) || node.End().Offset <= f.tokens[end].Offset
+}
+
+// Returns the index of the next token of the given type, or -1.
+func (f *sourcePrinter) findNext(tokenType token.Type) int {
+	for i := f.next; i < len(f.tokens); i++ {
+		if f.tokens[i].Type == tokenType {
+			return i
+		}
+	}
+	return -1
+}
+
+// Registers the blocks of the map scripts that are defined inline, so that
+// their statements are printed like the ones of scripts.
+func (f *sourcePrinter) addMapScriptBodies(s *ast.MapScriptsStatement) {
+	addBody := func(script *ast.ScriptStatement) {
+		if script == nil {
+			return
+		}
+		// The block's token is the first one inside its braces.
+		i := sort.Search(len(f.tokens), func(i int) bool {
+			return f.tokens[i].Offset >= script.Body.Token.Offset
+		})
+		if i > 0 && f.tokens[i-1].Type == token.LBRACE {
+			f.scriptBodies[i-1] = script.Body.Statements
+		}
+	}
+	for _, mapScript := range s.MapScripts {
+		addBody(mapScript.Script)
+	}
+	for _, tableMapScript := range s.TableMapScripts {
+		for _, entry := range tableMapScript.Entries {
+			addBody(entry.Script)
+		}
+	}
+}
+
+// Prints a statement of a block on its own line.
+func (f *sourcePrinter) printStatement(stmt ast.Statement) error {
+	f.lineBreak = true
+	var err error
+	switch s := stmt.(type) {
+	case *ast.IfStatement:
+		err = f.printBlock(s.Consequence.Body.Statements)
+		for _, elif := range s.ElifConsequences {
+			if err == nil {
+				err = f.printBlock(elif.Body.Statements)
+			}
+		}
+		if err == nil && s.ElseConsequence != nil {
+			err = f.printBlock(s.ElseConsequence.Statements)
+		}
+	case *ast.WhileStatement:
+		err = f.printBlock(s.Consequence.Body.Statements)
+	case *ast.DoWhileStatement:
+		err = f.printBlock(s.Consequence.Body.Statements)
+	case *ast.SwitchStatement:
+		err = f.printSwitch(s)
+	}
+	if err != nil {
+		return err
+	}
+	return f.printTokens(stmt.End().Offset, false)
+}
+
+// Prints the tokens that come before the next opening brace, like
+// "if (flag(FLAG_1))", and the block that it opens, with each of the
+// given statements on its own line.
+func (f *sourcePrinter) printBlock(statements []ast.Statement) error {
+	open := f.findNext(token.LBRACE)
+	close, ok := f.closing[open]
+	if !ok {
+		return fmt.Errorf("could not format block with missing curly brace")
+	}
+	if err := f.printTokens(f.tokens[open].Offset, false); err != nil {
+		return err
+	}
+	f.printToken(open, false)
+	f.depth++
+	if err := f.printStatements(statements, close, f.tokens[close].Offset); err != nil {
+		return err
+	}
+	f.flushComments(f.tokens[close].Offset)
+	f.depth--
+	f.printToken(close, true)
+	return nil
+}
+
+// Prints the statements that begin in the source before the token at the
+// given index, followed by the rest of the tokens before the given offset.
+func (f *sourcePrinter) printStatements(statements []ast.Statement, end int, until int) error {
+	for _, stmt := range statements {
+		if err := f.printGap(stmt.Pos().Offset); err != nil {
+			return err
+		}
+		if !f.isNext(stmt, end) {
+			continue
+		}
+		if err := f.printStatement(stmt); err != nil {
+			return err
+		}
+	}
+	return f.printGap(until)
+}
+
+// Prints a switch statement, with its cases on their own lines, and the
+// statements of each case indented below it.
+func (f *sourcePrinter) printSwitch(s *ast.SwitchStatement) error {
+	open := f.findNext(token.LBRACE)
+	close, ok := f.closing[open]
+	if !ok {
+		return fmt.Errorf("could not format switch statement with missing curly brace")
+	}
+	if err := f.printTokens(f.tokens[open].Offset, false); err != nil {
+		return err
+	}
+	f.printToken(open, false)
+	f.depth++
+	for i, switchCase := range s.Cases {
+		if err := f.printGap(switchCase.Pos().Offset); err != nil {
+			return err
+		}
+		colon := f.findNext(token.COLON)
+		if !f.isNext(switchCase, close) || colon == -1 || colon > close {
+			continue
+		}
+		f.lineBreak = true
+		if err := f.printTokens(f.tokens[colon].Offset+1, false); err != nil {
+			return err
+		}
+		caseEnd := f.tokens[close].Offset
+		if i+1 < len(s.Cases) && s.Cases[i+1].Pos().Offset < caseEnd {
+			caseEnd = s.Cases[i+1].Pos().Offset
+		}
+		f.depth++
+		if err := f.printStatements(switchCase.Body.Statements, close, caseEnd); err != nil {
+			return err
+		}
+		f.flushComments(caseEnd)
+		f.depth--
+	}
+	if err := f.printGap(f.tokens[close].Offset); err != nil {
+		return err
+	}
+	f.flushComments(f.tokens[close].Offset)
+	f.depth--
+	f.printToken(close, true)
+	return nil
+}
+
+// Prints the tokens before the given offset that aren't part of any
+// statement, like the uses of macros, on their own lines.
+func (f *sourcePrinter) printGap(until int) error {
+	if f.next < len(f.tokens) && f.tokens[f.next].Offset < until {
+		f.lineBreak = true
+	}
+	return f.printTokens(until, true)
+}
+
+// Prints the tokens before the given offset. Brackets are always printed
+// along with the brackets that close them, and curly braces and square
+// brackets open indented blocks. Unless keepLineBreaks is set, the tokens
+// are printed on the current line, except for the line breaks inside
+// parentheses and blocks.
+//
+// The tokens that keep their line breaks weren't necessarily parsed as
+// statements, like the bodies of macros, so their brackets are limited to
+// the parser's default nesting depth. Otherwise, their indentation could
+// grow quadratically with the depth.
+func (f *sourcePrinter) printTokens(until int, keepLineBreaks bool) error {
+	// The blocks that were opened, and whether each of them is the body of
+	// a do...while statement.
+	var doBlocks []bool
+	startParenDepth := f.parenDepth
+	prevOpen := false
+	prevClose := false
+	closedDo := false
+	for f.next < len(f.tokens) && (f.tokens[f.next].Offset < until || len(doBlocks) > 0 || f.parenDepth > startParenDepth) {
+		i := f.next
+		tok := f.tokens[i]
+		if keepLineBreaks && isOpeningBracket(tok) && len(doBlocks)+f.parenDepth-startParenDepth >= parser.DefaultNestingLimit {
+			return &parser.ParseError{
+				LineNumber: tok.LineNumber,
+				Column:     tok.Column,
+				Code:       parser.ErrorSyntax,
+				Message:    fmt.Sprintf("maximum nesting depth of %d exceeded", parser.DefaultNestingLimit),
+			}
+		}
+		switch {
+		case tok.Type == token.LBRACE || tok.Type == token.LBRACKET:
+			if statements, ok := f.scriptBodies[i]; ok {
+				if err := f.printBlock(statements); err != nil {
+					return err
+				}
+				prevOpen, prevClose, closedDo = false, true, false
+				continue
+			}
+			doBlocks = append(doBlocks, i > 0 && f.tokens[i-1].Type == token.DO)
+			f.printToken(i, false)
+			f.depth++
+			prevOpen, prevClose = true, false
+			continue
+		case (tok.Type == token.RBRACE || tok.Type == token.RBRACKET) && len(doBlocks) > 0:
+			f.flushComments(tok.Offset)
+			closedDo = doBlocks[len(doBlocks)-1]
+			doBlocks = doBlocks[:len(doBlocks)-1]
+			f.depth--
+			f.printToken(i, true)
+			prevOpen, prevClose = false, true
+			continue
+		}
+
+		lineBreak := false
+		switch {
+		case prevOpen:
+			lineBreak = true
+		case prevClose:
+			lineBreak = tok.Type != token.ELSE && tok.Type != token.ELSEIF && !(tok.Type == token.WHILE && closedDo)
+		case keepLineBreaks || len(doBlocks) > 0 || f.parenDepth > 0:
+			lineBreak = tok.LineNumber > f.lastLine
+		}
+		f.printToken(i, lineBreak)
+		prevOpen, prevClose = false, false
+	}
+	return nil
+}
+
+// Prints the comments that come before the given offset.
+func (f *sourcePrinter) flushComments(offset int) {
+	for f.nextComment < len(f.comments) && f.comments[f.nextComment].Pos().Offset < offset {
+		comment := f.comments[f.nextComment]
+		f.nextComment++
+		if f.sb.Len() > 0 && !f.lineStart && comment.Pos().Line == f.lastLine {
+			f.sb.WriteString(" ")
+		} else {
+			f.startLine(comment.Pos().Line, true)
+			f.writeIndent(f.depth + f.parenDepth)
+		}
+		f.sb.WriteString(comment.TokenLiteral())
+		f.lastLine = comment.Pos().Line
+		f.lineBreak = true
+		f.blockStart = false
+	}
+}
+
+// Begins a new line for something on the given source line. A blank line
+// is kept before it if there was one in the source, unless it follows the
+// beginning of a block.
+func (f *sourcePrinter) startLine(line int, allowBlank bool) {
+	if f.sb.Len() == 0 || f.lineStart {
+		return
+	}
+	f.sb.WriteString("\n")
+	if allowBlank && !f.blockStart && line > f.lastLine+1 {
+		f.sb.WriteString("\n")
+	}
+	f.lineStart = true
+}
+
+func (f *sourcePrinter) writeIndent(indent int) {
+	if f.lineStart {
+		f.sb.WriteString(strings.Repeat(indentation, indent))
+		f.lineStart = false
+	}
+}
+
+// Prints the token at the given index, on a new line if lineBreak is set.
+func (f *sourcePrinter) printToken(i int, lineBreak bool) {
+	tok := f.tokens[i]
+	f.flushComments(tok.Offset)
+	isClose := tok.Type == token.RBRACE || tok.Type == token.RBRACKET
+	if lineBreak || f.lineBreak {
+		f.startLine(tok.LineNumber, !isClose)
+	} else if f.sb.Len() > 0 && !f.lineStart && i > 0 && isSpace(f.tokens[i-1], tok) {
+		f.sb.WriteString(" ")
+	}
+	f.lineBreak = false
+
+	if tok.Type == token.RPAREN && f.parenDepth > 0 {
+		f.parenDepth--
+	}
+	isLineStart := f.lineStart
+	indent := f.depth + f.parenDepth
+	f.writeIndent(indent)
+	switch tok.Type {
+	case token.STRING:
+		// Adjacent strings are joined with newlines by the lexer, so each
+		// line is printed as its own string.
+		if !isLineStart && f.parenDepth == 0 {
+			indent++
+		}
+		for j, line := range strings.Split(tok.Literal, "\n") {
+			if j > 0 {
+				f.sb.WriteString("\n")
+				f.sb.WriteString(strings.Repeat(indentation, indent))
+			}
+			f.sb.WriteString("\"")
+			f.sb.WriteString(line)
+			f.sb.WriteString("\"")
+		}
+	case token.RAWSTRING:
+		fence := lexer.RawFence(tok.Literal)
+		// Fenced raw strings are always printed on their own lines, so
+		// that backticks at their ends don't run into the fence.
+		if isMultilineRaw(tok.Literal) || fence != "`" {
+			f.sb.WriteString(fence + "\n")
+			f.sb.WriteString(tok.Literal)
+			f.sb.WriteString("\n" + fence)
+		} else {
+			f.sb.WriteString("`")
+			f.sb.WriteString(tok.Literal)
+			f.sb.WriteString("`")
+		}
+	default:
+		f.sb.WriteString(tok.Literal)
+	}
+	if tok.Type == token.LPAREN {
+		f.parenDepth++
+	}
+	f.lastLine = tok.End.Line
+	f.blockStart = tok.Type == token.LBRACE || tok.Type == token.LBRACKET
+	f.next = i + 1
+}
+
+func isOpeningBracket(tok token.Token) bool {
+	return tok.Type == token.LBRACE || tok.Type == token.LBRACKET || tok.Type == token.LPAREN
+}
+
+func isMultilineRaw(literal string) bool {
+	return strings.Contains(literal, "\n") || strings.HasPrefix(literal, "\t") || strings.HasPrefix(literal, " ")
+}
+
+// Reports whether a space is printed between two tokens on the same line.
+func isSpace(prev token.Token, tok token.Token) bool {
+	switch tok.Type {
+	case token.RPAREN, token.COMMA, token.COLON:
+		return false
+	case token.LPAREN:
+		return spaceBeforeParenTokens[prev.Type]
+	case token.STRING:
+		if prev.Type == token.STRINGTYPE {
+			return false
+		}
+	}
+	switch prev.Type {
+	case token.LPAREN, token.NOT, token.AT:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,341 @@
+package printer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/huderlem/poryscript/ast"
+	"github.com/huderlem/poryscript/emitter"
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/parser"
+	"github.com/huderlem/poryscript/token"
+)
+
+const indentation = "    "
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var scopeKeywords = map[token.Type]string{
+	token.GLOBAL: "global",
+	token.LOCAL:  "local",
+}
+
+var conditionOperatorKeywords = map[token.Type]string{
+	token.VAR:      "var",
+	token.FLAG:     "flag",
+	token.DEFEATED: "defeated",
+}
+
+// Printer prints an AST as Poryscript source code.
+type Printer struct {
+	sb    strings.Builder
+	depth int
+}
+
+// Print returns Poryscript source code for the given program. Parsing
+// the source code produces a program that is semantically identical to
+// the given program. Implicit texts, such as msgbox("Hello"), are printed
+// as local text statements.
+func Print(program *ast.Program) (string, error) {
+	p := &Printer{}
+	for _, stmt := range program.TopLevelStatements {
+		if _, ok := stmt.(*ast.TextStatement); ok {
+			// Texts are printed from the program's list of texts, which
+			// also includes the implicit texts.
+			continue
+		}
+		if err := p.printTopLevelStatement(stmt); err != nil {
+			return "", err
+		}
+		p.sb.WriteString("\n")
+	}
+	for _, text := range program.Texts {
+		p.printAnnotations(text.Annotations)
+		var scope token.Type = token.LOCAL
+		if text.IsGlobal {
+			scope = token.GLOBAL
+		}
+		p.sb.WriteString(fmt.Sprintf("text(%s) %s {\n", scopeKeywords[scope], text.Name))
+		p.depth++
+		p.writeIndent()
+		p.printString(text.Value, text.StringType)
+		p.sb.WriteString("\n")
+		p.depth--
+		p.sb.WriteString("}\n\n")
+	}
+	return strings.TrimRight(p.sb.String(), "\n") + "\n", nil
+}
+
+// CheckRoundTrip verifies that printing the program and parsing the result
+// produces a semantically identical program, which compiles to the same
+// output. It's intended to be used by tests that build or transform ASTs.
+func CheckRoundTrip(program *ast.Program) error {
+	source, err := Print(program)
+	if err != nil {
+		return err
+	}
+	reparsed, err := parser.New(lexer.New(source), "", nil).ParseProgram()
+	if err != nil {
+		return fmt.Errorf("failed to parse printed program: %s", err.Error())
+	}
+	for _, optimize := range []bool{false, true} {
+		expected, err := emitter.New(program, optimize).Emit()
+		if err != nil {
+			return err
+		}
+		actual, err := emitter.New(reparsed, optimize).Emit()
+		if err != nil {
+			return fmt.Errorf("failed to emit printed program: %s", err.Error())
+		}
+		if expected != actual {
+			return fmt.Errorf("printed program is not semantically identical to the original. Expected=%q, Got=%q", expected, actual)
+		}
+	}
+	return nil
+}
+
+func (p *Printer) writeIndent() {
+	p.sb.WriteString(strings.Repeat(indentation, p.depth))
+}
+
+func (p *Printer) printTopLevelStatement(stmt ast.Statement) error {
+	p.printAnnotations(ast.AnnotationsOf(stmt))
+	switch s := stmt.(type) {
+	case *ast.ScriptStatement:
+		p.sb.WriteString(fmt.Sprintf("script(%s) %s", scopeKeywords[s.Scope], s.Name.Value))
+		if len(s.Params) > 0 {
+			params := make([]string, len(s.Params))
+			for i, param := range s.Params {
+				params[i] = fmt.Sprintf("%s = %s", param.Name, param.Var)
+			}
+			p.sb.WriteString(fmt.Sprintf("(%s)", strings.Join(params, ", ")))
+		}
+		p.sb.WriteString(" ")
+		return p.printBlock(s.Body.Statements)
+	case *ast.RawStatement:
+		p.sb.WriteString("raw ")
+		p.printRaw(s.Value)
+		p.sb.WriteString("\n")
+	case *ast.DirectiveStatement:
+		p.sb.WriteString("directive ")
+		if strings.Contains(s.Value, "`") {
+			p.printString(s.Value, "")
+		} else {
+			p.printRaw(s.Value)
+		}
+		p.sb.WriteString("\n")
+	case *ast.MovementStatement:
+		p.sb.WriteString(fmt.Sprintf("movement(%s) %s {\n", scopeKeywords[s.Scope], s.Name.Value))
+		p.printLines(s.MovementCommands)
+		p.sb.WriteString("}\n")
+	case *ast.MartStatement:
+		p.sb.WriteString(fmt.Sprintf("mart(%s) %s {\n", scopeKeywords[s.Scope], s.Name.Value))
+		p.printLines(s.MartItems)
+		p.sb.WriteString("}\n")
+	case *ast.DataStatement:
+		p.sb.WriteString(fmt.Sprintf("data(%s) %s {\n", scopeKeywords[s.Scope], s.Name.Value))
+		rows := make([]string, len(s.Rows))
+		for i, row := range s.Rows {
+			rows[i] = fmt.Sprintf("%s: %s", dataTypes[row.Size], strings.Join(row.Values, ", "))
+		}
+		p.printLines(rows)
+		p.sb.WriteString("}\n")
+	case *ast.AliasStatement:
+		p.sb.WriteString(fmt.Sprintf("alias(%s) %s = %s\n", scopeKeywords[s.Scope], s.Name.Value, s.Target.Value))
+	case *ast.MapScriptsStatement:
+		return p.printMapScriptsStatement(s)
+	default:
+		return fmt.Errorf("could not print unrecognized top-level statement '%s'", stmt.TokenLiteral())
+	}
+	return nil
+}
+
+// The types of the rows of data statements, by the size of their values.
+var dataTypes = map[int]string{
+	1: ".byte",
+	2: ".2byte",
+	4: ".4byte",
+}
+
+func (p *Printer) printAnnotations(annotations ast.Annotations) {
+	for _, annotation := range annotations {
+		p.sb.WriteString("@")
+		p.sb.WriteString(annotation.Name)
+		if len(annotation.Args) > 0 {
+			args := make([]string, len(annotation.Args))
+			for i, arg := range annotation.Args {
+				if _, err := strconv.ParseInt(arg, 0, 64); err == nil || identifierRegex.MatchString(arg) {
+					args[i] = arg
+				} else {
+					args[i] = fmt.Sprintf("\"%s\"", arg)
+				}
+			}
+			p.sb.WriteString(fmt.Sprintf("(%s)", strings.Join(args, ", ")))
+		}
+		p.sb.WriteString("\n")
+	}
+}
+
+func (p *Printer) printLines(lines []string) {
+	p.depth++
+	for _, line := range lines {
+		p.writeIndent()
+		p.sb.WriteString(line)
+		p.sb.WriteString("\n")
+	}
+	p.depth--
+}
+
+// Prints a string value. The lexer joins adjacent strings with newlines,
+// so each line of the value is printed as its own string.
+func (p *Printer) printString(value string, stringType string) {
+	p.sb.WriteString(stringType)
+	for i, line := range strings.Split(value, "\n") {
+		if i > 0 {
+			p.sb.WriteString("\n")
+			p.writeIndent()
+		}
+		p.sb.WriteString(fmt.Sprintf("\"%s\"", line))
+	}
+}
+
+func (p *Printer) printRaw(value string) {
+	fence := lexer.RawFence(value)
+	p.sb.WriteString(fence + "\n")
+	p.sb.WriteString(value)
+	p.sb.WriteString("\n" + fence)
+}
+
+func (p *Printer) printMapScriptsStatement(s *ast.MapScriptsStatement) error {
+	p.sb.WriteString(fmt.Sprintf("mapscripts(%s) %s {\n", scopeKeywords[s.Scope], s.Name.Value))
+	p.depth++
+	for _, mapScript := range s.MapScripts {
+		p.writeIndent()
+		if mapScript.Script == nil {
+			p.sb.WriteString(fmt.Sprintf("%s: %s\n", mapScript.Type, mapScript.Name))
+			continue
+		}
+		p.sb.WriteString(fmt.Sprintf("%s ", mapScript.Type))
+		if err := p.printBlock(mapScript.Script.Body.Statements); err != nil {
+			return err
+		}
+	}
+	for _, tableMapScript := range s.TableMapScripts {
+		p.writeIndent()
+		p.sb.WriteString(fmt.Sprintf("%s [\n", tableMapScript.Type))
+		p.depth++
+		for _, entry := range tableMapScript.Entries {
+			p.writeIndent()
+			if entry.Script == nil {
+				p.sb.WriteString(fmt.Sprintf("%s, %s: %s\n", entry.Condition, entry.Comparison, entry.Name))
+				continue
+			}
+			p.sb.WriteString(fmt.Sprintf("%s, %s ", entry.Condition, entry.Comparison))
+			if err := p.printBlock(entry.Script.Body.Statements); err != nil {
+				return err
+			}
+		}
+		p.depth--
+		p.writeIndent()
+		p.sb.WriteString("]\n")
+	}
+	p.depth--
+	p.sb.WriteString("}\n")
+	return nil
+}
+
+// Prints a block of statements, including its curly braces.
+func (p *Printer) printBlock(statements []ast.Statement) error {
+	p.sb.WriteString("{\n")
+	p.depth++
+	for _, stmt := range statements {
+		p.writeIndent()
+		if err := p.printStatement(stmt); err != nil {
+			return err
+		}
+		p.sb.WriteString("\n")
+	}
+	p.depth--
+	p.writeIndent()
+	p.sb.WriteString("}")
+	if p.depth == 0 {
+		p.sb.WriteString("\n")
+	}
+	return nil
+}
+
+func (p *Printer) printStatement(stmt ast.Statement) error {
+	switch s := stmt.(type) {
+	case *ast.CommandStatement:
+		p.sb.WriteString(s.Name.Value)
+		if len(s.Args) > 0 {
+			p.sb.WriteString(fmt.Sprintf("(%s)", strings.Join(s.Args, ", ")))
+		}
+	case *ast.IfStatement:
+		p.sb.WriteString(fmt.Sprintf("if (%s) ", printBooleanExpression(s.Consequence.Expression)))
+		if err := p.printBlock(s.Consequence.Body.Statements); err != nil {
+			return err
+		}
+		for _, elif := range s.ElifConsequences {
+			p.sb.WriteString(fmt.Sprintf(" elif (%s) ", printBooleanExpression(elif.Expression)))
+			if err := p.printBlock(elif.Body.Statements); err != nil {
+				return err
+			}
+		}
+		if s.ElseConsequence != nil {
+			p.sb.WriteString(" else ")
+			return p.printBlock(s.ElseConsequence.Statements)
+		}
+	case *ast.WhileStatement:
+		p.sb.WriteString(fmt.Sprintf("while (%s) ", printBooleanExpression(s.Consequence.Expression)))
+		return p.printBlock(s.Consequence.Body.Statements)
+	case *ast.DoWhileStatement:
+		p.sb.WriteString("do ")
+		if err := p.printBlock(s.Consequence.Body.Statements); err != nil {
+			return err
+		}
+		p.sb.WriteString(fmt.Sprintf(" while (%s)", printBooleanExpression(s.Consequence.Expression)))
+	case *ast.BreakStatement:
+		p.sb.WriteString("break")
+	case *ast.ContinueStatement:
+		p.sb.WriteString("continue")
+	case *ast.SwitchStatement:
+		p.sb.WriteString(fmt.Sprintf("switch (var(%s)) {\n", s.Operand))
+		p.depth++
+		for _, switchCase := range s.Cases {
+			p.writeIndent()
+			if switchCase.IsDefault {
+				p.sb.WriteString("default:\n")
+			} else {
+				p.sb.WriteString(fmt.Sprintf("case %s:\n", switchCase.Value))
+			}
+			p.depth++
+			for _, caseStmt := range switchCase.Body.Statements {
+				p.writeIndent()
+				if err := p.printStatement(caseStmt); err != nil {
+					return err
+				}
+				p.sb.WriteString("\n")
+			}
+			p.depth--
+		}
+		p.depth--
+		p.writeIndent()
+		p.sb.WriteString("}")
+	default:
+		return fmt.Errorf("could not print unrecognized statement '%s'", stmt.TokenLiteral())
+	}
+	return nil
+}
+
+func printBooleanExpression(expression ast.BooleanExpression) string {
+	switch e := expression.(type) {
+	case *ast.BinaryExpression:
+		return fmt.Sprintf("(%s) %s (%s)", printBooleanExpression(e.Left), e.Operator, printBooleanExpression(e.Right))
+	case *ast.OperatorExpression:
+		return fmt.Sprintf("%s(%s) %s %s", conditionOperatorKeywords[e.Type], e.Operand, e.Operator, e.ComparisonValue)
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package printer
+
+import (
+	"testing"
+
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/parser"
+)
+
+func TestPrint(t *testing.T) {
+	input := `
+const MAX = 3
+@deprecated("use Other")
+script(local) MyScript(item, count = VAR_TEMP_1) {
+	lock
+	msgbox("Hello\n"
+	"World", MSGBOX_DEFAULT)
+	if (!flag(FLAG_1) && var(VAR_1) < MAX) {
+		giveitem(item, count)
+	} elif (defeated(TRAINER_1)) {
+		release
+	}
+}
+movement MyMovement { walk_left * 2 }
+`
+	expected := `@deprecated("use Other")
+script(local) MyScript(item = VAR_0x8000, count = VAR_TEMP_1) {
+    lock
+    msgbox(MyScript_Text_0, MSGBOX_DEFAULT)
+    if ((flag(FLAG_1) == FALSE) && (var(VAR_1) < 3)) {
+        giveitem(VAR_0x8000, VAR_TEMP_1)
+    } elif (defeated(TRAINER_1) == TRUE) {
+        release
+    }
+}
+
+movement(local) MyMovement {
+    walk_left
+    walk_left
+}
+
+text(local) MyScript_Text_0 {
+    "Hello\n"
+    "World$"
+}
+`
+	program, err := parser.New(lexer.New(input), "../font_widths.json", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	output, err := Print(program)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if output != expected {
+		t.Errorf("Mismatching print -- Expected=%q, Got=%q", expected, output)
+	}
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	input := `
+script MyScript {
+	lock
+	faceplayer
+	while (var(VAR_1) < 5) {
+		if (flag(FLAG_1) || !(var(VAR_2) == 1 && flag(FLAG_2))) {
+			continue
+		}
+		switch (var(VAR_3)) {
+			case 0:
+				msgbox(ascii"Zero")
+			case 1:
+			case 2:
+				break
+			default:
+				goto(OtherScript)
+		}
+		addvar(VAR_1, 1)
+	}
+	do {
+		msgbox(format("This is a long text that will be automatically formatted."))
+	} while (!defeated(TRAINER_1))
+	call Helper(ITEM_POTION)
+	release
+	end
+}
+
+script Helper(item) {
+	giveitem(item)
+}
+
+@align(4)
+text(local) MyText {
+	"Explicit text"
+}
+
+raw ` + "`" + `
+	.byte 1
+` + "`" + `
+
+raw ` + "```" + `
+	.string "` + "`" + `quoted` + "`" + `$"
+` + "```" + `
+
+directive ` + "`" + `.include "constants/gba.inc"` + "`" + `
+
+mart MyMart {
+	ITEM_POTION
+	ITEM_ANTIDOTE
+}
+
+data(global) MyData {
+	.byte: 1, 0x2
+	.4byte: MyMart
+}
+
+@deprecated
+alias(local) OldMart = MyMart
+
+mapscripts MyMapScripts {
+	MAP_SCRIPT_ON_LOAD: MyScript
+	MAP_SCRIPT_ON_TRANSITION {
+		setweather(WEATHER_ASH)
+	}
+	MAP_SCRIPT_ON_FRAME_TABLE [
+		VAR_TEMP_0, 0: MyScript
+		VAR_TEMP_0, 1 {
+			msgbox("Inline")
+			setvar(VAR_TEMP_0, 2)
+		}
+	]
+}
+`
+	program, err := parser.New(lexer.New(input), "../font_widths.json", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := CheckRoundTrip(program); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestFormat(t *testing.T) {
+	input := `# Greets the player.
+script MyScript { lock faceplayer # Look at the player
+	if (flag(FLAG_1)) { msgbox("Hello") }
+	else { msgbox("Bye")
+	# Done
+	}
+
+
+	release end
+}
+`
+	expected := `# Greets the player.
+script MyScript {
+    lock
+    faceplayer # Look at the player
+    if (flag(FLAG_1)) {
+        msgbox("Hello")
+    } else {
+        msgbox("Bye")
+        # Done
+    }
+
+    release
+    end
+}
+`
+	program, err := parser.New(lexer.NewWithMode(input, lexer.ScanComments), "", nil).ParseProgram()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	output, err := Format(program, lexer.New(input))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if output != expected {
+		t.Errorf("Mismatching format -- Expected=%q, Got=%q", expected, output)
+	}
+}
@@ -0,0 +1,144 @@
+package sarif
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/huderlem/poryscript/parser"
+)
+
+const (
+	schemaURI      = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion   = "2.1.0"
+	toolName       = "poryscript"
+	informationURI = "https://github.com/huderlem/poryscript"
+)
+
+// Log is the top-level object of a SARIF file.
+type Log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run is a single invocation of an analysis tool.
+type Run struct {
+	Tool    Tool     `json:"tool"`
+	Results []Result `json:"results"`
+}
+
+// Tool describes the analysis tool that produced the results.
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+// Driver describes the main component of the analysis tool.
+type Driver struct {
+	Name           string `json:"name"`
+	Version        string `json:"version"`
+	InformationURI string `json:"informationUri"`
+	Rules          []Rule `json:"rules"`
+}
+
+// Rule describes a rule that produced results.
+type Rule struct {
+	ID string `json:"id"`
+}
+
+// Result is a single finding.
+type Result struct {
+	RuleID    string     `json:"ruleId"`
+	Level     string     `json:"level"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+}
+
+// Message is the text of a result.
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Location is where a result was found.
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+// PhysicalLocation is a region of a file.
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+// ArtifactLocation is the location of a file.
+type ArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// Region is a range of lines in a file.
+type Region struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// GetRuleID returns the SARIF rule id of a diagnostic. Lint diagnostics use
+// the name of their lint rule, and other diagnostics use their category.
+func GetRuleID(diagnostic parser.Diagnostic) string {
+	if diagnostic.Rule != "" {
+		return diagnostic.Rule
+	}
+	return diagnostic.Category
+}
+
+// New creates a SARIF log for the given diagnostics.
+func New(diagnostics []parser.Diagnostic, toolVersion string) Log {
+	results := make([]Result, 0, len(diagnostics))
+	ruleIDs := make(map[string]bool)
+	for _, diagnostic := range diagnostics {
+		ruleID := GetRuleID(diagnostic)
+		ruleIDs[ruleID] = true
+		result := Result{
+			RuleID:  ruleID,
+			Level:   diagnostic.Severity.String(),
+			Message: Message{Text: diagnostic.Message},
+		}
+		if diagnostic.Filepath != "" {
+			location := Location{
+				PhysicalLocation: PhysicalLocation{
+					ArtifactLocation: ArtifactLocation{URI: diagnostic.Filepath},
+				},
+			}
+			if diagnostic.LineNumber > 0 {
+				location.PhysicalLocation.Region = &Region{StartLine: diagnostic.LineNumber, StartColumn: diagnostic.Column}
+			}
+			result.Locations = []Location{location}
+		}
+		results = append(results, result)
+	}
+
+	rules := make([]Rule, 0, len(ruleIDs))
+	for ruleID := range ruleIDs {
+		rules = append(rules, Rule{ID: ruleID})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	return Log{
+		Schema:  schemaURI,
+		Version: sarifVersion,
+		Runs: []Run{{
+			Tool: Tool{
+				Driver: Driver{
+					Name:           toolName,
+					Version:        toolVersion,
+					InformationURI: informationURI,
+					Rules:          rules,
+				},
+			},
+			Results: results,
+		}},
+	}
+}
+
+// Marshal returns the indented JSON encoding of the SARIF log.
+func (log Log) Marshal() ([]byte, error) {
+	return json.MarshalIndent(log, "", "  ")
+}
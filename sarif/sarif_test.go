@@ -0,0 +1,90 @@
+package sarif
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/huderlem/poryscript/parser"
+)
+
+func TestNew(t *testing.T) {
+	diagnostics := []parser.Diagnostic{
+		{Severity: parser.SeverityWarning, Category: parser.WarningUnused, Filepath: "data/maps/Route1/scripts.pory", LineNumber: 4, Column: 6, Message: "local text 'Foo' is never referenced"},
+		{Severity: parser.SeverityError, Category: parser.WarningLint, Rule: "scriptNaming", Filepath: "data/maps/Route1/scripts.pory", LineNumber: 10, Message: "[scriptNaming] script 'bad' does not match"},
+		{Severity: parser.SeverityWarning, Category: parser.WarningDeprecated, Message: "no location"},
+	}
+	log := New(diagnostics, "1.0.0")
+	if log.Version != "2.1.0" {
+		t.Fatalf("Incorrect SARIF version. Expected '2.1.0', got '%s'", log.Version)
+	}
+	if len(log.Runs) != 1 {
+		t.Fatalf("Expected 1 run, got %d", len(log.Runs))
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "poryscript" || run.Tool.Driver.Version != "1.0.0" {
+		t.Errorf("Incorrect tool driver: %+v", run.Tool.Driver)
+	}
+	expectedRules := []string{"deprecated", "scriptNaming", "unused"}
+	if len(run.Tool.Driver.Rules) != len(expectedRules) {
+		t.Fatalf("Expected %d rules, got %d", len(expectedRules), len(run.Tool.Driver.Rules))
+	}
+	for i, rule := range run.Tool.Driver.Rules {
+		if rule.ID != expectedRules[i] {
+			t.Errorf("Incorrect rule %d. Expected '%s', got '%s'", i, expectedRules[i], rule.ID)
+		}
+	}
+
+	expected := []struct {
+		ruleID string
+		level  string
+		uri    string
+		line   int
+		column int
+	}{
+		{"unused", "warning", "data/maps/Route1/scripts.pory", 4, 6},
+		{"scriptNaming", "error", "data/maps/Route1/scripts.pory", 10, 0},
+		{"deprecated", "warning", "", 0, 0},
+	}
+	if len(run.Results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(run.Results))
+	}
+	for i, result := range run.Results {
+		if result.RuleID != expected[i].ruleID {
+			t.Errorf("Incorrect ruleId for result %d. Expected '%s', got '%s'", i, expected[i].ruleID, result.RuleID)
+		}
+		if result.Level != expected[i].level {
+			t.Errorf("Incorrect level for result %d. Expected '%s', got '%s'", i, expected[i].level, result.Level)
+		}
+		if result.Message.Text != diagnostics[i].Message {
+			t.Errorf("Incorrect message for result %d. Expected '%s', got '%s'", i, diagnostics[i].Message, result.Message.Text)
+		}
+		if expected[i].uri == "" {
+			if len(result.Locations) != 0 {
+				t.Errorf("Expected no locations for result %d, got %d", i, len(result.Locations))
+			}
+			continue
+		}
+		if len(result.Locations) != 1 {
+			t.Fatalf("Expected 1 location for result %d, got %d", i, len(result.Locations))
+		}
+		location := result.Locations[0].PhysicalLocation
+		if location.ArtifactLocation.URI != expected[i].uri {
+			t.Errorf("Incorrect uri for result %d. Expected '%s', got '%s'", i, expected[i].uri, location.ArtifactLocation.URI)
+		}
+		if location.Region == nil || location.Region.StartLine != expected[i].line || location.Region.StartColumn != expected[i].column {
+			t.Errorf("Incorrect region for result %d. Expected line %d, column %d, got %+v", i, expected[i].line, expected[i].column, location.Region)
+		}
+	}
+
+	bytes, err := log.Marshal()
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling SARIF log: %s", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(bytes, &decoded); err != nil {
+		t.Fatalf("Marshalled SARIF log is not valid JSON: %s", err)
+	}
+	if decoded["$schema"] != "https://json.schemastore.org/sarif-2.1.0.json" {
+		t.Errorf("Incorrect $schema: %v", decoded["$schema"])
+	}
+}
@@ -0,0 +1,113 @@
+package symbols
+
+import (
+	"fmt"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+// ExtractTextEdits returns the edits that move the inline texts of the
+// given scripts of a file, like the string of 'msgbox("Hello")', into
+// their own local text statements, which are placed after each script. The
+// texts are named like the labels that the compiler generates for inline
+// texts, like "MyScript_Text_0", unless a symbol with the same name is
+// already defined. Each script's identical texts share a text statement.
+// Returns the number of text statements, too.
+func (idx *Index) ExtractTextEdits(filepath string, input string, scriptNames map[string]bool) ([]Edit, int) {
+	f, ok := idx.files[filepath]
+	if !ok {
+		return nil, 0
+	}
+	edits := []Edit{}
+	count := 0
+	names := make(map[string]bool)
+	for _, d := range f.Definitions {
+		if d.Kind != KindScript || !scriptNames[d.Name] {
+			continue
+		}
+		labels := make(map[string]string)
+		var texts []string
+		n := 0
+		for i := 0; i < len(f.Tokens); i++ {
+			tok := f.Tokens[i]
+			if tok.Span.Start.Before(d.Span.Start) || !tok.Span.End.Before(d.Span.End) {
+				continue
+			}
+			if !idx.isTextArgStart(f, i) {
+				continue
+			}
+			end := findArgEnd(f.Tokens, i)
+			text := input[tok.Span.Start.Offset:f.Tokens[end].Span.End.Offset]
+			label, ok := labels[text]
+			if !ok {
+				for {
+					label = fmt.Sprintf("%s_Text_%d", d.Name, n)
+					n++
+					if _, defined := idx.LookupDefinition(filepath, label); !defined && !names[label] {
+						break
+					}
+				}
+				names[label] = true
+				labels[text] = label
+				texts = append(texts, fmt.Sprintf("\n\ntext(local) %s {\n\t%s\n}", label, text))
+			}
+			edits = append(edits, Edit{
+				Filepath: filepath,
+				Span:     Span{Start: tok.Span.Start, End: f.Tokens[end].Span.End},
+				NewText:  label,
+			})
+			i = end
+		}
+		for _, text := range texts {
+			edits = append(edits, Edit{Filepath: filepath, Span: Span{Start: d.Span.End, End: d.Span.End}, NewText: text})
+		}
+		count += len(texts)
+	}
+	return edits, count
+}
+
+// Reports whether the token begins an argument that is an inline text: a
+// string, a string with a type, like 'ascii"Hello"', a format() call, or an
+// instance of a text template.
+func (idx *Index) isTextArgStart(f *File, i int) bool {
+	if i == 0 || (f.Tokens[i-1].Type != token.LPAREN && f.Tokens[i-1].Type != token.COMMA) {
+		return false
+	}
+	tok := f.Tokens[i]
+	switch tok.Type {
+	case token.STRING, token.FORMAT:
+		return true
+	case token.STRINGTYPE:
+		return i+1 < len(f.Tokens) && f.Tokens[i+1].Type == token.STRING
+	case token.IDENT:
+		d, ok := idx.LookupDefinition(f.Filepath, tok.Literal)
+		return ok && d.Kind == KindTextTemplate && i+1 < len(f.Tokens) && f.Tokens[i+1].Type == token.LPAREN
+	}
+	return false
+}
+
+// Returns the index of the last token of the argument that begins at the
+// given token, which is followed by a comma or by the closing parenthesis
+// of its command.
+func findArgEnd(tokens []Token, start int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i].Type {
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			if depth == 0 {
+				return i - 1
+			}
+			depth--
+		case token.COMMA:
+			if depth == 0 {
+				return i - 1
+			}
+		case token.LBRACE, token.RBRACE:
+			// The command wasn't closed.
+			return i - 1
+		}
+	}
+	return len(tokens) - 1
+}
@@ -0,0 +1,472 @@
+package symbols
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/huderlem/poryscript/lexer"
+	"github.com/huderlem/poryscript/token"
+)
+
+// Position is a location in a file. Lines and columns start at 1, and
+// columns are counted in bytes.
+type Position = token.Position
+
+// Span is a range of a file. The end position is exclusive.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Contains reports whether the position is inside the span. A position at
+// the end of the span is considered inside, so that a cursor placed directly
+// after a word still refers to it.
+func (s Span) Contains(pos Position) bool {
+	return !pos.Before(s.Start) && !s.End.Before(pos)
+}
+
+// Token is a token, along with its span in the file.
+type Token struct {
+	token.Token
+	Span Span
+}
+
+// Kind is the kind of statement that defines a symbol.
+type Kind int
+
+// Kinds of symbols.
+const (
+	KindScript Kind = iota
+	KindText
+	KindMovement
+	KindMart
+	KindMapScripts
+	KindConst
+	KindMacro
+	KindTextTemplate
+	KindData
+	KindAlias
+)
+
+var kindKeywords = map[Kind]string{
+	KindScript:       "script",
+	KindText:         "text",
+	KindMovement:     "movement",
+	KindMart:         "mart",
+	KindMapScripts:   "mapscripts",
+	KindConst:        "const",
+	KindMacro:        "macro",
+	KindTextTemplate: "texttemplate",
+	KindData:         "data",
+	KindAlias:        "alias",
+}
+
+// String returns the keyword that defines the kind of symbol.
+func (k Kind) String() string {
+	return kindKeywords[k]
+}
+
+// ParseKind returns the kind of symbol that the keyword defines, like
+// "script" or "texttemplate".
+func ParseKind(keyword string) (Kind, bool) {
+	for kind, kindKeyword := range kindKeywords {
+		if kindKeyword == keyword {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+var tokenKinds = map[token.Type]Kind{
+	token.SCRIPT:     KindScript,
+	token.TEXT:       KindText,
+	token.MOVEMENT:   KindMovement,
+	token.MART:       KindMart,
+	token.MAPSCRIPTS: KindMapScripts,
+	token.CONST:      KindConst,
+	token.MACRO:      KindMacro,
+	token.TEMPLATE:   KindTextTemplate,
+	token.DATA:       KindData,
+	token.ALIAS:      KindAlias,
+}
+
+// The default scope of each kind of symbol that accepts a scope modifier.
+var defaultScopes = map[Kind]token.Type{
+	KindScript:     token.GLOBAL,
+	KindText:       token.GLOBAL,
+	KindMapScripts: token.GLOBAL,
+	KindMovement:   token.LOCAL,
+	KindMart:       token.LOCAL,
+	KindData:       token.LOCAL,
+	KindAlias:      token.GLOBAL,
+}
+
+// Definition is a symbol that is defined by a top-level statement.
+type Definition struct {
+	Name     string
+	Kind     Kind
+	Scope    token.Type // GLOBAL or LOCAL, or empty if the kind has no scope
+	Value    string     // value of a const, string of a text or texttemplate, or label of an alias
+	Filepath string
+	Span     Span // entire statement
+	NameSpan Span // name of the symbol
+}
+
+// Reference is a use of a symbol's name.
+type Reference struct {
+	Name     string
+	Filepath string
+	Span     Span
+}
+
+// File holds the tokens and definitions of a single Poryscript file.
+type File struct {
+	Filepath    string
+	Tokens      []Token
+	Definitions []Definition
+}
+
+// ParseFile finds the definitions in a Poryscript file. Unlike the parser,
+// it doesn't stop at the first syntax error, so that it can be used while a
+// file is being edited.
+func ParseFile(filepath string, input string) *File {
+	f := &File{Filepath: filepath, Tokens: Tokenize(input)}
+	f.Definitions = findDefinitions(filepath, f.Tokens)
+	return f
+}
+
+// Tokenize lexes the input, and assigns a span to each of its tokens.
+// Whitespace and comments are not included in the result.
+func Tokenize(input string) []Token {
+	l := lexer.NewWithMode(input, lexer.ScanTrivia)
+	tokens := []Token{}
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+		if tok.Type == token.WHITESPACE || tok.Type == token.COMMENT {
+			continue
+		}
+		tokens = append(tokens, Token{Token: tok, Span: Span{Start: tok.Pos(), End: tok.End}})
+	}
+	return tokens
+}
+
+// Tokens that begin a top-level statement.
+var topLevelTokens = map[token.Type]bool{
+	token.SCRIPT:     true,
+	token.RAW:        true,
+	token.TEXT:       true,
+	token.MOVEMENT:   true,
+	token.MART:       true,
+	token.DATA:       true,
+	token.ALIAS:      true,
+	token.MAPSCRIPTS: true,
+	token.CONST:      true,
+	token.DIRECTIVE:  true,
+	token.MACRO:      true,
+	token.TEMPLATE:   true,
+	token.IMPORT:     true,
+	token.AT:         true,
+}
+
+func findDefinitions(filepath string, tokens []Token) []Definition {
+	definitions := []Definition{}
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.Type {
+		case token.LBRACE:
+			depth++
+			continue
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+			continue
+		}
+		kind, ok := tokenKinds[tok.Type]
+		if depth > 0 || !ok {
+			continue
+		}
+
+		d := Definition{Kind: kind, Scope: defaultScopes[kind], Filepath: filepath}
+		j := i + 1
+		if d.Scope != "" && j+2 < len(tokens) && tokens[j].Type == token.LPAREN && tokens[j+2].Type == token.RPAREN {
+			d.Scope = tokens[j+1].Type
+			j += 3
+		}
+		if j >= len(tokens) || tokens[j].Type != token.IDENT {
+			continue
+		}
+		d.Name = tokens[j].Literal
+		d.NameSpan = tokens[j].Span
+		end := j
+		switch kind {
+		case KindConst, KindAlias:
+			end, d.Value = findConstantValue(tokens, j)
+		case KindTextTemplate:
+			for end+1 < len(tokens) && !topLevelTokens[tokens[end+1].Type] {
+				end++
+				if tokens[end].Type == token.STRING {
+					d.Value = tokens[end].Literal
+					break
+				}
+			}
+		default:
+			end = findClosingBrace(tokens, j)
+			if kind == KindText {
+				d.Value = findTextValue(tokens, j, end)
+			}
+		}
+		d.Span = Span{Start: tok.Span.Start, End: tokens[end].Span.End}
+		definitions = append(definitions, d)
+		i = end
+	}
+	return definitions
+}
+
+// Returns the first string in the given tokens. Consecutive string tokens
+// are joined into a single multi-line string, like the parser does.
+func findTextValue(tokens []Token, start int, end int) string {
+	lines := []string{}
+	for i := start; i < end; i++ {
+		if tokens[i].Type == token.STRING {
+			lines = append(lines, tokens[i].Literal)
+		} else if len(lines) > 0 {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the index of the last token of a const's value, and the value.
+func findConstantValue(tokens []Token, nameIndex int) (int, string) {
+	end := nameIndex
+	if end+1 < len(tokens) && tokens[end+1].Type == token.ASSIGN {
+		end++
+	}
+	values := []string{}
+	for end+1 < len(tokens) && !topLevelTokens[tokens[end+1].Type] {
+		end++
+		values = append(values, tokens[end].Literal)
+	}
+	return end, strings.Join(values, " ")
+}
+
+// Returns the index of the curly brace that closes the first block after
+// the given token. If the block isn't closed, the statement ends before the
+// next top-level statement.
+func findClosingBrace(tokens []Token, start int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i].Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+		if depth == 0 && i > start && topLevelTokens[tokens[i].Type] {
+			return i - 1
+		}
+	}
+	return len(tokens) - 1
+}
+
+// TokenAt returns the token at the given position, if any.
+func (f *File) TokenAt(pos Position) (Token, bool) {
+	i := sort.Search(len(f.Tokens), func(i int) bool {
+		return !f.Tokens[i].Span.End.Before(pos)
+	})
+	if i < len(f.Tokens) && f.Tokens[i].Span.Contains(pos) {
+		return f.Tokens[i], true
+	}
+	return Token{}, false
+}
+
+// Returns the file's own definition of the given name, if any.
+func (f *File) findDefinition(name string) (Definition, bool) {
+	for _, d := range f.Definitions {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Definition{}, false
+}
+
+// Index holds the definitions of a set of files, and resolves the
+// references between them.
+type Index struct {
+	files map[string]*File
+}
+
+// NewIndex creates an empty index.
+func NewIndex() *Index {
+	return &Index{files: make(map[string]*File)}
+}
+
+// AddFile adds a file to the index, or replaces it if the index already
+// contains a file with the same filepath.
+func (idx *Index) AddFile(filepath string, input string) *File {
+	f := ParseFile(filepath, input)
+	idx.files[filepath] = f
+	return f
+}
+
+// RemoveFile removes a file from the index.
+func (idx *Index) RemoveFile(filepath string) {
+	delete(idx.files, filepath)
+}
+
+// File returns the indexed file with the given filepath, if any.
+func (idx *Index) File(filepath string) (*File, bool) {
+	f, ok := idx.files[filepath]
+	return f, ok
+}
+
+// Returns the indexed files, sorted by filepath.
+func (idx *Index) getFiles() []*File {
+	files := make([]*File, 0, len(idx.files))
+	for _, f := range idx.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Filepath < files[j].Filepath })
+	return files
+}
+
+// Definitions returns all of the definitions in the index, sorted by
+// filepath and then by their order in the file.
+func (idx *Index) Definitions() []Definition {
+	definitions := []Definition{}
+	for _, f := range idx.getFiles() {
+		definitions = append(definitions, f.Definitions...)
+	}
+	return definitions
+}
+
+// LookupDefinition returns the definition that the given name refers to in
+// the given file. The file's own definitions take precedence. Otherwise, a
+// definition from another file is used, unless it is local to that file.
+func (idx *Index) LookupDefinition(filepath string, name string) (Definition, bool) {
+	if f, ok := idx.files[filepath]; ok {
+		if d, ok := f.findDefinition(name); ok {
+			return d, true
+		}
+	}
+	for _, f := range idx.getFiles() {
+		if f.Filepath == filepath {
+			continue
+		}
+		if d, ok := f.findDefinition(name); ok && d.Scope != token.LOCAL {
+			return d, true
+		}
+	}
+	return Definition{}, false
+}
+
+// DefinitionAt returns the definition of the symbol at the given position,
+// which can be either the definition's name or a reference to it.
+func (idx *Index) DefinitionAt(filepath string, pos Position) (Definition, bool) {
+	f, ok := idx.files[filepath]
+	if !ok {
+		return Definition{}, false
+	}
+	tok, ok := f.TokenAt(pos)
+	if !ok || tok.Type != token.IDENT {
+		return Definition{}, false
+	}
+	return idx.LookupDefinition(filepath, tok.Literal)
+}
+
+// FindReferences returns all of the references to the definition, sorted
+// by filepath and then by their position. The definition's own name is not
+// included.
+func (idx *Index) FindReferences(d Definition) []Reference {
+	references := []Reference{}
+	for _, f := range idx.getFiles() {
+		for _, tok := range f.Tokens {
+			if tok.Type != token.IDENT || tok.Literal != d.Name {
+				continue
+			}
+			if f.Filepath == d.Filepath && tok.Span == d.NameSpan {
+				continue
+			}
+			if target, ok := idx.LookupDefinition(f.Filepath, tok.Literal); !ok || target.Filepath != d.Filepath || target.NameSpan != d.NameSpan {
+				continue
+			}
+			references = append(references, Reference{Name: tok.Literal, Filepath: f.Filepath, Span: tok.Span})
+		}
+	}
+	return references
+}
+
+// Edit replaces a span of a file with new text.
+type Edit struct {
+	Filepath string
+	Span     Span
+	NewText  string
+}
+
+// RenameEdits returns the edits that rename the definition and all of its
+// references, sorted by filepath and then by their position. When a
+// mapscripts statement is renamed, the references to the labels of its
+// inline map scripts, like "MyMap_MapScripts_MAP_SCRIPT_ON_LOAD", are
+// renamed, too, since the labels are named after the statement.
+func (idx *Index) RenameEdits(d Definition, newName string) []Edit {
+	edits := []Edit{{Filepath: d.Filepath, Span: d.NameSpan, NewText: newName}}
+	for _, reference := range idx.FindReferences(d) {
+		edits = append(edits, Edit{Filepath: reference.Filepath, Span: reference.Span, NewText: newName})
+	}
+	if d.Kind == KindMapScripts {
+		prefix := d.Name + "_MAP_SCRIPT_"
+		for _, f := range idx.getFiles() {
+			if d.Scope == token.LOCAL && f.Filepath != d.Filepath {
+				continue
+			}
+			for _, tok := range f.Tokens {
+				if tok.Type != token.IDENT || !strings.HasPrefix(tok.Literal, prefix) {
+					continue
+				}
+				// A symbol with the same name as a label shadows it.
+				if _, ok := idx.LookupDefinition(f.Filepath, tok.Literal); ok {
+					continue
+				}
+				edits = append(edits, Edit{Filepath: f.Filepath, Span: tok.Span, NewText: newName + strings.TrimPrefix(tok.Literal, d.Name)})
+			}
+		}
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].Filepath != edits[j].Filepath {
+			return edits[i].Filepath < edits[j].Filepath
+		}
+		return edits[i].Span.Start.Offset < edits[j].Span.Start.Offset
+	})
+	return edits
+}
+
+// ApplyEdits returns the input of the file with the edits of the file
+// applied. The edits must not overlap.
+func ApplyEdits(filepath string, input string, edits []Edit) string {
+	var fileEdits []Edit
+	for _, edit := range edits {
+		if edit.Filepath == filepath {
+			fileEdits = append(fileEdits, edit)
+		}
+	}
+	sort.SliceStable(fileEdits, func(i, j int) bool {
+		return fileEdits[i].Span.Start.Offset < fileEdits[j].Span.Start.Offset
+	})
+	var sb strings.Builder
+	offset := 0
+	for _, edit := range fileEdits {
+		sb.WriteString(input[offset:edit.Span.Start.Offset])
+		sb.WriteString(edit.NewText)
+		offset = edit.Span.End.Offset
+	}
+	sb.WriteString(input[offset:])
+	return sb.String()
+}
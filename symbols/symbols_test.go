@@ -0,0 +1,330 @@
+package symbols
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/huderlem/poryscript/token"
+)
+
+func pos(offset, line, column int) Position {
+	return Position{Offset: offset, Line: line, Column: column}
+}
+
+func TestParseFile(t *testing.T) {
+	input := `const PROF_BIRCH_ID = 3
+const ASSISTANT_ID = PROF_BIRCH_ID + 1
+
+macro lockAndFace {
+	lock
+	faceplayer
+}
+
+texttemplate Obtained(item) = "Obtained {item}!"
+
+@deprecated
+script(local) MyScript {
+	lockAndFace
+	msgbox(MyText)
+	applymovement(PROF_BIRCH_ID, MyMovement)
+}
+
+text MyText {
+	"Héllo"
+	"there"
+}
+
+movement(global) MyMovement {
+	walk_left
+}
+
+mart MyMart {
+	ITEM_POTION
+}
+
+mapscripts MyMapScripts {
+	MAP_SCRIPT_ON_LOAD {
+		setflag(FLAG_TEMP_1)
+	}
+}
+
+alias OldScript = MyScript
+
+script Broken {
+	if (
+`
+	f := ParseFile("data/scripts/test.pory", input)
+	tests := []struct {
+		name     string
+		kind     Kind
+		scope    token.Type
+		value    string
+		span     Span
+		nameSpan Span
+	}{
+		{"PROF_BIRCH_ID", KindConst, "", "3", Span{pos(0, 1, 1), pos(23, 1, 24)}, Span{pos(6, 1, 7), pos(19, 1, 20)}},
+		{"ASSISTANT_ID", KindConst, "", "PROF_BIRCH_ID + 1", Span{pos(24, 2, 1), pos(62, 2, 39)}, Span{pos(30, 2, 7), pos(42, 2, 19)}},
+		{"lockAndFace", KindMacro, "", "", Span{pos(64, 4, 1), pos(103, 7, 2)}, Span{pos(70, 4, 7), pos(81, 4, 18)}},
+		{"Obtained", KindTextTemplate, "", "Obtained {item}!", Span{pos(105, 9, 1), pos(153, 9, 49)}, Span{pos(118, 9, 14), pos(126, 9, 22)}},
+		{"MyScript", KindScript, token.LOCAL, "", Span{pos(167, 12, 1), pos(264, 16, 2)}, Span{pos(181, 12, 15), pos(189, 12, 23)}},
+		{"MyText", KindText, token.GLOBAL, "Héllo\nthere", Span{pos(266, 18, 1), pos(300, 21, 2)}, Span{pos(271, 18, 6), pos(277, 18, 12)}},
+		{"MyMovement", KindMovement, token.GLOBAL, "", Span{pos(302, 23, 1), pos(344, 25, 2)}, Span{pos(319, 23, 18), pos(329, 23, 28)}},
+		{"MyMart", KindMart, token.LOCAL, "", Span{pos(346, 27, 1), pos(374, 29, 2)}, Span{pos(351, 27, 6), pos(357, 27, 12)}},
+		{"MyMapScripts", KindMapScripts, token.GLOBAL, "", Span{pos(376, 31, 1), pos(451, 35, 2)}, Span{pos(387, 31, 12), pos(399, 31, 24)}},
+		{"OldScript", KindAlias, token.GLOBAL, "MyScript", Span{pos(453, 37, 1), pos(479, 37, 27)}, Span{pos(459, 37, 7), pos(468, 37, 16)}},
+		{"Broken", KindScript, token.GLOBAL, "", Span{pos(481, 39, 1), pos(502, 40, 6)}, Span{pos(488, 39, 8), pos(494, 39, 14)}},
+	}
+	if len(f.Definitions) != len(tests) {
+		t.Fatalf("Expected %d definitions, got %d: %+v", len(tests), len(f.Definitions), f.Definitions)
+	}
+	for i, test := range tests {
+		d := f.Definitions[i]
+		if d.Name != test.name {
+			t.Errorf("Incorrect name for definition %d. Expected '%s', got '%s'", i, test.name, d.Name)
+		}
+		if d.Kind != test.kind {
+			t.Errorf("Incorrect kind for '%s'. Expected '%s', got '%s'", test.name, test.kind, d.Kind)
+		}
+		if d.Scope != test.scope {
+			t.Errorf("Incorrect scope for '%s'. Expected '%s', got '%s'", test.name, test.scope, d.Scope)
+		}
+		if d.Value != test.value {
+			t.Errorf("Incorrect value for '%s'. Expected '%s', got '%s'", test.name, test.value, d.Value)
+		}
+		if d.Span != test.span {
+			t.Errorf("Incorrect span for '%s'. Expected %+v, got %+v", test.name, test.span, d.Span)
+		}
+		if d.NameSpan != test.nameSpan {
+			t.Errorf("Incorrect name span for '%s'. Expected %+v, got %+v", test.name, test.nameSpan, d.NameSpan)
+		}
+		if d.Filepath != "data/scripts/test.pory" {
+			t.Errorf("Incorrect filepath for '%s'. Got '%s'", test.name, d.Filepath)
+		}
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for kind, keyword := range kindKeywords {
+		result, ok := ParseKind(keyword)
+		if !ok || result != kind {
+			t.Errorf("Incorrect kind for '%s'. Expected %v, got %v", keyword, kind, result)
+		}
+	}
+	if _, ok := ParseKind("raw"); ok {
+		t.Errorf("Expected 'raw' to not be a kind of symbol")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	index := NewIndex()
+	index.AddFile("shared.pory", `const GUIDE_ID = 3
+
+script Shared_EventScript_Heal {
+	special(HealPlayerParty)
+	msgbox(Shared_Text_Heal)
+}
+
+text(local) Shared_Text_Heal {
+	"Your POKéMON are healed."
+}
+`)
+	index.AddFile("route1.pory", `script Route1_EventScript_Nurse {
+	call(Shared_EventScript_Heal)
+	msgbox(Shared_Text_Heal)
+	applymovement(GUIDE_ID, Route1_Movement)
+	goto(Shared_EventScript_Heal)
+}
+
+movement Route1_Movement {
+	walk_up
+}
+`)
+	index.AddFile("route2.pory", `script(local) Shared_EventScript_Heal {
+	end
+}
+
+script Route2_EventScript {
+	call(Shared_EventScript_Heal)
+}
+`)
+
+	definitions := index.Definitions()
+	expectedNames := []string{"Route1_EventScript_Nurse", "Route1_Movement", "Shared_EventScript_Heal", "Route2_EventScript", "GUIDE_ID", "Shared_EventScript_Heal", "Shared_Text_Heal"}
+	if len(definitions) != len(expectedNames) {
+		t.Fatalf("Expected %d definitions, got %d", len(expectedNames), len(definitions))
+	}
+	for i, name := range expectedNames {
+		if definitions[i].Name != name {
+			t.Errorf("Incorrect definition %d. Expected '%s', got '%s'", i, name, definitions[i].Name)
+		}
+	}
+
+	// A file's own definition takes precedence.
+	d, ok := index.LookupDefinition("route2.pory", "Shared_EventScript_Heal")
+	if !ok || d.Filepath != "route2.pory" {
+		t.Errorf("Expected route2.pory's own definition of Shared_EventScript_Heal, got %+v", d)
+	}
+	d, ok = index.LookupDefinition("route1.pory", "Shared_EventScript_Heal")
+	if !ok || d.Filepath != "shared.pory" {
+		t.Errorf("Expected shared.pory's definition of Shared_EventScript_Heal, got %+v", d)
+	}
+	// Local definitions aren't visible from other files.
+	if d, ok := index.LookupDefinition("route1.pory", "Shared_Text_Heal"); ok {
+		t.Errorf("Expected local text to be invisible from route1.pory, got %+v", d)
+	}
+	if _, ok := index.LookupDefinition("shared.pory", "Shared_Text_Heal"); !ok {
+		t.Errorf("Expected local text to be visible from its own file")
+	}
+
+	// The cursor is on the "call(Shared_EventScript_Heal)" line.
+	d, ok = index.DefinitionAt("route1.pory", Position{Offset: 41, Line: 2, Column: 8})
+	if !ok || d.Name != "Shared_EventScript_Heal" || d.Filepath != "shared.pory" {
+		t.Fatalf("Incorrect definition at position. Got %+v", d)
+	}
+	if _, ok := index.DefinitionAt("route1.pory", Position{Offset: 36, Line: 2, Column: 3}); ok {
+		t.Errorf("Expected no definition for the 'call' command")
+	}
+
+	references := index.FindReferences(d)
+	expectedReferences := []Reference{
+		{"Shared_EventScript_Heal", "route1.pory", Span{pos(40, 2, 7), pos(63, 2, 30)}},
+		{"Shared_EventScript_Heal", "route1.pory", Span{pos(139, 5, 7), pos(162, 5, 30)}},
+	}
+	if len(references) != len(expectedReferences) {
+		t.Fatalf("Expected %d references, got %d: %+v", len(expectedReferences), len(references), references)
+	}
+	for i, expected := range expectedReferences {
+		if references[i] != expected {
+			t.Errorf("Incorrect reference %d. Expected %+v, got %+v", i, expected, references[i])
+		}
+	}
+
+	local, _ := index.LookupDefinition("shared.pory", "Shared_Text_Heal")
+	if references := index.FindReferences(local); len(references) != 1 || references[0].Filepath != "shared.pory" {
+		t.Errorf("Expected 1 reference to local text in shared.pory, got %+v", references)
+	}
+
+	index.RemoveFile("shared.pory")
+	if _, ok := index.LookupDefinition("route1.pory", "GUIDE_ID"); ok {
+		t.Errorf("Expected GUIDE_ID to be removed with its file")
+	}
+}
+
+func TestRenameEdits(t *testing.T) {
+	index := NewIndex()
+	maps := `mapscripts MyMap_MapScripts {
+	MAP_SCRIPT_ON_LOAD: MyMap_OnLoad
+	MAP_SCRIPT_ON_TRANSITION {
+		setflag(FLAG_1)
+	}
+}
+
+script MyMap_OnLoad {
+	call(MyMap_MapScripts_MAP_SCRIPT_ON_TRANSITION)
+}
+`
+	other := `script Other {
+	goto(MyMap_OnLoad)
+	goto(MyMap_MapScripts_MAP_SCRIPT_ON_TRANSITION)
+}
+`
+	index.AddFile("map.pory", maps)
+	index.AddFile("other.pory", other)
+
+	d, _ := index.LookupDefinition("map.pory", "MyMap_OnLoad")
+	edits := index.RenameEdits(d, "MyMap_Load")
+	if len(edits) != 3 {
+		t.Fatalf("Expected 3 edits, got %d: %+v", len(edits), edits)
+	}
+	expected := strings.Replace(maps, "MyMap_OnLoad", "MyMap_Load", -1)
+	if result := ApplyEdits("map.pory", maps, edits); result != expected {
+		t.Errorf("Incorrect renamed map.pory. Expected:\n%s\nGot:\n%s", expected, result)
+	}
+	expected = strings.Replace(other, "MyMap_OnLoad", "MyMap_Load", -1)
+	if result := ApplyEdits("other.pory", other, edits); result != expected {
+		t.Errorf("Incorrect renamed other.pory. Expected:\n%s\nGot:\n%s", expected, result)
+	}
+
+	// The labels of the inline map scripts are renamed with their
+	// mapscripts statement.
+	d, _ = index.LookupDefinition("map.pory", "MyMap_MapScripts")
+	edits = index.RenameEdits(d, "Map_Scripts")
+	expected = strings.Replace(maps, "MyMap_MapScripts", "Map_Scripts", -1)
+	if result := ApplyEdits("map.pory", maps, edits); result != expected {
+		t.Errorf("Incorrect renamed map.pory. Expected:\n%s\nGot:\n%s", expected, result)
+	}
+	expected = strings.Replace(other, "MyMap_MapScripts", "Map_Scripts", -1)
+	if result := ApplyEdits("other.pory", other, edits); result != expected {
+		t.Errorf("Incorrect renamed other.pory. Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestExtractTextEdits(t *testing.T) {
+	input := `texttemplate Obtained(item) = "Obtained {item}!"
+
+script MyScript {
+	msgbox("Hello", MSGBOX_DEFAULT)
+	if (flag(FLAG_1)) {
+		message(format("Hello again, {PLAYER}!", "1_latin_rse"))
+		msgbox("Hello", MSGBOX_DEFAULT)
+	}
+	msgbox(ascii"Bye")
+	msgbox(Obtained(POTION))
+	msgbox(MyScript_Text_0)
+}
+
+script Other {
+	msgbox("Other")
+}
+
+text MyScript_Text_0 {
+	"Explicit"
+}
+`
+	index := NewIndex()
+	index.AddFile("a.pory", input)
+	edits, count := index.ExtractTextEdits("a.pory", input, map[string]bool{"MyScript": true})
+	if count != 4 {
+		t.Errorf("Expected 4 texts, got %d", count)
+	}
+	expected := `texttemplate Obtained(item) = "Obtained {item}!"
+
+script MyScript {
+	msgbox(MyScript_Text_1, MSGBOX_DEFAULT)
+	if (flag(FLAG_1)) {
+		message(MyScript_Text_2)
+		msgbox(MyScript_Text_1, MSGBOX_DEFAULT)
+	}
+	msgbox(MyScript_Text_3)
+	msgbox(MyScript_Text_4)
+	msgbox(MyScript_Text_0)
+}
+
+text(local) MyScript_Text_1 {
+	"Hello"
+}
+
+text(local) MyScript_Text_2 {
+	format("Hello again, {PLAYER}!", "1_latin_rse")
+}
+
+text(local) MyScript_Text_3 {
+	ascii"Bye"
+}
+
+text(local) MyScript_Text_4 {
+	Obtained(POTION)
+}
+
+script Other {
+	msgbox("Other")
+}
+
+text MyScript_Text_0 {
+	"Explicit"
+}
+`
+	if result := ApplyEdits("a.pory", input, edits); result != expected {
+		t.Errorf("Incorrect extracted texts. Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
@@ -1,13 +1,37 @@
 package token
 
+import "strings"
+
 // Type distinguishes between different types of tokens in the Poryscript lexer.
 type Type string
 
+// Position is a location in a Poryscript file. Lines and columns start at 1,
+// and offsets start at 0. Columns and offsets are counted in bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// Before reports whether the position comes before the other position.
+func (p Position) Before(other Position) bool {
+	return p.Offset < other.Offset
+}
+
 // Token represents a single token in the Poryscript lexer.
 type Token struct {
 	Type       Type
 	Literal    string
+	Filepath   string // file that the token was read from, or empty if unknown
 	LineNumber int
+	Column     int      // column of the token's first byte, starting at 1
+	Offset     int      // byte offset of the token's first byte, starting at 0
+	End        Position // position immediately after the token's last byte
+}
+
+// Pos returns the position of the token's first byte.
+func (t Token) Pos() Position {
+	return Position{Offset: t.Offset, Line: t.LineNumber, Column: t.Column}
 }
 
 // Token types
@@ -21,6 +45,9 @@ const (
 	STRING     = "STRING"
 	RAWSTRING  = "RAWSTRING"
 	STRINGTYPE = "STRINGTYPE"
+	DATATYPE   = "DATATYPE"
+	COMMENT    = "COMMENT"
+	WHITESPACE = "WHITESPACE"
 
 	// Operators
 	ASSIGN = "="
@@ -38,6 +65,7 @@ const (
 	// Delimeters
 	COMMA = ","
 	COLON = ":"
+	AT    = "@"
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -52,6 +80,8 @@ const (
 	TEXT       = "TEXT"
 	MOVEMENT   = "MOVEMENT"
 	MART       = "MART"
+	DATA       = "DATA"
+	ALIAS      = "ALIAS"
 	MAPSCRIPTS = "MAPSCRIPTS"
 	FORMAT     = "FORMAT"
 	VAR        = "VAR"
@@ -73,6 +103,10 @@ const (
 	LOCAL      = "LOCAL"
 	PORYSWITCH = "PORYSWITCH"
 	CONST      = "CONST"
+	DIRECTIVE  = "DIRECTIVE"
+	MACRO      = "MACRO"
+	TEMPLATE   = "TEMPLATE"
+	IMPORT     = "IMPORT"
 )
 
 // If statement comparison types
@@ -82,34 +116,54 @@ const (
 )
 
 var keywords = map[string]Type{
-	"script":     SCRIPT,
-	"raw":        RAW,
-	"text":       TEXT,
-	"movement":   MOVEMENT,
-	"mart":       MART,
-	"mapscripts": MAPSCRIPTS,
-	"format":     FORMAT,
-	"var":        VAR,
-	"flag":       FLAG,
-	"defeated":   DEFEATED,
-	"TRUE":       TRUE,
-	"FALSE":      FALSE,
-	"true":       TRUE,
-	"false":      FALSE,
-	"if":         IF,
-	"else":       ELSE,
-	"elif":       ELSEIF,
-	"do":         DO,
-	"while":      WHILE,
-	"break":      BREAK,
-	"continue":   CONTINUE,
-	"switch":     SWITCH,
-	"case":       CASE,
-	"default":    DEFAULT,
-	"global":     GLOBAL,
-	"local":      LOCAL,
-	"poryswitch": PORYSWITCH,
-	"const":      CONST,
+	"script":       SCRIPT,
+	"raw":          RAW,
+	"text":         TEXT,
+	"movement":     MOVEMENT,
+	"mart":         MART,
+	"data":         DATA,
+	"alias":        ALIAS,
+	"mapscripts":   MAPSCRIPTS,
+	"format":       FORMAT,
+	"var":          VAR,
+	"flag":         FLAG,
+	"defeated":     DEFEATED,
+	"TRUE":         TRUE,
+	"FALSE":        FALSE,
+	"true":         TRUE,
+	"false":        FALSE,
+	"if":           IF,
+	"else":         ELSE,
+	"elif":         ELSEIF,
+	"do":           DO,
+	"while":        WHILE,
+	"break":        BREAK,
+	"continue":     CONTINUE,
+	"switch":       SWITCH,
+	"case":         CASE,
+	"default":      DEFAULT,
+	"global":       GLOBAL,
+	"local":        LOCAL,
+	"poryswitch":   PORYSWITCH,
+	"const":        CONST,
+	"directive":    DIRECTIVE,
+	"macro":        MACRO,
+	"texttemplate": TEMPLATE,
+	"import":       IMPORT,
+}
+
+// GetKeyword returns the keyword that matches the given identifier in any
+// case, like "If" for "if". Keywords that are valid in more than one case,
+// like "TRUE", are returned as they were written.
+func GetKeyword(ident string) (string, bool) {
+	if _, ok := keywords[ident]; ok {
+		return ident, true
+	}
+	lower := strings.ToLower(ident)
+	if _, ok := keywords[lower]; ok {
+		return lower, true
+	}
+	return "", false
 }
 
 // GetIdentType looks up the token type for the given identifier